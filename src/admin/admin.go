@@ -0,0 +1,140 @@
+// Package admin serves the admin HTTP API used by operators to inspect the running service.
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/events"
+	"kandji-cloudflare-device-sync/internal/ratelimit"
+)
+
+// Server is the admin HTTP API.
+type Server struct {
+	addr   string
+	mux    *http.ServeMux
+	events *events.Buffer
+
+	webhookTokens []config.WebhookToken
+	trigger       TriggerFunc
+
+	token   string
+	limiter *ratelimit.Limiter
+	syncs   []SyncLoop
+}
+
+// Option configures optional Server behaviour.
+type Option func(*Server)
+
+// WithEvents serves the events of the buffer on /events.
+func WithEvents(buffer *events.Buffer) Option {
+	return func(s *Server) {
+		s.events = buffer
+	}
+}
+
+// New creates an admin Server listening on addr.
+func New(addr string, opts ...Option) *Server {
+	s := &Server{
+		addr: addr,
+		mux:  http.NewServeMux(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.events != nil {
+		s.mux.HandleFunc("GET /events", s.handleEvents)
+	}
+	if s.trigger != nil {
+		s.mux.HandleFunc("POST /webhook/sync", s.handleWebhook)
+	}
+	if s.limiter != nil {
+		s.mux.HandleFunc("GET /ratelimits", s.handleRateLimits)
+		s.mux.HandleFunc("PUT /ratelimits", s.handleSetRateLimits)
+		s.mux.HandleFunc("DELETE /ratelimits", s.handleResetRateLimits)
+	}
+	if len(s.syncs) > 0 {
+		s.mux.HandleFunc("POST /sync", s.handleTriggerSync)
+		s.mux.HandleFunc("POST /sync/pause", s.handlePauseSync)
+		s.mux.HandleFunc("POST /sync/resume", s.handleResumeSync)
+		s.mux.HandleFunc("GET /sync/status", s.handleSyncStatus)
+		s.mux.HandleFunc("GET /sync/diff", s.handleSyncDiff)
+	}
+	return s
+}
+
+// Handle registers an additional handler, e.g. for the metrics endpoint.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() string {
+	return s.addr
+}
+
+// Run serves the API until ctx is cancelled.
+func (s *Server) Run(ctx context.Context) error {
+	server := &http.Server{Addr: s.addr, Handler: s.mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// handleEvents returns the buffered events, oldest first. Supported query parameters:
+// type (event type), since (RFC 3339 time or a duration such as 1h), after_id, limit.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := events.Filter{Type: query.Get("type")}
+
+	if since := query.Get("since"); since != "" {
+		if d, err := time.ParseDuration(since); err == nil {
+			filter.Since = time.Now().Add(-d)
+		} else if t, err := time.Parse(time.RFC3339, since); err == nil {
+			filter.Since = t
+		} else {
+			writeError(w, http.StatusBadRequest, "since must be an RFC 3339 time or a duration")
+			return
+		}
+	}
+	if afterID := query.Get("after_id"); afterID != "" {
+		id, err := strconv.ParseUint(afterID, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "after_id must be a non-negative integer")
+			return
+		}
+		filter.AfterID = id
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a non-negative integer")
+			return
+		}
+		filter.Limit = n
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"events": s.events.Events(filter)})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,128 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"kandji-cloudflare-device-sync/events"
+	"kandji-cloudflare-device-sync/internal/ratelimit"
+)
+
+// WithToken sets the bearer token required by the endpoints that change the running
+// service. Without a token those endpoints reject every request.
+func WithToken(token string) Option {
+	return func(s *Server) {
+		s.token = token
+	}
+}
+
+// WithRateLimits serves /ratelimits, which reads and adjusts the limits of the running
+// limiter.
+func WithRateLimits(limiter *ratelimit.Limiter) Option {
+	return func(s *Server) {
+		s.limiter = limiter
+	}
+}
+
+type rateLimits struct {
+	KandjiRequestsPerSecond     float64 `json:"kandji_requests_per_second"`
+	CloudflareRequestsPerSecond float64 `json:"cloudflare_requests_per_second"`
+	BurstCapacity               int     `json:"burst_capacity"`
+}
+
+type rateLimitsResponse struct {
+	rateLimits
+	Configured rateLimits `json:"configured"`
+}
+
+// rateLimitsUpdate is a partial update; omitted fields keep their current value.
+type rateLimitsUpdate struct {
+	KandjiRequestsPerSecond     *float64 `json:"kandji_requests_per_second"`
+	CloudflareRequestsPerSecond *float64 `json:"cloudflare_requests_per_second"`
+	BurstCapacity               *int     `json:"burst_capacity"`
+}
+
+func toRateLimits(cfg ratelimit.Config) rateLimits {
+	return rateLimits{
+		KandjiRequestsPerSecond:     cfg.KandjiRequestsPerSecond,
+		CloudflareRequestsPerSecond: cfg.CloudflareRequestsPerSecond,
+		BurstCapacity:               cfg.BurstCapacity,
+	}
+}
+
+// handleRateLimits returns the limits in effect and the configured ones.
+func (s *Server) handleRateLimits(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, rateLimitsResponse{
+		rateLimits: toRateLimits(s.limiter.Limits()),
+		Configured: toRateLimits(s.limiter.Configured()),
+	})
+}
+
+// handleSetRateLimits changes the limits in effect until the service restarts.
+func (s *Server) handleSetRateLimits(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r) {
+		return
+	}
+	var update rateLimitsUpdate
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&update); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	limits := s.limiter.Limits()
+	if update.KandjiRequestsPerSecond != nil {
+		limits.KandjiRequestsPerSecond = *update.KandjiRequestsPerSecond
+	}
+	if update.CloudflareRequestsPerSecond != nil {
+		limits.CloudflareRequestsPerSecond = *update.CloudflareRequestsPerSecond
+	}
+	if update.BurstCapacity != nil {
+		limits.BurstCapacity = *update.BurstCapacity
+	}
+	if limits.KandjiRequestsPerSecond <= 0 || limits.CloudflareRequestsPerSecond <= 0 {
+		writeError(w, http.StatusBadRequest, "requests per second must be positive")
+		return
+	}
+	if limits.BurstCapacity < 1 {
+		writeError(w, http.StatusBadRequest, "burst_capacity must be at least 1")
+		return
+	}
+	s.setRateLimits(limits, "Rate limits changed through the admin API")
+	s.handleRateLimits(w, r)
+}
+
+// handleResetRateLimits restores the configured limits.
+func (s *Server) handleResetRateLimits(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r) {
+		return
+	}
+	s.setRateLimits(s.limiter.Configured(), "Rate limits reset to the configured values through the admin API")
+	s.handleRateLimits(w, r)
+}
+
+func (s *Server) setRateLimits(limits ratelimit.Config, message string) {
+	s.limiter.SetLimits(limits)
+	if s.events != nil {
+		s.events.Record(events.TypeRateLimitsChanged, message,
+			"kandji_requests_per_second", limits.KandjiRequestsPerSecond,
+			"cloudflare_requests_per_second", limits.CloudflareRequestsPerSecond,
+			"burst_capacity", limits.BurstCapacity)
+	}
+}
+
+// authorize checks the request's bearer token against the admin token and writes the
+// error response if it does not match.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) bool {
+	if s.token == "" {
+		writeError(w, http.StatusForbidden, "admin.token is not configured, changes through the admin API are disabled")
+		return false
+	}
+	presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(presented), []byte(s.token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,199 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"kandji-cloudflare-device-sync/events"
+	"kandji-cloudflare-device-sync/syncer"
+)
+
+// SyncLoop is a sync run by the service that the admin API drives, see WithSyncs.
+type SyncLoop struct {
+	// Job is the name of the job, empty for the top-level sync
+	Job    string
+	Syncer *syncer.Syncer
+}
+
+// WithSyncs serves the /sync endpoints, which trigger, pause, resume and inspect the given
+// syncs. They all require the admin token.
+func WithSyncs(loops ...SyncLoop) Option {
+	return func(s *Server) {
+		s.syncs = append(s.syncs, loops...)
+	}
+}
+
+type syncRequest struct {
+	Job    *string `json:"job"`
+	Reason string  `json:"reason"`
+}
+
+type syncStatus struct {
+	Job string `json:"job,omitempty"`
+	syncer.Status
+}
+
+type syncDiff struct {
+	Job string `json:"job,omitempty"`
+	// Diff is nil until the sync's first cycle finished
+	*syncer.Diff
+}
+
+type syncResult struct {
+	Job    string `json:"job,omitempty"`
+	Status string `json:"status"`
+}
+
+// selectSyncs returns the syncs selected by the "job" query parameter or JSON body field,
+// all of them if neither is given, and writes the error response if no sync has that name.
+// The top-level sync is selected with an empty name.
+func (s *Server) selectSyncs(w http.ResponseWriter, r *http.Request, job *string) ([]SyncLoop, bool) {
+	if r.URL.Query().Has("job") {
+		name := r.URL.Query().Get("job")
+		job = &name
+	}
+	if job == nil {
+		return s.syncs, true
+	}
+	for _, loop := range s.syncs {
+		if loop.Job == *job {
+			return []SyncLoop{loop}, true
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Sprintf("no job named %q", *job))
+	return nil, false
+}
+
+// decodeSyncRequest decodes the optional JSON body of a POST to the /sync endpoints.
+func decodeSyncRequest(w http.ResponseWriter, r *http.Request) (syncRequest, bool) {
+	var body syncRequest
+	if r.ContentLength != 0 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body")
+			return body, false
+		}
+	}
+	return body, true
+}
+
+// handleTriggerSync queues an immediate cycle of the selected syncs. Paused syncs, and
+// syncs of a replica that is not the leader, are not triggered.
+func (s *Server) handleTriggerSync(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r) {
+		return
+	}
+	body, ok := decodeSyncRequest(w, r)
+	if !ok {
+		return
+	}
+	loops, ok := s.selectSyncs(w, r, body.Job)
+	if !ok {
+		return
+	}
+
+	reason := "admin API"
+	if body.Reason != "" {
+		reason += " (" + body.Reason + ")"
+	}
+	results := make([]syncResult, 0, len(loops))
+	for _, loop := range loops {
+		result := syncResult{Job: loop.Job, Status: "queued"}
+		switch {
+		case loop.Syncer.Paused():
+			result.Status = "paused"
+		case !loop.Syncer.Leading():
+			result.Status = "not_leader"
+		case !loop.Syncer.Trigger(reason):
+			result.Status = "already_queued"
+		}
+		if s.events != nil && (result.Status == "queued" || result.Status == "already_queued") {
+			s.events.Record(events.TypeSyncTriggered, "Sync requested through the admin API", "job", loop.Job, "reason", body.Reason)
+		}
+		results = append(results, result)
+	}
+	writeJSON(w, http.StatusAccepted, map[string]any{"syncs": results})
+}
+
+// handlePauseSync pauses the selected syncs until they are resumed or the service restarts.
+func (s *Server) handlePauseSync(w http.ResponseWriter, r *http.Request) {
+	s.setPaused(w, r, true)
+}
+
+// handleResumeSync resumes the selected syncs.
+func (s *Server) handleResumeSync(w http.ResponseWriter, r *http.Request) {
+	s.setPaused(w, r, false)
+}
+
+func (s *Server) setPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	if !s.authorize(w, r) {
+		return
+	}
+	body, ok := decodeSyncRequest(w, r)
+	if !ok {
+		return
+	}
+	loops, ok := s.selectSyncs(w, r, body.Job)
+	if !ok {
+		return
+	}
+
+	results := make([]syncResult, 0, len(loops))
+	for _, loop := range loops {
+		result := syncResult{Job: loop.Job}
+		switch {
+		case paused && loop.Syncer.Pause():
+			result.Status = "paused"
+			s.recordSyncEvent(events.TypeSyncPaused, "Sync paused through the admin API", loop.Job, body.Reason)
+		case paused:
+			result.Status = "already_paused"
+		case loop.Syncer.Resume():
+			result.Status = "resumed"
+			s.recordSyncEvent(events.TypeSyncResumed, "Sync resumed through the admin API", loop.Job, body.Reason)
+		default:
+			result.Status = "not_paused"
+		}
+		results = append(results, result)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"syncs": results})
+}
+
+func (s *Server) recordSyncEvent(eventType, message, job, reason string) {
+	if s.events != nil {
+		s.events.Record(eventType, message, "job", job, "reason", reason)
+	}
+}
+
+// handleSyncStatus returns the state and last cycle outcome of the selected syncs.
+func (s *Server) handleSyncStatus(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r) {
+		return
+	}
+	loops, ok := s.selectSyncs(w, r, nil)
+	if !ok {
+		return
+	}
+	statuses := make([]syncStatus, 0, len(loops))
+	for _, loop := range loops {
+		statuses = append(statuses, syncStatus{Job: loop.Job, Status: loop.Syncer.Status()})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"syncs": statuses})
+}
+
+// handleSyncDiff returns the serials the last cycle of the selected syncs added, removed,
+// failed to add or held back.
+func (s *Server) handleSyncDiff(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r) {
+		return
+	}
+	loops, ok := s.selectSyncs(w, r, nil)
+	if !ok {
+		return
+	}
+	diffs := make([]syncDiff, 0, len(loops))
+	for _, loop := range loops {
+		diffs = append(diffs, syncDiff{Job: loop.Job, Diff: loop.Syncer.LastDiff()})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"syncs": diffs})
+}
@@ -0,0 +1,89 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/events"
+)
+
+// TriggerFunc requests an immediate sync cycle and reports whether it was queued, or
+// coalesced with a request that is already queued.
+type TriggerFunc func(reason string) bool
+
+// WithWebhook serves POST /webhook/sync, which requests an immediate sync through trigger
+// when called with one of the configured bearer tokens.
+func WithWebhook(cfg config.WebhookConfig, trigger TriggerFunc) Option {
+	return func(s *Server) {
+		s.webhookTokens = cfg.Tokens
+		s.trigger = trigger
+	}
+}
+
+type webhookRequest struct {
+	Pipeline string `json:"pipeline"`
+	Reason   string `json:"reason"`
+}
+
+// handleWebhook queues an immediate sync. The caller names its pipeline in the "pipeline"
+// query parameter or JSON body field; tokens restricted to pipelines reject other names.
+func (s *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	token, ok := s.webhookToken(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+		return
+	}
+
+	var body webhookRequest
+	if r.ContentLength != 0 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+	}
+	pipeline := body.Pipeline
+	if p := r.URL.Query().Get("pipeline"); p != "" {
+		pipeline = p
+	}
+	if len(token.Pipelines) > 0 && !slices.Contains(token.Pipelines, pipeline) {
+		writeError(w, http.StatusForbidden, fmt.Sprintf("token %q may not trigger syncs for pipeline %q", token.Name, pipeline))
+		return
+	}
+
+	reason := "webhook:" + token.Name
+	if pipeline != "" {
+		reason += " pipeline:" + pipeline
+	}
+	if body.Reason != "" {
+		reason += " (" + body.Reason + ")"
+	}
+	if s.events != nil {
+		s.events.Record(events.TypeSyncTriggered, "Sync requested through webhook", "token", token.Name, "pipeline", pipeline, "reason", body.Reason)
+	}
+
+	status := "queued"
+	if !s.trigger(reason) {
+		status = "already_queued"
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": status})
+}
+
+// webhookToken returns the configured token matching the request's bearer token.
+func (s *Server) webhookToken(r *http.Request) (config.WebhookToken, bool) {
+	presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || presented == "" {
+		return config.WebhookToken{}, false
+	}
+	for _, token := range s.webhookTokens {
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(token.Token)) == 1 {
+			return token, true
+		}
+	}
+	return config.WebhookToken{}, false
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"kandji-cloudflare-device-sync/internal/httpclient"
+	"kandji-cloudflare-device-sync/metrics"
+)
+
+// apiResponseCounter counts Kandji and Cloudflare API responses by endpoint and status class.
+type apiResponseCounter struct {
+	responses *metrics.CounterVec
+}
+
+func newAPIResponseCounter(reg *metrics.Registry) *apiResponseCounter {
+	return &apiResponseCounter{
+		responses: reg.Counter("kandji_cloudflare_sync_api_responses_total",
+			"API responses by endpoint and status code class (2xx, 3xx, 4xx, 429, 5xx, or error if no response was received).",
+			"api", "method", "endpoint", "code_class"),
+	}
+}
+
+// observer returns the observer counting the responses of the named API.
+func (c *apiResponseCounter) observer(api string) httpclient.Observer {
+	return func(req *http.Request, status int) {
+		c.responses.Inc(api, req.Method, httpclient.Endpoint(req.URL.Path), httpclient.StatusClass(status))
+	}
+}
+
+// cloudflareQuotaGauges exports the rate limit quota reported by the Cloudflare API.
+type cloudflareQuotaGauges struct {
+	remaining *metrics.GaugeVec
+	limit     *metrics.GaugeVec
+	reset     *metrics.GaugeVec
+}
+
+func newCloudflareQuotaGauges(reg *metrics.Registry) *cloudflareQuotaGauges {
+	return &cloudflareQuotaGauges{
+		remaining: reg.Gauge("kandji_cloudflare_sync_cloudflare_ratelimit_remaining",
+			"Cloudflare API requests remaining in the current rate limit window, as last reported by the API."),
+		limit: reg.Gauge("kandji_cloudflare_sync_cloudflare_ratelimit_limit",
+			"Cloudflare API requests allowed per rate limit window, as last reported by the API."),
+		reset: reg.Gauge("kandji_cloudflare_sync_cloudflare_ratelimit_reset_seconds",
+			"Seconds until the current Cloudflare API rate limit window resets, as last reported by the API."),
+	}
+}
+
+// observe records the quota reported by a Cloudflare API response.
+func (g *cloudflareQuotaGauges) observe(remaining, limit int, reset time.Duration) {
+	g.remaining.Set(float64(remaining))
+	if limit > 0 {
+		g.limit.Set(float64(limit))
+	}
+	g.reset.Set(reset.Seconds())
+}
@@ -0,0 +1,132 @@
+// Package audit writes an append-only JSON Lines trail of every item appended to or
+// removed from a Cloudflare list, including the changes made by replacing a list.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"kandji-cloudflare-device-sync/cloudflare"
+)
+
+// Actions of an Entry.
+const (
+	ActionAdded   = "added"
+	ActionRemoved = "removed"
+)
+
+// Entry is a single line of the audit log: one item appended to or removed from a list.
+type Entry struct {
+	Time    time.Time `json:"time"`
+	CycleID string    `json:"cycle_id,omitempty"`
+	ListID  string    `json:"list_id"`
+	Action  string    `json:"action"`
+	// SerialNumber is the item's value, the owner's email address for the email list
+	SerialNumber string `json:"serial_number"`
+	Comment      string `json:"comment,omitempty"`
+	// Source is what the item was added for ("kandji" or source list IDs) or removed for
+	// ("on_missing", "expired", "sanitized"), empty if the caller did not annotate it
+	Source string `json:"source,omitempty"`
+}
+
+// Log appends entries to the audit log file. The file is only ever appended to, and
+// every mutation is flushed to disk before Record returns.
+type Log struct {
+	mu   sync.Mutex
+	file *os.File
+	log  *slog.Logger
+}
+
+// Open opens the audit log at path for appending, creating it if it does not exist.
+func Open(path string, log *slog.Logger) (*Log, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &Log{file: file, log: log}, nil
+}
+
+// Record writes an entry for every item of an applied mutation. It is a
+// cloudflare.MutationObserver; the cycle ID and the item annotations are taken from ctx.
+// The mutation was applied, so a failure to write is logged rather than returned.
+func (l *Log) Record(ctx context.Context, mutation cloudflare.Mutation) {
+	annotations := annotationsFrom(ctx)
+	var entries []Entry
+	if mutation.Operation == cloudflare.OperationReplace {
+		entries = replaceEntries(mutation, annotations)
+	} else {
+		action := ActionAdded
+		if mutation.Operation == cloudflare.OperationRemove {
+			action = ActionRemoved
+		}
+		for _, item := range mutation.Items {
+			annotation := annotations[item.Value]
+			entry := Entry{Action: action, SerialNumber: item.Value, Comment: item.Comment, Source: annotation.Source}
+			if entry.Comment == "" {
+				entry.Comment = annotation.Comment
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	cycleID := CycleID(ctx)
+	var lines []byte
+	for _, entry := range entries {
+		entry.Time, entry.CycleID, entry.ListID = mutation.Time, cycleID, mutation.ListID
+		line, err := json.Marshal(entry)
+		if err != nil {
+			l.log.Error("Failed to encode audit log entry", "serial_number", entry.SerialNumber, "error", err)
+			continue
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(lines); err != nil {
+		l.log.Error("Failed to write audit log", "list_id", mutation.ListID, "operation", mutation.Operation, "count", len(entries), "error", err)
+		return
+	}
+	if err := l.file.Sync(); err != nil {
+		l.log.Error("Failed to flush audit log", "error", err)
+	}
+}
+
+// replaceEntries returns the entries of a replacement. It carries the complete list, so
+// only the annotated items, those the caller changed, are recorded: as added if they are
+// in the replacement and as removed if they are not.
+func replaceEntries(mutation cloudflare.Mutation, annotations Annotations) []Entry {
+	replaced := make(map[string]string, len(mutation.Items))
+	for _, item := range mutation.Items {
+		replaced[item.Value] = item.Comment
+	}
+	values := make([]string, 0, len(annotations))
+	for value := range annotations {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+
+	entries := make([]Entry, 0, len(values))
+	for _, value := range values {
+		annotation := annotations[value]
+		entry := Entry{Action: ActionRemoved, SerialNumber: value, Comment: annotation.Comment, Source: annotation.Source}
+		if comment, ok := replaced[value]; ok {
+			entry.Action, entry.Comment = ActionAdded, comment
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Close closes the audit log file.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
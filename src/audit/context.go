@@ -0,0 +1,46 @@
+package audit
+
+import "context"
+
+type contextKey int
+
+const (
+	cycleIDKey contextKey = iota
+	annotationsKey
+)
+
+// Annotation is what the audit log records about an item beyond what the list mutation
+// itself carries.
+type Annotation struct {
+	Source string
+	// Comment is recorded for removals, whose mutations carry no comments
+	Comment string
+}
+
+// Annotations maps list item values to their annotations. A map attached to a context
+// may be filled in after the fact, it is read when a mutation is recorded.
+type Annotations map[string]Annotation
+
+// WithCycleID returns a context whose list mutations are recorded with the given sync
+// cycle ID.
+func WithCycleID(ctx context.Context, cycleID string) context.Context {
+	return context.WithValue(ctx, cycleIDKey, cycleID)
+}
+
+// CycleID returns the sync cycle ID attached to ctx, empty if there is none.
+func CycleID(ctx context.Context) string {
+	cycleID, _ := ctx.Value(cycleIDKey).(string)
+	return cycleID
+}
+
+// WithAnnotations returns a context whose list mutations are recorded with the given
+// annotations.
+func WithAnnotations(ctx context.Context, annotations Annotations) context.Context {
+	return context.WithValue(ctx, annotationsKey, annotations)
+}
+
+// annotationsFrom returns the annotations attached to ctx, nil if there are none.
+func annotationsFrom(ctx context.Context) Annotations {
+	annotations, _ := ctx.Value(annotationsKey).(Annotations)
+	return annotations
+}
@@ -0,0 +1,75 @@
+package cloudflare
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"kandji-cloudflare-device-sync/config"
+)
+
+// listBackend implements the list operations of the Client against one kind of Cloudflare
+// list. The Client handles dry runs, idempotency, batching and observers; a backend only
+// talks to the API. Lists are described with the Gateway list types whatever their kind.
+type listBackend interface {
+	// lists returns every list of the kind in the account
+	lists(ctx context.Context) ([]GatewayList, error)
+	// list returns the metadata of a list
+	list(ctx context.Context, listID string) (*GatewayList, error)
+	// items returns all items of a list
+	items(ctx context.Context, listID string) ([]GatewayListItem, error)
+	// appendItems adds items to a list, sending key as the request's idempotency key
+	appendItems(ctx context.Context, listID string, items []GatewayListItemCreateRequest, key string) error
+	// removeItems removes the items with the given values from a list
+	removeItems(ctx context.Context, listID string, values []string, key string) error
+	// updateItems removes the items with the given values from a list and appends items, so
+	// that no value both removed and appended is missing from the list in between
+	updateItems(ctx context.Context, listID string, values []string, items []GatewayListItemCreateRequest, key string) error
+	// replaceItems replaces all items of a list with the given items
+	replaceItems(ctx context.Context, listID string, items []GatewayListItemCreateRequest, key string) error
+	// create creates a list
+	create(ctx context.Context, request GatewayListCreateRequest) (*GatewayList, error)
+}
+
+// newListBackend returns the backend for the configured list kind.
+func newListBackend(c *Client, kind string) listBackend {
+	if kind == config.ListKindRules {
+		return &rulesBackend{c: c}
+	}
+	return &gatewayBackend{c: c}
+}
+
+// newRequest creates an authenticated API request for the given path below the account.
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	url := fmt.Sprintf("%s/accounts/%s%s", c.baseURL, c.accountID, path)
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiToken.Get())
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// decodeResponse checks the status of an API response and decodes its body into v. HTTP
+// 401 and 403 are reported as ErrUnauthorized, 404 as ErrListNotFound.
+func decodeResponse(resp *http.Response, v any) error {
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%w: HTTP %d - %s", ErrUnauthorized, resp.StatusCode, string(body))
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%w: HTTP %d - %s", ErrListNotFound, resp.StatusCode, string(body))
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("HTTP %d - %s", resp.StatusCode, string(body))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
@@ -1,16 +1,17 @@
 package cloudflare
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
+	"sync"
 	"time"
 
 	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/internal/authtoken"
+	"kandji-cloudflare-device-sync/internal/httpclient"
 	"kandji-cloudflare-device-sync/internal/ratelimit"
 )
 
@@ -18,14 +19,31 @@ const (
 	cloudflareAPIBaseV4 = "https://api.cloudflare.com/client/v4"
 )
 
-// Client represents a Cloudflare API client for managing Gateway device lists
+// Client represents a Cloudflare API client for managing device lists
 type Client struct {
-	apiToken    string
+	baseURL     string
+	apiToken    *authtoken.Token
 	accountID   string
 	listID      string
 	rateLimiter *ratelimit.Limiter
 	httpClient  *http.Client
+	httpOptions httpclient.Options
 	log         *slog.Logger
+	// backend talks to the API of the configured kind of list
+	backend listBackend
+	// deviceListType is the type of the lists holding serial numbers
+	deviceListType string
+
+	listNamesMu sync.Mutex
+	listNames   map[string]string // list name -> list ID
+
+	appliedBatches AppliedBatches
+	batchWindow    time.Duration
+	dryRun         bool
+	retry          config.RetryConfig
+	quotaObserver  QuotaObserver
+	// mutationObserver is notified of every applied list mutation, see WithMutationObserver
+	mutationObserver MutationObserver
 }
 
 // DeviceResult represents the result of a device operation
@@ -40,6 +58,8 @@ type BulkResult struct {
 	SuccessCount  int
 	FailedDevices []DeviceResult
 	Errors        []error
+	// Pending holds the serials of the batches not applied because the context was cancelled
+	Pending []string
 }
 
 // Gateway list API response structures
@@ -54,6 +74,7 @@ type GatewayList struct {
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
 	Type        string    `json:"type"`
+	Count       int       `json:"count"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
@@ -84,6 +105,12 @@ type GatewayListItemsCreateRequest struct {
 	Description string                         `json:"description,omitempty"`
 }
 
+// GatewayListItemsReplaceRequest replaces all items of a list. Unlike the replace field of
+// GatewayListItemsCreateRequest, an empty Replace is sent and clears the list.
+type GatewayListItemsReplaceRequest struct {
+	Replace []GatewayListItemCreateRequest `json:"replace"`
+}
+
 type GatewayListItemsDeleteRequest struct {
 	Remove []GatewayListItemCreateRequest `json:"remove"`
 }
@@ -92,357 +119,304 @@ type GatewayListItemDeleteRequest struct {
 	ID string `json:"id"`
 }
 
+// ErrUnauthorized is returned when Cloudflare rejects the API token or its permissions.
+var ErrUnauthorized = errors.New("Cloudflare API token rejected")
+
+// ErrListNotFound is returned when a list ID or name does not match any list.
+var ErrListNotFound = errors.New("Cloudflare list not found")
+
 // NewClient creates a new Cloudflare Gateway client
-func NewClient(cfg config.CloudflareConfig, rateLimiter *ratelimit.Limiter, log *slog.Logger) (*Client, error) {
+func NewClient(cfg config.CloudflareConfig, rateLimiter *ratelimit.Limiter, log *slog.Logger, opts ...Option) (*Client, error) {
 	if cfg.ApiToken == "" {
 		return nil, fmt.Errorf("Cloudflare API token is required")
 	}
 	if cfg.AccountID == "" {
 		return nil, fmt.Errorf("Cloudflare account ID is required")
 	}
-	if cfg.ListID == "" {
-		return nil, fmt.Errorf("Cloudflare list ID is required")
-	}
 
-	return &Client{
-		apiToken:    cfg.ApiToken,
+	c := &Client{
+		baseURL:     cloudflareAPIBaseV4,
+		apiToken:    authtoken.New(cfg.ApiToken),
 		accountID:   cfg.AccountID,
 		listID:      cfg.ListID,
 		rateLimiter: rateLimiter,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
+		httpOptions: httpclient.Options{
+			Timeout:  30 * time.Second,
+			ProxyURL: cfg.ProxyURL,
 		},
-		log: log,
-	}, nil
+		log:            log,
+		deviceListType: cfg.DeviceListType(),
+		listNames:      make(map[string]string),
+		retry:          cfg.Retry,
+	}
+	c.backend = newListBackend(c, cfg.ListKind)
+	for _, opt := range opts {
+		opt(c)
+	}
+	httpClient, err := httpclient.New(c.httpOptions)
+	if err != nil {
+		return nil, err
+	}
+	c.httpClient = httpClient
+	return c, nil
 }
 
-// makeRequest makes an HTTP request to the Cloudflare API
-func (c *Client) makeRequest(ctx context.Context, method, endpoint string, body interface{}) (*http.Response, error) {
-	// Apply rate limiting
-	if c.rateLimiter != nil {
-		if err := c.rateLimiter.WaitForCloudflare(ctx); err != nil {
-			return nil, fmt.Errorf("rate limiter cancelled: %w", err)
-		}
-	}
+// Option configures optional Client behaviour.
+type Option func(*Client)
 
-	var reqBody io.Reader
-	var payloadLog string
-	if body != nil {
-		jsonBody, err := json.Marshal(body)
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal request body: %w", err)
-		}
-		reqBody = bytes.NewReader(jsonBody)
-		payloadLog = string(jsonBody)
+// WithUserAgent sets the User-Agent sent on every request.
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.httpOptions.UserAgent = userAgent
 	}
+}
 
-	url := fmt.Sprintf("%s/accounts/%s/gateway/lists/%s%s", cloudflareAPIBaseV4, c.accountID, c.listID, endpoint)
-	c.log.Debug("Cloudflare API Request", "method", method, "url", url, "payload", payloadLog)
-	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+// WithNetwork sets the DNS servers and static host mappings used to reach the API.
+func WithNetwork(cfg config.NetworkConfig) Option {
+	return func(c *Client) {
+		c.httpOptions.DNSServers = cfg.DNSServers
+		c.httpOptions.Hosts = cfg.Hosts
 	}
+}
 
-	req.Header.Set("Authorization", "Bearer "+c.apiToken)
-	req.Header.Set("Content-Type", "application/json")
+// WithObserver sets an observer that is notified of the outcome of every API request.
+func WithObserver(observer httpclient.Observer) Option {
+	return func(c *Client) {
+		c.httpOptions.Observer = observer
+	}
+}
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute request: %w", err)
+// WithConditionalRequests keeps the responses of up to maxEntries GET requests that carry an
+// ETag or Last-Modified header, and sends those requests conditionally, so that Cloudflare
+// does not send a response again that did not change.
+func WithConditionalRequests(maxEntries int) Option {
+	return func(c *Client) {
+		c.httpOptions.ConditionalRequests = true
+		c.httpOptions.CacheEntries = maxEntries
 	}
+}
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		body, _ := io.ReadAll(resp.Body)
-		c.log.Error("Cloudflare API Error Response", "status", resp.StatusCode, "headers", resp.Header, "body", string(body))
-		resp.Body = io.NopCloser(bytes.NewReader(body)) // allow further reading
+// WithTokenSource sets where the API token is read again from when Cloudflare rejects it, so
+// that requests made after the token was rotated are retried with the new one.
+func WithTokenSource(source authtoken.Source) Option {
+	return func(c *Client) {
+		c.apiToken.SetSource(source)
 	}
+}
 
-	return resp, nil
+// SetToken replaces the API token, e.g. after it was rotated. Requests in progress finish with
+// the previous token.
+func (c *Client) SetToken(token string) {
+	c.apiToken.Set(token)
+}
+
+// WithBaseURL sends API requests to baseURL instead of the Cloudflare API, for example to
+// the fake API started in sandbox mode.
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.baseURL = baseURL
+	}
 }
 
 /*
-ValidateListExists checks if the specified Gateway list exists and is accessible.
+ValidateListExists checks if the target list exists and is accessible.
 */
 func (c *Client) ValidateListExists(ctx context.Context) error {
-	return c.ValidateListExistsByID(ctx, c.listID)
+	return c.ValidateListExistsByID(ctx, c.listID, c.deviceListType)
 }
 
 /*
-ValidateListExistsByID checks if the specified Gateway list exists and is accessible by ID.
-Returns nil if the list exists and is accessible, or an error otherwise.
+ValidateListExistsByID checks if the specified list exists and is accessible by ID.
+Returns nil if the list exists and is accessible, or an error otherwise. A warning is logged
+if the list is not of the expected type.
 */
-func (c *Client) ValidateListExistsByID(ctx context.Context, listID string) error {
-	resp, err := c.makeRequest(ctx, "GET", "", nil)
+func (c *Client) ValidateListExistsByID(ctx context.Context, listID, expectedType string) error {
+	list, err := c.GetListMetadataByID(ctx, listID)
 	if err != nil {
 		return fmt.Errorf("failed to validate list existence: %w", err)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to validate list existence: HTTP %d - %s", resp.StatusCode, string(body))
-	}
-
-	var response GatewayListResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return fmt.Errorf("failed to decode list response: %w", err)
-	}
 
-	if !response.Success {
-		return fmt.Errorf("failed to validate list existence: %v", response.Errors)
-	}
-
-	if response.Result == nil {
-		return fmt.Errorf("list with ID %s not found", listID)
-	}
-
-	c.log.Info("Successfully validated Cloudflare Gateway list",
+	c.log.Info("Successfully validated Cloudflare list",
 		"list_id", listID,
-		"list_name", response.Result.Name,
-		"list_type", response.Result.Type)
+		"list_name", list.Name,
+		"list_type", list.Type)
 
-	if response.Result.Type != "SERIAL" {
-		c.log.Warn("Cloudflare list is not of type SERIAL. Device serial sync may not work as expected.",
-			"list_id", listID, "list_type", response.Result.Type)
+	if list.Type != expectedType {
+		c.log.Warn("Cloudflare list is not of the expected type. Sync may not work as expected.",
+			"list_id", listID, "list_type", list.Type, "expected_type", expectedType)
 	}
 
 	return nil
 }
 
+/*
+DeviceListType returns the type of the lists that hold device serial numbers for the
+configured kind of list, e.g. SERIAL for Gateway lists.
+*/
+func (c *Client) DeviceListType() string {
+	return c.deviceListType
+}
+
 /*
 GetListTypeByID fetches the type of a Cloudflare list by its ID.
 Returns the type string (e.g., "SERIAL") or an error.
 */
 func (c *Client) GetListTypeByID(ctx context.Context, listID string) (string, error) {
-	endpoint := ""
-	url := fmt.Sprintf("%s/accounts/%s/gateway/lists/%s%s", cloudflareAPIBaseV4, c.accountID, listID, endpoint)
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
-	}
-	req.Header.Set("Authorization", "Bearer "+c.apiToken)
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := c.httpClient.Do(req)
+	list, err := c.backend.list(ctx, listID)
 	if err != nil {
 		return "", fmt.Errorf("failed to fetch list type: %w", err)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("failed to fetch list type: HTTP %d - %s", resp.StatusCode, string(body))
-	}
-
-	var response GatewayListResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return "", fmt.Errorf("failed to decode list response: %w", err)
-	}
-	if !response.Success || response.Result == nil {
-		return "", fmt.Errorf("failed to fetch list type: %v", response.Errors)
-	}
-	return response.Result.Type, nil
+	return list.Type, nil
 }
 
 /*
-GetListItemsByID retrieves all items from the specified Cloudflare Gateway list by ID,
+GetListItemsByID retrieves all items from the specified Cloudflare list by ID,
 handling pagination to ensure the full list is returned.
 Returns a slice of GatewayListItem (with Value and Comment).
 */
 func (c *Client) GetListItemsByID(ctx context.Context, listID string) ([]GatewayListItem, error) {
-	c.log.Debug("Fetching items from Cloudflare Gateway list", "list_id", listID)
-
-	var allItems []GatewayListItem
-	page := 1
-	perPage := 1000 // Cloudflare API max is 1000
-
-	for {
-		endpoint := fmt.Sprintf("/items?page=%d&per_page=%d", page, perPage)
-		url := fmt.Sprintf("%s/accounts/%s/gateway/lists/%s%s", cloudflareAPIBaseV4, c.accountID, listID, endpoint)
-		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create request: %w", err)
-		}
-		req.Header.Set("Authorization", "Bearer "+c.apiToken)
-		req.Header.Set("Content-Type", "application/json")
-
-		resp, err := c.httpClient.Do(req)
-		if err != nil {
-			return nil, fmt.Errorf("failed to execute request: %w", err)
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			return nil, fmt.Errorf("failed to get list items: HTTP %d - %s", resp.StatusCode, string(body))
-		}
-
-		var response GatewayListItemsResponse
-		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-			return nil, fmt.Errorf("failed to decode list items response: %w", err)
-		}
-
-		if !response.Success {
-			return nil, fmt.Errorf("failed to get list items: %v", response.Errors)
-		}
-
-		allItems = append(allItems, response.Result...)
-
-		// If we got less than perPage, we're done
-		if len(response.Result) < perPage {
-			break
-		}
-		page++
+	c.log.Debug("Fetching items from Cloudflare list", "list_id", listID)
+	items, err := c.backend.items(ctx, listID)
+	if err != nil {
+		return nil, err
 	}
-
-	c.log.Debug("Successfully fetched Gateway list items", "count", len(allItems))
-	return allItems, nil
+	c.log.Debug("Successfully fetched Cloudflare list items", "count", len(items))
+	return items, nil
 }
 
 /*
 GetListMetadataByID fetches the metadata (including description) for a Cloudflare list by its ID.
 */
 func (c *Client) GetListMetadataByID(ctx context.Context, listID string) (*GatewayList, error) {
-	url := fmt.Sprintf("%s/accounts/%s/gateway/lists/%s", cloudflareAPIBaseV4, c.accountID, listID)
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-	req.Header.Set("Authorization", "Bearer "+c.apiToken)
-	req.Header.Set("Content-Type", "application/json")
+	return c.backend.list(ctx, listID)
+}
 
-	resp, err := c.httpClient.Do(req)
+/*
+GetListItems retrieves the values of all items of the target list.
+*/
+func (c *Client) GetListItems(ctx context.Context) ([]string, error) {
+	items, err := c.GetListItemsByID(ctx, c.listID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch list metadata: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("failed to fetch list metadata: HTTP %d - %s", resp.StatusCode, string(body))
-	}
-
-	var response GatewayListResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, fmt.Errorf("failed to decode list metadata response: %w", err)
+		return nil, err
 	}
-	if !response.Success || response.Result == nil {
-		return nil, fmt.Errorf("failed to fetch list metadata: %v", response.Errors)
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		values = append(values, item.Value)
 	}
-	return response.Result, nil
+	return values, nil
 }
 
 /*
-GetListItems retrieves all items from the specified Cloudflare Gateway list,
-handling pagination to ensure the full list is returned.
+AppendDevices adds new devices to the target list (does not replace).
 */
-func (c *Client) GetListItems(ctx context.Context) ([]string, error) {
-	c.log.Debug("Fetching items from Cloudflare Gateway list", "list_id", c.listID)
-
-	var allItems []string
-	page := 1
-	perPage := 1000 // Cloudflare API max is 1000
-
-	for {
-		endpoint := fmt.Sprintf("/items?page=%d&per_page=%d", page, perPage)
-		resp, err := c.makeRequest(ctx, "GET", endpoint, nil)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get list items: %w", err)
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			return nil, fmt.Errorf("failed to get list items: HTTP %d - %s", resp.StatusCode, string(body))
-		}
-
-		var response GatewayListItemsResponse
-		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-			return nil, fmt.Errorf("failed to decode list items response: %w", err)
-		}
-
-		if !response.Success {
-			return nil, fmt.Errorf("failed to get list items: %v", response.Errors)
-		}
-
-		for _, item := range response.Result {
-			allItems = append(allItems, item.Value)
-		}
-
-		// If we got less than perPage, we're done
-		if len(response.Result) < perPage {
-			break
-		}
-		page++
-	}
-
-	c.log.Debug("Successfully fetched Gateway list items", "count", len(allItems))
-	return allItems, nil
+func (c *Client) AppendDevices(ctx context.Context, items []GatewayListItemCreateRequest, batchSize int) error {
+	return c.AppendItemsByID(ctx, c.listID, items)
 }
 
 /*
-AppendDevices adds new devices to the Cloudflare Gateway list (does not replace).
-This uses PATCH /accounts/{account_id}/gateway/lists/{list_id} with only "append".
+AppendItemsByID adds new items to the specified Cloudflare list by ID (does not replace).
 */
-func (c *Client) AppendDevices(ctx context.Context, items []GatewayListItemCreateRequest, batchSize int) error {
+func (c *Client) AppendItemsByID(ctx context.Context, listID string, items []GatewayListItemCreateRequest) error {
 	if len(items) == 0 {
 		return nil
 	}
 
-	c.log.Info("Appending devices to Cloudflare Gateway list", "count", len(items))
+	key := batchKey(ctx, listID, OperationAppend, items)
+	if c.isDryRun(ctx) {
+		c.log.Info("Dry run: not appending to Cloudflare list", "list_id", listID, "count", len(items), "items", items)
+		return nil
+	}
+	if c.batchApplied(ctx, key) {
+		c.log.Info("Skipping append batch that was already applied", "list_id", listID, "count", len(items), "idempotency_key", key)
+		return nil
+	}
 
-	requestBody := GatewayListItemsCreateRequest{
-		Append: items,
+	c.log.Info("Appending devices to Cloudflare list", "list_id", listID, "count", len(items), "idempotency_key", key)
+	if err := c.backend.appendItems(ctx, listID, items, key); err != nil {
+		return err
 	}
 
-	url := fmt.Sprintf("%s/accounts/%s/gateway/lists/%s", cloudflareAPIBaseV4, c.accountID, c.listID)
-	c.log.Debug("Cloudflare PATCH Request (append)", "url", url, "payload", requestBody)
+	c.recordBatch(ctx, key)
+	c.observeMutation(ctx, listID, OperationAppend, items)
+	c.log.Info("Successfully appended devices to Cloudflare list", "list_id", listID, "count", len(items))
+	return nil
+}
 
-	jsonBody, err := json.Marshal(requestBody)
-	if err != nil {
-		return fmt.Errorf("failed to marshal PATCH body: %w", err)
+/*
+ReplaceItemsByID replaces all items of the specified Cloudflare list with the given items
+in a single request. An empty items slice clears the list. Unlike appends, a replacement
+is sent even if the same batch was applied before, since its purpose is to overwrite
+whatever the list holds now.
+*/
+func (c *Client) ReplaceItemsByID(ctx context.Context, listID string, items []GatewayListItemCreateRequest) error {
+	key := batchKey(ctx, listID, OperationReplace, items)
+	if c.isDryRun(ctx) {
+		c.log.Info("Dry run: not replacing items of Cloudflare list", "list_id", listID, "count", len(items))
+		return nil
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "PATCH", url, bytes.NewReader(jsonBody))
-	if err != nil {
-		return fmt.Errorf("failed to create PATCH request: %w", err)
+	c.log.Info("Replacing items of Cloudflare list", "list_id", listID, "count", len(items), "idempotency_key", key)
+	if err := c.backend.replaceItems(ctx, listID, items, key); err != nil {
+		return err
 	}
-	req.Header.Set("Authorization", "Bearer "+c.apiToken)
-	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to execute PATCH request: %w", err)
-	}
-	defer resp.Body.Close()
+	c.observeMutation(ctx, listID, OperationReplace, items)
+	c.log.Info("Successfully replaced items of Cloudflare list", "list_id", listID, "count", len(items))
+	return nil
+}
 
-	body, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode != http.StatusOK {
-		c.log.Error("Cloudflare PATCH Error", "status", resp.StatusCode, "body", string(body))
-		return fmt.Errorf("PATCH failed: HTTP %d - %s", resp.StatusCode, string(body))
+/*
+UpdateItemsByID removes the items with the given values from the specified Cloudflare list
+and appends items, in one request where the kind of list allows it. A value that is both
+removed and appended, e.g. to update its comment, stays in the list throughout, also if the
+request fails.
+*/
+func (c *Client) UpdateItemsByID(ctx context.Context, listID string, values []string, items []GatewayListItemCreateRequest) error {
+	if len(values) == 0 && len(items) == 0 {
+		return nil
 	}
 
-	var response GatewayListResponse
-	if err := json.Unmarshal(body, &response); err != nil {
-		c.log.Error("Failed to decode PATCH response", "error", err)
-		return fmt.Errorf("decode failed: %w", err)
+	removeKeyItems := make([]GatewayListItemCreateRequest, 0, len(values))
+	for _, value := range values {
+		removeKeyItems = append(removeKeyItems, GatewayListItemCreateRequest{Value: value})
+	}
+	key := batchKey(ctx, listID, OperationRemove+"+"+OperationAppend, append(append([]GatewayListItemCreateRequest(nil), removeKeyItems...), items...))
+	if c.isDryRun(ctx) {
+		c.log.Info("Dry run: not updating Cloudflare list", "list_id", listID, "remove", values, "append", items)
+		return nil
+	}
+	if c.batchApplied(ctx, key) {
+		c.log.Info("Skipping update batch that was already applied", "list_id", listID, "remove", len(values), "append", len(items), "idempotency_key", key)
+		return nil
 	}
 
-	if !response.Success {
-		err := fmt.Errorf("PATCH failed: %v", response.Errors)
-		c.log.Error("Cloudflare PATCH failed", "error", err)
+	c.log.Info("Updating Cloudflare list", "list_id", listID, "remove", len(values), "append", len(items), "idempotency_key", key)
+	if err := c.backend.updateItems(ctx, listID, values, items, key); err != nil {
 		return err
 	}
 
-	c.log.Info("Successfully appended devices to Cloudflare Gateway list", "count", len(items))
+	c.recordBatch(ctx, key)
+	if len(values) > 0 {
+		c.observeMutation(ctx, listID, OperationRemove, removeKeyItems)
+	}
+	if len(items) > 0 {
+		c.observeMutation(ctx, listID, OperationAppend, items)
+	}
+	c.log.Info("Successfully updated Cloudflare list", "list_id", listID, "removed", len(values), "appended", len(items))
 	return nil
 }
 
 /*
-DeleteDevices removes Kandji devices from the Cloudflare Gateway list by serial number.
-This uses PATCH /accounts/{account_id}/gateway/lists/{list_id} with "remove".
+DeleteDevices removes Kandji devices from the target list by serial number.
 */
 func (c *Client) DeleteDevices(ctx context.Context, serialNumbers []string, batchSize int) (*BulkResult, error) {
+	return c.DeleteItemsByID(ctx, c.listID, serialNumbers, batchSize)
+}
+
+/*
+DeleteItemsByID removes items from the specified Cloudflare list by value, in batches.
+*/
+func (c *Client) DeleteItemsByID(ctx context.Context, listID string, serialNumbers []string, batchSize int) (*BulkResult, error) {
 	result := &BulkResult{
 		SuccessCount:  0,
 		FailedDevices: []DeviceResult{},
@@ -453,7 +427,7 @@ func (c *Client) DeleteDevices(ctx context.Context, serialNumbers []string, batc
 		return result, nil
 	}
 
-	c.log.Info("Removing devices from Cloudflare Gateway list", "count", len(serialNumbers), "batch_size", batchSize)
+	c.log.Info("Removing devices from Cloudflare list", "list_id", listID, "count", len(serialNumbers), "batch_size", batchSize)
 
 	// Process serials in batches
 	for i := 0; i < len(serialNumbers); i += batchSize {
@@ -462,7 +436,16 @@ func (c *Client) DeleteDevices(ctx context.Context, serialNumbers []string, batc
 			end = len(serialNumbers)
 		}
 		batch := serialNumbers[i:end]
-		batchResult := c.deleteDeviceBatch(ctx, batch)
+		if ctx.Err() != nil {
+			result.Pending = append(result.Pending, serialNumbers[i:]...)
+			result.Errors = append(result.Errors, fmt.Errorf("stopped before removing %d devices: %w", len(serialNumbers)-i, ctx.Err()))
+			break
+		}
+		batchResult := c.deleteDeviceBatch(ctx, listID, batch)
+		if batchResult.SuccessCount == 0 && ctx.Err() != nil {
+			// The batch was cut off and may or may not have been applied; removing is safe to repeat
+			result.Pending = append(result.Pending, batch...)
+		}
 		result.SuccessCount += batchResult.SuccessCount
 		result.FailedDevices = append(result.FailedDevices, batchResult.FailedDevices...)
 		result.Errors = append(result.Errors, batchResult.Errors...)
@@ -471,8 +454,8 @@ func (c *Client) DeleteDevices(ctx context.Context, serialNumbers []string, batc
 	return result, nil
 }
 
-// deleteDeviceBatch removes a batch of serial numbers from the Cloudflare Gateway list
-func (c *Client) deleteDeviceBatch(ctx context.Context, serialNumbers []string) *BulkResult {
+// deleteDeviceBatch removes a batch of serial numbers from a Cloudflare list
+func (c *Client) deleteDeviceBatch(ctx context.Context, listID string, serialNumbers []string) *BulkResult {
 	result := &BulkResult{
 		SuccessCount:  0,
 		FailedDevices: []DeviceResult{},
@@ -496,57 +479,35 @@ func (c *Client) deleteDeviceBatch(ctx context.Context, serialNumbers []string)
 		return result
 	}
 
-	requestBody := GatewayListItemsCreateRequest{
-		Remove: removeItems,
+	removeKeyItems := make([]GatewayListItemCreateRequest, 0, len(removeItems))
+	for _, serial := range removeItems {
+		removeKeyItems = append(removeKeyItems, GatewayListItemCreateRequest{Value: serial})
 	}
-
-	url := fmt.Sprintf("%s/accounts/%s/gateway/lists/%s", cloudflareAPIBaseV4, c.accountID, c.listID)
-	c.log.Debug("Cloudflare PATCH Request (remove)", "url", url, "payload", requestBody)
-
-	jsonBody, err := json.Marshal(requestBody)
-	if err != nil {
-		result.Errors = append(result.Errors, fmt.Errorf("failed to marshal PATCH body: %w", err))
+	key := batchKey(ctx, listID, OperationRemove, removeKeyItems)
+	if c.isDryRun(ctx) {
+		c.log.Info("Dry run: not removing from Cloudflare list", "list_id", listID, "count", len(removeItems), "items", removeItems)
+		result.SuccessCount = len(removeItems)
 		return result
 	}
-
-	req, err := http.NewRequestWithContext(ctx, "PATCH", url, bytes.NewReader(jsonBody))
-	if err != nil {
-		result.Errors = append(result.Errors, fmt.Errorf("failed to create PATCH request: %w", err))
+	if c.batchApplied(ctx, key) {
+		c.log.Info("Skipping remove batch that was already applied", "list_id", listID, "count", len(removeItems), "idempotency_key", key)
+		result.SuccessCount = len(removeItems)
 		return result
 	}
-	req.Header.Set("Authorization", "Bearer "+c.apiToken)
-	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		result.Errors = append(result.Errors, fmt.Errorf("failed to execute PATCH request: %w", err))
-		return result
-	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode != http.StatusOK {
-		c.log.Error("Cloudflare PATCH Error (remove)", "status", resp.StatusCode, "body", string(body))
-		result.Errors = append(result.Errors, fmt.Errorf("PATCH failed: HTTP %d - %s", resp.StatusCode, string(body)))
-		return result
-	}
-
-	var response GatewayListResponse
-	if err := json.Unmarshal(body, &response); err != nil {
-		c.log.Error("Failed to decode PATCH response (remove)", "error", err)
-		result.Errors = append(result.Errors, fmt.Errorf("decode failed: %w", err))
-		return result
-	}
-
-	if !response.Success {
-		err := fmt.Errorf("PATCH failed: %v", response.Errors)
-		c.log.Error("Cloudflare PATCH failed (remove)", "error", err)
+	if err := c.backend.removeItems(ctx, listID, removeItems, key); err != nil {
+		// The serials of the batch are listed too, so callers know which entries are still in the list
 		result.Errors = append(result.Errors, err)
+		for _, serial := range removeItems {
+			result.FailedDevices = append(result.FailedDevices, DeviceResult{SerialNumber: serial, Success: false, Error: err})
+		}
 		return result
 	}
 
 	result.SuccessCount = len(removeItems)
-	c.log.Info("Successfully removed devices from Cloudflare Gateway list", "count", result.SuccessCount)
+	c.recordBatch(ctx, key)
+	c.observeMutation(ctx, listID, OperationRemove, removeKeyItems)
+	c.log.Info("Successfully removed devices from Cloudflare list", "list_id", listID, "count", result.SuccessCount)
 	return result
 }
 
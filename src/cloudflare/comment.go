@@ -0,0 +1,33 @@
+package cloudflare
+
+import "unicode/utf8"
+
+// truncationSuffix marks a comment that was shortened to fit the comment length limit.
+const truncationSuffix = "..."
+
+// TruncateComment shortens a list item comment to at most maxLength characters. A leading
+// managed marker and a trailing expiry stamp are preserved and the text between them is
+// shortened instead, so truncated entries are still managed and still expire. A maxLength of
+// zero or less disables truncation.
+func TruncateComment(comment, marker string, maxLength int) string {
+	if maxLength <= 0 || utf8.RuneCountInString(comment) <= maxLength {
+		return comment
+	}
+
+	head, text, stamp := "", comment, ""
+	if HasMarker(text, marker) {
+		head, text = marker, text[len(marker):]
+	}
+	if loc := expiryPattern.FindStringIndex(text); loc != nil && loc[1] == len(text) {
+		text, stamp = text[:loc[0]], text[loc[0]:]
+	}
+
+	budget := maxLength - utf8.RuneCountInString(head) - utf8.RuneCountInString(stamp) - len(truncationSuffix)
+	if budget <= 0 {
+		// Not even the stamp fits; keep the marker over the stamp, and the stamp over the text.
+		// Configuration validation leaves room for both.
+		kept := []rune(head + stamp)
+		return string(kept[:max(min(maxLength, len(kept)), utf8.RuneCountInString(head))])
+	}
+	return head + string([]rune(text)[:min(budget, utf8.RuneCountInString(text))]) + truncationSuffix + stamp
+}
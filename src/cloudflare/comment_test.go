@@ -0,0 +1,54 @@
+package cloudflare
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateComment(t *testing.T) {
+	const marker = "[kandji-sync]"
+	tests := []struct {
+		name      string
+		comment   string
+		maxLength int
+		want      string
+	}{
+		{name: "fits", comment: "[kandji-sync] Jane's MacBook", maxLength: 64, want: "[kandji-sync] Jane's MacBook"},
+		{name: "no limit", comment: "[kandji-sync] Jane's MacBook", want: "[kandji-sync] Jane's MacBook"},
+		{name: "text shortened", comment: "[kandji-sync] Jane's MacBook Pro", maxLength: 24, want: "[kandji-sync] Jane's ..."},
+		{
+			name:      "stamp kept",
+			comment:   "[kandji-sync] Jane's MacBook Pro expires=2025-01-31T00:00:00Z",
+			maxLength: 52,
+			want:      "[kandji-sync] Jane's... expires=2025-01-31T00:00:00Z",
+		},
+		{
+			name:      "very short limit keeps the marker over the stamp",
+			comment:   "[kandji-sync] Jane's MacBook Pro expires=2025-01-31T00:00:00Z",
+			maxLength: 20,
+			want:      "[kandji-sync] expire",
+		},
+		{
+			name:      "limit shorter than the marker",
+			comment:   "[kandji-sync] Jane's MacBook Pro",
+			maxLength: 5,
+			want:      "[kandji-sync]",
+		},
+		{name: "unmanaged comment", comment: "Jane's MacBook Pro", maxLength: 10, want: "Jane's ..."},
+		{name: "multibyte text", comment: "[kandji-sync] Jörg's Mäc Bööök", maxLength: 24, want: "[kandji-sync] Jörg's ..."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := TruncateComment(tt.comment, marker, tt.maxLength)
+			if got != tt.want {
+				t.Errorf("TruncateComment(%q, %d) = %q, want %q", tt.comment, tt.maxLength, got, tt.want)
+			}
+			if !HasMarker(got, marker) && HasMarker(tt.comment, marker) {
+				t.Errorf("TruncateComment(%q, %d) = %q dropped the marker", tt.comment, tt.maxLength, got)
+			}
+			if tt.maxLength >= utf8.RuneCountInString(marker) && tt.maxLength > 0 && utf8.RuneCountInString(got) > tt.maxLength {
+				t.Errorf("TruncateComment(%q, %d) = %q is longer than the limit", tt.comment, tt.maxLength, got)
+			}
+		})
+	}
+}
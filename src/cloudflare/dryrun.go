@@ -0,0 +1,25 @@
+package cloudflare
+
+import "context"
+
+// WithDryRun makes the client log the list changes it would make instead of making them.
+// Appends, removals and list creation report success without any request being sent.
+func WithDryRun() Option {
+	return func(c *Client) {
+		c.dryRun = true
+	}
+}
+
+type dryRunKey struct{}
+
+// ContextWithDryRun returns a context that makes the client handle the requests made with
+// it as if it was created WithDryRun, e.g. for a single cycle during a blackout window.
+func ContextWithDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, true)
+}
+
+// isDryRun reports whether the changes requested with ctx are only logged.
+func (c *Client) isDryRun(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunKey{}).(bool)
+	return c.dryRun || dryRun
+}
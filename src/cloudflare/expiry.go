@@ -0,0 +1,34 @@
+package cloudflare
+
+import (
+	"regexp"
+	"time"
+)
+
+// expiryPattern matches the expiry stamp embedded in a list item comment, either as an
+// RFC 3339 timestamp or as a plain date (expires=2025-01-31).
+var expiryPattern = regexp.MustCompile(`(?:^|\s)expires=(\S+)`)
+
+// WithExpiry appends an expiry stamp to a list item comment.
+func WithExpiry(comment string, expiresAt time.Time) string {
+	stamp := "expires=" + expiresAt.UTC().Format(time.RFC3339)
+	if comment == "" {
+		return stamp
+	}
+	return comment + " " + stamp
+}
+
+// ParseExpiry extracts the expiry stamp from a list item comment.
+func ParseExpiry(comment string) (time.Time, bool) {
+	match := expiryPattern.FindStringSubmatch(comment)
+	if match == nil {
+		return time.Time{}, false
+	}
+	if expiresAt, err := time.Parse(time.RFC3339, match[1]); err == nil {
+		return expiresAt, true
+	}
+	if expiresAt, err := time.Parse("2006-01-02", match[1]); err == nil {
+		return expiresAt, true
+	}
+	return time.Time{}, false
+}
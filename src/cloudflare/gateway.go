@@ -0,0 +1,261 @@
+package cloudflare
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// gatewayBackend manages Zero Trust Gateway lists, under /accounts/{id}/gateway/lists.
+type gatewayBackend struct {
+	c *Client
+}
+
+func (g *gatewayBackend) lists(ctx context.Context) ([]GatewayList, error) {
+	req, err := g.c.newRequest(ctx, "GET", "/gateway/lists", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := g.c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Gateway lists: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var response GatewayListsResponse
+	if err := decodeResponse(resp, &response); err != nil {
+		return nil, fmt.Errorf("failed to list Gateway lists: %w", err)
+	}
+	if !response.Success {
+		return nil, fmt.Errorf("failed to list Gateway lists: %v", response.Errors)
+	}
+	return response.Result, nil
+}
+
+func (g *gatewayBackend) list(ctx context.Context, listID string) (*GatewayList, error) {
+	req, err := g.c.newRequest(ctx, "GET", "/gateway/lists/"+listID, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := g.c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch list metadata: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var response GatewayListResponse
+	if err := decodeResponse(resp, &response); err != nil {
+		return nil, fmt.Errorf("failed to fetch list metadata: %w", err)
+	}
+	if !response.Success || response.Result == nil {
+		return nil, fmt.Errorf("failed to fetch list metadata: %v", response.Errors)
+	}
+	return response.Result, nil
+}
+
+func (g *gatewayBackend) items(ctx context.Context, listID string) ([]GatewayListItem, error) {
+	var allItems []GatewayListItem
+	page := 1
+	perPage := 1000 // Cloudflare API max is 1000
+
+	for {
+		endpoint := fmt.Sprintf("/gateway/lists/%s/items?page=%d&per_page=%d", listID, page, perPage)
+		req, err := g.c.newRequest(ctx, "GET", endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := g.c.do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute request: %w", err)
+		}
+
+		var response GatewayListItemsResponse
+		err = decodeResponse(resp, &response)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get list items: %w", err)
+		}
+		if !response.Success {
+			return nil, fmt.Errorf("failed to get list items: %v", response.Errors)
+		}
+
+		allItems = append(allItems, response.Result...)
+
+		// If we got less than perPage, we're done
+		if len(response.Result) < perPage {
+			break
+		}
+		page++
+	}
+	return allItems, nil
+}
+
+// appendItems uses PATCH /accounts/{account_id}/gateway/lists/{list_id} with only "append".
+func (g *gatewayBackend) appendItems(ctx context.Context, listID string, items []GatewayListItemCreateRequest, key string) error {
+	body := GatewayListItemsCreateRequest{Append: items}
+	return g.patchRemaining(ctx, listID, body, key, body.remaining)
+}
+
+// removeItems uses PATCH /accounts/{account_id}/gateway/lists/{list_id} with "remove".
+func (g *gatewayBackend) removeItems(ctx context.Context, listID string, values []string, key string) error {
+	body := GatewayListItemsCreateRequest{Remove: values}
+	return g.patchRemaining(ctx, listID, body, key, body.remaining)
+}
+
+// updateItems uses a single PATCH /accounts/{account_id}/gateway/lists/{list_id} with
+// "remove" and "append", which Cloudflare applies at once.
+func (g *gatewayBackend) updateItems(ctx context.Context, listID string, values []string, items []GatewayListItemCreateRequest, key string) error {
+	body := GatewayListItemsCreateRequest{Append: items, Remove: values}
+	return g.patchRemaining(ctx, listID, body, key, body.remaining)
+}
+
+// replaceItems uses PATCH /accounts/{account_id}/gateway/lists/{list_id} with "replace".
+// The field is always sent, so an empty slice clears the list. Replacing twice gives the
+// same list, so the PATCH is sent again as is.
+func (g *gatewayBackend) replaceItems(ctx context.Context, listID string, items []GatewayListItemCreateRequest, key string) error {
+	if items == nil {
+		items = []GatewayListItemCreateRequest{}
+	}
+	return g.patchRemaining(ctx, listID, GatewayListItemsReplaceRequest{Replace: items}, key, nil)
+}
+
+// remaining returns the part of an append and remove PATCH not yet applied to a list whose
+// comments by value are current, or nil if the list already has all of it. An appended
+// value that is also removed is applied once it has the appended comment.
+func (r GatewayListItemsCreateRequest) remaining(current map[string]string) any {
+	var left GatewayListItemsCreateRequest
+	appended := make(map[string]string, len(r.Append))
+	for _, item := range r.Append {
+		appended[item.Value] = item.Comment
+	}
+	removed := make(map[string]struct{}, len(r.Remove))
+	for _, value := range r.Remove {
+		removed[value] = struct{}{}
+	}
+	for _, item := range r.Append {
+		comment, ok := current[item.Value]
+		if _, replaced := removed[item.Value]; !ok || (replaced && comment != item.Comment) {
+			left.Append = append(left.Append, item)
+		}
+	}
+	for _, value := range r.Remove {
+		comment, ok := current[value]
+		if reappended, replaced := appended[value]; ok && (!replaced || comment != reappended) {
+			left.Remove = append(left.Remove, value)
+		}
+	}
+	if len(left.Append) == 0 && len(left.Remove) == 0 {
+		return nil
+	}
+	return left
+}
+
+// patchRemaining sends a PATCH. Cloudflare does not deduplicate list mutations, so if it may
+// have been applied, the list is read again and only what remaining returns for it is sent,
+// up to cloudflare.retry.max_attempts in all. remaining may be nil if the PATCH can be sent
+// again as is. key is only logged and recorded, Cloudflare ignores an Idempotency-Key header.
+func (g *gatewayBackend) patchRemaining(ctx context.Context, listID string, body any, key string, remaining func(current map[string]string) any) error {
+	err := g.patch(ctx, listID, body, key)
+	maxAttempts := max(g.c.retry.MaxAttempts, 1)
+	for attempt := 1; errors.Is(err, errMaybeApplied) && attempt < maxAttempts; attempt++ {
+		delay := g.c.backoff(attempt)
+		g.c.log.Warn("Cloudflare list mutation may have been applied, reading the list before retrying",
+			"list_id", listID, "key", key, "reason", err, "attempt", attempt, "max_attempts", maxAttempts, "retry_in", delay.String())
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		if remaining != nil {
+			items, err := g.items(ctx, listID)
+			if err != nil {
+				return fmt.Errorf("failed to read list after a failed PATCH: %w", err)
+			}
+			current := make(map[string]string, len(items))
+			for _, item := range items {
+				current[item.Value] = item.Comment
+			}
+			if body = remaining(current); body == nil {
+				g.c.log.Info("Cloudflare list mutation was applied despite the error", "list_id", listID, "key", key)
+				return nil
+			}
+		}
+		err = g.patch(ctx, listID, body, key)
+	}
+	return err
+}
+
+// patch sends a PATCH once. A failure after which Cloudflare may have applied it wraps
+// errMaybeApplied.
+func (g *gatewayBackend) patch(ctx context.Context, listID string, requestBody any, key string) error {
+	g.c.log.Debug("Cloudflare PATCH Request", "list_id", listID, "key", key, "payload", requestBody)
+
+	jsonBody, err := json.Marshal(requestBody)
+	if err != nil {
+		return fmt.Errorf("failed to marshal PATCH body: %w", err)
+	}
+	req, err := g.c.newRequest(ctx, "PATCH", "/gateway/lists/"+listID, bytes.NewReader(jsonBody))
+	if err != nil {
+		return fmt.Errorf("failed to create PATCH request: %w", err)
+	}
+
+	resp, err := g.c.do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute PATCH request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		g.c.log.Error("Cloudflare PATCH Error", "status", resp.StatusCode, "body", string(body))
+		if resp.StatusCode >= 500 {
+			return fmt.Errorf("%w: PATCH failed: HTTP %d - %s", errMaybeApplied, resp.StatusCode, string(body))
+		}
+		return fmt.Errorf("PATCH failed: HTTP %d - %s", resp.StatusCode, string(body))
+	}
+
+	var response GatewayListResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		g.c.log.Error("Failed to decode PATCH response", "error", err)
+		return fmt.Errorf("decode failed: %w", err)
+	}
+	if !response.Success {
+		err := fmt.Errorf("PATCH failed: %v", response.Errors)
+		g.c.log.Error("Cloudflare PATCH failed", "error", err)
+		return err
+	}
+	return nil
+}
+
+func (g *gatewayBackend) create(ctx context.Context, request GatewayListCreateRequest) (*GatewayList, error) {
+	jsonBody, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+	req, err := g.c.newRequest(ctx, "POST", "/gateway/lists", bytes.NewReader(jsonBody))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := g.c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Gateway list: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var response GatewayListResponse
+	if err := decodeResponse(resp, &response); err != nil {
+		return nil, fmt.Errorf("failed to create Gateway list: %w", err)
+	}
+	if !response.Success || response.Result == nil {
+		return nil, fmt.Errorf("failed to create Gateway list: %v", response.Errors)
+	}
+	return response.Result, nil
+}
@@ -0,0 +1,83 @@
+package cloudflare
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// AppliedBatches remembers the list mutations that were applied, by idempotency key.
+type AppliedBatches interface {
+	BatchAppliedAt(key string) (time.Time, bool)
+	RecordBatch(key string, at time.Time) error
+}
+
+// WithAppliedBatches skips append and remove batches whose idempotency key was recorded as
+// applied within window. Keys are only checked and recorded for requests made with a context
+// from ContextWithMutationID, and include its ID, so only a batch of the same diff is skipped,
+// e.g. when an interrupted cycle is resumed, never a later diff that repeats its entries.
+func WithAppliedBatches(batches AppliedBatches, window time.Duration) Option {
+	return func(c *Client) {
+		c.appliedBatches = batches
+		c.batchWindow = window
+	}
+}
+
+type mutationIDKey struct{}
+
+// ContextWithMutationID returns a context whose list mutations belong to the diff with the
+// given ID, such as the ID of the cycle that computed it.
+func ContextWithMutationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, mutationIDKey{}, id)
+}
+
+// mutationID returns the diff ID attached to ctx, empty if there is none.
+func mutationID(ctx context.Context) string {
+	id, _ := ctx.Value(mutationIDKey{}).(string)
+	return id
+}
+
+// batchKey is the idempotency key of a list mutation: a hash of the diff it belongs to, the
+// list, the operation and its items, independent of the order of the items.
+// Cloudflare has no idempotency keys of its own, so it is only logged and recorded here.
+func batchKey(ctx context.Context, listID, operation string, items []GatewayListItemCreateRequest) string {
+	sorted := append([]GatewayListItemCreateRequest(nil), items...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Value != sorted[j].Value {
+			return sorted[i].Value < sorted[j].Value
+		}
+		return sorted[i].Comment < sorted[j].Comment
+	})
+	data, _ := json.Marshal(struct {
+		MutationID string                         `json:"mutation_id,omitempty"`
+		ListID     string                         `json:"list_id"`
+		Operation  string                         `json:"operation"`
+		Items      []GatewayListItemCreateRequest `json:"items"`
+	}{mutationID(ctx), listID, operation, sorted})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+// batchApplied reports whether the batch with the given key was applied within the window by
+// the diff of ctx.
+func (c *Client) batchApplied(ctx context.Context, key string) bool {
+	if c.appliedBatches == nil || mutationID(ctx) == "" {
+		return false
+	}
+	appliedAt, ok := c.appliedBatches.BatchAppliedAt(key)
+	return ok && time.Since(appliedAt) <= c.batchWindow
+}
+
+// recordBatch records a batch of the diff of ctx as applied. A failure to record is logged,
+// the batch was applied.
+func (c *Client) recordBatch(ctx context.Context, key string) {
+	if c.appliedBatches == nil || mutationID(ctx) == "" {
+		return
+	}
+	if err := c.appliedBatches.RecordBatch(key, time.Now().UTC()); err != nil {
+		c.log.Error("Failed to record applied batch", "idempotency_key", key, "error", err)
+	}
+}
@@ -0,0 +1,118 @@
+package cloudflare
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBatchKey(t *testing.T) {
+	ctx := ContextWithMutationID(context.Background(), "cycle-1")
+	items := []GatewayListItemCreateRequest{{Value: "A1"}, {Value: "B2", Comment: "owner"}}
+	key := batchKey(ctx, "list", "append", items)
+
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		listID    string
+		operation string
+		items     []GatewayListItemCreateRequest
+		wantSame  bool
+	}{
+		{
+			name: "same batch", ctx: ctx, listID: "list", operation: "append",
+			items:    items,
+			wantSame: true,
+		},
+		{
+			name: "items in another order", ctx: ctx, listID: "list", operation: "append",
+			items:    []GatewayListItemCreateRequest{{Value: "B2", Comment: "owner"}, {Value: "A1"}},
+			wantSame: true,
+		},
+		{
+			name: "another diff", ctx: ContextWithMutationID(context.Background(), "cycle-2"), listID: "list", operation: "append",
+			items: items,
+		},
+		{
+			name: "no diff", ctx: context.Background(), listID: "list", operation: "append",
+			items: items,
+		},
+		{
+			name: "another list", ctx: ctx, listID: "other", operation: "append",
+			items: items,
+		},
+		{
+			name: "another operation", ctx: ctx, listID: "list", operation: "remove",
+			items: items,
+		},
+		{
+			name: "another comment", ctx: ctx, listID: "list", operation: "append",
+			items: []GatewayListItemCreateRequest{{Value: "A1"}, {Value: "B2", Comment: "other owner"}},
+		},
+		{
+			name: "fewer items", ctx: ctx, listID: "list", operation: "append",
+			items: items[:1],
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := batchKey(tt.ctx, tt.listID, tt.operation, tt.items)
+			if (got == key) != tt.wantSame {
+				t.Errorf("batchKey() = %s, key of the batch = %s, want same = %v", got, key, tt.wantSame)
+			}
+		})
+	}
+}
+
+type fakeBatches map[string]time.Time
+
+func (b fakeBatches) BatchAppliedAt(key string) (time.Time, bool) {
+	at, ok := b[key]
+	return at, ok
+}
+
+func (b fakeBatches) RecordBatch(key string, at time.Time) error {
+	b[key] = at
+	return nil
+}
+
+func TestBatchApplied(t *testing.T) {
+	ctx := ContextWithMutationID(context.Background(), "cycle-1")
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		applied time.Duration
+		want    bool
+	}{
+		{name: "applied within the window", ctx: ctx, applied: time.Minute, want: true},
+		{name: "applied before the window", ctx: ctx, applied: time.Hour},
+		{name: "never applied", ctx: ctx},
+		{name: "no diff", ctx: context.Background(), applied: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batches := make(fakeBatches)
+			c := &Client{appliedBatches: batches, batchWindow: 10 * time.Minute}
+			key := batchKey(tt.ctx, "list", "append", []GatewayListItemCreateRequest{{Value: "A1"}})
+			if tt.applied > 0 {
+				batches[key] = time.Now().Add(-tt.applied)
+			}
+			if got := c.batchApplied(tt.ctx, key); got != tt.want {
+				t.Errorf("batchApplied() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordBatchWithoutDiff(t *testing.T) {
+	batches := make(fakeBatches)
+	c := &Client{appliedBatches: batches}
+	c.recordBatch(context.Background(), "key")
+	if len(batches) != 0 {
+		t.Errorf("recordBatch() without a diff recorded %v", batches)
+	}
+	c.recordBatch(ContextWithMutationID(context.Background(), "cycle-1"), "key")
+	if _, ok := batches["key"]; !ok {
+		t.Error("recordBatch() did not record the batch of a diff")
+	}
+}
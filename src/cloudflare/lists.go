@@ -0,0 +1,143 @@
+package cloudflare
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+)
+
+// listIDPattern matches Gateway list IDs, which are UUIDs, and Rules List IDs, which are 32
+// hex digits.
+var listIDPattern = regexp.MustCompile(`^(?:[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}|[0-9a-fA-F]{32})$`)
+
+// GatewayListCreateRequest is the body of a Gateway list creation request.
+type GatewayListCreateRequest struct {
+	Name        string                         `json:"name"`
+	Description string                         `json:"description,omitempty"`
+	Type        string                         `json:"type"`
+	Items       []GatewayListItemCreateRequest `json:"items,omitempty"`
+}
+
+type GatewayListsResponse struct {
+	Success bool          `json:"success"`
+	Errors  []any         `json:"errors"`
+	Result  []GatewayList `json:"result"`
+}
+
+// IsListID reports whether a list reference is a list ID rather than a list name.
+func IsListID(ref string) bool {
+	return listIDPattern.MatchString(ref)
+}
+
+/*
+ListLists retrieves all lists of the configured kind in the account.
+*/
+func (c *Client) ListLists(ctx context.Context) ([]GatewayList, error) {
+	return c.backend.lists(ctx)
+}
+
+/*
+ResolveListID resolves a list reference, either a list ID or a list name, to a list ID.
+Name lookups are cached until InvalidateListRef is called for the name. Names shared by
+more than one list are rejected.
+*/
+func (c *Client) ResolveListID(ctx context.Context, ref string) (string, error) {
+	if IsListID(ref) {
+		return ref, nil
+	}
+
+	c.listNamesMu.Lock()
+	id, ok := c.listNames[ref]
+	c.listNamesMu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	lists, err := c.ListLists(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve list %q: %w", ref, err)
+	}
+	var matches []string
+	for _, list := range lists {
+		if list.Name == ref {
+			matches = append(matches, list.ID)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("%w: no list named %q", ErrListNotFound, ref)
+	case 1:
+	default:
+		return "", fmt.Errorf("list name %q is ambiguous, it matches %d lists: %v", ref, len(matches), matches)
+	}
+
+	c.listNamesMu.Lock()
+	c.listNames[ref] = matches[0]
+	c.listNamesMu.Unlock()
+	c.log.Debug("Resolved Cloudflare list name", "name", ref, "list_id", matches[0])
+	return matches[0], nil
+}
+
+/*
+InvalidateListRef drops the cached ID of a list name so it is resolved again on next use.
+*/
+func (c *Client) InvalidateListRef(ref string) {
+	c.listNamesMu.Lock()
+	defer c.listNamesMu.Unlock()
+	delete(c.listNames, ref)
+}
+
+/*
+ResolveTargetList resolves the target list by name, checks that it has the expected type,
+and makes it the list used by the single-list methods. It returns the resolved list ID.
+*/
+func (c *Client) ResolveTargetList(ctx context.Context, name, expectedType string) (string, error) {
+	listID, err := c.ResolveListID(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	list, err := c.GetListMetadataByID(ctx, listID)
+	if err != nil {
+		return "", err
+	}
+	if list.Type != expectedType {
+		return "", fmt.Errorf("list %q (%s) is of type %s, expected %s", name, listID, list.Type, expectedType)
+	}
+
+	c.listID = listID
+	c.log.Info("Resolved Cloudflare target list by name", "name", name, "list_id", listID)
+	return listID, nil
+}
+
+/*
+CreateTargetList creates an empty list of the type that holds device serial numbers and
+makes it the list used by the single-list methods. It returns the new list's ID.
+*/
+func (c *Client) CreateTargetList(ctx context.Context, name, description string) (string, error) {
+	if c.isDryRun(ctx) {
+		return "", fmt.Errorf("not creating list %q in a dry run", name)
+	}
+	list, err := c.CreateList(ctx, GatewayListCreateRequest{Name: name, Description: description, Type: c.deviceListType})
+	if err != nil {
+		return "", err
+	}
+	c.listID = list.ID
+	return list.ID, nil
+}
+
+/*
+CreateList creates a new list of the configured kind in the account and returns it.
+*/
+func (c *Client) CreateList(ctx context.Context, request GatewayListCreateRequest) (*GatewayList, error) {
+	if c.isDryRun(ctx) {
+		c.log.Info("Dry run: not creating Cloudflare list", "name", request.Name, "type", request.Type, "items", len(request.Items))
+		return &GatewayList{Name: request.Name, Description: request.Description, Type: request.Type, Count: len(request.Items)}, nil
+	}
+	list, err := c.backend.create(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	c.log.Info("Created Cloudflare list", "list_id", list.ID, "name", list.Name, "type", list.Type)
+	return list, nil
+}
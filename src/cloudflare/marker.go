@@ -0,0 +1,20 @@
+package cloudflare
+
+import "strings"
+
+// WithMarker prefixes a list item comment with the managed-entry marker. An empty marker
+// leaves the comment unchanged.
+func WithMarker(comment, marker string) string {
+	if marker == "" {
+		return comment
+	}
+	if comment == "" {
+		return marker
+	}
+	return marker + " " + comment
+}
+
+// HasMarker reports whether a list item comment was stamped with the managed-entry marker.
+func HasMarker(comment, marker string) bool {
+	return marker != "" && strings.HasPrefix(comment, marker)
+}
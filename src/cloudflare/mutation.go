@@ -0,0 +1,42 @@
+package cloudflare
+
+import (
+	"context"
+	"time"
+)
+
+// Operations of a Mutation.
+const (
+	OperationAppend  = "append"
+	OperationRemove  = "remove"
+	OperationReplace = "replace"
+)
+
+// Mutation is a batch of items appended to or removed from a list, or the complete items a
+// list was replaced with. The items of a removal carry no comments.
+type Mutation struct {
+	Time      time.Time
+	ListID    string
+	Operation string
+	Items     []GatewayListItemCreateRequest
+}
+
+// MutationObserver is notified of every mutation Cloudflare confirmed. The context is the
+// one the mutation was requested with.
+type MutationObserver func(ctx context.Context, mutation Mutation)
+
+// WithMutationObserver sets an observer of the mutations applied to lists. Mutations that
+// are skipped in dry run mode or as already applied are not observed.
+func WithMutationObserver(observer MutationObserver) Option {
+	return func(c *Client) {
+		c.mutationObserver = observer
+	}
+}
+
+// observeMutation passes an applied mutation to the mutation observer.
+func (c *Client) observeMutation(ctx context.Context, listID, operation string, items []GatewayListItemCreateRequest) {
+	if c.mutationObserver == nil {
+		return
+	}
+	c.mutationObserver(ctx, Mutation{Time: time.Now().UTC(), ListID: listID, Operation: operation, Items: items})
+}
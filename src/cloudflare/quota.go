@@ -0,0 +1,70 @@
+package cloudflare
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// QuotaObserver is notified of the rate limit quota reported by every API response:
+// the requests remaining in the current window, the size of the quota (0 if not reported)
+// and when the window resets.
+type QuotaObserver func(remaining, limit int, reset time.Duration)
+
+// WithQuotaObserver sets an observer of the rate limit quota reported by the API.
+func WithQuotaObserver(observer QuotaObserver) Option {
+	return func(c *Client) {
+		c.quotaObserver = observer
+	}
+}
+
+// observeQuota logs the rate limit quota reported by resp and passes it to the quota
+// observer. Cloudflare reports it in the Ratelimit and Ratelimit-Policy headers, e.g.
+// `"default";r=1199;t=299` and `"burst";q=1200;w=300`, older endpoints in
+// X-RateLimit-Remaining and X-RateLimit-Limit.
+func (c *Client) observeQuota(resp *http.Response) {
+	remaining, reset, ok := rateLimitParams(resp.Header.Get("Ratelimit"), "r", "t")
+	limit, _, _ := rateLimitParams(resp.Header.Get("Ratelimit-Policy"), "q", "w")
+	if !ok {
+		var err error
+		if remaining, err = strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining")); err != nil {
+			return
+		}
+		limit, _ = strconv.Atoi(resp.Header.Get("X-RateLimit-Limit"))
+		reset = 0
+	}
+	c.log.Debug("Cloudflare API rate limit quota", "remaining", remaining, "limit", limit, "reset", reset.String())
+	if c.quotaObserver != nil {
+		c.quotaObserver(remaining, limit, reset)
+	}
+}
+
+// rateLimitParams reads two integer parameters of a structured rate limit header such as
+// `"default";r=50;t=30`, the second one in seconds.
+func rateLimitParams(header, countParam, secondsParam string) (int, time.Duration, bool) {
+	if header == "" {
+		return 0, 0, false
+	}
+	count, seconds := -1, 0
+	for _, part := range strings.Split(strings.SplitN(header, ",", 2)[0], ";") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			continue
+		}
+		switch key {
+		case countParam:
+			count = n
+		case secondsParam:
+			seconds = n
+		}
+	}
+	if count < 0 {
+		return 0, 0, false
+	}
+	return count, time.Duration(seconds) * time.Second, true
+}
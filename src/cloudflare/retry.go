@@ -0,0 +1,172 @@
+package cloudflare
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// errMaybeApplied marks a failed PATCH that may have been applied. Cloudflare does not
+// deduplicate list mutations, so do never sends such a PATCH again; the caller reads the
+// list and sends what is still missing instead, see gatewayBackend.patchRemaining.
+var errMaybeApplied = errors.New("list mutation may have been applied")
+
+// do sends the request, retrying it as configured if it fails with a network error, HTTP 429
+// or a 5xx status. Every attempt waits for the rate limiter, every retry also for the
+// backoff. The response of
+// the last attempt is returned, so a request that keeps failing with a status is handled
+// by the caller as before.
+//
+// A Retry-After header replaces the backoff and pauses the rate limiter, so that no other
+// request is sent before it has passed. HTTP 429 responses with Retry-After do not count as
+// attempts, until their delays add up to more than the configured maximum.
+//
+// If the token is rejected with HTTP 401 and a newer one is read from the token source, the
+// request is sent again once with it, without counting as an attempt.
+//
+// A PATCH is only retried if Cloudflare cannot have applied it: after HTTP 429, or a network
+// error before the request was written. After a 5xx status its response is returned, and a
+// network error after it was written is wrapped in errMaybeApplied.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	maxAttempts := max(c.retry.MaxAttempts, 1)
+	var retryAfterWaited time.Duration
+	tokenRefreshed := false
+	for attempt := 1; ; attempt++ {
+		if c.rateLimiter != nil {
+			if err := c.rateLimiter.WaitForCloudflare(ctx); err != nil {
+				return nil, fmt.Errorf("rate limiter cancelled: %w", err)
+			}
+		}
+		var wrote atomic.Bool
+		trace := &httptrace.ClientTrace{WroteRequest: func(httptrace.WroteRequestInfo) { wrote.Store(true) }}
+		resp, err := c.httpClient.Do(req.WithContext(httptrace.WithClientTrace(ctx, trace)))
+		if err == nil {
+			c.observeQuota(resp)
+		}
+		if err == nil && resp.StatusCode == http.StatusUnauthorized && !tokenRefreshed && (req.Body == nil || req.GetBody != nil) {
+			rejected := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+			if token, ok := c.apiToken.Refresh(ctx, rejected); ok {
+				tokenRefreshed = true
+				io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+				resp.Body.Close()
+				c.log.Info("Cloudflare API token rejected, retrying with the rotated token", "method", req.Method, "path", req.URL.Path)
+				if req, err = retryRequest(req); err != nil {
+					return nil, err
+				}
+				req.Header.Set("Authorization", "Bearer "+token)
+				attempt--
+				continue
+			}
+		}
+		if !retryable(ctx, resp, err) || (req.Body != nil && req.GetBody == nil) {
+			return resp, err
+		}
+		if req.Method == http.MethodPatch && wrote.Load() && (err != nil || resp.StatusCode != http.StatusTooManyRequests) {
+			if err != nil {
+				err = fmt.Errorf("%w: %w", errMaybeApplied, err)
+			}
+			return resp, err
+		}
+
+		failedAttempt := attempt
+		delay, hasRetryAfter := retryAfter(resp)
+		throttled := hasRetryAfter && resp.StatusCode == http.StatusTooManyRequests
+		if throttled && retryAfterWaited+delay <= c.retry.MaxRetryAfter {
+			retryAfterWaited += delay
+			attempt--
+		} else if attempt >= maxAttempts {
+			return resp, err
+		}
+		if !hasRetryAfter {
+			delay = c.backoff(attempt)
+		} else if c.rateLimiter != nil {
+			c.rateLimiter.PauseCloudflare(delay)
+		}
+
+		reason := fmt.Sprint(err)
+		if err == nil {
+			reason = fmt.Sprintf("HTTP %d", resp.StatusCode)
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		c.log.Warn("Cloudflare API request failed, retrying",
+			"method", req.Method, "path", req.URL.Path, "reason", reason,
+			"attempt", failedAttempt, "max_attempts", maxAttempts, "retry_in", delay.String(), "retry_after", hasRetryAfter)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		if req, err = retryRequest(req); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryable reports whether a request that ended with resp or err is worth retrying.
+func retryable(ctx context.Context, resp *http.Response, err error) bool {
+	if err != nil {
+		return ctx.Err() == nil
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// retryAfter returns the delay requested by the Retry-After header of resp, given in seconds
+// or as an HTTP date. Delays under a second are rounded up to one.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		delay = time.Until(date)
+	} else {
+		return 0, false
+	}
+	return max(delay, time.Second), true
+}
+
+// backoff returns the delay before the retry following the given attempt: the initial
+// backoff doubled per attempt up to the maximum, of which a random half is jitter.
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.retry.InitialBackoff
+	for i := 1; i < attempt && delay < c.retry.MaxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, c.retry.MaxBackoff)
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// retryRequest returns a copy of req with a fresh body to send it again.
+func retryRequest(req *http.Request) (*http.Request, error) {
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("failed to rewind request body: %w", err)
+		}
+		retry.Body = body
+	}
+	return retry, nil
+}
@@ -0,0 +1,166 @@
+package cloudflare
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"kandji-cloudflare-device-sync/config"
+)
+
+// flakyList is a Gateway list whose first PATCH fails with status, after it was applied if
+// applied is set. A status of 0 closes the connection instead of answering.
+type flakyList struct {
+	items   map[string]string
+	status  int
+	applied bool
+	patches int
+	reads   int
+}
+
+func (l *flakyList) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		l.reads++
+		response := GatewayListItemsResponse{Success: true, Result: []GatewayListItem{}}
+		for value, comment := range l.items {
+			response.Result = append(response.Result, GatewayListItem{Value: value, Comment: comment})
+		}
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	l.patches++
+	var body GatewayListItemsCreateRequest
+	json.NewDecoder(r.Body).Decode(&body)
+	failing := l.patches == 1 && l.status != http.StatusOK
+	if !failing || l.applied {
+		for _, value := range body.Remove {
+			delete(l.items, value)
+		}
+		for _, item := range body.Append {
+			if _, ok := l.items[item.Value]; ok {
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, `{"success":false,"errors":[{"message":"duplicate value `+item.Value+`"}]}`)
+				return
+			}
+			l.items[item.Value] = item.Comment
+		}
+	}
+	switch {
+	case failing && l.status == 0:
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	case failing:
+		w.WriteHeader(l.status)
+		io.WriteString(w, `{"success":false}`)
+	default:
+		json.NewEncoder(w).Encode(GatewayListResponse{Success: true, Result: &GatewayList{}})
+	}
+}
+
+func TestGatewayPatchRetry(t *testing.T) {
+	newItems := []GatewayListItemCreateRequest{{Value: "NEW1", Comment: "new"}}
+	tests := []struct {
+		name        string
+		status      int
+		applied     bool
+		update      bool
+		wantItems   map[string]string
+		wantPatches int
+		wantReads   int
+		wantErr     bool
+	}{
+		{name: "applied before HTTP 502", status: http.StatusBadGateway, applied: true, wantItems: map[string]string{"OLD1": "old", "NEW1": "new"}, wantPatches: 1, wantReads: 1},
+		{name: "not applied before HTTP 502", status: http.StatusBadGateway, wantItems: map[string]string{"OLD1": "old", "NEW1": "new"}, wantPatches: 2, wantReads: 1},
+		{name: "connection closed after the PATCH was applied", applied: true, wantItems: map[string]string{"OLD1": "old", "NEW1": "new"}, wantPatches: 1, wantReads: 1},
+		{name: "HTTP 429 is sent again as is", status: http.StatusTooManyRequests, wantItems: map[string]string{"OLD1": "old", "NEW1": "new"}, wantPatches: 2},
+		{name: "HTTP 400 is not retried", status: http.StatusBadRequest, wantItems: map[string]string{"OLD1": "old"}, wantPatches: 1, wantErr: true},
+		{name: "update applied before HTTP 500", status: http.StatusInternalServerError, applied: true, update: true, wantItems: map[string]string{"OLD1": "renamed", "NEW1": "new"}, wantPatches: 1, wantReads: 1},
+		{name: "update not applied before HTTP 500", status: http.StatusInternalServerError, update: true, wantItems: map[string]string{"OLD1": "renamed", "NEW1": "new"}, wantPatches: 2, wantReads: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list := &flakyList{items: map[string]string{"OLD1": "old"}, status: tt.status, applied: tt.applied}
+			srv := httptest.NewServer(list)
+			defer srv.Close()
+			c, err := NewClient(config.CloudflareConfig{
+				ApiToken:  "token",
+				AccountID: "account",
+				Retry:     config.RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+			}, nil, slog.New(slog.NewTextHandler(io.Discard, nil)), WithBaseURL(srv.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+			g := &gatewayBackend{c: c}
+
+			if tt.update {
+				err = g.updateItems(context.Background(), "list", []string{"OLD1"}, append(slices.Clone(newItems), GatewayListItemCreateRequest{Value: "OLD1", Comment: "renamed"}), "key")
+			} else {
+				err = g.appendItems(context.Background(), "list", newItems, "key")
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PATCH error = %v, want error = %v", err, tt.wantErr)
+			}
+			if err != nil && strings.Contains(err.Error(), "duplicate") {
+				t.Fatalf("PATCH was sent again after it was applied: %v", err)
+			}
+			if len(list.items) != len(tt.wantItems) {
+				t.Errorf("list = %v, want %v", list.items, tt.wantItems)
+			}
+			for value, comment := range tt.wantItems {
+				if got, ok := list.items[value]; !ok || got != comment {
+					t.Errorf("list = %v, want %v", list.items, tt.wantItems)
+					break
+				}
+			}
+			if list.patches != tt.wantPatches || list.reads != tt.wantReads {
+				t.Errorf("sent %d PATCH and %d GET requests, want %d and %d", list.patches, list.reads, tt.wantPatches, tt.wantReads)
+			}
+		})
+	}
+}
+
+func TestGatewayRemaining(t *testing.T) {
+	request := GatewayListItemsCreateRequest{
+		Append: []GatewayListItemCreateRequest{{Value: "NEW1", Comment: "new"}, {Value: "OLD1", Comment: "renamed"}},
+		Remove: []string{"OLD1", "GONE1"},
+	}
+	tests := []struct {
+		name    string
+		current map[string]string
+		want    any
+	}{
+		{name: "nothing applied", current: map[string]string{"OLD1": "old", "GONE1": ""}, want: request},
+		{name: "all applied", current: map[string]string{"OLD1": "renamed", "NEW1": "new"}, want: nil},
+		{
+			name:    "removal applied elsewhere",
+			current: map[string]string{"OLD1": "old"},
+			want: GatewayListItemsCreateRequest{
+				Append: request.Append,
+				Remove: []string{"OLD1"},
+			},
+		},
+		{
+			name:    "entry removed but not appended again",
+			current: map[string]string{"NEW1": "new"},
+			want:    GatewayListItemsCreateRequest{Append: request.Append[1:]},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := request.remaining(tt.current)
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(tt.want)
+			if string(gotJSON) != string(wantJSON) {
+				t.Errorf("remaining() = %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
@@ -0,0 +1,399 @@
+package cloudflare
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rulesOperationPollInterval is how often the status of an asynchronous Rules List
+// operation is checked.
+const rulesOperationPollInterval = time.Second
+
+// rulesBackend manages account-level Rules Lists, under /accounts/{id}/rules/lists. Only
+// the ip and hostname kinds are supported; their kind is reported as the list type, in
+// upper case like Gateway list types (IP, HOSTNAME).
+type rulesBackend struct {
+	c *Client
+
+	kindsMu sync.Mutex
+	kinds   map[string]string // list ID -> kind
+}
+
+type rulesList struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Kind        string    `json:"kind"`
+	NumItems    int       `json:"num_items"`
+	CreatedOn   time.Time `json:"created_on"`
+	ModifiedOn  time.Time `json:"modified_on"`
+}
+
+type rulesListItem struct {
+	ID         string         `json:"id"`
+	IP         string         `json:"ip,omitempty"`
+	Hostname   *rulesHostname `json:"hostname,omitempty"`
+	Comment    string         `json:"comment,omitempty"`
+	CreatedOn  time.Time      `json:"created_on"`
+	ModifiedOn time.Time      `json:"modified_on"`
+}
+
+type rulesListItemCreateRequest struct {
+	IP       string         `json:"ip,omitempty"`
+	Hostname *rulesHostname `json:"hostname,omitempty"`
+	Comment  string         `json:"comment,omitempty"`
+}
+
+type rulesHostname struct {
+	URLHostname string `json:"url_hostname"`
+}
+
+type rulesListResponse struct {
+	Success bool       `json:"success"`
+	Errors  []any      `json:"errors"`
+	Result  *rulesList `json:"result"`
+}
+
+type rulesListsResponse struct {
+	Success bool        `json:"success"`
+	Errors  []any       `json:"errors"`
+	Result  []rulesList `json:"result"`
+}
+
+type rulesListItemsResponse struct {
+	Success    bool            `json:"success"`
+	Errors     []any           `json:"errors"`
+	Result     []rulesListItem `json:"result"`
+	ResultInfo struct {
+		Cursors struct {
+			After string `json:"after"`
+		} `json:"cursors"`
+	} `json:"result_info"`
+}
+
+type rulesOperationResponse struct {
+	Success bool  `json:"success"`
+	Errors  []any `json:"errors"`
+	Result  struct {
+		OperationID string `json:"operation_id"`
+		// Status and Error are only set when the operation is fetched
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	} `json:"result"`
+}
+
+// gatewayList describes a Rules List with the Gateway list type.
+func (l *rulesList) gatewayList() GatewayList {
+	return GatewayList{
+		ID:          l.ID,
+		Name:        l.Name,
+		Description: l.Description,
+		Type:        strings.ToUpper(l.Kind),
+		Count:       l.NumItems,
+		CreatedAt:   l.CreatedOn,
+		UpdatedAt:   l.ModifiedOn,
+	}
+}
+
+// value returns the item's value for the list kind, empty for kinds that are not supported.
+func (i *rulesListItem) value() string {
+	if i.Hostname != nil {
+		return i.Hostname.URLHostname
+	}
+	return i.IP
+}
+
+func (r *rulesBackend) lists(ctx context.Context) ([]GatewayList, error) {
+	var response rulesListsResponse
+	if err := r.get(ctx, "/rules/lists", &response); err != nil {
+		return nil, fmt.Errorf("failed to list Rules Lists: %w", err)
+	}
+	if !response.Success {
+		return nil, fmt.Errorf("failed to list Rules Lists: %v", response.Errors)
+	}
+	lists := make([]GatewayList, 0, len(response.Result))
+	for _, list := range response.Result {
+		r.setKind(list.ID, list.Kind)
+		lists = append(lists, list.gatewayList())
+	}
+	return lists, nil
+}
+
+func (r *rulesBackend) list(ctx context.Context, listID string) (*GatewayList, error) {
+	var response rulesListResponse
+	if err := r.get(ctx, "/rules/lists/"+listID, &response); err != nil {
+		return nil, fmt.Errorf("failed to fetch list metadata: %w", err)
+	}
+	if !response.Success || response.Result == nil {
+		return nil, fmt.Errorf("failed to fetch list metadata: %v", response.Errors)
+	}
+	r.setKind(listID, response.Result.Kind)
+	list := response.Result.gatewayList()
+	return &list, nil
+}
+
+// items follows the cursor of the items endpoint until the last page.
+func (r *rulesBackend) items(ctx context.Context, listID string) ([]GatewayListItem, error) {
+	var allItems []GatewayListItem
+	cursor := ""
+	for {
+		query := url.Values{"per_page": {"500"}} // Rules List API max is 500
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+		var response rulesListItemsResponse
+		if err := r.get(ctx, "/rules/lists/"+listID+"/items?"+query.Encode(), &response); err != nil {
+			return nil, fmt.Errorf("failed to get list items: %w", err)
+		}
+		if !response.Success {
+			return nil, fmt.Errorf("failed to get list items: %v", response.Errors)
+		}
+		for _, item := range response.Result {
+			allItems = append(allItems, GatewayListItem{
+				ID:        item.ID,
+				Value:     item.value(),
+				Comment:   item.Comment,
+				CreatedAt: item.CreatedOn,
+				UpdatedAt: item.ModifiedOn,
+			})
+		}
+
+		cursor = response.ResultInfo.Cursors.After
+		if cursor == "" {
+			break
+		}
+	}
+	return allItems, nil
+}
+
+// appendItems creates the items with POST .../items and waits for the operation to finish.
+func (r *rulesBackend) appendItems(ctx context.Context, listID string, items []GatewayListItemCreateRequest, key string) error {
+	body, err := r.createRequests(ctx, listID, items)
+	if err != nil {
+		return err
+	}
+	return r.mutate(ctx, "POST", listID, body)
+}
+
+// updateItems appends the items first, which updates the comments of the values already
+// listed, and then removes the values that are not appended again, so that no appended
+// value is ever missing from the list.
+func (r *rulesBackend) updateItems(ctx context.Context, listID string, values []string, items []GatewayListItemCreateRequest, key string) error {
+	if len(items) > 0 {
+		if err := r.appendItems(ctx, listID, items, key); err != nil {
+			return err
+		}
+	}
+	appended := make(map[string]struct{}, len(items))
+	for _, item := range items {
+		appended[strings.ToLower(item.Value)] = struct{}{}
+	}
+	var remove []string
+	for _, value := range values {
+		if _, ok := appended[strings.ToLower(value)]; !ok {
+			remove = append(remove, value)
+		}
+	}
+	if len(remove) == 0 {
+		return nil
+	}
+	return r.removeItems(ctx, listID, remove, key)
+}
+
+// replaceItems replaces all items with PUT .../items and waits for the operation to finish.
+func (r *rulesBackend) replaceItems(ctx context.Context, listID string, items []GatewayListItemCreateRequest, key string) error {
+	body, err := r.createRequests(ctx, listID, items)
+	if err != nil {
+		return err
+	}
+	return r.mutate(ctx, "PUT", listID, body)
+}
+
+// createRequests converts items to the item type matching the kind of the list.
+func (r *rulesBackend) createRequests(ctx context.Context, listID string, items []GatewayListItemCreateRequest) ([]rulesListItemCreateRequest, error) {
+	kind, err := r.kind(ctx, listID)
+	if err != nil {
+		return nil, err
+	}
+	body := make([]rulesListItemCreateRequest, 0, len(items))
+	for _, item := range items {
+		ruleItem := rulesListItemCreateRequest{Comment: item.Comment}
+		switch kind {
+		case "ip":
+			ruleItem.IP = item.Value
+		case "hostname":
+			ruleItem.Hostname = &rulesHostname{URLHostname: item.Value}
+		default:
+			return nil, fmt.Errorf("Rules List %s is of kind %s, only ip and hostname lists are supported", listID, kind)
+		}
+		body = append(body, ruleItem)
+	}
+	return body, nil
+}
+
+// removeItems deletes items by ID, so the IDs of the values are looked up first. Values
+// that are not in the list are ignored.
+func (r *rulesBackend) removeItems(ctx context.Context, listID string, values []string, key string) error {
+	current, err := r.items(ctx, listID)
+	if err != nil {
+		return err
+	}
+	ids := make(map[string]string, len(current))
+	for _, item := range current {
+		ids[strings.ToLower(item.Value)] = item.ID
+	}
+
+	type itemID struct {
+		ID string `json:"id"`
+	}
+	var remove []itemID
+	for _, value := range values {
+		if id, ok := ids[strings.ToLower(value)]; ok {
+			remove = append(remove, itemID{ID: id})
+		}
+	}
+	if len(remove) == 0 {
+		return nil
+	}
+	return r.mutate(ctx, "DELETE", listID, map[string]any{"items": remove})
+}
+
+// create creates an empty list of the kind matching the request type and then appends the
+// request's items, which the Rules List creation endpoint does not take.
+func (r *rulesBackend) create(ctx context.Context, request GatewayListCreateRequest) (*GatewayList, error) {
+	jsonBody, err := json.Marshal(map[string]string{
+		"name":        request.Name,
+		"description": request.Description,
+		"kind":        strings.ToLower(request.Type),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+	req, err := r.c.newRequest(ctx, "POST", "/rules/lists", bytes.NewReader(jsonBody))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Rules List: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var response rulesListResponse
+	if err := decodeResponse(resp, &response); err != nil {
+		return nil, fmt.Errorf("failed to create Rules List: %w", err)
+	}
+	if !response.Success || response.Result == nil {
+		return nil, fmt.Errorf("failed to create Rules List: %v", response.Errors)
+	}
+	r.setKind(response.Result.ID, response.Result.Kind)
+	list := response.Result.gatewayList()
+	if len(request.Items) > 0 {
+		if err := r.appendItems(ctx, list.ID, request.Items, ""); err != nil {
+			return nil, fmt.Errorf("created Rules List %s but failed to add its items: %w", list.ID, err)
+		}
+		list.Count = len(request.Items)
+	}
+	return &list, nil
+}
+
+// mutate sends an item mutation, which the API applies asynchronously, and waits until the
+// operation has completed.
+func (r *rulesBackend) mutate(ctx context.Context, method, listID string, body any) error {
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s body: %w", method, err)
+	}
+	r.c.log.Debug("Cloudflare Rules List Request", "method", method, "list_id", listID, "payload", string(jsonBody))
+	req, err := r.c.newRequest(ctx, method, "/rules/lists/"+listID+"/items", bytes.NewReader(jsonBody))
+	if err != nil {
+		return err
+	}
+	resp, err := r.c.do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute %s request: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	var response rulesOperationResponse
+	if err := decodeResponse(resp, &response); err != nil {
+		r.c.log.Error("Cloudflare Rules List Error", "method", method, "list_id", listID, "error", err)
+		return fmt.Errorf("%s failed: %w", method, err)
+	}
+	if !response.Success {
+		return fmt.Errorf("%s failed: %v", method, response.Errors)
+	}
+	return r.waitForOperation(ctx, response.Result.OperationID)
+}
+
+// waitForOperation polls a bulk operation until it has completed or failed.
+func (r *rulesBackend) waitForOperation(ctx context.Context, operationID string) error {
+	if operationID == "" {
+		return nil
+	}
+	for {
+		var response rulesOperationResponse
+		if err := r.get(ctx, "/rules/lists/bulk_operations/"+operationID, &response); err != nil {
+			return fmt.Errorf("failed to fetch status of operation %s: %w", operationID, err)
+		}
+		switch response.Result.Status {
+		case "completed":
+			return nil
+		case "failed":
+			return fmt.Errorf("operation %s failed: %s", operationID, response.Result.Error)
+		}
+
+		timer := time.NewTimer(rulesOperationPollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("stopped waiting for operation %s: %w", operationID, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// kind returns the kind of a list, fetching its metadata if it is not known yet.
+func (r *rulesBackend) kind(ctx context.Context, listID string) (string, error) {
+	r.kindsMu.Lock()
+	kind, ok := r.kinds[listID]
+	r.kindsMu.Unlock()
+	if ok {
+		return kind, nil
+	}
+	if _, err := r.list(ctx, listID); err != nil {
+		return "", err
+	}
+	r.kindsMu.Lock()
+	defer r.kindsMu.Unlock()
+	return r.kinds[listID], nil
+}
+
+func (r *rulesBackend) setKind(listID, kind string) {
+	r.kindsMu.Lock()
+	defer r.kindsMu.Unlock()
+	if r.kinds == nil {
+		r.kinds = make(map[string]string)
+	}
+	r.kinds[listID] = kind
+}
+
+func (r *rulesBackend) get(ctx context.Context, path string, v any) error {
+	req, err := r.c.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.c.do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+	return decodeResponse(resp, v)
+}
@@ -0,0 +1,73 @@
+package cloudflare
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// TokenStatus is the state of the API token as reported by Cloudflare.
+type TokenStatus struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	// ExpiresOn is zero if the token does not expire
+	ExpiresOn time.Time `json:"expires_on"`
+}
+
+type tokenVerifyResponse struct {
+	Success bool         `json:"success"`
+	Errors  []any        `json:"errors"`
+	Result  *TokenStatus `json:"result"`
+}
+
+/*
+VerifyToken asks Cloudflare whether the API token is valid. User tokens are verified
+first; if Cloudflare does not know the token as a user token, it is verified as a token
+owned by the account.
+*/
+func (c *Client) VerifyToken(ctx context.Context) (*TokenStatus, error) {
+	status, err := c.verifyToken(ctx, c.baseURL+"/user/tokens/verify")
+	if err == nil {
+		return status, nil
+	}
+	if accountStatus, accountErr := c.verifyToken(ctx, fmt.Sprintf("%s/accounts/%s/tokens/verify", c.baseURL, c.accountID)); accountErr == nil {
+		return accountStatus, nil
+	}
+	return nil, err
+}
+
+func (c *Client) verifyToken(ctx context.Context, url string) (*TokenStatus, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiToken.Get())
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify API token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to verify API token: %w: HTTP %d - %s", ErrUnauthorized, resp.StatusCode, string(body))
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to verify API token: HTTP %d - %s", resp.StatusCode, string(body))
+	}
+
+	var response tokenVerifyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode token verify response: %w", err)
+	}
+	if !response.Success || response.Result == nil {
+		return nil, fmt.Errorf("failed to verify API token: %v", response.Errors)
+	}
+	return response.Result, nil
+}
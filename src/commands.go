@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// command is a subcommand of the binary.
+type command struct {
+	name string
+	// summary is the one-line description shown in the command list and the command's help
+	summary string
+	run     func(args []string) int
+}
+
+// commands returns the subcommands in the order they are listed in the help.
+func commands() []command {
+	return []command{
+		{"sync", "Run the sync service, or a single cycle with -once", runSync},
+		{"plan", "Compute the change the next sync would make and write it to a plan file", runPlan},
+		{"apply", "Make exactly the change of a plan file to the target list", runApply},
+		{"export", "Write the target list or the filtered devices to stdout as CSV or JSON", runExport},
+		{"import", "Append the serials of CSV or JSON files to the target list", runImport},
+		{"purge", "Remove every entry of a list, after confirmation", runPurge},
+		{"validate", "Check the configuration without contacting any API", runValidate},
+		{"preflight", "Check the configuration, the credentials and every configured list", runPreflight},
+		{"lists", "List the Gateway or Rules lists of the Cloudflare account", runLists},
+		{"stats", "Print statistics of the sync history in the state store", runStats},
+		{"history", "Query past sync cycles or the changes of a device", runHistory},
+		{"migrate-config", "Upgrade a config file to the current layout", runMigrateConfig},
+		{"version", "Print the version and build information", runVersion},
+		{"help", "Print this help, or the help of a command", runHelp},
+	}
+}
+
+// runCommand runs the subcommand named by the first argument. Without one, or if the first
+// argument is a flag, the arguments are those of sync, as before subcommands existed;
+// -version is still accepted there.
+func runCommand(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		for _, arg := range args {
+			if arg == "-version" || arg == "--version" {
+				return runVersion(nil)
+			}
+			if arg == "-h" || arg == "-help" || arg == "--help" {
+				return runHelp(nil)
+			}
+		}
+		return runSync(args)
+	}
+	if cmd, ok := findCommand(args[0]); ok {
+		return cmd.run(args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+	printUsage()
+	return exitConfig
+}
+
+// findCommand returns the subcommand called name.
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands() {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+// newFlagSet returns the flag set of a subcommand, whose -h prints the command's summary
+// and flags. name may include further words, as in "history cycles".
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s %s [flags]\n\n", programName(), name)
+		if cmd, ok := findCommand(strings.Fields(name)[0]); ok {
+			fmt.Fprintf(fs.Output(), "%s.\n\n", cmd.summary)
+		}
+		fmt.Fprintln(fs.Output(), "flags:")
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// runVersion prints the version and build information.
+func runVersion(args []string) int {
+	fs := newFlagSet("version")
+	if err := fs.Parse(args); err != nil {
+		return exitConfig
+	}
+	fmt.Printf("%s, %s, %s, %s\n", Version, Commit, CommitDate, TreeState)
+	return exitOK
+}
+
+// runHelp prints the list of subcommands, or the help of the command named by args.
+func runHelp(args []string) int {
+	if len(args) > 0 {
+		cmd, ok := findCommand(args[0])
+		if !ok {
+			fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+			printUsage()
+			return exitConfig
+		}
+		if cmd.name != "help" {
+			return cmd.run([]string{"-h"})
+		}
+	}
+	printUsage()
+	return exitOK
+}
+
+// printUsage prints the list of subcommands to stderr.
+func printUsage() {
+	name := programName()
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags]\n\ncommands:\n", name)
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands() {
+		fmt.Fprintf(w, "  %s\t%s\n", cmd.name, cmd.summary)
+	}
+	w.Flush()
+	fmt.Fprintf(os.Stderr, "\nRun \"%s <command> -h\" for the flags of a command. Without a command, the flags are those of sync.\n", name)
+}
+
+// programName is the name the binary was run as.
+func programName() string {
+	return filepath.Base(os.Args[0])
+}
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// BlackoutWindow is a period, such as a change freeze, during which sync cycles still run
+// and report their diff but make no changes. It is either a one-off period from From until
+// Until, or a recurring one from Start to End on Days.
+type BlackoutWindow struct {
+	// Name identifies the window in logs and reports
+	Name string `yaml:"name"`
+	// From and Until bound a one-off window, as RFC 3339 timestamps
+	From  string `yaml:"from"`
+	Until string `yaml:"until"`
+	// Days are the days of the week a recurring window starts on, e.g. mon or saturday; it
+	// recurs daily if empty
+	Days []string `yaml:"days"`
+	// Start and End are the times of day a recurring window starts and ends, as HH:MM in
+	// TimeZone. An End at or before Start ends the window the next day.
+	Start    string `yaml:"start"`
+	End      string `yaml:"end"`
+	TimeZone string `yaml:"timezone"`
+}
+
+// BlackoutWindows are the blackout windows of a sync.
+type BlackoutWindows []BlackoutWindow
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+// Validate checks that every window is either one-off or recurring, and well-formed.
+func (b BlackoutWindows) Validate() error {
+	for i, window := range b {
+		if err := window.validate(); err != nil {
+			return fmt.Errorf("window %s: %w", window.label(i), err)
+		}
+	}
+	return nil
+}
+
+func (w *BlackoutWindow) validate() error {
+	oneOff := w.From != "" || w.Until != ""
+	recurring := w.Start != "" || w.End != "" || len(w.Days) > 0
+	switch {
+	case oneOff && recurring:
+		return fmt.Errorf("set either from and until, or start and end, not both")
+	case oneOff:
+		from, err := time.Parse(time.RFC3339, w.From)
+		if err != nil {
+			return fmt.Errorf("from must be an RFC 3339 timestamp, got %q", w.From)
+		}
+		until, err := time.Parse(time.RFC3339, w.Until)
+		if err != nil {
+			return fmt.Errorf("until must be an RFC 3339 timestamp, got %q", w.Until)
+		}
+		if !until.After(from) {
+			return fmt.Errorf("until must be after from")
+		}
+		if w.TimeZone != "" {
+			return fmt.Errorf("timezone only applies to start and end, from and until carry their offset")
+		}
+	case recurring:
+		if _, err := time.Parse("15:04", w.Start); err != nil {
+			return fmt.Errorf("start must be HH:MM, got %q", w.Start)
+		}
+		if _, err := time.Parse("15:04", w.End); err != nil {
+			return fmt.Errorf("end must be HH:MM, got %q", w.End)
+		}
+		for _, day := range w.Days {
+			if _, ok := weekdays[strings.ToLower(day)]; !ok {
+				return fmt.Errorf("invalid day %q, use e.g. mon or monday", day)
+			}
+		}
+		if _, err := time.LoadLocation(w.TimeZone); err != nil {
+			return fmt.Errorf("invalid timezone %q: %w", w.TimeZone, err)
+		}
+	default:
+		return fmt.Errorf("set from and until, or start and end")
+	}
+	return nil
+}
+
+// label names the window by its name or else, e.g. "#2", by its position.
+func (w *BlackoutWindow) label(i int) string {
+	if w.Name != "" {
+		return w.Name
+	}
+	return fmt.Sprintf("#%d", i+1)
+}
+
+// Active returns the name of the first window that t falls in, or its position such as "#2"
+// if it has none, and whether there is one. The windows must have been validated.
+func (b BlackoutWindows) Active(t time.Time) (string, bool) {
+	for i, window := range b {
+		if window.contains(t) {
+			return window.label(i), true
+		}
+	}
+	return "", false
+}
+
+func (w *BlackoutWindow) contains(t time.Time) bool {
+	if w.From != "" {
+		from, _ := time.Parse(time.RFC3339, w.From)
+		until, _ := time.Parse(time.RFC3339, w.Until)
+		return !t.Before(from) && t.Before(until)
+	}
+
+	// An empty TimeZone loads UTC
+	loc, err := time.LoadLocation(w.TimeZone)
+	if err != nil {
+		return false
+	}
+	start, _ := time.Parse("15:04", w.Start)
+	end, _ := time.Parse("15:04", w.End)
+	local := t.In(loc)
+	// The window may have started today or, if it runs past midnight, yesterday
+	for _, day := range []time.Time{local, local.AddDate(0, 0, -1)} {
+		if !w.onDay(day.Weekday()) {
+			continue
+		}
+		opens := time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), 0, 0, loc)
+		closes := time.Date(day.Year(), day.Month(), day.Day(), end.Hour(), end.Minute(), 0, 0, loc)
+		if !closes.After(opens) {
+			closes = closes.AddDate(0, 0, 1)
+		}
+		if !local.Before(opens) && local.Before(closes) {
+			return true
+		}
+	}
+	return false
+}
+
+// onDay reports whether a recurring window starts on day.
+func (w *BlackoutWindow) onDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if weekdays[strings.ToLower(d)] == day {
+			return true
+		}
+	}
+	return false
+}
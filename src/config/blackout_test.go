@@ -0,0 +1,87 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestBlackoutWindowsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		window  BlackoutWindow
+		wantErr string
+	}{
+		{name: "one-off", window: BlackoutWindow{From: "2024-12-20T00:00:00Z", Until: "2025-01-02T00:00:00+01:00"}},
+		{name: "recurring", window: BlackoutWindow{Days: []string{"fri", "Saturday"}, Start: "18:00", End: "06:00", TimeZone: "Europe/Berlin"}},
+		{name: "daily in UTC", window: BlackoutWindow{Start: "00:00", End: "01:00"}},
+		{name: "empty", wantErr: "set from and until, or start and end"},
+		{name: "both kinds", window: BlackoutWindow{From: "2024-12-20T00:00:00Z", Until: "2025-01-02T00:00:00Z", Start: "18:00"}, wantErr: "not both"},
+		{name: "from is a date", window: BlackoutWindow{From: "2024-12-20", Until: "2025-01-02T00:00:00Z"}, wantErr: "from must be an RFC 3339 timestamp"},
+		{name: "until missing", window: BlackoutWindow{From: "2024-12-20T00:00:00Z"}, wantErr: "until must be an RFC 3339 timestamp"},
+		{name: "until before from", window: BlackoutWindow{From: "2025-01-02T00:00:00Z", Until: "2024-12-20T00:00:00Z"}, wantErr: "until must be after from"},
+		{name: "one-off with timezone", window: BlackoutWindow{From: "2024-12-20T00:00:00Z", Until: "2025-01-02T00:00:00Z", TimeZone: "UTC"}, wantErr: "timezone only applies"},
+		{name: "start out of range", window: BlackoutWindow{Start: "24:00", End: "06:00"}, wantErr: "start must be HH:MM"},
+		{name: "end missing", window: BlackoutWindow{Start: "18:00"}, wantErr: "end must be HH:MM"},
+		{name: "invalid day", window: BlackoutWindow{Days: []string{"friyay"}, Start: "18:00", End: "06:00"}, wantErr: "invalid day"},
+		{name: "invalid timezone", window: BlackoutWindow{Start: "18:00", End: "06:00", TimeZone: "Mars/Olympus"}, wantErr: "invalid timezone"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := BlackoutWindows{tt.window}.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBlackoutWindowsActive(t *testing.T) {
+	windows := BlackoutWindows{
+		{Name: "year-end freeze", From: "2024-12-20T00:00:00Z", Until: "2025-01-02T00:00:00Z"},
+		// Friday and Saturday nights in Berlin, UTC+1 in winter and UTC+2 in summer
+		{Days: []string{"fri", "saturday"}, Start: "22:00", End: "02:00", TimeZone: "Europe/Berlin"},
+		{Name: "maintenance", Start: "12:00", End: "12:30"},
+	}
+	if err := windows.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		at   string
+		want string
+	}{
+		{name: "start of a one-off window", at: "2024-12-20T00:00:00Z", want: "year-end freeze"},
+		{name: "end of a one-off window", at: "2025-01-02T00:00:00Z"},
+		{name: "recurring window on its day", at: "2024-03-15T21:30:00Z", want: "#2"},
+		{name: "recurring window past midnight", at: "2024-03-16T00:30:00Z", want: "#2"},
+		{name: "recurring window closed", at: "2024-03-16T01:00:00Z"},
+		{name: "recurring window opening at the day's start", at: "2024-03-16T21:00:00Z", want: "#2"},
+		{name: "recurring window past midnight into a day it does not start on", at: "2024-03-17T00:59:00Z", want: "#2"},
+		{name: "not on a day the window starts on", at: "2024-03-17T21:30:00Z"},
+		{name: "recurring window in summer time", at: "2024-07-12T20:00:00Z", want: "#2"},
+		{name: "before a recurring window in summer time", at: "2024-07-12T19:59:00Z"},
+		{name: "daily window", at: "2024-03-13T12:15:00Z", want: "maintenance"},
+		{name: "one-off window wins over a later one", at: "2024-12-20T12:15:00Z", want: "year-end freeze"},
+		{name: "no window", at: "2024-03-13T13:00:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at, err := time.Parse(time.RFC3339, tt.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			name, active := windows.Active(at)
+			if name != tt.want || active != (tt.want != "") {
+				t.Errorf("Active(%s) = %q, %v, want %q", tt.at, name, active, tt.want)
+			}
+		})
+	}
+}
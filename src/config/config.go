@@ -1,31 +1,823 @@
 package config
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"gopkg.in/yaml.v2"
+
+	"kandji-cloudflare-device-sync/internal/sops"
+	"kandji-cloudflare-device-sync/secrets"
 )
 
 // Config holds all configuration for the application.
 type Config struct {
-	SyncInterval time.Duration    `yaml:"sync_interval"`
-	OnMissing    string           `yaml:"on_missing"`
-	Kandji       KandjiConfig     `yaml:"kandji"`
-	Cloudflare   CloudflareConfig `yaml:"cloudflare"`
-	RateLimits   RateLimitConfig  `yaml:"rate_limits"`
-	Batch        BatchConfig      `yaml:"batch"`
-	Log          LoggingConfig    `yaml:"log"`
+	// ConfigVersion is the version of the config layout, see CurrentConfigVersion
+	ConfigVersion  int                  `yaml:"config_version"`
+	SyncInterval   time.Duration        `yaml:"sync_interval"`
+	OnMissing      string               `yaml:"on_missing"`
+	DeleteScope    string               `yaml:"delete_scope"`
+	SyncMode       string               `yaml:"sync_mode"`
+	CycleSLO       time.Duration        `yaml:"cycle_slo"`
+	OnOverlap      string               `yaml:"on_overlap"`
+	DryRun         bool                 `yaml:"dry_run"`
+	Kandji         KandjiConfig         `yaml:"kandji"`
+	Cloudflare     CloudflareConfig     `yaml:"cloudflare"`
+	Intune         IntuneConfig         `yaml:"intune"`
+	Mosyle         MosyleConfig         `yaml:"mosyle"`
+	RateLimits     RateLimitConfig      `yaml:"rate_limits"`
+	Batch          BatchConfig          `yaml:"batch"`
+	Log            LoggingConfig        `yaml:"log"`
+	Destinations   DestinationsConfig   `yaml:"destinations"`
+	Expiry         ExpiryConfig         `yaml:"expiry"`
+	State          StateConfig          `yaml:"state"`
+	Client         ClientConfig         `yaml:"client"`
+	Network        NetworkConfig        `yaml:"network"`
+	UpdateCheck    UpdateCheckConfig    `yaml:"update_check"`
+	Metrics        MetricsConfig        `yaml:"metrics"`
+	Admin          AdminConfig          `yaml:"admin"`
+	SMTP           SMTPConfig           `yaml:"smtp"`
+	Digest         DigestConfig         `yaml:"digest"`
+	Webhook        WebhookConfig        `yaml:"webhook"`
+	Sandbox        SandboxConfig        `yaml:"sandbox"`
+	WarmCache      WarmCacheConfig      `yaml:"warm_cache"`
+	CatchUp        CatchUpConfig        `yaml:"catch_up"`
+	Reload         ReloadConfig         `yaml:"reload"`
+	Notifications  NotificationsConfig  `yaml:"notifications"`
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+	LeaderElection LeaderElectionConfig `yaml:"leader_election"`
+	Shutdown       ShutdownConfig       `yaml:"shutdown"`
+	Systemd        SystemdConfig        `yaml:"systemd"`
+	Audit          AuditConfig          `yaml:"audit"`
+	Secrets        SecretsConfig        `yaml:"secrets"`
+	// MaxRemovals caps the removals a cycle may apply to each list
+	MaxRemovals MaxRemovalsConfig `yaml:"max_removals_per_cycle"`
+	// OnMissingGraceCycles is how many consecutive cycles an entry must be missing from all
+	// sources before on_missing "delete" removes it; 0 and 1 remove it at once
+	OnMissingGraceCycles int `yaml:"on_missing_grace_cycles"`
+	// SyncJitter is the upper bound of a random delay added to every sync interval, and
+	// StartupSplay that of the delay before the first cycle, so that many instances do not
+	// call the APIs at the same moment
+	SyncJitter   time.Duration `yaml:"sync_jitter"`
+	StartupSplay time.Duration `yaml:"startup_splay"`
+	// SourceFiles are CSV or JSON files, or globs of them, with serial numbers of devices
+	// that are in no MDM; they are read again every cycle
+	SourceFiles []string `yaml:"source_files"`
+	// StaticSerials are always part of the target list, whatever the sources report
+	StaticSerials []StaticSerial `yaml:"static_serials"`
+	// BlackoutWindows are periods, such as change freezes, during which cycles make no
+	// changes and only report the diff they would apply
+	BlackoutWindows BlackoutWindows `yaml:"blackout_windows"`
+	// Jobs are additional syncs to other target lists run by the same process
+	Jobs []JobConfig `yaml:"jobs"`
+
+	// raw is the config file as read, kept for Lint
+	raw []byte
+	// path is the config file read, empty if there was none
+	path string
+	// secretRefs are the secret references of the settings read from a secret backend, by
+	// setting, and resolver the backends they were read with
+	secretRefs map[string]string
+	resolver   *secrets.Resolver
+	// secretFiles are the files of the settings read from a *_file setting, by setting
+	secretFiles map[string]string
+}
+
+// StaticSerial is a serial number kept in the target list by static_serials.
+type StaticSerial struct {
+	SerialNumber string `yaml:"serial_number"`
+	Comment      string `yaml:"comment"`
+}
+
+// MetricsConfig holds settings for the Prometheus metrics endpoint, which is disabled if
+// ListenAddress is empty, and for sending the metrics to StatsD.
+type MetricsConfig struct {
+	ListenAddress string       `yaml:"listen_address"`
+	Path          string       `yaml:"path"`
+	StatsD        StatsDConfig `yaml:"statsd"`
+}
+
+// StatsDConfig holds settings for sending the metrics to a StatsD or DogStatsD agent, such as
+// the Datadog agent, as well as or instead of serving them to Prometheus.
+type StatsDConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Address is the host:port of the agent, or unix:///path of a DogStatsD socket
+	Address string `yaml:"address"`
+	// Prefix is prepended to the metric names, followed by a dot
+	Prefix string `yaml:"prefix"`
+	// Format is dogstatsd, which sends the labels as tags, or statsd, which appends their
+	// values to the metric names
+	Format string `yaml:"format"`
+	// Tags are sent with every metric in the dogstatsd format, e.g. env:prod
+	Tags          []string      `yaml:"tags"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+}
+
+func (m *MetricsConfig) Validate() error {
+	if m.StatsD.Enabled {
+		if err := m.StatsD.Validate(); err != nil {
+			return fmt.Errorf("statsd: %w", err)
+		}
+	}
+	if m.ListenAddress == "" {
+		return nil
+	}
+	if err := validateListenAddress(m.ListenAddress); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(m.Path, "/") {
+		return fmt.Errorf("path %q must start with /", m.Path)
+	}
+	return nil
+}
+
+func (s *StatsDConfig) Validate() error {
+	if path, ok := strings.CutPrefix(s.Address, "unix://"); ok {
+		if path == "" {
+			return fmt.Errorf("address %q has no socket path", s.Address)
+		}
+	} else if _, port, err := net.SplitHostPort(s.Address); err != nil || port == "" {
+		return fmt.Errorf("address %q must be host:port or unix:///path", s.Address)
+	}
+	if s.Format != "dogstatsd" && s.Format != "statsd" {
+		return fmt.Errorf("format must be one of: dogstatsd, statsd")
+	}
+	if s.Format == "statsd" && len(s.Tags) > 0 {
+		return fmt.Errorf("tags require format dogstatsd")
+	}
+	for _, tag := range s.Tags {
+		if tag == "" || strings.ContainsAny(tag, "|,#@\n") {
+			return fmt.Errorf("tag %q must not be empty or contain |, comma, # or @", tag)
+		}
+	}
+	if s.FlushInterval < 0 {
+		return fmt.Errorf("flush_interval cannot be negative")
+	}
+	return nil
+}
+
+// AdminConfig holds settings for the admin HTTP API, which is disabled if ListenAddress is
+// empty. If it equals metrics.listen_address, the metrics are served by the admin API.
+type AdminConfig struct {
+	ListenAddress string `yaml:"listen_address"`
+	// EventBufferSize is the number of recent events kept for /events
+	EventBufferSize int `yaml:"event_buffer_size"`
+	// Token is the bearer token required by the endpoints that change the running service
+	Token     string `yaml:"token"`
+	TokenFile string `yaml:"token_file"`
+}
+
+func (a *AdminConfig) Validate() error {
+	if a.ListenAddress == "" {
+		return nil
+	}
+	if err := validateListenAddress(a.ListenAddress); err != nil {
+		return err
+	}
+	if a.EventBufferSize < 0 {
+		return fmt.Errorf("event_buffer_size must not be negative")
+	}
+	if a.Token != "" && len(a.Token) < 16 {
+		return fmt.Errorf("token must be at least 16 characters")
+	}
+	return nil
+}
+
+// WebhookConfig holds settings for the inbound webhook that lets external systems request
+// an immediate sync. It is served by the admin API.
+type WebhookConfig struct {
+	Enabled bool           `yaml:"enabled"`
+	Tokens  []WebhookToken `yaml:"tokens"`
+}
+
+// WebhookToken is a bearer token accepted by the webhook. If Pipelines is set, the token
+// may only trigger syncs on behalf of the named pipelines.
+type WebhookToken struct {
+	Name      string   `yaml:"name"`
+	Token     string   `yaml:"token"`
+	TokenFile string   `yaml:"token_file"`
+	Pipelines []string `yaml:"pipelines"`
+}
+
+func (w *WebhookConfig) Validate() error {
+	if !w.Enabled {
+		return nil
+	}
+	if len(w.Tokens) == 0 {
+		return fmt.Errorf("at least one token is required")
+	}
+	names := make(map[string]struct{})
+	for _, token := range w.Tokens {
+		if token.Name == "" {
+			return fmt.Errorf("every token needs a name")
+		}
+		if _, dup := names[token.Name]; dup {
+			return fmt.Errorf("duplicate token name %q", token.Name)
+		}
+		names[token.Name] = struct{}{}
+		if len(token.Token) < 16 {
+			return fmt.Errorf("token %q must be at least 16 characters", token.Name)
+		}
+	}
+	return nil
+}
+
+// SandboxConfig holds settings for sandbox mode, in which the sync runs against fake Kandji
+// and Cloudflare APIs started in-process and seeded from fixture files.
+type SandboxConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Fixtures is a directory with kandji_devices.json and cloudflare_lists.json; missing
+	// files fall back to the built-in fixtures
+	Fixtures string `yaml:"fixtures"`
+}
+
+// Sandbox placeholder credentials, accepted by the fake APIs.
+const (
+	SandboxKandjiAPIURL   = "https://sandbox.api.kandji.io"
+	SandboxAPIToken       = "sandbox"
+	SandboxAccountID      = "sandbox"
+	SandboxTargetListName = "Kandji Devices"
+	// SandboxRulesTargetListName is the target list with list_kind rules
+	SandboxRulesTargetListName = "Kandji Device Hostnames"
+)
+
+// applySandbox fills in the placeholder credentials and target list that sandbox mode needs,
+// so that it runs without any real credentials.
+func (c *Config) applySandbox() {
+	c.Kandji.ApiURL = SandboxKandjiAPIURL
+	c.Kandji.ApiToken, c.Kandji.ApiTokenFile = SandboxAPIToken, ""
+	c.Kandji.ProxyURL = ""
+	c.Cloudflare.ApiToken, c.Cloudflare.ApiTokenFile = SandboxAPIToken, ""
+	c.Cloudflare.AccountID = SandboxAccountID
+	c.Cloudflare.ProxyURL = ""
+	if c.Cloudflare.ListID == "" && c.Cloudflare.TargetListName == "" && !c.Cloudflare.EmailOnly {
+		c.Cloudflare.TargetListName = SandboxTargetListName
+		if c.Cloudflare.ListKind == ListKindRules {
+			c.Cloudflare.TargetListName = SandboxRulesTargetListName
+		}
+	}
+	if c.ConfigVersion == 0 {
+		c.ConfigVersion = CurrentConfigVersion
+	}
+}
+
+// validateListenAddress checks that addr is a host:port or :port address to listen on.
+func validateListenAddress(addr string) error {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return fmt.Errorf("listen_address %q must be host:port or :port: %w", addr, err)
+	}
+	return nil
+}
+
+// SMTPConfig holds the SMTP server used to send email.
+type SMTPConfig struct {
+	Host         string `yaml:"host"`
+	Port         int    `yaml:"port"`
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+	From         string `yaml:"from"`
+	// Security is starttls, tls (implicit TLS, usually port 465) or none
+	Security string `yaml:"security"`
+}
+
+func (s *SMTPConfig) Validate() error {
+	if s.Host == "" {
+		return fmt.Errorf("host is required")
+	}
+	if s.From == "" {
+		return fmt.Errorf("from is required")
+	}
+	switch s.Security {
+	case "starttls", "tls", "none":
+	default:
+		return fmt.Errorf("security must be one of: starttls, tls, none")
+	}
+	return nil
+}
+
+// DigestConfig holds settings for the daily email digest of membership changes, which is
+// built from the state store.
+type DigestConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Time is the time of day the digest is sent, as HH:MM in TimeZone
+	Time       string   `yaml:"time"`
+	TimeZone   string   `yaml:"timezone"`
+	Recipients []string `yaml:"recipients"`
+	Subject    string   `yaml:"subject"`
+}
+
+// Validate checks the digest settings when the digest is enabled.
+func (d *DigestConfig) Validate() error {
+	if !d.Enabled {
+		return nil
+	}
+	if _, err := time.Parse("15:04", d.Time); err != nil {
+		return fmt.Errorf("time must be HH:MM, got %q", d.Time)
+	}
+	if _, err := time.LoadLocation(d.TimeZone); err != nil {
+		return fmt.Errorf("invalid timezone %q: %w", d.TimeZone, err)
+	}
+	if len(d.Recipients) == 0 {
+		return fmt.Errorf("recipients are required")
+	}
+	return nil
+}
+
+// UpdateCheckConfig holds settings for checking the GitHub releases feed for newer versions.
+type UpdateCheckConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// NetworkConfig holds settings for reaching the APIs in networks where public DNS is blocked.
+type NetworkConfig struct {
+	// DNSServers are queried instead of the system resolver, as host or host:port
+	DNSServers []string `yaml:"dns_servers"`
+	// Hosts maps host names such as api.cloudflare.com to fixed IP addresses
+	Hosts map[string]string `yaml:"hosts"`
+}
+
+func (n *NetworkConfig) Validate() error {
+	for _, server := range n.DNSServers {
+		host := server
+		if h, _, err := net.SplitHostPort(server); err == nil {
+			host = h
+		}
+		if net.ParseIP(host) == nil {
+			return fmt.Errorf("dns_servers entry %q must be an IP address, optionally with a port", server)
+		}
+	}
+	for host, ip := range n.Hosts {
+		if net.ParseIP(ip) == nil {
+			return fmt.Errorf("hosts entry %q must map to an IP address, got %q", host, ip)
+		}
+	}
+	return nil
+}
+
+// ClientConfig holds settings identifying this integration to the Kandji and Cloudflare APIs.
+type ClientConfig struct {
+	// InstanceID distinguishes deployments in API audit logs, the hostname by default
+	InstanceID      string `yaml:"instance_id"`
+	UserAgentSuffix string `yaml:"user_agent_suffix"`
+	// ConditionalRequests revalidates the Kandji and Cloudflare responses read before
+	// instead of downloading them again
+	ConditionalRequests ConditionalRequestsConfig `yaml:"conditional_requests"`
+}
+
+// ConditionalRequestsConfig holds settings for sending the GET requests of the Kandji and
+// Cloudflare clients with the ETag or Last-Modified of the previous response, which the APIs
+// answer with 304 Not Modified, and no body, if nothing changed.
+type ConditionalRequestsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// MaxEntries is how many responses each client keeps, the least recently used are dropped
+	MaxEntries int `yaml:"max_entries"`
+}
+
+func (c *ConditionalRequestsConfig) Validate() error {
+	if c.MaxEntries < 0 {
+		return fmt.Errorf("max_entries cannot be negative")
+	}
+	return nil
+}
+
+// StateConfig holds settings for the local state store that persists the sync history.
+// The store is disabled if Path is empty.
+type StateConfig struct {
+	Path      string        `yaml:"path"`
+	Retention time.Duration `yaml:"retention"`
+	// IdempotencyWindow is how long after it was applied a list mutation is skipped when the
+	// interrupted cycle whose diff it belongs to is resumed
+	IdempotencyWindow time.Duration `yaml:"idempotency_window"`
+}
+
+func (s *StateConfig) Validate() error {
+	if s.IdempotencyWindow < 0 || s.IdempotencyWindow > 24*time.Hour {
+		return fmt.Errorf("idempotency_window must be between 0 and 24h")
+	}
+	return nil
+}
+
+// WarmCacheConfig holds settings for the warm-start cache, which keeps the target list
+// contents and a hash of the Kandji inventory across restarts so the first cycle after a
+// restart does not have to download the target list again. It is disabled if Path is empty.
+type WarmCacheConfig struct {
+	Path string `yaml:"path"`
+	// MaxAge is how old a cache may be and still be used at startup
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+func (w *WarmCacheConfig) Validate() error {
+	if w.MaxAge < 0 {
+		return fmt.Errorf("max_age cannot be negative")
+	}
+	return nil
+}
+
+// AuditConfig holds settings for the audit log, an append-only JSON Lines file with an
+// entry for every item appended to or removed from a Cloudflare list. It is disabled if
+// Path is empty.
+type AuditConfig struct {
+	Path string `yaml:"path"`
+}
+
+// CatchUpConfig holds settings for catching up after downtime. At startup the cycles the
+// schedule recorded in the state store would have run while the service was down are
+// counted; if more than Threshold were missed, the first cycle is a full reconciliation.
+type CatchUpConfig struct {
+	Threshold int `yaml:"threshold"`
+}
+
+func (c *CatchUpConfig) Validate() error {
+	if c.Threshold < 0 {
+		return fmt.Errorf("threshold cannot be negative")
+	}
+	return nil
+}
+
+// ReloadConfig holds settings for reloading the configuration while the service runs. It is
+// always reloaded on SIGHUP; with WatchFile it is also reloaded when the file changes, as
+// checked every PollInterval.
+type ReloadConfig struct {
+	WatchFile    bool          `yaml:"watch_file"`
+	PollInterval time.Duration `yaml:"poll_interval"`
+}
+
+func (r *ReloadConfig) Validate() error {
+	if r.WatchFile && r.PollInterval < time.Second {
+		return fmt.Errorf("poll_interval must be at least 1s")
+	}
+	return nil
+}
+
+// ShutdownConfig holds settings for stopping the service. On SIGTERM or SIGINT no new cycles
+// are started, and a cycle in progress is given DrainTimeout to finish before it is
+// cancelled.
+type ShutdownConfig struct {
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+}
+
+func (s *ShutdownConfig) Validate() error {
+	if s.DrainTimeout < 0 {
+		return fmt.Errorf("drain_timeout cannot be negative")
+	}
+	return nil
+}
+
+// SystemdConfig holds settings for running as a systemd Type=notify service. The service
+// notifies systemd whenever NOTIFY_SOCKET is set; with WatchdogSec it sends watchdog
+// heartbeats while no sync has gone WatchdogMaxAge without a successful cycle.
+type SystemdConfig struct {
+	// WatchdogMaxAge defaults to three sync intervals of each sync
+	WatchdogMaxAge time.Duration `yaml:"watchdog_max_age"`
+}
+
+func (s *SystemdConfig) Validate() error {
+	if s.WatchdogMaxAge < 0 {
+		return fmt.Errorf("watchdog_max_age cannot be negative")
+	}
+	return nil
+}
+
+// MaxRemovalsConfig is a safety threshold for removals from each list. If the
+// removals a cycle computed exceed Count entries or Percent of the list, for example
+// because Kandji returned an empty fleet during an outage, none of them are applied and
+// an alert is raised. Zero disables a limit.
+type MaxRemovalsConfig struct {
+	Count   int     `yaml:"count"`
+	Percent float64 `yaml:"percent"`
+}
+
+func (c *MaxRemovalsConfig) Validate() error {
+	if c.Count < 0 {
+		return fmt.Errorf("count cannot be negative")
+	}
+	if c.Percent < 0 || c.Percent > 100 {
+		return fmt.Errorf("percent must be between 0 and 100")
+	}
+	return nil
+}
+
+// Enabled reports whether any limit is set.
+func (c *MaxRemovalsConfig) Enabled() bool {
+	return c.Count > 0 || c.Percent > 0
+}
+
+// Exceeded reports whether removing n of the listSize entries of a list exceeds a limit,
+// and if so which one.
+func (c *MaxRemovalsConfig) Exceeded(n, listSize int) (string, bool) {
+	if c.Count > 0 && n > c.Count {
+		return fmt.Sprintf("count %d", c.Count), true
+	}
+	if c.Percent > 0 && listSize > 0 && float64(n)*100 > c.Percent*float64(listSize) {
+		return fmt.Sprintf("percent %g", c.Percent), true
+	}
+	return "", false
+}
+
+// CircuitBreakerConfig holds settings for the circuit breakers around the Kandji and
+// Cloudflare APIs. After FailureThreshold consecutive cycles failed because of one API, cycles
+// are skipped for Cooldown instead of calling the failing API every interval.
+type CircuitBreakerConfig struct {
+	Enabled          bool          `yaml:"enabled"`
+	FailureThreshold int           `yaml:"failure_threshold"`
+	Cooldown         time.Duration `yaml:"cooldown"`
+}
+
+func (c *CircuitBreakerConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.FailureThreshold < 1 {
+		return fmt.Errorf("failure_threshold must be at least 1")
+	}
+	if c.Cooldown <= 0 {
+		return fmt.Errorf("cooldown must be positive")
+	}
+	return nil
+}
+
+// LeaderElectionConfig holds settings for electing one of several replicas of the service as
+// the leader, the only one running sync cycles. The leader holds a lease in the backend and
+// renews it every RenewInterval; another replica takes over once it expires.
+type LeaderElectionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Backend is kubernetes, for a Lease object, or redis, for a key
+	Backend string `yaml:"backend"`
+	// Identity names this replica in the lease, the hostname (the pod name on Kubernetes)
+	// with a random suffix by default
+	Identity      string                    `yaml:"identity"`
+	LeaseDuration time.Duration             `yaml:"lease_duration"`
+	RenewInterval time.Duration             `yaml:"renew_interval"`
+	Kubernetes    KubernetesLeaseConfig     `yaml:"kubernetes"`
+	Redis         RedisLeaderElectionConfig `yaml:"redis"`
+}
+
+// KubernetesLeaseConfig holds the Lease used for leader election on Kubernetes.
+type KubernetesLeaseConfig struct {
+	// Namespace defaults to the namespace of the pod
+	Namespace string `yaml:"namespace"`
+	LeaseName string `yaml:"lease_name"`
+}
+
+// RedisLeaderElectionConfig holds the Redis server and key used for leader election.
+type RedisLeaderElectionConfig struct {
+	// URL is redis://host:port/db, or rediss://host:port/db to require TLS
+	URL          string `yaml:"url"`
+	Key          string `yaml:"key"`
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+}
+
+func (l *LeaderElectionConfig) Validate() error {
+	if !l.Enabled {
+		return nil
+	}
+	switch l.Backend {
+	case "kubernetes":
+		if l.Kubernetes.LeaseName == "" {
+			return fmt.Errorf("kubernetes.lease_name is required")
+		}
+	case "redis":
+		u, err := url.Parse(l.Redis.URL)
+		if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+			return fmt.Errorf("redis.url must be a redis:// or rediss:// URL, got %q", l.Redis.URL)
+		}
+		if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+			if n, err := strconv.Atoi(db); err != nil || n < 0 {
+				return fmt.Errorf("redis.url must end in a database number, got %q", u.Path)
+			}
+		}
+		if l.Redis.Key == "" {
+			return fmt.Errorf("redis.key is required")
+		}
+	default:
+		return fmt.Errorf("backend must be one of: kubernetes, redis")
+	}
+	if l.RenewInterval <= 0 {
+		return fmt.Errorf("renew_interval must be positive")
+	}
+	if l.LeaseDuration < 2*l.RenewInterval {
+		return fmt.Errorf("lease_duration must be at least twice renew_interval, so a renewal can fail without losing the lease")
+	}
+	if l.Backend == "kubernetes" && l.LeaseDuration < time.Second {
+		return fmt.Errorf("lease_duration must be at least 1s with the kubernetes backend")
+	}
+	return nil
+}
+
+// NotificationsConfig holds settings for the notification backends that sync cycle summaries
+// and on_missing alerts are sent to. Alerts are always written to the log as well.
+type NotificationsConfig struct {
+	Slack     SlackConfig           `yaml:"slack"`
+	Email     EmailConfig           `yaml:"email"`
+	PagerDuty PagerDutyConfig       `yaml:"pagerduty"`
+	Webhook   OutboundWebhookConfig `yaml:"webhook"`
+}
+
+func (n *NotificationsConfig) Validate() error {
+	if err := n.Slack.Validate(); err != nil {
+		return fmt.Errorf("slack: %w", err)
+	}
+	if err := n.Email.Validate(); err != nil {
+		return fmt.Errorf("email: %w", err)
+	}
+	if err := n.PagerDuty.Validate(); err != nil {
+		return fmt.Errorf("pagerduty: %w", err)
+	}
+	if err := n.Webhook.Validate(); err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	return nil
+}
+
+// SlackConfig holds settings for posting to a Slack incoming webhook.
+type SlackConfig struct {
+	Enabled        bool   `yaml:"enabled"`
+	WebhookURL     string `yaml:"webhook_url"`
+	WebhookURLFile string `yaml:"webhook_url_file"`
+	// Summaries is which sync cycles are summarized: always, changes (cycles that changed the
+	// target list or failed) or never
+	Summaries string `yaml:"summaries"`
+}
+
+func (s *SlackConfig) Validate() error {
+	if !s.Enabled {
+		return nil
+	}
+	if s.WebhookURL == "" {
+		return fmt.Errorf("SLACK_WEBHOOK_URL is required")
+	}
+	if u, err := url.Parse(s.WebhookURL); err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("webhook_url must be an https URL")
+	}
+	switch s.Summaries {
+	case "always", "changes", "never":
+	default:
+		return fmt.Errorf("summaries must be one of: always, changes, never")
+	}
+	return nil
 }
 
+// EmailConfig holds settings for emailing alerts through the smtp server: when a cycle fails,
+// when a cycle removes more than DeletionThreshold serials, and on on_missing alerts.
+type EmailConfig struct {
+	Enabled       bool     `yaml:"enabled"`
+	Recipients    []string `yaml:"recipients"`
+	SubjectPrefix string   `yaml:"subject_prefix"`
+	// DeletionThreshold is the number of removals in a cycle above which an alert is sent,
+	// 0 to disable
+	DeletionThreshold int `yaml:"deletion_threshold"`
+}
+
+func (e *EmailConfig) Validate() error {
+	if !e.Enabled {
+		return nil
+	}
+	if len(e.Recipients) == 0 {
+		return fmt.Errorf("recipients are required")
+	}
+	if e.DeletionThreshold < 0 {
+		return fmt.Errorf("deletion_threshold cannot be negative")
+	}
+	return nil
+}
+
+// PagerDutyConfig holds settings for raising PagerDuty incidents through the Events API v2:
+// when FailureThreshold consecutive cycles failed, and when a cycle cannot read the target
+// list. The incidents are resolved by the next successful cycle.
+type PagerDutyConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// RoutingKey is the integration key of the PagerDuty service
+	RoutingKey       string `yaml:"routing_key"`
+	RoutingKeyFile   string `yaml:"routing_key_file"`
+	FailureThreshold int    `yaml:"failure_threshold"`
+	// Severity is critical, error, warning or info
+	Severity string `yaml:"severity"`
+}
+
+func (p *PagerDutyConfig) Validate() error {
+	if !p.Enabled {
+		return nil
+	}
+	if p.RoutingKey == "" {
+		return fmt.Errorf("PAGERDUTY_ROUTING_KEY is required")
+	}
+	if p.FailureThreshold < 1 {
+		return fmt.Errorf("failure_threshold must be at least 1")
+	}
+	switch p.Severity {
+	case "critical", "error", "warning", "info":
+	default:
+		return fmt.Errorf("severity must be one of: critical, error, warning, info")
+	}
+	return nil
+}
+
+// NotificationEventTypes are the event types an outbound webhook can subscribe to.
+var NotificationEventTypes = []string{"cycle_started", "cycle_completed", "cycle_failed", "list_unavailable", "missing_devices", "circuit_opened", "circuit_closed", "removals_blocked"}
+
+// OutboundWebhookConfig holds settings for POSTing every sync event as JSON to a URL. If
+// Secret is set, the requests are signed with HMAC-SHA256.
+type OutboundWebhookConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	URL        string `yaml:"url"`
+	Secret     string `yaml:"secret"`
+	SecretFile string `yaml:"secret_file"`
+	// Events limits the events sent to the given types, all of NotificationEventTypes if empty
+	Events []string `yaml:"events"`
+}
+
+func (w *OutboundWebhookConfig) Validate() error {
+	if !w.Enabled {
+		return nil
+	}
+	if u, err := url.Parse(w.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("url must be an http or https URL")
+	}
+	for _, event := range w.Events {
+		if !slices.Contains(NotificationEventTypes, event) {
+			return fmt.Errorf("unknown event %q, must be one of: %s", event, strings.Join(NotificationEventTypes, ", "))
+		}
+	}
+	return nil
+}
+
+// ExpiryConfig holds settings for time-limited list entries. When enabled, entries whose
+// comment carries an expiry stamp that has passed are removed every cycle, and Kandji devices
+// with one of Tags are added with an expiry of their enrollment date plus TTL.
+type ExpiryConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	TTL     time.Duration `yaml:"ttl"`
+	Tags    []string      `yaml:"tags"`
+}
+
+// blueprintGlobPrefix marks a blueprint name filter entry as a glob.
+const blueprintGlobPrefix = "glob:"
+
 type BlueprintFilter struct {
-	BlueprintIDs   []string `yaml:"blueprint_ids"`
+	BlueprintIDs []string `yaml:"blueprint_ids"`
+	// BlueprintNames match blueprint names exactly, or as globs such as "Prod-*" if they
+	// are prefixed with "glob:"
 	BlueprintNames []string `yaml:"blueprint_names"`
 }
 
+// Validate checks that the blueprint name globs are well-formed.
+func (b *BlueprintFilter) Validate() error {
+	for _, name := range b.BlueprintNames {
+		pattern, ok := strings.CutPrefix(name, blueprintGlobPrefix)
+		if !ok {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid blueprint name pattern %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// MatchName returns the entry of BlueprintNames that the blueprint name matches, and whether
+// there is one. Entries prefixed with "glob:" are matched with path.Match, so * and ? do not
+// match a "/"; all others only match a name equal to them.
+func (b *BlueprintFilter) MatchName(name string) (string, bool) {
+	for _, entry := range b.BlueprintNames {
+		pattern, ok := strings.CutPrefix(entry, blueprintGlobPrefix)
+		if !ok {
+			if entry == name {
+				return entry, true
+			}
+			continue
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return entry, true
+		}
+	}
+	return "", false
+}
+
+// PlatformFilter selects devices by their Kandji platform. Without Include every platform
+// that is not excluded is synced.
+type PlatformFilter struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+// mobilePlatforms are the platforms sync_mobile_devices refers to.
+var mobilePlatforms = []string{"iPhone", "iPad"}
+
 type LoggingConfig struct {
 	Level string `yaml:"level"`
 }
@@ -33,19 +825,544 @@ type LoggingConfig struct {
 type KandjiConfig struct {
 	ApiURL                   string          `yaml:"api_url"`
 	ApiToken                 string          `yaml:"api_token"`
+	ApiTokenFile             string          `yaml:"api_token_file"`
 	SyncDevicesWithoutOwners bool            `yaml:"sync_devices_without_owners"`
 	SyncMobileDevices        bool            `yaml:"sync_mobile_devices"`
 	IncludeTags              []string        `yaml:"include_tags"`
 	ExcludeTags              []string        `yaml:"exclude_tags"`
 	BlueprintsInclude        BlueprintFilter `yaml:"blueprints_include"`
 	BlueprintsExclude        BlueprintFilter `yaml:"blueprints_exclude"`
+	// Platforms selects the platforms synced. It replaces the deprecated SyncMobileDevices,
+	// which only applies if Platforms is nil
+	Platforms *PlatformFilter `yaml:"platforms"`
+	// ModelsInclude and ModelsExclude filter devices by globs matched against their Kandji
+	// model, e.g. "MacBook*" or "Mac mini*"
+	ModelsInclude []string `yaml:"models_include"`
+	ModelsExclude []string `yaml:"models_exclude"`
+	// MinOSVersion maps Kandji platforms (Mac, iPhone, iPad, ...) to the lowest OS version a
+	// device of the platform must run to be synced
+	MinOSVersion map[string]string `yaml:"min_os_version"`
+	// MaxLastCheckinAge skips devices that have not checked in to Kandji within this
+	// duration; zero disables the check
+	MaxLastCheckinAge time.Duration `yaml:"max_last_checkin_age"`
+	// AssetTagsInclude and AssetTagsExclude filter devices by their Kandji asset tag, with
+	// the patterns of IncludeTags; RequireAssetTag skips devices without one
+	AssetTagsInclude []string `yaml:"asset_tags_include"`
+	AssetTagsExclude []string `yaml:"asset_tags_exclude"`
+	RequireAssetTag  bool     `yaml:"require_asset_tag"`
+	// ProxyURL routes Kandji requests through an HTTP(S) proxy, overriding the proxy
+	// environment variables
+	ProxyURL string `yaml:"proxy_url"`
+	// Tenants are additional Kandji tenants whose devices are merged with those of the
+	// tenant above before filtering
+	Tenants []KandjiTenant `yaml:"tenants"`
+	// Pagination sets how the device inventory is paged through
+	Pagination KandjiPaginationConfig `yaml:"pagination"`
+	// Incremental downloads only the devices that checked in since the last cycle
+	Incremental KandjiIncrementalConfig `yaml:"incremental"`
+}
+
+// KandjiIncrementalConfig holds settings for downloading only the devices that checked in
+// since the last download, ordered by their last check-in, and merging them into the
+// inventory downloaded before. The whole inventory is downloaded again every
+// FullResyncInterval to pick up deleted devices and changes to devices that did not check in.
+type KandjiIncrementalConfig struct {
+	Enabled            bool          `yaml:"enabled"`
+	FullResyncInterval time.Duration `yaml:"full_resync_interval"`
+}
+
+// Validate checks the full resync interval when incremental downloads are enabled.
+func (i *KandjiIncrementalConfig) Validate() error {
+	if i.Enabled && i.FullResyncInterval <= 0 {
+		return fmt.Errorf("full_resync_interval must be positive")
+	}
+	return nil
+}
+
+// KandjiPaginationConfig holds settings for downloading the Kandji device inventory in
+// pages. With a Concurrency above 1, up to that many pages are fetched at once, each still
+// waiting for the Kandji rate limiter.
+type KandjiPaginationConfig struct {
+	// PageSize is the number of devices requested per page, at most 300, whether the pages
+	// are downloaded one after another or several at once
+	PageSize    int `yaml:"page_size"`
+	Concurrency int `yaml:"concurrency"`
+}
+
+// Validate checks the page size and concurrency.
+func (p *KandjiPaginationConfig) Validate() error {
+	if p.PageSize < 1 || p.PageSize > 300 {
+		return fmt.Errorf("page_size must be between 1 and 300")
+	}
+	if p.Concurrency < 1 || p.Concurrency > 16 {
+		return fmt.Errorf("concurrency must be between 1 and 16")
+	}
+	return nil
+}
+
+// KandjiTenant is an additional Kandji tenant, e.g. of a subsidiary. Its API token can be
+// set with the KANDJI_API_TOKEN_<NAME> environment variable, see TokenEnv.
+type KandjiTenant struct {
+	Name         string `yaml:"name"`
+	ApiURL       string `yaml:"api_url"`
+	ApiToken     string `yaml:"api_token"`
+	ApiTokenFile string `yaml:"api_token_file"`
+	// ProxyURL overrides the proxy_url of the kandji section if set
+	ProxyURL string `yaml:"proxy_url"`
+}
+
+// TokenEnv returns the environment variable that sets the tenant's API token, e.g.
+// KANDJI_API_TOKEN_EMEA for the tenant emea.
+func (t KandjiTenant) TokenEnv() string {
+	return "KANDJI_API_TOKEN_" + strings.ToUpper(strings.ReplaceAll(t.Name, "-", "_"))
+}
+
+// ForTenant returns the settings of the kandji section with the API URL, token and proxy of
+// a tenant, to create the tenant's client.
+func (k KandjiConfig) ForTenant(t KandjiTenant) KandjiConfig {
+	k.ApiURL, k.ApiToken = t.ApiURL, t.ApiToken
+	if t.ProxyURL != "" {
+		k.ProxyURL = t.ProxyURL
+	}
+	k.Tenants = nil
+	return k
+}
+
+func (t *KandjiTenant) Validate() error {
+	if !jobNamePattern.MatchString(t.Name) {
+		return fmt.Errorf("name %q must be lowercase letters, digits, - and _", t.Name)
+	}
+	if _, err := NormalizeKandjiAPIURL(t.ApiURL); err != nil {
+		return err
+	}
+	if t.ApiToken == "" {
+		return fmt.Errorf("api_token or %s is required", t.TokenEnv())
+	}
+	if err := validateProxyURL(t.ProxyURL); err != nil {
+		return fmt.Errorf("proxy_url: %w", err)
+	}
+	return nil
+}
+
+// IntuneConfig holds settings for Microsoft Intune as a second device source: the serials
+// of its managed devices are merged into the target list alongside the Kandji devices. The
+// app registration needs the DeviceManagementManagedDevices.Read.All application permission.
+type IntuneConfig struct {
+	Enabled          bool   `yaml:"enabled"`
+	TenantID         string `yaml:"tenant_id"`
+	ClientID         string `yaml:"client_id"`
+	ClientSecret     string `yaml:"client_secret"`
+	ClientSecretFile string `yaml:"client_secret_file"`
+	// OperatingSystems are the Intune operating systems synced, e.g. Windows (the default)
+	OperatingSystems []string `yaml:"operating_systems"`
+	// LoginURL and GraphURL select the Microsoft cloud, e.g. https://login.microsoftonline.us
+	// and https://graph.microsoft.us for GCC High
+	LoginURL string `yaml:"login_url"`
+	GraphURL string `yaml:"graph_url"`
+	// ProxyURL routes Intune requests through an HTTP(S) proxy, overriding the proxy
+	// environment variables
+	ProxyURL string `yaml:"proxy_url"`
+}
+
+func (i *IntuneConfig) Validate() error {
+	if !i.Enabled {
+		return nil
+	}
+	if i.TenantID == "" || i.ClientID == "" {
+		return fmt.Errorf("tenant_id and client_id are required")
+	}
+	if i.ClientSecret == "" {
+		return fmt.Errorf("INTUNE_CLIENT_SECRET is required")
+	}
+	if len(i.OperatingSystems) == 0 {
+		return fmt.Errorf("operating_systems cannot be empty")
+	}
+	for _, endpoint := range []struct{ name, value string }{{"login_url", i.LoginURL}, {"graph_url", i.GraphURL}} {
+		u, err := url.Parse(endpoint.value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s must be an http:// or https:// URL with a host", endpoint.name)
+		}
+	}
+	if err := validateProxyURL(i.ProxyURL); err != nil {
+		return fmt.Errorf("invalid proxy_url: %w", err)
+	}
+	return nil
+}
+
+// MosyleConfig holds settings for Mosyle Business as a device source. Its devices go through
+// the kandji filters along with the Kandji devices, or on their own if kandji.api_url and
+// kandji.api_token are both empty.
+type MosyleConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	APIURL  string `yaml:"api_url"`
+	// AccessToken is the API access token of the account; Email and Password are those of
+	// the API user
+	AccessToken     string `yaml:"access_token"`
+	AccessTokenFile string `yaml:"access_token_file"`
+	Email           string `yaml:"email"`
+	Password        string `yaml:"password"`
+	PasswordFile    string `yaml:"password_file"`
+	// OperatingSystems are the Mosyle operating systems listed: mac, ios, tvos, visionos
+	OperatingSystems  []string `yaml:"operating_systems"`
+	PageSize          int      `yaml:"page_size"`
+	RequestsPerSecond float64  `yaml:"requests_per_second"`
+	// ProxyURL routes Mosyle requests through an HTTP(S) proxy, overriding the proxy
+	// environment variables
+	ProxyURL string `yaml:"proxy_url"`
+}
+
+// mosyleOperatingSystems are the operating systems of the Mosyle listdevices endpoint.
+var mosyleOperatingSystems = []string{"mac", "ios", "tvos", "visionos"}
+
+func (m *MosyleConfig) Validate() error {
+	if !m.Enabled {
+		return nil
+	}
+	u, err := url.Parse(m.APIURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("api_url must be an http:// or https:// URL with a host")
+	}
+	if m.AccessToken == "" {
+		return fmt.Errorf("MOSYLE_ACCESS_TOKEN is required")
+	}
+	if m.Email == "" || m.Password == "" {
+		return fmt.Errorf("email and MOSYLE_PASSWORD are required")
+	}
+	if len(m.OperatingSystems) == 0 {
+		return fmt.Errorf("operating_systems cannot be empty")
+	}
+	for _, os := range m.OperatingSystems {
+		if !slices.Contains(mosyleOperatingSystems, os) {
+			return fmt.Errorf("unknown operating system %q, must be one of: %s", os, strings.Join(mosyleOperatingSystems, ", "))
+		}
+	}
+	if m.PageSize < 1 || m.PageSize > 50000 {
+		return fmt.Errorf("page_size must be between 1 and 50000")
+	}
+	if m.RequestsPerSecond <= 0 {
+		return fmt.Errorf("requests_per_second must be positive")
+	}
+	if err := validateProxyURL(m.ProxyURL); err != nil {
+		return fmt.Errorf("invalid proxy_url: %w", err)
+	}
+	return nil
+}
+
+// KandjiEnabled reports whether devices are read from Kandji. Kandji can only be left out,
+// by leaving its API URL and token empty, if Mosyle is the device source instead.
+func (c *Config) KandjiEnabled() bool {
+	return !c.Mosyle.Enabled || c.Kandji.ApiURL != "" || c.Kandji.ApiToken != ""
+}
+
+type CloudflareConfig struct {
+	ApiToken     string `yaml:"api_token"`
+	ApiTokenFile string `yaml:"api_token_file"`
+	AccountID    string `yaml:"account_id"`
+	// ProxyURL routes Cloudflare requests through an HTTP(S) proxy, overriding the proxy
+	// environment variables
+	ProxyURL string `yaml:"proxy_url"`
+	// ListKind selects the kind of list synced: "gateway" for Zero Trust Gateway lists or
+	// "rules" for account-level Rules Lists. It applies to every list the sync uses.
+	ListKind string `yaml:"list_kind"`
+	ListID   string `yaml:"target_list_id"`
+	// TargetListName selects the target list by name instead of ID
+	TargetListName string `yaml:"target_list_name"`
+	// CreateListIfMissing creates the target list at startup if it does not exist, named
+	// TargetListName or, for a list configured by ID, NewList.Name
+	CreateListIfMissing bool          `yaml:"create_list_if_missing"`
+	NewList             NewListConfig `yaml:"new_list"`
+	SourceListIDs       []string      `yaml:"source_list_ids"`
+	// SourceLists holds source lists referenced by ID or by name
+	SourceLists []string `yaml:"source_lists"`
+	// SourceListPatterns selects every SERIAL list whose name matches one of the globs
+	SourceListPatterns []string `yaml:"source_list_patterns"`
+	EmailListID        string   `yaml:"email_list_id"`
+	// EmailOnly maintains only the owner email list, with the owners of every device that
+	// passes the filters, instead of a serial number target list
+	EmailOnly     bool   `yaml:"email_only"`
+	ManagedMarker string `yaml:"managed_marker"`
+	// ConflictResolution decides the comment of a serial contributed by several sources
+	// with differing comments: kandji_first, sources_first, merge or skip
+	ConflictResolution string        `yaml:"conflict_resolution"`
+	Comment            CommentConfig `yaml:"comment"`
+	// PlatformRouting sends the Kandji devices of a platform (Mac, iPhone, iPad, ...) to the
+	// SERIAL list with the given ID or name instead of the target list
+	PlatformRouting map[string]string `yaml:"platform_routing"`
+	// BlueprintRouting sends the Kandji devices of a blueprint, by ID or name, to the SERIAL
+	// list with the given ID or name instead of the target list. It takes precedence over
+	// PlatformRouting.
+	BlueprintRouting map[string]string `yaml:"blueprint_routing"`
+	TagListMapping   TagListMapping    `yaml:"tag_list_mapping"`
+	Retry            RetryConfig       `yaml:"retry"`
+}
+
+// NewListConfig describes the target list created by create_list_if_missing.
+type NewListConfig struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+}
+
+// Kinds of list selected by CloudflareConfig.ListKind.
+const (
+	ListKindGateway = "gateway"
+	ListKindRules   = "rules"
+)
+
+// DeviceListType returns the type of the lists that hold device serial numbers: SERIAL
+// Gateway lists, or hostname Rules Lists, which have no serial number kind.
+func (c *CloudflareConfig) DeviceListType() string {
+	if c.ListKind == ListKindRules {
+		return "HOSTNAME"
+	}
+	return "SERIAL"
+}
+
+// TagListMapping adds the devices synced to the target list to further SERIAL lists by
+// Kandji tag. A device is added to the list of every mapped tag it has, and to DefaultList if
+// it has none. Lists are referenced by ID or name.
+type TagListMapping struct {
+	Tags        map[string]string `yaml:"tags"`
+	DefaultList string            `yaml:"default_list"`
+}
+
+// Refs returns the distinct lists of the mapping, sorted.
+func (t *TagListMapping) Refs() []string {
+	var refs []string
+	for _, ref := range t.Tags {
+		if !slices.Contains(refs, ref) {
+			refs = append(refs, ref)
+		}
+	}
+	if t.DefaultList != "" && !slices.Contains(refs, t.DefaultList) {
+		refs = append(refs, t.DefaultList)
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+// RetryConfig holds settings for retrying API requests that failed with a network error,
+// HTTP 429 or a 5xx status. The delay before each retry doubles from InitialBackoff up to
+// MaxBackoff, with random jitter.
+type RetryConfig struct {
+	// MaxAttempts is the number of attempts per request including the first, 1 disables retries
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	// MaxRetryAfter is the longest a request waits in total for the Retry-After delays of
+	// HTTP 429 responses, which do not count as attempts
+	MaxRetryAfter time.Duration `yaml:"max_retry_after"`
+}
+
+func (r *RetryConfig) Validate() error {
+	if r.MaxAttempts < 1 {
+		return fmt.Errorf("max_attempts must be at least 1")
+	}
+	if r.InitialBackoff <= 0 {
+		return fmt.Errorf("initial_backoff must be positive")
+	}
+	if r.MaxBackoff < r.InitialBackoff {
+		return fmt.Errorf("max_backoff must not be less than initial_backoff")
+	}
+	if r.MaxRetryAfter < 0 {
+		return fmt.Errorf("max_retry_after cannot be negative")
+	}
+	return nil
+}
+
+// CommentConfig controls how the comment of list entries created for Kandji devices is
+// composed from device attributes.
+type CommentConfig struct {
+	// Fields are joined in order: name, owner, blueprint, model, last_check_in
+	Fields    []string `yaml:"fields"`
+	Separator string   `yaml:"separator"`
+	// MaxLength is the maximum comment length, including the managed marker and expiry stamp
+	MaxLength int `yaml:"max_length"`
+}
+
+// minCommentLength leaves room for the managed marker and an expiry stamp.
+const minCommentLength = 64
+
+// stampLength is the length of an expiry stamp the syncer appends to a comment, with the
+// separating space and the suffix of a truncated comment.
+const stampLength = len(" expires=2006-01-02T15:04:05Z...")
+
+func (c *CommentConfig) Validate() error {
+	valid := map[string]bool{"name": true, "owner": true, "blueprint": true, "model": true, "last_check_in": true}
+	seen := make(map[string]bool)
+	for _, field := range c.Fields {
+		if !valid[field] {
+			return fmt.Errorf("unknown field %q (valid fields: name, owner, blueprint, model, last_check_in)", field)
+		}
+		if seen[field] {
+			return fmt.Errorf("field %q is listed more than once", field)
+		}
+		seen[field] = true
+	}
+	if c.MaxLength != 0 && c.MaxLength < minCommentLength {
+		return fmt.Errorf("max_length must be at least %d", minCommentLength)
+	}
+	return nil
+}
+
+// DestinationsConfig holds settings for the optional destinations that receive
+// the synced device set in addition to the Cloudflare target list.
+type DestinationsConfig struct {
+	Tailscale      TailscaleConfig      `yaml:"tailscale"`
+	GoogleSheets   GoogleSheetsConfig   `yaml:"google_sheets"`
+	S3             S3Config             `yaml:"s3"`
+	KandjiFeedback KandjiFeedbackConfig `yaml:"kandji_feedback"`
+	IPList         IPListConfig         `yaml:"ip_list"`
+	BlueprintLists BlueprintListsConfig `yaml:"blueprint_lists"`
+	DeviceEvents   DeviceEventsConfig   `yaml:"device_events"`
+	CSVDiff        CSVDiffConfig        `yaml:"csv_diff"`
+}
+
+// TailscaleConfig holds settings for tagging and approving Tailscale devices
+// that match the synced device set.
+type TailscaleConfig struct {
+	Enabled       bool     `yaml:"enabled"`
+	ApiToken      string   `yaml:"api_token"`
+	ApiTokenFile  string   `yaml:"api_token_file"`
+	Tailnet       string   `yaml:"tailnet"`
+	Tags          []string `yaml:"tags"`
+	Authorize     bool     `yaml:"authorize"`
+	MatchHostname bool     `yaml:"match_hostname"`
+}
+
+// GoogleSheetsConfig holds settings for mirroring the synced device set into a Google Sheet.
+type GoogleSheetsConfig struct {
+	Enabled         bool   `yaml:"enabled"`
+	SpreadsheetID   string `yaml:"spreadsheet_id"`
+	SheetName       string `yaml:"sheet_name"`
+	CredentialsFile string `yaml:"credentials_file"`
+}
+
+// S3Config holds settings for writing objects to an S3 bucket or an S3-compatible
+// store such as GCS (with HMAC keys) or MinIO.
+type S3Config struct {
+	Enabled             bool   `yaml:"enabled"`
+	Bucket              string `yaml:"bucket"`
+	Prefix              string `yaml:"prefix"`
+	Region              string `yaml:"region"`
+	Endpoint            string `yaml:"endpoint"`
+	PathStyle           bool   `yaml:"path_style"`
+	AccessKeyID         string `yaml:"access_key_id"`
+	SecretAccessKey     string `yaml:"secret_access_key"`
+	SecretAccessKeyFile string `yaml:"secret_access_key_file"`
+}
+
+// KandjiFeedbackConfig holds settings for writing the Cloudflare sync status back to
+// Kandji devices as a tag or a note.
+type KandjiFeedbackConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	Mode      string `yaml:"mode"`
+	SyncedTag string `yaml:"synced_tag"`
+	ErrorTag  string `yaml:"error_tag"`
+}
+
+// IPListConfig holds settings for maintaining a Cloudflare IP list from the public IPs
+// reported by Kandji devices.
+type IPListConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	ListID  string        `yaml:"list_id"`
+	TTL     time.Duration `yaml:"ttl"`
+}
+
+// BlueprintListsConfig holds settings for maintaining one Cloudflare SERIAL list per
+// Kandji blueprint.
+type BlueprintListsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// NameTemplate is the list name, with {blueprint} replaced by the blueprint name
+	NameTemplate string `yaml:"name_template"`
+}
+
+func (b *BlueprintListsConfig) Validate() error {
+	if !b.Enabled {
+		return nil
+	}
+	if !strings.Contains(b.NameTemplate, "{blueprint}") {
+		return fmt.Errorf("name_template must contain {blueprint}")
+	}
+	return nil
+}
+
+// DeviceEventsConfig holds settings for publishing device_added and device_removed events
+// to a NATS subject or, through a Kafka REST Proxy, to a Kafka topic.
+type DeviceEventsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Backend is "nats" or "kafka"
+	Backend string      `yaml:"backend"`
+	NATS    NATSConfig  `yaml:"nats"`
+	Kafka   KafkaConfig `yaml:"kafka"`
+}
+
+// NATSConfig holds the NATS server and subject device events are published to.
+type NATSConfig struct {
+	// URL is nats://host:port, or tls://host:port to require TLS
+	URL          string `yaml:"url"`
+	Subject      string `yaml:"subject"`
+	Token        string `yaml:"token"`
+	TokenFile    string `yaml:"token_file"`
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+}
+
+// KafkaConfig holds the Kafka REST Proxy (v2 API) and topic device events are published to.
+type KafkaConfig struct {
+	RestProxyURL string `yaml:"rest_proxy_url"`
+	Topic        string `yaml:"topic"`
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+}
+
+func (d *DeviceEventsConfig) Validate() error {
+	if !d.Enabled {
+		return nil
+	}
+	switch d.Backend {
+	case "nats":
+		u, err := url.Parse(d.NATS.URL)
+		if err != nil || (u.Scheme != "nats" && u.Scheme != "tls") || u.Host == "" {
+			return fmt.Errorf("nats.url must be a nats:// or tls:// URL, got %q", d.NATS.URL)
+		}
+		if d.NATS.Subject == "" || strings.ContainsAny(d.NATS.Subject, " \t\r\n*>") {
+			return fmt.Errorf("nats.subject must be set and must not contain wildcards or whitespace")
+		}
+	case "kafka":
+		u, err := url.Parse(d.Kafka.RestProxyURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("kafka.rest_proxy_url must be an http(s) URL, got %q", d.Kafka.RestProxyURL)
+		}
+		if d.Kafka.Topic == "" {
+			return fmt.Errorf("kafka.topic is required")
+		}
+	default:
+		return fmt.Errorf("backend must be one of: nats, kafka")
+	}
+	return nil
 }
 
-type CloudflareConfig struct {
-	ApiToken      string   `yaml:"api_token"`
-	AccountID     string   `yaml:"account_id"`
-	ListID        string   `yaml:"target_list_id"`
-	SourceListIDs []string `yaml:"source_list_ids"`
+// CSVDiffConfig holds settings for writing the applied diff of every cycle as a CSV file to
+// a local directory and/or an S3-compatible bucket.
+type CSVDiffConfig struct {
+	Enabled   bool     `yaml:"enabled"`
+	Directory string   `yaml:"directory"`
+	S3        S3Config `yaml:"s3"`
+}
+
+func (c *CSVDiffConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Directory == "" && !c.S3.Enabled {
+		return fmt.Errorf("directory or s3 is required")
+	}
+	if err := c.S3.Validate(); err != nil {
+		return fmt.Errorf("s3: %w", err)
+	}
+	return nil
 }
 
 // RateLimitConfig holds rate limiting settings.
@@ -63,32 +1380,103 @@ type BatchConfig struct {
 
 // ParseConfig parses flags, loads config file, applies env and CLI overrides, and returns a validated Config.
 func ParseConfig() (*Config, error) {
+	return ParseConfigArgs(flag.CommandLine, os.Args[1:])
+}
+
+// ParseConfigArgs is ParseConfig for an explicit flag set and argument list, as used by
+// subcommands. The configuration flags are registered on fs next to any command flags.
+func ParseConfigArgs(fs *flag.FlagSet, args []string) (*Config, error) {
+	cfg, err := loadConfig(fs, args)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate required configuration
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("configuration validation failed: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// ParseCloudflareConfigArgs is ParseConfigArgs for commands that only talk to the Cloudflare
+// account, and only requires the Cloudflare credentials to be set.
+func ParseCloudflareConfigArgs(fs *flag.FlagSet, args []string) (*Config, error) {
+	cfg, err := loadConfig(fs, args)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Cloudflare.ApiToken == "" {
+		return nil, fmt.Errorf("configuration validation failed: CLOUDFLARE_API_TOKEN is required")
+	}
+	if cfg.Cloudflare.AccountID == "" {
+		return nil, fmt.Errorf("configuration validation failed: CLOUDFLARE_ACCOUNT_ID is required")
+	}
+	return cfg, nil
+}
+
+// ParseStateConfigArgs is ParseConfigArgs for commands that only read the state store,
+// and only requires the state store to be configured.
+func ParseStateConfigArgs(fs *flag.FlagSet, args []string) (*Config, error) {
+	cfg, err := loadConfig(fs, args)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.State.Path == "" {
+		return nil, fmt.Errorf("configuration validation failed: STATE_PATH is required")
+	}
+	return cfg, nil
+}
+
+// loadConfig parses args, loads the config file, and applies env and CLI overrides and defaults.
+func loadConfig(fs *flag.FlagSet, args []string) (*Config, error) {
 	var (
-		configPath                     = flag.String("config", "config.yaml", "Path to config file")
-		syncInterval                   = flag.Duration("sync-interval", 0, "How often to run the sync process (e.g., 5m, 1h)")
-		onMissing                      = flag.String("on-missing", "", "Action for missing devices: ignore, delete, alert")
-		logLevelFlag                   = flag.String("log-level", "", "Log level: debug, info, warn, error")
-		kandjiApiURL                   = flag.String("kandji-api-url", "", "Kandji API URL")
-		kandjiApiToken                 = flag.String("kandji-api-token", "", "Kandji API Token")
-		kandjiSyncDevicesWithoutOwners = flag.Bool("kandji-sync-devices-without-owners", false, "Sync devices without owners")
-		kandjiSyncMobileDevices        = flag.Bool("kandji-sync-mobile-devices", false, "Sync mobile devices")
-		kandjiIncludeTags              = flag.String("kandji-include-tags", "", "Comma-separated list of tags to include")
-		kandjiExcludeTags              = flag.String("kandji-exclude-tags", "", "Comma-separated list of tags to exclude")
-		kandjiBlueprintsIncludeIDs     = flag.String("kandji-blueprints-include-ids", "", "Comma-separated list of blueprint IDs to include")
-		kandjiBlueprintsIncludeNames   = flag.String("kandji-blueprints-include-names", "", "Comma-separated list of blueprint names to include")
-		kandjiBlueprintsExcludeIDs     = flag.String("kandji-blueprints-exclude-ids", "", "Comma-separated list of blueprint IDs to exclude")
-		kandjiBlueprintsExcludeNames   = flag.String("kandji-blueprints-exclude-names", "", "Comma-separated list of blueprint names to exclude")
-		cloudflareApiToken             = flag.String("cloudflare-api-token", "", "Cloudflare API Token")
-		cloudflareAccountID            = flag.String("cloudflare-account-id", "", "Cloudflare Account ID")
-		cloudflareListID               = flag.String("cloudflare-list-id", "", "Cloudflare Target List ID")
-		cloudflareSourceListIDs        = flag.String("cloudflare-source-list-ids", "", "Comma-separated list of Cloudflare source list IDs")
-		kandjiRPS                      = flag.Float64("kandji-requests-per-second", 0, "Kandji API requests per second")
-		cloudflareRPS                  = flag.Float64("cloudflare-requests-per-second", 0, "Cloudflare API requests per second")
-		burstCapacity                  = flag.Int("burst-capacity", 0, "Burst capacity for rate limiting")
-		batchSize                      = flag.Int("batch-size", 0, "Number of devices to process in each batch")
-		maxConcurrentBatches           = flag.Int("max-concurrent-batches", 0, "Maximum concurrent batches")
+		configPath                     = fs.String("config", "config.yaml", "Path to config file")
+		syncInterval                   = fs.Duration("sync-interval", 0, "How often to run the sync process (e.g., 5m, 1h)")
+		onMissing                      = fs.String("on-missing", "", "Action for missing devices: ignore, delete, alert")
+		deleteScope                    = fs.String("delete-scope", "", "Entries on_missing=delete may remove: all, managed_only")
+		syncMode                       = fs.String("sync-mode", "", "How the target list is written: diff, replace")
+		cycleSLO                       = fs.Duration("cycle-slo", 0, "Target duration of a sync cycle, slower cycles are logged")
+		onOverlap                      = fs.String("on-overlap", "", "Action when a cycle overruns the sync interval: skip, delay")
+		syncJitter                     = fs.Duration("sync-jitter", 0, "Maximum random delay added to every sync interval")
+		startupSplay                   = fs.Duration("startup-splay", 0, "Maximum random delay before the first sync cycle")
+		dryRun                         = fs.Bool("dry-run", false, "Compute and log the changes to the Cloudflare lists without making them")
+		logLevelFlag                   = fs.String("log-level", "", "Log level: debug, info, warn, error")
+		kandjiApiURL                   = fs.String("kandji-api-url", "", "Kandji API URL")
+		kandjiApiToken                 = fs.String("kandji-api-token", "", "Kandji API Token")
+		kandjiProxyURL                 = fs.String("kandji-proxy-url", "", "HTTP(S) proxy for Kandji requests")
+		cloudflareProxyURL             = fs.String("cloudflare-proxy-url", "", "HTTP(S) proxy for Cloudflare requests")
+		kandjiSyncDevicesWithoutOwners = fs.Bool("kandji-sync-devices-without-owners", false, "Sync devices without owners")
+		kandjiSyncMobileDevices        = fs.Bool("kandji-sync-mobile-devices", false, "Sync mobile devices")
+		kandjiIncludeTags              = fs.String("kandji-include-tags", "", "Comma-separated list of tags to include")
+		kandjiExcludeTags              = fs.String("kandji-exclude-tags", "", "Comma-separated list of tags to exclude")
+		kandjiBlueprintsIncludeIDs     = fs.String("kandji-blueprints-include-ids", "", "Comma-separated list of blueprint IDs to include")
+		kandjiBlueprintsIncludeNames   = fs.String("kandji-blueprints-include-names", "", "Comma-separated list of blueprint names, or globs prefixed with glob:, to include")
+		kandjiBlueprintsExcludeIDs     = fs.String("kandji-blueprints-exclude-ids", "", "Comma-separated list of blueprint IDs to exclude")
+		kandjiBlueprintsExcludeNames   = fs.String("kandji-blueprints-exclude-names", "", "Comma-separated list of blueprint names, or globs prefixed with glob:, to exclude")
+		cloudflareApiToken             = fs.String("cloudflare-api-token", "", "Cloudflare API Token")
+		cloudflareAccountID            = fs.String("cloudflare-account-id", "", "Cloudflare Account ID")
+		cloudflareListID               = fs.String("cloudflare-list-id", "", "Cloudflare Target List ID")
+		cloudflareTargetListName       = fs.String("cloudflare-target-list-name", "", "Cloudflare Target List name, instead of its ID")
+		cloudflareSourceListIDs        = fs.String("cloudflare-source-list-ids", "", "Comma-separated list of Cloudflare source list IDs")
+		cloudflareSourceLists          = fs.String("cloudflare-source-lists", "", "Comma-separated list of Cloudflare source list IDs or names")
+		cloudflareSourceListPatterns   = fs.String("cloudflare-source-list-patterns", "", "Comma-separated list of name globs selecting Cloudflare source lists")
+		cloudflareEmailListID          = fs.String("cloudflare-email-list-id", "", "Cloudflare EMAIL list ID to keep in sync with device owners")
+		kandjiRPS                      = fs.Float64("kandji-requests-per-second", 0, "Kandji API requests per second")
+		cloudflareRPS                  = fs.Float64("cloudflare-requests-per-second", 0, "Cloudflare API requests per second")
+		burstCapacity                  = fs.Int("burst-capacity", 0, "Burst capacity for rate limiting")
+		batchSize                      = fs.Int("batch-size", 0, "Number of devices to process in each batch")
+		statePath                      = fs.String("state-path", "", "Path of the state store file that persists the sync history")
+		warmCachePath                  = fs.String("warm-cache-path", "", "Path of the warm-start cache file that persists the target list across restarts")
+		metricsListenAddress           = fs.String("metrics-listen-address", "", "Address to serve Prometheus metrics on, e.g. :9090")
+		adminListenAddress             = fs.String("admin-listen-address", "", "Address to serve the admin API on, e.g. :8080")
+		sandbox                        = fs.Bool("sandbox", false, "Run against fake Kandji and Cloudflare APIs seeded from fixtures, without real credentials")
+		sandboxFixtures                = fs.String("sandbox-fixtures", "", "Directory of sandbox fixture files, instead of the built-in fixtures")
+		maxConcurrentBatches           = fs.Int("max-concurrent-batches", 0, "Maximum concurrent batches")
 	)
-	flag.Parse()
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
 
 	cfg := &Config{}
 
@@ -98,16 +1486,25 @@ func ParseConfig() (*Config, error) {
 	} else {
 		configFileToUse = "config.yaml"
 	}
-	if _, err := os.Stat(configFileToUse); err != nil {
+	if _, err := os.Stat(configFileToUse); err == nil {
+		data, err := os.ReadFile(configFileToUse)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		// A file encrypted with SOPS is decrypted in memory, it is never written out
+		if sops.IsEncrypted(data) {
+			if data, err = sops.Decrypt(context.Background(), data); err != nil {
+				return nil, fmt.Errorf("failed to decrypt config file with sops: %w", err)
+			}
+		}
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config file: %w", err)
+		}
+		cfg.raw, cfg.path = data, configFileToUse
+	} else if !*sandbox {
+		// The sandbox runs without a config file, on the built-in defaults
 		return nil, fmt.Errorf("configuration file not found: %s", configFileToUse)
 	}
-	data, err := os.ReadFile(configFileToUse)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
-	}
-	if err := yaml.Unmarshal(data, cfg); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
-	}
 
 	// Override with environment variables if set
 	if url := os.Getenv("KANDJI_API_URL"); url != "" {
@@ -116,6 +1513,12 @@ func ParseConfig() (*Config, error) {
 	if token := os.Getenv("KANDJI_API_TOKEN"); token != "" {
 		cfg.Kandji.ApiToken = token
 	}
+	if proxyURL := os.Getenv("KANDJI_PROXY_URL"); proxyURL != "" {
+		cfg.Kandji.ProxyURL = proxyURL
+	}
+	if proxyURL := os.Getenv("CLOUDFLARE_PROXY_URL"); proxyURL != "" {
+		cfg.Cloudflare.ProxyURL = proxyURL
+	}
 	if token := os.Getenv("CLOUDFLARE_API_TOKEN"); token != "" {
 		cfg.Cloudflare.ApiToken = token
 	}
@@ -125,130 +1528,557 @@ func ParseConfig() (*Config, error) {
 	if listID := os.Getenv("CLOUDFLARE_LIST_ID"); listID != "" {
 		cfg.Cloudflare.ListID = listID
 	}
+	if instanceID := os.Getenv("INSTANCE_ID"); instanceID != "" {
+		cfg.Client.InstanceID = instanceID
+	}
+	if statePath := os.Getenv("STATE_PATH"); statePath != "" {
+		cfg.State.Path = statePath
+	}
+	if warmCachePath := os.Getenv("WARM_CACHE_PATH"); warmCachePath != "" {
+		cfg.WarmCache.Path = warmCachePath
+	}
+	if auditPath := os.Getenv("AUDIT_LOG_PATH"); auditPath != "" {
+		cfg.Audit.Path = auditPath
+	}
+	if listenAddress := os.Getenv("METRICS_LISTEN_ADDRESS"); listenAddress != "" {
+		cfg.Metrics.ListenAddress = listenAddress
+	}
+	if address := os.Getenv("STATSD_ADDRESS"); address != "" {
+		cfg.Metrics.StatsD.Address = address
+	} else if host := os.Getenv("DD_AGENT_HOST"); host != "" && cfg.Metrics.StatsD.Address == "" {
+		// As set for the Datadog agent's DogStatsD listener, e.g. to the node's IP on Kubernetes
+		port := os.Getenv("DD_DOGSTATSD_PORT")
+		if port == "" {
+			port = "8125"
+		}
+		cfg.Metrics.StatsD.Address = net.JoinHostPort(host, port)
+	}
+	if listenAddress := os.Getenv("ADMIN_LISTEN_ADDRESS"); listenAddress != "" {
+		cfg.Admin.ListenAddress = listenAddress
+	}
+	if listKind := os.Getenv("CLOUDFLARE_LIST_KIND"); listKind != "" {
+		cfg.Cloudflare.ListKind = listKind
+	}
+	if listName := os.Getenv("CLOUDFLARE_TARGET_LIST_NAME"); listName != "" {
+		cfg.Cloudflare.TargetListName = listName
+	}
 	if sourceListIDs := os.Getenv("CLOUDFLARE_SOURCE_LIST_IDS"); sourceListIDs != "" {
 		cfg.Cloudflare.SourceListIDs = strings.Split(sourceListIDs, ",")
 	}
+	if sourceLists := os.Getenv("CLOUDFLARE_SOURCE_LISTS"); sourceLists != "" {
+		cfg.Cloudflare.SourceLists = splitCommaList(sourceLists)
+	}
+	if patterns := os.Getenv("CLOUDFLARE_SOURCE_LIST_PATTERNS"); patterns != "" {
+		cfg.Cloudflare.SourceListPatterns = splitCommaList(patterns)
+	}
+	if emailListID := os.Getenv("CLOUDFLARE_EMAIL_LIST_ID"); emailListID != "" {
+		cfg.Cloudflare.EmailListID = emailListID
+	}
+	if createList := os.Getenv("CLOUDFLARE_CREATE_LIST_IF_MISSING"); createList != "" {
+		cfg.Cloudflare.CreateListIfMissing = strings.ToLower(createList) == "true"
+	}
+	if emailOnly := os.Getenv("CLOUDFLARE_EMAIL_ONLY"); emailOnly != "" {
+		cfg.Cloudflare.EmailOnly = strings.ToLower(emailOnly) == "true"
+	}
 	if onMissingEnv := os.Getenv("ON_MISSING"); onMissingEnv != "" {
 		cfg.OnMissing = onMissingEnv
 	}
+	if deleteScopeEnv := os.Getenv("DELETE_SCOPE"); deleteScopeEnv != "" {
+		cfg.DeleteScope = deleteScopeEnv
+	}
+	if syncModeEnv := os.Getenv("SYNC_MODE"); syncModeEnv != "" {
+		cfg.SyncMode = syncModeEnv
+	}
+	if dryRunEnv := os.Getenv("DRY_RUN"); dryRunEnv != "" {
+		cfg.DryRun = strings.ToLower(dryRunEnv) == "true"
+	}
 	if syncWithoutOwners := os.Getenv("SYNC_DEVICES_WITHOUT_OWNERS"); syncWithoutOwners != "" {
 		cfg.Kandji.SyncDevicesWithoutOwners = strings.ToLower(syncWithoutOwners) == "true"
 	}
-	if SyncMobileDevices := os.Getenv("SYNC_MOBILE_DEVICES"); SyncMobileDevices != "" {
-		cfg.Kandji.SyncMobileDevices = strings.ToLower(SyncMobileDevices) == "true"
+	if SyncMobileDevices := os.Getenv("SYNC_MOBILE_DEVICES"); SyncMobileDevices != "" {
+		cfg.Kandji.SyncMobileDevices = strings.ToLower(SyncMobileDevices) == "true"
+	}
+	if logLevel := os.Getenv("LOG_LEVEL"); logLevel != "" {
+		cfg.Log.Level = logLevel
+	}
+	if token := os.Getenv("TAILSCALE_API_TOKEN"); token != "" {
+		cfg.Destinations.Tailscale.ApiToken = token
+	}
+	if sourceFiles := os.Getenv("SOURCE_FILES"); sourceFiles != "" {
+		cfg.SourceFiles = splitCommaList(sourceFiles)
+	}
+	for i := range cfg.Kandji.Tenants {
+		if token := os.Getenv(cfg.Kandji.Tenants[i].TokenEnv()); token != "" {
+			cfg.Kandji.Tenants[i].ApiToken = token
+		}
+	}
+	for i := range cfg.Jobs {
+		if token := os.Getenv(cfg.Jobs[i].TokenEnv()); token != "" {
+			cfg.Jobs[i].ApiToken = token
+		}
+	}
+	if secret := os.Getenv("INTUNE_CLIENT_SECRET"); secret != "" {
+		cfg.Intune.ClientSecret = secret
+	}
+	if token := os.Getenv("MOSYLE_ACCESS_TOKEN"); token != "" {
+		cfg.Mosyle.AccessToken = token
+	}
+	if password := os.Getenv("MOSYLE_PASSWORD"); password != "" {
+		cfg.Mosyle.Password = password
+	}
+	if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		cfg.Admin.Token = token
+	}
+	if token := os.Getenv("WEBHOOK_TOKEN"); token != "" {
+		cfg.Webhook.Tokens = append(cfg.Webhook.Tokens, WebhookToken{Name: "env", Token: token})
+	}
+	if secret := os.Getenv("NOTIFICATIONS_WEBHOOK_SECRET"); secret != "" {
+		cfg.Notifications.Webhook.Secret = secret
+	}
+	if routingKey := os.Getenv("PAGERDUTY_ROUTING_KEY"); routingKey != "" {
+		cfg.Notifications.PagerDuty.RoutingKey = routingKey
+	}
+	if webhookURL := os.Getenv("SLACK_WEBHOOK_URL"); webhookURL != "" {
+		cfg.Notifications.Slack.WebhookURL = webhookURL
+	}
+	if password := os.Getenv("SMTP_PASSWORD"); password != "" {
+		cfg.SMTP.Password = password
+	}
+	if token := os.Getenv("NATS_TOKEN"); token != "" {
+		cfg.Destinations.DeviceEvents.NATS.Token = token
+	}
+	if password := os.Getenv("KAFKA_REST_PROXY_PASSWORD"); password != "" {
+		cfg.Destinations.DeviceEvents.Kafka.Password = password
+	}
+	cfg.applySecretsEnv()
+	if err := cfg.applySecretFilesEnv(); err != nil {
+		return nil, err
+	}
+
+	// Override config with CLI flags if set
+	if *syncInterval != 0 {
+		cfg.SyncInterval = *syncInterval
+	}
+	if *onMissing != "" {
+		cfg.OnMissing = *onMissing
+	}
+	if *deleteScope != "" {
+		cfg.DeleteScope = *deleteScope
+	}
+	if *syncMode != "" {
+		cfg.SyncMode = *syncMode
+	}
+	if *cycleSLO != 0 {
+		cfg.CycleSLO = *cycleSLO
+	}
+	if *onOverlap != "" {
+		cfg.OnOverlap = *onOverlap
+	}
+	if *syncJitter != 0 {
+		cfg.SyncJitter = *syncJitter
+	}
+	if *startupSplay != 0 {
+		cfg.StartupSplay = *startupSplay
+	}
+	if *dryRun {
+		cfg.DryRun = true
+	}
+	if *logLevelFlag != "" {
+		cfg.Log.Level = *logLevelFlag
+	}
+	if *kandjiApiURL != "" {
+		cfg.Kandji.ApiURL = *kandjiApiURL
+	}
+	if *kandjiApiToken != "" {
+		cfg.Kandji.ApiToken, cfg.Kandji.ApiTokenFile = *kandjiApiToken, ""
+	}
+	if *kandjiProxyURL != "" {
+		cfg.Kandji.ProxyURL = *kandjiProxyURL
+	}
+	if *cloudflareProxyURL != "" {
+		cfg.Cloudflare.ProxyURL = *cloudflareProxyURL
+	}
+	if *kandjiSyncDevicesWithoutOwners {
+		cfg.Kandji.SyncDevicesWithoutOwners = true
+	}
+	if *kandjiSyncMobileDevices {
+		cfg.Kandji.SyncMobileDevices = true
+	}
+	if *kandjiIncludeTags != "" {
+		cfg.Kandji.IncludeTags = splitCommaList(*kandjiIncludeTags)
+	}
+	if *kandjiExcludeTags != "" {
+		cfg.Kandji.ExcludeTags = splitCommaList(*kandjiExcludeTags)
+	}
+	if *kandjiBlueprintsIncludeIDs != "" {
+		cfg.Kandji.BlueprintsInclude.BlueprintIDs = splitCommaList(*kandjiBlueprintsIncludeIDs)
+	}
+	if *kandjiBlueprintsIncludeNames != "" {
+		cfg.Kandji.BlueprintsInclude.BlueprintNames = splitCommaList(*kandjiBlueprintsIncludeNames)
+	}
+	if *kandjiBlueprintsExcludeIDs != "" {
+		cfg.Kandji.BlueprintsExclude.BlueprintIDs = splitCommaList(*kandjiBlueprintsExcludeIDs)
+	}
+	if *kandjiBlueprintsExcludeNames != "" {
+		cfg.Kandji.BlueprintsExclude.BlueprintNames = splitCommaList(*kandjiBlueprintsExcludeNames)
+	}
+	if *cloudflareApiToken != "" {
+		cfg.Cloudflare.ApiToken, cfg.Cloudflare.ApiTokenFile = *cloudflareApiToken, ""
+	}
+	if *cloudflareAccountID != "" {
+		cfg.Cloudflare.AccountID = *cloudflareAccountID
+	}
+	if *cloudflareListID != "" {
+		cfg.Cloudflare.ListID = *cloudflareListID
+	}
+	if *statePath != "" {
+		cfg.State.Path = *statePath
+	}
+	if *warmCachePath != "" {
+		cfg.WarmCache.Path = *warmCachePath
+	}
+	if *metricsListenAddress != "" {
+		cfg.Metrics.ListenAddress = *metricsListenAddress
+	}
+	if *adminListenAddress != "" {
+		cfg.Admin.ListenAddress = *adminListenAddress
+	}
+	if *cloudflareTargetListName != "" {
+		cfg.Cloudflare.TargetListName = *cloudflareTargetListName
+	}
+	if *cloudflareSourceListIDs != "" {
+		cfg.Cloudflare.SourceListIDs = splitCommaList(*cloudflareSourceListIDs)
+	}
+	if *cloudflareSourceLists != "" {
+		cfg.Cloudflare.SourceLists = splitCommaList(*cloudflareSourceLists)
+	}
+	if *cloudflareSourceListPatterns != "" {
+		cfg.Cloudflare.SourceListPatterns = splitCommaList(*cloudflareSourceListPatterns)
+	}
+	if *cloudflareEmailListID != "" {
+		cfg.Cloudflare.EmailListID = *cloudflareEmailListID
+	}
+	if *kandjiRPS != 0 {
+		cfg.RateLimits.KandjiRequestsPerSecond = *kandjiRPS
+	}
+	if *cloudflareRPS != 0 {
+		cfg.RateLimits.CloudflareRequestsPerSecond = *cloudflareRPS
+	}
+	if *burstCapacity != 0 {
+		cfg.RateLimits.BurstCapacity = *burstCapacity
+	}
+	if *batchSize != 0 {
+		cfg.Batch.Size = *batchSize
+	}
+	if *maxConcurrentBatches != 0 {
+		cfg.Batch.MaxConcurrentBatches = *maxConcurrentBatches
+	}
+	if *sandbox {
+		cfg.Sandbox.Enabled = true
+	}
+	if *sandboxFixtures != "" {
+		cfg.Sandbox.Fixtures = *sandboxFixtures
+	}
+	if cfg.Sandbox.Enabled {
+		cfg.applySandbox()
+	}
+
+	cfg.SetDefaults()
+	if err := cfg.resolveSecrets(context.Background()); err != nil {
+		return nil, err
+	}
+	if err := cfg.readSecretFiles(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Path returns the path of the config file the configuration was read from, empty if it
+// was not read from a file.
+func (c *Config) Path() string {
+	return c.path
+}
+
+// Parse parses a config file's contents, fills in the defaults and validates the result.
+// Unlike ParseConfig it ignores environment variables and command-line flags, for
+// embedding the sync engine.
+func Parse(data []byte) (*Config, error) {
+	cfg := &Config{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	cfg.raw = data
+	cfg.SetDefaults()
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("configuration validation failed: %w", err)
+	}
+	return cfg, nil
+}
+
+// SetDefaults fills in the defaults of every setting left unset. Loading a config file does
+// this; configs built in code, e.g. for the engine package, should call it before use.
+func (c *Config) SetDefaults() {
+	// Set default log level if not specified
+	if c.Log.Level == "" {
+		c.Log.Level = "info"
+	}
+
+	// Set default sync interval if not specified
+	if c.SyncInterval == 0 {
+		c.SyncInterval = 5 * time.Minute
+	}
+
+	if c.Kandji.Pagination.PageSize == 0 {
+		c.Kandji.Pagination.PageSize = 300
+	}
+	if c.Kandji.Pagination.Concurrency == 0 {
+		c.Kandji.Pagination.Concurrency = 1
+	}
+	if c.Kandji.Incremental.FullResyncInterval == 0 {
+		c.Kandji.Incremental.FullResyncInterval = 24 * time.Hour
+	}
+	if c.Client.ConditionalRequests.MaxEntries == 0 {
+		c.Client.ConditionalRequests.MaxEntries = 1000
+	}
+
+	// Set default on_missing behavior if not specified
+	if c.OnMissing == "" {
+		c.OnMissing = "ignore"
+	}
+	if c.DeleteScope == "" {
+		c.DeleteScope = "all"
+	}
+	if c.SyncMode == "" {
+		c.SyncMode = "diff"
+	}
+	if c.OnOverlap == "" {
+		c.OnOverlap = "skip"
+	}
+	if c.Cloudflare.ManagedMarker == "" {
+		c.Cloudflare.ManagedMarker = "[kandji-sync]"
+	}
+	if c.Cloudflare.ConflictResolution == "" {
+		c.Cloudflare.ConflictResolution = "kandji_first"
+	}
+
+	// Set default rate limits if not specified
+	if c.RateLimits.KandjiRequestsPerSecond == 0 {
+		c.RateLimits.KandjiRequestsPerSecond = 10.0
+	}
+	if c.RateLimits.CloudflareRequestsPerSecond == 0 {
+		c.RateLimits.CloudflareRequestsPerSecond = 4.0 // Cloudflare has stricter limits
+	}
+	if c.RateLimits.BurstCapacity == 0 {
+		c.RateLimits.BurstCapacity = 5
+	}
+
+	// Set default batch settings if not specified
+	if c.Batch.Size == 0 {
+		c.Batch.Size = 50
+	}
+	if c.Batch.MaxConcurrentBatches == 0 {
+		c.Batch.MaxConcurrentBatches = 3
+	}
+
+	// Default to the tailnet that owns the API token
+	if c.Destinations.Tailscale.Tailnet == "" {
+		c.Destinations.Tailscale.Tailnet = "-"
+	}
+	if c.Destinations.GoogleSheets.SheetName == "" {
+		c.Destinations.GoogleSheets.SheetName = "Devices"
+	}
+	if c.Destinations.S3.Region == "" {
+		c.Destinations.S3.Region = "us-east-1"
+	}
+	if c.Destinations.CSVDiff.S3.Region == "" {
+		c.Destinations.CSVDiff.S3.Region = "us-east-1"
+	}
+	if c.Destinations.KandjiFeedback.Mode == "" {
+		c.Destinations.KandjiFeedback.Mode = "tags"
+	}
+	if c.Destinations.KandjiFeedback.SyncedTag == "" {
+		c.Destinations.KandjiFeedback.SyncedTag = "cf-synced"
+	}
+	if c.Destinations.KandjiFeedback.ErrorTag == "" {
+		c.Destinations.KandjiFeedback.ErrorTag = "cf-sync-error"
+	}
+	if c.Destinations.IPList.TTL == 0 {
+		c.Destinations.IPList.TTL = 24 * time.Hour
+	}
+	if c.Cloudflare.ListKind == "" {
+		c.Cloudflare.ListKind = ListKindGateway
+	}
+	if c.Cloudflare.NewList.Name == "" {
+		c.Cloudflare.NewList.Name = "Kandji Managed Devices"
+	}
+	if c.Cloudflare.NewList.Description == "" {
+		c.Cloudflare.NewList.Description = "Serial numbers of Kandji managed devices, maintained by kandji-cloudflare-device-sync"
+	}
+	if c.Cloudflare.Retry.MaxAttempts == 0 {
+		c.Cloudflare.Retry.MaxAttempts = 4
+	}
+	if c.Cloudflare.Retry.InitialBackoff == 0 {
+		c.Cloudflare.Retry.InitialBackoff = time.Second
+	}
+	if c.Cloudflare.Retry.MaxBackoff == 0 {
+		c.Cloudflare.Retry.MaxBackoff = 30 * time.Second
+	}
+	if c.Cloudflare.Retry.MaxRetryAfter == 0 {
+		c.Cloudflare.Retry.MaxRetryAfter = 5 * time.Minute
+	}
+	if len(c.Cloudflare.Comment.Fields) == 0 {
+		c.Cloudflare.Comment.Fields = []string{"name"}
+	}
+	if c.Cloudflare.Comment.Separator == "" {
+		c.Cloudflare.Comment.Separator = " | "
+	}
+	if c.Cloudflare.Comment.MaxLength == 0 {
+		c.Cloudflare.Comment.MaxLength = 500
+	}
+	if apiURL, err := NormalizeKandjiAPIURL(c.Kandji.ApiURL); err == nil {
+		c.Kandji.ApiURL = apiURL
+	}
+	if len(c.Network.Hosts) > 0 {
+		// Host names are case-insensitive; the dialer looks them up in lower case
+		hosts := make(map[string]string, len(c.Network.Hosts))
+		for host, ip := range c.Network.Hosts {
+			hosts[strings.ToLower(host)] = ip
+		}
+		c.Network.Hosts = hosts
+	}
+	if c.UpdateCheck.Interval == 0 {
+		c.UpdateCheck.Interval = 24 * time.Hour
+	}
+	if c.Client.InstanceID == "" {
+		c.Client.InstanceID, _ = os.Hostname()
+	}
+	if c.State.Retention == 0 {
+		c.State.Retention = 30 * 24 * time.Hour
+	}
+	if c.State.IdempotencyWindow == 0 {
+		c.State.IdempotencyWindow = 10 * time.Minute
+	}
+	if c.CatchUp.Threshold == 0 {
+		c.CatchUp.Threshold = 1
+	}
+	if c.Reload.PollInterval == 0 {
+		c.Reload.PollInterval = 10 * time.Second
+	}
+	if c.Secrets.RefreshInterval == 0 {
+		c.Secrets.RefreshInterval = 5 * time.Minute
+	}
+	if c.Secrets.Vault.AuthMethod == "" {
+		c.Secrets.Vault.AuthMethod = secrets.VaultAuthToken
+	}
+	if c.WarmCache.MaxAge == 0 {
+		c.WarmCache.MaxAge = 15 * time.Minute
 	}
-	if logLevel := os.Getenv("LOG_LEVEL"); logLevel != "" {
-		cfg.Log.Level = logLevel
+	if c.Notifications.Slack.Summaries == "" {
+		c.Notifications.Slack.Summaries = "always"
 	}
-
-	// Override config with CLI flags if set
-	if *syncInterval != 0 {
-		cfg.SyncInterval = *syncInterval
+	if c.Shutdown.DrainTimeout == 0 {
+		// Within the 30s Kubernetes waits between SIGTERM and SIGKILL by default
+		c.Shutdown.DrainTimeout = 25 * time.Second
 	}
-	if *onMissing != "" {
-		cfg.OnMissing = *onMissing
+	if c.LeaderElection.LeaseDuration == 0 {
+		c.LeaderElection.LeaseDuration = 15 * time.Second
 	}
-	if *logLevelFlag != "" {
-		cfg.Log.Level = *logLevelFlag
+	if c.LeaderElection.RenewInterval == 0 {
+		c.LeaderElection.RenewInterval = 5 * time.Second
 	}
-	if *kandjiApiURL != "" {
-		cfg.Kandji.ApiURL = *kandjiApiURL
+	if c.LeaderElection.Kubernetes.LeaseName == "" {
+		c.LeaderElection.Kubernetes.LeaseName = "kandji-cloudflare-device-sync"
 	}
-	if *kandjiApiToken != "" {
-		cfg.Kandji.ApiToken = *kandjiApiToken
+	if c.LeaderElection.Redis.Key == "" {
+		c.LeaderElection.Redis.Key = "kandji-cloudflare-device-sync:leader"
 	}
-	if *kandjiSyncDevicesWithoutOwners {
-		cfg.Kandji.SyncDevicesWithoutOwners = true
+	if c.CircuitBreaker.FailureThreshold == 0 {
+		c.CircuitBreaker.FailureThreshold = 3
 	}
-	if *kandjiSyncMobileDevices {
-		cfg.Kandji.SyncMobileDevices = true
+	if c.CircuitBreaker.Cooldown == 0 {
+		c.CircuitBreaker.Cooldown = 15 * time.Minute
 	}
-	if *kandjiIncludeTags != "" {
-		cfg.Kandji.IncludeTags = splitCommaList(*kandjiIncludeTags)
+	if c.Notifications.PagerDuty.FailureThreshold == 0 {
+		c.Notifications.PagerDuty.FailureThreshold = 3
 	}
-	if *kandjiExcludeTags != "" {
-		cfg.Kandji.ExcludeTags = splitCommaList(*kandjiExcludeTags)
+	if c.Notifications.PagerDuty.Severity == "" {
+		c.Notifications.PagerDuty.Severity = "error"
 	}
-	if *kandjiBlueprintsIncludeIDs != "" {
-		cfg.Kandji.BlueprintsInclude.BlueprintIDs = splitCommaList(*kandjiBlueprintsIncludeIDs)
+	if c.Notifications.Email.SubjectPrefix == "" {
+		c.Notifications.Email.SubjectPrefix = "[kandji-cloudflare-sync]"
 	}
-	if *kandjiBlueprintsIncludeNames != "" {
-		cfg.Kandji.BlueprintsInclude.BlueprintNames = splitCommaList(*kandjiBlueprintsIncludeNames)
+	if c.Destinations.BlueprintLists.NameTemplate == "" {
+		c.Destinations.BlueprintLists.NameTemplate = "Kandji - {blueprint}"
 	}
-	if *kandjiBlueprintsExcludeIDs != "" {
-		cfg.Kandji.BlueprintsExclude.BlueprintIDs = splitCommaList(*kandjiBlueprintsExcludeIDs)
+	if c.Metrics.Path == "" {
+		c.Metrics.Path = "/metrics"
 	}
-	if *kandjiBlueprintsExcludeNames != "" {
-		cfg.Kandji.BlueprintsExclude.BlueprintNames = splitCommaList(*kandjiBlueprintsExcludeNames)
+	if c.Metrics.StatsD.Address == "" {
+		c.Metrics.StatsD.Address = "127.0.0.1:8125"
 	}
-	if *cloudflareApiToken != "" {
-		cfg.Cloudflare.ApiToken = *cloudflareApiToken
+	if c.Metrics.StatsD.Format == "" {
+		c.Metrics.StatsD.Format = "dogstatsd"
 	}
-	if *cloudflareAccountID != "" {
-		cfg.Cloudflare.AccountID = *cloudflareAccountID
+	if c.Metrics.StatsD.FlushInterval == 0 {
+		c.Metrics.StatsD.FlushInterval = time.Second
 	}
-	if *cloudflareListID != "" {
-		cfg.Cloudflare.ListID = *cloudflareListID
+	if c.Admin.EventBufferSize == 0 {
+		c.Admin.EventBufferSize = 500
 	}
-	if *cloudflareSourceListIDs != "" {
-		cfg.Cloudflare.SourceListIDs = splitCommaList(*cloudflareSourceListIDs)
+	if c.SMTP.Security == "" {
+		c.SMTP.Security = "starttls"
 	}
-	if *kandjiRPS != 0 {
-		cfg.RateLimits.KandjiRequestsPerSecond = *kandjiRPS
+	if c.SMTP.Port == 0 {
+		c.SMTP.Port = 587
+		if c.SMTP.Security == "tls" {
+			c.SMTP.Port = 465
+		}
 	}
-	if *cloudflareRPS != 0 {
-		cfg.RateLimits.CloudflareRequestsPerSecond = *cloudflareRPS
+	if c.Digest.Time == "" {
+		c.Digest.Time = "08:00"
 	}
-	if *burstCapacity != 0 {
-		cfg.RateLimits.BurstCapacity = *burstCapacity
+	if c.Digest.TimeZone == "" {
+		c.Digest.TimeZone = "UTC"
 	}
-	if *batchSize != 0 {
-		cfg.Batch.Size = *batchSize
+	if c.Digest.Subject == "" {
+		c.Digest.Subject = "Kandji-Cloudflare device sync daily digest"
 	}
-	if *maxConcurrentBatches != 0 {
-		cfg.Batch.MaxConcurrentBatches = *maxConcurrentBatches
+	if c.Intune.OperatingSystems == nil {
+		c.Intune.OperatingSystems = []string{"Windows"}
 	}
-
-	// Set default log level if not specified
-	if cfg.Log.Level == "" {
-		cfg.Log.Level = "info"
+	if c.Intune.LoginURL == "" {
+		c.Intune.LoginURL = "https://login.microsoftonline.com"
 	}
-
-	// Set default sync interval if not specified
-	if cfg.SyncInterval == 0 {
-		cfg.SyncInterval = 5 * time.Minute
+	if c.Intune.GraphURL == "" {
+		c.Intune.GraphURL = "https://graph.microsoft.com"
 	}
-
-	// Set default on_missing behavior if not specified
-	if cfg.OnMissing == "" {
-		cfg.OnMissing = "ignore"
+	if c.Mosyle.APIURL == "" {
+		c.Mosyle.APIURL = "https://businessapi.mosyle.com/v1"
 	}
-
-	// Set default rate limits if not specified
-	if cfg.RateLimits.KandjiRequestsPerSecond == 0 {
-		cfg.RateLimits.KandjiRequestsPerSecond = 10.0
+	if c.Mosyle.OperatingSystems == nil {
+		c.Mosyle.OperatingSystems = []string{"mac"}
 	}
-	if cfg.RateLimits.CloudflareRequestsPerSecond == 0 {
-		cfg.RateLimits.CloudflareRequestsPerSecond = 4.0 // Cloudflare has stricter limits
+	if c.Mosyle.PageSize == 0 {
+		c.Mosyle.PageSize = 500
 	}
-	if cfg.RateLimits.BurstCapacity == 0 {
-		cfg.RateLimits.BurstCapacity = 5
+	if c.Mosyle.RequestsPerSecond == 0 {
+		c.Mosyle.RequestsPerSecond = 1.0
 	}
+}
 
-	// Set default batch settings if not specified
-	if cfg.Batch.Size == 0 {
-		cfg.Batch.Size = 50
+// validateProxyURL checks that an optional proxy URL is an absolute http(s) URL.
+func validateProxyURL(proxyURL string) error {
+	if proxyURL == "" {
+		return nil
 	}
-	if cfg.Batch.MaxConcurrentBatches == 0 {
-		cfg.Batch.MaxConcurrentBatches = 3
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return err
 	}
-
-	// Validate required configuration
-	if err := cfg.Validate(); err != nil {
-		return nil, fmt.Errorf("configuration validation failed: %w", err)
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("must be an http:// or https:// URL with a host")
 	}
-
-	return cfg, nil
+	return nil
 }
 
 // Helper to split comma-separated lists
@@ -275,6 +2105,11 @@ func LoadConfig() (*Config, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to read config file: %w", err)
 		}
+		if sops.IsEncrypted(data) {
+			if data, err = sops.Decrypt(context.Background(), data); err != nil {
+				return nil, fmt.Errorf("failed to decrypt config file with sops: %w", err)
+			}
+		}
 
 		if err := yaml.Unmarshal(data, cfg); err != nil {
 			return nil, fmt.Errorf("failed to parse config file: %w", err)
@@ -357,11 +2192,80 @@ func LoadConfig() (*Config, error) {
 
 // Validate checks that all required configuration values are present and valid.
 func (c *Config) Validate() error {
-	if c.Kandji.ApiURL == "" {
-		return fmt.Errorf("KANDJI_API_URL is required")
+	if c.ConfigVersion > CurrentConfigVersion {
+		return fmt.Errorf("config_version %d is newer than this release supports (%d)", c.ConfigVersion, CurrentConfigVersion)
+	}
+	if c.KandjiEnabled() {
+		if _, err := NormalizeKandjiAPIURL(c.Kandji.ApiURL); err != nil {
+			return err
+		}
+		if c.Kandji.ApiToken == "" {
+			return fmt.Errorf("KANDJI_API_TOKEN is required")
+		}
+	}
+	if err := c.Mosyle.Validate(); err != nil {
+		return fmt.Errorf("mosyle: %w", err)
+	}
+	tenantNames := make(map[string]struct{})
+	for i := range c.Kandji.Tenants {
+		tenant := &c.Kandji.Tenants[i]
+		if err := tenant.Validate(); err != nil {
+			return fmt.Errorf("kandji.tenants[%d]: %w", i, err)
+		}
+		if _, dup := tenantNames[tenant.Name]; dup {
+			return fmt.Errorf("kandji.tenants: duplicate name %q", tenant.Name)
+		}
+		tenantNames[tenant.Name] = struct{}{}
+	}
+	if len(c.Kandji.Tenants) > 0 && !c.KandjiEnabled() {
+		return fmt.Errorf("kandji.tenants are added to the tenant of kandji.api_url, which is required")
+	}
+	if !c.KandjiEnabled() && (c.Destinations.KandjiFeedback.Enabled || c.Destinations.IPList.Enabled) {
+		return fmt.Errorf("destinations.kandji_feedback and destinations.ip_list need the Kandji API, set kandji.api_url and kandji.api_token")
+	}
+	if _, err := CompileTagPatterns(c.Kandji.IncludeTags); err != nil {
+		return fmt.Errorf("kandji.include_tags: %w", err)
+	}
+	if _, err := CompileTagPatterns(c.Kandji.ExcludeTags); err != nil {
+		return fmt.Errorf("kandji.exclude_tags: %w", err)
 	}
-	if c.Kandji.ApiToken == "" {
-		return fmt.Errorf("KANDJI_API_TOKEN is required")
+	if _, err := CompileTagPatterns(c.Kandji.AssetTagsInclude); err != nil {
+		return fmt.Errorf("kandji.asset_tags_include: %w", err)
+	}
+	if _, err := CompileTagPatterns(c.Kandji.AssetTagsExclude); err != nil {
+		return fmt.Errorf("kandji.asset_tags_exclude: %w", err)
+	}
+	if err := c.Kandji.BlueprintsInclude.Validate(); err != nil {
+		return fmt.Errorf("kandji.blueprints_include: %w", err)
+	}
+	if err := c.Kandji.BlueprintsExclude.Validate(); err != nil {
+		return fmt.Errorf("kandji.blueprints_exclude: %w", err)
+	}
+	for _, pattern := range append(append([]string{}, c.Kandji.ModelsInclude...), c.Kandji.ModelsExclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid kandji.models_include or models_exclude entry %q: %w", pattern, err)
+		}
+	}
+	if c.Kandji.Platforms != nil {
+		for _, platform := range append(append([]string{}, c.Kandji.Platforms.Include...), c.Kandji.Platforms.Exclude...) {
+			if strings.TrimSpace(platform) == "" {
+				return fmt.Errorf("kandji.platforms entries cannot be empty")
+			}
+		}
+	}
+	if c.Kandji.MaxLastCheckinAge < 0 {
+		return fmt.Errorf("kandji.max_last_checkin_age cannot be negative")
+	}
+	if err := c.Kandji.Pagination.Validate(); err != nil {
+		return fmt.Errorf("kandji.pagination: %w", err)
+	}
+	if err := c.Kandji.Incremental.Validate(); err != nil {
+		return fmt.Errorf("kandji.incremental: %w", err)
+	}
+	for platform, version := range c.Kandji.MinOSVersion {
+		if _, err := ParseOSVersion(version); err != nil {
+			return fmt.Errorf("kandji.min_os_version for %s: %w", platform, err)
+		}
 	}
 	if c.Cloudflare.ApiToken == "" {
 		return fmt.Errorf("CLOUDFLARE_API_TOKEN is required")
@@ -369,11 +2273,170 @@ func (c *Config) Validate() error {
 	if c.Cloudflare.AccountID == "" {
 		return fmt.Errorf("CLOUDFLARE_ACCOUNT_ID is required")
 	}
+	if err := c.Network.Validate(); err != nil {
+		return fmt.Errorf("network: %w", err)
+	}
+	if err := c.Client.ConditionalRequests.Validate(); err != nil {
+		return fmt.Errorf("client.conditional_requests: %w", err)
+	}
+	if err := c.Metrics.Validate(); err != nil {
+		return fmt.Errorf("metrics: %w", err)
+	}
+	if err := c.Admin.Validate(); err != nil {
+		return fmt.Errorf("admin: %w", err)
+	}
+	if err := c.Webhook.Validate(); err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	if c.Webhook.Enabled && c.Admin.ListenAddress == "" {
+		return fmt.Errorf("webhook: admin.listen_address is required, the webhook is served by the admin API")
+	}
+	if err := c.WarmCache.Validate(); err != nil {
+		return fmt.Errorf("warm_cache: %w", err)
+	}
+	if err := c.State.Validate(); err != nil {
+		return fmt.Errorf("state: %w", err)
+	}
+	if err := c.CatchUp.Validate(); err != nil {
+		return fmt.Errorf("catch_up: %w", err)
+	}
+	if err := c.Reload.Validate(); err != nil {
+		return fmt.Errorf("reload: %w", err)
+	}
+	if err := c.Secrets.Validate(); err != nil {
+		return fmt.Errorf("secrets: %w", err)
+	}
+	if err := c.Systemd.Validate(); err != nil {
+		return fmt.Errorf("systemd: %w", err)
+	}
+	if err := c.Shutdown.Validate(); err != nil {
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	if err := c.LeaderElection.Validate(); err != nil {
+		return fmt.Errorf("leader_election: %w", err)
+	}
+	if err := c.CircuitBreaker.Validate(); err != nil {
+		return fmt.Errorf("circuit_breaker: %w", err)
+	}
+	if err := c.MaxRemovals.Validate(); err != nil {
+		return fmt.Errorf("max_removals_per_cycle: %w", err)
+	}
+	if err := c.Notifications.Validate(); err != nil {
+		return fmt.Errorf("notifications: %w", err)
+	}
+	if err := c.Digest.Validate(); err != nil {
+		return fmt.Errorf("digest: %w", err)
+	}
+	if err := c.BlackoutWindows.Validate(); err != nil {
+		return fmt.Errorf("blackout_windows: %w", err)
+	}
+	if c.OnMissingGraceCycles < 0 {
+		return fmt.Errorf("on_missing_grace_cycles cannot be negative")
+	}
+	if c.OnMissingGraceCycles > 1 && c.State.Path == "" {
+		return fmt.Errorf("on_missing_grace_cycles: state.path is required, misses are counted in the state store")
+	}
+	if c.Digest.Enabled {
+		if c.State.Path == "" {
+			return fmt.Errorf("digest: state.path is required, the digest is built from the state store")
+		}
+	}
+	if c.Digest.Enabled || c.Notifications.Email.Enabled {
+		if err := c.SMTP.Validate(); err != nil {
+			return fmt.Errorf("smtp: %w", err)
+		}
+	}
+	if err := validateProxyURL(c.Kandji.ProxyURL); err != nil {
+		return fmt.Errorf("kandji.proxy_url: %w", err)
+	}
+	if err := validateProxyURL(c.Cloudflare.ProxyURL); err != nil {
+		return fmt.Errorf("cloudflare.proxy_url: %w", err)
+	}
+	switch c.Cloudflare.ListKind {
+	case "", ListKindGateway:
+	case ListKindRules:
+		// Rules Lists hold IPs, hostnames, ASNs and redirects, there is no email kind
+		if c.Cloudflare.EmailListID != "" {
+			return fmt.Errorf("CLOUDFLARE_EMAIL_LIST_ID is not supported with list_kind rules")
+		}
+	default:
+		return fmt.Errorf("cloudflare.list_kind must be one of: gateway, rules")
+	}
+	if c.Cloudflare.EmailOnly {
+		if err := c.Cloudflare.validateEmailOnly(); err != nil {
+			return fmt.Errorf("cloudflare.email_only: %w", err)
+		}
+	} else if c.Cloudflare.ListID == "" && c.Cloudflare.TargetListName == "" {
+		return fmt.Errorf("CLOUDFLARE_LIST_ID or CLOUDFLARE_TARGET_LIST_NAME is required")
+	}
+	if c.Cloudflare.ListID != "" && c.Cloudflare.TargetListName != "" {
+		return fmt.Errorf("set only one of CLOUDFLARE_LIST_ID and CLOUDFLARE_TARGET_LIST_NAME")
+	}
 	// Optional: check for duplicates in SourceListIDs or if target is in source
-	for _, src := range c.Cloudflare.SourceListIDs {
+	for _, src := range c.Cloudflare.SourceListRefs() {
 		if src == c.Cloudflare.ListID {
 			return fmt.Errorf("CLOUDFLARE_SOURCE_LIST_IDS cannot contain the target list ID")
 		}
+		if c.Cloudflare.EmailListID != "" && src == c.Cloudflare.EmailListID {
+			return fmt.Errorf("CLOUDFLARE_SOURCE_LIST_IDS cannot contain the email list ID")
+		}
+	}
+	for _, pattern := range c.Cloudflare.SourceListPatterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid source_list_patterns entry %q: %w", pattern, err)
+		}
+	}
+	if c.Cloudflare.EmailListID != "" && c.Cloudflare.EmailListID == c.Cloudflare.ListID {
+		return fmt.Errorf("CLOUDFLARE_EMAIL_LIST_ID cannot be the target list ID")
+	}
+	sourceRefs := c.Cloudflare.SourceListRefs()
+	for platform, ref := range c.Cloudflare.PlatformRouting {
+		if strings.TrimSpace(platform) == "" || strings.TrimSpace(ref) == "" {
+			return fmt.Errorf("cloudflare.platform_routing entries need a platform and a list")
+		}
+		if ref == c.Cloudflare.ListID || ref == c.Cloudflare.TargetListName {
+			return fmt.Errorf("cloudflare.platform_routing cannot route %s devices to the target list", platform)
+		}
+		if c.Cloudflare.EmailListID != "" && ref == c.Cloudflare.EmailListID {
+			return fmt.Errorf("cloudflare.platform_routing cannot route %s devices to the email list", platform)
+		}
+		if slices.Contains(sourceRefs, ref) {
+			return fmt.Errorf("cloudflare.platform_routing cannot route %s devices to source list %q", platform, ref)
+		}
+	}
+	for blueprint, ref := range c.Cloudflare.BlueprintRouting {
+		if strings.TrimSpace(blueprint) == "" || strings.TrimSpace(ref) == "" {
+			return fmt.Errorf("cloudflare.blueprint_routing entries need a blueprint and a list")
+		}
+		if ref == c.Cloudflare.ListID || ref == c.Cloudflare.TargetListName {
+			return fmt.Errorf("cloudflare.blueprint_routing cannot route blueprint %q to the target list", blueprint)
+		}
+		if c.Cloudflare.EmailListID != "" && ref == c.Cloudflare.EmailListID {
+			return fmt.Errorf("cloudflare.blueprint_routing cannot route blueprint %q to the email list", blueprint)
+		}
+		if slices.Contains(sourceRefs, ref) {
+			return fmt.Errorf("cloudflare.blueprint_routing cannot route blueprint %q to source list %q", blueprint, ref)
+		}
+	}
+	routedRefs := c.Cloudflare.RoutedListRefs()
+	for tag, ref := range c.Cloudflare.TagListMapping.Tags {
+		if strings.TrimSpace(tag) == "" || strings.TrimSpace(ref) == "" {
+			return fmt.Errorf("cloudflare.tag_list_mapping.tags entries need a tag and a list")
+		}
+	}
+	for _, ref := range c.Cloudflare.TagListMapping.Refs() {
+		if ref == c.Cloudflare.ListID || ref == c.Cloudflare.TargetListName {
+			return fmt.Errorf("cloudflare.tag_list_mapping cannot add devices to the target list")
+		}
+		if c.Cloudflare.EmailListID != "" && ref == c.Cloudflare.EmailListID {
+			return fmt.Errorf("cloudflare.tag_list_mapping cannot add devices to the email list")
+		}
+		if slices.Contains(sourceRefs, ref) {
+			return fmt.Errorf("cloudflare.tag_list_mapping cannot add devices to source list %q", ref)
+		}
+		if slices.Contains(routedRefs, ref) {
+			return fmt.Errorf("cloudflare.tag_list_mapping cannot add devices to routed list %q", ref)
+		}
 	}
 
 	// Validate on_missing values
@@ -388,6 +2451,274 @@ func (c *Config) Validate() error {
 	if !isValid {
 		return fmt.Errorf("on_missing must be one of: %s", strings.Join(validOnMissing, ", "))
 	}
+	switch c.DeleteScope {
+	case "", "all", "managed_only":
+	default:
+		return fmt.Errorf("delete_scope must be one of: all, managed_only")
+	}
+	switch c.SyncMode {
+	case "", "diff", "replace":
+	default:
+		return fmt.Errorf("sync_mode must be one of: diff, replace")
+	}
+	switch c.OnOverlap {
+	case "", "skip", "delay":
+	default:
+		return fmt.Errorf("on_overlap must be one of: skip, delay")
+	}
+	if c.CycleSLO < 0 {
+		return fmt.Errorf("cycle_slo must not be negative")
+	}
+	if c.SyncJitter < 0 {
+		return fmt.Errorf("sync_jitter must not be negative")
+	}
+	if c.SyncJitter > 0 && c.SyncJitter >= c.SyncInterval {
+		return fmt.Errorf("sync_jitter must be shorter than sync_interval")
+	}
+	if c.StartupSplay < 0 {
+		return fmt.Errorf("startup_splay must not be negative")
+	}
+	switch c.Cloudflare.ConflictResolution {
+	case "", "kandji_first", "sources_first", "merge", "skip":
+	default:
+		return fmt.Errorf("conflict_resolution must be one of: kandji_first, sources_first, merge, skip")
+	}
+
+	if err := c.Cloudflare.Comment.Validate(); err != nil {
+		return fmt.Errorf("cloudflare.comment: %w", err)
+	}
+	// Truncated comments keep the managed marker and the expiry stamp
+	if maxLength := c.Cloudflare.Comment.MaxLength; maxLength > 0 && len(c.Cloudflare.ManagedMarker)+stampLength > maxLength {
+		return fmt.Errorf("cloudflare.comment.max_length %d leaves no room for cloudflare.managed_marker and an expiry stamp", maxLength)
+	}
+	if err := c.Cloudflare.Retry.Validate(); err != nil {
+		return fmt.Errorf("cloudflare.retry: %w", err)
+	}
+
+	if c.Expiry.Enabled && c.Expiry.TTL > 0 && len(c.Expiry.Tags) == 0 {
+		return fmt.Errorf("expiry.tags is required when expiry.ttl is set")
+	}
+
+	if err := c.Intune.Validate(); err != nil {
+		return fmt.Errorf("intune: %w", err)
+	}
+	if c.Intune.Enabled && c.Cloudflare.EmailOnly {
+		return fmt.Errorf("intune cannot be used with cloudflare.email_only, it only feeds the target list")
+	}
+	for _, pattern := range c.SourceFiles {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("source_files: invalid pattern %q: %w", pattern, err)
+		}
+	}
+	if len(c.SourceFiles) > 0 && c.Cloudflare.EmailOnly {
+		return fmt.Errorf("source_files cannot be used with cloudflare.email_only, they only feed the target list")
+	}
+	staticSerials := make(map[string]struct{})
+	for i, static := range c.StaticSerials {
+		if strings.TrimSpace(static.SerialNumber) == "" {
+			return fmt.Errorf("static_serials[%d]: serial_number is required", i)
+		}
+		if _, dup := staticSerials[static.SerialNumber]; dup {
+			return fmt.Errorf("static_serials: duplicate serial_number %q", static.SerialNumber)
+		}
+		staticSerials[static.SerialNumber] = struct{}{}
+	}
+	if len(c.StaticSerials) > 0 && c.Cloudflare.EmailOnly {
+		return fmt.Errorf("static_serials cannot be used with cloudflare.email_only, they only feed the target list")
+	}
+	if err := c.Destinations.Tailscale.Validate(); err != nil {
+		return fmt.Errorf("destinations.tailscale: %w", err)
+	}
+	if err := c.Destinations.GoogleSheets.Validate(); err != nil {
+		return fmt.Errorf("destinations.google_sheets: %w", err)
+	}
+	if err := c.Destinations.S3.Validate(); err != nil {
+		return fmt.Errorf("destinations.s3: %w", err)
+	}
+	if err := c.Destinations.KandjiFeedback.Validate(); err != nil {
+		return fmt.Errorf("destinations.kandji_feedback: %w", err)
+	}
+	if err := c.Destinations.BlueprintLists.Validate(); err != nil {
+		return fmt.Errorf("destinations.blueprint_lists: %w", err)
+	}
+	if err := c.Destinations.DeviceEvents.Validate(); err != nil {
+		return fmt.Errorf("destinations.device_events: %w", err)
+	}
+	if err := c.Destinations.CSVDiff.Validate(); err != nil {
+		return fmt.Errorf("destinations.csv_diff: %w", err)
+	}
+	if c.Destinations.IPList.Enabled {
+		if c.Destinations.IPList.ListID == "" {
+			return fmt.Errorf("destinations.ip_list: list_id is required")
+		}
+		if c.Destinations.IPList.ListID == c.Cloudflare.ListID {
+			return fmt.Errorf("destinations.ip_list: list_id cannot be the target list ID")
+		}
+	}
+	if err := c.validateJobs(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Validate checks the Tailscale destination settings when it is enabled.
+func (t *TailscaleConfig) Validate() error {
+	if !t.Enabled {
+		return nil
+	}
+	if t.ApiToken == "" {
+		return fmt.Errorf("TAILSCALE_API_TOKEN is required")
+	}
+	if len(t.Tags) == 0 && !t.Authorize {
+		return fmt.Errorf("at least one of tags or authorize must be set")
+	}
+	for _, tag := range t.Tags {
+		if !strings.HasPrefix(tag, "tag:") {
+			return fmt.Errorf("tag %q must start with \"tag:\"", tag)
+		}
+	}
+	return nil
+}
+
+// Validate checks the Google Sheets destination settings when it is enabled.
+func (g *GoogleSheetsConfig) Validate() error {
+	if !g.Enabled {
+		return nil
+	}
+	if g.SpreadsheetID == "" {
+		return fmt.Errorf("spreadsheet_id is required")
+	}
+	if g.CredentialsFile == "" && os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") == "" {
+		return fmt.Errorf("credentials_file or GOOGLE_APPLICATION_CREDENTIALS is required")
+	}
+	return nil
+}
+
+// Validate checks the S3 settings when they are enabled.
+func (s *S3Config) Validate() error {
+	if !s.Enabled {
+		return nil
+	}
+	if s.Bucket == "" {
+		return fmt.Errorf("bucket is required")
+	}
+	if s.Endpoint != "" && !strings.HasPrefix(s.Endpoint, "https://") && !strings.HasPrefix(s.Endpoint, "http://") {
+		return fmt.Errorf("endpoint must be an http(s) URL")
+	}
+	return nil
+}
+
+// Validate checks the Kandji feedback settings when they are enabled.
+func (k *KandjiFeedbackConfig) Validate() error {
+	if !k.Enabled {
+		return nil
+	}
+	if k.Mode != "tags" && k.Mode != "notes" {
+		return fmt.Errorf("mode must be one of: tags, notes")
+	}
+	if k.Mode == "tags" && k.SyncedTag == k.ErrorTag {
+		return fmt.Errorf("synced_tag and error_tag must differ")
+	}
+	return nil
+}
 
+// validateEmailOnly checks that nothing but the email list is configured, the settings of
+// the serial number lists have no effect when only the email list is maintained.
+func (c *CloudflareConfig) validateEmailOnly() error {
+	if c.EmailListID == "" {
+		return fmt.Errorf("CLOUDFLARE_EMAIL_LIST_ID is required")
+	}
+	if c.ListID != "" || c.TargetListName != "" {
+		return fmt.Errorf("no target list may be set, only the email list is synced")
+	}
+	if len(c.SourceListRefs()) > 0 || len(c.SourceListPatterns) > 0 {
+		return fmt.Errorf("source lists cannot be used, they hold serial numbers")
+	}
+	if len(c.RoutedListRefs()) > 0 || len(c.TagListMapping.Refs()) > 0 {
+		return fmt.Errorf("platform_routing, blueprint_routing and tag_list_mapping cannot be used")
+	}
 	return nil
 }
+
+// PlatformRoutingRefs returns the distinct lists that platform_routing routes devices to,
+// sorted. Each entry is either a list ID or a list name.
+func (c *CloudflareConfig) PlatformRoutingRefs() []string {
+	var refs []string
+	for _, ref := range c.PlatformRouting {
+		if !slices.Contains(refs, ref) {
+			refs = append(refs, ref)
+		}
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+// RoutedListRefs returns the distinct lists that platform_routing or blueprint_routing route
+// devices to, sorted. Each entry is either a list ID or a list name.
+func (c *CloudflareConfig) RoutedListRefs() []string {
+	refs := c.PlatformRoutingRefs()
+	for _, ref := range c.BlueprintRouting {
+		if !slices.Contains(refs, ref) {
+			refs = append(refs, ref)
+		}
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+// BlueprintList returns the list that blueprint_routing routes the devices of a blueprint to.
+// A route keyed by the blueprint's ID takes precedence over one keyed by its name.
+func (c *CloudflareConfig) BlueprintList(blueprintID, blueprintName string) (string, bool) {
+	if ref, ok := c.BlueprintRouting[blueprintID]; ok && blueprintID != "" {
+		return ref, true
+	}
+	if ref, ok := c.BlueprintRouting[blueprintName]; ok && blueprintName != "" {
+		return ref, true
+	}
+	return "", false
+}
+
+// PlatformList returns the list that platform_routing routes the devices of a platform to.
+// Platforms are matched case-insensitively.
+func (c *CloudflareConfig) PlatformList(platform string) (string, bool) {
+	for p, ref := range c.PlatformRouting {
+		if strings.EqualFold(p, platform) {
+			return ref, true
+		}
+	}
+	return "", false
+}
+
+// PlatformAllowed reports whether devices of a Kandji platform pass the platform filter.
+// Platforms are compared case-insensitively. Without kandji.platforms, iPhones and iPads
+// are only synced with sync_mobile_devices.
+func (c *KandjiConfig) PlatformAllowed(platform string) bool {
+	equal := func(p string) bool { return strings.EqualFold(p, platform) }
+	if c.Platforms == nil {
+		return c.SyncMobileDevices || !slices.ContainsFunc(mobilePlatforms, equal)
+	}
+	if slices.ContainsFunc(c.Platforms.Exclude, equal) {
+		return false
+	}
+	return len(c.Platforms.Include) == 0 || slices.ContainsFunc(c.Platforms.Include, equal)
+}
+
+// SourceListRefs returns the configured source lists from source_list_ids and source_lists,
+// without duplicates. Each entry is either a list ID or a list name.
+func (c *CloudflareConfig) SourceListRefs() []string {
+	var refs []string
+	seen := make(map[string]struct{})
+	for _, ref := range append(append([]string{}, c.SourceListIDs...), c.SourceLists...) {
+		ref = strings.TrimSpace(ref)
+		if ref == "" {
+			continue
+		}
+		if _, ok := seen[ref]; ok {
+			continue
+		}
+		seen[ref] = struct{}{}
+		refs = append(refs, ref)
+	}
+	return refs
+}
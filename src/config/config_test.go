@@ -0,0 +1,107 @@
+package config
+
+import "testing"
+
+func TestMaxRemovalsConfigExceeded(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    MaxRemovalsConfig
+		n         int
+		listSize  int
+		wantLimit string
+		want      bool
+	}{
+		{name: "disabled", n: 100, listSize: 100},
+		{name: "at the count", config: MaxRemovalsConfig{Count: 10}, n: 10, listSize: 100},
+		{name: "above the count", config: MaxRemovalsConfig{Count: 10}, n: 11, listSize: 100, wantLimit: "count 10", want: true},
+		{name: "at the percent", config: MaxRemovalsConfig{Percent: 10}, n: 10, listSize: 100},
+		{name: "above the percent", config: MaxRemovalsConfig{Percent: 10}, n: 11, listSize: 100, wantLimit: "percent 10", want: true},
+		{name: "fractional percent", config: MaxRemovalsConfig{Percent: 2.5}, n: 3, listSize: 100, wantLimit: "percent 2.5", want: true},
+		{name: "percent of an empty list", config: MaxRemovalsConfig{Percent: 10}, n: 5, listSize: 0},
+		{name: "count is checked first", config: MaxRemovalsConfig{Count: 5, Percent: 10}, n: 50, listSize: 100, wantLimit: "count 5", want: true},
+		{name: "within both", config: MaxRemovalsConfig{Count: 5, Percent: 10}, n: 5, listSize: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit, got := tt.config.Exceeded(tt.n, tt.listSize)
+			if got != tt.want || limit != tt.wantLimit {
+				t.Errorf("Exceeded(%d, %d) = %q, %v, want %q, %v", tt.n, tt.listSize, limit, got, tt.wantLimit, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxRemovalsConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  MaxRemovalsConfig
+		wantErr bool
+	}{
+		{name: "disabled"},
+		{name: "limits", config: MaxRemovalsConfig{Count: 10, Percent: 100}},
+		{name: "negative count", config: MaxRemovalsConfig{Count: -1}, wantErr: true},
+		{name: "negative percent", config: MaxRemovalsConfig{Percent: -1}, wantErr: true},
+		{name: "percent above 100", config: MaxRemovalsConfig{Percent: 101}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBlueprintFilterMatchName(t *testing.T) {
+	tests := []struct {
+		name      string
+		names     []string
+		blueprint string
+		wantEntry string
+		want      bool
+	}{
+		{name: "exact", names: []string{"Production"}, blueprint: "Production", wantEntry: "Production", want: true},
+		{name: "exact does not match a prefix", names: []string{"Prod"}, blueprint: "Production"},
+		{name: "glob", names: []string{"glob:Prod-*"}, blueprint: "Prod-Design", wantEntry: "glob:Prod-*", want: true},
+		{name: "glob matches the whole name", names: []string{"glob:Prod-*"}, blueprint: "Old Prod-Design"},
+		{name: "glob is case-sensitive", names: []string{"glob:Prod-*"}, blueprint: "prod-design"},
+		{name: "glob star does not match a slash", names: []string{"glob:Prod-*"}, blueprint: "Prod-EU/Design"},
+		{name: "glob across a slash", names: []string{"glob:Prod-*/*"}, blueprint: "Prod-EU/Design", wantEntry: "glob:Prod-*/*", want: true},
+		{name: "star in a name without prefix is literal", names: []string{"Prod-*"}, blueprint: "Prod-Design"},
+		{name: "name with a star", names: []string{"Prod-*"}, blueprint: "Prod-*", wantEntry: "Prod-*", want: true},
+		{name: "name with brackets", names: []string{"Macs [EU]"}, blueprint: "Macs [EU]", wantEntry: "Macs [EU]", want: true},
+		{name: "name with a backslash", names: []string{`Sales\Design`}, blueprint: `Sales\Design`, wantEntry: `Sales\Design`, want: true},
+		{name: "name with a question mark", names: []string{"Test?"}, blueprint: "Tests"},
+		{name: "first matching entry", names: []string{"glob:*-Sandbox", "glob:Prod-*"}, blueprint: "Prod-Sandbox", wantEntry: "glob:*-Sandbox", want: true},
+		{name: "no entries", blueprint: "Production"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := BlueprintFilter{BlueprintNames: tt.names}
+			entry, got := filter.MatchName(tt.blueprint)
+			if got != tt.want || entry != tt.wantEntry {
+				t.Errorf("MatchName(%q) with %q = %q, %v, want %q, %v", tt.blueprint, tt.names, entry, got, tt.wantEntry, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlueprintFilterValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		names   []string
+		wantErr bool
+	}{
+		{name: "names and globs", names: []string{"Production", "glob:Prod-*"}},
+		{name: "name that is not a valid glob", names: []string{"Macs [EU"}},
+		{name: "invalid glob", names: []string{"glob:Macs [EU"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := BlueprintFilter{BlueprintNames: tt.names}
+			if err := filter.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// JobConfig declares an additional sync job, run by the same process as the sync configured
+// at the top level. A job syncs the Kandji inventory to its own target list on its own
+// schedule; the inventory is downloaded once and shared by all jobs. Filters and source
+// lists that are not set are inherited from the top-level settings.
+type JobConfig struct {
+	Name           string        `yaml:"name"`
+	SyncInterval   time.Duration `yaml:"sync_interval"`
+	TargetListID   string        `yaml:"target_list_id"`
+	TargetListName string        `yaml:"target_list_name"`
+	// AccountID and ApiToken sync the job to a list of another Cloudflare account; the
+	// token can also be set with CLOUDFLARE_API_TOKEN_<NAME>, see TokenEnv
+	AccountID    string `yaml:"account_id"`
+	ApiToken     string `yaml:"api_token"`
+	ApiTokenFile string `yaml:"api_token_file"`
+	// SourceLists and SourceListPatterns replace the top-level cloudflare settings if set
+	SourceLists        []string `yaml:"source_lists"`
+	SourceListPatterns []string `yaml:"source_list_patterns"`
+	// The filters replace the corresponding kandji settings if set
+	SyncDevicesWithoutOwners *bool            `yaml:"sync_devices_without_owners"`
+	SyncMobileDevices        *bool            `yaml:"sync_mobile_devices"`
+	Platforms                *PlatformFilter  `yaml:"platforms"`
+	IncludeTags              []string         `yaml:"include_tags"`
+	ExcludeTags              []string         `yaml:"exclude_tags"`
+	BlueprintsInclude        *BlueprintFilter `yaml:"blueprints_include"`
+	BlueprintsExclude        *BlueprintFilter `yaml:"blueprints_exclude"`
+}
+
+// jobNamePattern restricts job names to what can be used in file names and log fields.
+var jobNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+func (j *JobConfig) Validate() error {
+	if !jobNamePattern.MatchString(j.Name) {
+		return fmt.Errorf("name %q must be lowercase letters, digits, - and _", j.Name)
+	}
+	if j.SyncInterval < 0 {
+		return fmt.Errorf("sync_interval cannot be negative")
+	}
+	if j.TargetListID == "" && j.TargetListName == "" {
+		return fmt.Errorf("target_list_id or target_list_name is required")
+	}
+	if j.TargetListID != "" && j.TargetListName != "" {
+		return fmt.Errorf("set only one of target_list_id and target_list_name")
+	}
+	return nil
+}
+
+// TokenEnv returns the environment variable that sets the job's Cloudflare API token, e.g.
+// CLOUDFLARE_API_TOKEN_CUSTOMER_A for the job customer-a.
+func (j JobConfig) TokenEnv() string {
+	return "CLOUDFLARE_API_TOKEN_" + strings.ToUpper(strings.ReplaceAll(j.Name, "-", "_"))
+}
+
+// otherAccount reports whether the job syncs to another Cloudflare account than the
+// top-level sync.
+func (j JobConfig) otherAccount(c *Config) bool {
+	return j.AccountID != "" && j.AccountID != c.Cloudflare.AccountID
+}
+
+// validateJobs checks the jobs against each other and against the top-level sync.
+func (c *Config) validateJobs() error {
+	names := make(map[string]bool)
+	// Lists are told apart by account, the same name can be used in several accounts
+	targets := map[string]bool{
+		c.Cloudflare.AccountID + "/" + c.Cloudflare.ListID:         true,
+		c.Cloudflare.AccountID + "/" + c.Cloudflare.TargetListName: true,
+	}
+	for i := range c.Jobs {
+		job := &c.Jobs[i]
+		if err := job.Validate(); err != nil {
+			return fmt.Errorf("jobs[%d]: %w", i, err)
+		}
+		if names[job.Name] {
+			return fmt.Errorf("jobs[%d]: duplicate name %q", i, job.Name)
+		}
+		names[job.Name] = true
+		target := job.TargetListID + job.TargetListName
+		account := c.Cloudflare.AccountID
+		if job.AccountID != "" {
+			account = job.AccountID
+		}
+		if targets[account+"/"+target] {
+			return fmt.Errorf("jobs[%d]: target list %q is already synced by the top-level sync or another job", i, target)
+		}
+		targets[account+"/"+target] = true
+		if err := c.ForJob(*job).Validate(); err != nil {
+			return fmt.Errorf("jobs[%d] (%s): %w", i, job.Name, err)
+		}
+	}
+	return nil
+}
+
+// ForJob returns the configuration the sync job runs with: the top-level configuration with
+// the job's target list, Cloudflare account, schedule, filters and source lists. Settings that belong to a
+// single list or that the top-level sync owns are not inherited: platform and blueprint
+// routing, the tag list mapping, the owner email list, the Intune source, destinations and
+// the sync webhook are only used by the top-level sync, and the daily digest only covers it. The job keeps its own
+// state store and warm cache next to the top-level ones, named after the job.
+func (c *Config) ForJob(job JobConfig) *Config {
+	cfg := *c
+	cfg.Jobs = nil
+	if job.SyncInterval > 0 {
+		cfg.SyncInterval = job.SyncInterval
+	}
+
+	cfg.Cloudflare.ListID = job.TargetListID
+	cfg.Cloudflare.TargetListName = job.TargetListName
+	// Lists created for jobs configured by ID would otherwise share one name
+	cfg.Cloudflare.NewList.Name = c.Cloudflare.NewList.Name + " (" + job.Name + ")"
+	if job.AccountID != "" {
+		cfg.Cloudflare.AccountID = job.AccountID
+	}
+	if job.ApiToken != "" {
+		cfg.Cloudflare.ApiToken = job.ApiToken
+		if i := slices.IndexFunc(c.Jobs, func(j JobConfig) bool { return j.Name == job.Name }); i >= 0 {
+			cfg.moveSecretOrigin(fmt.Sprintf("jobs[%d].api_token", i), "cloudflare.api_token")
+		}
+	}
+	// A job in another account looks its source lists up there, the top-level ones are not
+	// inherited
+	if job.SourceLists != nil || job.SourceListPatterns != nil || job.otherAccount(c) {
+		cfg.Cloudflare.SourceListIDs = nil
+		cfg.Cloudflare.SourceLists = job.SourceLists
+		cfg.Cloudflare.SourceListPatterns = job.SourceListPatterns
+	}
+	cfg.Cloudflare.PlatformRouting = nil
+	cfg.Cloudflare.BlueprintRouting = nil
+	cfg.Cloudflare.TagListMapping = TagListMapping{}
+	cfg.Cloudflare.EmailListID = ""
+	cfg.Cloudflare.EmailOnly = false
+
+	if job.SyncDevicesWithoutOwners != nil {
+		cfg.Kandji.SyncDevicesWithoutOwners = *job.SyncDevicesWithoutOwners
+	}
+	if job.SyncMobileDevices != nil {
+		cfg.Kandji.SyncMobileDevices = *job.SyncMobileDevices
+		cfg.Kandji.Platforms = nil
+	}
+	if job.Platforms != nil {
+		cfg.Kandji.Platforms = job.Platforms
+	}
+	if job.IncludeTags != nil {
+		cfg.Kandji.IncludeTags = job.IncludeTags
+	}
+	if job.ExcludeTags != nil {
+		cfg.Kandji.ExcludeTags = job.ExcludeTags
+	}
+	if job.BlueprintsInclude != nil {
+		cfg.Kandji.BlueprintsInclude = *job.BlueprintsInclude
+	}
+	if job.BlueprintsExclude != nil {
+		cfg.Kandji.BlueprintsExclude = *job.BlueprintsExclude
+	}
+
+	cfg.Intune.Enabled = false
+	cfg.Destinations = DestinationsConfig{}
+	cfg.Webhook = WebhookConfig{}
+	cfg.Digest = DigestConfig{}
+	cfg.State.Path = jobPath(c.State.Path, job.Name)
+	cfg.WarmCache.Path = jobPath(c.WarmCache.Path, job.Name)
+	return &cfg
+}
+
+// jobPath returns the path of a job's file next to the top-level one, e.g. state.macs.json
+// for state.json. It is empty if path is.
+func jobPath(path, job string) string {
+	if path == "" {
+		return ""
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + job + ext
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// kandjiAPIHostPattern matches the API host of a Kandji tenant in the US or EU region.
+var kandjiAPIHostPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*\.api(\.eu)?\.kandji\.io$`)
+
+// kandjiWebHostPattern matches the web console host of a Kandji tenant, a common mistake for the API URL.
+var kandjiWebHostPattern = regexp.MustCompile(`^([a-z0-9][a-z0-9-]*)\.(eu\.)?kandji\.io$`)
+
+// NormalizeKandjiAPIURL validates a Kandji API URL and returns it in the form the Kandji
+// client expects: https://<tenant>.api[.eu].kandji.io without a trailing slash or /api/v1
+// suffix. A missing scheme is treated as https. Hosts other than kandji.io are accepted
+// as-is for proxies and test servers.
+func NormalizeKandjiAPIURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", fmt.Errorf("KANDJI_API_URL is required")
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid Kandji API URL %q: %w", raw, err)
+	}
+	if u.Scheme != "https" {
+		return "", fmt.Errorf("Kandji API URL %q must use https", raw)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("Kandji API URL %q has no host", raw)
+	}
+	if u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return "", fmt.Errorf("Kandji API URL %q must not contain credentials, a query or a fragment", raw)
+	}
+
+	path := strings.TrimRight(u.Path, "/")
+	path = strings.TrimSuffix(path, "/api/v1")
+	path = strings.TrimSuffix(path, "/api")
+	if path != "" {
+		return "", fmt.Errorf("Kandji API URL %q must not contain a path (got %q); use https://<tenant>.api.kandji.io", raw, u.Path)
+	}
+
+	host := strings.ToLower(u.Host)
+	if match := kandjiWebHostPattern.FindStringSubmatch(host); match != nil && match[1] != "api" {
+		return "", fmt.Errorf("Kandji API URL %q points to the web console; use https://%s.api.%skandji.io", raw, match[1], match[2])
+	}
+	if strings.HasSuffix(host, ".kandji.io") && !kandjiAPIHostPattern.MatchString(host) {
+		return "", fmt.Errorf("Kandji API URL %q does not look like a tenant API host (<tenant>.api.kandji.io or <tenant>.api.eu.kandji.io)", raw)
+	}
+
+	return "https://" + host, nil
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Lint returns non-fatal warnings about the configuration: unknown and deprecated keys,
+// contradictory filters, and risky settings. expectedCycle is the typical duration of a
+// sync cycle, taken from the sync history; zero skips the interval check.
+func (c *Config) Lint(expectedCycle time.Duration) []string {
+	var warnings []string
+
+	if len(c.raw) > 0 {
+		var strict Config
+		if err := yaml.UnmarshalStrict(c.raw, &strict); err != nil {
+			if typeErr, ok := err.(*yaml.TypeError); ok {
+				for _, e := range typeErr.Errors {
+					if strings.Contains(e, "not found in type") {
+						warnings = append(warnings, "unknown key: "+e)
+					}
+				}
+			}
+		}
+	}
+
+	if c.ConfigVersion < CurrentConfigVersion {
+		warnings = append(warnings, fmt.Sprintf("config_version %d is older than the current layout (%d); run migrate-config to upgrade", max(c.ConfigVersion, 1), CurrentConfigVersion))
+	}
+	if len(c.Cloudflare.SourceListIDs) > 0 {
+		warnings = append(warnings, "cloudflare.source_list_ids is deprecated; use cloudflare.source_lists")
+	}
+
+	if c.Kandji.SyncMobileDevices {
+		if c.Kandji.Platforms != nil {
+			warnings = append(warnings, "kandji.sync_mobile_devices is ignored because kandji.platforms is set")
+		} else {
+			warnings = append(warnings, "kandji.sync_mobile_devices is deprecated; use kandji.platforms")
+		}
+	}
+	if c.Kandji.Platforms != nil {
+		for _, platform := range append(append([]string{}, c.Kandji.Platforms.Include...), c.Kandji.Platforms.Exclude...) {
+			if !slices.ContainsFunc(kandjiPlatforms, func(p string) bool { return strings.EqualFold(p, platform) }) {
+				warnings = append(warnings, fmt.Sprintf("kandji.platforms platform %q is not a Kandji platform (%s)", platform, strings.Join(kandjiPlatforms, ", ")))
+			}
+		}
+		for _, platform := range overlap(c.Kandji.Platforms.Include, c.Kandji.Platforms.Exclude) {
+			warnings = append(warnings, fmt.Sprintf("platform %q is in both kandji.platforms.include and kandji.platforms.exclude; the exclusion wins", platform))
+		}
+	}
+
+	for _, tag := range overlap(c.Kandji.IncludeTags, c.Kandji.ExcludeTags) {
+		warnings = append(warnings, fmt.Sprintf("tag %q is in both kandji.include_tags and kandji.exclude_tags; the exclusion wins", tag))
+	}
+	for _, id := range overlap(c.Kandji.BlueprintsInclude.BlueprintIDs, c.Kandji.BlueprintsExclude.BlueprintIDs) {
+		warnings = append(warnings, fmt.Sprintf("blueprint ID %q is both included and excluded", id))
+	}
+	for _, name := range overlap(c.Kandji.BlueprintsInclude.BlueprintNames, c.Kandji.BlueprintsExclude.BlueprintNames) {
+		warnings = append(warnings, fmt.Sprintf("blueprint name %q is both included and excluded", name))
+	}
+	for _, model := range overlap(c.Kandji.ModelsInclude, c.Kandji.ModelsExclude) {
+		warnings = append(warnings, fmt.Sprintf("model pattern %q is in both kandji.models_include and kandji.models_exclude; the exclusion wins", model))
+	}
+	for _, pattern := range overlap(c.Kandji.AssetTagsInclude, c.Kandji.AssetTagsExclude) {
+		warnings = append(warnings, fmt.Sprintf("asset tag pattern %q is in both kandji.asset_tags_include and kandji.asset_tags_exclude; the exclusion wins", pattern))
+	}
+
+	platforms := make([]string, 0, len(c.Cloudflare.PlatformRouting))
+	for platform := range c.Cloudflare.PlatformRouting {
+		platforms = append(platforms, platform)
+	}
+	sort.Strings(platforms)
+	for _, platform := range platforms {
+		if !slices.ContainsFunc(kandjiPlatforms, func(p string) bool { return strings.EqualFold(p, platform) }) {
+			warnings = append(warnings, fmt.Sprintf("cloudflare.platform_routing platform %q is not a Kandji platform (%s)", platform, strings.Join(kandjiPlatforms, ", ")))
+		}
+	}
+
+	minOSPlatforms := make([]string, 0, len(c.Kandji.MinOSVersion))
+	for platform := range c.Kandji.MinOSVersion {
+		minOSPlatforms = append(minOSPlatforms, platform)
+	}
+	sort.Strings(minOSPlatforms)
+	for _, platform := range minOSPlatforms {
+		if !slices.ContainsFunc(kandjiPlatforms, func(p string) bool { return strings.EqualFold(p, platform) }) {
+			warnings = append(warnings, fmt.Sprintf("kandji.min_os_version platform %q is not a Kandji platform (%s)", platform, strings.Join(kandjiPlatforms, ", ")))
+		}
+	}
+
+	blueprints := make([]string, 0, len(c.Cloudflare.BlueprintRouting))
+	for blueprint := range c.Cloudflare.BlueprintRouting {
+		blueprints = append(blueprints, blueprint)
+	}
+	sort.Strings(blueprints)
+	for _, blueprint := range blueprints {
+		_, excludedName := c.Kandji.BlueprintsExclude.MatchName(blueprint)
+		if slices.Contains(c.Kandji.BlueprintsExclude.BlueprintIDs, blueprint) || excludedName {
+			warnings = append(warnings, fmt.Sprintf("cloudflare.blueprint_routing blueprint %q is excluded by kandji.blueprints_exclude; its devices are never routed", blueprint))
+		}
+	}
+
+	if c.OnMissing == "delete" && c.DeleteScope != "managed_only" && !c.MaxRemovals.Enabled() {
+		warnings = append(warnings, "on_missing is delete with delete_scope all and no removal safety threshold; an empty Kandji response would empty the target list")
+	}
+
+	if expectedCycle > 0 && c.SyncInterval < expectedCycle {
+		warnings = append(warnings, fmt.Sprintf("sync_interval %s is shorter than the average cycle duration %s; cycles will run back to back", c.SyncInterval, expectedCycle.Round(time.Second)))
+	}
+
+	return warnings
+}
+
+// kandjiPlatforms are the device platforms reported by Kandji.
+var kandjiPlatforms = []string{"Mac", "iPhone", "iPad", "AppleTV", "Vision"}
+
+// overlap returns the values present in both a and b.
+func overlap(a, b []string) []string {
+	set := make(map[string]struct{}, len(b))
+	for _, v := range b {
+		set[v] = struct{}{}
+	}
+	var both []string
+	for _, v := range a {
+		if _, ok := set[v]; ok {
+			both = append(both, v)
+		}
+	}
+	return both
+}
@@ -0,0 +1,216 @@
+package config
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+
+	"kandji-cloudflare-device-sync/internal/sops"
+)
+
+// CurrentConfigVersion is the config layout version understood by this release. Configs
+// without config_version are version 1.
+const CurrentConfigVersion = 3
+
+// migration upgrades a config document from version from to from+1.
+type migration struct {
+	from        int
+	description string
+	apply       func(doc yaml.MapSlice) (yaml.MapSlice, error)
+}
+
+var migrations = []migration{
+	{
+		from:        1,
+		description: "merge cloudflare.source_list_ids into cloudflare.source_lists",
+		apply:       migrateSourceListIDs,
+	},
+	{
+		from:        2,
+		description: "replace kandji.sync_mobile_devices with kandji.platforms",
+		apply:       migrateSyncMobileDevices,
+	},
+}
+
+// MigrateConfig upgrades a YAML config document to CurrentConfigVersion and returns the
+// upgraded document along with a description of every applied migration. Comments are not
+// preserved.
+func MigrateConfig(data []byte) ([]byte, []string, error) {
+	if sops.IsEncrypted(data) {
+		return nil, nil, fmt.Errorf("the config file is encrypted with sops, decrypt it with sops -d, migrate the plain file and encrypt it again")
+	}
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	version := 1
+	if value, ok := mapValue(doc, "config_version"); ok {
+		v, ok := value.(int)
+		if !ok {
+			return nil, nil, fmt.Errorf("config_version must be an integer")
+		}
+		version = v
+	}
+	if version > CurrentConfigVersion {
+		return nil, nil, fmt.Errorf("config_version %d is newer than this release supports (%d)", version, CurrentConfigVersion)
+	}
+
+	var applied []string
+	for _, m := range migrations {
+		if m.from < version {
+			continue
+		}
+		var err error
+		doc, err = m.apply(doc)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to %s: %w", m.description, err)
+		}
+		version = m.from + 1
+		applied = append(applied, m.description)
+	}
+	doc = setMapValue(doc, "config_version", CurrentConfigVersion)
+
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal config: %w", err)
+	}
+	return out, applied, nil
+}
+
+// migrateSourceListIDs moves the deprecated source_list_ids into source_lists.
+func migrateSourceListIDs(doc yaml.MapSlice) (yaml.MapSlice, error) {
+	value, ok := mapValue(doc, "cloudflare")
+	if !ok {
+		return doc, nil
+	}
+	cloudflare, ok := value.(yaml.MapSlice)
+	if !ok {
+		return nil, fmt.Errorf("cloudflare must be a mapping")
+	}
+
+	ids, hasIDs := mapValue(cloudflare, "source_list_ids")
+	if !hasIDs {
+		return doc, nil
+	}
+	idList, _ := ids.([]interface{})
+	lists, _ := mapValue(cloudflare, "source_lists")
+	merged, _ := lists.([]interface{})
+	seen := make(map[interface{}]bool)
+	for _, ref := range merged {
+		seen[ref] = true
+	}
+	for _, id := range idList {
+		if !seen[id] {
+			merged = append(merged, id)
+			seen[id] = true
+		}
+	}
+
+	cloudflare = deleteMapValue(cloudflare, "source_list_ids")
+	if merged == nil {
+		merged = []interface{}{}
+	}
+	cloudflare = setMapValue(cloudflare, "source_lists", merged)
+	return setMapValue(doc, "cloudflare", cloudflare), nil
+}
+
+// migrateSyncMobileDevices replaces sync_mobile_devices of the kandji section and of every
+// job with the equivalent platforms filter. The kandji section gets one even without
+// sync_mobile_devices, since its default excluded mobile devices; jobs without it inherit.
+func migrateSyncMobileDevices(doc yaml.MapSlice) (yaml.MapSlice, error) {
+	kandji := yaml.MapSlice{}
+	if value, ok := mapValue(doc, "kandji"); ok && value != nil {
+		section, ok := value.(yaml.MapSlice)
+		if !ok {
+			return nil, fmt.Errorf("kandji must be a mapping")
+		}
+		kandji = section
+	}
+	kandji, err := replaceSyncMobileDevices(kandji, true)
+	if err != nil {
+		return nil, fmt.Errorf("kandji: %w", err)
+	}
+	doc = setMapValue(doc, "kandji", kandji)
+
+	value, ok := mapValue(doc, "jobs")
+	if !ok || value == nil {
+		return doc, nil
+	}
+	jobs, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("jobs must be a list")
+	}
+	for i, value := range jobs {
+		job, ok := value.(yaml.MapSlice)
+		if !ok {
+			return nil, fmt.Errorf("jobs[%d] must be a mapping", i)
+		}
+		if jobs[i], err = replaceSyncMobileDevices(job, false); err != nil {
+			return nil, fmt.Errorf("jobs[%d]: %w", i, err)
+		}
+	}
+	return setMapValue(doc, "jobs", jobs), nil
+}
+
+// replaceSyncMobileDevices replaces sync_mobile_devices in a section with a platforms
+// filter that syncs the same devices: every platform if it was true, all but iPhones and
+// iPads if it was false, or if it was unset and withDefault is set. An existing platforms
+// filter is kept, it already took precedence.
+func replaceSyncMobileDevices(section yaml.MapSlice, withDefault bool) (yaml.MapSlice, error) {
+	value, ok := mapValue(section, "sync_mobile_devices")
+	if !ok && !withDefault {
+		return section, nil
+	}
+	syncMobile := false
+	if ok && value != nil {
+		b, isBool := value.(bool)
+		if !isBool {
+			return nil, fmt.Errorf("sync_mobile_devices must be a boolean")
+		}
+		syncMobile = b
+	}
+	section = deleteMapValue(section, "sync_mobile_devices")
+	if _, ok := mapValue(section, "platforms"); ok {
+		return section, nil
+	}
+	platforms := yaml.MapSlice{}
+	if !syncMobile {
+		exclude := make([]interface{}, 0, len(mobilePlatforms))
+		for _, platform := range mobilePlatforms {
+			exclude = append(exclude, platform)
+		}
+		platforms = yaml.MapSlice{{Key: "exclude", Value: exclude}}
+	}
+	return append(section, yaml.MapItem{Key: "platforms", Value: platforms}), nil
+}
+
+func mapValue(m yaml.MapSlice, key string) (interface{}, bool) {
+	for _, item := range m {
+		if item.Key == key {
+			return item.Value, true
+		}
+	}
+	return nil, false
+}
+
+// setMapValue replaces the value of key in place, or prepends the key if it is missing.
+func setMapValue(m yaml.MapSlice, key string, value interface{}) yaml.MapSlice {
+	for i, item := range m {
+		if item.Key == key {
+			m[i].Value = value
+			return m
+		}
+	}
+	return append(yaml.MapSlice{{Key: key, Value: value}}, m...)
+}
+
+func deleteMapValue(m yaml.MapSlice, key string) yaml.MapSlice {
+	out := make(yaml.MapSlice, 0, len(m))
+	for _, item := range m {
+		if item.Key != key {
+			out = append(out, item)
+		}
+	}
+	return out
+}
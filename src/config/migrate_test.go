@@ -0,0 +1,140 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMigrateConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		in          string
+		want        string
+		wantApplied int
+		wantErr     string
+	}{
+		{
+			name: "version 1 merges source_list_ids and adds the default platforms",
+			in: `cloudflare:
+  source_lists: [a, b]
+  source_list_ids: [b, c]
+`,
+			want: `config_version: 3
+kandji:
+  platforms:
+    exclude:
+    - iPhone
+    - iPad
+cloudflare:
+  source_lists:
+  - a
+  - b
+  - c
+`,
+			wantApplied: 2,
+		},
+		{
+			name: "sync_mobile_devices true syncs every platform",
+			in: `config_version: 2
+kandji:
+  sync_mobile_devices: true
+`,
+			want: `config_version: 3
+kandji:
+  platforms: {}
+`,
+			wantApplied: 1,
+		},
+		{
+			name: "an existing platforms filter is kept",
+			in: `config_version: 2
+kandji:
+  sync_mobile_devices: false
+  platforms:
+    include: [Mac]
+`,
+			want: `config_version: 3
+kandji:
+  platforms:
+    include:
+    - Mac
+`,
+			wantApplied: 1,
+		},
+		{
+			name: "jobs without sync_mobile_devices inherit",
+			in: `config_version: 2
+kandji:
+  sync_mobile_devices: true
+jobs:
+- name: a
+  sync_mobile_devices: false
+- name: b
+`,
+			want: `config_version: 3
+kandji:
+  platforms: {}
+jobs:
+- name: a
+  platforms:
+    exclude:
+    - iPhone
+    - iPad
+- name: b
+`,
+			wantApplied: 1,
+		},
+		{
+			name: "current version is left alone",
+			in: `config_version: 3
+sync_interval: 5m
+`,
+			want: `config_version: 3
+sync_interval: 5m
+`,
+		},
+		{
+			name:    "newer version",
+			in:      "config_version: 4\n",
+			wantErr: "newer than this release supports",
+		},
+		{
+			name:    "version is not an integer",
+			in:      "config_version: two\n",
+			wantErr: "config_version must be an integer",
+		},
+		{
+			name: "sync_mobile_devices is not a boolean",
+			in: `config_version: 2
+kandji:
+  sync_mobile_devices: sometimes
+`,
+			wantErr: "sync_mobile_devices must be a boolean",
+		},
+		{
+			name:    "encrypted with sops",
+			in:      "kandji:\n  api_token: ENC[AES256_GCM,data:abc,type:str]\nsops:\n  version: 3.8.1\n  mac: ENC[AES256_GCM,data:abc,type:str]\n  lastmodified: \"2024-01-01T00:00:00Z\"\n",
+			wantErr: "encrypted with sops",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, applied, err := MigrateConfig([]byte(tt.in))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("MigrateConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("MigrateConfig() error = %v", err)
+			}
+			if string(out) != tt.want {
+				t.Errorf("MigrateConfig() =\n%s\nwant\n%s", out, tt.want)
+			}
+			if len(applied) != tt.wantApplied {
+				t.Errorf("MigrateConfig() applied %q, want %d migrations", applied, tt.wantApplied)
+			}
+		})
+	}
+}
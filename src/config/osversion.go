@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseOSVersion parses an OS version as reported by Kandji, such as "14.4.1" or
+// "16.5.1 (c)", into its numeric components. Anything after the first space, such as the
+// suffix of a Rapid Security Response, is ignored.
+func ParseOSVersion(version string) ([]int, error) {
+	fields := strings.Fields(version)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty OS version")
+	}
+	parts := strings.Split(fields[0], ".")
+	components := make([]int, 0, len(parts))
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid OS version %q", version)
+		}
+		components = append(components, n)
+	}
+	return components, nil
+}
+
+// compareOSVersions returns -1, 0 or 1 as a is lower than, equal to or higher than b.
+// Missing components count as zero, so "14" equals "14.0.0".
+func compareOSVersions(a, b []int) int {
+	for i := 0; i < max(len(a), len(b)); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// MinOSVersionFor returns the minimum OS version configured for a Kandji platform. Platforms
+// are compared case-insensitively.
+func (c *KandjiConfig) MinOSVersionFor(platform string) (string, bool) {
+	for p, version := range c.MinOSVersion {
+		if strings.EqualFold(p, platform) {
+			return version, true
+		}
+	}
+	return "", false
+}
+
+// MeetsMinOSVersion reports whether a device of the platform running osVersion satisfies
+// min_os_version. Devices of platforms without a minimum always do; devices whose OS
+// version cannot be parsed never do when there is one.
+func (c *KandjiConfig) MeetsMinOSVersion(platform, osVersion string) bool {
+	minimum, ok := c.MinOSVersionFor(platform)
+	if !ok {
+		return true
+	}
+	want, err := ParseOSVersion(minimum)
+	if err != nil {
+		return false
+	}
+	have, err := ParseOSVersion(osVersion)
+	if err != nil {
+		return false
+	}
+	return compareOSVersions(have, want) >= 0
+}
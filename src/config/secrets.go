@@ -0,0 +1,441 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"kandji-cloudflare-device-sync/internal/awsauth"
+	"kandji-cloudflare-device-sync/internal/azureauth"
+	"kandji-cloudflare-device-sync/secrets"
+)
+
+// SecretsConfig holds settings for the secret backends that secret settings, such as the API
+// tokens, may refer to instead of holding the secret, as in vault:secret/data/kandji#api_token,
+// aws-sm:arn:aws:secretsmanager:eu-west-1:123456789012:secret:kandji-AbCdEf,
+// gcp-sm://projects/acme/secrets/kandji/versions/latest or
+// azure-kv://acme.vault.azure.net/secrets/kandji-api-token.
+type SecretsConfig struct {
+	// RefreshInterval is how often the service reads the referenced secrets again; a changed
+	// secret reloads the configuration. 0 turns it off
+	RefreshInterval time.Duration      `yaml:"refresh_interval"`
+	Vault           VaultConfig        `yaml:"vault"`
+	AWS             AWSSecretsConfig   `yaml:"aws"`
+	GCP             GCPSecretsConfig   `yaml:"gcp"`
+	Azure           AzureSecretsConfig `yaml:"azure"`
+}
+
+// VaultConfig holds settings for reading vault: references from HashiCorp Vault.
+type VaultConfig struct {
+	Address   string `yaml:"address"`
+	Namespace string `yaml:"namespace"`
+	// AuthMethod is token, approle or kubernetes
+	AuthMethod string `yaml:"auth_method"`
+	Token      string `yaml:"token"`
+	// AuthMount is the path the auth method is mounted at, its name by default
+	AuthMount           string `yaml:"auth_mount"`
+	RoleID              string `yaml:"role_id"`
+	SecretID            string `yaml:"secret_id"`
+	Role                string `yaml:"role"`
+	KubernetesTokenPath string `yaml:"kubernetes_token_path"`
+}
+
+// AWSSecretsConfig holds settings for reading aws-sm: references from AWS Secrets Manager.
+// Credentials not set here are read from the standard AWS environment variables.
+type AWSSecretsConfig struct {
+	// Region is the region of secrets referenced by name, also AWS_REGION
+	Region string `yaml:"region"`
+	// Endpoint replaces the regional Secrets Manager endpoint, e.g. for a VPC endpoint
+	Endpoint        string `yaml:"endpoint"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+}
+
+func (a *AWSSecretsConfig) Validate() error {
+	if !a.credentials().Valid() {
+		return fmt.Errorf("access_key_id and secret_access_key, or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, are required")
+	}
+	if a.Endpoint != "" {
+		if u, err := url.Parse(a.Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("endpoint must be an absolute URL")
+		}
+	}
+	return nil
+}
+
+// credentials returns the configured AWS credentials, completed from the environment.
+func (a *AWSSecretsConfig) credentials() awsauth.Credentials {
+	return awsauth.CredentialsFromEnv(awsauth.Credentials{
+		AccessKeyID:     a.AccessKeyID,
+		SecretAccessKey: a.SecretAccessKey,
+	})
+}
+
+// GCPSecretsConfig holds settings for reading gcp-sm: references from Google Cloud Secret
+// Manager.
+type GCPSecretsConfig struct {
+	// CredentialsFile is a service account key or gcloud user credentials; Application
+	// Default Credentials are used if empty, e.g. the Workload Identity of a GKE pod
+	CredentialsFile string `yaml:"credentials_file"`
+	// Endpoint replaces https://secretmanager.googleapis.com, e.g. with a regional endpoint
+	Endpoint string `yaml:"endpoint"`
+}
+
+func (g *GCPSecretsConfig) Validate() error {
+	if g.CredentialsFile != "" {
+		if _, err := os.Stat(g.CredentialsFile); err != nil {
+			return fmt.Errorf("credentials_file: %w", err)
+		}
+	}
+	if g.Endpoint != "" {
+		if u, err := url.Parse(g.Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("endpoint must be an absolute URL")
+		}
+	}
+	return nil
+}
+
+// AzureSecretsConfig holds settings for reading azure-kv: references from Azure Key Vault.
+// Without a client secret or federated token file, which AKS workload identity sets through
+// AZURE_FEDERATED_TOKEN_FILE, the managed identity of the VM or node is used.
+type AzureSecretsConfig struct {
+	TenantID string `yaml:"tenant_id"`
+	// ClientID is the application of a workload identity or service principal, or the
+	// user-assigned managed identity to use
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+}
+
+func (s *SecretsConfig) Validate() error {
+	if s.RefreshInterval < 0 {
+		return fmt.Errorf("refresh_interval cannot be negative")
+	}
+	if s.RefreshInterval > 0 && s.RefreshInterval < 10*time.Second {
+		return fmt.Errorf("refresh_interval must be at least 10s")
+	}
+	return nil
+}
+
+// validateBackend checks the settings of the backend of a reference scheme. Backends are
+// only checked if a setting refers to them.
+func (s *SecretsConfig) validateBackend(scheme string) error {
+	switch scheme {
+	case "vault":
+		if err := s.Vault.Validate(); err != nil {
+			return fmt.Errorf("vault: %w", err)
+		}
+	case "aws-sm":
+		if err := s.AWS.Validate(); err != nil {
+			return fmt.Errorf("aws: %w", err)
+		}
+	case "gcp-sm":
+		if err := s.GCP.Validate(); err != nil {
+			return fmt.Errorf("gcp: %w", err)
+		}
+	}
+	return nil
+}
+
+func (v *VaultConfig) Validate() error {
+	if v.Address == "" {
+		return fmt.Errorf("address or VAULT_ADDR is required")
+	}
+	switch v.AuthMethod {
+	case secrets.VaultAuthToken:
+		if v.Token == "" {
+			return fmt.Errorf("token or VAULT_TOKEN is required with auth_method token")
+		}
+	case secrets.VaultAuthAppRole:
+		if v.RoleID == "" || v.SecretID == "" {
+			return fmt.Errorf("role_id and secret_id are required with auth_method approle")
+		}
+	case secrets.VaultAuthKubernetes:
+		if v.Role == "" {
+			return fmt.Errorf("role is required with auth_method kubernetes")
+		}
+	default:
+		return fmt.Errorf("auth_method must be one of: token, approle, kubernetes")
+	}
+	return nil
+}
+
+// applySecretsEnv overrides the secret backend settings with the environment variables of
+// their clients.
+func (c *Config) applySecretsEnv() {
+	if address := os.Getenv("VAULT_ADDR"); address != "" {
+		c.Secrets.Vault.Address = address
+	}
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		c.Secrets.Vault.Token = token
+	}
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		c.Secrets.Vault.Namespace = namespace
+	}
+	if roleID := os.Getenv("VAULT_ROLE_ID"); roleID != "" {
+		c.Secrets.Vault.RoleID = roleID
+	}
+	if secretID := os.Getenv("VAULT_SECRET_ID"); secretID != "" {
+		c.Secrets.Vault.SecretID = secretID
+	}
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		c.Secrets.AWS.Region = region
+	} else if region := os.Getenv("AWS_DEFAULT_REGION"); region != "" && c.Secrets.AWS.Region == "" {
+		c.Secrets.AWS.Region = region
+	}
+}
+
+// secretField is a secret setting. file is its *_file setting, and env the environment
+// variable that overrides it, if any.
+type secretField struct {
+	name  string
+	value *string
+	file  *string
+	env   string
+}
+
+// secretFields returns the secret settings.
+func (c *Config) secretFields() []secretField {
+	fields := []secretField{
+		{"kandji.api_token", &c.Kandji.ApiToken, &c.Kandji.ApiTokenFile, "KANDJI_API_TOKEN"},
+		{"cloudflare.api_token", &c.Cloudflare.ApiToken, &c.Cloudflare.ApiTokenFile, "CLOUDFLARE_API_TOKEN"},
+		{"intune.client_secret", &c.Intune.ClientSecret, &c.Intune.ClientSecretFile, "INTUNE_CLIENT_SECRET"},
+		{"mosyle.access_token", &c.Mosyle.AccessToken, &c.Mosyle.AccessTokenFile, "MOSYLE_ACCESS_TOKEN"},
+		{"mosyle.password", &c.Mosyle.Password, &c.Mosyle.PasswordFile, "MOSYLE_PASSWORD"},
+		{"admin.token", &c.Admin.Token, &c.Admin.TokenFile, "ADMIN_TOKEN"},
+		{"smtp.password", &c.SMTP.Password, &c.SMTP.PasswordFile, "SMTP_PASSWORD"},
+		{"notifications.slack.webhook_url", &c.Notifications.Slack.WebhookURL, &c.Notifications.Slack.WebhookURLFile, "SLACK_WEBHOOK_URL"},
+		{"notifications.pagerduty.routing_key", &c.Notifications.PagerDuty.RoutingKey, &c.Notifications.PagerDuty.RoutingKeyFile, "PAGERDUTY_ROUTING_KEY"},
+		{"notifications.webhook.secret", &c.Notifications.Webhook.Secret, &c.Notifications.Webhook.SecretFile, "NOTIFICATIONS_WEBHOOK_SECRET"},
+		{"destinations.tailscale.api_token", &c.Destinations.Tailscale.ApiToken, &c.Destinations.Tailscale.ApiTokenFile, "TAILSCALE_API_TOKEN"},
+		{"destinations.s3.secret_access_key", &c.Destinations.S3.SecretAccessKey, &c.Destinations.S3.SecretAccessKeyFile, ""},
+		{"destinations.csv_diff.s3.secret_access_key", &c.Destinations.CSVDiff.S3.SecretAccessKey, &c.Destinations.CSVDiff.S3.SecretAccessKeyFile, ""},
+		{"destinations.device_events.nats.token", &c.Destinations.DeviceEvents.NATS.Token, &c.Destinations.DeviceEvents.NATS.TokenFile, "NATS_TOKEN"},
+		{"destinations.device_events.nats.password", &c.Destinations.DeviceEvents.NATS.Password, &c.Destinations.DeviceEvents.NATS.PasswordFile, ""},
+		{"destinations.device_events.kafka.password", &c.Destinations.DeviceEvents.Kafka.Password, &c.Destinations.DeviceEvents.Kafka.PasswordFile, "KAFKA_REST_PROXY_PASSWORD"},
+		{"leader_election.redis.password", &c.LeaderElection.Redis.Password, &c.LeaderElection.Redis.PasswordFile, "LEADER_ELECTION_REDIS_PASSWORD"},
+	}
+	for i := range c.Kandji.Tenants {
+		tenant := &c.Kandji.Tenants[i]
+		fields = append(fields, secretField{TenantTokenSetting(i), &tenant.ApiToken, &tenant.ApiTokenFile, tenant.TokenEnv()})
+	}
+	for i := range c.Jobs {
+		job := &c.Jobs[i]
+		fields = append(fields, secretField{fmt.Sprintf("jobs[%d].api_token", i), &job.ApiToken, &job.ApiTokenFile, job.TokenEnv()})
+	}
+	for i := range c.Webhook.Tokens {
+		token := &c.Webhook.Tokens[i]
+		fields = append(fields, secretField{fmt.Sprintf("webhook.tokens[%d].token", i), &token.Token, &token.TokenFile, ""})
+	}
+	return fields
+}
+
+// applySecretFilesEnv applies the <VARIABLE>_FILE environment variables, which name a file
+// holding the secret of <VARIABLE>, as Docker secrets are passed to official images. Like
+// the secret variables, they override the secret settings of the config file.
+func (c *Config) applySecretFilesEnv() error {
+	if path := os.Getenv("WEBHOOK_TOKEN_FILE"); path != "" {
+		if os.Getenv("WEBHOOK_TOKEN") != "" {
+			return fmt.Errorf("WEBHOOK_TOKEN and WEBHOOK_TOKEN_FILE cannot both be set")
+		}
+		c.Webhook.Tokens = append(c.Webhook.Tokens, WebhookToken{Name: "env", TokenFile: path})
+	}
+	for _, field := range c.secretFields() {
+		if field.env == "" {
+			continue
+		}
+		path := os.Getenv(field.env + "_FILE")
+		switch {
+		case path != "" && os.Getenv(field.env) != "":
+			return fmt.Errorf("%s and %s_FILE cannot both be set", field.env, field.env)
+		case path != "":
+			*field.value, *field.file = "", path
+		case os.Getenv(field.env) != "":
+			*field.file = ""
+		}
+	}
+	return nil
+}
+
+// readSecretFiles sets the secret settings with a *_file setting to the contents of the
+// file, without leading and trailing whitespace such as the final newline. The files are
+// kept for SecretsChanged, so that a rotated Kubernetes secret is picked up.
+func (c *Config) readSecretFiles() error {
+	c.secretFiles = make(map[string]string)
+	for _, field := range c.secretFields() {
+		if *field.file == "" {
+			continue
+		}
+		if *field.value != "" {
+			return fmt.Errorf("%s and %s_file cannot both be set", field.name, field.name)
+		}
+		secret, err := readSecretFile(*field.file)
+		if err != nil {
+			return fmt.Errorf("%s_file: %w", field.name, err)
+		}
+		*field.value = secret
+		c.secretFiles[field.name] = *field.file
+	}
+	return nil
+}
+
+// readSecretFile returns the trimmed contents of a secret file.
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimSpace(string(data))
+	if secret == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return secret, nil
+}
+
+// resolveSecrets replaces the secret references of the secret settings with the secrets
+// they refer to. The references, and the backends with their logins, are kept for
+// SecretsChanged.
+func (c *Config) resolveSecrets(ctx context.Context) error {
+	c.secretRefs = make(map[string]string)
+	for _, field := range c.secretFields() {
+		scheme, _, ok := secrets.Reference(*field.value)
+		if !ok {
+			continue
+		}
+		if err := c.Secrets.validateBackend(scheme); err != nil {
+			return fmt.Errorf("%s: secrets: %w", field.name, err)
+		}
+		c.secretRefs[field.name] = *field.value
+	}
+	if len(c.secretRefs) == 0 {
+		return nil
+	}
+
+	c.resolver = c.secretResolver()
+	for _, field := range c.secretFields() {
+		ref, ok := c.secretRefs[field.name]
+		if !ok {
+			continue
+		}
+		secret, err := c.resolver.Resolve(ctx, ref)
+		if err != nil {
+			return fmt.Errorf("%s: %w", field.name, err)
+		}
+		*field.value = secret
+	}
+	return nil
+}
+
+// secretResolver returns the resolver of the configured secret backends.
+func (c *Config) secretResolver() *secrets.Resolver {
+	resolver := secrets.NewResolver()
+	if c.Secrets.Vault.Address != "" {
+		resolver.Register("vault", secrets.NewVault(secrets.VaultOptions{
+			Address:             c.Secrets.Vault.Address,
+			Namespace:           c.Secrets.Vault.Namespace,
+			AuthMethod:          c.Secrets.Vault.AuthMethod,
+			Token:               c.Secrets.Vault.Token,
+			AuthMount:           c.Secrets.Vault.AuthMount,
+			RoleID:              c.Secrets.Vault.RoleID,
+			SecretID:            c.Secrets.Vault.SecretID,
+			Role:                c.Secrets.Vault.Role,
+			KubernetesTokenPath: c.Secrets.Vault.KubernetesTokenPath,
+		}))
+	}
+	resolver.Register("aws-sm", secrets.NewAWSSecretsManager(secrets.AWSSecretsManagerOptions{
+		Region:      c.Secrets.AWS.Region,
+		Endpoint:    c.Secrets.AWS.Endpoint,
+		Credentials: c.Secrets.AWS.credentials(),
+	}))
+	resolver.Register("gcp-sm", secrets.NewGCPSecretManager(secrets.GCPSecretManagerOptions{
+		CredentialsFile: c.Secrets.GCP.CredentialsFile,
+		Endpoint:        c.Secrets.GCP.Endpoint,
+	}))
+	resolver.Register("azure-kv", secrets.NewAzureKeyVault(azureauth.Options{
+		TenantID:     c.Secrets.Azure.TenantID,
+		ClientID:     c.Secrets.Azure.ClientID,
+		ClientSecret: c.Secrets.Azure.ClientSecret,
+	}))
+	return resolver
+}
+
+// HasSecretRefs reports whether any setting was read from a secret backend or a secret file.
+func (c *Config) HasSecretRefs() bool {
+	return len(c.secretRefs) > 0 || len(c.secretFiles) > 0
+}
+
+// SecretsChanged reads the secret references and secret files of the configuration again and
+// reports whether any secret differs from the one the configuration holds.
+func (c *Config) SecretsChanged(ctx context.Context) (bool, error) {
+	if !c.HasSecretRefs() {
+		return false, nil
+	}
+	for _, field := range c.secretFields() {
+		source := c.SecretSource(field.name)
+		if source == nil {
+			continue
+		}
+		secret, err := source(ctx)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", field.name, err)
+		}
+		if secret != *field.value {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// TenantTokenSetting is the name of the API token setting of the i-th Kandji tenant, as
+// taken by SecretOrigin and SecretSource.
+func TenantTokenSetting(i int) string {
+	return fmt.Sprintf("kandji.tenants[%d].api_token", i)
+}
+
+// SecretOrigin returns the secret reference or the file a secret setting, such as
+// kandji.api_token, was read from, empty if the setting held the secret itself.
+func (c *Config) SecretOrigin(name string) string {
+	if ref, ok := c.secretRefs[name]; ok {
+		return ref
+	}
+	if path, ok := c.secretFiles[name]; ok {
+		return "file:" + path
+	}
+	return ""
+}
+
+// SecretSource returns a function that reads the secret of a secret setting again from its
+// secret reference or file, so that clients can pick up a rotated token. It is nil if the
+// setting held the secret itself.
+func (c *Config) SecretSource(name string) func(ctx context.Context) (string, error) {
+	if ref, ok := c.secretRefs[name]; ok {
+		resolver := c.resolver
+		return func(ctx context.Context) (string, error) {
+			return resolver.Resolve(ctx, ref)
+		}
+	}
+	if path, ok := c.secretFiles[name]; ok {
+		return func(context.Context) (string, error) {
+			return readSecretFile(path)
+		}
+	}
+	return nil
+}
+
+// moveSecretOrigin makes setting to of a derived configuration read from the origin of
+// setting from, whose secret it holds.
+func (c *Config) moveSecretOrigin(from, to string) {
+	refs, files := maps.Clone(c.secretRefs), maps.Clone(c.secretFiles)
+	delete(refs, to)
+	delete(files, to)
+	if ref, ok := refs[from]; ok {
+		refs[to] = ref
+	}
+	if path, ok := files[from]; ok {
+		files[to] = path
+	}
+	c.secretRefs, c.secretFiles = refs, files
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// TagMatcher matches Kandji tags or asset tags against the patterns of filters such as
+// include_tags or asset_tags_exclude. A pattern enclosed in slashes, such as "/^corp-/", is
+// a regular expression matched anywhere in the tag unless anchored; one with *, ? or [ is a
+// glob such as "vpn-*", matching the whole tag; any other pattern matches exactly.
+type TagMatcher struct {
+	exact   map[string]struct{}
+	globs   []string
+	regexps []*regexp.Regexp
+}
+
+// CompileTagPatterns compiles tag patterns into a TagMatcher.
+func CompileTagPatterns(patterns []string) (*TagMatcher, error) {
+	m := &TagMatcher{exact: make(map[string]struct{})}
+	for _, pattern := range patterns {
+		switch {
+		case len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/"):
+			re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid tag pattern %q: %w", pattern, err)
+			}
+			m.regexps = append(m.regexps, re)
+		case strings.ContainsAny(pattern, "*?["):
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid tag pattern %q: %w", pattern, err)
+			}
+			m.globs = append(m.globs, pattern)
+		default:
+			m.exact[pattern] = struct{}{}
+		}
+	}
+	return m, nil
+}
+
+// Empty reports whether the matcher has no patterns.
+func (m *TagMatcher) Empty() bool {
+	return len(m.exact) == 0 && len(m.globs) == 0 && len(m.regexps) == 0
+}
+
+// MatchAny reports whether any of the tags matches one of the patterns.
+func (m *TagMatcher) MatchAny(tags []string) bool {
+	for _, tag := range tags {
+		if m.Match(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match reports whether the tag matches one of the patterns.
+func (m *TagMatcher) Match(tag string) bool {
+	if _, ok := m.exact[tag]; ok {
+		return true
+	}
+	for _, glob := range m.globs {
+		if ok, _ := path.Match(glob, tag); ok {
+			return true
+		}
+	}
+	for _, re := range m.regexps {
+		if re.MatchString(tag) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package config
+
+import "testing"
+
+func TestTagMatcher(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		tag      string
+		want     bool
+	}{
+		{name: "exact", patterns: []string{"vpn"}, tag: "vpn", want: true},
+		{name: "exact is case-sensitive", patterns: []string{"vpn"}, tag: "VPN"},
+		{name: "exact does not match a prefix", patterns: []string{"vpn"}, tag: "vpn-eu"},
+		{name: "glob", patterns: []string{"vpn-*"}, tag: "vpn-eu", want: true},
+		{name: "glob matches the whole tag", patterns: []string{"vpn-*"}, tag: "corp-vpn-eu"},
+		{name: "glob star does not match a slash", patterns: []string{"vpn-*"}, tag: "vpn-eu/berlin"},
+		{name: "glob single character", patterns: []string{"floor-?"}, tag: "floor-3", want: true},
+		{name: "glob character class", patterns: []string{"floor-[0-4]"}, tag: "floor-5"},
+		{name: "regexp matches anywhere", patterns: []string{"/vpn/"}, tag: "corp-vpn-eu", want: true},
+		{name: "anchored regexp", patterns: []string{"/^corp-/"}, tag: "old-corp-laptop"},
+		{name: "any pattern", patterns: []string{"kiosk", "/^corp-/", "vpn-*"}, tag: "corp-laptop", want: true},
+		{name: "no patterns", tag: "vpn"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := CompileTagPatterns(tt.patterns)
+			if err != nil {
+				t.Fatalf("CompileTagPatterns() error = %v", err)
+			}
+			if got := m.Match(tt.tag); got != tt.want {
+				t.Errorf("Match(%q) with %q = %v, want %v", tt.tag, tt.patterns, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileTagPatternsInvalid(t *testing.T) {
+	for _, pattern := range []string{"vpn-[", "/(/"} {
+		if _, err := CompileTagPatterns([]string{pattern}); err == nil {
+			t.Errorf("CompileTagPatterns(%q) succeeded, want error", pattern)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package destination
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"kandji-cloudflare-device-sync/cloudflare"
+	"kandji-cloudflare-device-sync/config"
+)
+
+// blueprintListDescriptionPrefix marks the lists created and maintained by this destination.
+const blueprintListDescriptionPrefix = "Managed by kandji-cloudflare-device-sync for blueprint "
+
+// BlueprintLists maintains one Cloudflare SERIAL list per Kandji blueprint and routes every
+// synced Kandji device to the list of its blueprint.
+type BlueprintLists struct {
+	nameTemplate string
+	marker       string
+	batchSize    int
+	cf           *cloudflare.Client
+	log          *slog.Logger
+}
+
+// NewBlueprintLists creates a new per-blueprint list destination.
+func NewBlueprintLists(cfg config.BlueprintListsConfig, marker string, batchSize int, cloudflareClient *cloudflare.Client, log *slog.Logger) *BlueprintLists {
+	return &BlueprintLists{
+		nameTemplate: cfg.NameTemplate,
+		marker:       marker,
+		batchSize:    batchSize,
+		cf:           cloudflareClient,
+		log:          log,
+	}
+}
+
+// Name returns the destination name used in logs.
+func (b *BlueprintLists) Name() string {
+	return "blueprint_lists"
+}
+
+// Publish creates the lists of new blueprints, brings the lists of existing blueprints in
+// line with their devices, and empties managed lists that no longer have a blueprint.
+func (b *BlueprintLists) Publish(ctx context.Context, snapshot *Snapshot) error {
+	byList := make(map[string][]Device) // list name -> devices
+	blueprints := make(map[string]string)
+	skipped := 0
+	for _, device := range snapshot.Devices {
+		if device.Source != "kandji" {
+			continue
+		}
+		if device.Blueprint == "" {
+			skipped++
+			continue
+		}
+		name := b.listName(device.Blueprint)
+		byList[name] = append(byList[name], device)
+		blueprints[name] = device.Blueprint
+	}
+	if skipped > 0 {
+		b.log.Debug("Devices without a blueprint are not routed to a blueprint list", "count", skipped)
+	}
+
+	lists, err := b.cf.ListLists(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list Cloudflare lists: %w", err)
+	}
+	existing := make(map[string]cloudflare.GatewayList)
+	for _, list := range lists {
+		if list.Type == b.cf.DeviceListType() {
+			existing[list.Name] = list
+		}
+	}
+
+	names := make([]string, 0, len(byList))
+	for name := range byList {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var created, updated, failed int
+	for _, name := range names {
+		devices := byList[name]
+		list, ok := existing[name]
+		if !ok {
+			_, err := b.cf.CreateList(ctx, cloudflare.GatewayListCreateRequest{
+				Name:        name,
+				Description: blueprintListDescriptionPrefix + blueprints[name],
+				Type:        b.cf.DeviceListType(),
+				Items:       b.listItems(devices),
+			})
+			if err != nil {
+				failed++
+				b.log.Error("Failed to create blueprint list", "name", name, "blueprint", blueprints[name], "error", err)
+				continue
+			}
+			created++
+			continue
+		}
+
+		changed, err := b.reconcile(ctx, list, devices)
+		if err != nil {
+			failed++
+			b.log.Error("Failed to update blueprint list", "name", name, "list_id", list.ID, "error", err)
+			continue
+		}
+		if changed {
+			updated++
+		}
+	}
+
+	emptied := 0
+	for name, list := range existing {
+		if _, wanted := byList[name]; wanted || !strings.HasPrefix(list.Description, blueprintListDescriptionPrefix) || list.Count == 0 {
+			continue
+		}
+		changed, err := b.reconcile(ctx, list, nil)
+		if err != nil {
+			failed++
+			b.log.Error("Failed to empty stale blueprint list", "name", name, "list_id", list.ID, "error", err)
+			continue
+		}
+		if changed {
+			emptied++
+		}
+	}
+
+	b.log.Info("Blueprint lists updated",
+		"blueprints", len(byList),
+		"created_lists", created,
+		"updated_lists", updated,
+		"emptied_lists", emptied,
+		"failed_lists", failed)
+	return nil
+}
+
+// listName renders the name template for a blueprint.
+func (b *BlueprintLists) listName(blueprint string) string {
+	return strings.ReplaceAll(b.nameTemplate, "{blueprint}", blueprint)
+}
+
+// reconcile adds the missing devices to a list and removes the entries of devices that no
+// longer belong to it. It reports whether the list was changed.
+func (b *BlueprintLists) reconcile(ctx context.Context, list cloudflare.GatewayList, devices []Device) (bool, error) {
+	items, err := b.cf.GetListItemsByID(ctx, list.ID)
+	if err != nil {
+		return false, err
+	}
+
+	wanted := make(map[string]struct{}, len(devices))
+	for _, device := range devices {
+		wanted[device.SerialNumber] = struct{}{}
+	}
+	current := make(map[string]struct{}, len(items))
+	var toRemove []string
+	for _, item := range items {
+		current[item.Value] = struct{}{}
+		if _, ok := wanted[item.Value]; !ok {
+			toRemove = append(toRemove, item.Value)
+		}
+	}
+	var toAdd []Device
+	for _, device := range devices {
+		if _, ok := current[device.SerialNumber]; !ok {
+			toAdd = append(toAdd, device)
+		}
+	}
+
+	if len(toRemove) > 0 {
+		result, err := b.cf.DeleteItemsByID(ctx, list.ID, toRemove, b.batchSize)
+		if err != nil {
+			return false, err
+		}
+		if len(result.Errors) > 0 {
+			return false, fmt.Errorf("failed to remove entries: %v", result.Errors)
+		}
+	}
+	if err := b.cf.AppendItemsByID(ctx, list.ID, b.listItems(toAdd)); err != nil {
+		return false, err
+	}
+	return len(toRemove) > 0 || len(toAdd) > 0, nil
+}
+
+func (b *BlueprintLists) listItems(devices []Device) []cloudflare.GatewayListItemCreateRequest {
+	items := make([]cloudflare.GatewayListItemCreateRequest, 0, len(devices))
+	seen := make(map[string]struct{}, len(devices))
+	for _, device := range devices {
+		if _, dup := seen[device.SerialNumber]; dup {
+			continue
+		}
+		seen[device.SerialNumber] = struct{}{}
+		items = append(items, cloudflare.GatewayListItemCreateRequest{
+			Value:   device.SerialNumber,
+			Comment: cloudflare.WithMarker(device.DeviceName, b.marker),
+		})
+	}
+	return items
+}
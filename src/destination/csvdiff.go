@@ -0,0 +1,80 @@
+package destination
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/internal/objectstore"
+)
+
+// CSVDiff writes the changes applied in every cycle as a CSV file, for attaching to
+// change-management tickets. Cycles without changes produce no file.
+type CSVDiff struct {
+	directory string
+	store     *objectstore.S3
+	log       *slog.Logger
+}
+
+// NewCSVDiff creates a new CSV diff destination.
+func NewCSVDiff(cfg config.CSVDiffConfig, log *slog.Logger) (*CSVDiff, error) {
+	c := &CSVDiff{directory: cfg.Directory, log: log}
+	if cfg.S3.Enabled {
+		store, err := objectstore.NewS3(cfg.S3)
+		if err != nil {
+			return nil, err
+		}
+		c.store = store
+	}
+	if c.directory != "" {
+		if err := os.MkdirAll(c.directory, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create directory: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// Name returns the destination name used in logs.
+func (c *CSVDiff) Name() string {
+	return "csv_diff"
+}
+
+// Publish writes diff-<timestamp>.csv with one row per change.
+func (c *CSVDiff) Publish(ctx context.Context, snapshot *Snapshot) error {
+	if len(snapshot.Changes) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write([]string{"serial_number", "action", "comment", "source", "result", "error"})
+	for _, change := range snapshot.Changes {
+		_ = w.Write([]string{change.SerialNumber, change.Action, change.Comment, change.Source, change.Result, change.Error})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to write CSV: %w", err)
+	}
+
+	name := "diff-" + snapshot.Time.UTC().Format("20060102T150405Z") + ".csv"
+	if c.directory != "" {
+		path := filepath.Join(c.directory, name)
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		c.log.Info("CSV diff written", "path", path, "changes", len(snapshot.Changes))
+	}
+	if c.store != nil {
+		key, err := c.store.PutObject(ctx, name, "text/csv", buf.Bytes())
+		if err != nil {
+			return err
+		}
+		c.log.Info("CSV diff uploaded", "bucket", c.store.Bucket(), "key", key, "changes", len(snapshot.Changes))
+	}
+	return nil
+}
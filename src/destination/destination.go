@@ -0,0 +1,125 @@
+package destination
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"kandji-cloudflare-device-sync/cloudflare"
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/kandji"
+)
+
+// Device is a single entry of the synced device set.
+type Device struct {
+	SerialNumber string   `json:"serial_number"`
+	DeviceID     string   `json:"device_id,omitempty"`
+	DeviceName   string   `json:"device_name,omitempty"`
+	UserEmail    string   `json:"user_email,omitempty"`
+	Platform     string   `json:"platform,omitempty"`
+	Blueprint    string   `json:"blueprint,omitempty"`
+	LastSeen     string   `json:"last_seen,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	// PublicIP is set if the Kandji device listing reports it
+	PublicIP string `json:"public_ip,omitempty"`
+	// Source is "kandji" for Kandji devices, "kandji:" and the tenant name for devices of an
+	// additional Kandji tenant, or the ID of the Cloudflare source list
+	Source string `json:"source"`
+}
+
+// Snapshot is the state of a sync cycle handed to every destination.
+type Snapshot struct {
+	// CycleID identifies the cycle the snapshot was taken in
+	CycleID string    `json:"cycle_id,omitempty"`
+	Time    time.Time `json:"time"`
+	Devices []Device  `json:"devices"`
+	Added   []string  `json:"added"`
+	Removed []string  `json:"removed"`
+	// Failed holds the serials that could not be appended to the target list
+	Failed []string `json:"failed,omitempty"`
+	// Changes details every addition and removal attempted in the cycle
+	Changes []Change `json:"changes,omitempty"`
+}
+
+// Change actions and results.
+const (
+	ActionAdd    = "add"
+	ActionRemove = "remove"
+	ResultOK     = "ok"
+	ResultFailed = "failed"
+)
+
+// Change is a single addition to or removal from the target list attempted in a cycle.
+type Change struct {
+	SerialNumber string `json:"serial_number"`
+	Action       string `json:"action"`
+	// Comment is the comment written for an addition, or the comment of the removed entry
+	Comment string `json:"comment"`
+	// Source is the comma-separated sources ("kandji" or source list IDs) of an addition,
+	// or why an entry was removed: "expired" or "on_missing"
+	Source string `json:"source"`
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Destination receives the synced device set at the end of every sync cycle.
+type Destination interface {
+	Name() string
+	Publish(ctx context.Context, snapshot *Snapshot) error
+}
+
+// New builds the destinations enabled in the configuration.
+func New(cfg *config.Config, kandjiClient *kandji.Client, cloudflareClient *cloudflare.Client, log *slog.Logger) ([]Destination, error) {
+	var destinations []Destination
+
+	if cfg.Destinations.Tailscale.Enabled {
+		destinations = append(destinations, NewTailscale(cfg.Destinations.Tailscale, log))
+	}
+
+	if cfg.Destinations.GoogleSheets.Enabled {
+		sheets, err := NewGoogleSheets(cfg.Destinations.GoogleSheets, log)
+		if err != nil {
+			return nil, fmt.Errorf("google_sheets: %w", err)
+		}
+		destinations = append(destinations, sheets)
+	}
+
+	if cfg.Destinations.S3.Enabled {
+		snapshots, err := NewS3Snapshot(cfg.Destinations.S3, log)
+		if err != nil {
+			return nil, fmt.Errorf("s3: %w", err)
+		}
+		destinations = append(destinations, snapshots)
+	}
+
+	if cfg.Destinations.KandjiFeedback.Enabled {
+		destinations = append(destinations, NewKandjiFeedback(cfg.Destinations.KandjiFeedback, cfg.Cloudflare.ListID, kandjiClient, log))
+	}
+
+	if cfg.Destinations.IPList.Enabled {
+		destinations = append(destinations, NewIPList(cfg.Destinations.IPList, cfg.Cloudflare.ManagedMarker, kandjiClient, cloudflareClient, log))
+	}
+
+	if cfg.Destinations.BlueprintLists.Enabled {
+		destinations = append(destinations, NewBlueprintLists(cfg.Destinations.BlueprintLists, cfg.Cloudflare.ManagedMarker, cfg.Batch.Size, cloudflareClient, log))
+	}
+
+	if cfg.Destinations.DeviceEvents.Enabled {
+		deviceEvents, err := NewDeviceEvents(cfg.Destinations.DeviceEvents, cfg.Cloudflare.ListID, cfg.Client.InstanceID, log)
+		if err != nil {
+			return nil, fmt.Errorf("device_events: %w", err)
+		}
+		destinations = append(destinations, deviceEvents)
+	}
+
+	if cfg.Destinations.CSVDiff.Enabled {
+		csvDiff, err := NewCSVDiff(cfg.Destinations.CSVDiff, log)
+		if err != nil {
+			return nil, fmt.Errorf("csv_diff: %w", err)
+		}
+		destinations = append(destinations, csvDiff)
+	}
+
+	return destinations, nil
+}
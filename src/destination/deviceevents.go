@@ -0,0 +1,116 @@
+package destination
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/internal/eventbus"
+)
+
+// DeviceEventSchemaVersion is the version of the DeviceEvent message schema. It is increased
+// on incompatible changes only; new fields may be added without a version change.
+const DeviceEventSchemaVersion = 1
+
+// DeviceEvent is the message published for every device added to or removed from the
+// target list.
+type DeviceEvent struct {
+	SchemaVersion int    `json:"schema_version"`
+	Type          string `json:"type"`
+	Time          string `json:"time"`
+	SerialNumber  string `json:"serial_number"`
+	TargetListID  string `json:"target_list_id"`
+	InstanceID    string `json:"instance_id,omitempty"`
+	// Device holds the device details for device_added, if known
+	Device *Device `json:"device,omitempty"`
+}
+
+// DeviceEvents publishes a device_added or device_removed event for every change of the
+// target list to a NATS subject or a Kafka topic.
+type DeviceEvents struct {
+	publisher    eventbus.Publisher
+	backend      string
+	targetListID string
+	instanceID   string
+	log          *slog.Logger
+}
+
+// NewDeviceEvents creates a new device events destination.
+func NewDeviceEvents(cfg config.DeviceEventsConfig, targetListID, instanceID string, log *slog.Logger) (*DeviceEvents, error) {
+	var publisher eventbus.Publisher
+	switch cfg.Backend {
+	case "nats":
+		nats, err := eventbus.NewNATS(cfg.NATS, "kandji-cloudflare-device-sync "+instanceID)
+		if err != nil {
+			return nil, err
+		}
+		publisher = nats
+	case "kafka":
+		publisher = eventbus.NewKafka(cfg.Kafka)
+	default:
+		return nil, fmt.Errorf("unknown backend %q", cfg.Backend)
+	}
+	return &DeviceEvents{
+		publisher:    publisher,
+		backend:      cfg.Backend,
+		targetListID: targetListID,
+		instanceID:   instanceID,
+		log:          log,
+	}, nil
+}
+
+// Name returns the destination name used in logs.
+func (d *DeviceEvents) Name() string {
+	return "device_events"
+}
+
+// Publish sends one event per added and removed serial.
+func (d *DeviceEvents) Publish(ctx context.Context, snapshot *Snapshot) error {
+	if len(snapshot.Added) == 0 && len(snapshot.Removed) == 0 {
+		return nil
+	}
+
+	devices := make(map[string]*Device, len(snapshot.Devices))
+	for i := range snapshot.Devices {
+		devices[snapshot.Devices[i].SerialNumber] = &snapshot.Devices[i]
+	}
+
+	messages := make([]eventbus.Message, 0, len(snapshot.Added)+len(snapshot.Removed))
+	add := func(eventType, serial string, device *Device) error {
+		value, err := json.Marshal(DeviceEvent{
+			SchemaVersion: DeviceEventSchemaVersion,
+			Type:          eventType,
+			Time:          snapshot.Time.UTC().Format("2006-01-02T15:04:05Z"),
+			SerialNumber:  serial,
+			TargetListID:  d.targetListID,
+			InstanceID:    d.instanceID,
+			Device:        device,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s event: %w", eventType, err)
+		}
+		messages = append(messages, eventbus.Message{Key: serial, Value: value})
+		return nil
+	}
+	for _, serial := range snapshot.Added {
+		if err := add("device_added", serial, devices[serial]); err != nil {
+			return err
+		}
+	}
+	for _, serial := range snapshot.Removed {
+		if err := add("device_removed", serial, nil); err != nil {
+			return err
+		}
+	}
+
+	if err := d.publisher.Publish(ctx, messages); err != nil {
+		return err
+	}
+	d.log.Info("Device events published",
+		"backend", d.backend,
+		"added", len(snapshot.Added),
+		"removed", len(snapshot.Removed))
+	return nil
+}
@@ -0,0 +1,207 @@
+package destination
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/internal/gcpauth"
+)
+
+const (
+	sheetsAPIBaseV4 = "https://sheets.googleapis.com/v4/spreadsheets"
+	sheetsScope     = "https://www.googleapis.com/auth/spreadsheets"
+)
+
+var sheetsHeader = []string{"Serial Number", "Device Name", "Owner", "Source", "Last Seen"}
+
+// GoogleSheets mirrors the synced device set into a Google Sheet. Only rows whose
+// contents changed since the previous cycle are written.
+type GoogleSheets struct {
+	spreadsheetID string
+	sheetName     string
+	tokenSource   *gcpauth.TokenSource
+	httpClient    *http.Client
+	log           *slog.Logger
+}
+
+type sheetsValueRange struct {
+	Range  string     `json:"range"`
+	Values [][]string `json:"values"`
+}
+
+// NewGoogleSheets creates a new Google Sheets destination.
+func NewGoogleSheets(cfg config.GoogleSheetsConfig, log *slog.Logger) (*GoogleSheets, error) {
+	tokenSource, err := gcpauth.NewServiceAccountTokenSource(cfg.CredentialsFile, sheetsScope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Google credentials: %w", err)
+	}
+
+	return &GoogleSheets{
+		spreadsheetID: cfg.SpreadsheetID,
+		sheetName:     cfg.SheetName,
+		tokenSource:   tokenSource,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		log: log,
+	}, nil
+}
+
+// Name returns the destination name used in logs.
+func (g *GoogleSheets) Name() string {
+	return "google_sheets"
+}
+
+// Publish writes the changed rows of the device set to the sheet and clears any
+// rows left over from a larger previous set.
+func (g *GoogleSheets) Publish(ctx context.Context, snapshot *Snapshot) error {
+	desired := [][]string{sheetsHeader}
+	for _, device := range sortedDevices(snapshot.Devices) {
+		desired = append(desired, []string{
+			device.SerialNumber,
+			device.DeviceName,
+			device.UserEmail,
+			device.Source,
+			device.LastSeen,
+		})
+	}
+
+	current, err := g.readValues(ctx, g.a1Range("A:E"))
+	if err != nil {
+		return err
+	}
+
+	var changed []sheetsValueRange
+	for i, row := range desired {
+		if i < len(current) && rowsEqual(current[i], row) {
+			continue
+		}
+		changed = append(changed, sheetsValueRange{
+			Range:  g.a1Range(fmt.Sprintf("A%d:E%d", i+1, i+1)),
+			Values: [][]string{row},
+		})
+	}
+
+	if len(changed) > 0 {
+		body := map[string]any{
+			"valueInputOption": "RAW",
+			"data":             changed,
+		}
+		if err := g.post(ctx, "/values:batchUpdate", body); err != nil {
+			return fmt.Errorf("failed to update sheet rows: %w", err)
+		}
+	}
+
+	if len(current) > len(desired) {
+		staleRange := g.a1Range(fmt.Sprintf("A%d:E%d", len(desired)+1, len(current)))
+		if err := g.post(ctx, "/values/"+url.PathEscape(staleRange)+":clear", map[string]any{}); err != nil {
+			return fmt.Errorf("failed to clear stale sheet rows: %w", err)
+		}
+	}
+
+	g.log.Info("Google Sheets destination updated",
+		"spreadsheet_id", g.spreadsheetID,
+		"rows", len(desired)-1,
+		"changed_rows", len(changed),
+		"cleared_rows", max(len(current)-len(desired), 0))
+	return nil
+}
+
+// a1Range qualifies a cell range with the configured sheet name.
+func (g *GoogleSheets) a1Range(cells string) string {
+	return "'" + strings.ReplaceAll(g.sheetName, "'", "''") + "'!" + cells
+}
+
+func (g *GoogleSheets) readValues(ctx context.Context, a1 string) ([][]string, error) {
+	token, err := g.tokenSource.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("%s/%s/values/%s", sheetsAPIBaseV4, g.spreadsheetID, url.PathEscape(a1))
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sheet: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to read sheet: HTTP %d - %s", resp.StatusCode, string(body))
+	}
+
+	var response sheetsValueRange
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode sheet values: %w", err)
+	}
+	return response.Values, nil
+}
+
+func (g *GoogleSheets) post(ctx context.Context, path string, body any) error {
+	token, err := g.tokenSource.Token(ctx)
+	if err != nil {
+		return err
+	}
+
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request body: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", sheetsAPIBaseV4+"/"+g.spreadsheetID+path, bytes.NewReader(jsonBody))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("HTTP %d - %s", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
+
+// sortedDevices returns the devices ordered by serial number so rows stay stable between cycles.
+func sortedDevices(devices []Device) []Device {
+	sorted := append([]Device(nil), devices...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].SerialNumber < sorted[j].SerialNumber
+	})
+	return sorted
+}
+
+// rowsEqual compares a sheet row with a desired row. The Sheets API omits trailing empty cells.
+func rowsEqual(current, desired []string) bool {
+	for i, value := range desired {
+		var have string
+		if i < len(current) {
+			have = current[i]
+		}
+		if have != value {
+			return false
+		}
+	}
+	return len(current) <= len(desired)
+}
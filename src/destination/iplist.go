@@ -0,0 +1,169 @@
+package destination
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"regexp"
+	"sort"
+	"time"
+
+	"kandji-cloudflare-device-sync/cloudflare"
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/kandji"
+)
+
+// seenPattern extracts the timestamp this tool stamps at the end of IP list comments.
+var seenPattern = regexp.MustCompile(` seen (\S+)$`)
+
+// IPList maintains a Cloudflare IP list from the public IPs last reported by the synced
+// Kandji devices. Every entry carries the time its IP was last reported in its comment, and
+// entries whose IP has not been reported within the TTL are removed.
+type IPList struct {
+	listID string
+	marker string
+	ttl    time.Duration
+	kandji *kandji.Client
+	cf     *cloudflare.Client
+	log    *slog.Logger
+	// publicIPs caches the public IPs read from the device details, by device ID, for a TTL
+	publicIPs map[string]cachedIP
+}
+
+type cachedIP struct {
+	ip        string
+	fetchedAt time.Time
+}
+
+// NewIPList creates a new IP list destination.
+func NewIPList(cfg config.IPListConfig, marker string, kandjiClient *kandji.Client, cloudflareClient *cloudflare.Client, log *slog.Logger) *IPList {
+	return &IPList{
+		listID:    cfg.ListID,
+		marker:    marker,
+		ttl:       cfg.TTL,
+		kandji:    kandjiClient,
+		cf:        cloudflareClient,
+		log:       log,
+		publicIPs: make(map[string]cachedIP),
+	}
+}
+
+// Name returns the destination name used in logs.
+func (l *IPList) Name() string {
+	return "ip_list"
+}
+
+// Publish adds newly reported IPs, refreshes the timestamp of entries older than half the
+// TTL that are still reported, and expires entries that have not been reported within the TTL.
+func (l *IPList) Publish(ctx context.Context, snapshot *Snapshot) error {
+	now := snapshot.Time.UTC()
+
+	reported := make(map[string][]string) // ip -> serials
+	current := make(map[string]struct{}, len(snapshot.Devices))
+	for _, device := range snapshot.Devices {
+		if device.Source != "kandji" || device.DeviceID == "" {
+			continue
+		}
+		current[device.DeviceID] = struct{}{}
+		ip := net.ParseIP(l.publicIP(ctx, device, now))
+		if ip == nil {
+			continue
+		}
+		reported[ip.String()] = append(reported[ip.String()], device.SerialNumber)
+	}
+	for deviceID := range l.publicIPs {
+		if _, ok := current[deviceID]; !ok {
+			delete(l.publicIPs, deviceID)
+		}
+	}
+
+	items, err := l.cf.GetListItemsByID(ctx, l.listID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch IP list items: %w", err)
+	}
+
+	var toRemove []string
+	var toAppend []cloudflare.GatewayListItemCreateRequest
+	existing := make(map[string]struct{}, len(items))
+	expired := 0
+	for _, item := range items {
+		existing[item.Value] = struct{}{}
+		seen, ok := parseSeen(item.Comment)
+		if !ok {
+			// Not stamped by this tool, leave it alone
+			continue
+		}
+		serials, stillReported := reported[item.Value]
+		switch {
+		case stillReported && now.Sub(seen) > l.ttl/2:
+			toRemove = append(toRemove, item.Value)
+			toAppend = append(toAppend, l.ipListItem(item.Value, serials, now))
+		case !stillReported && now.Sub(seen) > l.ttl:
+			toRemove = append(toRemove, item.Value)
+			expired++
+		}
+	}
+	for ip, serials := range reported {
+		if _, ok := existing[ip]; !ok {
+			toAppend = append(toAppend, l.ipListItem(ip, serials, now))
+		}
+	}
+
+	// Refreshed entries are removed and appended in one update, so they are never missing
+	if err := l.cf.UpdateItemsByID(ctx, l.listID, toRemove, toAppend); err != nil {
+		return fmt.Errorf("failed to update IP list items: %w", err)
+	}
+
+	l.log.Info("IP list destination updated",
+		"list_id", l.listID,
+		"reported_ips", len(reported),
+		"written", len(toAppend),
+		"expired", expired)
+	return nil
+}
+
+// publicIP returns the public IP a device last reported: the one in the device listing if
+// it has one, and otherwise the one in its details, which are read at most once per TTL. If
+// they cannot be read, the IP read before is used.
+func (l *IPList) publicIP(ctx context.Context, device Device, now time.Time) string {
+	if device.PublicIP != "" {
+		return device.PublicIP
+	}
+	cached, ok := l.publicIPs[device.DeviceID]
+	if ok && now.Sub(cached.fetchedAt) < l.ttl {
+		return cached.ip
+	}
+	details, err := l.kandji.GetDeviceDetails(ctx, device.DeviceID)
+	if err != nil {
+		l.log.Warn("Failed to fetch Kandji device details", "serial_number", device.SerialNumber, "error", err)
+		return cached.ip
+	}
+	l.publicIPs[device.DeviceID] = cachedIP{ip: details.Network.PublicIP, fetchedAt: now}
+	return details.Network.PublicIP
+}
+
+// ipListItem builds an IP list entry commented with the reporting serials and the time it was seen.
+func (l *IPList) ipListItem(ip string, serials []string, seen time.Time) cloudflare.GatewayListItemCreateRequest {
+	sort.Strings(serials)
+	comment := serials[0]
+	if len(serials) > 1 {
+		comment = fmt.Sprintf("%s +%d more", serials[0], len(serials)-1)
+	}
+	return cloudflare.GatewayListItemCreateRequest{
+		Value:   ip,
+		Comment: cloudflare.WithMarker(comment+" seen "+seen.Format(time.RFC3339), l.marker),
+	}
+}
+
+func parseSeen(comment string) (time.Time, bool) {
+	match := seenPattern.FindStringSubmatch(comment)
+	if match == nil {
+		return time.Time{}, false
+	}
+	seen, err := time.Parse(time.RFC3339, match[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return seen, true
+}
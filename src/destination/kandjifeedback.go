@@ -0,0 +1,156 @@
+package destination
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/kandji"
+)
+
+const (
+	feedbackNotePrefix = "[kandji-cloudflare-device-sync]"
+)
+
+// KandjiFeedback writes the Cloudflare sync status of every Kandji device back to
+// Kandji, either as a device tag or as a device note.
+type KandjiFeedback struct {
+	client    *kandji.Client
+	mode      string
+	syncedTag string
+	errorTag  string
+	listID    string
+	log       *slog.Logger
+	// notes caches the note ID and content last seen per device so unchanged
+	// statuses do not require a request
+	notes map[string]kandji.Note
+}
+
+// NewKandjiFeedback creates a new Kandji feedback destination.
+func NewKandjiFeedback(cfg config.KandjiFeedbackConfig, listID string, client *kandji.Client, log *slog.Logger) *KandjiFeedback {
+	return &KandjiFeedback{
+		client:    client,
+		mode:      cfg.Mode,
+		syncedTag: cfg.SyncedTag,
+		errorTag:  cfg.ErrorTag,
+		listID:    listID,
+		log:       log,
+		notes:     make(map[string]kandji.Note),
+	}
+}
+
+// Name returns the destination name used in logs.
+func (k *KandjiFeedback) Name() string {
+	return "kandji_feedback"
+}
+
+// Publish updates the tag or note of every Kandji device whose status changed.
+func (k *KandjiFeedback) Publish(ctx context.Context, snapshot *Snapshot) error {
+	failed := make(map[string]struct{}, len(snapshot.Failed))
+	for _, serial := range snapshot.Failed {
+		failed[serial] = struct{}{}
+	}
+
+	var updated, errored int
+	for _, device := range snapshot.Devices {
+		if device.Source != "kandji" || device.DeviceID == "" {
+			continue
+		}
+		_, isFailed := failed[device.SerialNumber]
+
+		var changed bool
+		var err error
+		if k.mode == "notes" {
+			changed, err = k.updateNote(ctx, device, isFailed)
+		} else {
+			changed, err = k.updateTags(ctx, device, isFailed)
+		}
+		if err != nil {
+			errored++
+			k.log.Error("Failed to write sync status to Kandji", "serial_number", device.SerialNumber, "device_id", device.DeviceID, "error", err)
+			continue
+		}
+		if changed {
+			updated++
+		}
+	}
+
+	k.log.Info("Kandji feedback updated", "mode", k.mode, "updated_devices", updated, "failed_devices", errored)
+	return nil
+}
+
+// updateTags swaps the synced/error tag on a device when its status changed.
+func (k *KandjiFeedback) updateTags(ctx context.Context, device Device, isFailed bool) (bool, error) {
+	want, drop := k.syncedTag, k.errorTag
+	if isFailed {
+		want, drop = k.errorTag, k.syncedTag
+	}
+
+	var tags []string
+	hasWanted := false
+	for _, tag := range device.Tags {
+		if tag == drop {
+			continue
+		}
+		if tag == want {
+			hasWanted = true
+		}
+		tags = append(tags, tag)
+	}
+	if hasWanted && len(tags) == len(device.Tags) {
+		return false, nil
+	}
+	if !hasWanted {
+		tags = append(tags, want)
+	}
+
+	return true, k.client.UpdateDeviceTags(ctx, device.DeviceID, tags)
+}
+
+// updateNote creates or updates the status note of a device when its content changed.
+func (k *KandjiFeedback) updateNote(ctx context.Context, device Device, isFailed bool) (bool, error) {
+	status := "synced"
+	if isFailed {
+		status = "error"
+	}
+	content := fmt.Sprintf("%s Cloudflare status: %s (list %s)", feedbackNotePrefix, status, k.listID)
+
+	note, cached := k.notes[device.DeviceID]
+	if !cached {
+		notes, err := k.client.GetDeviceNotes(ctx, device.DeviceID)
+		if err != nil {
+			return false, err
+		}
+		for _, n := range notes {
+			if strings.HasPrefix(n.Content, feedbackNotePrefix) {
+				note = n
+				break
+			}
+		}
+	}
+	if note.Content == content {
+		k.notes[device.DeviceID] = note
+		return false, nil
+	}
+
+	var err error
+	if note.NoteID != "" {
+		err = k.client.UpdateDeviceNote(ctx, device.DeviceID, note.NoteID, content)
+	} else {
+		err = k.client.CreateDeviceNote(ctx, device.DeviceID, content)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if note.NoteID != "" {
+		note.Content = content
+		k.notes[device.DeviceID] = note
+	} else {
+		// The create response does not reliably include the note ID, so look it up next cycle
+		delete(k.notes, device.DeviceID)
+	}
+	return true, nil
+}
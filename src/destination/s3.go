@@ -0,0 +1,105 @@
+package destination
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/internal/objectstore"
+)
+
+// S3Snapshot writes the device inventory and the applied diff of every cycle as
+// timestamped JSON objects to an S3-compatible bucket.
+type S3Snapshot struct {
+	store *objectstore.S3
+	log   *slog.Logger
+}
+
+type inventoryObject struct {
+	CycleID string   `json:"cycle_id,omitempty"`
+	Time    string   `json:"time"`
+	Count   int      `json:"count"`
+	Devices []Device `json:"devices"`
+}
+
+type diffObject struct {
+	CycleID string   `json:"cycle_id,omitempty"`
+	Time    string   `json:"time"`
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+// NewS3Snapshot creates a new S3 snapshot destination.
+func NewS3Snapshot(cfg config.S3Config, log *slog.Logger) (*S3Snapshot, error) {
+	store, err := objectstore.NewS3(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &S3Snapshot{store: store, log: log}, nil
+}
+
+// Name returns the destination name used in logs.
+func (s *S3Snapshot) Name() string {
+	return "s3"
+}
+
+// Publish uploads inventory/<timestamp>-<cycle ID>.json and diff/<timestamp>-<cycle ID>.json,
+// so that cycles finishing within the same second do not overwrite each other's objects.
+func (s *S3Snapshot) Publish(ctx context.Context, snapshot *Snapshot) error {
+	name := objectName(snapshot)
+
+	inventory, err := json.Marshal(inventoryObject{
+		CycleID: snapshot.CycleID,
+		Time:    snapshot.Time.UTC().Format("2006-01-02T15:04:05Z"),
+		Count:   len(snapshot.Devices),
+		Devices: sortedDevices(snapshot.Devices),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal inventory: %w", err)
+	}
+	inventoryKey, err := s.store.PutObject(ctx, "inventory/"+name, "application/json", inventory)
+	if err != nil {
+		return err
+	}
+
+	diff, err := json.Marshal(diffObject{
+		CycleID: snapshot.CycleID,
+		Time:    snapshot.Time.UTC().Format("2006-01-02T15:04:05Z"),
+		Added:   nonNil(snapshot.Added),
+		Removed: nonNil(snapshot.Removed),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal diff: %w", err)
+	}
+	diffKey, err := s.store.PutObject(ctx, "diff/"+name, "application/json", diff)
+	if err != nil {
+		return err
+	}
+
+	s.log.Info("S3 snapshot written",
+		"bucket", s.store.Bucket(),
+		"inventory_key", inventoryKey,
+		"diff_key", diffKey,
+		"devices", len(snapshot.Devices))
+	return nil
+}
+
+// objectName returns the name of a snapshot's objects: its time to the second, for listing
+// them in order, and its cycle ID. A snapshot without one is told apart by the nanoseconds.
+func objectName(snapshot *Snapshot) string {
+	timestamp := snapshot.Time.UTC().Format("20060102T150405Z")
+	if snapshot.CycleID == "" {
+		return fmt.Sprintf("%s-%09d.json", timestamp, snapshot.Time.Nanosecond())
+	}
+	return timestamp + "-" + snapshot.CycleID + ".json"
+}
+
+// nonNil makes empty slices encode as [] rather than null.
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
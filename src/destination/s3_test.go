@@ -0,0 +1,26 @@
+package destination
+
+import (
+	"testing"
+	"time"
+)
+
+func TestObjectName(t *testing.T) {
+	at := time.Date(2026, 10, 16, 12, 0, 5, 250_000_000, time.UTC)
+	tests := []struct {
+		name     string
+		snapshot Snapshot
+		want     string
+	}{
+		{name: "cycle ID", snapshot: Snapshot{CycleID: "a1b2c3d4e5f60718", Time: at}, want: "20261016T120005Z-a1b2c3d4e5f60718.json"},
+		{name: "another cycle in the same second", snapshot: Snapshot{CycleID: "0f1e2d3c4b5a6978", Time: at}, want: "20261016T120005Z-0f1e2d3c4b5a6978.json"},
+		{name: "no cycle ID", snapshot: Snapshot{Time: at}, want: "20261016T120005Z-250000000.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := objectName(&tt.snapshot); got != tt.want {
+				t.Errorf("objectName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package destination
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"kandji-cloudflare-device-sync/config"
+)
+
+const (
+	tailscaleAPIBaseV2 = "https://api.tailscale.com/api/v2"
+)
+
+// Tailscale tags and/or authorizes Tailscale devices whose serial number or hostname
+// matches the synced device set.
+type Tailscale struct {
+	apiToken      string
+	tailnet       string
+	tags          []string
+	authorize     bool
+	matchHostname bool
+	httpClient    *http.Client
+	log           *slog.Logger
+}
+
+type tailscaleDevicesResponse struct {
+	Devices []tailscaleDevice `json:"devices"`
+}
+
+type tailscaleDevice struct {
+	ID              string   `json:"id"`
+	NodeID          string   `json:"nodeId"`
+	Hostname        string   `json:"hostname"`
+	Name            string   `json:"name"`
+	Tags            []string `json:"tags"`
+	Authorized      bool     `json:"authorized"`
+	PostureIdentity *struct {
+		SerialNumbers []string `json:"serialNumbers"`
+	} `json:"postureIdentity"`
+}
+
+// NewTailscale creates a new Tailscale destination.
+func NewTailscale(cfg config.TailscaleConfig, log *slog.Logger) *Tailscale {
+	return &Tailscale{
+		apiToken:      cfg.ApiToken,
+		tailnet:       cfg.Tailnet,
+		tags:          cfg.Tags,
+		authorize:     cfg.Authorize,
+		matchHostname: cfg.MatchHostname,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		log: log,
+	}
+}
+
+// Name returns the destination name used in logs.
+func (t *Tailscale) Name() string {
+	return "tailscale"
+}
+
+// Publish applies the configured tags and authorization to every matching Tailscale device.
+func (t *Tailscale) Publish(ctx context.Context, snapshot *Snapshot) error {
+	serials := make(map[string]struct{}, len(snapshot.Devices))
+	hostnames := make(map[string]struct{}, len(snapshot.Devices))
+	for _, device := range snapshot.Devices {
+		serials[strings.ToUpper(device.SerialNumber)] = struct{}{}
+		if device.DeviceName != "" {
+			hostnames[strings.ToLower(device.DeviceName)] = struct{}{}
+		}
+	}
+
+	devices, err := t.listDevices(ctx)
+	if err != nil {
+		return err
+	}
+
+	var tagged, authorized, matched int
+	for _, device := range devices {
+		if !t.matches(device, serials, hostnames) {
+			continue
+		}
+		matched++
+
+		if missing := missingTags(device.Tags, t.tags); len(missing) > 0 {
+			tags := append(append([]string{}, device.Tags...), missing...)
+			if err := t.post(ctx, "/device/"+device.deviceID()+"/tags", map[string]any{"tags": tags}); err != nil {
+				t.log.Error("Failed to tag Tailscale device", "device", device.Hostname, "tags", tags, "error", err)
+				continue
+			}
+			tagged++
+		}
+
+		if t.authorize && !device.Authorized {
+			if err := t.post(ctx, "/device/"+device.deviceID()+"/authorized", map[string]any{"authorized": true}); err != nil {
+				t.log.Error("Failed to authorize Tailscale device", "device", device.Hostname, "error", err)
+				continue
+			}
+			authorized++
+		}
+	}
+
+	t.log.Info("Tailscale destination updated",
+		"tailscale_devices", len(devices),
+		"matched", matched,
+		"tagged", tagged,
+		"authorized", authorized)
+	return nil
+}
+
+// matches reports whether a Tailscale device belongs to the synced device set.
+func (t *Tailscale) matches(device tailscaleDevice, serials, hostnames map[string]struct{}) bool {
+	if device.PostureIdentity != nil {
+		for _, serial := range device.PostureIdentity.SerialNumbers {
+			if _, ok := serials[strings.ToUpper(serial)]; ok {
+				return true
+			}
+		}
+	}
+	if t.matchHostname {
+		if _, ok := hostnames[strings.ToLower(device.Hostname)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (d tailscaleDevice) deviceID() string {
+	if d.NodeID != "" {
+		return d.NodeID
+	}
+	return d.ID
+}
+
+// missingTags returns the wanted tags that are not already set on the device.
+func missingTags(existing, wanted []string) []string {
+	have := make(map[string]struct{}, len(existing))
+	for _, tag := range existing {
+		have[tag] = struct{}{}
+	}
+	var missing []string
+	for _, tag := range wanted {
+		if _, ok := have[tag]; !ok {
+			missing = append(missing, tag)
+		}
+	}
+	return missing
+}
+
+// listDevices fetches all devices in the tailnet including their posture identity.
+func (t *Tailscale) listDevices(ctx context.Context) ([]tailscaleDevice, error) {
+	endpoint := fmt.Sprintf("%s/tailnet/%s/devices?fields=all", tailscaleAPIBaseV2, url.PathEscape(t.tailnet))
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+t.apiToken)
+
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Tailscale devices: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to list Tailscale devices: HTTP %d - %s", resp.StatusCode, string(body))
+	}
+
+	var response tailscaleDevicesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode Tailscale devices response: %w", err)
+	}
+	return response.Devices, nil
+}
+
+func (t *Tailscale) post(ctx context.Context, path string, body any) error {
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request body: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", tailscaleAPIBaseV2+path, bytes.NewReader(jsonBody))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+t.apiToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("HTTP %d - %s", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
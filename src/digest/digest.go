@@ -0,0 +1,138 @@
+// Package digest emails a daily summary of the membership changes recorded in the state
+// store, on a schedule independent of the sync cycles.
+package digest
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/internal/mail"
+	"kandji-cloudflare-device-sync/state"
+)
+
+// maxListedSerials caps the serials listed per section so large changes keep the mail readable.
+const maxListedSerials = 200
+
+// Digest sends the daily digest.
+type Digest struct {
+	cfg      config.DigestConfig
+	store    *state.Store
+	sender   *mail.Sender
+	location *time.Location
+	log      *slog.Logger
+}
+
+// New creates a Digest. The configuration must have been validated.
+func New(cfg config.DigestConfig, store *state.Store, sender *mail.Sender, log *slog.Logger) (*Digest, error) {
+	location, err := time.LoadLocation(cfg.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+	return &Digest{cfg: cfg, store: store, sender: sender, location: location, log: log}, nil
+}
+
+// Run sends the digest every day at the configured time until ctx is cancelled.
+func (d *Digest) Run(ctx context.Context) {
+	for {
+		next := d.nextRun(time.Now())
+		d.log.Info("Next daily digest scheduled", "at", next.Format(time.RFC3339))
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if err := d.Send(ctx, next); err != nil {
+			d.log.Error("Failed to send daily digest", "error", err)
+		}
+	}
+}
+
+// nextRun returns the next occurrence of the configured time of day after now.
+func (d *Digest) nextRun(now time.Time) time.Time {
+	at, _ := time.Parse("15:04", d.cfg.Time)
+	local := now.In(d.location)
+	next := time.Date(local.Year(), local.Month(), local.Day(), at.Hour(), at.Minute(), 0, 0, d.location)
+	if !next.After(local) {
+		next = time.Date(local.Year(), local.Month(), local.Day()+1, at.Hour(), at.Minute(), 0, 0, d.location)
+	}
+	return next
+}
+
+// Send emails the digest of the 24 hours before until.
+func (d *Digest) Send(ctx context.Context, until time.Time) error {
+	since := until.Add(-24 * time.Hour)
+	body := Build(d.store.Cycles(), since, until)
+	subject := fmt.Sprintf("%s (%s)", d.cfg.Subject, until.In(d.location).Format("2006-01-02"))
+	if err := d.sender.Send(ctx, d.cfg.Recipients, subject, body); err != nil {
+		return err
+	}
+	d.log.Info("Daily digest sent", "recipients", len(d.cfg.Recipients))
+	return nil
+}
+
+// Build formats the digest of the cycles that started in [since, until).
+func Build(cycles []state.Cycle, since, until time.Time) string {
+	var added, removed, failed []string
+	var cycleCount, failedCycles int
+	var cycleErrors []string
+	devices := -1
+	for _, cycle := range cycles {
+		if cycle.StartedAt.Before(since) || !cycle.StartedAt.Before(until) {
+			continue
+		}
+		cycleCount++
+		added = append(added, cycle.Added...)
+		removed = append(removed, cycle.Removed...)
+		failed = append(failed, cycle.Failed...)
+		if cycle.Error != "" {
+			failedCycles++
+			cycleErrors = append(cycleErrors, cycle.StartedAt.UTC().Format(time.RFC3339)+": "+cycle.Error)
+			continue
+		}
+		devices = cycle.Devices
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Membership changes from %s to %s\n\n", since.UTC().Format(time.RFC3339), until.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "Sync cycles:    %d (%d failed)\n", cycleCount, failedCycles)
+	if devices >= 0 {
+		fmt.Fprintf(&b, "Devices:        %d\n", devices)
+	}
+	fmt.Fprintf(&b, "Added:          %d\n", len(added))
+	fmt.Fprintf(&b, "Removed:        %d\n", len(removed))
+	fmt.Fprintf(&b, "Failed to add:  %d\n", len(failed))
+
+	writeSerials(&b, "Added", added)
+	writeSerials(&b, "Removed", removed)
+	writeSerials(&b, "Failed to add", failed)
+	if len(cycleErrors) > 0 {
+		b.WriteString("\nFailed cycles:\n")
+		for _, e := range cycleErrors {
+			fmt.Fprintf(&b, "  %s\n", e)
+		}
+	}
+	return b.String()
+}
+
+func writeSerials(b *strings.Builder, title string, serials []string) {
+	if len(serials) == 0 {
+		return
+	}
+	serials = append([]string(nil), serials...)
+	sort.Strings(serials)
+	fmt.Fprintf(b, "\n%s:\n", title)
+	for i, serial := range serials {
+		if i == maxListedSerials {
+			fmt.Fprintf(b, "  ... and %d more\n", len(serials)-maxListedSerials)
+			break
+		}
+		fmt.Fprintf(b, "  %s\n", serial)
+	}
+}
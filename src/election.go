@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log/slog"
+
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/leader"
+	"kandji-cloudflare-device-sync/metrics"
+)
+
+// newElector returns the elector of the configured leader election backend.
+func newElector(cfg config.LeaderElectionConfig, log *slog.Logger) (*leader.Elector, error) {
+	var lock leader.Lock
+	var err error
+	switch cfg.Backend {
+	case "kubernetes":
+		lock, err = leader.NewKubernetesLease(cfg.Kubernetes.Namespace, cfg.Kubernetes.LeaseName)
+	case "redis":
+		lock, err = leader.NewRedisLock(leader.RedisOptions{
+			URL:      cfg.Redis.URL,
+			Key:      cfg.Redis.Key,
+			Username: cfg.Redis.Username,
+			Password: cfg.Redis.Password,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	identity := cfg.Identity
+	if identity == "" {
+		identity = leader.DefaultIdentity()
+	}
+	return leader.New(lock, leader.Options{
+		Identity:      identity,
+		LeaseDuration: cfg.LeaseDuration,
+		RenewInterval: cfg.RenewInterval,
+		Logger:        log,
+	}), nil
+}
+
+// leaderGauge exports whether the replica is the leader.
+type leaderGauge struct {
+	leader *metrics.GaugeVec
+}
+
+func newLeaderGauge(reg *metrics.Registry) *leaderGauge {
+	return &leaderGauge{
+		leader: reg.Gauge("kandji_cloudflare_sync_leader",
+			"1 if this replica is the elected leader running sync cycles, 0 otherwise."),
+	}
+}
+
+func (g *leaderGauge) set(leading bool) {
+	value := 0.0
+	if leading {
+		value = 1
+	}
+	g.leader.Set(value)
+}
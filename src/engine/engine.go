@@ -0,0 +1,437 @@
+// Package engine is the library entry point to the Kandji to Cloudflare sync. It sets up the
+// Kandji and Cloudflare clients, checks the configured lists the way the service does at
+// startup, and runs sync cycles, so the sync can be embedded in another Go program instead
+// of running the binary:
+//
+//	cfg, err := config.Parse(data)
+//	...
+//	report, err := engine.Sync(ctx, engine.Options{Config: cfg})
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"kandji-cloudflare-device-sync/cloudflare"
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/destination"
+	"kandji-cloudflare-device-sync/internal/httpclient"
+	"kandji-cloudflare-device-sync/internal/ratelimit"
+	"kandji-cloudflare-device-sync/intune"
+	"kandji-cloudflare-device-sync/kandji"
+	"kandji-cloudflare-device-sync/mosyle"
+	"kandji-cloudflare-device-sync/notify"
+	"kandji-cloudflare-device-sync/syncer"
+)
+
+// Report is the outcome of a sync cycle.
+type Report = syncer.Report
+
+// ErrInvalidConfig is wrapped by the errors of New caused by the configuration rather than
+// by the APIs.
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// SetupError is returned by New when one of its setup steps fails.
+type SetupError struct {
+	// Step describes the step that failed, e.g. "Failed to connect to Kandji API"
+	Step string
+	Err  error
+}
+
+func (e *SetupError) Error() string {
+	return e.Step + ": " + e.Err.Error()
+}
+
+func (e *SetupError) Unwrap() error {
+	return e.Err
+}
+
+// Options configures an Engine. Only Config is required.
+type Options struct {
+	// Config is the sync configuration, from config.Parse or built in code and completed
+	// with SetDefaults. It is validated by New.
+	Config *config.Config
+	// Logger receives the engine's logs, slog.Default() if nil
+	Logger *slog.Logger
+	// UserAgent identifies the requests to the APIs, built from Config.Client if empty
+	UserAgent string
+	// RateLimiter is shared with other users of the APIs, built from Config.RateLimits if nil
+	RateLimiter *ratelimit.Limiter
+	// Destinations receive the synced device set, in addition to those in Config.Destinations
+	Destinations []destination.Destination
+	// Notifiers receive the alerts of the sync cycles, in addition to those built from Config
+	Notifiers []notify.Notifier
+	// KandjiClient is a client set up and checked by another engine, to share it and its
+	// inventory cache between sync jobs. It is created from Config.Kandji if nil, unless
+	// Mosyle replaces Kandji.
+	KandjiClient *kandji.Client
+	// MosyleClient is shared between sync jobs like KandjiClient. It is created from
+	// Config.Mosyle if nil and Mosyle is enabled.
+	MosyleClient *mosyle.Client
+	// KandjiTenants are shared between sync jobs like KandjiClient. They are created from
+	// Config.Kandji.Tenants if nil.
+	KandjiTenants []syncer.KandjiTenant
+	// CreatedLists remembers the target lists created by create_list_if_missing, so a list
+	// configured by ID is not created again at every start. Lists are only created if nil.
+	CreatedLists CreatedLists
+	// KandjiOptions, MosyleOptions, CloudflareOptions and SyncerOptions are applied after
+	// the engine's own
+	KandjiOptions     []kandji.Option
+	MosyleOptions     []mosyle.Option
+	CloudflareOptions []cloudflare.Option
+	SyncerOptions     []syncer.Option
+}
+
+// CreatedLists remembers the lists created in place of configured target lists that did not
+// exist. It is implemented by *state.Store.
+type CreatedLists interface {
+	CreatedList(ref string) (string, bool)
+	RecordCreatedList(ref, listID string) error
+}
+
+// Engine runs sync cycles with clients that were set up and checked once.
+type Engine struct {
+	kandjiClient     *kandji.Client
+	kandjiTenants    []syncer.KandjiTenant
+	mosyleClient     *mosyle.Client
+	cloudflareClient *cloudflare.Client
+	syncer           *syncer.Syncer
+	// opts are the options the engine was created with, with the defaults filled in, and
+	// config the configuration it syncs with, see Reload
+	opts   Options
+	config *config.Config
+}
+
+// Sync sets up an engine and runs a single sync cycle. Programs that sync repeatedly should
+// create an Engine with New and call its Sync method instead.
+func Sync(ctx context.Context, opts Options) (Report, error) {
+	e, err := New(ctx, opts)
+	if err != nil {
+		return Report{}, err
+	}
+	return e.Sync(ctx)
+}
+
+// New creates the Kandji and Cloudflare clients and checks what the service checks at
+// startup: that the Kandji API accepts the token, and that the target list and every
+// other configured list exist and have the expected type. A target list configured by name
+// is resolved to its ID in Config, and a missing target list is created if
+// create_list_if_missing is set.
+func New(ctx context.Context, opts Options) (*Engine, error) {
+	cfg := opts.Config
+	if cfg == nil {
+		return nil, &SetupError{Step: "Invalid options", Err: fmt.Errorf("%w: Config is required", ErrInvalidConfig)}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, &SetupError{Step: "Invalid configuration", Err: fmt.Errorf("%w: %w", ErrInvalidConfig, err)}
+	}
+	log := opts.Logger
+	if log == nil {
+		log = slog.Default()
+	}
+	rateLimiter := opts.RateLimiter
+	if rateLimiter == nil {
+		rateLimiter = ratelimit.New(ratelimit.Config{
+			KandjiRequestsPerSecond:     cfg.RateLimits.KandjiRequestsPerSecond,
+			CloudflareRequestsPerSecond: cfg.RateLimits.CloudflareRequestsPerSecond,
+			BurstCapacity:               cfg.RateLimits.BurstCapacity,
+		})
+	}
+	userAgent := opts.UserAgent
+	if userAgent == "" {
+		userAgent = httpclient.UserAgent("library", cfg.Client.InstanceID, cfg.Client.UserAgentSuffix)
+	}
+
+	opts.Logger, opts.RateLimiter, opts.UserAgent = log, rateLimiter, userAgent
+
+	kandjiClient := opts.KandjiClient
+	if kandjiClient == nil && cfg.KandjiEnabled() {
+		var err error
+		kandjiClient, err = newKandjiClient(ctx, cfg, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	kandjiTenants := opts.KandjiTenants
+	if kandjiTenants == nil {
+		for i, tenant := range cfg.Kandji.Tenants {
+			kandjiOptions := kandjiClientOptions(cfg, opts, log.With("tenant", tenant.Name))
+			if source := cfg.SecretSource(config.TenantTokenSetting(i)); source != nil {
+				kandjiOptions = append(kandjiOptions, kandji.WithTokenSource(source))
+			}
+			client, err := kandji.NewClient(cfg.Kandji.ForTenant(tenant), rateLimiter, kandjiOptions...)
+			if err != nil {
+				return nil, &SetupError{Step: "Failed to create Kandji client", Err: fmt.Errorf("%w: tenant %s: %w", ErrInvalidConfig, tenant.Name, err)}
+			}
+			if err := client.Probe(ctx); err != nil {
+				return nil, &SetupError{Step: "Failed to connect to Kandji API", Err: fmt.Errorf("tenant %s: %w", tenant.Name, err)}
+			}
+			kandjiTenants = append(kandjiTenants, syncer.KandjiTenant{Name: tenant.Name, Client: client})
+		}
+	}
+
+	mosyleClient := opts.MosyleClient
+	if mosyleClient == nil && cfg.Mosyle.Enabled {
+		mosyleOptions := append([]mosyle.Option{mosyle.WithUserAgent(userAgent), mosyle.WithNetwork(cfg.Network)}, opts.MosyleOptions...)
+		var err error
+		mosyleClient, err = mosyle.NewClient(cfg.Mosyle, mosyleOptions...)
+		if err != nil {
+			return nil, &SetupError{Step: "Failed to create Mosyle client", Err: fmt.Errorf("%w: %w", ErrInvalidConfig, err)}
+		}
+		if err := mosyleClient.Probe(ctx); err != nil {
+			return nil, &SetupError{Step: "Failed to connect to Mosyle API", Err: err}
+		}
+	}
+
+	cloudflareClient, err := newCloudflareClient(cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLists(ctx, cfg, cloudflareClient, opts.CreatedLists, log); err != nil {
+		return nil, err
+	}
+
+	destinations, err := destination.New(cfg, kandjiClient, cloudflareClient, log)
+	if err != nil {
+		return nil, &SetupError{Step: "Failed to create destinations", Err: fmt.Errorf("%w: %w", ErrInvalidConfig, err)}
+	}
+	notifiers, err := notify.New(cfg, log)
+	if err != nil {
+		return nil, &SetupError{Step: "Failed to create notifiers", Err: fmt.Errorf("%w: %w", ErrInvalidConfig, err)}
+	}
+	syncerOptions := append([]syncer.Option{
+		syncer.WithDestinations(append(destinations, opts.Destinations...)...),
+		syncer.WithNotifiers(append(notifiers, opts.Notifiers...)...),
+	}, opts.SyncerOptions...)
+	if cfg.Intune.Enabled {
+		intuneClient, err := intune.NewClient(cfg.Intune, intune.WithUserAgent(userAgent), intune.WithNetwork(cfg.Network))
+		if err != nil {
+			return nil, &SetupError{Step: "Failed to create Intune client", Err: fmt.Errorf("%w: %w", ErrInvalidConfig, err)}
+		}
+		if err := intuneClient.Probe(ctx); err != nil {
+			return nil, &SetupError{Step: "Failed to connect to Intune", Err: err}
+		}
+		syncerOptions = append(syncerOptions, syncer.WithIntune(intuneClient))
+	}
+	if mosyleClient != nil {
+		syncerOptions = append(syncerOptions, syncer.WithMosyle(mosyleClient))
+	}
+	if len(kandjiTenants) > 0 {
+		syncerOptions = append(syncerOptions, syncer.WithKandjiTenants(kandjiTenants...))
+	}
+
+	return &Engine{
+		kandjiClient:     kandjiClient,
+		kandjiTenants:    kandjiTenants,
+		mosyleClient:     mosyleClient,
+		cloudflareClient: cloudflareClient,
+		syncer:           syncer.New(kandjiClient, cloudflareClient, cfg, log, syncerOptions...),
+		opts:             opts,
+		config:           cfg,
+	}, nil
+}
+
+// newKandjiClient creates the Kandji client of cfg and checks that the API accepts its token.
+func newKandjiClient(ctx context.Context, cfg *config.Config, opts Options) (*kandji.Client, error) {
+	kandjiOptions := kandjiClientOptions(cfg, opts, opts.Logger)
+	if source := cfg.SecretSource("kandji.api_token"); source != nil {
+		kandjiOptions = append(kandjiOptions, kandji.WithTokenSource(source))
+	}
+	client, err := kandji.NewClient(cfg.Kandji, opts.RateLimiter, kandjiOptions...)
+	if err != nil {
+		return nil, &SetupError{Step: "Failed to create Kandji client", Err: fmt.Errorf("%w: %w", ErrInvalidConfig, err)}
+	}
+	if err := client.Probe(ctx); err != nil {
+		return nil, &SetupError{Step: "Failed to connect to Kandji API", Err: err}
+	}
+	return client, nil
+}
+
+// kandjiClientOptions returns the options of a Kandji client of cfg, followed by those of
+// opts. log receives the client's logs.
+func kandjiClientOptions(cfg *config.Config, opts Options, log *slog.Logger) []kandji.Option {
+	kandjiOptions := []kandji.Option{kandji.WithUserAgent(opts.UserAgent), kandji.WithNetwork(cfg.Network)}
+	if cfg.Kandji.Incremental.Enabled {
+		kandjiOptions = append(kandjiOptions, kandji.WithIncrementalSync(cfg.Kandji.Incremental.FullResyncInterval, log))
+	}
+	if cfg.Client.ConditionalRequests.Enabled {
+		kandjiOptions = append(kandjiOptions, kandji.WithConditionalRequests(cfg.Client.ConditionalRequests.MaxEntries))
+	}
+	return append(kandjiOptions, opts.KandjiOptions...)
+}
+
+// newCloudflareClient creates the Cloudflare client of cfg. The lists are checked by checkLists.
+func newCloudflareClient(cfg *config.Config, opts Options) (*cloudflare.Client, error) {
+	cloudflareOptions := append([]cloudflare.Option{cloudflare.WithUserAgent(opts.UserAgent), cloudflare.WithNetwork(cfg.Network)}, opts.CloudflareOptions...)
+	if cfg.DryRun {
+		cloudflareOptions = append(cloudflareOptions, cloudflare.WithDryRun())
+	}
+	if cfg.Client.ConditionalRequests.Enabled {
+		cloudflareOptions = append(cloudflareOptions, cloudflare.WithConditionalRequests(cfg.Client.ConditionalRequests.MaxEntries))
+	}
+	if source := cfg.SecretSource("cloudflare.api_token"); source != nil {
+		cloudflareOptions = append(cloudflareOptions, cloudflare.WithTokenSource(source))
+	}
+	client, err := cloudflare.NewClient(cfg.Cloudflare, opts.RateLimiter, opts.Logger, cloudflareOptions...)
+	if err != nil {
+		return nil, &SetupError{Step: "Failed to create Cloudflare client", Err: fmt.Errorf("%w: %w", ErrInvalidConfig, err)}
+	}
+	return client, nil
+}
+
+// checkLists resolves the target list if it was configured by name, and checks that it and
+// the source, routed, tag, owner email and device IP lists exist. With email_only there is
+// no target list.
+func checkLists(ctx context.Context, cfg *config.Config, client *cloudflare.Client, createdLists CreatedLists, log *slog.Logger) error {
+	if cfg.Cloudflare.EmailOnly {
+		return checkEmailOnlyLists(ctx, cfg, client)
+	}
+	if cfg.Cloudflare.ListID == "" {
+		listID, err := client.ResolveTargetList(ctx, cfg.Cloudflare.TargetListName, client.DeviceListType())
+		if errors.Is(err, cloudflare.ErrListNotFound) && cfg.Cloudflare.CreateListIfMissing {
+			// Found by name from now on, there is nothing to remember
+			listID, err = client.CreateTargetList(ctx, cfg.Cloudflare.TargetListName, cfg.Cloudflare.NewList.Description)
+		}
+		if err != nil {
+			return &SetupError{Step: "Failed to resolve Cloudflare target list by name", Err: fmt.Errorf("list %q: %w", cfg.Cloudflare.TargetListName, err)}
+		}
+		cfg.Cloudflare.ListID = listID
+	}
+
+	if err := client.ValidateListExists(ctx); err != nil {
+		if !errors.Is(err, cloudflare.ErrListNotFound) || !cfg.Cloudflare.CreateListIfMissing {
+			return &SetupError{Step: "Failed to validate Cloudflare list! This likely means you don't have access to the list or the list ID is wrong.", Err: err}
+		}
+		listID, err := replaceTargetList(ctx, cfg, client, createdLists, log)
+		if err != nil {
+			return &SetupError{Step: "Failed to create missing Cloudflare target list", Err: fmt.Errorf("list %s: %w", cfg.Cloudflare.ListID, err)}
+		}
+		cfg.Cloudflare.ListID = listID
+	}
+	for _, ref := range cfg.Cloudflare.SourceListRefs() {
+		listID, err := client.ResolveListID(ctx, ref)
+		if err != nil {
+			return &SetupError{Step: "Failed to resolve Cloudflare source list", Err: fmt.Errorf("list %q: %w", ref, err)}
+		}
+		if listID == cfg.Cloudflare.ListID {
+			return &SetupError{Step: "Cloudflare source list resolves to the target list", Err: fmt.Errorf("%w: list %q is %s", ErrInvalidConfig, ref, listID)}
+		}
+	}
+	routedRefs := make(map[string]string) // list ID -> reference
+	for _, ref := range append(cfg.Cloudflare.RoutedListRefs(), cfg.Cloudflare.TagListMapping.Refs()...) {
+		listID, err := client.ResolveListID(ctx, ref)
+		if err != nil {
+			return &SetupError{Step: "Failed to resolve Cloudflare routed list", Err: fmt.Errorf("list %q: %w", ref, err)}
+		}
+		if listID == cfg.Cloudflare.ListID {
+			return &SetupError{Step: "Cloudflare routed list resolves to the target list", Err: fmt.Errorf("%w: list %q is %s", ErrInvalidConfig, ref, listID)}
+		}
+		// Synced once per reference, two references to one list would undo each other's changes
+		if other, ok := routedRefs[listID]; ok {
+			return &SetupError{Step: "Cloudflare routed lists resolve to the same list", Err: fmt.Errorf("%w: lists %q and %q are both %s, reference it the same way", ErrInvalidConfig, other, ref, listID)}
+		}
+		routedRefs[listID] = ref
+		list, err := client.GetListMetadataByID(ctx, listID)
+		if err != nil {
+			return &SetupError{Step: "Failed to validate Cloudflare routed list", Err: fmt.Errorf("list %q (%s): %w", ref, listID, err)}
+		}
+		if list.Type != client.DeviceListType() {
+			return &SetupError{Step: "Cloudflare routed list is not a " + client.DeviceListType() + " list", Err: fmt.Errorf("list %q (%s) is of type %s", ref, listID, list.Type)}
+		}
+	}
+	if cfg.Cloudflare.EmailListID != "" {
+		if err := client.ValidateListExistsByID(ctx, cfg.Cloudflare.EmailListID, "EMAIL"); err != nil {
+			return &SetupError{Step: "Failed to validate Cloudflare email list!", Err: fmt.Errorf("list %s: %w", cfg.Cloudflare.EmailListID, err)}
+		}
+	}
+	if cfg.Destinations.IPList.Enabled {
+		if err := client.ValidateListExistsByID(ctx, cfg.Destinations.IPList.ListID, "IP"); err != nil {
+			return &SetupError{Step: "Failed to validate Cloudflare IP list!", Err: fmt.Errorf("list %s: %w", cfg.Destinations.IPList.ListID, err)}
+		}
+	}
+	return nil
+}
+
+// replaceTargetList makes a new list the target in place of the configured list ID, which
+// does not exist: the list created for it at an earlier start if it still exists, or else a
+// newly created list.
+func replaceTargetList(ctx context.Context, cfg *config.Config, client *cloudflare.Client, createdLists CreatedLists, log *slog.Logger) (string, error) {
+	ref := cfg.Cloudflare.ListID
+	if createdLists != nil {
+		if listID, ok := createdLists.CreatedList(ref); ok {
+			_, err := client.ResolveTargetList(ctx, listID, client.DeviceListType())
+			if err == nil {
+				log.Info("Using the Cloudflare list created in place of the missing target list", "target_list_id", ref, "list_id", listID)
+				return listID, nil
+			}
+			if !errors.Is(err, cloudflare.ErrListNotFound) {
+				return "", err
+			}
+		}
+	}
+
+	listID, err := client.CreateTargetList(ctx, cfg.Cloudflare.NewList.Name, cfg.Cloudflare.NewList.Description)
+	if err != nil {
+		return "", err
+	}
+	if createdLists == nil {
+		log.Warn("Created a Cloudflare list in place of the missing target list; set target_list_id to it, or configure a state store, or another list is created at the next start", "target_list_id", ref, "list_id", listID)
+		return listID, nil
+	}
+	if err := createdLists.RecordCreatedList(ref, listID); err != nil {
+		return "", fmt.Errorf("created list %s but failed to remember it: %w", listID, err)
+	}
+	log.Warn("Created a Cloudflare list in place of the missing target list", "target_list_id", ref, "list_id", listID, "name", cfg.Cloudflare.NewList.Name)
+	return listID, nil
+}
+
+// checkEmailOnlyLists checks that the email list and the device IP list exist.
+func checkEmailOnlyLists(ctx context.Context, cfg *config.Config, client *cloudflare.Client) error {
+	if err := client.ValidateListExistsByID(ctx, cfg.Cloudflare.EmailListID, "EMAIL"); err != nil {
+		return &SetupError{Step: "Failed to validate Cloudflare email list!", Err: fmt.Errorf("list %s: %w", cfg.Cloudflare.EmailListID, err)}
+	}
+	if cfg.Destinations.IPList.Enabled {
+		if err := client.ValidateListExistsByID(ctx, cfg.Destinations.IPList.ListID, "IP"); err != nil {
+			return &SetupError{Step: "Failed to validate Cloudflare IP list!", Err: fmt.Errorf("list %s: %w", cfg.Destinations.IPList.ListID, err)}
+		}
+	}
+	return nil
+}
+
+// Sync runs a single sync cycle, recording it in the state store if one is set, and returns
+// its report. The report is returned even if the cycle failed part way through.
+func (e *Engine) Sync(ctx context.Context) (Report, error) {
+	report, err := e.syncer.RunOnce(ctx)
+	if report == nil {
+		return Report{}, err
+	}
+	return *report, err
+}
+
+// Syncer returns the engine's syncer, to run it on a schedule with Run.
+func (e *Engine) Syncer() *syncer.Syncer {
+	return e.syncer
+}
+
+// KandjiClient returns the engine's Kandji client.
+func (e *Engine) KandjiClient() *kandji.Client {
+	return e.kandjiClient
+}
+
+// KandjiTenants returns the clients of the additional Kandji tenants.
+func (e *Engine) KandjiTenants() []syncer.KandjiTenant {
+	return e.kandjiTenants
+}
+
+// MosyleClient returns the engine's Mosyle client, nil unless Mosyle is enabled.
+func (e *Engine) MosyleClient() *mosyle.Client {
+	return e.mosyleClient
+}
+
+// CloudflareClient returns the engine's Cloudflare client.
+func (e *Engine) CloudflareClient() *cloudflare.Client {
+	return e.cloudflareClient
+}
@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/internal/ratelimit"
+	"kandji-cloudflare-device-sync/kandji"
+)
+
+// Reload switches a running engine to a changed configuration. The configuration is
+// validated and the lists are checked as in New, then handed to the syncer, which applies
+// it before its next cycle, so a cycle in progress finishes with the previous one. On error
+// nothing changes.
+//
+// The Kandji client, and with it its inventory cache, is only replaced if the Kandji API
+// URL, token or proxy changed. A token that was rotated, read again from the same secret
+// reference or file, is switched to in place, as are those of the Kandji tenants. kandjiClient is a client shared by another engine, as
+// Options.KandjiClient; it replaces the engine's own if not nil. A new Cloudflare client is
+// always created, as it holds the target list. Changed rate limits are put into effect on
+// the engine's rate limiter, replacing any set at runtime. What else the engine was created with, such
+// as destinations, notifiers, Intune, Mosyle and the Kandji tenants, is kept.
+func (e *Engine) Reload(ctx context.Context, cfg *config.Config, kandjiClient *kandji.Client) error {
+	if err := cfg.Validate(); err != nil {
+		return &SetupError{Step: "Invalid configuration", Err: fmt.Errorf("%w: %w", ErrInvalidConfig, err)}
+	}
+
+	if kandjiClient == nil {
+		kandjiClient = e.kandjiClient
+		if kandjiCredentialsChanged(e.config, cfg) {
+			kandjiClient = nil
+			if cfg.KandjiEnabled() {
+				client, err := newKandjiClient(ctx, cfg, e.opts)
+				if err != nil {
+					return err
+				}
+				kandjiClient = client
+			}
+			e.opts.Logger.Info("Kandji credentials changed, replaced the Kandji client")
+		} else if kandjiClient != nil && cfg.Kandji.ApiToken != e.config.Kandji.ApiToken {
+			kandjiClient.SetToken(cfg.Kandji.ApiToken)
+			e.opts.Logger.Info("Kandji API token rotated, switched the Kandji client to it")
+		}
+	}
+	if e.opts.KandjiTenants == nil {
+		e.rotateTenantTokens(cfg)
+	}
+
+	cloudflareClient, err := newCloudflareClient(cfg, e.opts)
+	if err != nil {
+		return err
+	}
+	if err := checkLists(ctx, cfg, cloudflareClient, e.opts.CreatedLists, e.opts.Logger); err != nil {
+		return err
+	}
+
+	if cfg.RateLimits != e.config.RateLimits {
+		e.opts.RateLimiter.Reconfigure(ratelimit.Config{
+			KandjiRequestsPerSecond:     cfg.RateLimits.KandjiRequestsPerSecond,
+			CloudflareRequestsPerSecond: cfg.RateLimits.CloudflareRequestsPerSecond,
+			BurstCapacity:               cfg.RateLimits.BurstCapacity,
+		})
+	}
+	e.syncer.Reload(cfg, kandjiClient, cloudflareClient)
+	e.kandjiClient, e.cloudflareClient, e.config = kandjiClient, cloudflareClient, cfg
+	return nil
+}
+
+// kandjiCredentialsChanged reports whether the Kandji client of the old configuration cannot
+// be used with the new one. A token read from the same secret reference or file as before
+// was rotated, and is switched to without replacing the client.
+func kandjiCredentialsChanged(old, cfg *config.Config) bool {
+	origin := cfg.SecretOrigin("kandji.api_token")
+	return old.KandjiEnabled() != cfg.KandjiEnabled() ||
+		old.Kandji.ApiURL != cfg.Kandji.ApiURL ||
+		(old.Kandji.ApiToken != cfg.Kandji.ApiToken && origin == "") ||
+		old.SecretOrigin("kandji.api_token") != origin ||
+		old.Kandji.ProxyURL != cfg.Kandji.ProxyURL
+}
+
+// rotateTenantTokens switches the clients of the Kandji tenants to their rotated tokens. The
+// tenants are matched by name; other changes to them take effect after a restart.
+func (e *Engine) rotateTenantTokens(cfg *config.Config) {
+	for i, tenant := range cfg.Kandji.Tenants {
+		origin := cfg.SecretOrigin(config.TenantTokenSetting(i))
+		for j, old := range e.config.Kandji.Tenants {
+			if old.Name != tenant.Name || j >= len(e.kandjiTenants) {
+				continue
+			}
+			if origin != "" && origin == e.config.SecretOrigin(config.TenantTokenSetting(j)) && old.ApiToken != tenant.ApiToken {
+				e.kandjiTenants[j].Client.SetToken(tenant.ApiToken)
+				e.opts.Logger.Info("Kandji API token rotated, switched the Kandji tenant client to it", "tenant", tenant.Name)
+			}
+		}
+	}
+}
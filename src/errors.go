@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"kandji-cloudflare-device-sync/engine"
+)
+
+// errorFormat selects how startup and one-shot failures are reported: "text" only logs
+// them, "json" additionally writes a structured error to stderr.
+var errorFormat = "text"
+
+// structuredError is the JSON written to stderr for a failure in the json error format.
+type structuredError struct {
+	Code    int            `json:"code"`
+	Type    string         `json:"type"`
+	Message string         `json:"message"`
+	Details map[string]any `json:"details,omitempty"`
+	Hints   []string       `json:"hints,omitempty"`
+}
+
+// fail reports a startup or one-shot failure and exits with code. args are slog key-value
+// pairs describing the failure.
+func fail(log *slog.Logger, code int, message string, args ...any) {
+	log.Error(message, args...)
+
+	if errorFormat == "json" {
+		details := make(map[string]any)
+		for i := 0; i+1 < len(args); i += 2 {
+			key := fmt.Sprint(args[i])
+			if err, ok := args[i+1].(error); ok {
+				details[key] = err.Error()
+			} else {
+				details[key] = args[i+1]
+			}
+		}
+		encoder := json.NewEncoder(os.Stderr)
+		encoder.SetEscapeHTML(false)
+		_ = encoder.Encode(structuredError{
+			Code:    code,
+			Type:    errorType(code),
+			Message: message,
+			Details: details,
+			Hints:   errorHints(code, message),
+		})
+	}
+
+	os.Exit(code)
+}
+
+// failSetup reports a failure of engine.New, keeping the failed step as the message and
+// exiting with the code for its cause.
+func failSetup(log *slog.Logger, err error) {
+	message := "Failed to set up the sync engine"
+	var setupErr *engine.SetupError
+	if errors.As(err, &setupErr) {
+		message, err = setupErr.Step, setupErr.Err
+	}
+	code := apiExitCode(err, exitValidation)
+	if errors.Is(err, engine.ErrInvalidConfig) {
+		code = exitConfig
+	}
+	fail(log, code, message, "error", err)
+}
+
+func errorType(code int) string {
+	switch code {
+	case exitConfig:
+		return "config_error"
+	case exitAuth:
+		return "auth_error"
+	case exitValidation:
+		return "validation_error"
+	case exitSyncFailed:
+		return "sync_failed"
+	case exitPartial:
+		return "partial_success"
+	default:
+		return "error"
+	}
+}
+
+// errorHints suggests remediation steps for a failure.
+func errorHints(code int, message string) []string {
+	switch code {
+	case exitConfig:
+		return []string{"Run the validate command with the same -config to list every configuration problem."}
+	case exitAuth:
+		if strings.Contains(message, "Kandji") {
+			return []string{"Check that KANDJI_API_TOKEN is current, belongs to this tenant, and has the Device List permission."}
+		}
+		return []string{"Check that CLOUDFLARE_API_TOKEN is current and has Zero Trust read and edit permissions for the account."}
+	case exitValidation:
+		if strings.Contains(message, "Kandji") {
+			return []string{"Check kandji.api_url: it should be https://<tenant>.api.kandji.io (or .api.eu.kandji.io)."}
+		}
+		return []string{
+			"Run the lists command to see the IDs, names and types of the account's Gateway lists.",
+			"Check that the API token has access to the configured lists.",
+		}
+	case exitSyncFailed:
+		return []string{"Check the logs of the failed cycle; the next run retries the full sync."}
+	case exitPartial:
+		return []string{"Devices that could not be added are retried in the next cycle."}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,224 @@
+// Package events keeps the most recent lifecycle events and errors of the service in memory,
+// so they can be inspected through the admin API without access to the logs.
+package events
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Event types recorded by the service. Warnings and errors that are logged are recorded
+// with the type of their log level.
+const (
+	TypeStartup           = "startup"
+	TypeShutdown          = "shutdown"
+	TypeCycleFinished     = "cycle_finished"
+	TypeCycleFailed       = "cycle_failed"
+	TypeSyncTriggered     = "sync_triggered"
+	TypeSyncPaused        = "sync_paused"
+	TypeSyncResumed       = "sync_resumed"
+	TypeRateLimitsChanged = "rate_limits_changed"
+	TypeConfigReloaded    = "config_reloaded"
+	TypeCircuitOpened     = "circuit_opened"
+	TypeCircuitClosed     = "circuit_closed"
+	TypeLeaderChanged     = "leader_changed"
+	TypeBlackoutStarted   = "blackout_started"
+	TypeBlackoutEnded     = "blackout_ended"
+	TypeWarning           = "warning"
+	TypeError             = "error"
+)
+
+// Event is a single recorded event.
+type Event struct {
+	ID      uint64         `json:"id"`
+	Time    time.Time      `json:"time"`
+	Type    string         `json:"type"`
+	Message string         `json:"message"`
+	Attrs   map[string]any `json:"attrs,omitempty"`
+}
+
+// Buffer is a fixed-size ring buffer of events; once full, the oldest event is overwritten.
+// It is safe for concurrent use.
+type Buffer struct {
+	mu     sync.Mutex
+	events []Event
+	next   int
+	full   bool
+	lastID uint64
+}
+
+// NewBuffer creates a Buffer holding the last size events.
+func NewBuffer(size int) *Buffer {
+	if size < 1 {
+		size = 1
+	}
+	return &Buffer{events: make([]Event, size)}
+}
+
+// Record adds an event with the given type, message and attributes (as key-value pairs,
+// like slog).
+func (b *Buffer) Record(eventType, message string, args ...any) {
+	b.add(eventType, message, attrsFromArgs(args))
+}
+
+func (b *Buffer) add(eventType, message string, attrs map[string]any) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastID++
+	b.events[b.next] = Event{
+		ID:      b.lastID,
+		Time:    time.Now().UTC(),
+		Type:    eventType,
+		Message: message,
+		Attrs:   attrs,
+	}
+	b.next = (b.next + 1) % len(b.events)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// Filter selects events returned by Events. Zero fields match everything.
+type Filter struct {
+	Type    string
+	Since   time.Time
+	AfterID uint64
+	// Limit keeps only the newest Limit matching events
+	Limit int
+}
+
+// Events returns the buffered events matching the filter, oldest first.
+func (b *Buffer) Events(filter Filter) []Event {
+	b.mu.Lock()
+	var ordered []Event
+	if b.full {
+		ordered = append(ordered, b.events[b.next:]...)
+	}
+	ordered = append(ordered, b.events[:b.next]...)
+	b.mu.Unlock()
+
+	matched := make([]Event, 0, len(ordered))
+	for _, e := range ordered {
+		if filter.Type != "" && e.Type != filter.Type {
+			continue
+		}
+		if !filter.Since.IsZero() && e.Time.Before(filter.Since) {
+			continue
+		}
+		if e.ID <= filter.AfterID {
+			continue
+		}
+		matched = append(matched, e)
+	}
+	if filter.Limit > 0 && len(matched) > filter.Limit {
+		matched = matched[len(matched)-filter.Limit:]
+	}
+	return matched
+}
+
+func attrsFromArgs(args []any) map[string]any {
+	if len(args) == 0 {
+		return nil
+	}
+	r := slog.NewRecord(time.Time{}, 0, "", 0)
+	r.Add(args...)
+	attrs := make(map[string]any, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		addAttr(attrs, "", a)
+		return true
+	})
+	return attrs
+}
+
+func addAttr(attrs map[string]any, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	key := a.Key
+	if prefix != "" {
+		key = prefix + "." + key
+	}
+	if v.Kind() == slog.KindGroup {
+		for _, ga := range v.Group() {
+			addAttr(attrs, key, ga)
+		}
+		return
+	}
+	if err, ok := v.Any().(error); ok {
+		attrs[key] = err.Error()
+		return
+	}
+	attrs[key] = v.Any()
+}
+
+// handler passes log records on to the next handler and records those at or above its
+// level in the buffer.
+type handler struct {
+	next   slog.Handler
+	buffer *Buffer
+	level  slog.Level
+	attrs  []slog.Attr
+	group  string
+}
+
+// NewHandler wraps next so that every record at or above level is also recorded in the
+// buffer, as a TypeError or TypeWarning event.
+func NewHandler(next slog.Handler, buffer *Buffer, level slog.Level) slog.Handler {
+	return &handler{next: next, buffer: buffer, level: level}
+}
+
+func (h *handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level || h.next.Enabled(ctx, level)
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= h.level {
+		attrs := make(map[string]any)
+		for _, a := range h.attrs {
+			addAttr(attrs, "", a)
+		}
+		r.Attrs(func(a slog.Attr) bool {
+			addAttr(attrs, h.group, a)
+			return true
+		})
+		eventType := TypeWarning
+		if r.Level >= slog.LevelError {
+			eventType = TypeError
+		}
+		if len(attrs) == 0 {
+			attrs = nil
+		}
+		h.buffer.add(eventType, r.Message, attrs)
+	}
+	if !h.next.Enabled(ctx, r.Level) {
+		return nil
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.next = h.next.WithAttrs(attrs)
+	c.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		if h.group != "" {
+			a = slog.Group(h.group, a)
+		}
+		c.attrs = append(c.attrs, a)
+	}
+	return &c
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.next = h.next.WithGroup(name)
+	if h.group != "" {
+		c.group = h.group + "." + name
+	} else {
+		c.group = name
+	}
+	return &c
+}
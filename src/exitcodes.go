@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+
+	"kandji-cloudflare-device-sync/cloudflare"
+	"kandji-cloudflare-device-sync/kandji"
+)
+
+// Process exit codes, so wrappers and cron monitors can branch on the kind of failure.
+const (
+	exitOK = 0
+	// exitFailure is any failure not covered by a more specific code
+	exitFailure = 1
+	// exitConfig means the configuration could not be loaded or is invalid
+	exitConfig = 2
+	// exitAuth means Kandji or Cloudflare rejected the API token
+	exitAuth = 3
+	// exitValidation means a startup check against the APIs failed, e.g. a missing list
+	exitValidation = 4
+	// exitSyncFailed means the sync cycle failed in -once mode
+	exitSyncFailed = 5
+	// exitPartial means the sync cycle completed in -once mode but some devices could not be added
+	exitPartial = 6
+)
+
+// apiExitCode returns exitAuth for errors caused by a rejected API token, and fallback otherwise.
+func apiExitCode(err error, fallback int) int {
+	if errors.Is(err, kandji.ErrUnauthorized) || errors.Is(err, cloudflare.ErrUnauthorized) {
+		return exitAuth
+	}
+	return fallback
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"kandji-cloudflare-device-sync/cloudflare"
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/destination"
+	"kandji-cloudflare-device-sync/engine"
+	"kandji-cloudflare-device-sync/internal/httpclient"
+	"kandji-cloudflare-device-sync/internal/ratelimit"
+)
+
+// exportedItem is an entry of the target list as written by export.
+type exportedItem struct {
+	Value     string    `json:"value"`
+	Comment   string    `json:"comment"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const exportUsage = `usage:
+  export target [-format csv|json]
+  export devices [-format csv|json]`
+
+// runExport writes the entries of the target list, or the devices that pass the filters, to
+// stdout as CSV or JSON.
+func runExport(args []string) int {
+	if len(args) == 0 || (args[0] != "target" && args[0] != "devices") {
+		fmt.Fprintln(os.Stderr, exportUsage)
+		if len(args) > 0 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help") {
+			return exitOK
+		}
+		return exitConfig
+	}
+	what := args[0]
+	fs := newFlagSet("export " + what)
+	format := fs.String("format", "csv", "Output format: csv, json")
+	// The target list only needs the Cloudflare credentials
+	parse := config.ParseConfigArgs
+	if what == "target" {
+		parse = config.ParseCloudflareConfigArgs
+	}
+	cfg, err := parse(fs, args[1:])
+	if err != nil {
+		slog.Error("Failed to load configuration", "error", err)
+		return exitConfig
+	}
+	if *format != "csv" && *format != "json" {
+		slog.Error("Invalid output format", "format", *format)
+		return exitConfig
+	}
+	if cfg.Sandbox.Enabled {
+		slog.Error("Export reads the real APIs, turn off sandbox mode")
+		return exitConfig
+	}
+
+	log := slog.New(slog.NewJSONHandler(os.Stderr, nil))
+	if what == "target" {
+		items, err := targetListItems(context.Background(), cfg, log)
+		if err != nil {
+			log.Error("Failed to get items of target list", "error", err)
+			return apiExitCode(err, exitFailure)
+		}
+		err = writeExport(os.Stdout, *format, items, []string{"value", "comment", "created_at"}, func(item exportedItem) []string {
+			return []string{item.Value, item.Comment, item.CreatedAt.Format(time.RFC3339)}
+		})
+		if err != nil {
+			log.Error("Failed to write export", "error", err)
+			return exitFailure
+		}
+		return exitOK
+	}
+
+	// Nothing is changed, the engine only downloads and filters the inventory
+	cfg.DryRun = true
+	syncEngine, err := engine.New(context.Background(), engine.Options{
+		Config:    cfg,
+		Logger:    log,
+		UserAgent: httpclient.UserAgent(Version, cfg.Client.InstanceID, cfg.Client.UserAgentSuffix),
+	})
+	if err != nil {
+		failSetup(log, err)
+	}
+	devices, err := syncEngine.Syncer().EligibleDevices(context.Background())
+	if err != nil {
+		log.Error("Failed to get devices", "error", err)
+		return apiExitCode(err, exitFailure)
+	}
+	header := []string{"serial_number", "device_name", "user_email", "platform", "blueprint", "last_seen", "tags", "source"}
+	err = writeExport(os.Stdout, *format, devices, header, func(device destination.Device) []string {
+		return []string{device.SerialNumber, device.DeviceName, device.UserEmail, device.Platform, device.Blueprint, device.LastSeen, strings.Join(device.Tags, ";"), device.Source}
+	})
+	if err != nil {
+		log.Error("Failed to write export", "error", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// targetListItems returns the entries of the configured target list, resolving it if it
+// is configured by name.
+func targetListItems(ctx context.Context, cfg *config.Config, log *slog.Logger) ([]exportedItem, error) {
+	rateLimiter := ratelimit.New(ratelimit.Config{
+		KandjiRequestsPerSecond:     cfg.RateLimits.KandjiRequestsPerSecond,
+		CloudflareRequestsPerSecond: cfg.RateLimits.CloudflareRequestsPerSecond,
+		BurstCapacity:               cfg.RateLimits.BurstCapacity,
+	})
+	userAgent := httpclient.UserAgent(Version, cfg.Client.InstanceID, cfg.Client.UserAgentSuffix)
+	client, err := cloudflare.NewClient(cfg.Cloudflare, rateLimiter, log, cloudflare.WithUserAgent(userAgent), cloudflare.WithNetwork(cfg.Network))
+	if err != nil {
+		return nil, err
+	}
+	listID := cfg.Cloudflare.ListID
+	if listID == "" {
+		if listID, err = client.ResolveListID(ctx, cfg.Cloudflare.TargetListName); err != nil {
+			return nil, err
+		}
+	}
+	items, err := client.GetListItemsByID(ctx, listID)
+	if err != nil {
+		return nil, err
+	}
+	exported := make([]exportedItem, 0, len(items))
+	for _, item := range items {
+		exported = append(exported, exportedItem{Value: item.Value, Comment: item.Comment, CreatedAt: item.CreatedAt})
+	}
+	return exported, nil
+}
+
+// writeExport writes rows as a JSON array, or as CSV with a header row and the columns
+// returned by record.
+func writeExport[T any](w io.Writer, format string, rows []T, header []string, record func(T) []string) error {
+	if format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if rows == nil {
+			rows = []T{}
+		}
+		return encoder.Encode(rows)
+	}
+	csvWriter := csv.NewWriter(w)
+	if err := csvWriter.Write(header); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := csvWriter.Write(record(row)); err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
@@ -0,0 +1,141 @@
+// Package filesource reads serial numbers, with optional comments, from local CSV and JSON
+// files, for devices that are not managed by any MDM such as lab hardware and loaners.
+package filesource
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Entry is a serial number read from a file.
+type Entry struct {
+	SerialNumber string
+	Comment      string
+	// File is the path of the file the entry was read from
+	File string
+}
+
+// Read reads the entries of every file matched by the glob patterns, file by file in name
+// order. A pattern that matches no file is not an error, so a file can be removed to remove
+// its devices; a file that cannot be read or parsed is.
+func Read(patterns []string) ([]Entry, error) {
+	var files []string
+	seen := make(map[string]struct{})
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source file pattern %q: %w", pattern, err)
+		}
+		for _, match := range matches {
+			if _, ok := seen[match]; !ok {
+				seen[match] = struct{}{}
+				files = append(files, match)
+			}
+		}
+	}
+	sort.Strings(files)
+
+	var entries []Entry
+	for _, file := range files {
+		fileEntries, err := readFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("source file %s: %w", file, err)
+		}
+		entries = append(entries, fileEntries...)
+	}
+	return entries, nil
+}
+
+// readFile reads a JSON file if its name ends in .json, and a CSV file otherwise.
+func readFile(file string) ([]Entry, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	if strings.EqualFold(filepath.Ext(file), ".json") {
+		entries, err = parseJSON(data)
+	} else {
+		entries, err = parseCSV(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		entries[i].File = file
+	}
+	return entries, nil
+}
+
+// parseCSV parses rows of a serial number and an optional comment. Lines starting with #
+// and a header row whose first column is serial_number or serial are skipped.
+func parseCSV(data []byte) ([]Entry, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.Comment = '#'
+	r.TrimLeadingSpace = true
+
+	var entries []Entry
+	for first := true; ; first = false {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		serial := strings.TrimSpace(record[0])
+		if first {
+			switch strings.ToLower(serial) {
+			case "serial_number", "serial":
+				continue
+			}
+		}
+		if serial == "" {
+			continue
+		}
+		entry := Entry{SerialNumber: serial}
+		if len(record) > 1 {
+			entry.Comment = strings.TrimSpace(record[1])
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// parseJSON parses an array whose elements are serial number strings or objects with a
+// serial_number and an optional comment.
+func parseJSON(data []byte) ([]Entry, error) {
+	var elements []json.RawMessage
+	if err := json.Unmarshal(data, &elements); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return nil, fmt.Errorf("expected a JSON array, got %s", typeErr.Value)
+		}
+		return nil, err
+	}
+	var entries []Entry
+	for i, element := range elements {
+		var serial string
+		if err := json.Unmarshal(element, &serial); err == nil {
+			entries = append(entries, Entry{SerialNumber: serial})
+			continue
+		}
+		var object struct {
+			SerialNumber string `json:"serial_number"`
+			Comment      string `json:"comment"`
+		}
+		if err := json.Unmarshal(element, &object); err != nil {
+			return nil, fmt.Errorf("element %d must be a serial number or an object with a serial_number", i)
+		}
+		entries = append(entries, Entry{SerialNumber: object.SerialNumber, Comment: object.Comment})
+	}
+	return entries, nil
+}
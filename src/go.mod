@@ -5,6 +5,8 @@ go 1.23.10
 toolchain go1.24.1
 
 require (
+	go.etcd.io/bbolt v1.4.3
+	golang.org/x/crypto v0.40.0
 	golang.org/x/time v0.12.0
 	gopkg.in/yaml.v2 v2.4.0
 )
@@ -12,5 +14,6 @@ require (
 require (
 	github.com/kr/pretty v0.3.1 // indirect
 	github.com/rogpeppe/go-internal v1.13.1 // indirect
+	golang.org/x/sys v0.34.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
 )
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/state"
+)
+
+const historyUsage = `usage:
+  history cycles [-since 7d] [-format table|json]
+  history device <serial> [-since 7d] [-format table|json]`
+
+// runHistory queries the sync history recorded in the state store for past cycles or
+// for the changes of a single device.
+func runHistory(args []string) int {
+	if len(args) > 0 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help") {
+		fmt.Fprintln(os.Stderr, historyUsage)
+		return exitOK
+	}
+	if len(args) == 0 || (args[0] != "cycles" && args[0] != "device") {
+		fmt.Fprintln(os.Stderr, historyUsage)
+		return exitConfig
+	}
+	query := args[0]
+	args = args[1:]
+	var serial string
+	if query == "device" {
+		if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+			fmt.Fprintln(os.Stderr, historyUsage)
+			return exitConfig
+		}
+		serial = args[0]
+		args = args[1:]
+	}
+
+	fs := newFlagSet("history " + query)
+	sinceFlag := fs.String("since", "", "Only include cycles since this time: a duration such as 7d or 12h, a date, or an RFC 3339 time")
+	format := fs.String("format", "table", "Output format: table, json")
+	cfg, err := config.ParseStateConfigArgs(fs, args)
+	if err != nil {
+		slog.Error("Failed to load configuration", "error", err)
+		return exitConfig
+	}
+	if *format != "table" && *format != "json" {
+		slog.Error("Invalid output format", "format", *format)
+		return exitConfig
+	}
+	var since time.Time
+	if *sinceFlag != "" {
+		since, err = parseSince(*sinceFlag, time.Now().UTC())
+		if err != nil {
+			slog.Error("Invalid -since", "since", *sinceFlag, "error", err)
+			return exitConfig
+		}
+	}
+
+	store, err := state.Open(cfg.State.Path, cfg.State.Retention)
+	if err != nil {
+		slog.Error("Failed to open state store", "path", cfg.State.Path, "error", err)
+		return exitFailure
+	}
+	cycles := state.CyclesSince(store.Cycles(), since)
+
+	if query == "cycles" {
+		err = printCycleHistory(cycles, *format)
+	} else {
+		record, seen := store.Device(serial)
+		err = printDeviceHistory(state.DeviceHistory(cycles, serial), serial, record, seen, *format)
+	}
+	if err != nil {
+		slog.Error("Failed to write history", "error", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+func printCycleHistory(cycles []state.Cycle, format string) error {
+	if format == "json" {
+		return writeJSON(nonNilSlice(cycles))
+	}
+	if len(cycles) == 0 {
+		fmt.Println("No sync cycles recorded in this period.")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STARTED\tDURATION\tDEVICES\tADDED\tREMOVED\tFAILED TO ADD\tERROR")
+	for _, cycle := range cycles {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%s\n",
+			cycle.StartedAt.UTC().Format(time.RFC3339),
+			cycle.Duration().Round(time.Millisecond),
+			cycle.Devices, len(cycle.Added), len(cycle.Removed), len(cycle.Failed), cycle.Error)
+	}
+	return w.Flush()
+}
+
+func printDeviceHistory(events []state.DeviceEvent, serial string, record state.DeviceRecord, seen bool, format string) error {
+	if format == "json" {
+		return writeJSON(nonNilSlice(events))
+	}
+	if seen {
+		fmt.Printf("First seen: %s\nLast seen:  %s\n\n",
+			record.FirstSeen.UTC().Format(time.RFC3339), record.LastSeen.UTC().Format(time.RFC3339))
+	}
+	if len(events) == 0 {
+		fmt.Printf("No changes recorded for %s in this period.\n", serial)
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tSERIAL\tACTION")
+	for _, event := range events {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", event.Time.UTC().Format(time.RFC3339), event.SerialNumber, event.Action)
+	}
+	return w.Flush()
+}
+
+// parseSince parses a -since value: a number of days such as 7d, a Go duration such as
+// 12h, a date (2006-01-02) or an RFC 3339 time.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("expected a duration such as 7d or 12h, a date, or an RFC 3339 time")
+}
+
+func writeJSON(v any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// nonNilSlice turns a nil slice into an empty one, so it is written as [] rather than null.
+func nonNilSlice[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
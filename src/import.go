@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"kandji-cloudflare-device-sync/audit"
+	"kandji-cloudflare-device-sync/cloudflare"
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/filesource"
+	"kandji-cloudflare-device-sync/syncer"
+)
+
+// runImport appends the serials of CSV or JSON files to the target list, e.g. to seed a new
+// list before the service is enabled. Serials already in the list are skipped.
+func runImport(args []string) int {
+	fs := newFlagSet("import")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s import [flags] <file>...\n\nAppend the serials of CSV or JSON files to the target list.\n\nflags:\n", programName())
+		fs.PrintDefaults()
+	}
+	cfg, err := config.ParseCloudflareConfigArgs(fs, args)
+	if err != nil {
+		slog.Error("Failed to load configuration", "error", err)
+		return exitConfig
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitConfig
+	}
+
+	log := slog.New(slog.NewJSONHandler(os.Stderr, nil))
+	entries, err := filesource.Read(fs.Args())
+	if err != nil {
+		log.Error("Failed to read import files", "error", err)
+		return exitFailure
+	}
+	if len(entries) == 0 {
+		log.Error("No serials found in the import files", "files", fs.Args())
+		return exitFailure
+	}
+
+	client, closeAudit, err := newAuditedCloudflareClient(cfg, log)
+	if err != nil {
+		log.Error("Failed to create Cloudflare client", "error", err)
+		return exitConfig
+	}
+	defer closeAudit()
+
+	ctx := context.Background()
+	listID := cfg.Cloudflare.ListID
+	if listID == "" {
+		if listID, err = client.ResolveListID(ctx, cfg.Cloudflare.TargetListName); err != nil {
+			log.Error("Failed to resolve target list", "name", cfg.Cloudflare.TargetListName, "error", err)
+			return apiExitCode(err, exitValidation)
+		}
+	}
+	if err := client.ValidateListExistsByID(ctx, listID, client.DeviceListType()); err != nil {
+		log.Error("Failed to validate target list", "list_id", listID, "error", err)
+		return apiExitCode(err, exitValidation)
+	}
+	targetItems, err := client.GetListItemsByID(ctx, listID)
+	if err != nil {
+		log.Error("Failed to get items of target list", "list_id", listID, "error", err)
+		return apiExitCode(err, exitFailure)
+	}
+
+	items, skipped := importItems(entries, targetItems, cfg.Cloudflare)
+	log.Info("Importing serials into target list", "list_id", listID, "read", len(entries), "new", len(items), "skipped", skipped, "batch_size", cfg.Batch.Size, "dry_run", cfg.DryRun)
+
+	ctx = audit.WithAnnotations(ctx, importAnnotations(items))
+	imported := 0
+	for start := 0; start < len(items); start += cfg.Batch.Size {
+		end := min(start+cfg.Batch.Size, len(items))
+		if err := client.AppendItemsByID(ctx, listID, items[start:end]); err != nil {
+			log.Error("Failed to append serials to target list", "list_id", listID, "imported", imported, "remaining", len(items)-imported, "error", err)
+			return apiExitCode(err, exitFailure)
+		}
+		imported = end
+	}
+
+	verb := "imported"
+	if cfg.DryRun {
+		verb = "would import"
+	}
+	fmt.Printf("%s %d serials into list %s, skipped %d already in the list or repeated\n", verb, imported, listID, skipped)
+	return exitOK
+}
+
+// importItems returns the list items to append for the imported entries: sanitized,
+// deduplicated, not yet in the target list, and with the comment the service would write.
+// It also returns how many entries were skipped.
+func importItems(entries []filesource.Entry, targetItems []cloudflare.GatewayListItem, cfg config.CloudflareConfig) ([]cloudflare.GatewayListItemCreateRequest, int) {
+	seen := make(map[string]struct{}, len(targetItems)+len(entries))
+	for _, item := range targetItems {
+		seen[item.Value] = struct{}{}
+	}
+	var items []cloudflare.GatewayListItemCreateRequest
+	skipped := 0
+	for _, entry := range entries {
+		serial := syncer.SanitizeSerial(entry.SerialNumber)
+		if _, ok := seen[serial]; ok || serial == "" {
+			skipped++
+			continue
+		}
+		seen[serial] = struct{}{}
+		comment := cloudflare.TruncateComment(cloudflare.WithMarker(entry.Comment, cfg.ManagedMarker), cfg.ManagedMarker, cfg.Comment.MaxLength)
+		items = append(items, cloudflare.GatewayListItemCreateRequest{Value: serial, Comment: comment})
+	}
+	return items, skipped
+}
+
+// importAnnotations records imported serials in the audit log with the import as their source.
+func importAnnotations(items []cloudflare.GatewayListItemCreateRequest) audit.Annotations {
+	annotations := make(audit.Annotations, len(items))
+	for _, item := range items {
+		annotations[item.Value] = audit.Annotation{Source: "import"}
+	}
+	return annotations
+}
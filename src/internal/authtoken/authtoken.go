@@ -0,0 +1,72 @@
+// Package authtoken holds an API token that can be rotated while requests are using it.
+package authtoken
+
+import (
+	"context"
+	"sync"
+)
+
+// Source reads the current token, e.g. again from the secret backend or file it came from.
+type Source func(ctx context.Context) (string, error)
+
+// Token is an API token shared by the requests of a client. It is replaced with Set when the
+// configured token changes, or by Refresh when the API rejects it.
+type Token struct {
+	mu     sync.Mutex
+	value  string
+	source Source
+
+	// refreshMu lets one request read the source at a time, so that requests rejected
+	// together read it once
+	refreshMu sync.Mutex
+}
+
+// New returns a token holding value.
+func New(value string) *Token {
+	return &Token{value: value}
+}
+
+// Get returns the current token.
+func (t *Token) Get() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.value
+}
+
+// Set replaces the token.
+func (t *Token) Set(value string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.value = value
+}
+
+// SetSource sets where Refresh reads the current token from.
+func (t *Token) SetSource(source Source) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.source = source
+}
+
+// Refresh is called when the API rejected the token rejected. It returns the token to retry
+// with and true if there is a different one: either the token was replaced in the meantime,
+// or the source returns a new one, which replaces it.
+func (t *Token) Refresh(ctx context.Context, rejected string) (string, bool) {
+	t.refreshMu.Lock()
+	defer t.refreshMu.Unlock()
+
+	t.mu.Lock()
+	current, source := t.value, t.source
+	t.mu.Unlock()
+	if current != rejected {
+		return current, true
+	}
+	if source == nil {
+		return "", false
+	}
+	value, err := source(ctx)
+	if err != nil || value == "" || value == rejected {
+		return "", false
+	}
+	t.Set(value)
+	return value, true
+}
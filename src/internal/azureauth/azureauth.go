@@ -0,0 +1,166 @@
+package azureauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAuthorityHost = "https://login.microsoftonline.com/"
+	imdsTokenURL         = "http://169.254.169.254/metadata/identity/oauth2/token"
+)
+
+// Options selects the identity of a TokenSource. Empty fields are read from the environment
+// variables AZURE_TENANT_ID, AZURE_CLIENT_ID, AZURE_CLIENT_SECRET, AZURE_FEDERATED_TOKEN_FILE
+// and AZURE_AUTHORITY_HOST, as set by AKS workload identity.
+type Options struct {
+	TenantID string
+	// ClientID is the application of a workload identity or service principal, or the
+	// user-assigned managed identity to use
+	ClientID     string
+	ClientSecret string
+	// FederatedTokenFile is the Kubernetes service account token of a workload identity
+	FederatedTokenFile string
+	AuthorityHost      string
+}
+
+// TokenSource issues access tokens for one resource, such as https://vault.azure.net, caching
+// each token until shortly before it expires. It authenticates as a workload identity if a
+// federated token file is set, as a service principal if a client secret is set, and
+// otherwise as the managed identity of the VM or node, through the instance metadata service.
+type TokenSource struct {
+	opts       Options
+	resource   string
+	httpClient *http.Client
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// NewTokenSource returns a token source for resource.
+func NewTokenSource(opts Options, resource string) *TokenSource {
+	fromEnv := func(value *string, name string) {
+		if *value == "" {
+			*value = os.Getenv(name)
+		}
+	}
+	fromEnv(&opts.TenantID, "AZURE_TENANT_ID")
+	fromEnv(&opts.ClientID, "AZURE_CLIENT_ID")
+	fromEnv(&opts.ClientSecret, "AZURE_CLIENT_SECRET")
+	fromEnv(&opts.FederatedTokenFile, "AZURE_FEDERATED_TOKEN_FILE")
+	fromEnv(&opts.AuthorityHost, "AZURE_AUTHORITY_HOST")
+	if opts.AuthorityHost == "" {
+		opts.AuthorityHost = defaultAuthorityHost
+	}
+	if !strings.HasSuffix(opts.AuthorityHost, "/") {
+		opts.AuthorityHost += "/"
+	}
+	return &TokenSource{
+		opts:       opts,
+		resource:   strings.TrimSuffix(resource, "/"),
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Method names how the token source authenticates.
+func (t *TokenSource) Method() string {
+	switch {
+	case t.opts.FederatedTokenFile != "":
+		return "workload identity"
+	case t.opts.ClientSecret != "":
+		return "client secret"
+	default:
+		return "managed identity"
+	}
+}
+
+// Token returns a valid access token, requesting a new one if the cached token is about to expire.
+func (t *TokenSource) Token(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.token != "" && time.Until(t.expiry) > time.Minute {
+		return t.token, nil
+	}
+
+	req, err := t.newRequest(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %w", err)
+	}
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute token request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s token request failed: HTTP %d - %s", t.Method(), resp.StatusCode, string(body))
+	}
+
+	// The instance metadata service returns expires_in as a string
+	var response struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresIn   json.Number `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", fmt.Errorf("failed to decode token response: %w", err)
+	}
+	expiresIn, _ := response.ExpiresIn.Int64()
+
+	t.token = response.AccessToken
+	t.expiry = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	return t.token, nil
+}
+
+// newRequest builds the token request of the configured identity.
+func (t *TokenSource) newRequest(ctx context.Context) (*http.Request, error) {
+	if t.opts.FederatedTokenFile == "" && t.opts.ClientSecret == "" {
+		query := url.Values{"api-version": {"2018-02-01"}, "resource": {t.resource}}
+		if t.opts.ClientID != "" {
+			query.Set("client_id", t.opts.ClientID)
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", imdsTokenURL+"?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Metadata", "true")
+		return req, nil
+	}
+
+	if t.opts.TenantID == "" || t.opts.ClientID == "" {
+		return nil, fmt.Errorf("tenant ID and client ID are required for %s authentication", t.Method())
+	}
+	form := url.Values{
+		"grant_type": {"client_credentials"},
+		"client_id":  {t.opts.ClientID},
+		"scope":      {t.resource + "/.default"},
+	}
+	if t.opts.FederatedTokenFile != "" {
+		// The projected token is rotated by the kubelet, so it is read for every request
+		assertion, err := os.ReadFile(t.opts.FederatedTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read federated token: %w", err)
+		}
+		form.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+		form.Set("client_assertion", strings.TrimSpace(string(assertion)))
+	} else {
+		form.Set("client_secret", t.opts.ClientSecret)
+	}
+	tokenURL := t.opts.AuthorityHost + url.PathEscape(t.opts.TenantID) + "/oauth2/v2.0/token"
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
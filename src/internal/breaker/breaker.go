@@ -0,0 +1,94 @@
+// Package breaker implements a circuit breaker that stops calls to a failing dependency for a
+// cooldown period after a number of consecutive failures.
+package breaker
+
+import (
+	"sync"
+	"time"
+)
+
+// States of a Breaker.
+const (
+	Closed   = "closed"
+	Open     = "open"
+	HalfOpen = "half_open"
+)
+
+// Breaker opens after threshold consecutive failures. Once the cooldown has passed it is
+// half-open and lets calls through again: a success closes it, a failure opens it for
+// another cooldown.
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	open     bool
+	openedAt time.Time
+}
+
+// New creates a closed Breaker.
+func New(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Allow reports whether a call may be made at now. If not, it also returns when the
+// cooldown ends.
+func (b *Breaker) Allow(now time.Time) (bool, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.open {
+		return true, time.Time{}
+	}
+	until := b.openedAt.Add(b.cooldown)
+	return !now.Before(until), until
+}
+
+// Success records a successful call and reports whether it closed an open circuit.
+func (b *Breaker) Success() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wasOpen := b.open
+	b.failures = 0
+	b.open = false
+	return wasOpen
+}
+
+// Failure records a failed call at now and reports whether it opened the circuit. A
+// failure while half-open opens it again at once, which is reported as well.
+func (b *Breaker) Failure(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.open {
+		b.openedAt = now
+		return true
+	}
+	if b.failures < b.threshold {
+		return false
+	}
+	b.open = true
+	b.openedAt = now
+	return true
+}
+
+// State returns the state of the circuit at now.
+func (b *Breaker) State(now time.Time) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case !b.open:
+		return Closed
+	case now.Before(b.openedAt.Add(b.cooldown)):
+		return Open
+	default:
+		return HalfOpen
+	}
+}
+
+// Failures returns the number of consecutive failures.
+func (b *Breaker) Failures() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures
+}
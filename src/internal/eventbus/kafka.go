@@ -0,0 +1,109 @@
+package eventbus
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"kandji-cloudflare-device-sync/config"
+)
+
+// Kafka publishes messages to a Kafka topic through the v2 API of a Kafka REST Proxy,
+// such as the Confluent REST Proxy.
+type Kafka struct {
+	endpoint   string
+	username   string
+	password   string
+	httpClient *http.Client
+}
+
+type kafkaRecord struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+type kafkaProduceRequest struct {
+	Records []kafkaRecord `json:"records"`
+}
+
+type kafkaProduceResponse struct {
+	Offsets []struct {
+		Partition int    `json:"partition"`
+		Offset    int64  `json:"offset"`
+		ErrorCode *int   `json:"error_code"`
+		Error     string `json:"error"`
+	} `json:"offsets"`
+}
+
+// NewKafka creates a Kafka REST Proxy publisher.
+func NewKafka(cfg config.KafkaConfig) *Kafka {
+	return &Kafka{
+		endpoint: strings.TrimSuffix(cfg.RestProxyURL, "/") + "/topics/" + url.PathEscape(cfg.Topic),
+		username: cfg.Username,
+		password: cfg.Password,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+// Publish produces the messages as JSON records keyed by Message.Key.
+func (k *Kafka) Publish(ctx context.Context, messages []Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	request := kafkaProduceRequest{Records: make([]kafkaRecord, 0, len(messages))}
+	for _, m := range messages {
+		request.Records = append(request.Records, kafkaRecord{Key: m.Key, Value: m.Value})
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	if k.username != "" {
+		req.SetBasicAuth(k.username, k.password)
+	}
+
+	resp, err := k.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach Kafka REST Proxy: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Kafka REST Proxy returned status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	// Records can fail individually even though the request succeeded
+	var produced kafkaProduceResponse
+	if err := json.Unmarshal(respBody, &produced); err != nil {
+		return fmt.Errorf("failed to parse Kafka REST Proxy response: %w", err)
+	}
+	failed := 0
+	var firstError string
+	for _, offset := range produced.Offsets {
+		if offset.ErrorCode != nil {
+			if failed == 0 {
+				firstError = offset.Error
+			}
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d records were rejected by Kafka: %s", failed, len(messages), firstError)
+	}
+	return nil
+}
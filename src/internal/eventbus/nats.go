@@ -0,0 +1,165 @@
+// Package eventbus publishes messages to NATS subjects and, through a Kafka REST Proxy, to
+// Kafka topics.
+package eventbus
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"kandji-cloudflare-device-sync/config"
+)
+
+// Message is a single message to publish. Key is used as the Kafka record key so that
+// the messages of one device stay in order; NATS ignores it.
+type Message struct {
+	Key   string
+	Value json.RawMessage
+}
+
+// Publisher publishes a batch of messages.
+type Publisher interface {
+	Publish(ctx context.Context, messages []Message) error
+}
+
+// NATS publishes messages to a NATS subject using the NATS client protocol. A connection
+// is opened for every batch, as batches are sent once per sync cycle.
+type NATS struct {
+	url     *url.URL
+	subject string
+	token   string
+	user    string
+	pass    string
+	name    string
+}
+
+// NewNATS creates a NATS publisher. name identifies the connection on the server.
+func NewNATS(cfg config.NATSConfig, name string) (*NATS, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NATS URL: %w", err)
+	}
+	n := &NATS{url: u, subject: cfg.Subject, token: cfg.Token, user: cfg.Username, pass: cfg.Password, name: name}
+	if u.User != nil && n.user == "" && n.token == "" {
+		if pass, ok := u.User.Password(); ok {
+			n.user, n.pass = u.User.Username(), pass
+		} else {
+			n.token = u.User.Username()
+		}
+	}
+	return n, nil
+}
+
+type natsInfo struct {
+	TLSRequired bool `json:"tls_required"`
+	MaxPayload  int  `json:"max_payload"`
+}
+
+type natsConnect struct {
+	Verbose   bool   `json:"verbose"`
+	Pedantic  bool   `json:"pedantic"`
+	Name      string `json:"name"`
+	Lang      string `json:"lang"`
+	Version   string `json:"version"`
+	Protocol  int    `json:"protocol"`
+	AuthToken string `json:"auth_token,omitempty"`
+	User      string `json:"user,omitempty"`
+	Pass      string `json:"pass,omitempty"`
+}
+
+// Publish sends the messages and waits for the server to acknowledge them with a PONG.
+func (n *NATS) Publish(ctx context.Context, messages []Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	host := n.url.Host
+	if n.url.Port() == "" {
+		host = net.JoinHostPort(n.url.Hostname(), "4222")
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(60 * time.Second)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetDeadline(deadline)
+
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read NATS server info: %w", err)
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("unexpected NATS greeting: %q", strings.TrimSpace(line))
+	}
+	var info natsInfo
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info); err != nil {
+		return fmt.Errorf("failed to parse NATS server info: %w", err)
+	}
+
+	if n.url.Scheme == "tls" || info.TLSRequired {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: n.url.Hostname(), MinVersion: tls.VersionTLS12})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return fmt.Errorf("NATS TLS handshake failed: %w", err)
+		}
+		conn = tlsConn
+		reader = bufio.NewReader(conn)
+	}
+
+	connect, err := json.Marshal(natsConnect{
+		Name:      n.name,
+		Lang:      "go",
+		Version:   "1",
+		Protocol:  1,
+		AuthToken: n.token,
+		User:      n.user,
+		Pass:      n.pass,
+	})
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(conn)
+	fmt.Fprintf(writer, "CONNECT %s\r\n", connect)
+	for _, m := range messages {
+		if info.MaxPayload > 0 && len(m.Value) > info.MaxPayload {
+			return fmt.Errorf("message for %s exceeds the NATS max payload of %d bytes", m.Key, info.MaxPayload)
+		}
+		fmt.Fprintf(writer, "PUB %s %d\r\n", n.subject, len(m.Value))
+		writer.Write(m.Value)
+		writer.WriteString("\r\n")
+	}
+	writer.WriteString("PING\r\n")
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("failed to publish to NATS: %w", err)
+	}
+
+	// The server answers the PING once it has processed everything before it, or reports
+	// an error such as an authorization violation
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("failed to read NATS acknowledgement: %w", err)
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("NATS server error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		case line == "PING":
+			writer.WriteString("PONG\r\n")
+			writer.Flush()
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package gcpauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Source issues OAuth2 access tokens.
+type Source interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// NewDefaultTokenSource finds Application Default Credentials like the Google client
+// libraries: the key file named by GOOGLE_APPLICATION_CREDENTIALS, then the file written by
+// gcloud auth application-default login, and otherwise the service account of the GCE or
+// GKE metadata server. A key file may be a service account key or gcloud user credentials.
+func NewDefaultTokenSource(scopes ...string) (Source, error) {
+	credentialsFile := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if credentialsFile == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			wellKnown := filepath.Join(home, ".config", "gcloud", "application_default_credentials.json")
+			if _, err := os.Stat(wellKnown); err == nil {
+				credentialsFile = wellKnown
+			}
+		}
+	}
+	if credentialsFile == "" {
+		return newMetadataTokenSource(scopes), nil
+	}
+	return NewFileTokenSource(credentialsFile, scopes...)
+}
+
+// NewFileTokenSource loads a service account key or gcloud user credentials from
+// credentialsFile.
+func NewFileTokenSource(credentialsFile string, scopes ...string) (Source, error) {
+	data, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file: %w", err)
+	}
+	var credentials struct {
+		Type         string `json:"type"`
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(data, &credentials); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials file: %w", err)
+	}
+	switch credentials.Type {
+	case "service_account":
+		source, err := NewServiceAccountTokenSource(credentialsFile, scopes...)
+		if err != nil {
+			return nil, err
+		}
+		return source, nil
+	case "authorized_user":
+		return &userTokenSource{
+			form: url.Values{
+				"grant_type":    {"refresh_token"},
+				"client_id":     {credentials.ClientID},
+				"client_secret": {credentials.ClientSecret},
+				"refresh_token": {credentials.RefreshToken},
+			},
+			httpClient: &http.Client{Timeout: 30 * time.Second},
+		}, nil
+	default:
+		return nil, fmt.Errorf("credentials file must be a service account key or user credentials, got type %q", credentials.Type)
+	}
+}
+
+// userTokenSource issues access tokens for gcloud user credentials with their refresh token.
+type userTokenSource struct {
+	form       url.Values
+	httpClient *http.Client
+	cache      tokenCache
+}
+
+func (u *userTokenSource) Token(ctx context.Context) (string, error) {
+	return u.cache.get(func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", defaultTokenURI, strings.NewReader(u.form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+	}, u.httpClient)
+}
+
+// metadataTokenSource issues access tokens for the default service account of the GCE or GKE
+// metadata server, which on GKE is the Workload Identity of the pod.
+type metadataTokenSource struct {
+	tokenURL   string
+	httpClient *http.Client
+	cache      tokenCache
+}
+
+func newMetadataTokenSource(scopes []string) *metadataTokenSource {
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = "metadata.google.internal"
+	}
+	tokenURL := "http://" + host + "/computeMetadata/v1/instance/service-accounts/default/token"
+	if len(scopes) > 0 {
+		tokenURL += "?" + url.Values{"scopes": {strings.Join(scopes, ",")}}.Encode()
+	}
+	return &metadataTokenSource{tokenURL: tokenURL, httpClient: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (m *metadataTokenSource) Token(ctx context.Context) (string, error) {
+	return m.cache.get(func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", m.tokenURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+		return req, nil
+	}, m.httpClient)
+}
+
+// tokenCache caches an access token until shortly before it expires.
+type tokenCache struct {
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// get returns the cached token, or requests a new one with the request built by newRequest.
+func (c *tokenCache) get(newRequest func() (*http.Request, error), httpClient *http.Client) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && time.Until(c.expiry) > time.Minute {
+		return c.token, nil
+	}
+
+	req, err := newRequest()
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %w", err)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute token request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request failed: HTTP %d - %s", resp.StatusCode, string(body))
+	}
+
+	var response struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", fmt.Errorf("failed to decode token response: %w", err)
+	}
+	c.token = response.AccessToken
+	c.expiry = time.Now().Add(time.Duration(response.ExpiresIn) * time.Second)
+	return c.token, nil
+}
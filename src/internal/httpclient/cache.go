@@ -0,0 +1,145 @@
+package httpclient
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultCacheEntries is how many responses a client keeps for conditional requests when
+// Options.CacheEntries is not set.
+const DefaultCacheEntries = 1000
+
+// cacheTransport sends GET requests conditionally, with the ETag or Last-Modified of the
+// response it received last for the URL, and answers them from that response if the server
+// replies 304 Not Modified. Callers see a 200 as if the whole response was sent again.
+type cacheTransport struct {
+	base       http.RoundTripper
+	maxEntries int
+
+	mu sync.Mutex
+	// entries holds the cached responses by URL, lru their URLs from the least recently used
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type cacheEntry struct {
+	url    string
+	header http.Header
+	body   []byte
+}
+
+func newCacheTransport(base http.RoundTripper, maxEntries int) *cacheTransport {
+	if maxEntries <= 0 {
+		maxEntries = DefaultCacheEntries
+	}
+	return &cacheTransport{
+		base:       base,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Requests that set their own validators or ask for part of a response are not touched
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" ||
+		req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+		return t.base.RoundTrip(req)
+	}
+
+	key := req.URL.String()
+	cached := t.get(key)
+	if cached != nil {
+		req = req.Clone(req.Context())
+		if etag := cached.header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if modified := cached.header.Get("Last-Modified"); modified != "" {
+			req.Header.Set("If-Modified-Since", modified)
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		// The headers of the 304, such as rate limit headers, are newer than the cached ones
+		header := cached.header.Clone()
+		for name, values := range resp.Header {
+			header[name] = values
+		}
+		header.Set("Content-Length", strconv.Itoa(len(cached.body)))
+		return &http.Response{
+			Status:        "200 OK",
+			StatusCode:    http.StatusOK,
+			Proto:         resp.Proto,
+			ProtoMajor:    resp.ProtoMajor,
+			ProtoMinor:    resp.ProtoMinor,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewReader(cached.body)),
+			ContentLength: int64(len(cached.body)),
+			Request:       req,
+		}, nil
+	case resp.StatusCode == http.StatusOK && (resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != "") &&
+		!strings.Contains(resp.Header.Get("Cache-Control"), "no-store"):
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		t.put(&cacheEntry{url: key, header: resp.Header.Clone(), body: body})
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		return resp, nil
+	case resp.StatusCode == http.StatusOK:
+		// The server stopped sending validators, the cached response cannot be revalidated
+		t.remove(key)
+	}
+	return resp, nil
+}
+
+// get returns the cached response of url, nil if there is none.
+func (t *cacheTransport) get(url string) *cacheEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	element, ok := t.entries[url]
+	if !ok {
+		return nil
+	}
+	t.lru.MoveToBack(element)
+	return element.Value.(*cacheEntry)
+}
+
+// put caches entry, dropping the least recently used responses beyond maxEntries.
+func (t *cacheTransport) put(entry *cacheEntry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if element, ok := t.entries[entry.url]; ok {
+		element.Value = entry
+		t.lru.MoveToBack(element)
+		return
+	}
+	t.entries[entry.url] = t.lru.PushBack(entry)
+	for t.lru.Len() > t.maxEntries {
+		oldest := t.lru.Front()
+		t.lru.Remove(oldest)
+		delete(t.entries, oldest.Value.(*cacheEntry).url)
+	}
+}
+
+func (t *cacheTransport) remove(url string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if element, ok := t.entries[url]; ok {
+		t.lru.Remove(element)
+		delete(t.entries, url)
+	}
+}
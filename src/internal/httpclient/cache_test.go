@@ -0,0 +1,191 @@
+package httpclient
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCacheTransport(t *testing.T) {
+	type request struct {
+		path string
+		// header is sent with the request
+		header http.Header
+		// status and header are what the server answers
+		status         int
+		responseHeader http.Header
+		body           string
+		// wantConditional is whether the server sees If-None-Match or If-Modified-Since
+		wantConditional bool
+		wantStatus      int
+		wantBody        string
+	}
+	etag := http.Header{"Etag": {`"v1"`}}
+	tests := []struct {
+		name       string
+		maxEntries int
+		requests   []request
+	}{
+		{
+			name: "not modified is answered from the cache",
+			requests: []request{
+				{path: "/a", status: 200, responseHeader: etag, body: "one", wantStatus: 200, wantBody: "one"},
+				{path: "/a", status: 304, responseHeader: etag, wantConditional: true, wantStatus: 200, wantBody: "one"},
+			},
+		},
+		{
+			name: "a changed response replaces the cached one",
+			requests: []request{
+				{path: "/a", status: 200, responseHeader: etag, body: "one", wantStatus: 200, wantBody: "one"},
+				{path: "/a", status: 200, responseHeader: http.Header{"Etag": {`"v2"`}}, body: "two", wantConditional: true, wantStatus: 200, wantBody: "two"},
+				{path: "/a", status: 304, wantConditional: true, wantStatus: 200, wantBody: "two"},
+			},
+		},
+		{
+			name: "last modified",
+			requests: []request{
+				{path: "/a", status: 200, responseHeader: http.Header{"Last-Modified": {"Mon, 01 Jan 2024 00:00:00 GMT"}}, body: "one", wantStatus: 200, wantBody: "one"},
+				{path: "/a", status: 304, wantConditional: true, wantStatus: 200, wantBody: "one"},
+			},
+		},
+		{
+			name: "responses without validators are not cached",
+			requests: []request{
+				{path: "/a", status: 200, body: "one", wantStatus: 200, wantBody: "one"},
+				{path: "/a", status: 200, body: "one", wantStatus: 200, wantBody: "one"},
+			},
+		},
+		{
+			name: "no-store responses are not cached",
+			requests: []request{
+				{path: "/a", status: 200, responseHeader: http.Header{"Etag": {`"v1"`}, "Cache-Control": {"no-store"}}, body: "one", wantStatus: 200, wantBody: "one"},
+				{path: "/a", status: 200, body: "one", wantStatus: 200, wantBody: "one"},
+			},
+		},
+		{
+			name: "a response without validators drops the cached one",
+			requests: []request{
+				{path: "/a", status: 200, responseHeader: etag, body: "one", wantStatus: 200, wantBody: "one"},
+				{path: "/a", status: 200, body: "two", wantConditional: true, wantStatus: 200, wantBody: "two"},
+				{path: "/a", status: 200, body: "two", wantStatus: 200, wantBody: "two"},
+			},
+		},
+		{
+			name: "errors do not drop the cached response",
+			requests: []request{
+				{path: "/a", status: 200, responseHeader: etag, body: "one", wantStatus: 200, wantBody: "one"},
+				{path: "/a", status: 503, body: "down", wantConditional: true, wantStatus: 503, wantBody: "down"},
+				{path: "/a", status: 304, wantConditional: true, wantStatus: 200, wantBody: "one"},
+			},
+		},
+		{
+			name: "requests with their own validators are not touched",
+			requests: []request{
+				{path: "/a", status: 200, responseHeader: etag, body: "one", wantStatus: 200, wantBody: "one"},
+				{path: "/a", header: http.Header{"If-None-Match": {`"v0"`}}, status: 304, wantConditional: true, wantStatus: 304},
+			},
+		},
+		{
+			name: "range requests are not touched",
+			requests: []request{
+				{path: "/a", status: 200, responseHeader: etag, body: "one", wantStatus: 200, wantBody: "one"},
+				{path: "/a", header: http.Header{"Range": {"bytes=0-1"}}, status: 206, body: "on", wantStatus: 206, wantBody: "on"},
+			},
+		},
+		{
+			name: "URLs are cached separately",
+			requests: []request{
+				{path: "/a", status: 200, responseHeader: etag, body: "one", wantStatus: 200, wantBody: "one"},
+				{path: "/a?page=2", status: 200, body: "two", wantStatus: 200, wantBody: "two"},
+			},
+		},
+		{
+			name:       "the least recently used response is evicted",
+			maxEntries: 2,
+			requests: []request{
+				{path: "/a", status: 200, responseHeader: etag, body: "a", wantStatus: 200, wantBody: "a"},
+				{path: "/b", status: 200, responseHeader: etag, body: "b", wantStatus: 200, wantBody: "b"},
+				{path: "/a", status: 304, wantConditional: true, wantStatus: 200, wantBody: "a"},
+				{path: "/c", status: 200, responseHeader: etag, body: "c", wantStatus: 200, wantBody: "c"},
+				{path: "/b", status: 200, responseHeader: etag, body: "b", wantStatus: 200, wantBody: "b"},
+				{path: "/a", status: 200, responseHeader: etag, body: "a", wantStatus: 200, wantBody: "a"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var current request
+			var conditional bool
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conditional = r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != ""
+				for name, values := range current.responseHeader {
+					w.Header()[name] = values
+				}
+				w.WriteHeader(current.status)
+				io.WriteString(w, current.body)
+			}))
+			defer srv.Close()
+			client := &http.Client{Transport: newCacheTransport(http.DefaultTransport, tt.maxEntries)}
+
+			for i := range tt.requests {
+				current = tt.requests[i]
+				req, err := http.NewRequest(http.MethodGet, srv.URL+current.path, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for name, values := range current.header {
+					req.Header[name] = values
+				}
+				resp, err := client.Do(req)
+				if err != nil {
+					t.Fatalf("request %d: %v", i+1, err)
+				}
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				got := fmt.Sprintf("%d %q conditional=%v", resp.StatusCode, body, conditional)
+				want := fmt.Sprintf("%d %q conditional=%v", current.wantStatus, current.wantBody, current.wantConditional)
+				if got != want {
+					t.Errorf("request %d to %s: got %s, want %s", i+1, current.path, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheTransportKeepsNewerHeaders(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("X-Ratelimit-Remaining", fmt.Sprint(100-calls))
+		if calls > 1 {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		io.WriteString(w, "cached body")
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: newCacheTransport(http.DefaultTransport, 0)}
+
+	for range 2 {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if calls == 2 {
+			if got := resp.Header.Get("X-Ratelimit-Remaining"); got != "98" {
+				t.Errorf("X-Ratelimit-Remaining = %q, want the 304's 98", got)
+			}
+			if got := resp.Header.Get("Content-Length"); got != fmt.Sprint(len("cached body")) {
+				t.Errorf("Content-Length = %q, want the cached body's", got)
+			}
+			if !strings.HasPrefix(resp.Status, "200") {
+				t.Errorf("Status = %q, want 200", resp.Status)
+			}
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultUserAgent identifies requests when no user agent is configured.
+const DefaultUserAgent = "kandji-cloudflare-device-sync"
+
+// Options configures an HTTP client built by New.
+type Options struct {
+	Timeout time.Duration
+	// UserAgent is sent on every request, DefaultUserAgent if empty
+	UserAgent string
+	// ProxyURL routes every request through the given HTTP(S) proxy. If empty, the
+	// HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables are used.
+	ProxyURL string
+	// DNSServers are queried instead of the system resolver (host or host:port)
+	DNSServers []string
+	// Hosts maps host names to fixed IP addresses, bypassing DNS entirely
+	Hosts map[string]string
+	// Observer is notified of the outcome of every request, if set
+	Observer Observer
+	// ConditionalRequests keeps the last response with an ETag or Last-Modified header of up
+	// to CacheEntries URLs, DefaultCacheEntries if zero, and sends GET requests for them
+	// conditionally, answering them from the kept response if the server replies 304
+	ConditionalRequests bool
+	CacheEntries        int
+}
+
+// Observer is notified of the outcome of a request. status is 0 if no response was received.
+type Observer func(req *http.Request, status int)
+
+// New builds an HTTP client from opts.
+func New(opts Options) (*http.Client, error) {
+	userAgent := opts.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.ProxyURL != "" {
+		proxyURL, err := url.Parse(opts.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	if len(opts.DNSServers) > 0 || len(opts.Hosts) > 0 {
+		transport.DialContext = dialContext(opts.DNSServers, opts.Hosts)
+	}
+
+	var roundTripper http.RoundTripper = transport
+	if opts.Observer != nil {
+		roundTripper = &observerTransport{base: roundTripper, observer: opts.Observer}
+	}
+	// The observer sees the 304 responses, the callers the cached responses
+	if opts.ConditionalRequests {
+		roundTripper = newCacheTransport(roundTripper, opts.CacheEntries)
+	}
+
+	return &http.Client{
+		Timeout: opts.Timeout,
+		Transport: &userAgentTransport{
+			base:      roundTripper,
+			userAgent: userAgent,
+		},
+	}, nil
+}
+
+// dialContext returns a dial function that connects to the static address of a mapped
+// host, and otherwise resolves the host through the given DNS servers, if any.
+func dialContext(dnsServers []string, hosts map[string]string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if len(dnsServers) > 0 {
+		dialer.Resolver = Resolver(dnsServers)
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if ip, ok := hosts[strings.ToLower(host)]; ok {
+			addr = net.JoinHostPort(ip, port)
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+}
+
+// Resolver returns a resolver that queries the given DNS servers instead of the system
+// resolver, or the default resolver if there are none.
+func Resolver(dnsServers []string) *net.Resolver {
+	if len(dnsServers) == 0 {
+		return net.DefaultResolver
+	}
+	var next atomic.Uint32
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			// Rotate through the servers so one unreachable server does not fail every lookup
+			server := dnsServers[int(next.Add(1))%len(dnsServers)]
+			return (&net.Dialer{Timeout: 5 * time.Second}).DialContext(ctx, network, DNSServerAddress(server))
+		},
+	}
+}
+
+// DNSServerAddress adds the default DNS port to a server address without a port.
+func DNSServerAddress(server string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(server, "53")
+}
+
+// UserAgent builds the user agent identifying this integration, e.g.
+// "kandji-cloudflare-device-sync/1.4.0 (instance=sync-eu-1) acme-it".
+func UserAgent(version, instanceID, suffix string) string {
+	userAgent := fmt.Sprintf("%s/%s", DefaultUserAgent, version)
+	if instanceID != "" {
+		userAgent += fmt.Sprintf(" (instance=%s)", instanceID)
+	}
+	if suffix = strings.TrimSpace(suffix); suffix != "" {
+		userAgent += " " + suffix
+	}
+	return userAgent
+}
+
+// userAgentTransport sets the User-Agent header on every request.
+type userAgentTransport struct {
+	base      http.RoundTripper
+	userAgent string
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.base.RoundTrip(req)
+}
+
+// observerTransport reports the outcome of every request to an Observer.
+type observerTransport struct {
+	base     http.RoundTripper
+	observer Observer
+}
+
+func (t *observerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	status := 0
+	if err == nil {
+		status = resp.StatusCode
+	}
+	t.observer(req, status)
+	return resp, err
+}
+
+// idSegment matches path segments that are identifiers rather than fixed names: UUIDs,
+// hex IDs such as Cloudflare account and list IDs, and numbers.
+var idSegment = regexp.MustCompile(`^(?i:[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}|[0-9a-f]{16,}|[0-9]+)$`)
+
+// Endpoint returns the path of a request URL with identifiers replaced by {id}, e.g.
+// /client/v4/accounts/{id}/gateway/lists/{id}/items, to label requests by endpoint.
+func Endpoint(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if idSegment.MatchString(segment) {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// StatusClass groups a status code into 2xx, 3xx, 4xx, 429, 5xx, or "error" if no
+// response was received. 429 is kept apart from 4xx as it signals rate limiting.
+func StatusClass(status int) string {
+	switch {
+	case status == 0:
+		return "error"
+	case status == http.StatusTooManyRequests:
+		return "429"
+	default:
+		return strconv.Itoa(status/100) + "xx"
+	}
+}
@@ -0,0 +1,95 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/internal/awsauth"
+)
+
+// S3 writes objects to an S3 bucket or an S3-compatible store such as GCS (HMAC keys) or MinIO.
+type S3 struct {
+	bucket     string
+	prefix     string
+	region     string
+	endpoint   string
+	pathStyle  bool
+	creds      awsauth.Credentials
+	httpClient *http.Client
+}
+
+// NewS3 creates a new S3 object writer. Credentials not set in the config are read from the
+// standard AWS environment variables.
+func NewS3(cfg config.S3Config) (*S3, error) {
+	creds := awsauth.CredentialsFromEnv(awsauth.Credentials{
+		AccessKeyID:     cfg.AccessKeyID,
+		SecretAccessKey: cfg.SecretAccessKey,
+	})
+	if !creds.Valid() {
+		return nil, fmt.Errorf("S3 credentials are required (access_key_id/secret_access_key or AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY)")
+	}
+
+	return &S3{
+		bucket:    cfg.Bucket,
+		prefix:    cfg.Prefix,
+		region:    cfg.Region,
+		endpoint:  strings.TrimSuffix(cfg.Endpoint, "/"),
+		pathStyle: cfg.PathStyle || cfg.Endpoint != "",
+		creds:     creds,
+		httpClient: &http.Client{
+			Timeout: 60 * time.Second,
+		},
+	}, nil
+}
+
+// Bucket returns the configured bucket name.
+func (s *S3) Bucket() string {
+	return s.bucket
+}
+
+// PutObject uploads body under the configured prefix and returns the full object key.
+func (s *S3) PutObject(ctx context.Context, key, contentType string, body []byte) (string, error) {
+	fullKey := s.prefix + key
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", s.objectURL(fullKey), bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.ContentLength = int64(len(body))
+
+	if err := awsauth.Sign(req, body, s.creds, "s3", s.region, time.Now()); err != nil {
+		return "", err
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload object: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("failed to upload object %s: HTTP %d - %s", fullKey, resp.StatusCode, string(respBody))
+	}
+	return fullKey, nil
+}
+
+func (s *S3) objectURL(key string) string {
+	escapedKey := (&url.URL{Path: key}).EscapedPath()
+	if s.pathStyle {
+		endpoint := s.endpoint
+		if endpoint == "" {
+			endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", s.region)
+		}
+		return endpoint + "/" + s.bucket + "/" + escapedKey
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.bucket, s.region, escapedKey)
+}
@@ -2,6 +2,8 @@ package ratelimit
 
 import (
 	"context"
+	"sync"
+	"time"
 
 	"golang.org/x/time/rate"
 )
@@ -10,6 +12,11 @@ import (
 type Limiter struct {
 	kandjiLimiter     *rate.Limiter
 	cloudflareLimiter *rate.Limiter
+
+	mu         sync.Mutex
+	configured Config
+	// cloudflarePausedUntil holds back Cloudflare requests after the API asked to retry later
+	cloudflarePausedUntil time.Time
 }
 
 // Config holds rate limiting configuration
@@ -24,9 +31,43 @@ func New(cfg Config) *Limiter {
 	return &Limiter{
 		kandjiLimiter:     rate.NewLimiter(rate.Limit(cfg.KandjiRequestsPerSecond), cfg.BurstCapacity),
 		cloudflareLimiter: rate.NewLimiter(rate.Limit(cfg.CloudflareRequestsPerSecond), cfg.BurstCapacity),
+		configured:        cfg,
+	}
+}
+
+// Limits returns the limits currently in effect
+func (l *Limiter) Limits() Config {
+	return Config{
+		KandjiRequestsPerSecond:     float64(l.kandjiLimiter.Limit()),
+		CloudflareRequestsPerSecond: float64(l.cloudflareLimiter.Limit()),
+		BurstCapacity:               l.kandjiLimiter.Burst(),
 	}
 }
 
+// Configured returns the limits the limiter was created or last reconfigured with
+func (l *Limiter) Configured() Config {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.configured
+}
+
+// Reconfigure replaces the configured limits, as when the configuration is reloaded, and
+// puts them into effect in place of any limits changed at runtime
+func (l *Limiter) Reconfigure(cfg Config) {
+	l.mu.Lock()
+	l.configured = cfg
+	l.mu.Unlock()
+	l.SetLimits(cfg)
+}
+
+// SetLimits changes the limits at runtime; requests already waiting pick up the new limits
+func (l *Limiter) SetLimits(cfg Config) {
+	l.kandjiLimiter.SetLimit(rate.Limit(cfg.KandjiRequestsPerSecond))
+	l.kandjiLimiter.SetBurst(cfg.BurstCapacity)
+	l.cloudflareLimiter.SetLimit(rate.Limit(cfg.CloudflareRequestsPerSecond))
+	l.cloudflareLimiter.SetBurst(cfg.BurstCapacity)
+}
+
 // WaitForKandji waits for permission to make a Kandji API request
 func (l *Limiter) WaitForKandji(ctx context.Context) error {
 	return l.kandjiLimiter.Wait(ctx)
@@ -34,9 +75,31 @@ func (l *Limiter) WaitForKandji(ctx context.Context) error {
 
 // WaitForCloudflare waits for permission to make a Cloudflare API request
 func (l *Limiter) WaitForCloudflare(ctx context.Context) error {
+	l.mu.Lock()
+	pause := time.Until(l.cloudflarePausedUntil)
+	l.mu.Unlock()
+	if pause > 0 {
+		timer := time.NewTimer(pause)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
 	return l.cloudflareLimiter.Wait(ctx)
 }
 
+// PauseCloudflare holds back all Cloudflare API requests for d, e.g. as requested by a
+// Retry-After header. An earlier pause that ends later is kept.
+func (l *Limiter) PauseCloudflare(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := time.Now().Add(d); until.After(l.cloudflarePausedUntil) {
+		l.cloudflarePausedUntil = until
+	}
+}
+
 // AllowKandji checks if a Kandji API request is allowed without blocking
 func (l *Limiter) AllowKandji() bool {
 	return l.kandjiLimiter.Allow()
@@ -0,0 +1,67 @@
+// Package sdnotify implements the systemd service notification protocol (sd_notify), which
+// tells systemd when a Type=notify service is ready or stopping and feeds its watchdog.
+package sdnotify
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Notification states.
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// Notifier sends notifications to the socket of the service manager.
+type Notifier struct {
+	addr *net.UnixAddr
+}
+
+// FromEnv returns a notifier for the socket systemd passes in NOTIFY_SOCKET, nil if the
+// process was not started by systemd with notifications enabled. A socket name starting
+// with @ is in the abstract namespace.
+func FromEnv() *Notifier {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	return &Notifier{addr: &net.UnixAddr{Name: socket, Net: "unixgram"}}
+}
+
+// Notify sends the given states, e.g. Ready or "STATUS=...", in one datagram. It does
+// nothing on a nil Notifier.
+func (n *Notifier) Notify(states ...string) error {
+	if n == nil {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, n.addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to systemd notification socket %s: %w", n.addr.Name, err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(strings.Join(states, "\n"))); err != nil {
+		return fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	return nil
+}
+
+// WatchdogInterval returns the watchdog timeout systemd set for the process with
+// WatchdogSec, zero if the watchdog is not enabled for it. Heartbeats should be sent at
+// half of it.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	// WATCHDOG_PID names the process the watchdog is meant for, if set
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
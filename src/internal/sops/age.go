@@ -0,0 +1,327 @@
+package sops
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	ageIntro        = "age-encryption.org/v1"
+	ageX25519Label  = "age-encryption.org/v1/X25519"
+	ageArmorBegin   = "-----BEGIN AGE ENCRYPTED FILE-----"
+	ageArmorEnd     = "-----END AGE ENCRYPTED FILE-----"
+	ageChunkSize    = 64 * 1024
+	ageSecretKeyHRP = "age-secret-key-"
+)
+
+// ageIdentities reads the age X25519 identities of SOPS_AGE_KEY, SOPS_AGE_KEY_FILE and the
+// sops keys file, as sops does.
+func ageIdentities() ([]*ecdh.PrivateKey, error) {
+	var sources []string
+	if keys := os.Getenv("SOPS_AGE_KEY"); keys != "" {
+		sources = append(sources, keys)
+	}
+	keysFile := os.Getenv("SOPS_AGE_KEY_FILE")
+	if keysFile == "" {
+		if configDir, err := os.UserConfigDir(); err == nil {
+			keysFile = filepath.Join(configDir, "sops", "age", "keys.txt")
+		}
+	}
+	if keysFile != "" {
+		data, err := os.ReadFile(keysFile)
+		if err != nil && (!errors.Is(err, os.ErrNotExist) || os.Getenv("SOPS_AGE_KEY_FILE") != "") {
+			return nil, fmt.Errorf("failed to read age keys file: %w", err)
+		}
+		sources = append(sources, string(data))
+	}
+
+	var identities []*ecdh.PrivateKey
+	for _, source := range sources {
+		for _, line := range strings.Split(source, "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			identity, err := parseAgeIdentity(line)
+			if err != nil {
+				return nil, err
+			}
+			identities = append(identities, identity)
+		}
+	}
+	if len(identities) == 0 {
+		return nil, errors.New("no age identity found, set SOPS_AGE_KEY or SOPS_AGE_KEY_FILE")
+	}
+	return identities, nil
+}
+
+// parseAgeIdentity parses an AGE-SECRET-KEY-1... identity.
+func parseAgeIdentity(s string) (*ecdh.PrivateKey, error) {
+	hrp, data, err := bech32Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid age identity: %w", err)
+	}
+	if hrp != ageSecretKeyHRP {
+		return nil, fmt.Errorf("invalid age identity: unexpected type %q", hrp)
+	}
+	key, err := ecdh.X25519().NewPrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid age identity: %w", err)
+	}
+	return key, nil
+}
+
+// ageDecrypt decrypts an armored age file encrypted to one of the X25519 identities.
+func ageDecrypt(armored string, identities []*ecdh.PrivateKey) ([]byte, error) {
+	data, err := ageDearmor(armored)
+	if err != nil {
+		return nil, err
+	}
+	header, payload, stanzas, mac, err := parseAgeHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileKey []byte
+	for _, stanza := range stanzas {
+		if stanza.kind != "X25519" {
+			continue
+		}
+		for _, identity := range identities {
+			if key, err := unwrapX25519(stanza, identity); err == nil {
+				fileKey = key
+				break
+			}
+		}
+		if fileKey != nil {
+			break
+		}
+	}
+	if fileKey == nil {
+		return nil, errors.New("no age identity matches the file's recipients")
+	}
+
+	hmacKey := hkdfKey(fileKey, nil, "header")
+	h := hmac.New(sha256.New, hmacKey)
+	h.Write(header)
+	if !hmac.Equal(h.Sum(nil), mac) {
+		return nil, errors.New("invalid age header MAC")
+	}
+	return ageDecryptPayload(fileKey, payload)
+}
+
+// ageDearmor decodes the PEM-like armor age files are stored in by sops.
+func ageDearmor(armored string) ([]byte, error) {
+	armored = strings.TrimSpace(armored)
+	if !strings.HasPrefix(armored, ageArmorBegin) || !strings.HasSuffix(armored, ageArmorEnd) {
+		return nil, errors.New("age file is not armored")
+	}
+	body := strings.TrimSuffix(strings.TrimPrefix(armored, ageArmorBegin), ageArmorEnd)
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(body), ""))
+}
+
+// ageStanza is a recipient stanza of an age header.
+type ageStanza struct {
+	kind string
+	args []string
+	body []byte
+}
+
+// parseAgeHeader splits an age file into its header up to the MAC, the payload, the
+// recipient stanzas and the header MAC.
+func parseAgeHeader(data []byte) (header, payload []byte, stanzas []ageStanza, mac []byte, err error) {
+	br := bytes.NewReader(data)
+	r := bufio.NewReader(br)
+	readLine := func() (string, error) {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", errors.New("truncated age header")
+		}
+		return strings.TrimSuffix(line, "\n"), nil
+	}
+
+	line, err := readLine()
+	if err != nil || line != ageIntro {
+		return nil, nil, nil, nil, errors.New("unsupported age file version")
+	}
+	for {
+		line, err := readLine()
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		if strings.HasPrefix(line, "--- ") {
+			mac, err = base64.RawStdEncoding.DecodeString(strings.TrimPrefix(line, "--- "))
+			if err != nil {
+				return nil, nil, nil, nil, fmt.Errorf("invalid age header MAC: %w", err)
+			}
+			consumed := len(data) - br.Len() - r.Buffered()
+			header = data[:consumed-len(line)-1+len("---")]
+			return header, data[consumed:], stanzas, mac, nil
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "->" {
+			return nil, nil, nil, nil, errors.New("invalid age recipient stanza")
+		}
+		stanza := ageStanza{kind: fields[1], args: fields[2:]}
+		// The body is wrapped at 64 columns and ends with a shorter line
+		for {
+			bodyLine, err := readLine()
+			if err != nil {
+				return nil, nil, nil, nil, err
+			}
+			decoded, err := base64.RawStdEncoding.DecodeString(bodyLine)
+			if err != nil {
+				return nil, nil, nil, nil, fmt.Errorf("invalid age stanza body: %w", err)
+			}
+			stanza.body = append(stanza.body, decoded...)
+			if len(bodyLine) < 64 {
+				break
+			}
+		}
+		stanzas = append(stanzas, stanza)
+	}
+}
+
+// unwrapX25519 returns the file key of an X25519 stanza wrapped for identity.
+func unwrapX25519(stanza ageStanza, identity *ecdh.PrivateKey) ([]byte, error) {
+	if len(stanza.args) != 1 {
+		return nil, errors.New("invalid X25519 stanza")
+	}
+	share, err := base64.RawStdEncoding.DecodeString(stanza.args[0])
+	if err != nil {
+		return nil, err
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(share)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := identity.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	salt := append(append([]byte{}, share...), identity.PublicKey().Bytes()...)
+	aead, err := chacha20poly1305.New(hkdfKey(shared, salt, ageX25519Label))
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, make([]byte, chacha20poly1305.NonceSize), stanza.body, nil)
+}
+
+// ageDecryptPayload decrypts the STREAM-encrypted payload of an age file.
+func ageDecryptPayload(fileKey, payload []byte) ([]byte, error) {
+	if len(payload) < 16 {
+		return nil, errors.New("truncated age payload")
+	}
+	aead, err := chacha20poly1305.New(hkdfKey(fileKey, payload[:16], "payload"))
+	if err != nil {
+		return nil, err
+	}
+	payload = payload[16:]
+
+	var plaintext []byte
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	for counter := uint64(0); ; counter++ {
+		chunkSize := min(len(payload), ageChunkSize+aead.Overhead())
+		last := chunkSize == len(payload)
+		binary.BigEndian.PutUint64(nonce[3:11], counter)
+		if last {
+			nonce[11] = 1
+		}
+		chunk, err := aead.Open(nil, nonce, payload[:chunkSize], nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt age payload: %w", err)
+		}
+		plaintext = append(plaintext, chunk...)
+		payload = payload[chunkSize:]
+		if last {
+			return plaintext, nil
+		}
+	}
+}
+
+// hkdfKey derives a 32-byte key with HKDF-SHA256.
+func hkdfKey(secret, salt []byte, info string) []byte {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// bech32Decode decodes a Bech32 string such as an age identity, returning its lowercase
+// human-readable part and its data.
+func bech32Decode(s string) (string, []byte, error) {
+	const charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errors.New("mixed case")
+	}
+	s = strings.ToLower(s)
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, errors.New("invalid separator position")
+	}
+	hrp := s[:pos]
+	var values []byte
+	for _, c := range s[pos+1:] {
+		v := strings.IndexRune(charset, c)
+		if v < 0 {
+			return "", nil, fmt.Errorf("invalid character %q", c)
+		}
+		values = append(values, byte(v))
+	}
+
+	// Verify the checksum over the expanded human-readable part and the values
+	chk := uint32(1)
+	polymod := func(v byte) {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i, g := range []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3} {
+			if (top>>i)&1 == 1 {
+				chk ^= g
+			}
+		}
+	}
+	for _, c := range []byte(hrp) {
+		polymod(c >> 5)
+	}
+	polymod(0)
+	for _, c := range []byte(hrp) {
+		polymod(c & 31)
+	}
+	for _, v := range values {
+		polymod(v)
+	}
+	if chk != 1 {
+		return "", nil, errors.New("invalid checksum")
+	}
+
+	// Convert the 5-bit values, without the checksum, to bytes
+	var data []byte
+	acc, bits := uint32(0), uint(0)
+	for _, v := range values[:len(values)-6] {
+		acc = acc<<5 | uint32(v)
+		bits += 5
+		for bits >= 8 {
+			bits -= 8
+			data = append(data, byte(acc>>bits))
+		}
+	}
+	if bits >= 5 || acc&(1<<bits-1) != 0 {
+		return "", nil, errors.New("invalid padding")
+	}
+	return hrp, data, nil
+}
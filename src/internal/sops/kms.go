@@ -0,0 +1,71 @@
+package sops
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"kandji-cloudflare-device-sync/internal/awsauth"
+)
+
+// kmsDecrypt decrypts a data key with the AWS KMS key of arn, signing the request with the
+// credentials of the standard AWS environment variables. The KMS endpoint may be replaced
+// with AWS_ENDPOINT_URL_KMS.
+func kmsDecrypt(ctx context.Context, arn, enc string, encryptionContext map[string]string) ([]byte, error) {
+	// arn:aws:kms:<region>:<account>:key/<id>
+	parts := strings.SplitN(arn, ":", 5)
+	if len(parts) != 5 || parts[0] != "arn" || parts[3] == "" {
+		return nil, fmt.Errorf("invalid KMS key ARN")
+	}
+	region := parts[3]
+	ciphertext, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encrypted data key: %w", err)
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_KMS")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://kms.%s.amazonaws.com", region)
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"CiphertextBlob":    ciphertext,
+		"KeyId":             arn,
+		"EncryptionContext": encryptionContext,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService.Decrypt")
+	if err := awsauth.Sign(req, body, awsauth.CredentialsFromEnv(awsauth.Credentials{}), "kms", region, time.Now()); err != nil {
+		return nil, err
+	}
+
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d - %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	var response struct {
+		Plaintext []byte `json:"Plaintext"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return response.Plaintext, nil
+}
@@ -0,0 +1,255 @@
+package sops
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// encryptedValue matches a value encrypted by SOPS.
+var encryptedValue = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.+),tag:(.+),type:(.+)\]$`)
+
+// metadata is the sops key of an encrypted file.
+type metadata struct {
+	KMS []struct {
+		ARN     string            `yaml:"arn"`
+		Enc     string            `yaml:"enc"`
+		Context map[string]string `yaml:"context"`
+	} `yaml:"kms"`
+	Age []struct {
+		Recipient string `yaml:"recipient"`
+		Enc       string `yaml:"enc"`
+	} `yaml:"age"`
+	PGP              []any  `yaml:"pgp"`
+	GCPKMS           []any  `yaml:"gcp_kms"`
+	AzureKV          []any  `yaml:"azure_kv"`
+	HCVault          []any  `yaml:"hc_vault"`
+	KeyGroups        []any  `yaml:"key_groups"`
+	LastModified     string `yaml:"lastmodified"`
+	MAC              string `yaml:"mac"`
+	MACOnlyEncrypted bool   `yaml:"mac_only_encrypted"`
+}
+
+// IsEncrypted reports whether data is a YAML file encrypted by SOPS.
+func IsEncrypted(data []byte) bool {
+	var file struct {
+		SOPS *metadata `yaml:"sops"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil || file.SOPS == nil {
+		return false
+	}
+	return file.SOPS.MAC != "" && file.SOPS.LastModified != ""
+}
+
+// Decrypt decrypts a YAML file encrypted by SOPS and returns it as plain YAML, without the sops
+// key. The data key is decrypted with an age identity, from SOPS_AGE_KEY, SOPS_AGE_KEY_FILE or
+// the sops keys file, or with AWS KMS. The file's MAC is verified, so a file whose values were
+// changed, added or removed without SOPS is rejected.
+func Decrypt(ctx context.Context, data []byte) ([]byte, error) {
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse encrypted file: %w", err)
+	}
+	var file struct {
+		SOPS metadata `yaml:"sops"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse sops metadata: %w", err)
+	}
+	meta := file.SOPS
+	if len(meta.KeyGroups) > 0 {
+		return nil, fmt.Errorf("files encrypted with key_groups are not supported")
+	}
+
+	dataKey, err := decryptDataKey(ctx, &meta)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := make(yaml.MapSlice, 0, len(doc))
+	for _, item := range doc {
+		if key, _ := item.Key.(string); key != "sops" {
+			tree = append(tree, item)
+		}
+	}
+	hash := sha512.New()
+	decrypted, err := walk(tree, nil, func(value any, path []string) (any, error) {
+		plain, encrypted, err := decryptValue(value, dataKey, strings.Join(path, ":")+":")
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt %s: %w", strings.Join(path, "."), err)
+		}
+		if plain == nil {
+			return nil, nil
+		}
+		if encrypted || !meta.MACOnlyEncrypted {
+			hash.Write(macBytes(plain))
+		}
+		return plain, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mac, _, err := decryptValue(meta.MAC, dataKey, meta.LastModified)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt MAC: %w", err)
+	}
+	if macString, _ := mac.(string); !strings.EqualFold(macString, fmt.Sprintf("%X", hash.Sum(nil))) {
+		return nil, errors.New("MAC mismatch, the file was changed without sops")
+	}
+
+	out, err := yaml.Marshal(decrypted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode decrypted file: %w", err)
+	}
+	return out, nil
+}
+
+// walk calls onLeaf for every value of a tree in document order, with the keys leading to it,
+// and returns the tree with the values it returned. Lists do not add to the path, as in SOPS.
+func walk(value any, path []string, onLeaf func(value any, path []string) (any, error)) (any, error) {
+	switch value := value.(type) {
+	case yaml.MapSlice:
+		out := make(yaml.MapSlice, 0, len(value))
+		for _, item := range value {
+			key := fmt.Sprint(item.Key)
+			walked, err := walk(item.Value, append(path[:len(path):len(path)], key), onLeaf)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, yaml.MapItem{Key: item.Key, Value: walked})
+		}
+		return out, nil
+	case []any:
+		out := make([]any, 0, len(value))
+		for _, item := range value {
+			walked, err := walk(item, path, onLeaf)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, walked)
+		}
+		return out, nil
+	default:
+		return onLeaf(value, path)
+	}
+}
+
+// decryptValue decrypts a value encrypted by SOPS with the data key and additional data,
+// converting it to its original type. encrypted is false for a value left in plain text.
+func decryptValue(value any, dataKey []byte, additionalData string) (plain any, encrypted bool, err error) {
+	s, ok := value.(string)
+	if !ok {
+		return value, false, nil
+	}
+	match := encryptedValue.FindStringSubmatch(s)
+	if match == nil {
+		return value, false, nil
+	}
+	var parts [3][]byte
+	for i := range parts {
+		if parts[i], err = base64.StdEncoding.DecodeString(match[i+1]); err != nil {
+			return nil, true, fmt.Errorf("invalid encrypted value: %w", err)
+		}
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, true, err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return nil, true, err
+	}
+	decrypted, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to authenticate value: %w", err)
+	}
+
+	text := string(decrypted)
+	switch valueType := match[4]; valueType {
+	case "str", "bytes":
+		return text, true, nil
+	case "int":
+		n, err := strconv.Atoi(text)
+		return n, true, err
+	case "float":
+		f, err := strconv.ParseFloat(text, 64)
+		return f, true, err
+	case "bool":
+		return strings.EqualFold(text, "true"), true, nil
+	case "comment":
+		return nil, true, nil
+	default:
+		return nil, true, fmt.Errorf("unknown value type %q", valueType)
+	}
+}
+
+// macBytes returns the bytes a value adds to the MAC, as formatted by SOPS.
+func macBytes(value any) []byte {
+	switch value := value.(type) {
+	case string:
+		return []byte(value)
+	case int:
+		return []byte(strconv.Itoa(value))
+	case float64:
+		return []byte(strconv.FormatFloat(value, 'f', -1, 64))
+	case bool:
+		if value {
+			return []byte("True")
+		}
+		return []byte("False")
+	case time.Time:
+		return []byte(value.Format(time.RFC3339))
+	default:
+		return []byte(fmt.Sprint(value))
+	}
+}
+
+// decryptDataKey decrypts the file's data key with the first master key that can.
+func decryptDataKey(ctx context.Context, meta *metadata) ([]byte, error) {
+	var errs []error
+	if len(meta.Age) > 0 {
+		identities, err := ageIdentities()
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for _, key := range meta.Age {
+			if len(identities) == 0 {
+				break
+			}
+			dataKey, err := ageDecrypt(key.Enc, identities)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("age recipient %s: %w", key.Recipient, err))
+				continue
+			}
+			return dataKey, nil
+		}
+	}
+	for _, key := range meta.KMS {
+		dataKey, err := kmsDecrypt(ctx, key.ARN, key.Enc, key.Context)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("AWS KMS key %s: %w", key.ARN, err))
+			continue
+		}
+		return dataKey, nil
+	}
+	if len(meta.PGP) > 0 || len(meta.GCPKMS) > 0 || len(meta.AzureKV) > 0 || len(meta.HCVault) > 0 {
+		errs = append(errs, errors.New("only age and AWS KMS master keys are supported"))
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("the file has no master keys")
+	}
+	return nil, fmt.Errorf("failed to decrypt the data key: %w", errors.Join(errs...))
+}
@@ -0,0 +1,309 @@
+package sops
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"gopkg.in/yaml.v2"
+)
+
+const lastModified = "2024-05-01T10:00:00Z"
+
+func TestDecrypt(t *testing.T) {
+	identity, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := yaml.MapSlice{
+		{Key: "kandji", Value: yaml.MapSlice{
+			{Key: "api_token", Value: "kandji-secret"},
+			{Key: "page_size", Value: 300},
+		}},
+		{Key: "dry_run", Value: true},
+		{Key: "source_lists", Value: []any{"a", "b"}},
+	}
+	want, err := yaml.Marshal(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		// key is the age identity sops is given
+		key     string
+		tamper  func(string) string
+		wantErr string
+	}{
+		{name: "decrypts with the identity", key: encodeIdentity(t, identity)},
+		{name: "decrypts with one of several identities", key: encodeIdentity(t, other) + "\n# comment\n" + encodeIdentity(t, identity)},
+		{name: "wrong identity", key: encodeIdentity(t, other), wantErr: "no age identity matches"},
+		{name: "no identity", wantErr: "no age identity found"},
+		{name: "invalid identity", key: "AGE-SECRET-KEY-1INVALID", wantErr: "invalid age identity"},
+		{
+			name: "value replaced in plain text",
+			key:  encodeIdentity(t, identity),
+			tamper: func(s string) string {
+				start := strings.Index(s, "dry_run: ")
+				end := start + strings.Index(s[start:], "\n")
+				return s[:start] + "dry_run: false" + s[end:]
+			},
+			wantErr: "MAC mismatch",
+		},
+		{
+			name: "removed value",
+			key:  encodeIdentity(t, identity),
+			tamper: func(s string) string {
+				lines := strings.Split(s, "\n")
+				var kept []string
+				for _, line := range lines {
+					if !strings.HasPrefix(line, "dry_run:") {
+						kept = append(kept, line)
+					}
+				}
+				return strings.Join(kept, "\n")
+			},
+			wantErr: "MAC mismatch",
+		},
+		{
+			name: "value moved to another key",
+			key:  encodeIdentity(t, identity),
+			tamper: func(s string) string {
+				return strings.Replace(s, "dry_run:", "sync_mobile_devices:", 1)
+			},
+			wantErr: "failed to authenticate value",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SOPS_AGE_KEY", tt.key)
+			t.Setenv("SOPS_AGE_KEY_FILE", "")
+			t.Setenv("HOME", t.TempDir())
+			t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+			encrypted := encryptFile(t, plain, identity.PublicKey())
+			if tt.tamper != nil {
+				encrypted = tt.tamper(encrypted)
+			}
+			if !IsEncrypted([]byte(encrypted)) {
+				t.Fatal("IsEncrypted() = false for an encrypted file")
+			}
+			got, err := Decrypt(context.Background(), []byte(encrypted))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Decrypt() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Decrypt() error = %v", err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("Decrypt() =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestIsEncrypted(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want bool
+	}{
+		{name: "plain config", data: "kandji:\n  api_token: secret\n"},
+		{name: "sops key without a MAC", data: "sops:\n  lastmodified: \"" + lastModified + "\"\n"},
+		{name: "sops metadata", data: "a: b\nsops:\n  mac: ENC[x]\n  lastmodified: \"" + lastModified + "\"\n", want: true},
+		{name: "not YAML", data: "{"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsEncrypted([]byte(tt.data)); got != tt.want {
+				t.Errorf("IsEncrypted() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// encryptFile encrypts a document the way sops does with a single age recipient.
+func encryptFile(t *testing.T, doc yaml.MapSlice, recipient *ecdh.PublicKey) string {
+	t.Helper()
+	dataKey := randomBytes(t, 32)
+	hash := sha512.New()
+	encrypted, err := walk(doc, nil, func(value any, path []string) (any, error) {
+		var text, valueType string
+		switch value := value.(type) {
+		case string:
+			text, valueType = value, "str"
+		case int:
+			text, valueType = strconv.Itoa(value), "int"
+		case bool:
+			text, valueType = map[bool]string{true: "True", false: "False"}[value], "bool"
+		default:
+			return nil, fmt.Errorf("unsupported value %v", value)
+		}
+		hash.Write([]byte(text))
+		return encryptValue(t, text, valueType, dataKey, strings.Join(path, ":")+":"), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := encryptValue(t, fmt.Sprintf("%X", hash.Sum(nil)), "str", dataKey, lastModified)
+
+	file := append(encrypted.(yaml.MapSlice), yaml.MapItem{Key: "sops", Value: yaml.MapSlice{
+		{Key: "age", Value: []any{yaml.MapSlice{
+			{Key: "recipient", Value: "age1test"},
+			{Key: "enc", Value: ageEncrypt(t, dataKey, recipient)},
+		}}},
+		{Key: "lastmodified", Value: lastModified},
+		{Key: "mac", Value: mac},
+		{Key: "version", Value: "3.8.1"},
+	}})
+	out, err := yaml.Marshal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func encryptValue(t *testing.T, text, valueType string, dataKey []byte, additionalData string) string {
+	t.Helper()
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv := randomBytes(t, 32)
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := gcm.Seal(nil, iv, []byte(text), []byte(additionalData))
+	data, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+	enc := base64.StdEncoding.EncodeToString
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]", enc(data), enc(iv), enc(tag), valueType)
+}
+
+// ageEncrypt encrypts data to an X25519 recipient as an armored age file.
+func ageEncrypt(t *testing.T, data []byte, recipient *ecdh.PublicKey) string {
+	t.Helper()
+	fileKey := randomBytes(t, 16)
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	share := ephemeral.PublicKey().Bytes()
+	salt := append(append([]byte{}, share...), recipient.Bytes()...)
+	wrap, err := chacha20poly1305.New(hkdfKey(shared, salt, ageX25519Label))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := wrap.Seal(nil, make([]byte, chacha20poly1305.NonceSize), fileKey, nil)
+
+	raw := base64.RawStdEncoding.EncodeToString
+	header := ageIntro + "\n-> X25519 " + raw(share) + "\n" + raw(body) + "\n---"
+	mac := hmac.New(sha256.New, hkdfKey(fileKey, nil, "header"))
+	mac.Write([]byte(header))
+
+	nonce := randomBytes(t, 16)
+	stream, err := chacha20poly1305.New(hkdfKey(fileKey, nonce, "payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunkNonce := make([]byte, chacha20poly1305.NonceSize)
+	chunkNonce[11] = 1
+	payload := append(nonce, stream.Seal(nil, chunkNonce, data, nil)...)
+
+	file := append([]byte(header+" "+raw(mac.Sum(nil))+"\n"), payload...)
+	encoded := base64.StdEncoding.EncodeToString(file)
+	var armored strings.Builder
+	armored.WriteString(ageArmorBegin + "\n")
+	for len(encoded) > 64 {
+		armored.WriteString(encoded[:64] + "\n")
+		encoded = encoded[64:]
+	}
+	armored.WriteString(encoded + "\n" + ageArmorEnd + "\n")
+	return armored.String()
+}
+
+// encodeIdentity encodes an X25519 private key as an AGE-SECRET-KEY-1... identity.
+func encodeIdentity(t *testing.T, key *ecdh.PrivateKey) string {
+	t.Helper()
+	const charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	var values []byte
+	acc, bits := uint32(0), uint(0)
+	for _, b := range key.Bytes() {
+		acc = acc<<8 | uint32(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			values = append(values, byte(acc>>bits)&31)
+		}
+	}
+	if bits > 0 {
+		values = append(values, byte(acc<<(5-bits))&31)
+	}
+
+	chk := uint32(1)
+	polymod := func(v byte) {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i, g := range []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3} {
+			if (top>>i)&1 == 1 {
+				chk ^= g
+			}
+		}
+	}
+	hrp := ageSecretKeyHRP
+	for _, c := range []byte(hrp) {
+		polymod(c >> 5)
+	}
+	polymod(0)
+	for _, c := range []byte(hrp) {
+		polymod(c & 31)
+	}
+	for _, v := range values {
+		polymod(v)
+	}
+	for range 6 {
+		polymod(0)
+	}
+	chk ^= 1
+	for i := range 6 {
+		values = append(values, byte(chk>>(5*(5-i)))&31)
+	}
+
+	var s strings.Builder
+	s.WriteString(hrp + "1")
+	for _, v := range values {
+		s.WriteByte(charset[v])
+	}
+	return strings.ToUpper(s.String())
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
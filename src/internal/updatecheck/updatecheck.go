@@ -0,0 +1,170 @@
+package updatecheck
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReleasesURL is the GitHub releases feed of this project.
+const ReleasesURL = "https://api.github.com/repos/santiago-mooser/Kandji-Cloudflare-device-sync/releases?per_page=30"
+
+// securityPattern matches release notes that announce security fixes.
+var securityPattern = regexp.MustCompile(`(?i)\bsecurity\b|\bCVE-\d{4}-\d+\b|\bvulnerabilit`)
+
+type release struct {
+	TagName    string `json:"tag_name"`
+	Name       string `json:"name"`
+	Body       string `json:"body"`
+	HTMLURL    string `json:"html_url"`
+	Draft      bool   `json:"draft"`
+	Prerelease bool   `json:"prerelease"`
+}
+
+// Result is the outcome of an update check.
+type Result struct {
+	Latest string
+	URL    string
+	// Newer reports whether Latest is newer than the running version
+	Newer bool
+	// Security reports whether any release newer than the running version mentions security fixes
+	Security bool
+}
+
+// Checker checks the releases feed for versions newer than the running one.
+type Checker struct {
+	current    string
+	httpClient *http.Client
+	log        *slog.Logger
+}
+
+// New creates a new update checker for the running version.
+func New(current string, httpClient *http.Client, log *slog.Logger) *Checker {
+	return &Checker{
+		current:    current,
+		httpClient: httpClient,
+		log:        log,
+	}
+}
+
+// Run checks for updates immediately and then every interval until ctx is cancelled.
+// Newer releases are logged as warnings; failed checks are logged and retried next interval.
+func (c *Checker) Run(ctx context.Context, interval time.Duration) {
+	if _, ok := parseVersion(c.current); !ok {
+		c.log.Info("Skipping update check for development build", "version", c.current)
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		result, err := c.Check(ctx)
+		switch {
+		case err != nil:
+			c.log.Warn("Update check failed", "error", err)
+		case result.Newer:
+			c.log.Warn("A newer release is available",
+				"current_version", c.current,
+				"latest_version", result.Latest,
+				"security_fixes", result.Security,
+				"url", result.URL)
+		default:
+			c.log.Debug("Running the latest release", "version", c.current)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Check fetches the releases feed and compares the published releases with the running version.
+func (c *Checker) Check(ctx context.Context) (*Result, error) {
+	current, ok := parseVersion(c.current)
+	if !ok {
+		return nil, fmt.Errorf("running version %q is not a release version", c.current)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", ReleasesURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch releases: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to fetch releases: HTTP %d - %s", resp.StatusCode, string(body))
+	}
+
+	var releases []release
+	if err := json.NewDecoder(resp.Body).Decode(&releases); err != nil {
+		return nil, fmt.Errorf("failed to decode releases: %w", err)
+	}
+
+	result := &Result{Latest: c.current}
+	latest := current
+	for _, r := range releases {
+		if r.Draft || r.Prerelease {
+			continue
+		}
+		version, ok := parseVersion(r.TagName)
+		if !ok || !newer(version, current) {
+			continue
+		}
+		result.Newer = true
+		if securityPattern.MatchString(r.Name + "\n" + r.Body) {
+			result.Security = true
+		}
+		if newer(version, latest) {
+			latest = version
+			result.Latest = r.TagName
+			result.URL = r.HTMLURL
+		}
+	}
+	return result, nil
+}
+
+// parseVersion parses a "v1.2.3" or "1.2.3" version, ignoring any pre-release or build suffix.
+func parseVersion(s string) ([3]int, bool) {
+	var version [3]int
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) == 0 || len(parts) > 3 {
+		return version, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return version, false
+		}
+		version[i] = n
+	}
+	return version, true
+}
+
+func newer(a, b [3]int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] > b[i]
+		}
+	}
+	return false
+}
@@ -0,0 +1,218 @@
+// Package intune reads the managed devices of Microsoft Intune through the Microsoft Graph
+// API, authenticating as an app registration with the client credentials grant.
+package intune
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/internal/httpclient"
+)
+
+// managedDevicesFields are the managedDevice properties requested from Graph.
+const managedDevicesFields = "id,deviceName,serialNumber,operatingSystem,osVersion,model,userPrincipalName,lastSyncDateTime"
+
+// Device is a managed device from the Intune managedDevices endpoint.
+type Device struct {
+	ID                string `json:"id"`
+	DeviceName        string `json:"deviceName"`
+	SerialNumber      string `json:"serialNumber"`
+	OperatingSystem   string `json:"operatingSystem"`
+	OSVersion         string `json:"osVersion"`
+	Model             string `json:"model"`
+	UserPrincipalName string `json:"userPrincipalName"`
+	LastSyncDateTime  string `json:"lastSyncDateTime"`
+}
+
+// managedDevicesResponse is a page of the managedDevices collection.
+type managedDevicesResponse struct {
+	Value    []Device `json:"value"`
+	NextLink string   `json:"@odata.nextLink"`
+}
+
+// Client is a client for the Intune devices of a Microsoft Graph tenant.
+type Client struct {
+	tokenURL         string
+	graphURL         string
+	clientID         string
+	clientSecret     string
+	scope            string
+	operatingSystems []string
+	httpClient       *http.Client
+	httpOptions      httpclient.Options
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// Option configures optional Client behaviour.
+type Option func(*Client)
+
+// WithUserAgent sets the User-Agent sent on every request.
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.httpOptions.UserAgent = userAgent
+	}
+}
+
+// WithNetwork sets the DNS servers and static host mappings used to reach the API.
+func WithNetwork(cfg config.NetworkConfig) Option {
+	return func(c *Client) {
+		c.httpOptions.DNSServers = cfg.DNSServers
+		c.httpOptions.Hosts = cfg.Hosts
+	}
+}
+
+// NewClient creates a new Intune client.
+func NewClient(cfg config.IntuneConfig, opts ...Option) (*Client, error) {
+	if cfg.TenantID == "" || cfg.ClientID == "" || cfg.ClientSecret == "" {
+		return nil, fmt.Errorf("intune tenant_id, client_id and client_secret are required")
+	}
+	graphURL := strings.TrimRight(cfg.GraphURL, "/")
+	c := &Client{
+		tokenURL:         strings.TrimRight(cfg.LoginURL, "/") + "/" + url.PathEscape(cfg.TenantID) + "/oauth2/v2.0/token",
+		graphURL:         graphURL,
+		clientID:         cfg.ClientID,
+		clientSecret:     cfg.ClientSecret,
+		scope:            graphURL + "/.default",
+		operatingSystems: cfg.OperatingSystems,
+		httpOptions: httpclient.Options{
+			Timeout:  30 * time.Second,
+			ProxyURL: cfg.ProxyURL,
+		},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	httpClient, err := httpclient.New(c.httpOptions)
+	if err != nil {
+		return nil, err
+	}
+	c.httpClient = httpClient
+	return c, nil
+}
+
+// accessToken returns a Graph access token, requesting a new one if the cached token is
+// about to expire.
+func (c *Client) accessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Until(c.expiry) > time.Minute {
+		return c.token, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {c.clientID},
+		"client_secret": {c.clientSecret},
+		"scope":         {c.scope},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create Intune token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute Intune token request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Intune token request failed: HTTP %d - %s", resp.StatusCode, string(body))
+	}
+
+	var response struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", fmt.Errorf("failed to decode Intune token response: %w", err)
+	}
+
+	c.token = response.AccessToken
+	c.expiry = time.Now().Add(time.Duration(response.ExpiresIn) * time.Second)
+	return c.token, nil
+}
+
+// GetDevices retrieves every managed device of the configured operating systems.
+func (c *Client) GetDevices(ctx context.Context) ([]Device, error) {
+	query := url.Values{"$select": {managedDevicesFields}, "$top": {"999"}}
+	nextURL := c.graphURL + "/v1.0/deviceManagement/managedDevices?" + query.Encode()
+	maxPages := 1000 // Safety limit to prevent infinite loops
+	pageCount := 0
+
+	var devices []Device
+	for nextURL != "" && pageCount < maxPages {
+		pageCount++
+		token, err := c.accessToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "GET", nextURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Intune API request: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Accept", "application/json")
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute Intune API request: %w", err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Intune API response body: %w", err)
+		}
+		switch {
+		case resp.StatusCode == http.StatusForbidden:
+			return nil, fmt.Errorf("missing permission to list Intune devices (HTTP 403); grant the app registration DeviceManagementManagedDevices.Read.All")
+		case resp.StatusCode != http.StatusOK:
+			return nil, fmt.Errorf("received non-200 status from Intune API: %s, body: %s", resp.Status, string(body))
+		}
+
+		var page managedDevicesResponse
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal Intune devices JSON: %w", err)
+		}
+		for _, device := range page.Value {
+			if c.syncsOperatingSystem(device.OperatingSystem) {
+				devices = append(devices, device)
+			}
+		}
+		nextURL = page.NextLink
+	}
+
+	if pageCount >= maxPages && nextURL != "" {
+		return devices, fmt.Errorf("reached maximum page limit (%d pages), there may be more devices", maxPages)
+	}
+	return devices, nil
+}
+
+// syncsOperatingSystem reports whether devices of an Intune operating system are synced.
+func (c *Client) syncsOperatingSystem(operatingSystem string) bool {
+	return slices.ContainsFunc(c.operatingSystems, func(synced string) bool {
+		return strings.EqualFold(synced, operatingSystem)
+	})
+}
+
+// Probe requests an access token to check that the tenant and app credentials are accepted.
+func (c *Client) Probe(ctx context.Context) error {
+	_, err := c.accessToken(ctx)
+	return err
+}
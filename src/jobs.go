@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"kandji-cloudflare-device-sync/cloudflare"
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/engine"
+	"kandji-cloudflare-device-sync/state"
+	"kandji-cloudflare-device-sync/syncer"
+)
+
+// syncJob is a sync run by the process: the top-level sync or one of the configured jobs.
+type syncJob struct {
+	cfg    *config.Config
+	engine *engine.Engine
+	syncer *syncer.Syncer
+	log    *slog.Logger
+	// name is the job's name, empty for the top-level sync
+	name string
+	// attrs identify the job in logs, empty for the top-level sync
+	attrs []any
+}
+
+// openState opens the state store and loads the warm cache configured in cfg, and returns
+// the client and syncer options that use them. The store is nil if none is configured.
+func openState(cfg *config.Config, log *slog.Logger) (*state.Store, []cloudflare.Option, []syncer.Option, error) {
+	var store *state.Store
+	var cloudflareOptions []cloudflare.Option
+	var syncerOptions []syncer.Option
+	if cfg.State.Path != "" {
+		var err error
+		store, err = state.Open(cfg.State.Path, cfg.State.Retention)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		// Batches applied by a cycle are not applied again by a retried or resumed cycle
+		cloudflareOptions = append(cloudflareOptions, cloudflare.WithAppliedBatches(store, cfg.State.IdempotencyWindow))
+		syncerOptions = append(syncerOptions, syncer.WithStateStore(store))
+	}
+
+	if cfg.WarmCache.Path != "" {
+		// The cache only saves a download, start cold if it cannot be read
+		warmCache, err := state.LoadWarmCache(cfg.WarmCache.Path)
+		if err != nil {
+			log.Warn("Failed to load warm cache, starting cold", "path", cfg.WarmCache.Path, "error", err)
+		}
+		syncerOptions = append(syncerOptions, syncer.WithWarmCache(warmCache, cfg.WarmCache.MaxAge))
+	}
+	return store, cloudflareOptions, syncerOptions, nil
+}
+
+// inventoryMaxAge is how long the Kandji inventory downloaded by one sync is reused by the
+// others: half the shortest sync interval, so the most frequent sync always downloads a
+// fresh inventory and the others reuse it.
+func inventoryMaxAge(cfg *config.Config) time.Duration {
+	interval := cfg.SyncInterval
+	for _, job := range cfg.Jobs {
+		if job.SyncInterval > 0 && job.SyncInterval < interval {
+			interval = job.SyncInterval
+		}
+	}
+	return interval / 2
+}
+
+// setupJobs creates a syncer for every configured job. The jobs share the Kandji client, and
+// with it the inventory, of the top-level engine. opts are the options the top-level engine
+// was created with before its state options; each job adds its own.
+func setupJobs(ctx context.Context, cfg *config.Config, log *slog.Logger, top *engine.Engine, opts engine.Options, sharedSyncerOptions []syncer.Option) ([]*syncJob, error) {
+	var jobs []*syncJob
+	for _, job := range cfg.Jobs {
+		jobCfg := cfg.ForJob(job)
+		jobLog := log.With("job", job.Name)
+		store, cloudflareOptions, syncerOptions, err := openState(jobCfg, jobLog)
+		if err != nil {
+			fail(jobLog, exitFailure, "Failed to open state store", "path", jobCfg.State.Path, "error", err)
+		}
+
+		jobOpts := opts
+		jobOpts.Config = jobCfg
+		jobOpts.Logger = jobLog
+		jobOpts.KandjiClient = top.KandjiClient()
+		jobOpts.MosyleClient = top.MosyleClient()
+		jobOpts.KandjiTenants = top.KandjiTenants()
+		jobOpts.CloudflareOptions = append(append([]cloudflare.Option(nil), opts.CloudflareOptions...), cloudflareOptions...)
+		jobOpts.SyncerOptions = append(append([]syncer.Option(nil), sharedSyncerOptions...), syncerOptions...)
+		if store != nil {
+			jobOpts.CreatedLists = store
+		}
+		jobEngine, err := engine.New(ctx, jobOpts)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, &syncJob{cfg: jobCfg, engine: jobEngine, syncer: jobEngine.Syncer(), log: jobLog, name: job.Name, attrs: []any{"job", job.Name}})
+	}
+	return jobs, nil
+}
@@ -0,0 +1,160 @@
+package kandji
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// errUnordered is returned by fetchChangedSince if the API did not sort the devices by their
+// last check-in as requested, so the devices that changed cannot be told apart.
+var errUnordered = errors.New("devices are not ordered by last check-in")
+
+// incrementalSync holds the inventory that GetDevices updates with the devices that checked
+// in since the last download, see WithIncrementalSync.
+type incrementalSync struct {
+	fullResync time.Duration
+	log        *slog.Logger
+
+	// mu is held during a download
+	mu      sync.Mutex
+	devices []Device
+	// watermark is the newest check-in seen, fullAt when the whole inventory was last
+	// downloaded
+	watermark time.Time
+	fullAt    time.Time
+	// unordered is set once Kandji ignored the ordering, every download is a full one then
+	unordered bool
+}
+
+// WithIncrementalSync makes GetDevices download only the devices that checked in since the
+// newest check-in it saw before, and merge them into the inventory it downloaded last. The
+// whole inventory is downloaded at first and then again every fullResync, which is when
+// devices deleted from Kandji, and changes made to devices that did not check in since, are
+// picked up.
+func WithIncrementalSync(fullResync time.Duration, log *slog.Logger) Option {
+	return func(c *Client) {
+		c.incremental = &incrementalSync{fullResync: fullResync, log: log}
+	}
+}
+
+// fetchIncremental returns the inventory, downloading it in full if it is due and only the
+// devices that checked in since the last download otherwise.
+func (c *Client) fetchIncremental(ctx context.Context) ([]Device, error) {
+	inc := c.incremental
+	inc.mu.Lock()
+	defer inc.mu.Unlock()
+
+	if inc.unordered || inc.devices == nil || time.Since(inc.fullAt) >= inc.fullResync {
+		return c.fetchFull(ctx)
+	}
+	changed, err := c.fetchChangedSince(ctx, inc.watermark)
+	if errors.Is(err, errUnordered) {
+		inc.log.Warn("Kandji did not order the devices by last check-in, downloading the whole inventory every cycle until restarted", "url", c.apiURL)
+		inc.unordered = true
+		return c.fetchFull(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]int, len(inc.devices))
+	for i, device := range inc.devices {
+		index[deviceKey(device)] = i
+	}
+	for _, device := range changed {
+		if i, ok := index[deviceKey(device)]; ok {
+			inc.devices[i] = device
+		} else {
+			index[deviceKey(device)] = len(inc.devices)
+			inc.devices = append(inc.devices, device)
+		}
+	}
+	since := inc.watermark
+	inc.watermark = newestCheckIn(changed, inc.watermark)
+	inc.log.Info("Downloaded the Kandji devices that checked in since the last download",
+		"url", c.apiURL,
+		"changed", len(changed),
+		"since", since.Format(time.RFC3339),
+		"devices", len(inc.devices),
+		"next_full_resync", inc.fullAt.Add(inc.fullResync).Format(time.RFC3339))
+	return append([]Device(nil), inc.devices...), nil
+}
+
+// fetchFull downloads the whole inventory and makes it the one that later downloads update.
+func (c *Client) fetchFull(ctx context.Context) ([]Device, error) {
+	inc := c.incremental
+	devices, err := c.fetchDevices(ctx)
+	if err != nil {
+		return devices, err
+	}
+	inc.devices, inc.fullAt = devices, time.Now()
+	inc.watermark = newestCheckIn(devices, time.Time{})
+	inc.log.Info("Downloaded the whole Kandji inventory", "url", c.apiURL, "devices", len(devices), "newest_check_in", inc.watermark.Format(time.RFC3339))
+	return append([]Device(nil), devices...), nil
+}
+
+// fetchChangedSince pages through the devices from the most recent check-in down, until it
+// reaches a device that last checked in before since. Devices checking in exactly at since
+// are downloaded again, so none are missed. Devices without a check-in time are included.
+//
+// The ordering parameter is not part of Kandji's documented device API. The order of every
+// page is therefore checked, and errUnordered returned if the API ignored it.
+func (c *Client) fetchChangedSince(ctx context.Context, since time.Time) ([]Device, error) {
+	var (
+		changed  []Device
+		previous time.Time
+	)
+	pageURL := fmt.Sprintf("%s/api/v1/devices?ordering=-last_seen&limit=%d&offset=0", c.apiURL, c.limit())
+	for pageCount := 0; pageCount < maxPages; pageCount++ {
+		page, err := c.getDevicePage(ctx, pageURL)
+		if err != nil {
+			return nil, err
+		}
+		// The whole page is checked for order, as the first device may already be too old
+		for _, device := range page.devices {
+			if seen, err := time.Parse(time.RFC3339Nano, device.LastSeen); err == nil {
+				if !previous.IsZero() && seen.After(previous) {
+					return nil, errUnordered
+				}
+				previous = seen
+			}
+		}
+		for _, device := range page.devices {
+			if seen, err := time.Parse(time.RFC3339Nano, device.LastSeen); err == nil && seen.Before(since) {
+				return changed, nil
+			}
+			changed = append(changed, device)
+		}
+		// Plain arrays are paged by offset until a short page, like the full download
+		pageURL, err = nextPageURL(pageURL, page)
+		if err != nil {
+			return nil, err
+		}
+		if pageURL == "" {
+			return changed, nil
+		}
+	}
+	return nil, fmt.Errorf("reached maximum page limit (%d pages), there may be more devices", maxPages)
+}
+
+// deviceKey identifies a device across downloads, by its Kandji ID if it has one.
+func deviceKey(device Device) string {
+	if device.DeviceID != "" {
+		return device.DeviceID
+	}
+	return "serial:" + device.SerialNumber
+}
+
+// newestCheckIn returns the latest check-in of devices, or newest if it is later.
+func newestCheckIn(devices []Device, newest time.Time) time.Time {
+	for _, device := range devices {
+		if seen, err := time.Parse(time.RFC3339Nano, device.LastSeen); err == nil && seen.After(newest) {
+			newest = seen
+		}
+	}
+	return newest
+}
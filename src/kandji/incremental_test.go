@@ -0,0 +1,85 @@
+package kandji
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"kandji-cloudflare-device-sync/config"
+)
+
+// checkIns serves devices as plain arrays by limit and offset, with the check-ins in the
+// given order, the way /api/v1/devices?ordering=-last_seen does.
+type checkIns struct {
+	devices  []Device
+	requests int
+}
+
+func (c *checkIns) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.requests++
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	end := min(offset+limit, len(c.devices))
+	json.NewEncoder(w).Encode(c.devices[min(offset, end):end])
+}
+
+func TestFetchChangedSince(t *testing.T) {
+	base := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	// 12 devices, one check-in a minute apart, newest first
+	newestFirst := make([]Device, 12)
+	for i := range newestFirst {
+		newestFirst[i] = Device{SerialNumber: fmt.Sprintf("SN%02d", i), LastSeen: base.Add(-time.Duration(i) * time.Minute).Format(time.RFC3339)}
+	}
+	shuffled := append([]Device{newestFirst[6]}, newestFirst[:6]...)
+	tests := []struct {
+		name         string
+		devices      []Device
+		since        time.Time
+		wantChanged  int
+		wantRequests int
+		wantErr      error
+	}{
+		{name: "within the first page", devices: newestFirst, since: base.Add(-2 * time.Minute), wantChanged: 3, wantRequests: 1},
+		{name: "across plain-array pages", devices: newestFirst, since: base.Add(-11 * time.Minute), wantChanged: 12, wantRequests: 3},
+		{name: "all devices changed", devices: newestFirst, since: base.Add(-time.Hour), wantChanged: 12, wantRequests: 3},
+		{name: "ordering ignored", devices: shuffled, since: base.Add(-time.Hour), wantErr: errUnordered},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &checkIns{devices: tt.devices}
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+			c, err := NewClient(config.KandjiConfig{
+				ApiURL:     "https://example.api.kandji.io",
+				ApiToken:   "token",
+				Pagination: config.KandjiPaginationConfig{PageSize: 5},
+			}, nil, WithBaseURL(srv.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			changed, err := c.fetchChangedSince(context.Background(), tt.since)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("fetchChangedSince() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("fetchChangedSince() error = %v", err)
+			}
+			if len(changed) != tt.wantChanged {
+				t.Errorf("fetchChangedSince() returned %d devices, want %d", len(changed), tt.wantChanged)
+			}
+			if api.requests != tt.wantRequests {
+				t.Errorf("fetchChangedSince() sent %d requests, want %d", api.requests, tt.wantRequests)
+			}
+		})
+	}
+}
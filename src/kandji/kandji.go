@@ -1,15 +1,20 @@
 package kandji
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"strings"
+	"net/url"
+	"strconv"
 	"time"
 
 	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/internal/authtoken"
+	"kandji-cloudflare-device-sync/internal/httpclient"
 	"kandji-cloudflare-device-sync/internal/ratelimit"
 )
 
@@ -38,6 +43,11 @@ type Device struct {
 	Tags           []string `json:"tags"`
 	BlueprintID    string   `json:"blueprint_id"`
 	BlueprintName  string   `json:"blueprint_name"`
+	// PublicIP is the public IP the device last reported, if the device listing includes it
+	PublicIP string `json:"public_ip"`
+	// Tenant is the name of the additional tenant the device was read from, empty for the
+	// primary tenant; it is set by the syncer
+	Tenant string `json:"-"`
 }
 
 // UnmarshalJSON implements custom JSON unmarshaling for Device to handle the user field properly
@@ -58,6 +68,7 @@ func (d *Device) UnmarshalJSON(data []byte) error {
 		Tags           []string    `json:"tags"`
 		BlueprintID    string      `json:"blueprint_id"`
 		BlueprintName  string      `json:"blueprint_name"`
+		PublicIP       string      `json:"public_ip"`
 	}
 
 	var temp TempDevice
@@ -79,6 +90,7 @@ func (d *Device) UnmarshalJSON(data []byte) error {
 	d.Tags = temp.Tags
 	d.BlueprintID = temp.BlueprintID
 	d.BlueprintName = temp.BlueprintName
+	d.PublicIP = temp.PublicIP
 
 	// Handle the user field based on its type
 	if temp.User != nil {
@@ -120,13 +132,72 @@ type DevicesResponse struct {
 // Client is a client for interacting with the Kandji API.
 type Client struct {
 	apiURL      string
-	apiToken    string
+	apiToken    *authtoken.Token
 	httpClient  *http.Client
+	httpOptions httpclient.Options
 	rateLimiter *ratelimit.Limiter
+	// inventory is nil unless WithInventoryCache is set, incremental unless
+	// WithIncrementalSync is
+	inventory   *inventoryCache
+	incremental *incrementalSync
+	// pageSize and pageConcurrency are the kandji.pagination settings, see fetchDevices
+	pageSize        int
+	pageConcurrency int
+}
+
+// Option configures optional Client behaviour.
+type Option func(*Client)
+
+// WithUserAgent sets the User-Agent sent on every request.
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.httpOptions.UserAgent = userAgent
+	}
+}
+
+// WithNetwork sets the DNS servers and static host mappings used to reach the API.
+func WithNetwork(cfg config.NetworkConfig) Option {
+	return func(c *Client) {
+		c.httpOptions.DNSServers = cfg.DNSServers
+		c.httpOptions.Hosts = cfg.Hosts
+	}
+}
+
+// WithObserver sets an observer that is notified of the outcome of every API request.
+func WithObserver(observer httpclient.Observer) Option {
+	return func(c *Client) {
+		c.httpOptions.Observer = observer
+	}
+}
+
+// WithConditionalRequests keeps the responses of up to maxEntries GET requests that carry an
+// ETag or Last-Modified header, and sends those requests conditionally, so that Kandji
+// does not send a response again that did not change.
+func WithConditionalRequests(maxEntries int) Option {
+	return func(c *Client) {
+		c.httpOptions.ConditionalRequests = true
+		c.httpOptions.CacheEntries = maxEntries
+	}
+}
+
+// WithTokenSource sets where the API token is read again from when Kandji rejects it, so
+// that requests made after the token was rotated are retried with the new one.
+func WithTokenSource(source authtoken.Source) Option {
+	return func(c *Client) {
+		c.apiToken.SetSource(source)
+	}
+}
+
+// WithBaseURL sends API requests to baseURL instead of the configured tenant API URL, for
+// example to the fake API started in sandbox mode.
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.apiURL = baseURL
+	}
 }
 
 // NewClient creates a new Kandji API client.
-func NewClient(cfg config.KandjiConfig, rateLimiter *ratelimit.Limiter) (*Client, error) {
+func NewClient(cfg config.KandjiConfig, rateLimiter *ratelimit.Limiter, opts ...Option) (*Client, error) {
 	// Validate the API URL and token
 	if cfg.ApiURL == "" {
 		return nil, fmt.Errorf("kandji api url is required")
@@ -134,27 +205,132 @@ func NewClient(cfg config.KandjiConfig, rateLimiter *ratelimit.Limiter) (*Client
 	if cfg.ApiToken == "" {
 		return nil, fmt.Errorf("kandji api token is required")
 	}
-	if !strings.HasPrefix(cfg.ApiURL, "https://") {
-		return nil, fmt.Errorf("kandji api url must start with https://")
+	apiURL, err := config.NormalizeKandjiAPIURL(cfg.ApiURL)
+	if err != nil {
+		return nil, err
 	}
 
-	return &Client{
-		apiURL:      strings.TrimSuffix(cfg.ApiURL, "/"),
-		apiToken:    cfg.ApiToken,
-		rateLimiter: rateLimiter,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
+	c := &Client{
+		apiURL:          apiURL,
+		apiToken:        authtoken.New(cfg.ApiToken),
+		rateLimiter:     rateLimiter,
+		pageSize:        cfg.Pagination.PageSize,
+		pageConcurrency: cfg.Pagination.Concurrency,
+		httpOptions: httpclient.Options{
+			Timeout:  30 * time.Second,
+			ProxyURL: cfg.ProxyURL,
 		},
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	httpClient, err := httpclient.New(c.httpOptions)
+	if err != nil {
+		return nil, err
+	}
+	c.httpClient = httpClient
+	return c, nil
+}
+
+// SetToken replaces the API token, e.g. after it was rotated. Requests in progress finish with
+// the previous token.
+func (c *Client) SetToken(token string) {
+	c.apiToken.Set(token)
+}
+
+// do sends an API request with the current token. If Kandji rejects the token with HTTP 401
+// and a newer one is read from the token source, the request is sent again with it.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	token := c.apiToken.Get()
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := c.httpClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || (req.Body != nil && req.GetBody == nil) {
+		return resp, err
+	}
+	newToken, ok := c.apiToken.Refresh(req.Context(), token)
+	if !ok {
+		return resp, nil
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, fmt.Errorf("failed to rewind request body: %w", err)
+		}
+	}
+	retry.Header.Set("Authorization", "Bearer "+newToken)
+	return c.httpClient.Do(retry)
+}
+
+// ErrUnauthorized is returned when Kandji rejects the API token or its permissions.
+var ErrUnauthorized = errors.New("Kandji API token rejected")
+
+// Probe makes a lightweight authenticated request to check that the API URL points to a
+// Kandji tenant and that the token is accepted, and returns an actionable error otherwise.
+func (c *Client) Probe(ctx context.Context) error {
+	if c.rateLimiter != nil {
+		if err := c.rateLimiter.WaitForKandji(ctx); err != nil {
+			return fmt.Errorf("rate limiter cancelled: %w", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", c.apiURL+"/api/v1/devices?limit=1", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create Kandji API request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach Kandji API at %s (check the tenant name, DNS and proxy settings): %w", c.apiURL, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		if !json.Valid(body) {
+			return fmt.Errorf("Kandji API at %s did not return JSON; check that the URL is the tenant API URL", c.apiURL)
+		}
+		return nil
+	case resp.StatusCode == http.StatusUnauthorized:
+		return fmt.Errorf("%w (HTTP 401); check KANDJI_API_TOKEN and that it belongs to this tenant", ErrUnauthorized)
+	case resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%w: missing permission to list devices (HTTP 403); grant the Device List permission in Kandji", ErrUnauthorized)
+	case resp.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("no Kandji API found at %s (HTTP 404); the URL should be https://<tenant>.api.kandji.io", c.apiURL)
+	default:
+		return fmt.Errorf("unexpected response from Kandji API: %s, body: %s", resp.Status, string(body))
+	}
 }
 
 // GetDevices retrieves a list of all devices from Kandji with pagination support.
 func (c *Client) GetDevices(ctx context.Context) ([]Device, error) {
-	var allDevices []Device
-	nextURL := c.apiURL + "/api/v1/devices"
-	maxPages := 1000 // Safety limit to prevent infinite loops
-	pageCount := 0
+	fetch := c.fetchDevices
+	if c.incremental != nil {
+		fetch = c.fetchIncremental
+	}
+	if c.inventory != nil {
+		return c.inventory.cachedDevices(ctx, fetch)
+	}
+	return fetch(ctx)
+}
 
+// fetchDevices downloads every page of the device inventory, page_size devices at a time,
+// following the link to the next page, or fetching several pages at once if the page
+// concurrency is above 1.
+func (c *Client) fetchDevices(ctx context.Context) ([]Device, error) {
+	if c.pageConcurrency > 1 {
+		return c.fetchDevicesParallel(ctx)
+	}
+	return c.followPages(ctx, c.pageURL(0, c.limit()), nil, 0)
+}
+
+// followPages downloads the page at nextURL and the pages it links to one after another,
+// appending their devices to allDevices. pageCount is the number of pages downloaded before.
+func (c *Client) followPages(ctx context.Context, nextURL string, allDevices []Device, pageCount int) ([]Device, error) {
 	for nextURL != "" && pageCount < maxPages {
 		// Check for context cancellation
 		select {
@@ -164,71 +340,209 @@ func (c *Client) GetDevices(ctx context.Context) ([]Device, error) {
 		}
 
 		pageCount++
-
-		// Apply rate limiting
-		if c.rateLimiter != nil {
-			if err := c.rateLimiter.WaitForKandji(ctx); err != nil {
-				return nil, fmt.Errorf("rate limiter cancelled: %w", err)
-			}
+		page, err := c.getDevicePage(ctx, nextURL)
+		if err != nil {
+			return nil, err
 		}
-
-		req, err := http.NewRequest("GET", nextURL, nil)
+		allDevices = append(allDevices, page.devices...)
+		nextURL, err = nextPageURL(nextURL, page)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create Kandji API request: %w", err)
+			return nil, err
 		}
+	}
+
+	// Check if we hit the page limit
+	if pageCount >= maxPages {
+		return allDevices, fmt.Errorf("reached maximum page limit (%d pages), there may be more devices", maxPages)
+	}
 
-		// Add context to the request
-		req = req.WithContext(ctx)
+	return allDevices, nil
+}
 
-		req.Header.Set("Authorization", "Bearer "+c.apiToken)
-		req.Header.Set("Accept", "application/json")
-		req.Header.Set("User-Agent", "kandji-cloudflare-device-sync/1.0")
+// devicePage is a page of the device inventory.
+type devicePage struct {
+	devices []Device
+	// total is the number of devices Kandji reported, -1 if the page was a plain array
+	total int
+	// next is the URL of the next page, nil on the last page or a plain array
+	next *string
+}
 
-		resp, err := c.httpClient.Do(req)
-		if err != nil {
-			if resp != nil {
-				resp.Body.Close()
-			}
-			return nil, fmt.Errorf("failed to execute Kandji API request: %w", err)
+// nextPageURL returns the URL of the page after page, which was downloaded from pageURL, or
+// an empty string after the last page. A paginated response links to the next page. The
+// plain array that /api/v1/devices returns holds up to limit devices and does not, so the
+// offset is advanced until a page comes back shorter than the limit.
+func nextPageURL(pageURL string, page *devicePage) (string, error) {
+	if page.total >= 0 {
+		if page.next == nil {
+			return "", nil
 		}
+		return *page.next, nil
+	}
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid Kandji page URL: %w", err)
+	}
+	query := u.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	if len(page.devices) == 0 || len(page.devices) < limit {
+		return "", nil
+	}
+	query.Set("offset", strconv.Itoa(offset+len(page.devices)))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// getDevicePage downloads a page of the device inventory, waiting for the rate limiter.
+func (c *Client) getDevicePage(ctx context.Context, pageURL string) (*devicePage, error) {
+	// Apply rate limiting
+	if c.rateLimiter != nil {
+		if err := c.rateLimiter.WaitForKandji(ctx); err != nil {
+			return nil, fmt.Errorf("rate limiter cancelled: %w", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", pageURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kandji API request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
 
-		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
+	resp, err := c.do(req)
+	if err != nil {
+		if resp != nil {
 			resp.Body.Close()
-			return nil, fmt.Errorf("received non-200 status from Kandji API: %s, body: %s", resp.Status, string(body))
 		}
+		return nil, fmt.Errorf("failed to execute Kandji API request: %w", err)
+	}
 
-		body, err := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
-		if err != nil {
-			return nil, fmt.Errorf("failed to read Kandji API response body: %w", err)
+		return nil, fmt.Errorf("received non-200 status from Kandji API: %s, body: %s", resp.Status, string(body))
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Kandji API response body: %w", err)
+	}
+
+	// Try to parse as paginated response first
+	var paginatedResp DevicesResponse
+	if err := json.Unmarshal(body, &paginatedResp); err == nil {
+		return &devicePage{devices: paginatedResp.Results, total: paginatedResp.Count, next: paginatedResp.Next}, nil
+	}
+	// Fallback: try to parse as direct array
+	var devices []Device
+	if err := json.Unmarshal(body, &devices); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Kandji devices JSON: %w, body: %s", err, string(body))
+	}
+	return &devicePage{devices: devices, total: -1}, nil
+}
+
+// Note represents a device note from the Kandji API.
+type Note struct {
+	NoteID  string `json:"note_id"`
+	Content string `json:"content"`
+}
+
+// makeRequest makes a rate limited request to the Kandji API and fails on non-2xx responses.
+func (c *Client) makeRequest(ctx context.Context, method, path string, body any) ([]byte, error) {
+	if c.rateLimiter != nil {
+		if err := c.rateLimiter.WaitForKandji(ctx); err != nil {
+			return nil, fmt.Errorf("rate limiter cancelled: %w", err)
 		}
+	}
 
-		// Try to parse as paginated response first
-		var paginatedResp DevicesResponse
-		if err := json.Unmarshal(body, &paginatedResp); err == nil {
-			allDevices = append(allDevices, paginatedResp.Results...)
-			if paginatedResp.Next != nil {
-				nextURL = *paginatedResp.Next
-			} else {
-				nextURL = ""
-			}
-		} else {
-			// Fallback: try to parse as direct array
-			var devices []Device
-			if err := json.Unmarshal(body, &devices); err != nil {
-				fmt.Printf("Error details: %s\n", string(body))
-				return nil, fmt.Errorf("failed to unmarshal Kandji devices JSON: %w", err)
-			}
-			allDevices = append(allDevices, devices...)
-			nextURL = ""
+	var reqBody io.Reader
+	if body != nil {
+		jsonBody, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request body: %w", err)
 		}
+		reqBody = bytes.NewReader(jsonBody)
 	}
 
-	// Check if we hit the page limit
-	if pageCount >= maxPages {
-		return allDevices, fmt.Errorf("reached maximum page limit (%d pages), there may be more devices", maxPages)
+	req, err := http.NewRequestWithContext(ctx, method, c.apiURL+path, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kandji API request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
 	}
 
-	return allDevices, nil
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute Kandji API request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Kandji API response body: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("received non-2xx status from Kandji API: %s, body: %s", resp.Status, string(respBody))
+	}
+	return respBody, nil
+}
+
+// UpdateDeviceTags replaces the tags of a device.
+func (c *Client) UpdateDeviceTags(ctx context.Context, deviceID string, tags []string) error {
+	if tags == nil {
+		tags = []string{}
+	}
+	_, err := c.makeRequest(ctx, "PATCH", "/api/v1/devices/"+url.PathEscape(deviceID), map[string]any{"tags": tags})
+	return err
+}
+
+// GetDeviceNotes retrieves the notes of a device.
+func (c *Client) GetDeviceNotes(ctx context.Context, deviceID string) ([]Note, error) {
+	body, err := c.makeRequest(ctx, "GET", "/api/v1/devices/"+url.PathEscape(deviceID)+"/notes", nil)
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		Notes []Note `json:"notes"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Kandji notes JSON: %w", err)
+	}
+	return response.Notes, nil
+}
+
+// CreateDeviceNote adds a note to a device.
+func (c *Client) CreateDeviceNote(ctx context.Context, deviceID, content string) error {
+	_, err := c.makeRequest(ctx, "POST", "/api/v1/devices/"+url.PathEscape(deviceID)+"/notes", map[string]string{"content": content})
+	return err
+}
+
+// UpdateDeviceNote replaces the content of an existing device note.
+func (c *Client) UpdateDeviceNote(ctx context.Context, deviceID, noteID, content string) error {
+	_, err := c.makeRequest(ctx, "PATCH", "/api/v1/devices/"+url.PathEscape(deviceID)+"/notes/"+url.PathEscape(noteID), map[string]string{"content": content})
+	return err
+}
+
+// DeviceDetails represents the subset of the Kandji device details used by the syncer.
+type DeviceDetails struct {
+	Network struct {
+		PublicIP string `json:"public_ip"`
+		LocalIP  string `json:"local_ip"`
+	} `json:"network"`
+}
+
+// GetDeviceDetails retrieves the detailed record of a single device.
+func (c *Client) GetDeviceDetails(ctx context.Context, deviceID string) (*DeviceDetails, error) {
+	body, err := c.makeRequest(ctx, "GET", "/api/v1/devices/"+url.PathEscape(deviceID)+"/details", nil)
+	if err != nil {
+		return nil, err
+	}
+	var details DeviceDetails
+	if err := json.Unmarshal(body, &details); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Kandji device details JSON: %w", err)
+	}
+	return &details, nil
 }
@@ -0,0 +1,144 @@
+package kandji
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+const (
+	// maxPages is a safety limit to prevent infinite loops
+	maxPages = 1000
+	// maxPageSize is the most devices Kandji returns per page
+	maxPageSize = 300
+)
+
+// fetchDevicesParallel downloads the device inventory by offset, up to pageConcurrency pages
+// at once. Every request still waits for the shared rate limiter, so concurrency only helps
+// while the limiter has capacity to spare, i.e. when the page latency is what limits the
+// download. If Kandji reports the number of devices, the remaining pages are all requested
+// after the first; otherwise, as for the plain arrays of /api/v1/devices, pages are requested
+// in rounds until one comes back short.
+func (c *Client) fetchDevicesParallel(ctx context.Context) ([]Device, error) {
+	size := c.limit()
+	first, err := c.getDevicePage(ctx, c.pageURL(0, size))
+	if err != nil {
+		return nil, err
+	}
+	allDevices := first.devices
+	// A server that returns fewer devices per page than requested sets the stride
+	if first.next != nil && len(first.devices) > 0 && len(first.devices) < size {
+		size = len(first.devices)
+	}
+	if len(first.devices) < size && first.next == nil {
+		return allDevices, nil
+	}
+
+	if first.total >= len(first.devices) {
+		pages := (first.total + size - 1) / size
+		if pages > maxPages {
+			return allDevices, fmt.Errorf("reached maximum page limit (%d pages), there may be more devices", maxPages)
+		}
+		offsets := make([]int, 0, pages-1)
+		for page := 1; page < pages; page++ {
+			offsets = append(offsets, page*size)
+		}
+		rest, err := c.getDevicePages(ctx, offsets, size)
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		for _, page := range rest {
+			allDevices = append(allDevices, page.devices...)
+			last = page
+		}
+		// Devices enrolled during the download push the last ones onto a further page
+		if last.next != nil {
+			return c.followPages(ctx, *last.next, allDevices, pages)
+		}
+		return allDevices, nil
+	}
+
+	// Without a device count, request a round of pages at a time until one is short
+	for pageCount := 1; pageCount < maxPages; {
+		offsets := make([]int, 0, c.pageConcurrency)
+		for len(offsets) < c.pageConcurrency && pageCount+len(offsets) < maxPages {
+			offsets = append(offsets, (pageCount+len(offsets))*size)
+		}
+		pages, err := c.getDevicePages(ctx, offsets, size)
+		if err != nil {
+			return nil, err
+		}
+		pageCount += len(pages)
+		for _, page := range pages {
+			allDevices = append(allDevices, page.devices...)
+			if len(page.devices) < size {
+				return allDevices, nil
+			}
+		}
+	}
+	return allDevices, fmt.Errorf("reached maximum page limit (%d pages), there may be more devices", maxPages)
+}
+
+// getDevicePages downloads the pages at offsets with up to pageConcurrency requests at a
+// time, and returns them in the order of offsets. The first failure cancels the others.
+func (c *Client) getDevicePages(ctx context.Context, offsets []int, size int) ([]*devicePage, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pages := make([]*devicePage, len(offsets))
+	indexes := make(chan int)
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for range min(c.pageConcurrency, len(offsets)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				page, err := c.getDevicePage(ctx, c.pageURL(offsets[i], size))
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					continue
+				}
+				pages[i] = page
+			}
+		}()
+	}
+feed:
+	for i := range offsets {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return pages, nil
+}
+
+// limit returns the number of devices requested per page, kandji.pagination.page_size.
+func (c *Client) limit() int {
+	if c.pageSize <= 0 || c.pageSize > maxPageSize {
+		return maxPageSize
+	}
+	return c.pageSize
+}
+
+// pageURL returns the URL of the page of the device inventory at offset.
+func (c *Client) pageURL(offset, size int) string {
+	return fmt.Sprintf("%s/api/v1/devices?limit=%d&offset=%d", c.apiURL, size, offset)
+}
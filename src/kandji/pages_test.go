@@ -0,0 +1,171 @@
+package kandji
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"kandji-cloudflare-device-sync/config"
+)
+
+// fakeInventory serves a device inventory by limit and offset, as the Kandji API does.
+type fakeInventory struct {
+	devices int
+	// array serves pages as plain arrays without a count or a link to the next page, as
+	// /api/v1/devices does
+	array bool
+	// withoutCount leaves the device count out of paginated responses
+	withoutCount bool
+	// maxPageSize caps the devices per page below the requested limit
+	maxPageSize int
+	// enrolled devices are added once the first page was served
+	enrolled int
+	// failOffset fails the request for the page at that offset
+	failOffset int
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func (f *fakeInventory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, r.URL.RawQuery)
+	total := f.devices
+	if len(f.requests) > 1 {
+		total += f.enrolled
+	}
+	f.mu.Unlock()
+
+	devices := make([]Device, 0, total)
+	for i := range total {
+		devices = append(devices, Device{SerialNumber: fmt.Sprintf("SN%04d", i)})
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	if f.failOffset > 0 && offset == f.failOffset {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if f.maxPageSize > 0 && limit > f.maxPageSize {
+		limit = f.maxPageSize
+	}
+	end := min(offset+limit, total)
+	if f.array {
+		json.NewEncoder(w).Encode(devices[min(offset, total):end])
+		return
+	}
+	resp := DevicesResponse{Results: devices[min(offset, total):end]}
+	if !f.withoutCount {
+		resp.Count = total
+	}
+	if end < total {
+		next := fmt.Sprintf("http://%s/api/v1/devices?limit=%d&offset=%d", r.Host, limit, end)
+		resp.Next = &next
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func TestFetchDevicesParallel(t *testing.T) {
+	tests := []struct {
+		name         string
+		inventory    *fakeInventory
+		pageSize     int
+		wantDevices  int
+		wantRequests int
+		wantErr      bool
+	}{
+		{name: "counted pages", inventory: &fakeInventory{devices: 23}, pageSize: 5, wantDevices: 23, wantRequests: 5},
+		{name: "a single page", inventory: &fakeInventory{devices: 3}, pageSize: 5, wantDevices: 3, wantRequests: 1},
+		{name: "an exact number of pages", inventory: &fakeInventory{devices: 10}, pageSize: 5, wantDevices: 10, wantRequests: 2},
+		{name: "empty inventory", inventory: &fakeInventory{}, pageSize: 5, wantRequests: 1},
+		{name: "plain array", inventory: &fakeInventory{devices: 3, array: true}, pageSize: 5, wantDevices: 3, wantRequests: 1},
+		// Plain arrays are paged in rounds like pages without a count: offsets 0, then 5, 10, 15
+		{name: "plain array pages", inventory: &fakeInventory{devices: 12, array: true}, pageSize: 5, wantDevices: 12, wantRequests: 4},
+		{name: "plain array of full pages", inventory: &fakeInventory{devices: 10, array: true}, pageSize: 5, wantDevices: 10, wantRequests: 4},
+		// Rounds of 3 pages until one is short: offsets 0, then 5, 10, 15, then 20, 25, 30
+		{name: "pages without a count", inventory: &fakeInventory{devices: 23, withoutCount: true}, pageSize: 5, wantDevices: 23, wantRequests: 7},
+		{name: "server caps the page size", inventory: &fakeInventory{devices: 23, maxPageSize: 4}, pageSize: 10, wantDevices: 23, wantRequests: 6},
+		{name: "devices enrolled during the download", inventory: &fakeInventory{devices: 10, enrolled: 2}, pageSize: 5, wantDevices: 12, wantRequests: 3},
+		{name: "a page fails", inventory: &fakeInventory{devices: 23, failOffset: 10}, pageSize: 5, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.inventory)
+			defer srv.Close()
+			c, err := NewClient(config.KandjiConfig{
+				ApiURL:     "https://example.api.kandji.io",
+				ApiToken:   "token",
+				Pagination: config.KandjiPaginationConfig{PageSize: tt.pageSize, Concurrency: 3},
+			}, nil, WithBaseURL(srv.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			devices, err := c.fetchDevicesParallel(context.Background())
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("fetchDevicesParallel() succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("fetchDevicesParallel() error = %v", err)
+			}
+			if len(devices) != tt.wantDevices {
+				t.Errorf("fetchDevicesParallel() returned %d devices, want %d", len(devices), tt.wantDevices)
+			}
+			for i, device := range devices {
+				if want := fmt.Sprintf("SN%04d", i); device.SerialNumber != want {
+					t.Fatalf("device %d = %s, want %s in inventory order", i, device.SerialNumber, want)
+				}
+			}
+			if len(tt.inventory.requests) != tt.wantRequests {
+				t.Errorf("fetchDevicesParallel() sent %d requests %q, want %d", len(tt.inventory.requests), tt.inventory.requests, tt.wantRequests)
+			}
+		})
+	}
+}
+
+// TestFetchDevicesPlainArrayPages downloads 2.5 pages of plain arrays, the way Kandji serves
+// /api/v1/devices, one page after another and in parallel.
+func TestFetchDevicesPlainArrayPages(t *testing.T) {
+	for _, concurrency := range []int{1, 3} {
+		t.Run(fmt.Sprintf("concurrency %d", concurrency), func(t *testing.T) {
+			inventory := &fakeInventory{devices: 750, array: true}
+			srv := httptest.NewServer(inventory)
+			defer srv.Close()
+			c, err := NewClient(config.KandjiConfig{
+				ApiURL:     "https://example.api.kandji.io",
+				ApiToken:   "token",
+				Pagination: config.KandjiPaginationConfig{Concurrency: concurrency},
+			}, nil, WithBaseURL(srv.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			devices, err := c.fetchDevices(context.Background())
+			if err != nil {
+				t.Fatalf("fetchDevices() error = %v", err)
+			}
+			if len(devices) != 750 {
+				t.Fatalf("fetchDevices() returned %d devices, want 750", len(devices))
+			}
+			for i, device := range devices {
+				if want := fmt.Sprintf("SN%04d", i); device.SerialNumber != want {
+					t.Fatalf("device %d = %s, want %s in inventory order", i, device.SerialNumber, want)
+				}
+			}
+			for _, query := range inventory.requests {
+				if limit := strings.Split(query, "&")[0]; limit != "limit=300" {
+					t.Errorf("request %q, want limit=300", query)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package leader
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// serviceAccountDir is where Kubernetes mounts the credentials of the pod's service account.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// microTimeLayout is the format of the MicroTime fields of a Lease.
+const microTimeLayout = "2006-01-02T15:04:05.000000Z07:00"
+
+var (
+	errLeaseNotFound = errors.New("lease not found")
+	errLeaseConflict = errors.New("lease was changed concurrently")
+)
+
+// KubernetesLease is a Lock backed by a coordination.k8s.io/v1 Lease, the object the
+// Kubernetes controllers elect their leaders with. It talks to the API server of the cluster
+// the service runs in with the credentials of the pod's service account, which needs the get,
+// create and update verbs on leases in the namespace.
+type KubernetesLease struct {
+	baseURL   string
+	namespace string
+	name      string
+	tokenPath string
+	client    *http.Client
+
+	mu sync.Mutex
+	// observed is the holder and renew time last seen in a lease held by another replica,
+	// and observedAt when it was first seen. The lease is expired once it has not changed
+	// for its duration, measured by the local clock, so the clocks of the replicas need not
+	// agree.
+	observed   string
+	observedAt time.Time
+}
+
+// lease is a coordination.k8s.io/v1 Lease. The metadata is sent back as read, keeping the
+// resourceVersion that makes an update fail if another replica updated the lease meanwhile.
+type lease struct {
+	APIVersion string         `json:"apiVersion"`
+	Kind       string         `json:"kind"`
+	Metadata   map[string]any `json:"metadata"`
+	Spec       leaseSpec      `json:"spec"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int    `json:"leaseTransitions,omitempty"`
+}
+
+// NewKubernetesLease returns a lock on the Lease name in namespace, or in the pod's namespace
+// if namespace is empty. It must run in a pod of the cluster.
+func NewKubernetesLease(namespace, name string) (*KubernetesLease, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes pod: KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+	if namespace == "" {
+		data, err := os.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the pod's namespace, set leader_election.kubernetes.namespace: %w", err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+	caCert, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the cluster's CA certificate: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("no certificates found in %s", filepath.Join(serviceAccountDir, "ca.crt"))
+	}
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+		},
+	}
+	return newKubernetesLease("https://"+net.JoinHostPort(host, port), filepath.Join(serviceAccountDir, "token"), client, namespace, name), nil
+}
+
+func newKubernetesLease(baseURL, tokenPath string, client *http.Client, namespace, name string) *KubernetesLease {
+	return &KubernetesLease{
+		baseURL:   baseURL,
+		namespace: namespace,
+		name:      name,
+		tokenPath: tokenPath,
+		client:    client,
+	}
+}
+
+// Acquire implements Lock. It creates the lease if it does not exist, and takes it over if it
+// has no holder or was not renewed for its duration.
+func (l *KubernetesLease) Acquire(ctx context.Context, identity string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	durationSeconds := max(int(ttl.Round(time.Second)/time.Second), 1)
+
+	current, err := l.get(ctx)
+	if errors.Is(err, errLeaseNotFound) {
+		created := lease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   map[string]any{"name": l.name, "namespace": l.namespace},
+			Spec: leaseSpec{
+				HolderIdentity:       identity,
+				LeaseDurationSeconds: durationSeconds,
+				AcquireTime:          now.UTC().Format(microTimeLayout),
+				RenewTime:            now.UTC().Format(microTimeLayout),
+			},
+		}
+		err = l.send(ctx, http.MethodPost, l.collectionPath(), created)
+		if errors.Is(err, errLeaseConflict) {
+			// Another replica created it first
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	spec := current.Spec
+	if spec.HolderIdentity != identity {
+		if spec.HolderIdentity != "" && !l.expired(spec, now) {
+			return false, nil
+		}
+		spec.AcquireTime = now.UTC().Format(microTimeLayout)
+		spec.LeaseTransitions++
+	}
+	spec.HolderIdentity = identity
+	spec.LeaseDurationSeconds = durationSeconds
+	spec.RenewTime = now.UTC().Format(microTimeLayout)
+	current.Spec = spec
+
+	err = l.send(ctx, http.MethodPut, l.leasePath(), *current)
+	if errors.Is(err, errLeaseConflict) {
+		// Another replica renewed or took over the lease since it was read
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// expired reports whether a lease held by another replica has gone unrenewed for its
+// duration.
+func (l *KubernetesLease) expired(spec leaseSpec, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if observed := spec.HolderIdentity + " " + spec.RenewTime; observed != l.observed {
+		l.observed, l.observedAt = observed, now
+	}
+	return now.Sub(l.observedAt) > time.Duration(spec.LeaseDurationSeconds)*time.Second
+}
+
+// Release implements Lock. It clears the holder of the lease, as the Kubernetes controllers
+// do when they step down.
+func (l *KubernetesLease) Release(ctx context.Context, identity string) error {
+	current, err := l.get(ctx)
+	if errors.Is(err, errLeaseNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if current.Spec.HolderIdentity != identity {
+		return nil
+	}
+	current.Spec.HolderIdentity = ""
+	current.Spec.LeaseDurationSeconds = 1
+	current.Spec.RenewTime = time.Now().UTC().Format(microTimeLayout)
+	return l.send(ctx, http.MethodPut, l.leasePath(), *current)
+}
+
+func (l *KubernetesLease) collectionPath() string {
+	return "/apis/coordination.k8s.io/v1/namespaces/" + url.PathEscape(l.namespace) + "/leases"
+}
+
+func (l *KubernetesLease) leasePath() string {
+	return l.collectionPath() + "/" + url.PathEscape(l.name)
+}
+
+// get reads the lease.
+func (l *KubernetesLease) get(ctx context.Context) (*lease, error) {
+	resp, err := l.do(ctx, http.MethodGet, l.leasePath(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkLeaseResponse(resp); err != nil {
+		return nil, err
+	}
+	var current lease
+	if err := json.NewDecoder(resp.Body).Decode(&current); err != nil {
+		return nil, fmt.Errorf("failed to decode lease: %w", err)
+	}
+	return &current, nil
+}
+
+// send creates or updates the lease.
+func (l *KubernetesLease) send(ctx context.Context, method, path string, body lease) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode lease: %w", err)
+	}
+	resp, err := l.do(ctx, method, path, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkLeaseResponse(resp)
+}
+
+// do sends an API request with the service account token, which is read for every request
+// because the kubelet rotates it.
+func (l *KubernetesLease) do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	token, err := os.ReadFile(l.tokenPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account token: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, l.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("lease request failed: %w", err)
+	}
+	return resp, nil
+}
+
+// checkLeaseResponse maps the status of an API response to an error: HTTP 404 to
+// errLeaseNotFound, 409 to errLeaseConflict.
+func checkLeaseResponse(resp *http.Response) error {
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return errLeaseNotFound
+	case resp.StatusCode == http.StatusConflict:
+		return errLeaseConflict
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("HTTP %d - %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+// Package leader elects one of several replicas of the service as the leader, the only one
+// running sync cycles. Leadership is a lease in a lock backend that the leader renews; if it
+// stops renewing, e.g. because it died, another replica takes over once the lease expires.
+package leader
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// releaseTimeout bounds releasing the lease on shutdown.
+const releaseTimeout = 5 * time.Second
+
+// Lock is a lease that at most one identity holds at a time.
+type Lock interface {
+	// Acquire takes the lease for identity for ttl if it is free or expired, extends it if
+	// identity already holds it, and reports whether identity holds it
+	Acquire(ctx context.Context, identity string, ttl time.Duration) (bool, error)
+	// Release frees the lease if identity holds it, so another replica takes over without
+	// waiting for it to expire
+	Release(ctx context.Context, identity string) error
+}
+
+// Options configures an Elector.
+type Options struct {
+	// Identity names the replica in the lease
+	Identity string
+	// LeaseDuration is how long the lease lasts without being renewed
+	LeaseDuration time.Duration
+	// RenewInterval is how often the leader renews the lease and the other replicas try to
+	// acquire it
+	RenewInterval time.Duration
+	Logger        *slog.Logger
+}
+
+// Elector acquires and renews the lease of a Lock. The replica is the leader while its last
+// successful renewal is recent enough: it steps down one renew interval before the lease
+// expires in the backend, so it has stopped leading by the time another replica can take
+// over, even if the backend cannot be reached.
+type Elector struct {
+	lock Lock
+	opts Options
+
+	mu sync.Mutex
+	// leadingUntil is when the replica stops leading without another renewal
+	leadingUntil time.Time
+	// done is closed once Start's background work has stopped and released the lease
+	done chan struct{}
+}
+
+// New returns an elector for lock.
+func New(lock Lock, opts Options) *Elector {
+	return &Elector{lock: lock, opts: opts, done: make(chan struct{})}
+}
+
+// DefaultIdentity returns the hostname, which is the pod name on Kubernetes, with a random
+// suffix that tells apart replicas sharing a hostname.
+func DefaultIdentity() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "kandji-cloudflare-device-sync"
+	}
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return hostname + "_" + hex.EncodeToString(suffix)
+}
+
+// Identity returns the identity the elector holds the lease as.
+func (e *Elector) Identity() string {
+	return e.opts.Identity
+}
+
+// IsLeader reports whether the replica is the leader.
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return time.Now().Before(e.leadingUntil)
+}
+
+// Start makes a first attempt to acquire the lease, then keeps trying, or renews the lease
+// while leading, every renew interval in the background until ctx is done, when it releases
+// the lease if held. onChange is called from the background when the replica becomes the
+// leader or stops being the leader after the first attempt, but not once ctx is done.
+func (e *Elector) Start(ctx context.Context, onChange func(leading bool)) {
+	e.renew(ctx)
+	leading := e.IsLeader()
+	if leading {
+		e.opts.Logger.Info("Became the leader, running sync cycles", "identity", e.opts.Identity)
+	} else {
+		e.opts.Logger.Info("Another replica is the leader, waiting to take over", "identity", e.opts.Identity)
+	}
+
+	go func() {
+		defer close(e.done)
+		ticker := time.NewTicker(e.opts.RenewInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				if leading {
+					e.release()
+				}
+				return
+			}
+			e.renew(ctx)
+			if now := e.IsLeader(); now != leading && ctx.Err() == nil {
+				leading = now
+				if leading {
+					e.opts.Logger.Info("Became the leader, running sync cycles", "identity", e.opts.Identity)
+				} else {
+					e.opts.Logger.Warn("Lost leadership, no longer running sync cycles", "identity", e.opts.Identity)
+				}
+				if onChange != nil {
+					onChange(leading)
+				}
+			}
+		}
+	}()
+}
+
+// Wait blocks until the elector started with Start has stopped after its context was done,
+// including releasing the lease.
+func (e *Elector) Wait() {
+	<-e.done
+}
+
+// renew makes one attempt to acquire or renew the lease.
+func (e *Elector) renew(ctx context.Context) {
+	start := time.Now()
+	attemptCtx, cancel := context.WithTimeout(ctx, e.opts.RenewInterval)
+	held, err := e.lock.Acquire(attemptCtx, e.opts.Identity, e.opts.LeaseDuration)
+	cancel()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	switch {
+	case err != nil:
+		if ctx.Err() != nil {
+			return
+		}
+		// A leader keeps leading until its lease runs out, the backend may be back by then
+		e.opts.Logger.Warn("Failed to acquire or renew the leader lease", "identity", e.opts.Identity, "error", err)
+	case held:
+		// The lease runs from no earlier than the start of the request
+		e.leadingUntil = start.Add(e.opts.LeaseDuration - e.opts.RenewInterval)
+	default:
+		e.leadingUntil = time.Time{}
+	}
+}
+
+// release gives up the lease on shutdown, so another replica does not wait for it to expire.
+func (e *Elector) release() {
+	e.mu.Lock()
+	e.leadingUntil = time.Time{}
+	e.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+	if err := e.lock.Release(ctx, e.opts.Identity); err != nil {
+		e.opts.Logger.Warn("Failed to release the leader lease, another replica takes over once it expires", "identity", e.opts.Identity, "error", err)
+		return
+	}
+	e.opts.Logger.Info("Released the leader lease", "identity", e.opts.Identity)
+}
@@ -0,0 +1,191 @@
+package leader
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisTimeout bounds a Redis command whose context has no deadline.
+const redisTimeout = 10 * time.Second
+
+// acquireScript takes the key if it is not set, or extends it if it holds the identity. The
+// key expires with the lease, so a leader that stops renewing loses it.
+const acquireScript = `local holder = redis.call('GET', KEYS[1])
+if holder == ARGV[1] then
+  redis.call('PEXPIRE', KEYS[1], ARGV[2])
+  return 1
+end
+if not holder then
+  redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+  return 1
+end
+return 0`
+
+// releaseScript deletes the key if it holds the identity.
+const releaseScript = `if redis.call('GET', KEYS[1]) == ARGV[1] then
+  return redis.call('DEL', KEYS[1])
+end
+return 0`
+
+// RedisOptions configures a RedisLock.
+type RedisOptions struct {
+	// URL is redis://host:port/db, or rediss://host:port/db to require TLS
+	URL string
+	// Key is the key holding the identity of the leader
+	Key string
+	// Username and Password authenticate with AUTH if Password is set; Username is for
+	// Redis 6 ACL users
+	Username string
+	Password string
+}
+
+// RedisLock is a Lock backed by a Redis key holding the identity of the leader, which expires
+// with the lease. The key is only read and changed by Lua scripts, so checking the holder and
+// changing the key is atomic. It needs a single Redis server, or the primary of a replicated
+// setup; a failover that loses the key lets another replica take over early.
+type RedisLock struct {
+	address string
+	db      int
+	tls     *tls.Config
+	opts    RedisOptions
+}
+
+// NewRedisLock returns a lock on the key of opts.
+func NewRedisLock(opts RedisOptions) (*RedisLock, error) {
+	u, err := url.Parse(opts.URL)
+	if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+		return nil, fmt.Errorf("invalid Redis URL %q", opts.URL)
+	}
+	l := &RedisLock{address: u.Host, opts: opts}
+	if u.Port() == "" {
+		l.address = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if l.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid Redis database %q", db)
+		}
+	}
+	if u.Scheme == "rediss" {
+		l.tls = &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+	}
+	return l, nil
+}
+
+// Acquire implements Lock.
+func (l *RedisLock) Acquire(ctx context.Context, identity string, ttl time.Duration) (bool, error) {
+	reply, err := l.eval(ctx, acquireScript, identity, strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err != nil {
+		return false, err
+	}
+	return reply == 1, nil
+}
+
+// Release implements Lock.
+func (l *RedisLock) Release(ctx context.Context, identity string) error {
+	_, err := l.eval(ctx, releaseScript, identity)
+	return err
+}
+
+// eval runs a script on the key with args on a new connection and returns its integer reply.
+// A lock is only used every renew interval, which does not warrant keeping a connection.
+func (l *RedisLock) eval(ctx context.Context, script string, args ...string) (int64, error) {
+	var conn net.Conn
+	var err error
+	if l.tls != nil {
+		dialer := &tls.Dialer{Config: l.tls}
+		conn, err = dialer.DialContext(ctx, "tcp", l.address)
+	} else {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", l.address)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect to Redis at %s: %w", l.address, err)
+	}
+	defer conn.Close()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(redisTimeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return 0, fmt.Errorf("failed to set Redis deadline: %w", err)
+	}
+
+	r := bufio.NewReader(conn)
+	if l.opts.Password != "" {
+		auth := []string{"AUTH", l.opts.Password}
+		if l.opts.Username != "" {
+			auth = []string{"AUTH", l.opts.Username, l.opts.Password}
+		}
+		if _, err := redisCommand(conn, r, auth...); err != nil {
+			return 0, fmt.Errorf("failed to authenticate with Redis: %w", err)
+		}
+	}
+	if l.db != 0 {
+		if _, err := redisCommand(conn, r, "SELECT", strconv.Itoa(l.db)); err != nil {
+			return 0, fmt.Errorf("failed to select Redis database %d: %w", l.db, err)
+		}
+	}
+	reply, err := redisCommand(conn, r, append([]string{"EVAL", script, "1", l.opts.Key}, args...)...)
+	if err != nil {
+		return 0, fmt.Errorf("redis EVAL failed: %w", err)
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected Redis reply %v", reply)
+	}
+	return n, nil
+}
+
+// redisCommand sends a command in the RESP protocol and reads its reply: a string for simple
+// and bulk strings, int64 for integers, nil for a null reply. Error replies are returned as
+// errors.
+func redisCommand(w io.Writer, r *bufio.Reader, args ...string) (any, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return nil, err
+	}
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty Redis reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errors.New(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid Redis bulk string length %q", line[1:])
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	}
+	return nil, fmt.Errorf("unsupported Redis reply %q", line)
+}
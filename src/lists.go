@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"kandji-cloudflare-device-sync/cloudflare"
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/internal/httpclient"
+	"kandji-cloudflare-device-sync/internal/ratelimit"
+)
+
+// runLists prints every list of the configured kind in the Cloudflare account, so operators
+// can find the list IDs and names to configure without opening the dashboard.
+func runLists(args []string) int {
+	cfg, err := config.ParseCloudflareConfigArgs(newFlagSet("lists"), args)
+	if err != nil {
+		slog.Error("Failed to load configuration", "error", err)
+		return exitConfig
+	}
+
+	log := slog.New(slog.NewJSONHandler(os.Stderr, nil))
+	rateLimiter := ratelimit.New(ratelimit.Config{
+		KandjiRequestsPerSecond:     cfg.RateLimits.KandjiRequestsPerSecond,
+		CloudflareRequestsPerSecond: cfg.RateLimits.CloudflareRequestsPerSecond,
+		BurstCapacity:               cfg.RateLimits.BurstCapacity,
+	})
+	userAgent := httpclient.UserAgent(Version, cfg.Client.InstanceID, cfg.Client.UserAgentSuffix)
+	cloudflareClient, err := cloudflare.NewClient(cfg.Cloudflare, rateLimiter, log, cloudflare.WithUserAgent(userAgent), cloudflare.WithNetwork(cfg.Network))
+	if err != nil {
+		log.Error("Failed to create Cloudflare client", "error", err)
+		return exitConfig
+	}
+
+	lists, err := cloudflareClient.ListLists(context.Background())
+	if err != nil {
+		log.Error("Failed to list Cloudflare lists", "error", err)
+		return apiExitCode(err, exitFailure)
+	}
+	sort.Slice(lists, func(i, j int) bool {
+		if lists[i].Type != lists[j].Type {
+			return lists[i].Type < lists[j].Type
+		}
+		return lists[i].Name < lists[j].Name
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tTYPE\tITEMS\tDESCRIPTION")
+	for _, list := range lists {
+		description := strings.Join(strings.Fields(list.Description), " ")
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", list.ID, list.Name, list.Type, list.Count, description)
+	}
+	if err := w.Flush(); err != nil {
+		log.Error("Failed to write list table", "error", err)
+		return exitFailure
+	}
+	return exitOK
+}
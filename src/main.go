@@ -2,16 +2,30 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
+	"kandji-cloudflare-device-sync/admin"
+	"kandji-cloudflare-device-sync/audit"
 	"kandji-cloudflare-device-sync/cloudflare"
 	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/digest"
+	"kandji-cloudflare-device-sync/engine"
+	"kandji-cloudflare-device-sync/events"
+	"kandji-cloudflare-device-sync/internal/httpclient"
+	"kandji-cloudflare-device-sync/internal/mail"
 	"kandji-cloudflare-device-sync/internal/ratelimit"
+	"kandji-cloudflare-device-sync/internal/sdnotify"
+	"kandji-cloudflare-device-sync/internal/updatecheck"
 	"kandji-cloudflare-device-sync/kandji"
+	"kandji-cloudflare-device-sync/leader"
+	"kandji-cloudflare-device-sync/metrics"
+	"kandji-cloudflare-device-sync/mosyle"
+	"kandji-cloudflare-device-sync/state"
 	"kandji-cloudflare-device-sync/syncer"
 )
 
@@ -23,35 +37,40 @@ var (
 )
 
 func main() {
-	showVersion := false
-	for _, arg := range os.Args[1:] {
-		if arg == "-version" || arg == "--version" {
-			showVersion = true
-			break
-		}
-	}
-	if showVersion {
-		fmt.Printf("%s, %s, %s, %s\n", Version, Commit, CommitDate, TreeState)
-		os.Exit(0)
-	}
+	os.Exit(runCommand(os.Args[1:]))
+}
 
-	cfg, err := config.ParseConfig()
+// runSync runs the sync service, or a single cycle with -once.
+func runSync(args []string) int {
+	fs := newFlagSet("sync")
+	once := fs.Bool("once", false, "Run a single sync cycle and exit with a status code reflecting its outcome")
+	fs.StringVar(&errorFormat, "error-format", "text", "Format of startup and one-shot failures: text, or json for a structured error on stderr")
+	cfg, err := config.ParseConfigArgs(fs, args)
 	if err != nil {
-		slog.Error("Failed to load configuration", "error", err)
-		os.Exit(1)
+		fail(slog.Default(), exitConfig, "Failed to load configuration", "error", err)
 	}
 
 	// Setup structured logging with configured level
 	var logLevel slog.Level
 	err = logLevel.UnmarshalText([]byte(cfg.Log.Level))
 	if err != nil {
-		slog.Error("Invalid log level", "level", cfg.Log.Level, "error", err)
-		os.Exit(1)
+		fail(slog.Default(), exitConfig, "Invalid log level", "level", cfg.Log.Level, "error", err)
 	}
 
-	log := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+	var logHandler slog.Handler = slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
 		Level: logLevel,
-	}))
+	})
+	// Keep recent warnings and errors for the admin API's /events
+	var eventBuffer *events.Buffer
+	if cfg.Admin.ListenAddress != "" {
+		eventBuffer = events.NewBuffer(cfg.Admin.EventBufferSize)
+		logHandler = events.NewHandler(logHandler, eventBuffer, slog.LevelWarn)
+	}
+	log := slog.New(logHandler)
+
+	for _, warning := range cfg.Lint(expectedCycleDuration(cfg)) {
+		log.Warn("Configuration warning", "warning", warning)
+	}
 
 	// Create rate limiter
 	rateLimiter := ratelimit.New(ratelimit.Config{
@@ -60,28 +79,119 @@ func main() {
 		BurstCapacity:               cfg.RateLimits.BurstCapacity,
 	})
 
-	// Create clients for Kandji and Cloudflare
-	kandjiClient, err := kandji.NewClient(cfg.Kandji, rateLimiter)
-	if err != nil {
-		log.Error("Failed to create Kandji client", "error", err)
-		os.Exit(1)
+	// Options for the Kandji and Cloudflare clients beyond the user agent and network settings
+	userAgent := httpclient.UserAgent(Version, cfg.Client.InstanceID, cfg.Client.UserAgentSuffix)
+	var kandjiOptions []kandji.Option
+	var cloudflareOptions []cloudflare.Option
+
+	if cfg.Sandbox.Enabled {
+		apis, err := startSandbox(cfg, log)
+		if err != nil {
+			fail(log, exitConfig, "Failed to start sandbox", "error", err)
+		}
+		defer apis.Close()
+		kandjiOptions = append(kandjiOptions, kandji.WithBaseURL(apis.KandjiURL()))
+		cloudflareOptions = append(cloudflareOptions, cloudflare.WithBaseURL(apis.CloudflareURL()))
+	}
+
+	var registry *metrics.Registry
+	if cfg.Metrics.ListenAddress != "" || cfg.Metrics.StatsD.Enabled {
+		registry = metrics.NewRegistry()
+		responses := newAPIResponseCounter(registry)
+		kandjiOptions = append(kandjiOptions, kandji.WithObserver(responses.observer("kandji")))
+		cloudflareOptions = append(cloudflareOptions,
+			cloudflare.WithObserver(responses.observer("cloudflare")),
+			cloudflare.WithQuotaObserver(newCloudflareQuotaGauges(registry).observe))
+	}
+	var statsd *metrics.StatsD
+	if cfg.Metrics.StatsD.Enabled {
+		statsd, err = metrics.NewStatsD(metrics.StatsDOptions{
+			Address:       cfg.Metrics.StatsD.Address,
+			Prefix:        cfg.Metrics.StatsD.Prefix,
+			Format:        cfg.Metrics.StatsD.Format,
+			Tags:          cfg.Metrics.StatsD.Tags,
+			FlushInterval: cfg.Metrics.StatsD.FlushInterval,
+		})
+		if err != nil {
+			fail(log, exitConfig, "Failed to set up StatsD metrics", "error", err)
+		}
+		registry.AddSink(statsd)
+		// Send the metrics recorded last, e.g. of a single run, before exiting
+		defer statsd.Close()
+	}
+
+	if cfg.Audit.Path != "" {
+		auditLog, err := audit.Open(cfg.Audit.Path, log)
+		if err != nil {
+			fail(log, exitFailure, "Failed to open audit log", "path", cfg.Audit.Path, "error", err)
+		}
+		defer auditLog.Close()
+		cloudflareOptions = append(cloudflareOptions, cloudflare.WithMutationObserver(auditLog.Record))
+	}
+
+	var mosyleOptions []mosyle.Option
+	if len(cfg.Jobs) > 0 {
+		// The jobs share one Kandji client, the most frequent sync downloads the inventory for all
+		kandjiOptions = append(kandjiOptions, kandji.WithInventoryCache(inventoryMaxAge(cfg)))
+		mosyleOptions = append(mosyleOptions, mosyle.WithInventoryCache(inventoryMaxAge(cfg)))
+	}
+
+	var sharedSyncerOptions []syncer.Option
+	if eventBuffer != nil {
+		sharedSyncerOptions = append(sharedSyncerOptions, syncer.WithEvents(eventBuffer))
 	}
 
-	cloudflareClient, err := cloudflare.NewClient(cfg.Cloudflare, rateLimiter, log)
+	// Only the elected leader runs the scheduled cycles; runs with -once are not coordinated
+	var elector *leader.Elector
+	if cfg.LeaderElection.Enabled && !*once {
+		elector, err = newElector(cfg.LeaderElection, log)
+		if err != nil {
+			fail(log, exitConfig, "Failed to set up leader election", "error", err)
+		}
+		sharedSyncerOptions = append(sharedSyncerOptions, syncer.WithLeaderElection(elector.IsLeader))
+	}
+
+	store, stateCloudflareOptions, stateSyncerOptions, err := openState(cfg, log)
 	if err != nil {
-		log.Error("Failed to create Cloudflare client", "error", err)
-		os.Exit(1)
+		fail(log, exitFailure, "Failed to open state store", "path", cfg.State.Path, "error", err)
+	}
+	syncerOptions := append(append([]syncer.Option(nil), sharedSyncerOptions...), stateSyncerOptions...)
+
+	if registry != nil {
+		syncerOptions = append(syncerOptions, syncer.WithMetrics(registry))
 	}
 
-	// Validate that the Cloudflare list exists
-	if err := cloudflareClient.ValidateListExists(context.Background()); err != nil {
-		log.Error("Failed to validate Cloudflare list! This likely means you don't have access to the list or the list ID is wrong.", "error", err)
-		os.Exit(1)
+	// Create the clients, check the configured lists and create the syncer
+	engineOptions := engine.Options{
+		Config:            cfg,
+		Logger:            log,
+		UserAgent:         userAgent,
+		RateLimiter:       rateLimiter,
+		KandjiOptions:     kandjiOptions,
+		MosyleOptions:     mosyleOptions,
+		CloudflareOptions: cloudflareOptions,
+	}
+	topOptions := engineOptions
+	topOptions.CloudflareOptions = append(append([]cloudflare.Option(nil), cloudflareOptions...), stateCloudflareOptions...)
+	topOptions.SyncerOptions = syncerOptions
+	if store != nil {
+		topOptions.CreatedLists = store
+	}
+	syncEngine, err := engine.New(context.Background(), topOptions)
+	if err != nil {
+		failSetup(log, err)
+	}
+	syncService := syncEngine.Syncer()
+	jobs := []*syncJob{{cfg: cfg, engine: syncEngine, syncer: syncService, log: log}}
+	extraJobs, err := setupJobs(context.Background(), cfg, log, syncEngine, engineOptions, sharedSyncerOptions)
+	if err != nil {
+		failSetup(log, err)
 	}
+	jobs = append(jobs, extraJobs...)
 
 	// Debug: List devices already in the target Cloudflare list
-	if logLevel == slog.LevelDebug {
-		targetSerials, err := cloudflareClient.GetListItems(context.Background())
+	if logLevel == slog.LevelDebug && !cfg.Cloudflare.EmailOnly {
+		targetSerials, err := syncEngine.CloudflareClient().GetListItems(context.Background())
 		if err != nil {
 			log.Error("Failed to fetch devices from target Cloudflare list", "error", err)
 		} else {
@@ -89,9 +199,6 @@ func main() {
 		}
 	}
 
-	// Create and start the syncer
-	syncService := syncer.New(kandjiClient, cloudflareClient, cfg, log)
-
 	// Set up context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -100,14 +207,183 @@ func main() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	// Notifies systemd if it started the service with Type=notify, nil otherwise
+	notifier := sdnotify.FromEnv()
+
 	go func() {
 		<-sigChan
-		log.Info("Shutdown signal received, stopping service...")
+		log.Info("Shutdown signal received, stopping service...", "drain_timeout", cfg.Shutdown.DrainTimeout.String())
+		if err := notifier.Notify(sdnotify.Stopping); err != nil {
+			log.Warn("Failed to notify systemd", "error", err)
+		}
+		if eventBuffer != nil {
+			eventBuffer.Record(events.TypeShutdown, "Shutdown signal received")
+		}
 		cancel()
 	}()
 
-	// Start the main sync loop
+	if *once {
+		// Every job runs once; the exit code reflects the worst outcome
+		code, message, details := exitOK, "", []any(nil)
+		// A shutdown lets the cycle in progress finish within the drain timeout
+		cycleCtx, cancelCycles := syncer.DrainContext(ctx, cfg.Shutdown.DrainTimeout)
+		defer cancelCycles()
+		for _, job := range jobs {
+			if ctx.Err() != nil {
+				code, message, details = exitSyncFailed, "Sync cycle not run, the service is shutting down", job.attrs
+				break
+			}
+			report, err := job.syncer.RunOnce(cycleCtx)
+			saveWarmCache(job.cfg, job.syncer, job.log)
+			switch {
+			case err != nil:
+				code, message, details = exitSyncFailed, "Sync cycle failed", append(job.attrs, "error", err)
+			case len(report.FailedToAdd) > 0 && code == exitOK:
+				code, message, details = exitPartial, "Sync cycle completed with failures", append(job.attrs, "failed_to_add", len(report.FailedToAdd))
+			}
+		}
+		if code != exitOK {
+			fail(log, code, message, details...)
+		}
+		return exitOK
+	}
+
+	var adminServer *admin.Server
+	if cfg.Admin.ListenAddress != "" {
+		adminOptions := []admin.Option{admin.WithEvents(eventBuffer), admin.WithToken(cfg.Admin.Token), admin.WithRateLimits(rateLimiter)}
+		if cfg.Webhook.Enabled {
+			adminOptions = append(adminOptions, admin.WithWebhook(cfg.Webhook, syncService.Trigger))
+		}
+		for _, job := range jobs {
+			adminOptions = append(adminOptions, admin.WithSyncs(admin.SyncLoop{Job: job.name, Syncer: job.syncer}))
+		}
+		adminServer = admin.New(cfg.Admin.ListenAddress, adminOptions...)
+		go serve(ctx, log, "admin API", adminServer)
+	}
+	if statsd != nil {
+		go statsd.Run(ctx)
+	}
+	if registry != nil && cfg.Metrics.ListenAddress != "" {
+		// Share the admin API's listener if both are configured on the same address
+		if adminServer != nil && cfg.Metrics.ListenAddress == cfg.Admin.ListenAddress {
+			adminServer.Handle(cfg.Metrics.Path, registry.Handler())
+		} else {
+			metricsServer := admin.New(cfg.Metrics.ListenAddress)
+			metricsServer.Handle(cfg.Metrics.Path, registry.Handler())
+			go serve(ctx, log, "metrics", metricsServer)
+		}
+	}
+	if eventBuffer != nil {
+		eventBuffer.Record(events.TypeStartup, "Service started", "version", Version)
+	}
+
+	// Reload the configuration on SIGHUP and, if configured, when the file changes
+	configReloader := &reloader{args: args, jobs: jobs, log: log, events: eventBuffer, cfg: cfg}
+	go configReloader.run(ctx)
+
+	if cfg.Digest.Enabled {
+		dailyDigest, err := digest.New(cfg.Digest, store, mail.New(cfg.SMTP), log)
+		if err != nil {
+			fail(log, exitConfig, "Failed to create daily digest", "error", err)
+		}
+		go dailyDigest.Run(ctx)
+	}
+
+	if cfg.UpdateCheck.Enabled {
+		httpClient, err := httpclient.New(httpclient.Options{
+			Timeout:    30 * time.Second,
+			UserAgent:  userAgent,
+			DNSServers: cfg.Network.DNSServers,
+			Hosts:      cfg.Network.Hosts,
+		})
+		if err != nil {
+			fail(log, exitConfig, "Failed to create update check client", "error", err)
+		}
+		go updatecheck.New(Version, httpClient, log).Run(ctx, cfg.UpdateCheck.Interval)
+	}
+
+	if elector != nil {
+		var gauge *leaderGauge
+		if registry != nil {
+			gauge = newLeaderGauge(registry)
+		}
+		// The lease is held until the sync loops have drained, so another replica does not
+		// start a cycle while the last one here finishes
+		electionCtx, stopElection := context.WithCancel(context.Background())
+		defer func() {
+			stopElection()
+			elector.Wait()
+		}()
+		elector.Start(electionCtx, func(leading bool) {
+			if gauge != nil {
+				gauge.set(leading)
+			}
+			if eventBuffer != nil {
+				eventBuffer.Record(events.TypeLeaderChanged, "Leadership changed", "identity", elector.Identity(), "leader", leading)
+			}
+			if leading {
+				// Take over at once rather than at the next scheduled run
+				for _, job := range jobs {
+					job.syncer.Trigger("became leader")
+				}
+			}
+		})
+		if gauge != nil {
+			gauge.set(elector.IsLeader())
+		}
+	}
+
+	// Startup checks have passed, the sync loops start now
+	if err := notifier.Notify(sdnotify.Ready, "STATUS=Syncing every "+cfg.SyncInterval.String()); err != nil {
+		log.Warn("Failed to notify systemd", "error", err)
+	}
+	if timeout := sdnotify.WatchdogInterval(); notifier != nil && timeout > 0 {
+		go runWatchdog(ctx, notifier, timeout, cfg, jobs, log)
+	}
+
+	// Start the sync loops, the top-level one in this goroutine
+	var wg sync.WaitGroup
+	for _, job := range jobs[1:] {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			job.syncer.Run(ctx, job.cfg.SyncInterval)
+			saveWarmCache(job.cfg, job.syncer, job.log)
+		}()
+	}
 	syncService.Run(ctx, cfg.SyncInterval)
+	saveWarmCache(cfg, syncService, log)
+	wg.Wait()
 
 	log.Info("Service has shut down gracefully.")
+	return exitOK
+}
+
+// saveWarmCache writes the target list as left by the last cycle to the warm cache, if one
+// is configured. Without a known state any previous cache is removed, so a restart does not
+// pick up contents that no longer match the list.
+func saveWarmCache(cfg *config.Config, syncService *syncer.Syncer, log *slog.Logger) {
+	if cfg.WarmCache.Path == "" || cfg.DryRun {
+		return
+	}
+	cache := syncService.WarmCache()
+	if cache == nil {
+		if err := os.Remove(cfg.WarmCache.Path); err != nil && !os.IsNotExist(err) {
+			log.Warn("Failed to remove outdated warm cache", "path", cfg.WarmCache.Path, "error", err)
+		}
+		return
+	}
+	if err := state.SaveWarmCache(cfg.WarmCache.Path, cache); err != nil {
+		log.Error("Failed to save warm cache", "path", cfg.WarmCache.Path, "error", err)
+		return
+	}
+	log.Info("Saved warm cache", "path", cfg.WarmCache.Path, "entries", len(cache.TargetItems))
+}
+
+// serve runs an HTTP server until ctx is cancelled, logging if it fails.
+func serve(ctx context.Context, log *slog.Logger, name string, server *admin.Server) {
+	log.Info("Serving "+name, "address", server.Addr())
+	if err := server.Run(ctx); err != nil {
+		log.Error("Failed to serve "+name, "error", err)
+	}
 }
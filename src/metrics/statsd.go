@@ -0,0 +1,164 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// FormatDogStatsD sends labels as DogStatsD tags, as the Datadog agent expects
+	FormatDogStatsD = "dogstatsd"
+	// FormatStatsD appends the label values to the metric name, as plain StatsD has no tags
+	FormatStatsD = "statsd"
+
+	// statsdMaxPacketSize keeps a packet within the MTU of common networks
+	statsdMaxPacketSize = 1432
+)
+
+// StatsDOptions configures a StatsD sink.
+type StatsDOptions struct {
+	// Address is the host:port of the agent's UDP listener, or unix:///path of a DogStatsD
+	// Unix domain socket
+	Address string
+	// Prefix is prepended to every metric name, followed by a dot, if set
+	Prefix string
+	// Format is FormatDogStatsD or FormatStatsD
+	Format string
+	// Tags are sent with every metric in the DogStatsD format, e.g. env:prod
+	Tags []string
+	// FlushInterval is how often buffered metrics are sent, if the buffer does not fill first
+	FlushInterval time.Duration
+}
+
+// StatsD is a Sink sending the metrics of a registry to a StatsD or DogStatsD agent:
+// counters as counts, gauges as gauges, and histograms in seconds as timings in
+// milliseconds, other histograms as histograms. Metrics are buffered into packets and sent
+// when a packet is full and every flush interval while Run runs. Sending is best effort, a
+// packet the agent does not receive is lost.
+type StatsD struct {
+	opts StatsDOptions
+	conn net.Conn
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+// NewStatsD returns a sink sending to the agent at opts.Address.
+func NewStatsD(opts StatsDOptions) (*StatsD, error) {
+	network, address := "udp", opts.Address
+	if path, ok := strings.CutPrefix(address, "unix://"); ok {
+		network, address = "unixgram", path
+	}
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to StatsD agent at %s: %w", opts.Address, err)
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	return &StatsD{opts: opts, conn: conn}, nil
+}
+
+// Record buffers a metric update, sending the buffer first if the update does not fit.
+func (s *StatsD) Record(name, kind string, value float64, labelNames, labelValues []string) {
+	line := s.format(name, kind, value, labelNames, labelValues)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.buf) > 0 && len(s.buf)+1+len(line) > statsdMaxPacketSize {
+		s.flushLocked()
+	}
+	if len(s.buf) > 0 {
+		s.buf = append(s.buf, '\n')
+	}
+	s.buf = append(s.buf, line...)
+}
+
+// format returns the StatsD line of a metric update.
+func (s *StatsD) format(name, kind string, value float64, labelNames, labelValues []string) string {
+	metricType := "c"
+	switch kind {
+	case string(kindGauge):
+		metricType = "g"
+	case string(kindHistogram):
+		metricType = "h"
+		if strings.HasSuffix(name, "_seconds") {
+			metricType, value = "ms", value*1000
+		}
+	}
+
+	if s.opts.Prefix != "" {
+		name = s.opts.Prefix + "." + name
+	}
+	if s.opts.Format == FormatStatsD {
+		for _, labelValue := range labelValues {
+			name += "." + sanitizeStatsD(labelValue, ".:|@#,/ \n")
+		}
+	}
+	line := name + ":" + strconv.FormatFloat(value, 'f', -1, 64) + "|" + metricType
+
+	if s.opts.Format != FormatStatsD {
+		tags := append([]string(nil), s.opts.Tags...)
+		for i, labelName := range labelNames {
+			tags = append(tags, labelName+":"+sanitizeStatsD(labelValues[i], ":|@#,\n"))
+		}
+		if len(tags) > 0 {
+			line += "|#" + strings.Join(tags, ",")
+		}
+	}
+	return line
+}
+
+// Run sends the buffered metrics every flush interval until ctx is done, then sends the
+// rest. Metrics recorded later, e.g. by a cycle draining at shutdown, are sent by Close.
+func (s *StatsD) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.Flush()
+		case <-ctx.Done():
+			s.Flush()
+			return
+		}
+	}
+}
+
+// Close sends the buffered metrics and closes the connection.
+func (s *StatsD) Close() error {
+	s.Flush()
+	return s.conn.Close()
+}
+
+// Flush sends the buffered metrics.
+func (s *StatsD) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushLocked()
+}
+
+func (s *StatsD) flushLocked() {
+	if len(s.buf) == 0 {
+		return
+	}
+	// A failed send, e.g. while the agent restarts, only loses these metrics
+	_, _ = s.conn.Write(s.buf)
+	s.buf = s.buf[:0]
+}
+
+// sanitizeStatsD replaces the characters that have a meaning in the StatsD protocol with
+// underscores.
+func sanitizeStatsD(s, reserved string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(reserved, r) {
+			return '_'
+		}
+		return r
+	}, s)
+}
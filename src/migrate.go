@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"kandji-cloudflare-device-sync/config"
+)
+
+// runMigrateConfig upgrades a config file to the current config layout in place, keeping a
+// backup of the original next to it.
+func runMigrateConfig(args []string) int {
+	fs := newFlagSet("migrate-config")
+	configPath := fs.String("config", "config.yaml", "Path to config file")
+	dryRun := fs.Bool("dry-run", false, "Print the migrated config instead of writing it")
+	if err := fs.Parse(args); err != nil {
+		return exitConfig
+	}
+
+	data, err := os.ReadFile(*configPath)
+	if err != nil {
+		slog.Error("Failed to read config file", "path", *configPath, "error", err)
+		return exitFailure
+	}
+	migrated, applied, err := config.MigrateConfig(data)
+	if err != nil {
+		slog.Error("Failed to migrate config file", "path", *configPath, "error", err)
+		return exitConfig
+	}
+
+	if *dryRun {
+		fmt.Print(string(migrated))
+		return exitOK
+	}
+	if len(applied) == 0 {
+		fmt.Printf("%s is already at config_version %d\n", *configPath, config.CurrentConfigVersion)
+		return exitOK
+	}
+
+	info, err := os.Stat(*configPath)
+	if err != nil {
+		slog.Error("Failed to stat config file", "path", *configPath, "error", err)
+		return exitFailure
+	}
+	backupPath := *configPath + ".bak"
+	if err := os.WriteFile(backupPath, data, info.Mode().Perm()); err != nil {
+		slog.Error("Failed to write config backup", "path", backupPath, "error", err)
+		return exitFailure
+	}
+	if err := os.WriteFile(*configPath, migrated, info.Mode().Perm()); err != nil {
+		slog.Error("Failed to write migrated config", "path", *configPath, "error", err)
+		return exitFailure
+	}
+
+	for _, description := range applied {
+		fmt.Printf("applied: %s\n", description)
+	}
+	fmt.Printf("%s migrated to config_version %d (original saved as %s; comments are not preserved)\n", *configPath, config.CurrentConfigVersion, backupPath)
+	return exitOK
+}
@@ -0,0 +1,44 @@
+package mosyle
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"kandji-cloudflare-device-sync/kandji"
+)
+
+// inventoryCache holds the last downloaded device inventory, see WithInventoryCache.
+type inventoryCache struct {
+	maxAge time.Duration
+
+	// mu is held during a download, so concurrent callers wait for it instead of starting their own
+	mu        sync.Mutex
+	devices   []kandji.Device
+	fetchedAt time.Time
+}
+
+// WithInventoryCache makes GetDevices return the inventory downloaded within maxAge instead
+// of downloading it again, so several sync jobs sharing the client download it once. Calls
+// made while a download is in progress wait for it.
+func WithInventoryCache(maxAge time.Duration) Option {
+	return func(c *Client) {
+		c.inventory = &inventoryCache{maxAge: maxAge}
+	}
+}
+
+// cachedDevices returns the cached inventory if it is recent enough, and downloads it with
+// fetch otherwise. A failed download is not cached.
+func (ic *inventoryCache) cachedDevices(ctx context.Context, fetch func(context.Context) ([]kandji.Device, error)) ([]kandji.Device, error) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	if ic.devices != nil && time.Since(ic.fetchedAt) < ic.maxAge {
+		return append([]kandji.Device(nil), ic.devices...), nil
+	}
+	devices, err := fetch(ctx)
+	if err != nil {
+		return devices, err
+	}
+	ic.devices, ic.fetchedAt = devices, time.Now()
+	return append([]kandji.Device(nil), devices...), nil
+}
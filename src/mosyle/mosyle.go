@@ -0,0 +1,310 @@
+// Package mosyle reads the device inventory of Mosyle Business through its API, as a device
+// source merged with, or used in place of, the Kandji inventory.
+package mosyle
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/internal/httpclient"
+	"kandji-cloudflare-device-sync/kandji"
+)
+
+// tokenLifetime is how long a Mosyle bearer token is used before logging in again. Tokens
+// are valid for 24 hours.
+const tokenLifetime = 23 * time.Hour
+
+// Device is a device record from the Mosyle listdevices endpoint.
+type Device struct {
+	DeviceName      string `json:"device_name"`
+	SerialNumber    string `json:"serial_number"`
+	OS              string `json:"os"`
+	OSVersion       string `json:"osversion"`
+	DeviceModelName string `json:"device_model_name"`
+	UserID          string `json:"userid"`
+	UserEmail       string `json:"useremail"`
+	Tags            string `json:"tags"`
+	// DateInfo is the Unix time of the last inventory report, as a string or a number
+	DateInfo json.RawMessage `json:"date_info"`
+}
+
+// listDevicesResponse is a page of the listdevices endpoint.
+type listDevicesResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	// Response is an object with the devices, or an error message for some failures
+	Response json.RawMessage `json:"response"`
+}
+
+// Client is a client for the devices of a Mosyle Business account.
+type Client struct {
+	apiURL           string
+	accessToken      string
+	email            string
+	password         string
+	operatingSystems []string
+	pageSize         int
+	limiter          *rate.Limiter
+	httpClient       *http.Client
+	httpOptions      httpclient.Options
+	// inventory is nil unless WithInventoryCache is set
+	inventory *inventoryCache
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// Option configures optional Client behaviour.
+type Option func(*Client)
+
+// WithUserAgent sets the User-Agent sent on every request.
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.httpOptions.UserAgent = userAgent
+	}
+}
+
+// WithNetwork sets the DNS servers and static host mappings used to reach the API.
+func WithNetwork(cfg config.NetworkConfig) Option {
+	return func(c *Client) {
+		c.httpOptions.DNSServers = cfg.DNSServers
+		c.httpOptions.Hosts = cfg.Hosts
+	}
+}
+
+// NewClient creates a new Mosyle client. Its requests are rate limited on their own,
+// separately from the Kandji and Cloudflare requests.
+func NewClient(cfg config.MosyleConfig, opts ...Option) (*Client, error) {
+	if cfg.AccessToken == "" || cfg.Email == "" || cfg.Password == "" {
+		return nil, fmt.Errorf("mosyle access_token, email and password are required")
+	}
+	c := &Client{
+		apiURL:           strings.TrimRight(cfg.APIURL, "/"),
+		accessToken:      cfg.AccessToken,
+		email:            cfg.Email,
+		password:         cfg.Password,
+		operatingSystems: cfg.OperatingSystems,
+		pageSize:         cfg.PageSize,
+		limiter:          rate.NewLimiter(rate.Limit(cfg.RequestsPerSecond), 1),
+		httpOptions: httpclient.Options{
+			Timeout:  60 * time.Second,
+			ProxyURL: cfg.ProxyURL,
+		},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	httpClient, err := httpclient.New(c.httpOptions)
+	if err != nil {
+		return nil, err
+	}
+	c.httpClient = httpClient
+	return c, nil
+}
+
+// bearerToken returns the token of the API user, logging in again if the cached token is
+// about to expire.
+func (c *Client) bearerToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Now().Before(c.expiry) {
+		return c.token, nil
+	}
+	if err := c.limiter.Wait(ctx); err != nil {
+		return "", fmt.Errorf("rate limiter cancelled: %w", err)
+	}
+
+	body, err := json.Marshal(map[string]string{"email": c.email, "password": c.password})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal Mosyle login request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.apiURL+"/login", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to create Mosyle login request: %w", err)
+	}
+	req.Header.Set("accesstoken", c.accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute Mosyle login request: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Mosyle login failed: HTTP %d - %s", resp.StatusCode, string(respBody))
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(resp.Header.Get("Authorization"), "Bearer "))
+	if token == "" {
+		return "", fmt.Errorf("Mosyle login failed, no token returned; check the access token, email and password: %s", string(respBody))
+	}
+
+	c.token = token
+	c.expiry = time.Now().Add(tokenLifetime)
+	return c.token, nil
+}
+
+// Probe logs in to check that the access token and the API user are accepted.
+func (c *Client) Probe(ctx context.Context) error {
+	_, err := c.bearerToken(ctx)
+	return err
+}
+
+// GetDevices retrieves the devices of every configured operating system, converted to
+// Kandji device records so that they go through the same filters.
+func (c *Client) GetDevices(ctx context.Context) ([]kandji.Device, error) {
+	if c.inventory != nil {
+		return c.inventory.cachedDevices(ctx, c.fetchDevices)
+	}
+	return c.fetchDevices(ctx)
+}
+
+// fetchDevices downloads every page of the device inventory of every operating system.
+func (c *Client) fetchDevices(ctx context.Context) ([]kandji.Device, error) {
+	var devices []kandji.Device
+	for _, os := range c.operatingSystems {
+		maxPages := 1000 // Safety limit to prevent infinite loops
+		for page := 1; ; page++ {
+			if page > maxPages {
+				return devices, fmt.Errorf("reached maximum page limit (%d pages) for %s devices, there may be more devices", maxPages, os)
+			}
+			pageDevices, err := c.listDevices(ctx, os, page)
+			if err != nil {
+				return nil, err
+			}
+			for _, device := range pageDevices {
+				devices = append(devices, device.kandjiDevice())
+			}
+			if len(pageDevices) < c.pageSize {
+				break
+			}
+		}
+	}
+	return devices, nil
+}
+
+// listDevices retrieves a page of the devices of an operating system.
+func (c *Client) listDevices(ctx context.Context, os string, page int) ([]Device, error) {
+	token, err := c.bearerToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, fmt.Errorf("rate limiter cancelled: %w", err)
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"operation": "list",
+		"options": map[string]any{
+			"os":        os,
+			"page":      page,
+			"page_size": c.pageSize,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal Mosyle request body: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.apiURL+"/listdevices", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Mosyle API request: %w", err)
+	}
+	req.Header.Set("accesstoken", c.accessToken)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute Mosyle API request: %w", err)
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Mosyle API response body: %w", err)
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		// Log in again on the next request
+		c.mu.Lock()
+		c.token = ""
+		c.mu.Unlock()
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("received non-200 status from Mosyle API: %s, body: %s", resp.Status, string(respBody))
+	}
+
+	var result listDevicesResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Mosyle devices JSON: %w", err)
+	}
+	if result.Status != "OK" {
+		return nil, fmt.Errorf("Mosyle API returned status %q: %s", result.Status, string(respBody))
+	}
+	var response struct {
+		Devices []Device `json:"devices"`
+	}
+	if err := json.Unmarshal(result.Response, &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Mosyle devices JSON: %w", err)
+	}
+	return response.Devices, nil
+}
+
+// kandjiDevice converts the device to a Kandji device record. The platform is derived from
+// the operating system and, for iOS, the model. Mosyle tags are comma-separated.
+func (d Device) kandjiDevice() kandji.Device {
+	device := kandji.Device{
+		DeviceName:   d.DeviceName,
+		SerialNumber: d.SerialNumber,
+		Platform:     platform(d.OS, d.DeviceModelName),
+		Model:        d.DeviceModelName,
+		OSVersion:    d.OSVersion,
+		UserEmail:    d.UserEmail,
+		LastSeen:     lastSeen(d.DateInfo),
+	}
+	for _, tag := range strings.Split(d.Tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			device.Tags = append(device.Tags, tag)
+		}
+	}
+	return device
+}
+
+// platform maps a Mosyle operating system to the Kandji platform name.
+func platform(os, model string) string {
+	switch strings.ToLower(os) {
+	case "mac":
+		return "Mac"
+	case "ios":
+		if strings.HasPrefix(model, "iPad") {
+			return "iPad"
+		}
+		return "iPhone"
+	case "tvos":
+		return "AppleTV"
+	case "visionos":
+		return "Vision"
+	}
+	return os
+}
+
+// lastSeen formats the Unix time of the last inventory report like a Kandji last_seen
+// time. It is empty if the time is missing.
+func lastSeen(dateInfo json.RawMessage) string {
+	seconds, err := strconv.ParseInt(strings.Trim(string(dateInfo), `"`), 10, 64)
+	if err != nil || seconds <= 0 {
+		return ""
+	}
+	return time.Unix(seconds, 0).UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,84 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/internal/mail"
+)
+
+// Email sends alerts by email: failed cycles, cycles that removed more serials than the
+// deletion threshold, and on_missing alerts. Other cycle summaries are not sent.
+type Email struct {
+	recipients        []string
+	subjectPrefix     string
+	deletionThreshold int
+	sender            *mail.Sender
+	log               *slog.Logger
+}
+
+// NewEmail creates an Email notifier.
+func NewEmail(cfg config.EmailConfig, sender *mail.Sender, log *slog.Logger) *Email {
+	return &Email{
+		recipients:        cfg.Recipients,
+		subjectPrefix:     cfg.SubjectPrefix,
+		deletionThreshold: cfg.DeletionThreshold,
+		sender:            sender,
+		log:               log,
+	}
+}
+
+// Name returns the notifier name used in logs.
+func (e *Email) Name() string {
+	return "email"
+}
+
+// Notify emails the event if it is one of the alerts the notifier sends.
+func (e *Email) Notify(ctx context.Context, event *Event) error {
+	var subject string
+	var b strings.Builder
+	switch event.Type {
+	case TypeCycleFailed:
+		subject = "Sync cycle failed"
+		fmt.Fprintf(&b, "The sync cycle for Cloudflare list %s failed at %s:\n\n%s\n",
+			event.ListID, event.Time.Format(time.RFC3339), event.Error)
+	case TypeCycleCompleted:
+		if e.deletionThreshold == 0 || len(event.Summary.Removed) <= e.deletionThreshold {
+			return nil
+		}
+		subject = fmt.Sprintf("%d serials removed in one sync cycle", len(event.Summary.Removed))
+		fmt.Fprintf(&b, "The sync cycle at %s removed %d serials from Cloudflare list %s, more than the threshold of %d.\n",
+			event.Time.Format(time.RFC3339), len(event.Summary.Removed), event.ListID, e.deletionThreshold)
+		fmt.Fprintf(&b, "\nRemoved:\n")
+		for _, serial := range event.Summary.Removed {
+			fmt.Fprintf(&b, "  %s\n", serial)
+		}
+	case TypeRemovalsBlocked:
+		subject = fmt.Sprintf("%d removals blocked by max_removals_per_cycle", len(event.Removals.Serials))
+		fmt.Fprintf(&b, "The sync cycle at %s computed %d removals from Cloudflare list %s (%d entries), more than max_removals_per_cycle %s.\n",
+			event.Time.Format(time.RFC3339), len(event.Removals.Serials), event.ListID, event.Removals.ListSize, event.Removals.Limit)
+		fmt.Fprintf(&b, "None of them were applied; the list is left in place until the removals are within the limit.\n")
+		fmt.Fprintf(&b, "\nNot removed:\n")
+		for _, serial := range event.Removals.Serials {
+			fmt.Fprintf(&b, "  %s\n", serial)
+		}
+	case TypeMissingDevices:
+		subject = fmt.Sprintf("%d devices missing from all sources", len(event.Missing))
+		fmt.Fprintf(&b, "These devices in Cloudflare list %s are in none of the sources and were left in place:\n\n",
+			event.ListID)
+		for _, device := range event.Missing {
+			fmt.Fprintf(&b, "  %s  %s\n", device.SerialNumber, device.Comment)
+		}
+	default:
+		return nil
+	}
+	if err := e.sender.Send(ctx, e.recipients, e.subjectPrefix+" "+subject, b.String()); err != nil {
+		return err
+	}
+	e.log.Info("Alert email sent", "type", event.Type, "recipients", len(e.recipients))
+	return nil
+}
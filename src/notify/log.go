@@ -0,0 +1,40 @@
+package notify
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Log writes events to the service log.
+type Log struct {
+	log *slog.Logger
+}
+
+// NewLog creates a Log notifier.
+func NewLog(log *slog.Logger) *Log {
+	return &Log{log: log}
+}
+
+// Name returns the notifier name used in logs.
+func (l *Log) Name() string {
+	return "log"
+}
+
+// Notify logs alerts as warnings, with one line per missing device. Cycle summaries are not
+// logged again, the syncer already logs every cycle.
+func (l *Log) Notify(ctx context.Context, event *Event) error {
+	switch event.Type {
+	case TypeMissingDevices:
+		for _, device := range event.Missing {
+			l.log.Warn("ALERT: device in Cloudflare list is missing from all sources",
+				"list_id", event.ListID, "serial_number", device.SerialNumber, "comment", device.Comment)
+		}
+		l.log.Warn("ALERT: devices in Cloudflare list are missing from all sources",
+			"list_id", event.ListID, "count", len(event.Missing))
+	case TypeRemovalsBlocked:
+		l.log.Warn("ALERT: removals from Cloudflare list exceed max_removals_per_cycle and were not applied",
+			"list_id", event.ListID, "count", len(event.Removals.Serials), "list_size", event.Removals.ListSize,
+			"limit", event.Removals.Limit, "serials", event.Removals.Serials)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+// Package notify dispatches alerts raised by the sync cycles to the configured notification
+// backends.
+package notify
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/internal/mail"
+)
+
+// Event types.
+const (
+	// TypeMissingDevices is raised with on_missing "alert" when the target list, or a list
+	// devices are routed to, holds devices that are in none of the sources
+	TypeMissingDevices = "missing_devices"
+	// TypeCycleStarted is raised at the start of every sync cycle
+	TypeCycleStarted = "cycle_started"
+	// TypeCycleCompleted and TypeCycleFailed are raised at the end of every sync cycle
+	TypeCycleCompleted = "cycle_completed"
+	TypeCycleFailed    = "cycle_failed"
+	// TypeListUnavailable is raised before TypeCycleFailed when the cycle failed because the
+	// target list could not be read
+	TypeListUnavailable = "list_unavailable"
+	// TypeCircuitOpened and TypeCircuitClosed are raised when the circuit breaker of an API
+	// opens or closes again
+	TypeCircuitOpened = "circuit_opened"
+	TypeCircuitClosed = "circuit_closed"
+	// TypeRemovalsBlocked is raised when a cycle computed more removals from a list than
+	// max_removals_per_cycle allows and applied none of them
+	TypeRemovalsBlocked = "removals_blocked"
+)
+
+// MissingDevice is a list entry whose serial is in none of the sources.
+type MissingDevice struct {
+	SerialNumber string `json:"serial_number"`
+	Comment      string `json:"comment"`
+}
+
+// Summary is the outcome of a sync cycle.
+type Summary struct {
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+	Devices     int       `json:"devices"`
+	Added       []string  `json:"added"`
+	Removed     []string  `json:"removed"`
+	FailedToAdd []string  `json:"failed_to_add,omitempty"`
+}
+
+// Changed reports whether the cycle added, removed or failed to add any serial.
+func (s *Summary) Changed() bool {
+	return len(s.Added) > 0 || len(s.Removed) > 0 || len(s.FailedToAdd) > 0
+}
+
+// Circuit describes the circuit breaker of an API that opened or closed.
+type Circuit struct {
+	// API is "kandji" or "cloudflare"
+	API string `json:"api"`
+	// OpenUntil is when the circuit is half-open again, for TypeCircuitOpened
+	OpenUntil time.Time `json:"open_until,omitempty"`
+	Failures  int       `json:"failures,omitempty"`
+}
+
+// BlockedRemovals are the removals a cycle did not apply because they exceeded
+// max_removals_per_cycle.
+type BlockedRemovals struct {
+	Serials  []string `json:"serials"`
+	ListSize int      `json:"list_size"`
+	// Limit is the exceeded limit, e.g. "count 50" or "percent 10"
+	Limit string `json:"limit"`
+}
+
+// Event is a single notification.
+type Event struct {
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	ListID string    `json:"list_id"`
+	// Missing holds the devices of a TypeMissingDevices event
+	Missing []MissingDevice `json:"missing,omitempty"`
+	// Summary is set for TypeCycleCompleted and TypeCycleFailed events
+	Summary *Summary `json:"summary,omitempty"`
+	// Error is why the cycle of a TypeCycleFailed or TypeListUnavailable event failed
+	Error string `json:"error,omitempty"`
+	// Circuit is set for TypeCircuitOpened and TypeCircuitClosed events
+	Circuit *Circuit `json:"circuit,omitempty"`
+	// Removals is set for TypeRemovalsBlocked events
+	Removals *BlockedRemovals `json:"removals,omitempty"`
+}
+
+// Notifier delivers events to a notification backend.
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, event *Event) error
+}
+
+// New builds the notifiers enabled in the configuration. The log notifier is always
+// included, so alerts are never lost when no other backend is configured.
+func New(cfg *config.Config, log *slog.Logger) ([]Notifier, error) {
+	notifiers := []Notifier{NewLog(log)}
+
+	if cfg.Notifications.Slack.Enabled {
+		notifiers = append(notifiers, NewSlack(cfg.Notifications.Slack, log))
+	}
+
+	if cfg.Notifications.PagerDuty.Enabled {
+		notifiers = append(notifiers, NewPagerDuty(cfg.Notifications.PagerDuty, cfg.Client.InstanceID, log))
+	}
+
+	if cfg.Notifications.Webhook.Enabled {
+		notifiers = append(notifiers, NewWebhook(cfg.Notifications.Webhook, cfg.Client.InstanceID, log))
+	}
+
+	if cfg.Notifications.Email.Enabled {
+		notifiers = append(notifiers, NewEmail(cfg.Notifications.Email, mail.New(cfg.SMTP), log))
+	}
+
+	return notifiers, nil
+}
+
+// Dispatch hands the event to every notifier. Notifier failures are logged and never fail
+// the sync cycle.
+func Dispatch(ctx context.Context, notifiers []Notifier, event *Event, log *slog.Logger) {
+	for _, notifier := range notifiers {
+		if err := notifier.Notify(ctx, event); err != nil {
+			log.Error("Failed to send notification", "notifier", notifier.Name(), "type", event.Type, "error", err)
+		}
+	}
+}
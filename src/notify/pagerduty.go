@@ -0,0 +1,167 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"kandji-cloudflare-device-sync/config"
+)
+
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// PagerDuty triggers PagerDuty incidents when consecutive cycles fail or the target list
+// cannot be read, and resolves them when a cycle succeeds.
+type PagerDuty struct {
+	routingKey       string
+	failureThreshold int
+	severity         string
+	source           string
+	httpClient       *http.Client
+	log              *slog.Logger
+
+	mu sync.Mutex
+	// consecutiveFailures counts the failed cycles since the last successful one
+	consecutiveFailures int
+	// open holds the dedup keys of the incidents that may be open. It is unknown at startup,
+	// so the first successful cycle resolves both incidents.
+	open  map[string]bool
+	known bool
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string         `json:"summary"`
+	Source        string         `json:"source"`
+	Severity      string         `json:"severity"`
+	Timestamp     string         `json:"timestamp"`
+	Component     string         `json:"component,omitempty"`
+	CustomDetails map[string]any `json:"custom_details,omitempty"`
+}
+
+// NewPagerDuty creates a PagerDuty notifier. source identifies the instance in incidents.
+func NewPagerDuty(cfg config.PagerDutyConfig, source string, log *slog.Logger) *PagerDuty {
+	return &PagerDuty{
+		routingKey:       cfg.RoutingKey,
+		failureThreshold: cfg.FailureThreshold,
+		severity:         cfg.Severity,
+		source:           source,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		log:  log,
+		open: make(map[string]bool),
+	}
+}
+
+// Name returns the notifier name used in logs.
+func (p *PagerDuty) Name() string {
+	return "pagerduty"
+}
+
+// Notify triggers or resolves incidents as the cycle outcomes require.
+func (p *PagerDuty) Notify(ctx context.Context, event *Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch event.Type {
+	case TypeCycleFailed:
+		p.consecutiveFailures++
+		if p.consecutiveFailures < p.failureThreshold || p.open[p.failuresKey()] {
+			return nil
+		}
+		return p.trigger(ctx, p.failuresKey(), event,
+			fmt.Sprintf("Kandji to Cloudflare sync failed %d times in a row: %s", p.consecutiveFailures, event.Error),
+			map[string]any{"list_id": event.ListID, "consecutive_failures": p.consecutiveFailures, "error": event.Error})
+	case TypeListUnavailable:
+		key := p.listKey(event.ListID)
+		if p.open[key] {
+			return nil
+		}
+		return p.trigger(ctx, key, event,
+			fmt.Sprintf("Cloudflare list %s used by the Kandji sync cannot be read: %s", event.ListID, event.Error),
+			map[string]any{"list_id": event.ListID, "error": event.Error})
+	case TypeCycleCompleted:
+		p.consecutiveFailures = 0
+		keys := []string{p.failuresKey(), p.listKey(event.ListID)}
+		for _, key := range keys {
+			if p.known && !p.open[key] {
+				continue
+			}
+			if err := p.send(ctx, pagerDutyEvent{RoutingKey: p.routingKey, EventAction: "resolve", DedupKey: key}); err != nil {
+				return err
+			}
+			delete(p.open, key)
+			p.log.Info("Resolved PagerDuty incident", "dedup_key", key)
+		}
+		p.known = true
+	}
+	return nil
+}
+
+// trigger opens the incident with the dedup key.
+func (p *PagerDuty) trigger(ctx context.Context, key string, event *Event, summary string, details map[string]any) error {
+	err := p.send(ctx, pagerDutyEvent{
+		RoutingKey:  p.routingKey,
+		EventAction: "trigger",
+		DedupKey:    key,
+		Payload: &pagerDutyPayload{
+			Summary:       summary,
+			Source:        p.source,
+			Severity:      p.severity,
+			Timestamp:     event.Time.Format(time.RFC3339),
+			Component:     "kandji-cloudflare-device-sync",
+			CustomDetails: details,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	p.open[key] = true
+	p.log.Warn("Triggered PagerDuty incident", "dedup_key", key, "summary", summary)
+	return nil
+}
+
+func (p *PagerDuty) failuresKey() string {
+	return "kandji-cloudflare-sync/" + p.source + "/cycle-failures"
+}
+
+func (p *PagerDuty) listKey(listID string) string {
+	return "kandji-cloudflare-sync/" + p.source + "/list-unavailable/" + listID
+}
+
+func (p *PagerDuty) send(ctx context.Context, event pagerDutyEvent) error {
+	jsonBody, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request body: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", pagerDutyEventsURL, bytes.NewReader(jsonBody))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("HTTP %d - %s", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"kandji-cloudflare-device-sync/config"
+)
+
+// maxSlackSerials caps the serials listed in a message so large changes stay readable.
+const maxSlackSerials = 50
+
+// Slack posts cycle summaries and alerts to a Slack incoming webhook.
+type Slack struct {
+	webhookURL string
+	summaries  string
+	httpClient *http.Client
+	log        *slog.Logger
+}
+
+type slackMessage struct {
+	Text string `json:"text"`
+}
+
+// NewSlack creates a Slack notifier.
+func NewSlack(cfg config.SlackConfig, log *slog.Logger) *Slack {
+	return &Slack{
+		webhookURL: cfg.WebhookURL,
+		summaries:  cfg.Summaries,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		log: log,
+	}
+}
+
+// Name returns the notifier name used in logs.
+func (s *Slack) Name() string {
+	return "slack"
+}
+
+// Notify posts the event to the webhook, unless it is a cycle summary that the summaries
+// setting filters out.
+func (s *Slack) Notify(ctx context.Context, event *Event) error {
+	var text string
+	switch event.Type {
+	case TypeMissingDevices:
+		text = s.missingText(event)
+	case TypeCycleCompleted:
+		if s.summaries == "never" || (s.summaries == "changes" && !event.Summary.Changed()) {
+			return nil
+		}
+		text = s.summaryText(event)
+	case TypeCycleFailed:
+		if s.summaries == "never" {
+			return nil
+		}
+		text = fmt.Sprintf(":x: Kandji to Cloudflare sync failed for list `%s`: %s", event.ListID, event.Error)
+	case TypeCircuitOpened:
+		text = fmt.Sprintf(":octagonal_sign: Circuit breaker for the %s API opened after %d failed cycles, sync cycles are skipped until %s: %s",
+			event.Circuit.API, event.Circuit.Failures, event.Circuit.OpenUntil.Format(time.RFC3339), event.Error)
+	case TypeCircuitClosed:
+		text = fmt.Sprintf(":large_green_circle: Circuit breaker for the %s API closed, sync cycles resumed", event.Circuit.API)
+	case TypeRemovalsBlocked:
+		text = s.blockedText(event)
+	default:
+		return nil
+	}
+	if err := s.post(ctx, slackMessage{Text: text}); err != nil {
+		return err
+	}
+	s.log.Debug("Posted Slack notification", "type", event.Type)
+	return nil
+}
+
+// summaryText formats the summary of a completed cycle.
+func (s *Slack) summaryText(event *Event) string {
+	summary := event.Summary
+	var b strings.Builder
+	icon := ":white_check_mark:"
+	if len(summary.FailedToAdd) > 0 {
+		icon = ":warning:"
+	}
+	fmt.Fprintf(&b, "%s Kandji to Cloudflare sync for list `%s`: %d added, %d removed, %d failed to add (%d devices, took %s)",
+		icon, event.ListID, len(summary.Added), len(summary.Removed), len(summary.FailedToAdd), summary.Devices,
+		summary.FinishedAt.Sub(summary.StartedAt).Round(time.Millisecond))
+	writeSerials(&b, "Added", summary.Added)
+	writeSerials(&b, "Removed", summary.Removed)
+	writeSerials(&b, "Failed to add", summary.FailedToAdd)
+	return b.String()
+}
+
+// missingText formats an on_missing alert.
+func (s *Slack) missingText(event *Event) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, ":rotating_light: %d devices in Cloudflare list `%s` are missing from all sources and were left in place",
+		len(event.Missing), event.ListID)
+	for i, device := range event.Missing {
+		if i == maxSlackSerials {
+			fmt.Fprintf(&b, "\n… and %d more", len(event.Missing)-maxSlackSerials)
+			break
+		}
+		fmt.Fprintf(&b, "\n• `%s`", device.SerialNumber)
+		if device.Comment != "" {
+			fmt.Fprintf(&b, " %s", device.Comment)
+		}
+	}
+	return b.String()
+}
+
+// blockedText formats a max_removals_per_cycle alert.
+func (s *Slack) blockedText(event *Event) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, ":no_entry: Kandji to Cloudflare sync computed %d removals from list `%s` (%d entries), more than max_removals_per_cycle %s; none were applied",
+		len(event.Removals.Serials), event.ListID, event.Removals.ListSize, event.Removals.Limit)
+	writeSerials(&b, "Not removed", event.Removals.Serials)
+	return b.String()
+}
+
+// writeSerials appends a line listing the serials, if there are any.
+func writeSerials(b *strings.Builder, label string, serials []string) {
+	if len(serials) == 0 {
+		return
+	}
+	listed := serials
+	if len(listed) > maxSlackSerials {
+		listed = listed[:maxSlackSerials]
+	}
+	fmt.Fprintf(b, "\n%s: `%s`", label, strings.Join(listed, "`, `"))
+	if len(serials) > len(listed) {
+		fmt.Fprintf(b, " and %d more", len(serials)-len(listed))
+	}
+}
+
+func (s *Slack) post(ctx context.Context, message slackMessage) error {
+	jsonBody, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request body: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", s.webhookURL, bytes.NewReader(jsonBody))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("HTTP %d - %s", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
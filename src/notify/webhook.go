@@ -0,0 +1,101 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"kandji-cloudflare-device-sync/config"
+)
+
+// Webhook POSTs events as JSON to a URL, optionally signed with HMAC-SHA256.
+type Webhook struct {
+	url        string
+	secret     string
+	events     []string
+	instanceID string
+	httpClient *http.Client
+	log        *slog.Logger
+}
+
+// webhookPayload is the body of a webhook request: the event and the instance it is from.
+type webhookPayload struct {
+	*Event
+	Instance string `json:"instance"`
+}
+
+// NewWebhook creates a Webhook notifier. instanceID identifies the instance in the payload.
+func NewWebhook(cfg config.OutboundWebhookConfig, instanceID string, log *slog.Logger) *Webhook {
+	return &Webhook{
+		url:        cfg.URL,
+		secret:     cfg.Secret,
+		events:     cfg.Events,
+		instanceID: instanceID,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		log: log,
+	}
+}
+
+// Name returns the notifier name used in logs.
+func (w *Webhook) Name() string {
+	return "webhook"
+}
+
+// Notify POSTs the event, unless its type is not one of the configured events. With a
+// secret, the X-Signature-Timestamp header carries the Unix time of the request and
+// X-Signature-256 is "sha256=" and the hex HMAC-SHA256 of the timestamp, ".", and the body.
+func (w *Webhook) Notify(ctx context.Context, event *Event) error {
+	if len(w.events) > 0 && !slices.Contains(w.events, event.Type) {
+		return nil
+	}
+	jsonBody, err := json.Marshal(webhookPayload{Event: event, Instance: w.instanceID})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request body: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", w.url, bytes.NewReader(jsonBody))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Type", event.Type)
+	if w.secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Signature-Timestamp", timestamp)
+		req.Header.Set("X-Signature-256", "sha256="+Sign(w.secret, timestamp, jsonBody))
+	}
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("HTTP %d - %s", resp.StatusCode, string(respBody))
+	}
+	w.log.Debug("Posted webhook notification", "type", event.Type)
+	return nil
+}
+
+// Sign returns the hex HMAC-SHA256 with which a webhook request is signed, so receivers
+// written in Go can verify it.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"kandji-cloudflare-device-sync/audit"
+	"kandji-cloudflare-device-sync/cloudflare"
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/engine"
+	"kandji-cloudflare-device-sync/internal/httpclient"
+	"kandji-cloudflare-device-sync/internal/ratelimit"
+	"kandji-cloudflare-device-sync/state"
+	"kandji-cloudflare-device-sync/syncer"
+)
+
+// runPlan computes the change the next sync cycle would make to the target list with a dry
+// run, prints it and writes it to a plan file for apply.
+func runPlan(args []string) int {
+	fs := newFlagSet("plan")
+	out := fs.String("out", "plan.json", "Path to write the plan to, - for stdout")
+	cfg, err := config.ParseConfigArgs(fs, args)
+	if err != nil {
+		slog.Error("Failed to load configuration", "error", err)
+		return exitConfig
+	}
+	if cfg.Sandbox.Enabled {
+		slog.Error("A plan is applied to the real target list, turn off sandbox mode")
+		return exitConfig
+	}
+	cfg.DryRun = true
+
+	log := slog.New(slog.NewJSONHandler(os.Stderr, nil))
+	opts := engine.Options{
+		Config:    cfg,
+		Logger:    log,
+		UserAgent: httpclient.UserAgent(Version, cfg.Client.InstanceID, cfg.Client.UserAgentSuffix),
+	}
+	// The state store counts the misses of on_missing_grace_cycles; a dry run does not
+	// record any
+	if cfg.State.Path != "" {
+		store, err := state.Open(cfg.State.Path, cfg.State.Retention)
+		if err != nil {
+			log.Error("Failed to open state store", "path", cfg.State.Path, "error", err)
+			return exitFailure
+		}
+		opts.SyncerOptions = append(opts.SyncerOptions, syncer.WithStateStore(store))
+	}
+	syncEngine, err := engine.New(context.Background(), opts)
+	if err != nil {
+		failSetup(log, err)
+	}
+
+	plan, err := syncEngine.Syncer().Plan(context.Background())
+	if err != nil {
+		log.Error("Failed to compute plan", "error", err)
+		return apiExitCode(err, exitSyncFailed)
+	}
+
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		log.Error("Failed to encode plan", "error", err)
+		return exitFailure
+	}
+	if *out == "-" {
+		fmt.Println(string(data))
+		return exitOK
+	}
+	if err := os.WriteFile(*out, append(data, '\n'), 0o644); err != nil {
+		log.Error("Failed to write plan", "path", *out, "error", err)
+		return exitFailure
+	}
+	printPlan(os.Stdout, plan)
+	fmt.Printf("\nplan written to %s, run apply -plan %s to make these changes\n", *out, *out)
+	return exitOK
+}
+
+// printPlan prints the changes of a plan, one per line.
+func printPlan(w io.Writer, plan *syncer.Plan) {
+	fmt.Fprintf(w, "target list %s (%d entries, sync_mode %s)\n", plan.ListID, plan.TargetItems, plan.SyncMode)
+	for _, item := range plan.Remove {
+		fmt.Fprintf(w, "  - %s\t%q\t(%s)\n", item.SerialNumber, item.Comment, item.Reason)
+	}
+	for _, item := range plan.Add {
+		fmt.Fprintf(w, "  + %s\t%q\t(%s)\n", item.SerialNumber, item.Comment, item.Reason)
+	}
+	for _, update := range plan.UpdateComments {
+		fmt.Fprintf(w, "  ~ %s\t%q -> %q\n", update.SerialNumber, update.Comment, update.NewComment)
+	}
+	fmt.Fprintf(w, "%d to add, %d to remove, %d comments to update\n", len(plan.Add), len(plan.Remove), len(plan.UpdateComments))
+}
+
+// runApply makes exactly the change of a plan written by plan, refusing if the target list
+// changed since.
+func runApply(args []string) int {
+	fs := newFlagSet("apply")
+	planPath := fs.String("plan", "plan.json", "Path of the plan to apply")
+	cfg, err := config.ParseCloudflareConfigArgs(fs, args)
+	if err != nil {
+		slog.Error("Failed to load configuration", "error", err)
+		return exitConfig
+	}
+
+	log := slog.New(slog.NewJSONHandler(os.Stderr, nil))
+	data, err := os.ReadFile(*planPath)
+	if err != nil {
+		log.Error("Failed to read plan", "path", *planPath, "error", err)
+		return exitFailure
+	}
+	var plan syncer.Plan
+	if err := json.Unmarshal(data, &plan); err != nil || plan.ListID == "" {
+		log.Error("Invalid plan", "path", *planPath, "error", err)
+		return exitConfig
+	}
+	if cfg.Cloudflare.ListID != "" && cfg.Cloudflare.ListID != plan.ListID {
+		log.Error("Plan was computed for another target list", "plan_list_id", plan.ListID, "list_id", cfg.Cloudflare.ListID)
+		return exitConfig
+	}
+
+	cloudflareClient, closeAudit, err := newAuditedCloudflareClient(cfg, log)
+	if err != nil {
+		log.Error("Failed to create Cloudflare client", "error", err)
+		return exitConfig
+	}
+	defer closeAudit()
+
+	printPlan(os.Stdout, &plan)
+	if err := syncer.ApplyPlan(context.Background(), cloudflareClient, &plan, cfg.Batch.Size, log); err != nil {
+		log.Error("Failed to apply plan", "path", *planPath, "error", err)
+		if errors.Is(err, syncer.ErrPlanStale) {
+			fmt.Println("\nthe target list changed since the plan was computed, run plan again")
+			return exitValidation
+		}
+		return apiExitCode(err, exitSyncFailed)
+	}
+	fmt.Println("\nplan applied")
+	return exitOK
+}
+
+// newAuditedCloudflareClient creates the Cloudflare client of a command that changes lists.
+// If an audit log is configured, the client's mutations are written to it until the
+// returned function closes it.
+func newAuditedCloudflareClient(cfg *config.Config, log *slog.Logger) (*cloudflare.Client, func(), error) {
+	rateLimiter := ratelimit.New(ratelimit.Config{
+		KandjiRequestsPerSecond:     cfg.RateLimits.KandjiRequestsPerSecond,
+		CloudflareRequestsPerSecond: cfg.RateLimits.CloudflareRequestsPerSecond,
+		BurstCapacity:               cfg.RateLimits.BurstCapacity,
+	})
+	userAgent := httpclient.UserAgent(Version, cfg.Client.InstanceID, cfg.Client.UserAgentSuffix)
+	cloudflareOptions := []cloudflare.Option{cloudflare.WithUserAgent(userAgent), cloudflare.WithNetwork(cfg.Network)}
+	if cfg.DryRun {
+		cloudflareOptions = append(cloudflareOptions, cloudflare.WithDryRun())
+	}
+	closeAudit := func() {}
+	if cfg.Audit.Path != "" {
+		auditLog, err := audit.Open(cfg.Audit.Path, log)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open audit log %s: %w", cfg.Audit.Path, err)
+		}
+		closeAudit = func() { auditLog.Close() }
+		cloudflareOptions = append(cloudflareOptions, cloudflare.WithMutationObserver(auditLog.Record))
+	}
+	client, err := cloudflare.NewClient(cfg.Cloudflare, rateLimiter, log, cloudflareOptions...)
+	if err != nil {
+		closeAudit()
+		return nil, nil, err
+	}
+	return client, closeAudit, nil
+}
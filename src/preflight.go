@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"kandji-cloudflare-device-sync/cloudflare"
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/internal/httpclient"
+	"kandji-cloudflare-device-sync/internal/ratelimit"
+	"kandji-cloudflare-device-sync/kandji"
+	"kandji-cloudflare-device-sync/mosyle"
+)
+
+const (
+	// preflightTimeout bounds every single check
+	preflightTimeout = 15 * time.Second
+	// maxClockSkew is the largest difference to an API's clock that passes; entry expiry
+	// and token expiry are judged by the local clock
+	maxClockSkew = time.Minute
+)
+
+type checkStatus string
+
+const (
+	checkPass checkStatus = "PASS"
+	checkWarn checkStatus = "WARN"
+	checkFail checkStatus = "FAIL"
+)
+
+// preflightCheck is a line of the preflight checklist.
+type preflightCheck struct {
+	status checkStatus
+	name   string
+	detail string
+}
+
+// preflight runs the connectivity checks against the configured APIs and collects the results.
+type preflight struct {
+	cfg              *config.Config
+	kandjiURL        string
+	cloudflareURL    string
+	kandjiClient     *kandji.Client
+	mosyleClient     *mosyle.Client
+	cloudflareClient *cloudflare.Client
+	checks           []preflightCheck
+	// tenantClients are the clients of cfg.Kandji.Tenants, in the same order
+	tenantClients []*kandji.Client
+}
+
+// runPreflight runs every check the service makes at startup plus DNS resolution, proxy
+// reachability, token validity, list types and clock skew, and prints a pass/fail
+// checklist. It is meant to be run on a new host before the service is enabled.
+func runPreflight(args []string) int {
+	cfg, err := config.ParseConfigArgs(newFlagSet("preflight"), args)
+	if err != nil {
+		fmt.Printf("%s\tconfiguration\t%v\n", checkFail, err)
+		return exitConfig
+	}
+	if cfg.Sandbox.Enabled {
+		fmt.Printf("%s\tconfiguration\tpreflight checks the real APIs, turn off sandbox mode\n", checkFail)
+		return exitConfig
+	}
+
+	p := &preflight{cfg: cfg, cloudflareURL: "https://api.cloudflare.com/client/v4"}
+	p.kandjiURL, _ = config.NormalizeKandjiAPIURL(cfg.Kandji.ApiURL)
+	warnings := cfg.Lint(expectedCycleDuration(cfg))
+	p.add(checkPass, "configuration", fmt.Sprintf("valid, %d warnings", len(warnings)))
+	for _, warning := range warnings {
+		p.add(checkWarn, "configuration", warning)
+	}
+	if err := p.newClients(); err != nil {
+		p.add(checkFail, "clients", err.Error())
+		return p.print()
+	}
+
+	if p.kandjiClient != nil {
+		p.checkDNS("dns: kandji", p.kandjiURL, cfg.Kandji.ProxyURL)
+		p.checkProxy("proxy: kandji", p.kandjiURL, cfg.Kandji.ProxyURL)
+		p.checkClockSkew("clock skew: kandji", p.kandjiURL, cfg.Kandji.ProxyURL)
+		p.checkKandjiToken("kandji: token", p.kandjiClient)
+	}
+	for i, tenant := range cfg.Kandji.Tenants {
+		tenantURL, _ := config.NormalizeKandjiAPIURL(tenant.ApiURL)
+		proxyURL := cfg.Kandji.ForTenant(tenant).ProxyURL
+		p.checkDNS("dns: kandji "+tenant.Name, tenantURL, proxyURL)
+		p.checkProxy("proxy: kandji "+tenant.Name, tenantURL, proxyURL)
+		p.checkKandjiToken("kandji "+tenant.Name+": token", p.tenantClients[i])
+	}
+	if p.mosyleClient != nil {
+		p.checkDNS("dns: mosyle", cfg.Mosyle.APIURL, cfg.Mosyle.ProxyURL)
+		p.checkProxy("proxy: mosyle", cfg.Mosyle.APIURL, cfg.Mosyle.ProxyURL)
+		p.checkMosyleLogin()
+	}
+	p.checkDNS("dns: cloudflare", p.cloudflareURL, cfg.Cloudflare.ProxyURL)
+	p.checkProxy("proxy: cloudflare", p.cloudflareURL, cfg.Cloudflare.ProxyURL)
+	p.checkClockSkew("clock skew: cloudflare", p.cloudflareURL, cfg.Cloudflare.ProxyURL)
+	p.checkCloudflareToken()
+	p.checkLists()
+	return p.print()
+}
+
+func (p *preflight) add(status checkStatus, name, detail string) {
+	p.checks = append(p.checks, preflightCheck{status: status, name: name, detail: detail})
+}
+
+// print writes the checklist and a summary, and returns the exit code.
+func (p *preflight) print() int {
+	failed, warned := 0, 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, check := range p.checks {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", check.status, check.name, check.detail)
+		switch check.status {
+		case checkFail:
+			failed++
+		case checkWarn:
+			warned++
+		}
+	}
+	w.Flush()
+	if failed > 0 {
+		fmt.Printf("preflight failed: %d of %d checks failed (%d warnings)\n", failed, len(p.checks), warned)
+		return exitValidation
+	}
+	fmt.Printf("preflight passed: %d checks (%d warnings)\n", len(p.checks), warned)
+	return exitOK
+}
+
+func (p *preflight) newClients() error {
+	// The clients log list validation at info level, which would clutter the checklist
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	rateLimiter := ratelimit.New(ratelimit.Config{
+		KandjiRequestsPerSecond:     p.cfg.RateLimits.KandjiRequestsPerSecond,
+		CloudflareRequestsPerSecond: p.cfg.RateLimits.CloudflareRequestsPerSecond,
+		BurstCapacity:               p.cfg.RateLimits.BurstCapacity,
+	})
+	userAgent := httpclient.UserAgent(Version, p.cfg.Client.InstanceID, p.cfg.Client.UserAgentSuffix)
+
+	var err error
+	if p.cfg.KandjiEnabled() {
+		p.kandjiClient, err = kandji.NewClient(p.cfg.Kandji, rateLimiter, kandji.WithUserAgent(userAgent), kandji.WithNetwork(p.cfg.Network))
+		if err != nil {
+			return fmt.Errorf("failed to create Kandji client: %w", err)
+		}
+	}
+	for _, tenant := range p.cfg.Kandji.Tenants {
+		client, err := kandji.NewClient(p.cfg.Kandji.ForTenant(tenant), rateLimiter, kandji.WithUserAgent(userAgent), kandji.WithNetwork(p.cfg.Network))
+		if err != nil {
+			return fmt.Errorf("failed to create Kandji client for tenant %s: %w", tenant.Name, err)
+		}
+		p.tenantClients = append(p.tenantClients, client)
+	}
+	if p.cfg.Mosyle.Enabled {
+		p.mosyleClient, err = mosyle.NewClient(p.cfg.Mosyle, mosyle.WithUserAgent(userAgent), mosyle.WithNetwork(p.cfg.Network))
+		if err != nil {
+			return fmt.Errorf("failed to create Mosyle client: %w", err)
+		}
+	}
+	p.cloudflareClient, err = cloudflare.NewClient(p.cfg.Cloudflare, rateLimiter, log, cloudflare.WithUserAgent(userAgent), cloudflare.WithNetwork(p.cfg.Network))
+	if err != nil {
+		return fmt.Errorf("failed to create Cloudflare client: %w", err)
+	}
+	return nil
+}
+
+// proxyFor returns the proxy that requests to apiURL go through: the configured proxy, or
+// the one from the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables.
+func proxyFor(apiURL, proxyURL string) (*url.URL, error) {
+	if proxyURL != "" {
+		return url.Parse(proxyURL)
+	}
+	req, err := http.NewRequest("GET", apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// checkDNS resolves the API host the way the clients do. Behind a proxy the proxy resolves
+// the host, so a local failure is only a warning.
+func (p *preflight) checkDNS(name, apiURL, proxyURL string) {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		p.add(checkFail, name, err.Error())
+		return
+	}
+	host := u.Hostname()
+	if ip, ok := p.cfg.Network.Hosts[strings.ToLower(host)]; ok {
+		p.add(checkPass, name, fmt.Sprintf("%s is mapped to %s by network.hosts", host, ip))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), preflightTimeout)
+	defer cancel()
+	addrs, err := httpclient.Resolver(p.cfg.Network.DNSServers).LookupHost(ctx, host)
+	if err != nil {
+		if proxy, _ := proxyFor(apiURL, proxyURL); proxy != nil {
+			p.add(checkWarn, name, fmt.Sprintf("%s does not resolve locally, the proxy has to resolve it: %v", host, err))
+			return
+		}
+		p.add(checkFail, name, fmt.Sprintf("%s does not resolve: %v", host, err))
+		return
+	}
+	p.add(checkPass, name, fmt.Sprintf("%s resolves to %s", host, strings.Join(addrs, ", ")))
+}
+
+// checkProxy connects to the proxy used for an API, if there is one.
+func (p *preflight) checkProxy(name, apiURL, proxyURL string) {
+	proxy, err := proxyFor(apiURL, proxyURL)
+	if err != nil {
+		p.add(checkFail, name, fmt.Sprintf("invalid proxy: %v", err))
+		return
+	}
+	if proxy == nil {
+		p.add(checkPass, name, "no proxy, connecting directly")
+		return
+	}
+	port := proxy.Port()
+	if port == "" {
+		port = "80"
+		if proxy.Scheme == "https" {
+			port = "443"
+		}
+	}
+	address := net.JoinHostPort(proxy.Hostname(), port)
+	conn, err := net.DialTimeout("tcp", address, preflightTimeout)
+	if err != nil {
+		p.add(checkFail, name, fmt.Sprintf("cannot connect to proxy %s: %v", address, err))
+		return
+	}
+	conn.Close()
+	p.add(checkPass, name, fmt.Sprintf("proxy %s is reachable", address))
+}
+
+// checkClockSkew compares the local clock to the Date header of an unauthenticated
+// request to the API.
+func (p *preflight) checkClockSkew(name, apiURL, proxyURL string) {
+	client, err := httpclient.New(httpclient.Options{
+		Timeout:    preflightTimeout,
+		ProxyURL:   proxyURL,
+		DNSServers: p.cfg.Network.DNSServers,
+		Hosts:      p.cfg.Network.Hosts,
+	})
+	if err != nil {
+		p.add(checkFail, name, err.Error())
+		return
+	}
+	start := time.Now()
+	resp, err := client.Head(apiURL)
+	if err != nil {
+		p.add(checkFail, name, fmt.Sprintf("request failed: %v", err))
+		return
+	}
+	resp.Body.Close()
+	serverTime, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		p.add(checkWarn, name, "the response has no usable Date header")
+		return
+	}
+	// The Date header has second precision and was set while the request was in flight
+	local := start.Add(time.Since(start) / 2)
+	skew := local.Sub(serverTime).Round(time.Second)
+	if skew.Abs() > maxClockSkew {
+		p.add(checkFail, name, fmt.Sprintf("local clock is off by %s (more than %s), check NTP", skew, maxClockSkew))
+		return
+	}
+	p.add(checkPass, name, fmt.Sprintf("local clock is off by %s", skew))
+}
+
+func (p *preflight) checkKandjiToken(name string, client *kandji.Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), preflightTimeout)
+	defer cancel()
+	if err := client.Probe(ctx); err != nil {
+		p.add(checkFail, name, err.Error())
+		return
+	}
+	p.add(checkPass, name, "accepted, can list devices")
+}
+
+func (p *preflight) checkMosyleLogin() {
+	ctx, cancel := context.WithTimeout(context.Background(), preflightTimeout)
+	defer cancel()
+	if err := p.mosyleClient.Probe(ctx); err != nil {
+		p.add(checkFail, "mosyle: login", err.Error())
+		return
+	}
+	p.add(checkPass, "mosyle: login", "access token and API user accepted")
+}
+
+func (p *preflight) checkCloudflareToken() {
+	ctx, cancel := context.WithTimeout(context.Background(), preflightTimeout)
+	defer cancel()
+	status, err := p.cloudflareClient.VerifyToken(ctx)
+	switch {
+	case err != nil:
+		p.add(checkFail, "cloudflare: token", err.Error())
+	case status.Status != "active":
+		p.add(checkFail, "cloudflare: token", fmt.Sprintf("token status is %q", status.Status))
+	case !status.ExpiresOn.IsZero() && time.Until(status.ExpiresOn) < 7*24*time.Hour:
+		p.add(checkWarn, "cloudflare: token", fmt.Sprintf("active, expires on %s", status.ExpiresOn.Format(time.RFC3339)))
+	default:
+		p.add(checkPass, "cloudflare: token", "active")
+	}
+
+	lists, err := p.cloudflareClient.ListLists(ctx)
+	if err != nil {
+		p.add(checkFail, "cloudflare: zero trust read", err.Error())
+		return
+	}
+	kind := "Gateway"
+	if p.cfg.Cloudflare.ListKind == config.ListKindRules {
+		kind = "Rules"
+	}
+	p.add(checkPass, "cloudflare: zero trust read", fmt.Sprintf("%d %s lists visible; write access is only exercised by a sync", len(lists), kind))
+}
+
+// checkLists resolves every configured list and checks its type.
+func (p *preflight) checkLists() {
+	cf := p.cfg.Cloudflare
+	target := cf.ListID
+	if target == "" {
+		target = cf.TargetListName
+	}
+	deviceListType := cf.DeviceListType()
+	if !cf.EmailOnly {
+		p.checkList("list: target", target, deviceListType)
+	}
+	for _, ref := range cf.SourceListRefs() {
+		p.checkList("list: source", ref, deviceListType)
+	}
+	for _, ref := range cf.RoutedListRefs() {
+		p.checkList("list: routed", ref, deviceListType)
+	}
+	for _, ref := range cf.TagListMapping.Refs() {
+		p.checkList("list: tag", ref, deviceListType)
+	}
+	if cf.EmailListID != "" {
+		p.checkList("list: owner emails", cf.EmailListID, "EMAIL")
+	}
+	if p.cfg.Destinations.IPList.Enabled {
+		p.checkList("list: device IPs", p.cfg.Destinations.IPList.ListID, "IP")
+	}
+}
+
+func (p *preflight) checkList(name, ref, expectedType string) {
+	ctx, cancel := context.WithTimeout(context.Background(), preflightTimeout)
+	defer cancel()
+	listID, err := p.cloudflareClient.ResolveListID(ctx, ref)
+	if err != nil {
+		p.add(checkFail, name, err.Error())
+		return
+	}
+	list, err := p.cloudflareClient.GetListMetadataByID(ctx, listID)
+	if err != nil {
+		p.add(checkFail, name, err.Error())
+		return
+	}
+	if list.Type != expectedType {
+		p.add(checkFail, name, fmt.Sprintf("%q (%s) is a %s list, expected %s", list.Name, listID, list.Type, expectedType))
+		return
+	}
+	p.add(checkPass, name, fmt.Sprintf("%q (%s), %s, %d entries", list.Name, listID, list.Type, list.Count))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"kandji-cloudflare-device-sync/audit"
+	"kandji-cloudflare-device-sync/config"
+)
+
+// purgePreview is how many entries purge prints before asking for confirmation.
+const purgePreview = 10
+
+// runPurge removes every entry of a list in batches, after the list's name is typed to
+// confirm, for decommissioning or resetting a list.
+func runPurge(args []string) int {
+	fs := newFlagSet("purge")
+	listID := fs.String("list-id", "", "ID of the list to empty (required)")
+	yes := fs.Bool("yes", false, "Do not ask for confirmation")
+	cfg, err := config.ParseCloudflareConfigArgs(fs, args)
+	if err != nil {
+		slog.Error("Failed to load configuration", "error", err)
+		return exitConfig
+	}
+	if *listID == "" {
+		slog.Error("-list-id is required")
+		return exitConfig
+	}
+
+	log := slog.New(slog.NewJSONHandler(os.Stderr, nil))
+	client, closeAudit, err := newAuditedCloudflareClient(cfg, log)
+	if err != nil {
+		log.Error("Failed to create Cloudflare client", "error", err)
+		return exitConfig
+	}
+	defer closeAudit()
+
+	ctx := context.Background()
+	list, err := client.GetListMetadataByID(ctx, *listID)
+	if err != nil {
+		log.Error("Failed to get list", "list_id", *listID, "error", err)
+		return apiExitCode(err, exitValidation)
+	}
+	items, err := client.GetListItemsByID(ctx, *listID)
+	if err != nil {
+		log.Error("Failed to get items of list", "list_id", *listID, "error", err)
+		return apiExitCode(err, exitFailure)
+	}
+
+	fmt.Printf("list %q (%s, %s) has %d entries\n", list.Name, list.ID, list.Type, len(items))
+	if len(items) == 0 {
+		return exitOK
+	}
+	if *listID == cfg.Cloudflare.ListID {
+		fmt.Println("this is the configured target list, the next sync cycle adds the synced devices back")
+	}
+	for i, item := range items {
+		if i == purgePreview && !cfg.DryRun {
+			fmt.Printf("  ... and %d more\n", len(items)-purgePreview)
+			break
+		}
+		fmt.Printf("  - %s\t%q\n", item.Value, item.Comment)
+	}
+	if cfg.DryRun {
+		fmt.Printf("dry run: would remove %d entries\n", len(items))
+		return exitOK
+	}
+	if !*yes && !confirmPurge(list.Name) {
+		fmt.Println("not confirmed, nothing was removed")
+		return exitFailure
+	}
+
+	values := make([]string, 0, len(items))
+	annotations := make(audit.Annotations, len(items))
+	for _, item := range items {
+		values = append(values, item.Value)
+		annotations[item.Value] = audit.Annotation{Source: "purge", Comment: item.Comment}
+	}
+	result, err := client.DeleteItemsByID(audit.WithAnnotations(ctx, annotations), *listID, values, cfg.Batch.Size)
+	if err != nil {
+		log.Error("Failed to purge list", "list_id", *listID, "error", err)
+		return apiExitCode(err, exitFailure)
+	}
+	for _, failedDevice := range result.FailedDevices {
+		log.Error("Failed to remove entry", "value", failedDevice.SerialNumber, "error", failedDevice.Error)
+	}
+	for _, batchError := range result.Errors {
+		log.Error("Failed to remove batch", "error", batchError)
+	}
+	fmt.Printf("removed %d of %d entries\n", result.SuccessCount, len(items))
+	if result.SuccessCount < len(items) {
+		return apiExitCode(errors.Join(result.Errors...), exitFailure)
+	}
+	return exitOK
+}
+
+// confirmPurge asks for the list's name to be typed on stdin and reports whether it was.
+func confirmPurge(name string) bool {
+	fmt.Printf("type the list name %q to remove every entry: ", name)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		fmt.Println()
+		return false
+	}
+	return strings.TrimSpace(line) == name
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"syscall"
+	"time"
+
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/events"
+)
+
+// reloader reloads the configuration of the running service on SIGHUP, if
+// reload.watch_file is set when the config file changes, and when a secret the configuration
+// refers to changed, as read every secrets.refresh_interval. Each sync picks up the reloaded
+// configuration before its next cycle, so a cycle in progress is not interrupted.
+type reloader struct {
+	// args are the sync command's arguments, parsed again on every reload
+	args   []string
+	jobs   []*syncJob
+	log    *slog.Logger
+	events *events.Buffer
+	// cfg is the configuration last applied
+	cfg *config.Config
+}
+
+// run reloads the configuration until ctx is cancelled.
+func (r *reloader) run(ctx context.Context) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	defer signal.Stop(sighup)
+
+	// Without a watched file, the poll never fires
+	var poll <-chan time.Time
+	path := r.cfg.Path()
+	if r.cfg.Reload.WatchFile && path != "" {
+		ticker := time.NewTicker(r.cfg.Reload.PollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+		r.log.Info("Watching config file for changes", "path", path, "poll_interval", r.cfg.Reload.PollInterval.String())
+	}
+	modified := fileModified(path)
+
+	var refresh <-chan time.Time
+	if r.cfg.HasSecretRefs() && r.cfg.Secrets.RefreshInterval > 0 {
+		ticker := time.NewTicker(r.cfg.Secrets.RefreshInterval)
+		defer ticker.Stop()
+		refresh = ticker.C
+		r.log.Info("Reading referenced secrets again periodically", "refresh_interval", r.cfg.Secrets.RefreshInterval.String())
+	}
+
+	for {
+		select {
+		case <-sighup:
+			r.reload(ctx, "SIGHUP")
+			modified = fileModified(path)
+		case <-poll:
+			if m := fileModified(path); !m.Equal(modified) {
+				modified = m
+				r.reload(ctx, "config file changed")
+			}
+		case <-refresh:
+			changed, err := r.cfg.SecretsChanged(ctx)
+			if err != nil {
+				r.log.Error("Failed to read referenced secrets, keeping the current ones", "error", err)
+				continue
+			}
+			if changed {
+				r.reload(ctx, "secret changed")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reload reads the configuration again, with the same environment and flags as at startup,
+// and hands it to the top-level sync and the jobs. If it cannot be read or is invalid, the
+// current configuration is kept.
+func (r *reloader) reload(ctx context.Context, reason string) {
+	r.log.Info("Reloading configuration", "reason", reason)
+	cfg, err := loadReloadedConfig(r.args)
+	if err != nil {
+		r.log.Error("Failed to reload configuration, keeping the current one", "error", err)
+		return
+	}
+	for _, setting := range restartRequired(r.cfg, cfg) {
+		r.log.Warn("Configuration change takes effect only after a restart", "setting", setting)
+	}
+	for _, warning := range cfg.Lint(expectedCycleDuration(cfg)) {
+		r.log.Warn("Configuration warning", "warning", warning)
+	}
+
+	top := r.jobs[0]
+	if err := top.engine.Reload(ctx, cfg, nil); err != nil {
+		r.log.Error("Failed to reload configuration, keeping the current one", "error", err)
+		return
+	}
+
+	// Jobs are matched by name; adding or removing one requires a restart
+	jobConfigs := make(map[string]config.JobConfig, len(cfg.Jobs))
+	for _, job := range cfg.Jobs {
+		jobConfigs[job.Name] = job
+	}
+	running := make(map[string]bool, len(r.jobs))
+	for _, job := range r.jobs[1:] {
+		running[job.name] = true
+		jobConfig, ok := jobConfigs[job.name]
+		if !ok {
+			job.log.Warn("Job was removed from the configuration, it keeps running until a restart")
+			continue
+		}
+		if err := job.engine.Reload(ctx, cfg.ForJob(jobConfig), top.engine.KandjiClient()); err != nil {
+			job.log.Error("Failed to reload job configuration, keeping the current one", "error", err)
+		}
+	}
+	for _, job := range cfg.Jobs {
+		if !running[job.Name] {
+			r.log.Warn("Job was added to the configuration, it starts after a restart", "job", job.Name)
+		}
+	}
+
+	r.cfg = cfg
+	r.log.Info("Reloaded configuration, applied before the next sync cycle", "reason", reason)
+	if r.events != nil {
+		r.events.Record(events.TypeConfigReloaded, "Configuration reloaded", "reason", reason)
+	}
+}
+
+// loadReloadedConfig parses the configuration from the sync command's arguments like
+// runSync does at startup.
+func loadReloadedConfig(args []string) (*config.Config, error) {
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	// The command flags registered by runSync are accepted and ignored
+	fs.Bool("once", false, "")
+	fs.String("error-format", "", "")
+	return config.ParseConfigArgs(fs, args)
+}
+
+// restartRequired returns the settings that differ between old and cfg but are only read at
+// startup.
+func restartRequired(old, cfg *config.Config) []string {
+	settings := []struct {
+		name     string
+		old, new any
+	}{
+		{"log", old.Log, cfg.Log},
+		{"client", old.Client, cfg.Client},
+		{"kandji.pagination", old.Kandji.Pagination, cfg.Kandji.Pagination},
+		{"kandji.incremental", old.Kandji.Incremental, cfg.Kandji.Incremental},
+		{"metrics", old.Metrics, cfg.Metrics},
+		{"admin", old.Admin, cfg.Admin},
+		{"webhook", old.Webhook, cfg.Webhook},
+		{"state", old.State, cfg.State},
+		{"warm_cache", old.WarmCache, cfg.WarmCache},
+		{"audit", old.Audit, cfg.Audit},
+		{"sandbox", old.Sandbox, cfg.Sandbox},
+		{"digest", old.Digest, cfg.Digest},
+		{"smtp", old.SMTP, cfg.SMTP},
+		{"update_check", old.UpdateCheck, cfg.UpdateCheck},
+		{"leader_election", old.LeaderElection, cfg.LeaderElection},
+		{"shutdown", old.Shutdown, cfg.Shutdown},
+		{"systemd", old.Systemd, cfg.Systemd},
+		{"reload", old.Reload, cfg.Reload},
+		{"secrets.refresh_interval", old.Secrets.RefreshInterval, cfg.Secrets.RefreshInterval},
+		{"destinations", old.Destinations, cfg.Destinations},
+		{"notifications", old.Notifications, cfg.Notifications},
+		{"intune", old.Intune, cfg.Intune},
+		{"mosyle", old.Mosyle, cfg.Mosyle},
+		{"kandji.tenants", tenantSettings(old), tenantSettings(cfg)},
+	}
+	var changed []string
+	for _, setting := range settings {
+		if !reflect.DeepEqual(setting.old, setting.new) {
+			changed = append(changed, setting.name)
+		}
+	}
+	return changed
+}
+
+// tenantSettings returns the Kandji tenants of cfg to compare, with the tokens read from a
+// secret reference or file replaced by where they were read from, as they are rotated in
+// place.
+func tenantSettings(cfg *config.Config) []config.KandjiTenant {
+	tenants := slices.Clone(cfg.Kandji.Tenants)
+	for i := range tenants {
+		if origin := cfg.SecretOrigin(config.TenantTokenSetting(i)); origin != "" {
+			tenants[i].ApiToken = origin
+		}
+	}
+	return tenants
+}
+
+// fileModified returns the modification time of path, zero if it cannot be read.
+func fileModified(path string) time.Time {
+	if path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log/slog"
+
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/sandbox"
+)
+
+// startSandbox starts the fake Kandji and Cloudflare APIs for sandbox mode and turns off the
+// features that would reach other third-party services.
+func startSandbox(cfg *config.Config, log *slog.Logger) (*sandbox.Sandbox, error) {
+	external := []struct {
+		name    string
+		enabled *bool
+	}{
+		{"destinations.tailscale", &cfg.Destinations.Tailscale.Enabled},
+		{"destinations.google_sheets", &cfg.Destinations.GoogleSheets.Enabled},
+		{"destinations.s3", &cfg.Destinations.S3.Enabled},
+		{"destinations.device_events", &cfg.Destinations.DeviceEvents.Enabled},
+		{"destinations.csv_diff.s3", &cfg.Destinations.CSVDiff.S3.Enabled},
+		{"digest", &cfg.Digest.Enabled},
+		{"notifications.slack", &cfg.Notifications.Slack.Enabled},
+		{"notifications.email", &cfg.Notifications.Email.Enabled},
+		{"notifications.pagerduty", &cfg.Notifications.PagerDuty.Enabled},
+		{"notifications.webhook", &cfg.Notifications.Webhook.Enabled},
+		{"update_check", &cfg.UpdateCheck.Enabled},
+		{"intune", &cfg.Intune.Enabled},
+		{"mosyle", &cfg.Mosyle.Enabled},
+	}
+	for _, feature := range external {
+		if *feature.enabled {
+			*feature.enabled = false
+			log.Warn("Disabled in sandbox mode, it reaches an external service", "feature", feature.name)
+		}
+	}
+	if len(cfg.Kandji.Tenants) > 0 {
+		// Only the primary tenant is faked
+		cfg.Kandji.Tenants = nil
+		log.Warn("Disabled in sandbox mode, it reaches an external service", "feature", "kandji.tenants")
+	}
+	if cfg.Destinations.CSVDiff.Enabled && cfg.Destinations.CSVDiff.Directory == "" {
+		cfg.Destinations.CSVDiff.Enabled = false
+	}
+	return sandbox.Start(cfg.Sandbox.Fixtures, log)
+}
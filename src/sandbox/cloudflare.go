@@ -0,0 +1,279 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// cloudflarePrefix is the path prefix of the Cloudflare v4 API.
+const cloudflarePrefix = "/client/v4"
+
+type cloudflareItem struct {
+	// ID is only used by Rules Lists, which delete items by ID
+	ID        string    `json:"-"`
+	Value     string    `json:"value"`
+	Comment   string    `json:"comment,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type cloudflareList struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Type        string `json:"type"`
+	// Kind marks an account-level Rules List (ip or hostname), served by the Rules List
+	// endpoints instead of the Gateway ones
+	Kind      string           `json:"kind,omitempty"`
+	Count     int              `json:"count"`
+	CreatedAt time.Time        `json:"created_at"`
+	UpdatedAt time.Time        `json:"updated_at"`
+	Items     []cloudflareItem `json:"items,omitempty"`
+}
+
+type cloudflareError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// cloudflareAPI fakes the Zero Trust Gateway list and Rules List endpoints used by the sync,
+// for a single account whose ID is not checked.
+type cloudflareAPI struct {
+	mu    sync.Mutex
+	lists []*cloudflareList
+}
+
+// newCloudflareAPI seeds the fake from a JSON array of lists with their items. List IDs
+// are generated for lists that have none.
+func newCloudflareAPI(data []byte) (*cloudflareAPI, error) {
+	var lists []*cloudflareList
+	if err := json.Unmarshal(data, &lists); err != nil {
+		return nil, fmt.Errorf("expected a JSON array of lists: %w", err)
+	}
+	now := time.Now().UTC()
+	ids := make(map[string]struct{})
+	for i, list := range lists {
+		if list.Name == "" || (list.Type == "") == (list.Kind == "") {
+			return nil, fmt.Errorf("list %d needs a name and either a type or a kind", i)
+		}
+		if list.ID == "" && list.Kind != "" {
+			list.ID = newRulesID()
+		} else if list.ID == "" {
+			list.ID = newUUID()
+		}
+		if _, dup := ids[list.ID]; dup {
+			return nil, fmt.Errorf("duplicate list id %q", list.ID)
+		}
+		ids[list.ID] = struct{}{}
+		list.CreatedAt, list.UpdatedAt = now, now
+		for j := range list.Items {
+			list.Items[j].ID = newRulesID()
+			list.Items[j].CreatedAt = now
+		}
+		list.Count = len(list.Items)
+	}
+	return &cloudflareAPI{lists: lists}, nil
+}
+
+func (c *cloudflareAPI) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+cloudflarePrefix+"/accounts/{account}/gateway/lists", c.listLists)
+	mux.HandleFunc("POST "+cloudflarePrefix+"/accounts/{account}/gateway/lists", c.createList)
+	mux.HandleFunc("GET "+cloudflarePrefix+"/accounts/{account}/gateway/lists/{id}", c.getList)
+	mux.HandleFunc("PATCH "+cloudflarePrefix+"/accounts/{account}/gateway/lists/{id}", c.patchList)
+	mux.HandleFunc("GET "+cloudflarePrefix+"/accounts/{account}/gateway/lists/{id}/items", c.listItems)
+	c.handleRules(mux)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r) {
+			writeCloudflareError(w, http.StatusUnauthorized, 10000, "Authentication error")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func writeCloudflareResult(w http.ResponseWriter, result any) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"success":  true,
+		"errors":   []cloudflareError{},
+		"messages": []any{},
+		"result":   result,
+	})
+}
+
+func writeCloudflareError(w http.ResponseWriter, status, code int, message string) {
+	writeJSON(w, status, map[string]any{
+		"success":  false,
+		"errors":   []cloudflareError{{Code: code, Message: message}},
+		"messages": []any{},
+		"result":   nil,
+	})
+}
+
+// find returns the Gateway list with the given ID. The caller must hold c.mu.
+func (c *cloudflareAPI) find(id string) *cloudflareList {
+	for _, list := range c.lists {
+		if list.ID == id && list.Kind == "" {
+			return list
+		}
+	}
+	return nil
+}
+
+// metadata returns a copy of the list without its items.
+func (l *cloudflareList) metadata() cloudflareList {
+	m := *l
+	m.Items = nil
+	return m
+}
+
+func (c *cloudflareAPI) listLists(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result := make([]cloudflareList, 0, len(c.lists))
+	for _, list := range c.lists {
+		if list.Kind == "" {
+			result = append(result, list.metadata())
+		}
+	}
+	writeCloudflareResult(w, result)
+}
+
+func (c *cloudflareAPI) createList(w http.ResponseWriter, r *http.Request) {
+	var list cloudflareList
+	if err := json.NewDecoder(r.Body).Decode(&list); err != nil {
+		writeCloudflareError(w, http.StatusBadRequest, 2001, "Invalid JSON body")
+		return
+	}
+	if list.Name == "" || list.Type == "" {
+		writeCloudflareError(w, http.StatusBadRequest, 2001, "name and type are required")
+		return
+	}
+	now := time.Now().UTC()
+	list.ID = newUUID()
+	list.CreatedAt, list.UpdatedAt = now, now
+	for i := range list.Items {
+		list.Items[i].CreatedAt = now
+	}
+	list.Count = len(list.Items)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lists = append(c.lists, &list)
+	writeCloudflareResult(w, list.metadata())
+}
+
+func (c *cloudflareAPI) getList(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	list := c.find(r.PathValue("id"))
+	if list == nil {
+		writeCloudflareError(w, http.StatusNotFound, 7003, "list not found")
+		return
+	}
+	writeCloudflareResult(w, list.metadata())
+}
+
+func (c *cloudflareAPI) listItems(w http.ResponseWriter, r *http.Request) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if perPage < 1 || perPage > 1000 {
+		perPage = 1000
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	list := c.find(r.PathValue("id"))
+	if list == nil {
+		writeCloudflareError(w, http.StatusNotFound, 7003, "list not found")
+		return
+	}
+	start := min((page-1)*perPage, len(list.Items))
+	end := min(start+perPage, len(list.Items))
+	writeCloudflareResult(w, append([]cloudflareItem{}, list.Items[start:end]...))
+}
+
+// patchList applies a replacement, removals and appends by value, like the Gateway list
+// PATCH endpoint. Appending a value that is already in the list replaces its comment.
+func (c *cloudflareAPI) patchList(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Append      []cloudflareItem  `json:"append"`
+		Replace     *[]cloudflareItem `json:"replace"`
+		Remove      []string          `json:"remove"`
+		Description *string           `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeCloudflareError(w, http.StatusBadRequest, 2001, "Invalid JSON body")
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	list := c.find(r.PathValue("id"))
+	if list == nil {
+		writeCloudflareError(w, http.StatusNotFound, 7003, "list not found")
+		return
+	}
+	now := time.Now().UTC()
+	if body.Replace != nil {
+		list.Items = replaceItems(list.Items, *body.Replace, now)
+	}
+	if len(body.Remove) > 0 {
+		remove := make(map[string]struct{}, len(body.Remove))
+		for _, value := range body.Remove {
+			remove[value] = struct{}{}
+		}
+		kept := list.Items[:0]
+		for _, item := range list.Items {
+			if _, ok := remove[item.Value]; !ok {
+				kept = append(kept, item)
+			}
+		}
+		list.Items = kept
+	}
+	for _, item := range body.Append {
+		replaced := false
+		for i := range list.Items {
+			if list.Items[i].Value == item.Value {
+				list.Items[i].Comment = item.Comment
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			item.CreatedAt = now
+			list.Items = append(list.Items, item)
+		}
+	}
+	if body.Description != nil {
+		list.Description = *body.Description
+	}
+	list.Count = len(list.Items)
+	list.UpdatedAt = now
+	writeCloudflareResult(w, list.metadata())
+}
+
+// replaceItems returns the items of a list replaced with the given items. Items whose
+// value was already listed keep their ID and creation time.
+func replaceItems(current, replacement []cloudflareItem, now time.Time) []cloudflareItem {
+	existing := make(map[string]cloudflareItem, len(current))
+	for _, item := range current {
+		existing[item.Value] = item
+	}
+	items := make([]cloudflareItem, 0, len(replacement))
+	for _, item := range replacement {
+		if old, ok := existing[item.Value]; ok {
+			item.ID, item.CreatedAt = old.ID, old.CreatedAt
+		} else {
+			item.CreatedAt = now
+		}
+		items = append(items, item)
+	}
+	return items
+}
@@ -0,0 +1,225 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// kandjiPageSize is the page size of the fake device list when no limit is requested.
+const kandjiPageSize = 300
+
+type kandjiNote struct {
+	NoteID  string `json:"note_id"`
+	Content string `json:"content"`
+}
+
+// kandjiAPI fakes the Kandji device endpoints used by the sync. Devices are kept as raw
+// JSON objects so fixtures can be pasted from real API responses.
+type kandjiAPI struct {
+	mu         sync.Mutex
+	devices    []map[string]any
+	byID       map[string]map[string]any
+	details    map[string]any
+	notes      map[string][]kandjiNote
+	nextNoteID int
+}
+
+// newKandjiAPI seeds the fake from a JSON array of device records. A record may carry the
+// device's details under "details", which are served by the details endpoint.
+func newKandjiAPI(data []byte) (*kandjiAPI, error) {
+	var devices []map[string]any
+	if err := json.Unmarshal(data, &devices); err != nil {
+		return nil, fmt.Errorf("expected a JSON array of devices: %w", err)
+	}
+	api := &kandjiAPI{
+		byID:    make(map[string]map[string]any),
+		details: make(map[string]any),
+		notes:   make(map[string][]kandjiNote),
+	}
+	for i, device := range devices {
+		if serial, _ := device["serial_number"].(string); serial == "" {
+			return nil, fmt.Errorf("device %d has no serial_number", i)
+		}
+		id, _ := device["device_id"].(string)
+		if id == "" {
+			id = newUUID()
+			device["device_id"] = id
+		}
+		if _, dup := api.byID[id]; dup {
+			return nil, fmt.Errorf("duplicate device_id %q", id)
+		}
+		if details, ok := device["details"]; ok {
+			api.details[id] = details
+			delete(device, "details")
+		}
+		api.devices = append(api.devices, device)
+		api.byID[id] = device
+	}
+	return api, nil
+}
+
+func (k *kandjiAPI) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/devices", k.listDevices)
+	mux.HandleFunc("PATCH /api/v1/devices/{id}", k.updateDevice)
+	mux.HandleFunc("GET /api/v1/devices/{id}/details", k.deviceDetails)
+	mux.HandleFunc("GET /api/v1/devices/{id}/notes", k.listNotes)
+	mux.HandleFunc("POST /api/v1/devices/{id}/notes", k.createNote)
+	mux.HandleFunc("PATCH /api/v1/devices/{id}/notes/{noteID}", k.updateNote)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r) {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"detail": "Authentication credentials were not provided."})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (k *kandjiAPI) listDevices(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = kandjiPageSize
+	}
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	devices := k.devices
+	// ordering sorts by a field, descending with a leading "-", as the real API does
+	if ordering := r.URL.Query().Get("ordering"); ordering != "" {
+		field := strings.TrimPrefix(ordering, "-")
+		devices = slices.Clone(devices)
+		slices.SortStableFunc(devices, func(a, b map[string]any) int {
+			x, _ := a[field].(string)
+			y, _ := b[field].(string)
+			if field != ordering {
+				return strings.Compare(y, x)
+			}
+			return strings.Compare(x, y)
+		})
+	}
+	end := min(offset+limit, len(devices))
+	results := []map[string]any{}
+	if offset < end {
+		results = devices[offset:end]
+	}
+	var next, previous *string
+	if end < len(devices) {
+		u := pageURL(r, limit, end)
+		next = &u
+	}
+	if offset > 0 {
+		u := pageURL(r, limit, max(offset-limit, 0))
+		previous = &u
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"count":    len(devices),
+		"next":     next,
+		"previous": previous,
+		"results":  results,
+	})
+}
+
+// pageURL returns the URL of the page at offset of the device list requested by r, with
+// its other query parameters.
+func pageURL(r *http.Request, limit, offset int) string {
+	query := r.URL.Query()
+	query.Set("limit", strconv.Itoa(limit))
+	query.Set("offset", strconv.Itoa(offset))
+	return fmt.Sprintf("http://%s/api/v1/devices?%s", r.Host, query.Encode())
+}
+
+func (k *kandjiAPI) updateDevice(w http.ResponseWriter, r *http.Request) {
+	var update map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"detail": "Invalid JSON body."})
+		return
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	device, ok := k.byID[r.PathValue("id")]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"detail": "Not found."})
+		return
+	}
+	for key, value := range update {
+		device[key] = value
+	}
+	writeJSON(w, http.StatusOK, device)
+}
+
+func (k *kandjiAPI) deviceDetails(w http.ResponseWriter, r *http.Request) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	id := r.PathValue("id")
+	if _, ok := k.byID[id]; !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"detail": "Not found."})
+		return
+	}
+	details, ok := k.details[id]
+	if !ok {
+		details = map[string]any{}
+	}
+	writeJSON(w, http.StatusOK, details)
+}
+
+func (k *kandjiAPI) listNotes(w http.ResponseWriter, r *http.Request) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	id := r.PathValue("id")
+	if _, ok := k.byID[id]; !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"detail": "Not found."})
+		return
+	}
+	notes := k.notes[id]
+	if notes == nil {
+		notes = []kandjiNote{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"notes": notes})
+}
+
+func (k *kandjiAPI) createNote(w http.ResponseWriter, r *http.Request) {
+	var body kandjiNote
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"detail": "Invalid JSON body."})
+		return
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	id := r.PathValue("id")
+	if _, ok := k.byID[id]; !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"detail": "Not found."})
+		return
+	}
+	k.nextNoteID++
+	note := kandjiNote{NoteID: strconv.Itoa(k.nextNoteID), Content: body.Content}
+	k.notes[id] = append(k.notes[id], note)
+	writeJSON(w, http.StatusCreated, note)
+}
+
+func (k *kandjiAPI) updateNote(w http.ResponseWriter, r *http.Request) {
+	var body kandjiNote
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"detail": "Invalid JSON body."})
+		return
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	notes := k.notes[r.PathValue("id")]
+	for i := range notes {
+		if notes[i].NoteID == r.PathValue("noteID") {
+			notes[i].Content = body.Content
+			writeJSON(w, http.StatusOK, notes[i])
+			return
+		}
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"detail": "Not found."})
+}
@@ -0,0 +1,295 @@
+package sandbox
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// rulesList is a list as returned by the Rules List endpoints.
+type rulesList struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Kind        string    `json:"kind"`
+	NumItems    int       `json:"num_items"`
+	CreatedOn   time.Time `json:"created_on"`
+	ModifiedOn  time.Time `json:"modified_on"`
+}
+
+type rulesHostname struct {
+	URLHostname string `json:"url_hostname"`
+}
+
+// rulesItem is a list item as sent to and returned by the Rules List endpoints.
+type rulesItem struct {
+	ID        string         `json:"id,omitempty"`
+	IP        string         `json:"ip,omitempty"`
+	Hostname  *rulesHostname `json:"hostname,omitempty"`
+	Comment   string         `json:"comment,omitempty"`
+	CreatedOn time.Time      `json:"created_on"`
+}
+
+// handleRules registers the Rules List endpoints. Item mutations are applied at once and
+// report an operation that has already completed.
+func (c *cloudflareAPI) handleRules(mux *http.ServeMux) {
+	mux.HandleFunc("GET "+cloudflarePrefix+"/accounts/{account}/rules/lists", c.listRulesLists)
+	mux.HandleFunc("POST "+cloudflarePrefix+"/accounts/{account}/rules/lists", c.createRulesList)
+	// Matches bulk_operations/{operation}, a pattern that would conflict with {id}/items
+	mux.HandleFunc("GET "+cloudflarePrefix+"/accounts/{account}/rules/lists/{id}/{operation}", c.getRulesOperation)
+	mux.HandleFunc("GET "+cloudflarePrefix+"/accounts/{account}/rules/lists/{id}", c.getRulesList)
+	mux.HandleFunc("GET "+cloudflarePrefix+"/accounts/{account}/rules/lists/{id}/items", c.listRulesItems)
+	mux.HandleFunc("POST "+cloudflarePrefix+"/accounts/{account}/rules/lists/{id}/items", c.appendRulesItems)
+	mux.HandleFunc("PUT "+cloudflarePrefix+"/accounts/{account}/rules/lists/{id}/items", c.replaceRulesItems)
+	mux.HandleFunc("DELETE "+cloudflarePrefix+"/accounts/{account}/rules/lists/{id}/items", c.deleteRulesItems)
+}
+
+// newRulesID returns a random Rules List or item ID, 32 hex digits.
+func newRulesID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// findRules returns the Rules List with the given ID. The caller must hold c.mu.
+func (c *cloudflareAPI) findRules(id string) *cloudflareList {
+	for _, list := range c.lists {
+		if list.ID == id && list.Kind != "" {
+			return list
+		}
+	}
+	return nil
+}
+
+func (l *cloudflareList) rulesMetadata() rulesList {
+	return rulesList{
+		ID:          l.ID,
+		Name:        l.Name,
+		Description: l.Description,
+		Kind:        l.Kind,
+		NumItems:    len(l.Items),
+		CreatedOn:   l.CreatedAt,
+		ModifiedOn:  l.UpdatedAt,
+	}
+}
+
+func (l *cloudflareList) rulesItem(item cloudflareItem) rulesItem {
+	result := rulesItem{ID: item.ID, Comment: item.Comment, CreatedOn: item.CreatedAt}
+	if l.Kind == "hostname" {
+		result.Hostname = &rulesHostname{URLHostname: item.Value}
+	} else {
+		result.IP = item.Value
+	}
+	return result
+}
+
+// rulesValue returns the value of an item sent to the list, false if a hostname list item
+// has no hostname.
+func (l *cloudflareList) rulesValue(item rulesItem) (string, bool) {
+	if l.Kind != "hostname" {
+		return item.IP, true
+	}
+	if item.Hostname == nil {
+		return "", false
+	}
+	return item.Hostname.URLHostname, true
+}
+
+func writeRulesOperation(w http.ResponseWriter) {
+	writeCloudflareResult(w, map[string]string{"operation_id": newRulesID()})
+}
+
+func (c *cloudflareAPI) listRulesLists(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result := []rulesList{}
+	for _, list := range c.lists {
+		if list.Kind != "" {
+			result = append(result, list.rulesMetadata())
+		}
+	}
+	writeCloudflareResult(w, result)
+}
+
+func (c *cloudflareAPI) createRulesList(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Kind        string `json:"kind"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeCloudflareError(w, http.StatusBadRequest, 2001, "Invalid JSON body")
+		return
+	}
+	if body.Name == "" || (body.Kind != "ip" && body.Kind != "hostname") {
+		writeCloudflareError(w, http.StatusBadRequest, 2001, "name and a kind of ip or hostname are required")
+		return
+	}
+	now := time.Now().UTC()
+	list := &cloudflareList{ID: newRulesID(), Name: body.Name, Description: body.Description, Kind: body.Kind, CreatedAt: now, UpdatedAt: now}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lists = append(c.lists, list)
+	writeCloudflareResult(w, list.rulesMetadata())
+}
+
+func (c *cloudflareAPI) getRulesOperation(w http.ResponseWriter, r *http.Request) {
+	if r.PathValue("id") != "bulk_operations" {
+		writeCloudflareError(w, http.StatusNotFound, 10000, "not found")
+		return
+	}
+	writeCloudflareResult(w, map[string]string{"id": r.PathValue("operation"), "status": "completed"})
+}
+
+func (c *cloudflareAPI) getRulesList(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	list := c.findRules(r.PathValue("id"))
+	if list == nil {
+		writeCloudflareError(w, http.StatusNotFound, 10000, "list not found")
+		return
+	}
+	writeCloudflareResult(w, list.rulesMetadata())
+}
+
+// listRulesItems pages with a cursor that is the offset of the next item.
+func (c *cloudflareAPI) listRulesItems(w http.ResponseWriter, r *http.Request) {
+	perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if perPage < 1 || perPage > 500 {
+		perPage = 500
+	}
+	start, _ := strconv.Atoi(r.URL.Query().Get("cursor"))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	list := c.findRules(r.PathValue("id"))
+	if list == nil {
+		writeCloudflareError(w, http.StatusNotFound, 10000, "list not found")
+		return
+	}
+	start = min(max(start, 0), len(list.Items))
+	end := min(start+perPage, len(list.Items))
+	result := make([]rulesItem, 0, end-start)
+	for _, item := range list.Items[start:end] {
+		result = append(result, list.rulesItem(item))
+	}
+	cursors := map[string]string{}
+	if end < len(list.Items) {
+		cursors["after"] = strconv.Itoa(end)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"success":     true,
+		"errors":      []cloudflareError{},
+		"messages":    []any{},
+		"result":      result,
+		"result_info": map[string]any{"cursors": cursors},
+	})
+}
+
+// appendRulesItems adds items, replacing the comment of values that are already listed.
+func (c *cloudflareAPI) appendRulesItems(w http.ResponseWriter, r *http.Request) {
+	var body []rulesItem
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeCloudflareError(w, http.StatusBadRequest, 2001, "Invalid JSON body")
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	list := c.findRules(r.PathValue("id"))
+	if list == nil {
+		writeCloudflareError(w, http.StatusNotFound, 10000, "list not found")
+		return
+	}
+	now := time.Now().UTC()
+	for _, item := range body {
+		value, ok := list.rulesValue(item)
+		if !ok {
+			writeCloudflareError(w, http.StatusBadRequest, 10021, "hostname list items need a hostname")
+			return
+		}
+		replaced := false
+		for i := range list.Items {
+			if list.Items[i].Value == value {
+				list.Items[i].Comment = item.Comment
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			list.Items = append(list.Items, cloudflareItem{ID: newRulesID(), Value: value, Comment: item.Comment, CreatedAt: now})
+		}
+	}
+	list.Count = len(list.Items)
+	list.UpdatedAt = now
+	writeRulesOperation(w)
+}
+
+// replaceRulesItems replaces all items of a list.
+func (c *cloudflareAPI) replaceRulesItems(w http.ResponseWriter, r *http.Request) {
+	var body []rulesItem
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeCloudflareError(w, http.StatusBadRequest, 2001, "Invalid JSON body")
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	list := c.findRules(r.PathValue("id"))
+	if list == nil {
+		writeCloudflareError(w, http.StatusNotFound, 10000, "list not found")
+		return
+	}
+	replacement := make([]cloudflareItem, 0, len(body))
+	for _, item := range body {
+		value, ok := list.rulesValue(item)
+		if !ok {
+			writeCloudflareError(w, http.StatusBadRequest, 10021, "hostname list items need a hostname")
+			return
+		}
+		replacement = append(replacement, cloudflareItem{ID: newRulesID(), Value: value, Comment: item.Comment})
+	}
+	now := time.Now().UTC()
+	list.Items = replaceItems(list.Items, replacement, now)
+	list.Count = len(list.Items)
+	list.UpdatedAt = now
+	writeRulesOperation(w)
+}
+
+func (c *cloudflareAPI) deleteRulesItems(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Items []struct {
+			ID string `json:"id"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeCloudflareError(w, http.StatusBadRequest, 2001, "Invalid JSON body")
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	list := c.findRules(r.PathValue("id"))
+	if list == nil {
+		writeCloudflareError(w, http.StatusNotFound, 10000, "list not found")
+		return
+	}
+	remove := make(map[string]struct{}, len(body.Items))
+	for _, item := range body.Items {
+		remove[item.ID] = struct{}{}
+	}
+	kept := list.Items[:0]
+	for _, item := range list.Items {
+		if _, ok := remove[item.ID]; !ok {
+			kept = append(kept, item)
+		}
+	}
+	list.Items = kept
+	list.Count = len(list.Items)
+	list.UpdatedAt = time.Now().UTC()
+	writeRulesOperation(w)
+}
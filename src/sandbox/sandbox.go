@@ -0,0 +1,192 @@
+// Package sandbox runs fake Kandji and Cloudflare APIs in-process, seeded from fixture files,
+// so the real sync logic can be exercised without any credentials.
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Fixture file names, looked up in the fixtures directory.
+const (
+	KandjiDevicesFile   = "kandji_devices.json"
+	CloudflareListsFile = "cloudflare_lists.json"
+)
+
+//go:embed fixtures/*.json
+var builtinFixtures embed.FS
+
+// Sandbox is a pair of running fake APIs.
+type Sandbox struct {
+	kandji     *fakeServer
+	cloudflare *fakeServer
+}
+
+// Start loads the fixtures from dir, falling back to the built-in fixtures for files that
+// are missing or if dir is empty, and starts the fake APIs on loopback addresses.
+func Start(dir string, log *slog.Logger) (*Sandbox, error) {
+	devicesData, err := readFixture(dir, KandjiDevicesFile)
+	if err != nil {
+		return nil, err
+	}
+	kandji, err := newKandjiAPI(devicesData)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", KandjiDevicesFile, err)
+	}
+	listsData, err := readFixture(dir, CloudflareListsFile)
+	if err != nil {
+		return nil, err
+	}
+	cloudflare, err := newCloudflareAPI(listsData)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", CloudflareListsFile, err)
+	}
+
+	s := &Sandbox{}
+	if s.kandji, err = startServer(kandji.handler(), log.With("api", "kandji")); err != nil {
+		return nil, err
+	}
+	if s.cloudflare, err = startServer(cloudflare.handler(), log.With("api", "cloudflare")); err != nil {
+		s.kandji.close()
+		return nil, err
+	}
+	log.Info("Sandbox APIs started", "kandji_url", s.KandjiURL(), "cloudflare_url", s.CloudflareURL(),
+		"devices", len(kandji.devices), "lists", len(cloudflare.lists))
+	return s, nil
+}
+
+// KandjiURL is the base URL of the fake Kandji API.
+func (s *Sandbox) KandjiURL() string {
+	return s.kandji.url
+}
+
+// CloudflareURL is the base URL of the fake Cloudflare API, including the /client/v4 prefix.
+func (s *Sandbox) CloudflareURL() string {
+	return s.cloudflare.url + cloudflarePrefix
+}
+
+// Close stops the fake APIs.
+func (s *Sandbox) Close() {
+	s.kandji.close()
+	s.cloudflare.close()
+}
+
+// readFixture reads a fixture file from dir, or the built-in one if it is not there.
+func readFixture(dir, name string) ([]byte, error) {
+	if dir != "" {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err == nil {
+			return data, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read sandbox fixture: %w", err)
+		}
+	}
+	return builtinFixtures.ReadFile("fixtures/" + name)
+}
+
+type fakeServer struct {
+	url    string
+	server *http.Server
+}
+
+func startServer(handler http.Handler, log *slog.Logger) (*fakeServer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start sandbox API: %w", err)
+	}
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log.Debug("Sandbox API request", "method", r.Method, "path", r.URL.RequestURI())
+			if r.Method == http.MethodGet {
+				serveWithETag(handler, w, r)
+				return
+			}
+			handler.ServeHTTP(w, r)
+		}),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error("Sandbox API stopped", "error", err)
+		}
+	}()
+	return &fakeServer{url: "http://" + listener.Addr().String(), server: server}, nil
+}
+
+func (f *fakeServer) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = f.server.Shutdown(ctx)
+}
+
+// serveWithETag serves a GET request with an ETag, the hash of the response, and answers it
+// with 304 Not Modified if the request carries the same ETag in If-None-Match, like the real
+// APIs may.
+func serveWithETag(handler http.Handler, w http.ResponseWriter, r *http.Request) {
+	buffered := &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+	handler.ServeHTTP(buffered, r)
+	for name, values := range buffered.header {
+		w.Header()[name] = values
+	}
+	if buffered.status == http.StatusOK {
+		sum := sha256.Sum256(buffered.body.Bytes())
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.Header().Del("Content-Type")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.WriteHeader(buffered.status)
+	_, _ = w.Write(buffered.body.Bytes())
+}
+
+// bufferedResponse keeps a response in memory, see serveWithETag.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) WriteHeader(status int) { b.status = status }
+
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+
+// authorized reports whether the request carries a bearer token; the fake APIs accept any.
+func authorized(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	return len(auth) > len("Bearer ") && auth[:len("Bearer ")] == "Bearer "
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// newUUID returns a random version 4 UUID.
+func newUUID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,117 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"kandji-cloudflare-device-sync/internal/awsauth"
+)
+
+// AWSSecretsManagerOptions configures an AWS Secrets Manager backend.
+type AWSSecretsManagerOptions struct {
+	// Region is the region of secrets referenced by name; an ARN names its own region
+	Region string
+	// Endpoint replaces https://secretsmanager.<region>.amazonaws.com, e.g. for a VPC endpoint
+	Endpoint    string
+	Credentials awsauth.Credentials
+	HTTPClient  *http.Client
+}
+
+// AWSSecretsManager reads secrets from AWS Secrets Manager. A reference is the ARN or name of
+// a secret, optionally followed by the key of a value of a JSON secret, e.g.
+// arn:aws:secretsmanager:eu-west-1:123456789012:secret:kandji-AbCdEf#api_token. Without a
+// key the whole secret string is the secret.
+type AWSSecretsManager struct {
+	opts       AWSSecretsManagerOptions
+	httpClient *http.Client
+}
+
+// NewAWSSecretsManager returns an AWS Secrets Manager backend.
+func NewAWSSecretsManager(opts AWSSecretsManagerOptions) *AWSSecretsManager {
+	opts.Endpoint = strings.TrimSuffix(opts.Endpoint, "/")
+	httpClient := opts.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &AWSSecretsManager{opts: opts, httpClient: httpClient}
+}
+
+// Fetch reads the current version of a secret.
+func (a *AWSSecretsManager) Fetch(ctx context.Context, ref string) (string, error) {
+	secretID, key, _ := strings.Cut(ref, "#")
+	if secretID == "" {
+		return "", fmt.Errorf("reference must be the ARN or name of a secret")
+	}
+	region := a.opts.Region
+	// arn:aws:secretsmanager:<region>:<account>:secret:<name>
+	if parts := strings.SplitN(secretID, ":", 5); len(parts) == 5 && parts[0] == "arn" {
+		region = parts[3]
+	}
+	if region == "" {
+		return "", fmt.Errorf("no region for secret %s, reference it by ARN or set the region", secretID)
+	}
+	endpoint := a.opts.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://secretsmanager.%s.amazonaws.com", region)
+	}
+
+	body, err := json.Marshal(map[string]string{"SecretId": secretID})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	if err := awsauth.Sign(req, body, a.opts.Credentials, "secretsmanager", region, time.Now()); err != nil {
+		return "", err
+	}
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HTTP %d - %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	var response struct {
+		SecretString *string `json:"SecretString"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+	if response.SecretString == nil {
+		return "", fmt.Errorf("secret is binary, only secret strings are supported")
+	}
+	if key == "" {
+		return *response.SecretString, nil
+	}
+	return jsonSecretKey(*response.SecretString, key)
+}
+
+// jsonSecretKey returns the value of a key of a secret that is a JSON object.
+func jsonSecretKey(secret, key string) (string, error) {
+	var values map[string]any
+	if err := json.Unmarshal([]byte(secret), &values); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object, reference it without #%s", key)
+	}
+	value, ok := values[key]
+	if !ok {
+		return "", fmt.Errorf("secret has no key %q", key)
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("key %q of the secret is not a string", key)
+	}
+	return s, nil
+}
@@ -0,0 +1,71 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"kandji-cloudflare-device-sync/internal/azureauth"
+)
+
+const azureKeyVaultResource = "https://vault.azure.net"
+
+// AzureKeyVault reads secrets from Azure Key Vault. A reference is the host name of a vault,
+// the name of a secret and optionally its version, followed by the key of a value of a JSON
+// secret if needed, e.g. //acme.vault.azure.net/secrets/kandji-api-token. Without a version
+// the current version is read.
+type AzureKeyVault struct {
+	tokenSource *azureauth.TokenSource
+	httpClient  *http.Client
+}
+
+// NewAzureKeyVault returns an Azure Key Vault backend authenticating as the identity of opts.
+func NewAzureKeyVault(opts azureauth.Options) *AzureKeyVault {
+	return &AzureKeyVault{
+		tokenSource: azureauth.NewTokenSource(opts, azureKeyVaultResource),
+		httpClient:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Fetch reads the value of a secret.
+func (a *AzureKeyVault) Fetch(ctx context.Context, ref string) (string, error) {
+	name, key, _ := strings.Cut(strings.TrimPrefix(ref, "//"), "#")
+	host, path, _ := strings.Cut(name, "/")
+	parts := strings.Split(path, "/")
+	if host == "" || len(parts) < 2 || len(parts) > 3 || parts[0] != "secrets" || parts[1] == "" {
+		return "", fmt.Errorf("reference must be a secret, as in azure-kv://<vault>.vault.azure.net/secrets/<name>")
+	}
+	token, err := a.tokenSource.Token(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get Azure access token: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+host+"/"+path+"?api-version=7.4", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HTTP %d - %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	var response struct {
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+	if key == "" {
+		return response.Value, nil
+	}
+	return jsonSecretKey(response.Value, key)
+}
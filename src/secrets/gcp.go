@@ -0,0 +1,124 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"kandji-cloudflare-device-sync/internal/gcpauth"
+)
+
+const (
+	gcpSecretManagerEndpoint = "https://secretmanager.googleapis.com"
+	gcpCloudPlatformScope    = "https://www.googleapis.com/auth/cloud-platform"
+)
+
+// GCPSecretManagerOptions configures a GCP Secret Manager backend.
+type GCPSecretManagerOptions struct {
+	// CredentialsFile is a service account key or gcloud user credentials; Application
+	// Default Credentials are used if empty
+	CredentialsFile string
+	// Endpoint replaces https://secretmanager.googleapis.com, e.g. for a regional endpoint
+	Endpoint   string
+	HTTPClient *http.Client
+}
+
+// GCPSecretManager reads secrets from Google Cloud Secret Manager. A reference is the
+// resource name of a secret version, optionally followed by the key of a value of a JSON
+// secret, e.g. //projects/acme/secrets/kandji/versions/latest#api_token. The credentials are
+// loaded when the first secret is read.
+type GCPSecretManager struct {
+	opts       GCPSecretManagerOptions
+	httpClient *http.Client
+
+	mu          sync.Mutex
+	tokenSource gcpauth.Source
+}
+
+// NewGCPSecretManager returns a GCP Secret Manager backend.
+func NewGCPSecretManager(opts GCPSecretManagerOptions) *GCPSecretManager {
+	opts.Endpoint = strings.TrimSuffix(opts.Endpoint, "/")
+	if opts.Endpoint == "" {
+		opts.Endpoint = gcpSecretManagerEndpoint
+	}
+	httpClient := opts.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &GCPSecretManager{opts: opts, httpClient: httpClient}
+}
+
+// Fetch reads the payload of a secret version.
+func (g *GCPSecretManager) Fetch(ctx context.Context, ref string) (string, error) {
+	name, key, _ := strings.Cut(strings.TrimPrefix(ref, "//"), "#")
+	if !strings.HasPrefix(name, "projects/") || !strings.Contains(name, "/secrets/") {
+		return "", fmt.Errorf("reference must be a secret version, as in gcp-sm://projects/<project>/secrets/<secret>/versions/latest")
+	}
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	tokenSource, err := g.source()
+	if err != nil {
+		return "", err
+	}
+	token, err := tokenSource.Token(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get GCP access token: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.opts.Endpoint+"/v1/"+name+":access", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HTTP %d - %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	var response struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+	payload, err := base64.StdEncoding.DecodeString(response.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode secret payload: %w", err)
+	}
+	if key == "" {
+		return string(payload), nil
+	}
+	return jsonSecretKey(string(payload), key)
+}
+
+// source returns the token source of the configured credentials, loading them on first use.
+func (g *GCPSecretManager) source() (gcpauth.Source, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.tokenSource != nil {
+		return g.tokenSource, nil
+	}
+	var err error
+	if g.opts.CredentialsFile != "" {
+		g.tokenSource, err = gcpauth.NewFileTokenSource(g.opts.CredentialsFile, gcpCloudPlatformScope)
+	} else {
+		g.tokenSource, err = gcpauth.NewDefaultTokenSource(gcpCloudPlatformScope)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load GCP credentials: %w", err)
+	}
+	return g.tokenSource, nil
+}
@@ -0,0 +1,70 @@
+// Package secrets resolves secret references in configuration values, such as
+// vault:secret/data/kandji#api_token, by reading the secret from the backend named by the
+// reference's scheme.
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Backend reads the secrets of one reference scheme.
+type Backend interface {
+	// Fetch returns the secret of a reference, without its scheme
+	Fetch(ctx context.Context, ref string) (string, error)
+}
+
+// Schemes are the reference schemes a configuration value may start with.
+var Schemes = []string{"vault", "aws-sm", "gcp-sm", "azure-kv"}
+
+// Reference splits a configuration value into the scheme and the reference of a secret. ok is
+// false if the value is not a secret reference, but the secret itself.
+func Reference(value string) (scheme, ref string, ok bool) {
+	scheme, ref, found := strings.Cut(value, ":")
+	if !found {
+		return "", "", false
+	}
+	for _, known := range Schemes {
+		if scheme == known {
+			return scheme, ref, true
+		}
+	}
+	return "", "", false
+}
+
+// Resolver reads secret references from the backends registered for their schemes.
+type Resolver struct {
+	backends map[string]Backend
+}
+
+// NewResolver returns a resolver without backends.
+func NewResolver() *Resolver {
+	return &Resolver{backends: make(map[string]Backend)}
+}
+
+// Register makes backend read the references of scheme.
+func (r *Resolver) Register(scheme string, backend Backend) {
+	r.backends[scheme] = backend
+}
+
+// Resolve returns the secret a configuration value refers to, or the value itself if it is
+// not a secret reference.
+func (r *Resolver) Resolve(ctx context.Context, value string) (string, error) {
+	scheme, ref, ok := Reference(value)
+	if !ok {
+		return value, nil
+	}
+	backend, ok := r.backends[scheme]
+	if !ok {
+		return "", fmt.Errorf("%s secret backend is not configured", scheme)
+	}
+	secret, err := backend.Fetch(ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s secret %s: %w", scheme, ref, err)
+	}
+	if secret == "" {
+		return "", fmt.Errorf("%s secret %s is empty", scheme, ref)
+	}
+	return secret, nil
+}
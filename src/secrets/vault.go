@@ -0,0 +1,196 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Vault auth methods.
+const (
+	VaultAuthToken      = "token"
+	VaultAuthAppRole    = "approle"
+	VaultAuthKubernetes = "kubernetes"
+)
+
+// DefaultKubernetesTokenPath is where Kubernetes mounts the service account token of a pod.
+const DefaultKubernetesTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// VaultOptions configures a Vault backend.
+type VaultOptions struct {
+	// Address is the URL of the Vault server, e.g. https://vault.example.com:8200
+	Address   string
+	Namespace string
+	// AuthMethod is VaultAuthToken, VaultAuthAppRole or VaultAuthKubernetes
+	AuthMethod string
+	// Token is the Vault token of the token auth method
+	Token string
+	// AuthMount is the path the auth method is mounted at, its name if empty
+	AuthMount string
+	// RoleID and SecretID log in with the AppRole auth method
+	RoleID   string
+	SecretID string
+	// Role logs in with the Kubernetes auth method, with the service account token read
+	// from KubernetesTokenPath
+	Role                string
+	KubernetesTokenPath string
+	HTTPClient          *http.Client
+}
+
+// Vault reads secrets from a HashiCorp Vault KV secrets engine, version 1 or 2. A reference
+// is the API path of the secret and the key of the value, e.g. secret/data/kandji#api_token
+// for the key api_token of the KV version 2 secret kandji mounted at secret.
+type Vault struct {
+	opts       VaultOptions
+	httpClient *http.Client
+
+	mu sync.Mutex
+	// token is the Vault token of the last login, valid until expiry; zero if it does not
+	// expire
+	token  string
+	expiry time.Time
+}
+
+// NewVault returns a Vault backend.
+func NewVault(opts VaultOptions) *Vault {
+	opts.Address = strings.TrimSuffix(opts.Address, "/")
+	if opts.AuthMount == "" {
+		opts.AuthMount = opts.AuthMethod
+	}
+	if opts.KubernetesTokenPath == "" {
+		opts.KubernetesTokenPath = DefaultKubernetesTokenPath
+	}
+	httpClient := opts.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &Vault{opts: opts, httpClient: httpClient}
+}
+
+// Fetch reads the value of a key of a secret.
+func (v *Vault) Fetch(ctx context.Context, ref string) (string, error) {
+	path, key, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || key == "" {
+		return "", fmt.Errorf("reference must be the path of the secret and a key, as in secret/data/kandji#api_token")
+	}
+	token, err := v.login(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var response struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := v.do(ctx, http.MethodGet, "/v1/"+strings.TrimPrefix(path, "/"), token, nil, &response); err != nil {
+		return "", err
+	}
+	data := response.Data
+	// KV version 2 nests the secret's data next to its metadata
+	if inner, ok := data["data"].(map[string]any); ok {
+		if _, versioned := data["metadata"]; versioned {
+			data = inner
+		}
+	}
+	value, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("secret has no key %q", key)
+	}
+	secret, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("key %q of the secret is not a string", key)
+	}
+	return secret, nil
+}
+
+// login returns the Vault token to read secrets with, logging in again with the AppRole or
+// Kubernetes auth method shortly before the last token expires.
+func (v *Vault) login(ctx context.Context) (string, error) {
+	if v.opts.AuthMethod == VaultAuthToken {
+		return v.opts.Token, nil
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.token != "" && (v.expiry.IsZero() || time.Until(v.expiry) > time.Minute) {
+		return v.token, nil
+	}
+
+	var body map[string]string
+	switch v.opts.AuthMethod {
+	case VaultAuthAppRole:
+		body = map[string]string{"role_id": v.opts.RoleID, "secret_id": v.opts.SecretID}
+	case VaultAuthKubernetes:
+		jwt, err := os.ReadFile(v.opts.KubernetesTokenPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to read Kubernetes service account token: %w", err)
+		}
+		body = map[string]string{"role": v.opts.Role, "jwt": strings.TrimSpace(string(jwt))}
+	default:
+		return "", fmt.Errorf("unknown Vault auth method %q", v.opts.AuthMethod)
+	}
+
+	var response struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	if err := v.do(ctx, http.MethodPost, "/v1/auth/"+v.opts.AuthMount+"/login", "", body, &response); err != nil {
+		return "", fmt.Errorf("failed to log in to Vault with %s: %w", v.opts.AuthMethod, err)
+	}
+	if response.Auth.ClientToken == "" {
+		return "", fmt.Errorf("failed to log in to Vault with %s: no token in the response", v.opts.AuthMethod)
+	}
+	v.token = response.Auth.ClientToken
+	v.expiry = time.Time{}
+	if response.Auth.LeaseDuration > 0 {
+		v.expiry = time.Now().Add(time.Duration(response.Auth.LeaseDuration) * time.Second)
+	}
+	return v.token, nil
+}
+
+// do sends a request to the Vault API and decodes its JSON response into out.
+func (v *Vault) do(ctx context.Context, method, path, token string, body any, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, v.opts.Address+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if v.opts.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.opts.Namespace)
+	}
+
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d - %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package state
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// DeviceRecord is when a device was first and last seen by a successful cycle.
+type DeviceRecord struct {
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// AppliedDiff is the last non-empty change a successful cycle applied to the target list.
+type AppliedDiff struct {
+	At      time.Time `json:"at"`
+	Added   []string  `json:"added,omitempty"`
+	Removed []string  `json:"removed,omitempty"`
+}
+
+// RecordSeen marks the given serials as seen at the given time and drops devices not seen
+// within the retention period. The devices are written to disk with the next save.
+func (s *Store) RecordSeen(serials []string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.data.Devices == nil {
+		s.data.Devices = make(map[string]DeviceRecord)
+	}
+	seen := make(map[string]DeviceRecord, len(serials))
+	for _, serial := range serials {
+		record, ok := s.data.Devices[serial]
+		if !ok {
+			record.FirstSeen = at
+		}
+		record.LastSeen = at
+		s.data.Devices[serial] = record
+		seen[serial] = record
+	}
+	var expired []string
+	if s.retention > 0 {
+		cutoff := at.Add(-s.retention)
+		for serial, record := range s.data.Devices {
+			if record.LastSeen.Before(cutoff) {
+				delete(s.data.Devices, serial)
+				expired = append(expired, serial)
+			}
+		}
+	}
+	s.write(func(tx *bolt.Tx) error {
+		return updateMap(tx, devicesBucket, seen, expired)
+	})
+	return nil
+}
+
+// Device returns when the device with the given serial was first and last seen. Serials
+// are compared case-insensitively.
+func (s *Store) Device(serial string) (DeviceRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if record, ok := s.data.Devices[serial]; ok {
+		return record, true
+	}
+	for recorded, record := range s.data.Devices {
+		if strings.EqualFold(recorded, serial) {
+			return record, true
+		}
+	}
+	return DeviceRecord{}, false
+}
+
+// DeviceSerials returns the serials of all recorded devices, sorted.
+func (s *Store) DeviceSerials() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	serials := make([]string, 0, len(s.data.Devices))
+	for serial := range s.data.Devices {
+		serials = append(serials, serial)
+	}
+	sort.Strings(serials)
+	return serials
+}
+
+// LastSuccess returns when the last successful cycle finished, zero if none has.
+func (s *Store) LastSuccess() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.LastSuccessAt
+}
+
+// LastDiff returns the last non-empty change applied to the target list, or nil.
+func (s *Store) LastDiff() *AppliedDiff {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.LastDiff
+}
+
+// Misses returns the number of consecutive cycles each serial of the target list has been
+// missing from all sources, as last recorded by RecordMisses.
+func (s *Store) Misses() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	misses := make(map[string]int, len(s.data.Misses))
+	for serial, count := range s.data.Misses {
+		misses[serial] = count
+	}
+	return misses
+}
+
+// RecordMisses replaces the consecutive miss counts with the given ones, which forgets the
+// serials that are no longer missing. The counts are written to disk with the next save.
+func (s *Store) RecordMisses(misses map[string]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Misses = misses
+	s.write(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(missesBucket)
+		if err != nil {
+			return err
+		}
+		return replaceMap(b, misses)
+	})
+	return nil
+}
+
+// ListMisses returns the number of consecutive cycles each entry of a list other than the
+// target list has been missing from all sources, as last recorded by RecordListMisses.
+func (s *Store) ListMisses(listID string) map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	misses := make(map[string]int, len(s.data.ListMisses[listID]))
+	for key, count := range s.data.ListMisses[listID] {
+		misses[key] = count
+	}
+	return misses
+}
+
+// RecordListMisses replaces the consecutive miss counts of a list like RecordMisses does
+// for the target list.
+func (s *Store) RecordListMisses(listID string, misses map[string]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(misses) == 0 {
+		delete(s.data.ListMisses, listID)
+	} else {
+		if s.data.ListMisses == nil {
+			s.data.ListMisses = make(map[string]map[string]int)
+		}
+		s.data.ListMisses[listID] = misses
+	}
+	s.write(func(tx *bolt.Tx) error {
+		lists, err := tx.CreateBucketIfNotExists(listMissesBucket)
+		if err != nil {
+			return err
+		}
+		if len(misses) == 0 {
+			if lists.Bucket([]byte(listID)) == nil {
+				return nil
+			}
+			return lists.DeleteBucket([]byte(listID))
+		}
+		b, err := lists.CreateBucketIfNotExists([]byte(listID))
+		if err != nil {
+			return err
+		}
+		return replaceMap(b, misses)
+	})
+	return nil
+}
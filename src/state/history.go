@@ -0,0 +1,54 @@
+package state
+
+import (
+	"strings"
+	"time"
+)
+
+// Device event actions reported by DeviceHistory.
+const (
+	ActionAdded       = "added"
+	ActionRemoved     = "removed"
+	ActionFailedToAdd = "failed_to_add"
+)
+
+// DeviceEvent is a change of a single serial in the target list, as recorded in a cycle.
+type DeviceEvent struct {
+	Time         time.Time `json:"time"`
+	SerialNumber string    `json:"serial_number"`
+	Action       string    `json:"action"`
+}
+
+// CyclesSince returns the cycles that started at or after since, oldest first.
+func CyclesSince(cycles []Cycle, since time.Time) []Cycle {
+	var matched []Cycle
+	for _, cycle := range cycles {
+		if !cycle.StartedAt.Before(since) {
+			matched = append(matched, cycle)
+		}
+	}
+	return matched
+}
+
+// DeviceHistory returns the recorded additions, removals and failed additions of a serial,
+// oldest first. Serials are compared case-insensitively.
+func DeviceHistory(cycles []Cycle, serial string) []DeviceEvent {
+	var events []DeviceEvent
+	for _, cycle := range cycles {
+		for _, change := range []struct {
+			action  string
+			serials []string
+		}{
+			{ActionAdded, cycle.Added},
+			{ActionRemoved, cycle.Removed},
+			{ActionFailedToAdd, cycle.Failed},
+		} {
+			for _, s := range change.serials {
+				if strings.EqualFold(s, serial) {
+					events = append(events, DeviceEvent{Time: cycle.FinishedAt, SerialNumber: s, Action: change.action})
+				}
+			}
+		}
+	}
+	return events
+}
@@ -0,0 +1,27 @@
+package state
+
+import bolt "go.etcd.io/bbolt"
+
+// CreatedList returns the ID of the list created in place of the configured target list
+// ref, which did not exist.
+func (s *Store) CreatedList(ref string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	listID, ok := s.data.CreatedLists[ref]
+	return listID, ok
+}
+
+// RecordCreatedList remembers the list created in place of the configured target list ref
+// and writes the store to disk.
+func (s *Store) RecordCreatedList(ref, listID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.CreatedLists == nil {
+		s.data.CreatedLists = make(map[string]string)
+	}
+	s.data.CreatedLists[ref] = listID
+	s.write(func(tx *bolt.Tx) error {
+		return updateMap(tx, createdListsBucket, map[string]string{ref: listID}, nil)
+	})
+	return s.save()
+}
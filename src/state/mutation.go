@@ -0,0 +1,39 @@
+package state
+
+import (
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// PendingMutation is the part of a cycle's diff against the target list that was not
+// applied because the cycle was interrupted. The next cycle applies exactly these
+// operations instead of computing a new diff.
+type PendingMutation struct {
+	// InterruptedAt is when the cycle that computed the diff was interrupted
+	InterruptedAt time.Time `json:"interrupted_at"`
+	// MutationID identifies the diff, so the batches of it that were applied are skipped
+	MutationID string     `json:"mutation_id,omitempty"`
+	ListID     string     `json:"list_id"`
+	Remove     []string   `json:"remove,omitempty"`
+	Append     []ListItem `json:"append,omitempty"`
+}
+
+// PendingMutation returns the unapplied rest of an interrupted cycle's diff, or nil.
+func (s *Store) PendingMutation() *PendingMutation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.PendingMutation
+}
+
+// SetPendingMutation records the unapplied rest of an interrupted cycle's diff, replacing
+// any previous one, and writes the store to disk. A nil mutation clears it.
+func (s *Store) SetPendingMutation(mutation *PendingMutation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.PendingMutation = mutation
+	s.write(func(tx *bolt.Tx) error {
+		return putMeta(tx, pendingMutationKey, mutation)
+	})
+	return s.save()
+}
@@ -0,0 +1,116 @@
+package state
+
+import (
+	"sort"
+	"time"
+)
+
+// DayStats aggregates the cycles that started on a single UTC day.
+type DayStats struct {
+	Date         string `json:"date"`
+	Cycles       int    `json:"cycles"`
+	FailedCycles int    `json:"failed_cycles"`
+	Added        int    `json:"added"`
+	Removed      int    `json:"removed"`
+	Failed       int    `json:"failed_to_add"`
+}
+
+// SerialChanges counts how often a serial was added to or removed from the target list.
+type SerialChanges struct {
+	SerialNumber string `json:"serial_number"`
+	Changes      int    `json:"changes"`
+}
+
+// Stats aggregates the recorded sync history.
+type Stats struct {
+	Cycles          int           `json:"cycles"`
+	FailedCycles    int           `json:"failed_cycles"`
+	Since           time.Time     `json:"since"`
+	Until           time.Time     `json:"until"`
+	AverageDuration time.Duration `json:"average_duration"`
+	// CurrentDevices is the size of the desired device set in the last successful cycle
+	CurrentDevices int `json:"current_devices"`
+	// ChurnRate is the number of additions and removals over the last 24 hours relative
+	// to CurrentDevices
+	ChurnRate float64         `json:"churn_rate"`
+	Days      []DayStats      `json:"days"`
+	Flapping  []SerialChanges `json:"flapping"`
+}
+
+// Summarize aggregates cycles into Stats. Only serials that changed at least twice are
+// reported as flapping, and at most top of them are returned.
+func Summarize(cycles []Cycle, now time.Time, top int) Stats {
+	var stats Stats
+	if len(cycles) == 0 {
+		return stats
+	}
+	stats.Cycles = len(cycles)
+	stats.Since = cycles[0].StartedAt
+	stats.Until = cycles[len(cycles)-1].FinishedAt
+
+	days := make(map[string]*DayStats)
+	changes := make(map[string]int)
+	var totalDuration time.Duration
+	var succeeded, recentChanges int
+	for _, cycle := range cycles {
+		date := cycle.StartedAt.UTC().Format("2006-01-02")
+		day, ok := days[date]
+		if !ok {
+			day = &DayStats{Date: date}
+			days[date] = day
+		}
+		day.Cycles++
+		day.Added += len(cycle.Added)
+		day.Removed += len(cycle.Removed)
+		day.Failed += len(cycle.Failed)
+
+		if cycle.Error != "" {
+			stats.FailedCycles++
+			day.FailedCycles++
+		} else {
+			succeeded++
+			totalDuration += cycle.Duration()
+			stats.CurrentDevices = cycle.Devices
+		}
+
+		for _, serial := range cycle.Added {
+			changes[serial]++
+		}
+		for _, serial := range cycle.Removed {
+			changes[serial]++
+		}
+		if now.Sub(cycle.StartedAt) <= 24*time.Hour {
+			recentChanges += len(cycle.Added) + len(cycle.Removed)
+		}
+	}
+
+	if succeeded > 0 {
+		stats.AverageDuration = totalDuration / time.Duration(succeeded)
+	}
+	if stats.CurrentDevices > 0 {
+		stats.ChurnRate = float64(recentChanges) / float64(stats.CurrentDevices)
+	}
+
+	for _, day := range days {
+		stats.Days = append(stats.Days, *day)
+	}
+	sort.Slice(stats.Days, func(i, j int) bool {
+		return stats.Days[i].Date < stats.Days[j].Date
+	})
+
+	for serial, count := range changes {
+		if count >= 2 {
+			stats.Flapping = append(stats.Flapping, SerialChanges{SerialNumber: serial, Changes: count})
+		}
+	}
+	sort.Slice(stats.Flapping, func(i, j int) bool {
+		if stats.Flapping[i].Changes != stats.Flapping[j].Changes {
+			return stats.Flapping[i].Changes > stats.Flapping[j].Changes
+		}
+		return stats.Flapping[i].SerialNumber < stats.Flapping[j].SerialNumber
+	})
+	if top >= 0 && len(stats.Flapping) > top {
+		stats.Flapping = stats.Flapping[:top]
+	}
+	return stats
+}
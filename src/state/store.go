@@ -0,0 +1,565 @@
+package state
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// storeVersion is the version of the on-disk format. Version 1 was a single JSON file.
+const storeVersion = 2
+
+// Cycle is the persisted record of a single sync cycle.
+type Cycle struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// Devices is the size of the desired device set
+	Devices int      `json:"devices"`
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	Failed  []string `json:"failed,omitempty"`
+	// Error is set if the cycle failed
+	Error string `json:"error,omitempty"`
+	// Interval is the sync interval the cycle was scheduled at, zero for one-off runs
+	Interval time.Duration `json:"interval,omitempty"`
+}
+
+// Duration returns how long the cycle took.
+func (c Cycle) Duration() time.Duration {
+	return c.FinishedAt.Sub(c.StartedAt)
+}
+
+// MissedRuns returns how many cycles the schedule the cycle ran on would have started
+// between it and now, not counting the one due now. It is zero for one-off runs.
+func (c Cycle) MissedRuns(now time.Time) int {
+	if c.Interval <= 0 {
+		return 0
+	}
+	due := int(now.Sub(c.StartedAt) / c.Interval)
+	return max(due-1, 0)
+}
+
+// ListItem is an entry of a Cloudflare list.
+type ListItem struct {
+	Value   string `json:"value"`
+	Comment string `json:"comment,omitempty"`
+}
+
+type storeData struct {
+	Version int     `json:"version"`
+	Cycles  []Cycle `json:"cycles"`
+	// AppliedBatches maps the idempotency keys of applied list mutations to when they were applied
+	AppliedBatches map[string]time.Time `json:"applied_batches,omitempty"`
+	// PendingMutation is the rest of a diff left unapplied by an interrupted cycle
+	PendingMutation *PendingMutation `json:"pending_mutation,omitempty"`
+	// Devices maps serials to when they were first and last seen by a successful cycle
+	Devices map[string]DeviceRecord `json:"devices,omitempty"`
+	// LastSuccessAt is when the last successful cycle finished
+	LastSuccessAt time.Time `json:"last_success_at"`
+	// LastDiff is the last non-empty change a successful cycle applied
+	LastDiff *AppliedDiff `json:"last_diff,omitempty"`
+	// CreatedLists maps configured target lists that did not exist to the lists created
+	// in their place
+	CreatedLists map[string]string `json:"created_lists,omitempty"`
+	// Misses maps the serials of target list entries missing from all sources to the
+	// number of consecutive cycles they have been missing
+	Misses map[string]int `json:"misses,omitempty"`
+	// ListMisses holds the same counts for the entries of the other lists the syncer
+	// removes from, by list ID
+	ListMisses map[string]map[string]int `json:"list_misses,omitempty"`
+}
+
+// batchRetention is how long the idempotency keys of applied batches are kept.
+const batchRetention = 24 * time.Hour
+
+// lockTimeout is how long opening the store waits for another process that has it open,
+// such as the stats command reading it while the service writes.
+const lockTimeout = 10 * time.Second
+
+// Buckets of the bbolt database. Cycles are keyed by a sequence number, so they are read in
+// the order they were recorded; list_misses holds a bucket per list ID.
+var (
+	metaBucket         = []byte("meta")
+	cyclesBucket       = []byte("cycles")
+	batchesBucket      = []byte("applied_batches")
+	devicesBucket      = []byte("devices")
+	createdListsBucket = []byte("created_lists")
+	missesBucket       = []byte("misses")
+	listMissesBucket   = []byte("list_misses")
+)
+
+// Keys of the meta bucket.
+var (
+	versionKey         = []byte("version")
+	lastSuccessKey     = []byte("last_success_at")
+	lastDiffKey        = []byte("last_diff")
+	pendingMutationKey = []byte("pending_mutation")
+)
+
+// Store persists the sync history in a bbolt database. Cycles older than the retention
+// period are dropped whenever a new cycle is recorded.
+//
+// The whole store is read into memory when it is opened. Changes are written as the keys
+// they touch, so a cycle writes the devices it saw and the batches it applied, not the
+// whole history. What a cycle records along the way (applied batches, seen devices,
+// missing devices) is written in one transaction with the cycle itself, once per cycle. A
+// pending mutation and a created list are written at once, since they must survive a
+// crash. The database is only open while it is read or written, so the stats and history
+// commands can read it while the service runs.
+type Store struct {
+	path      string
+	retention time.Duration
+	mu        sync.Mutex
+	data      storeData
+	// writes are the changes not on disk yet, applied in a single transaction by save
+	writes []func(tx *bolt.Tx) error
+}
+
+// Open loads the state store at path. A missing file is treated as an empty store. A JSON
+// store written by an earlier version is converted, and kept next to it as path.v1.json.
+func Open(path string, retention time.Duration) (*Store, error) {
+	s := &Store{
+		path:      path,
+		retention: retention,
+		data:      storeData{Version: storeVersion},
+	}
+
+	legacy, err := readJSONStore(path)
+	if err != nil {
+		return nil, err
+	}
+	if legacy != nil {
+		if err := s.convert(legacy); err != nil {
+			return nil, fmt.Errorf("failed to convert JSON state store %s: %w", path, err)
+		}
+		return s, nil
+	}
+
+	if info, err := os.Stat(path); os.IsNotExist(err) || (err == nil && info.Size() == 0) {
+		return s, nil
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{ReadOnly: true, Timeout: lockTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state store %s: %w", path, err)
+	}
+	defer db.Close()
+	if err := db.View(s.load); err != nil {
+		return nil, fmt.Errorf("failed to read state store %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// readJSONStore reads the store at path if it is a JSON file written by an earlier version,
+// and returns nil otherwise.
+func readJSONStore(path string) (*storeData, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state store: %w", err)
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	if first, err := r.Peek(1); err != nil || first[0] != '{' {
+		return nil, nil
+	}
+	var data storeData
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return nil, fmt.Errorf("failed to parse state store %s: %w", path, err)
+	}
+	if data.Version > 1 {
+		return nil, fmt.Errorf("state store %s has unsupported version %d", path, data.Version)
+	}
+	return &data, nil
+}
+
+// convert writes a JSON store into a new database at the store's path, keeping the JSON
+// file as path.v1.json.
+func (s *Store) convert(legacy *storeData) error {
+	backup := s.path + ".v1.json"
+	if err := os.Rename(s.path, backup); err != nil {
+		return err
+	}
+	s.data = *legacy
+	s.data.Version = storeVersion
+	s.write(s.writeAll)
+	if err := s.save(); err != nil {
+		os.Rename(backup, s.path)
+		return err
+	}
+	return nil
+}
+
+// writeAll writes everything in memory to the database.
+func (s *Store) writeAll(tx *bolt.Tx) error {
+	cycles, err := tx.CreateBucketIfNotExists(cyclesBucket)
+	if err != nil {
+		return err
+	}
+	for _, cycle := range s.data.Cycles {
+		seq, err := cycles.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := putJSON(cycles, binary.BigEndian.AppendUint64(nil, seq), cycle); err != nil {
+			return err
+		}
+	}
+	for key, v := range map[string]any{
+		string(lastSuccessKey):     s.data.LastSuccessAt,
+		string(lastDiffKey):        s.data.LastDiff,
+		string(pendingMutationKey): s.data.PendingMutation,
+	} {
+		if err := putMeta(tx, []byte(key), v); err != nil {
+			return err
+		}
+	}
+	if err := updateMap(tx, batchesBucket, s.data.AppliedBatches, nil); err != nil {
+		return err
+	}
+	if err := updateMap(tx, devicesBucket, s.data.Devices, nil); err != nil {
+		return err
+	}
+	if err := updateMap(tx, createdListsBucket, s.data.CreatedLists, nil); err != nil {
+		return err
+	}
+	if err := updateMap(tx, missesBucket, s.data.Misses, nil); err != nil {
+		return err
+	}
+	lists, err := tx.CreateBucketIfNotExists(listMissesBucket)
+	if err != nil {
+		return err
+	}
+	for listID, misses := range s.data.ListMisses {
+		b, err := lists.CreateBucketIfNotExists([]byte(listID))
+		if err != nil {
+			return err
+		}
+		if err := replaceMap(b, misses); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// load reads the database into memory.
+func (s *Store) load(tx *bolt.Tx) error {
+	meta := tx.Bucket(metaBucket)
+	if meta == nil {
+		return nil
+	}
+	if err := getJSON(meta, versionKey, &s.data.Version); err != nil {
+		return err
+	}
+	if s.data.Version > storeVersion {
+		return fmt.Errorf("unsupported version %d", s.data.Version)
+	}
+	s.data.Version = storeVersion
+	if err := getJSON(meta, lastSuccessKey, &s.data.LastSuccessAt); err != nil {
+		return err
+	}
+	if err := getJSON(meta, lastDiffKey, &s.data.LastDiff); err != nil {
+		return err
+	}
+	if err := getJSON(meta, pendingMutationKey, &s.data.PendingMutation); err != nil {
+		return err
+	}
+	err := forEachJSON(tx.Bucket(cyclesBucket), func(_ string, cycle Cycle) {
+		s.data.Cycles = append(s.data.Cycles, cycle)
+	})
+	if err != nil {
+		return err
+	}
+	if s.data.AppliedBatches, err = readMap[time.Time](tx.Bucket(batchesBucket)); err != nil {
+		return err
+	}
+	if s.data.Devices, err = readMap[DeviceRecord](tx.Bucket(devicesBucket)); err != nil {
+		return err
+	}
+	if s.data.CreatedLists, err = readMap[string](tx.Bucket(createdListsBucket)); err != nil {
+		return err
+	}
+	if s.data.Misses, err = readMap[int](tx.Bucket(missesBucket)); err != nil {
+		return err
+	}
+	if listMisses := tx.Bucket(listMissesBucket); listMisses != nil {
+		s.data.ListMisses = make(map[string]map[string]int)
+		err := listMisses.ForEachBucket(func(listID []byte) error {
+			misses, err := readMap[int](listMisses.Bucket(listID))
+			s.data.ListMisses[string(listID)] = misses
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordCycle appends a cycle to the history and writes the store, with everything recorded
+// since it was last written, to disk. A successful
+// cycle also updates the last successful sync time and, if it changed the target list,
+// the last applied diff.
+func (s *Store) RecordCycle(cycle Cycle) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Cycles = append(s.data.Cycles, cycle)
+	lastSuccess, lastDiff := s.data.LastSuccessAt, s.data.LastDiff
+	if cycle.Error == "" {
+		lastSuccess = cycle.FinishedAt
+		if len(cycle.Added) > 0 || len(cycle.Removed) > 0 {
+			lastDiff = &AppliedDiff{At: cycle.FinishedAt, Added: cycle.Added, Removed: cycle.Removed}
+		}
+	}
+	s.data.LastSuccessAt, s.data.LastDiff = lastSuccess, lastDiff
+	var cutoff time.Time
+	if s.retention > 0 {
+		cutoff = cycle.StartedAt.Add(-s.retention)
+		keep := 0
+		for keep < len(s.data.Cycles) && s.data.Cycles[keep].StartedAt.Before(cutoff) {
+			keep++
+		}
+		s.data.Cycles = s.data.Cycles[keep:]
+	}
+	s.write(func(tx *bolt.Tx) error {
+		cycles, err := tx.CreateBucketIfNotExists(cyclesBucket)
+		if err != nil {
+			return err
+		}
+		if !cutoff.IsZero() {
+			if err := deleteCyclesBefore(cycles, cutoff); err != nil {
+				return err
+			}
+		}
+		seq, err := cycles.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := putJSON(cycles, binary.BigEndian.AppendUint64(nil, seq), cycle); err != nil {
+			return err
+		}
+		if err := putMeta(tx, lastSuccessKey, lastSuccess); err != nil {
+			return err
+		}
+		return putMeta(tx, lastDiffKey, lastDiff)
+	})
+	return s.save()
+}
+
+// deleteCyclesBefore deletes the cycles that started before cutoff, which are the first ones.
+func deleteCyclesBefore(cycles *bolt.Bucket, cutoff time.Time) error {
+	c := cycles.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.First() {
+		var cycle Cycle
+		if err := json.Unmarshal(v, &cycle); err != nil {
+			return err
+		}
+		if !cycle.StartedAt.Before(cutoff) {
+			return nil
+		}
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BatchAppliedAt returns when the list mutation with the given idempotency key was applied.
+func (s *Store) BatchAppliedAt(key string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	at, ok := s.data.AppliedBatches[key]
+	return at, ok
+}
+
+// RecordBatch records that the list mutation with the given idempotency key was applied and
+// drops keys older than a day. The key is written to disk with the next save.
+func (s *Store) RecordBatch(key string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.data.AppliedBatches == nil {
+		s.data.AppliedBatches = make(map[string]time.Time)
+	}
+	var expired []string
+	for k, appliedAt := range s.data.AppliedBatches {
+		if at.Sub(appliedAt) > batchRetention {
+			delete(s.data.AppliedBatches, k)
+			expired = append(expired, k)
+		}
+	}
+	s.data.AppliedBatches[key] = at
+	s.write(func(tx *bolt.Tx) error {
+		return updateMap(tx, batchesBucket, map[string]time.Time{key: at}, expired)
+	})
+	return nil
+}
+
+// Cycles returns the recorded cycles, oldest first.
+func (s *Store) Cycles() []Cycle {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Cycle(nil), s.data.Cycles...)
+}
+
+// Flush writes the store to disk if it has changes that were not written yet.
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.writes) == 0 {
+		return nil
+	}
+	return s.save()
+}
+
+// write queues a change for the next save.
+func (s *Store) write(change func(tx *bolt.Tx) error) {
+	s.writes = append(s.writes, change)
+}
+
+// save writes the queued changes to disk in a single transaction. If it fails, they stay
+// queued for the next save.
+func (s *Store) save() error {
+	db, err := bolt.Open(s.path, 0o600, &bolt.Options{Timeout: lockTimeout})
+	if err != nil {
+		return fmt.Errorf("failed to open state store: %w", err)
+	}
+	defer db.Close()
+	err = db.Update(func(tx *bolt.Tx) error {
+		if err := putMeta(tx, versionKey, storeVersion); err != nil {
+			return err
+		}
+		for _, write := range s.writes {
+			if err := write(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write state store: %w", err)
+	}
+	s.writes = nil
+	return nil
+}
+
+// putMeta writes a value of the meta bucket as JSON. A nil value deletes the key.
+func putMeta(tx *bolt.Tx, key []byte, v any) error {
+	meta, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+	return putJSON(meta, key, v)
+}
+
+// putJSON writes v to a bucket as JSON. A nil pointer deletes the key.
+func putJSON(b *bolt.Bucket, key []byte, v any) error {
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Pointer && rv.IsNil() {
+		return b.Delete(key)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, data)
+}
+
+// getJSON reads the JSON value of a key into v, leaving v unchanged if there is none.
+func getJSON(b *bolt.Bucket, key []byte, v any) error {
+	data := b.Get(key)
+	if data == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	return nil
+}
+
+// forEachJSON calls fn with every JSON value of a bucket, which may be nil.
+func forEachJSON[V any](b *bolt.Bucket, fn func(key string, v V)) error {
+	if b == nil {
+		return nil
+	}
+	return b.ForEach(func(k, data []byte) error {
+		var v V
+		if err := json.Unmarshal(data, &v); err != nil {
+			return fmt.Errorf("%s: %w", k, err)
+		}
+		fn(string(k), v)
+		return nil
+	})
+}
+
+// readMap reads a bucket of JSON values into a map, nil if the bucket does not exist.
+func readMap[V any](b *bolt.Bucket) (map[string]V, error) {
+	if b == nil {
+		return nil, nil
+	}
+	m := make(map[string]V)
+	err := forEachJSON(b, func(key string, v V) { m[key] = v })
+	return m, err
+}
+
+// updateMap writes the given keys of a bucket of JSON values and deletes the removed ones.
+func updateMap[V any](tx *bolt.Tx, name []byte, set map[string]V, removed []string) error {
+	b, err := tx.CreateBucketIfNotExists(name)
+	if err != nil {
+		return err
+	}
+	for key, v := range set {
+		if err := putJSON(b, []byte(key), v); err != nil {
+			return err
+		}
+	}
+	for _, key := range removed {
+		if err := b.Delete([]byte(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceMap replaces the JSON values of a bucket with m.
+func replaceMap[V any](b *bolt.Bucket, m map[string]V) error {
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.First() {
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+	for key, v := range m {
+		if err := putJSON(b, []byte(key), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to path by writing a temporary file next to it and renaming
+// it, so readers never see a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,176 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestStoreWritesOncePerCycle(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		// record changes the store during a cycle
+		record func(s *Store) error
+		// onDisk reports whether the change is in a store reopened from disk
+		onDisk func(s *Store) bool
+		// immediate changes are written at once, the others with the cycle
+		immediate bool
+	}{
+		{
+			name:   "applied batch",
+			record: func(s *Store) error { return s.RecordBatch("key", now) },
+			onDisk: func(s *Store) bool { _, ok := s.BatchAppliedAt("key"); return ok },
+		},
+		{
+			name:   "seen devices",
+			record: func(s *Store) error { return s.RecordSeen([]string{"SN1"}, now) },
+			onDisk: func(s *Store) bool { _, ok := s.Device("SN1"); return ok },
+		},
+		{
+			name:   "missing devices",
+			record: func(s *Store) error { return s.RecordMisses(map[string]int{"SN1": 1}) },
+			onDisk: func(s *Store) bool { return s.Misses()["SN1"] == 1 },
+		},
+		{
+			name:   "missing list entries",
+			record: func(s *Store) error { return s.RecordListMisses("list", map[string]int{"SN1": 1}) },
+			onDisk: func(s *Store) bool { return s.ListMisses("list")["SN1"] == 1 && len(s.ListMisses("other")) == 0 },
+		},
+		{
+			name: "pending mutation",
+			record: func(s *Store) error {
+				return s.SetPendingMutation(&PendingMutation{ListID: "list", Remove: []string{"SN1"}})
+			},
+			onDisk:    func(s *Store) bool { return s.PendingMutation() != nil },
+			immediate: true,
+		},
+		{
+			name:      "created list",
+			record:    func(s *Store) error { return s.RecordCreatedList("ref", "list") },
+			onDisk:    func(s *Store) bool { _, ok := s.CreatedList("ref"); return ok },
+			immediate: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.db")
+			reopen := func() *Store {
+				s, err := Open(path, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return s
+			}
+			store := reopen()
+			if err := tt.record(store); err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.onDisk(reopen()); got != tt.immediate {
+				t.Errorf("on disk before the cycle is recorded = %v, want %v", got, tt.immediate)
+			}
+			if err := store.RecordCycle(Cycle{StartedAt: now, FinishedAt: now}); err != nil {
+				t.Fatal(err)
+			}
+			if !tt.onDisk(reopen()) {
+				t.Error("not on disk after the cycle is recorded")
+			}
+		})
+	}
+}
+
+func TestStoreFlush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	store, err := Open(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing to write creates no file
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Flush() without changes wrote the store: %v", err)
+	}
+	if err := store.RecordSeen([]string{"SN1"}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := Open(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reopened.Device("SN1"); !ok {
+		t.Error("Flush() did not write the seen devices")
+	}
+}
+
+func TestStoreRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	store, err := Open(path, 48*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	for day := range 4 {
+		at := start.Add(time.Duration(day) * 24 * time.Hour)
+		if err := store.RecordCycle(Cycle{StartedAt: at, FinishedAt: at, Devices: day}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reopened, err := Open(path, 48*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var devices []int
+	for _, cycle := range reopened.Cycles() {
+		devices = append(devices, cycle.Devices)
+	}
+	if want := []int{1, 2, 3}; !slices.Equal(devices, want) {
+		t.Errorf("cycles on disk = %v, want %v", devices, want)
+	}
+	if got, want := reopened.LastSuccess(), start.Add(72*time.Hour); !got.Equal(want) {
+		t.Errorf("LastSuccess() = %v, want %v", got, want)
+	}
+}
+
+func TestOpenConvertsJSONStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	at := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	legacy := `{"version":1,"cycles":[{"started_at":"2024-05-01T10:00:00Z","finished_at":"2024-05-01T10:00:00Z","devices":2,"added":["SN1"]}],` +
+		`"devices":{"SN1":{"first_seen":"2024-05-01T10:00:00Z","last_seen":"2024-05-01T10:00:00Z"}},` +
+		`"last_success_at":"2024-05-01T10:00:00Z","created_lists":{"ref":"list"},"list_misses":{"list":{"SN2":1}}}`
+	if err := os.WriteFile(path, []byte(legacy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path, 0); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if _, err := os.Stat(path + ".v1.json"); err != nil {
+		t.Errorf("JSON store not kept: %v", err)
+	}
+
+	store, err := Open(path, 0)
+	if err != nil {
+		t.Fatalf("Open() of the converted store error = %v", err)
+	}
+	if cycles := store.Cycles(); len(cycles) != 1 || cycles[0].Devices != 2 {
+		t.Errorf("Cycles() = %v, want the cycle of the JSON store", cycles)
+	}
+	if record, ok := store.Device("SN1"); !ok || !record.FirstSeen.Equal(at) {
+		t.Errorf("Device(SN1) = %v, %v, want first seen at %v", record, ok, at)
+	}
+	if !store.LastSuccess().Equal(at) {
+		t.Errorf("LastSuccess() = %v, want %v", store.LastSuccess(), at)
+	}
+	if listID, _ := store.CreatedList("ref"); listID != "list" {
+		t.Errorf("CreatedList(ref) = %q, want list", listID)
+	}
+	if misses := store.ListMisses("list"); misses["SN2"] != 1 {
+		t.Errorf("ListMisses(list) = %v, want SN2 missing once", misses)
+	}
+}
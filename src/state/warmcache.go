@@ -0,0 +1,61 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// warmCacheVersion is the version of the on-disk warm cache format.
+const warmCacheVersion = 1
+
+// WarmCache is what the service knew about the target list and the Kandji inventory when it
+// last shut down. It lets the first cycle after a restart skip downloading the target list.
+type WarmCache struct {
+	Version int       `json:"version"`
+	SavedAt time.Time `json:"saved_at"`
+	// TargetListID is the list the items were read from; a cache for another list is ignored
+	TargetListID string     `json:"target_list_id"`
+	TargetItems  []ListItem `json:"target_items"`
+	// KandjiHash is a hash of the Kandji inventory seen by the last cycle
+	KandjiHash string `json:"kandji_hash"`
+}
+
+// Age returns how long ago the cache was saved.
+func (c *WarmCache) Age() time.Duration {
+	return time.Since(c.SavedAt)
+}
+
+// LoadWarmCache reads the warm cache at path. A missing file returns a nil cache.
+func LoadWarmCache(path string) (*WarmCache, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read warm cache: %w", err)
+	}
+	var cache WarmCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, fmt.Errorf("failed to parse warm cache %s: %w", path, err)
+	}
+	if cache.Version != warmCacheVersion {
+		return nil, fmt.Errorf("warm cache %s has unsupported version %d", path, cache.Version)
+	}
+	return &cache, nil
+}
+
+// SaveWarmCache writes the warm cache to path, stamping it with the current time.
+func SaveWarmCache(path string, cache *WarmCache) error {
+	cache.Version = warmCacheVersion
+	cache.SavedAt = time.Now().UTC()
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return fmt.Errorf("failed to marshal warm cache: %w", err)
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to write warm cache: %w", err)
+	}
+	return nil
+}
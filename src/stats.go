@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/state"
+)
+
+// runStats prints aggregate statistics of the sync history recorded in the state store.
+func runStats(args []string) int {
+	fs := newFlagSet("stats")
+	days := fs.Int("days", 14, "Number of most recent days to print per-day statistics for")
+	top := fs.Int("top", 10, "Number of most frequently changing serials to print")
+	cfg, err := config.ParseStateConfigArgs(fs, args)
+	if err != nil {
+		slog.Error("Failed to load configuration", "error", err)
+		return exitConfig
+	}
+
+	store, err := state.Open(cfg.State.Path, cfg.State.Retention)
+	if err != nil {
+		slog.Error("Failed to open state store", "path", cfg.State.Path, "error", err)
+		return exitFailure
+	}
+	stats := state.Summarize(store.Cycles(), time.Now().UTC(), *top)
+	if stats.Cycles == 0 {
+		fmt.Println("No sync cycles recorded yet.")
+		return exitOK
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Cycles recorded:\t%d (%d failed)\n", stats.Cycles, stats.FailedCycles)
+	fmt.Fprintf(w, "Period:\t%s - %s\n", stats.Since.Format(time.RFC3339), stats.Until.Format(time.RFC3339))
+	fmt.Fprintf(w, "Average cycle duration:\t%s\n", stats.AverageDuration.Round(time.Millisecond))
+	fmt.Fprintf(w, "Current devices:\t%d\n", stats.CurrentDevices)
+	fmt.Fprintf(w, "Churn rate (24h):\t%.2f%%\n", stats.ChurnRate*100)
+	if lastSuccess := store.LastSuccess(); !lastSuccess.IsZero() {
+		fmt.Fprintf(w, "Last successful sync:\t%s\n", lastSuccess.UTC().Format(time.RFC3339))
+	}
+	if diff := store.LastDiff(); diff != nil {
+		fmt.Fprintf(w, "Last applied change:\t%s (%d added, %d removed)\n", diff.At.UTC().Format(time.RFC3339), len(diff.Added), len(diff.Removed))
+	}
+	fmt.Fprintf(w, "Devices tracked:\t%d\n", len(store.DeviceSerials()))
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "DATE\tCYCLES\tFAILED CYCLES\tADDED\tREMOVED\tFAILED TO ADD")
+	dayStats := stats.Days
+	if *days >= 0 && len(dayStats) > *days {
+		dayStats = dayStats[len(dayStats)-*days:]
+	}
+	for _, day := range dayStats {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\n", day.Date, day.Cycles, day.FailedCycles, day.Added, day.Removed, day.Failed)
+	}
+
+	if len(stats.Flapping) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "FLAPPING SERIAL\tCHANGES")
+		for _, serial := range stats.Flapping {
+			fmt.Fprintf(w, "%s\t%d\n", serial.SerialNumber, serial.Changes)
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		slog.Error("Failed to write statistics", "error", err)
+		return exitFailure
+	}
+	return exitOK
+}
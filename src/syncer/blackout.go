@@ -0,0 +1,42 @@
+package syncer
+
+import (
+	"time"
+
+	"kandji-cloudflare-device-sync/events"
+)
+
+// checkBlackout looks up the blackout window a cycle starting at now runs in, logging when
+// one starts or ends. A cycle in a blackout window is a dry run: it computes and reports its
+// diff, but defers the changes to the first cycle after the window.
+func (s *Syncer) checkBlackout(now time.Time) {
+	window, _ := s.config.BlackoutWindows.Active(now)
+	if s.metrics != nil {
+		active := 0.0
+		if window != "" {
+			active = 1
+		}
+		s.metrics.blackout.Set(active)
+	}
+	if window == s.blackout {
+		return
+	}
+	if window != "" {
+		s.log.Warn("Blackout window active, sync cycles report their changes without making them", "window", window)
+		if s.events != nil {
+			s.events.Record(events.TypeBlackoutStarted, "Blackout window started, changes are deferred", "window", window)
+		}
+	} else {
+		s.log.Info("Blackout window ended, sync cycles make their changes again", "window", s.blackout)
+		if s.events != nil {
+			s.events.Record(events.TypeBlackoutEnded, "Blackout window ended", "window", s.blackout)
+		}
+	}
+	s.blackout = window
+}
+
+// dryRun reports whether the cycle in progress makes no changes, because dry_run is set or
+// it runs in a blackout window.
+func (s *Syncer) dryRun() bool {
+	return s.config.DryRun || s.blackout != ""
+}
@@ -0,0 +1,89 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"kandji-cloudflare-device-sync/events"
+	"kandji-cloudflare-device-sync/internal/breaker"
+	"kandji-cloudflare-device-sync/notify"
+)
+
+// ErrCircuitOpen is wrapped by the error of a cycle skipped because the circuit breaker of
+// an API is open.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// checkCircuits returns an error wrapping ErrCircuitOpen if the circuit of either API is
+// open, so the cycle is skipped. Once the cooldown has passed, a cycle is let through as
+// a trial.
+func (s *Syncer) checkCircuits(now time.Time) error {
+	for _, circuit := range []struct {
+		api     string
+		breaker *breaker.Breaker
+	}{{"kandji", s.kandjiBreaker}, {"cloudflare", s.cloudflareBreaker}} {
+		if circuit.breaker == nil {
+			continue
+		}
+		if ok, until := circuit.breaker.Allow(now); !ok {
+			s.log.Warn("Skipping sync cycle, circuit breaker is open", "api", circuit.api, "open_until", until.Format(time.RFC3339))
+			return fmt.Errorf("skipped sync cycle: %w for the %s API until %s", ErrCircuitOpen, circuit.api, until.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// apiFailed records a cycle that failed because of the API. Failures caused by the cycle
+// being cancelled are not counted.
+func (s *Syncer) apiFailed(ctx context.Context, api string, b *breaker.Breaker, err error) {
+	if b == nil || ctx.Err() != nil {
+		return
+	}
+	now := time.Now().UTC()
+	if !b.Failure(now) {
+		return
+	}
+	until, failures := now.Add(s.config.CircuitBreaker.Cooldown), b.Failures()
+	s.log.Error("Circuit breaker opened, skipping sync cycles during the cooldown",
+		"api", api, "consecutive_failures", failures, "open_until", until.Format(time.RFC3339), "error", err)
+	if s.events != nil {
+		s.events.Record(events.TypeCircuitOpened, "Circuit breaker opened",
+			"api", api, "consecutive_failures", failures, "open_until", until.Format(time.RFC3339), "error", err)
+	}
+	if s.metrics != nil {
+		s.metrics.circuitOpen.Set(1, api)
+	}
+	s.notifyCircuit(ctx, notify.TypeCircuitOpened, &notify.Circuit{API: api, OpenUntil: until, Failures: failures}, err)
+}
+
+// apiSucceeded records a cycle in which the API worked, closing its circuit if it was open.
+func (s *Syncer) apiSucceeded(ctx context.Context, api string, b *breaker.Breaker) {
+	if b == nil || !b.Success() {
+		return
+	}
+	s.log.Info("Circuit breaker closed", "api", api)
+	if s.events != nil {
+		s.events.Record(events.TypeCircuitClosed, "Circuit breaker closed", "api", api)
+	}
+	if s.metrics != nil {
+		s.metrics.circuitOpen.Set(0, api)
+	}
+	s.notifyCircuit(ctx, notify.TypeCircuitClosed, &notify.Circuit{API: api}, nil)
+}
+
+func (s *Syncer) notifyCircuit(ctx context.Context, eventType string, circuit *notify.Circuit, err error) {
+	if s.dryRun() {
+		return
+	}
+	event := &notify.Event{
+		Type:    eventType,
+		Time:    time.Now().UTC(),
+		ListID:  s.config.Cloudflare.ListID,
+		Circuit: circuit,
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	notify.Dispatch(ctx, s.notifiers, event, s.log)
+}
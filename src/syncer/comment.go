@@ -0,0 +1,45 @@
+package syncer
+
+import (
+	"strings"
+
+	"kandji-cloudflare-device-sync/intune"
+	"kandji-cloudflare-device-sync/kandji"
+)
+
+// composeComment builds the comment of a Kandji device from the configured comment fields,
+// in the configured order. Empty fields are left out.
+func (s *Syncer) composeComment(device kandji.Device) string {
+	var parts []string
+	for _, field := range s.config.Cloudflare.Comment.Fields {
+		var value string
+		switch field {
+		case "name":
+			value = device.DeviceName
+		case "owner":
+			value = device.UserEmail
+		case "blueprint":
+			value = device.BlueprintName
+		case "model":
+			value = device.Model
+		case "last_check_in":
+			value = device.LastSeen
+		}
+		if value = strings.TrimSpace(value); value != "" {
+			parts = append(parts, value)
+		}
+	}
+	return strings.Join(parts, s.config.Cloudflare.Comment.Separator)
+}
+
+// composeIntuneComment builds the comment of an Intune device like that of a Kandji device,
+// with its user principal name as the owner and its last Intune sync as the last check-in.
+// Intune devices have no blueprint.
+func (s *Syncer) composeIntuneComment(device intune.Device) string {
+	return s.composeComment(kandji.Device{
+		DeviceName: device.DeviceName,
+		UserEmail:  device.UserPrincipalName,
+		Model:      device.Model,
+		LastSeen:   device.LastSyncDateTime,
+	})
+}
@@ -0,0 +1,100 @@
+package syncer
+
+import "strings"
+
+// commentCandidates collects the comments every source would write per serial, in the
+// order the serials were first seen.
+type commentCandidates struct {
+	order    []string
+	bySerial map[string][]CommentSource
+}
+
+func newCommentCandidates() *commentCandidates {
+	return &commentCandidates{bySerial: make(map[string][]CommentSource)}
+}
+
+func (c *commentCandidates) add(serial, source, comment string) {
+	if _, seen := c.bySerial[serial]; !seen {
+		c.order = append(c.order, serial)
+	}
+	c.bySerial[serial] = append(c.bySerial[serial], CommentSource{Source: source, Comment: comment})
+}
+
+// distinctComments returns the distinct non-empty comments in candidate order.
+func distinctComments(candidates []CommentSource) []string {
+	var comments []string
+	seen := make(map[string]struct{})
+	for _, candidate := range candidates {
+		if candidate.Comment == "" {
+			continue
+		}
+		if _, ok := seen[candidate.Comment]; ok {
+			continue
+		}
+		seen[candidate.Comment] = struct{}{}
+		comments = append(comments, candidate.Comment)
+	}
+	return comments
+}
+
+// resolveComment picks the comment to write for a serial according to the configured
+// conflict resolution strategy. It returns false if the serial should not be added.
+func (s *Syncer) resolveComment(candidates []CommentSource) (string, bool) {
+	comments := distinctComments(candidates)
+	if len(comments) == 0 {
+		return "", true
+	}
+	if len(comments) == 1 {
+		return comments[0], true
+	}
+
+	switch s.config.Cloudflare.ConflictResolution {
+	case "sources_first":
+		for _, candidate := range candidates {
+			// Kandji tenants are sources "kandji:<tenant>"
+			if !strings.HasPrefix(candidate.Source, "kandji") && candidate.Comment != "" {
+				return candidate.Comment, true
+			}
+		}
+		return comments[0], true
+	case "merge":
+		return strings.Join(comments, " | "), true
+	case "skip":
+		return "", false
+	default: // kandji_first
+		return comments[0], true
+	}
+}
+
+// conflicts resolves every serial with more than one distinct comment and returns the conflicts.
+func (s *Syncer) conflicts(candidates *commentCandidates) []Conflict {
+	var conflicts []Conflict
+	for _, serial := range candidates.order {
+		sources := candidates.bySerial[serial]
+		if len(distinctComments(sources)) < 2 {
+			continue
+		}
+		resolution, ok := s.resolveComment(sources)
+		conflicts = append(conflicts, Conflict{
+			SerialNumber: serial,
+			Candidates:   sources,
+			Resolution:   resolution,
+			Skipped:      !ok,
+		})
+	}
+	return conflicts
+}
+
+// candidateSources returns the distinct sources that contributed a serial, joined with ",".
+func candidateSources(candidates []CommentSource) string {
+	var sources []string
+	seen := make(map[string]struct{})
+	for _, candidate := range candidates {
+		if _, ok := seen[candidate.Source]; ok {
+			continue
+		}
+		seen[candidate.Source] = struct{}{}
+		sources = append(sources, candidate.Source)
+	}
+	return strings.Join(sources, ",")
+}
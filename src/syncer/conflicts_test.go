@@ -0,0 +1,39 @@
+package syncer
+
+import (
+	"testing"
+
+	"kandji-cloudflare-device-sync/config"
+)
+
+func TestResolveComment(t *testing.T) {
+	// The serial is enrolled in two Kandji tenants and listed in a source list
+	candidates := []CommentSource{
+		{Source: "kandji", Comment: "Jane's MacBook"},
+		{Source: "kandji:acme", Comment: "Acme loaner"},
+		{Source: "source-list-id", Comment: "Contractor laptop"},
+	}
+	tests := []struct {
+		strategy   string
+		candidates []CommentSource
+		want       string
+		wantOK     bool
+	}{
+		{strategy: "kandji_first", candidates: candidates, want: "Jane's MacBook", wantOK: true},
+		{strategy: "sources_first", candidates: candidates, want: "Contractor laptop", wantOK: true},
+		{strategy: "sources_first", candidates: candidates[:2], want: "Jane's MacBook", wantOK: true},
+		{strategy: "sources_first", candidates: []CommentSource{candidates[1], candidates[0]}, want: "Acme loaner", wantOK: true},
+		{strategy: "merge", candidates: candidates, want: "Jane's MacBook | Acme loaner | Contractor laptop", wantOK: true},
+		{strategy: "skip", candidates: candidates},
+		{strategy: "skip", candidates: []CommentSource{candidates[0], {Source: "kandji:acme", Comment: "Jane's MacBook"}}, want: "Jane's MacBook", wantOK: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			s := &Syncer{config: &config.Config{Cloudflare: config.CloudflareConfig{ConflictResolution: tt.strategy}}}
+			got, ok := s.resolveComment(tt.candidates)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("resolveComment(%v) = %q, %v, want %q, %v", tt.candidates, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package syncer
+
+import (
+	"sync"
+	"time"
+)
+
+// control is what the admin API reads and changes of a running Syncer, guarded by its own
+// mutex as it is accessed from outside the Run loop.
+type control struct {
+	mu     sync.Mutex
+	paused bool
+	// runningSince is when the cycle in progress started, zero between cycles
+	runningSince time.Time
+	// last is the report of the last cycle, and lastErr its error
+	last    *Report
+	lastErr error
+	// progress is when the loop last showed it is working, see LastProgress
+	progress time.Time
+}
+
+// Status is the state of a sync loop.
+type Status struct {
+	Paused bool `json:"paused"`
+	// Leader is set if leader election is enabled
+	Leader *bool `json:"leader,omitempty"`
+	// RunningSince is set while a cycle runs
+	RunningSince *time.Time   `json:"running_since,omitempty"`
+	LastCycle    *CycleResult `json:"last_cycle,omitempty"`
+}
+
+// CycleResult summarizes the outcome of a cycle.
+type CycleResult struct {
+	CycleID        string    `json:"cycle_id"`
+	StartedAt      time.Time `json:"started_at"`
+	FinishedAt     time.Time `json:"finished_at"`
+	Duration       string    `json:"duration"`
+	Error          string    `json:"error,omitempty"`
+	DesiredDevices int       `json:"desired_devices"`
+	Added          int       `json:"added"`
+	Removed        int       `json:"removed"`
+	FailedToAdd    int       `json:"failed_to_add"`
+	DryRun         bool      `json:"dry_run,omitempty"`
+	Blackout       string    `json:"blackout,omitempty"`
+}
+
+// Diff is the changes a cycle made to the target list, and those it did not make.
+type Diff struct {
+	CycleID     string    `json:"cycle_id"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+	Error       string    `json:"error,omitempty"`
+	Added       []string  `json:"added"`
+	Removed     []string  `json:"removed"`
+	FailedToAdd []string  `json:"failed_to_add"`
+	// BlockedRemovals exceeded max_removals_per_cycle, Missing are the entries on_missing
+	// reported
+	BlockedRemovals []string `json:"blocked_removals"`
+	Missing         []string `json:"missing"`
+	DryRun          bool     `json:"dry_run,omitempty"`
+	// Blackout is the blackout window the cycle ran in, so its changes were not made
+	Blackout string `json:"blackout,omitempty"`
+}
+
+// Pause makes Run skip its cycles, scheduled and triggered, until Resume is called. A cycle
+// in progress finishes. It returns false if the loop was already paused.
+func (s *Syncer) Pause() bool {
+	s.control.mu.Lock()
+	defer s.control.mu.Unlock()
+	if s.control.paused {
+		return false
+	}
+	s.control.paused = true
+	s.log.Info("Sync loop paused")
+	return true
+}
+
+// Resume undoes Pause; the next cycle runs on schedule. It returns false if the loop was
+// not paused.
+func (s *Syncer) Resume() bool {
+	s.control.mu.Lock()
+	defer s.control.mu.Unlock()
+	if !s.control.paused {
+		return false
+	}
+	s.control.paused = false
+	s.log.Info("Sync loop resumed")
+	return true
+}
+
+// Paused reports whether the loop is paused.
+func (s *Syncer) Paused() bool {
+	s.control.mu.Lock()
+	defer s.control.mu.Unlock()
+	return s.control.paused
+}
+
+// Leading reports whether Run runs cycles as far as leader election is concerned: always
+// without it, only on the leader with it.
+func (s *Syncer) Leading() bool {
+	return s.isLeader == nil || s.isLeader()
+}
+
+// Status returns the state of the loop and the outcome of the last cycle.
+func (s *Syncer) Status() Status {
+	s.control.mu.Lock()
+	defer s.control.mu.Unlock()
+	status := Status{Paused: s.control.paused}
+	if s.isLeader != nil {
+		leader := s.isLeader()
+		status.Leader = &leader
+	}
+	if !s.control.runningSince.IsZero() {
+		since := s.control.runningSince
+		status.RunningSince = &since
+	}
+	if last := s.control.last; last != nil {
+		status.LastCycle = &CycleResult{
+			CycleID:        last.CycleID,
+			StartedAt:      last.StartedAt,
+			FinishedAt:     last.FinishedAt,
+			Duration:       last.FinishedAt.Sub(last.StartedAt).String(),
+			DesiredDevices: last.DesiredDevices,
+			Added:          len(last.Added),
+			Removed:        len(last.Removed),
+			FailedToAdd:    len(last.FailedToAdd),
+			DryRun:         last.DryRun,
+		}
+		if s.control.lastErr != nil {
+			status.LastCycle.Error = s.control.lastErr.Error()
+		}
+	}
+	return status
+}
+
+// LastDiff returns the changes of the last cycle, nil if none ran yet.
+func (s *Syncer) LastDiff() *Diff {
+	s.control.mu.Lock()
+	defer s.control.mu.Unlock()
+	last := s.control.last
+	if last == nil {
+		return nil
+	}
+	diff := &Diff{
+		CycleID:         last.CycleID,
+		StartedAt:       last.StartedAt,
+		FinishedAt:      last.FinishedAt,
+		Added:           nonNil(last.Added),
+		Removed:         nonNil(last.Removed),
+		FailedToAdd:     nonNil(last.FailedToAdd),
+		BlockedRemovals: nonNil(last.BlockedRemovals),
+		Missing:         nonNil(last.Missing),
+		DryRun:          last.DryRun,
+		Blackout:        last.Blackout,
+	}
+	if s.control.lastErr != nil {
+		diff.Error = s.control.lastErr.Error()
+	}
+	return diff
+}
+
+// cycleStarted marks a cycle as in progress.
+func (s *Syncer) cycleStarted() {
+	s.control.mu.Lock()
+	defer s.control.mu.Unlock()
+	s.control.runningSince = time.Now().UTC()
+}
+
+// cycleFinished records the outcome of the cycle in progress.
+func (s *Syncer) cycleFinished(report *Report, err error) {
+	if report.FinishedAt.IsZero() {
+		finished := *report
+		finished.FinishedAt = time.Now().UTC()
+		report = &finished
+	}
+	s.control.mu.Lock()
+	defer s.control.mu.Unlock()
+	s.control.runningSince = time.Time{}
+	s.control.last, s.control.lastErr = report, err
+	if err == nil {
+		s.control.progress = time.Now()
+	}
+}
+
+// markProgress records that the loop is working without running a cycle: it started, or
+// skipped a cycle it was not meant to run.
+func (s *Syncer) markProgress() {
+	s.control.mu.Lock()
+	defer s.control.mu.Unlock()
+	s.control.progress = time.Now()
+}
+
+// LastProgress returns when Run last made progress: when a cycle last succeeded, or when a
+// cycle was skipped because the loop is paused or another replica is the leader, or else
+// when Run started. It is zero before Run started.
+func (s *Syncer) LastProgress() time.Time {
+	s.control.mu.Lock()
+	defer s.control.mu.Unlock()
+	return s.control.progress
+}
+
+// nonNil returns serials, or an empty slice if it is nil, so it is encoded as [].
+func nonNil(serials []string) []string {
+	if serials == nil {
+		return []string{}
+	}
+	return serials
+}
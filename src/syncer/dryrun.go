@@ -0,0 +1,31 @@
+package syncer
+
+import (
+	"fmt"
+
+	"kandji-cloudflare-device-sync/destination"
+)
+
+// logDryRun logs every change a dry run computed for the target list, with the comment of
+// the entry and the reason it would be removed or the sources it would be added from. A
+// cycle in a blackout window logs the changes it deferred the same way.
+func (s *Syncer) logDryRun(changes []destination.Change) {
+	prefix := "Dry run"
+	if !s.config.DryRun && s.blackout != "" {
+		prefix = fmt.Sprintf("Blackout window %s", s.blackout)
+	}
+	var removals, additions int
+	for _, change := range changes {
+		switch change.Action {
+		case destination.ActionRemove:
+			removals++
+			s.log.Info(prefix+": would remove device from target Cloudflare list",
+				"serial_number", change.SerialNumber, "comment", change.Comment, "reason", change.Source)
+		case destination.ActionAdd:
+			additions++
+			s.log.Info(prefix+": would add device to target Cloudflare list",
+				"serial_number", change.SerialNumber, "comment", change.Comment, "sources", change.Source)
+		}
+	}
+	s.log.Info(prefix+": no changes were made to the target Cloudflare list", "would_remove", removals, "would_add", additions)
+}
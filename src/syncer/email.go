@@ -0,0 +1,146 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"kandji-cloudflare-device-sync/audit"
+	"kandji-cloudflare-device-sync/cloudflare"
+	"kandji-cloudflare-device-sync/destination"
+	"kandji-cloudflare-device-sync/kandji"
+)
+
+// syncEmailOnly finishes a cycle with email_only set: the EMAIL list is kept in step with
+// the owners of every device that passed the filters, and no serial list is touched. Owners
+// are removed through syncEmailList, so on_missing_grace_cycles and max_removals_per_cycle
+// guard them against a Kandji response that lacks their devices.
+func (s *Syncer) syncEmailOnly(ctx context.Context, report *Report, kandjiDevices int, devices []kandji.Device, sanitizer *serialSanitizer) (*Report, error) {
+	serials := make(map[string]struct{}, len(devices))
+	for _, device := range devices {
+		serials[device.SerialNumber] = struct{}{}
+		s.seenSerials = append(s.seenSerials, device.SerialNumber)
+	}
+
+	if err := s.syncEmailList(ctx, report, devices, serials); err != nil {
+		s.apiFailed(ctx, "cloudflare", s.cloudflareBreaker, err)
+		return report, fmt.Errorf("failed to sync owner email list: %w", err)
+	}
+	s.apiSucceeded(ctx, "cloudflare", s.cloudflareBreaker)
+
+	if len(s.destinations) > 0 && s.dryRun() {
+		s.log.Info("Dry run: not publishing to destinations", "count", len(s.destinations))
+	} else if len(s.destinations) > 0 {
+		s.publish(ctx, &destination.Snapshot{CycleID: report.CycleID, Time: time.Now().UTC(), Devices: snapshotDevices(devices)})
+	}
+
+	report.FinishedAt = time.Now().UTC()
+	report.KandjiDevices = kandjiDevices
+	report.EligibleDevices = len(devices)
+	report.DesiredDevices = len(devices)
+	report.SanitizedSerials = sanitizer.changed
+	s.logSanitizedSerials(sanitizer.changed)
+
+	s.log.Info("Sync cycle complete",
+		"cycle_id", report.CycleID,
+		"kandji_devices_total", report.KandjiDevices,
+		"eligible_devices", report.EligibleDevices,
+		"owner_emails_added", report.OwnerEmailsAdded,
+		"owner_emails_removed", report.OwnerEmailsRemoved,
+		"missing_owners", len(report.MissingOwners),
+		"blocked_owner_removals", len(report.BlockedOwnerRemovals),
+		"sanitized_serials", len(report.SanitizedSerials),
+		"dry_run", report.DryRun)
+	return report, nil
+}
+
+// syncEmailList keeps the EMAIL list in step with the owners of the Kandji devices that are
+// present in the serial list at the end of this cycle, or of every device that passed the
+// filters with email_only. Owners without such a device are handled by applyOnMissing like
+// the entries of the serial list.
+func (s *Syncer) syncEmailList(ctx context.Context, report *Report, devices []kandji.Device, inSerialList map[string]struct{}) error {
+	listID := s.config.Cloudflare.EmailListID
+
+	// Desired owner emails, commented with the serials of the devices they own
+	ownerSerials := make(map[string][]string)
+	for _, device := range devices {
+		if device.UserEmail == "" {
+			continue
+		}
+		if _, ok := inSerialList[device.SerialNumber]; !ok {
+			continue
+		}
+		email := strings.ToLower(strings.TrimSpace(device.UserEmail))
+		ownerSerials[email] = append(ownerSerials[email], device.SerialNumber)
+	}
+
+	items, err := s.cloudflareClient.GetListItemsByID(ctx, listID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch email list items: %w", err)
+	}
+	current := make(map[string]struct{}, len(items))
+	for _, item := range items {
+		current[strings.ToLower(item.Value)] = struct{}{}
+	}
+
+	annotations := make(audit.Annotations)
+	ctx = audit.WithAnnotations(ctx, annotations)
+
+	var toAppend []cloudflare.GatewayListItemCreateRequest
+	for email, serials := range ownerSerials {
+		if _, exists := current[email]; exists {
+			continue
+		}
+		sort.Strings(serials)
+		toAppend = append(toAppend, cloudflare.GatewayListItemCreateRequest{
+			Value:   email,
+			Comment: cloudflare.WithMarker(strings.Join(serials, ", "), s.config.Cloudflare.ManagedMarker),
+		})
+		annotations[email] = audit.Annotation{Source: "kandji"}
+	}
+
+	var missingEntries []missingEntry
+	for _, item := range items {
+		email := strings.ToLower(item.Value)
+		if _, keep := ownerSerials[email]; !keep {
+			missingEntries = append(missingEntries, missingEntry{Value: item.Value, Comment: item.Comment, Key: email})
+		}
+	}
+	counter := s.newMissCounter(listID)
+	removals, missing := s.applyOnMissing(ctx, listID, missingEntries, counter)
+	s.recordMisses(listID, counter)
+	if s.config.OnMissing == "alert" {
+		report.MissingOwners = serialsOf(missing)
+	}
+	var toRemove []string
+	for _, entry := range removals {
+		toRemove = append(toRemove, entry.Value)
+		annotations[entry.Value] = audit.Annotation{Source: "on_missing", Comment: entry.Comment}
+	}
+
+	if s.removalsExceeded(ctx, listID, toRemove, len(items)) {
+		report.BlockedOwnerRemovals = toRemove
+		toRemove = nil
+	}
+
+	s.log.Debug("Computed email list changes", "list_id", listID, "owners", len(ownerSerials), "to_add", len(toAppend), "to_remove", len(toRemove))
+
+	if len(toRemove) > 0 {
+		result, err := s.cloudflareClient.DeleteItemsByID(ctx, listID, toRemove, s.config.Batch.Size)
+		if err != nil {
+			return fmt.Errorf("failed to remove owners from email list: %w", err)
+		}
+		for _, generalError := range result.Errors {
+			s.log.Error("Email list deletion error", "error", generalError)
+		}
+		report.OwnerEmailsRemoved = result.SuccessCount
+	}
+
+	if err := s.cloudflareClient.AppendItemsByID(ctx, listID, toAppend); err != nil {
+		return fmt.Errorf("failed to append owners to email list: %w", err)
+	}
+	report.OwnerEmailsAdded = len(toAppend)
+	return nil
+}
@@ -0,0 +1,180 @@
+package syncer
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/destination"
+	"kandji-cloudflare-device-sync/kandji"
+	"kandji-cloudflare-device-sync/state"
+)
+
+func TestSerialsInList(t *testing.T) {
+	removed := func(serial, source string, result string) destination.Change {
+		return destination.Change{SerialNumber: serial, Action: destination.ActionRemove, Source: source, Result: result}
+	}
+	tests := []struct {
+		name    string
+		target  []string
+		changes []destination.Change
+		added   []string
+		want    []string
+	}{
+		{name: "applied removal", target: []string{"A", "B"}, changes: []destination.Change{removed("B", "on_missing", destination.ResultOK)}, want: []string{"A"}},
+		{name: "failed removal stays", target: []string{"A", "B"}, changes: []destination.Change{removed("B", "on_missing", destination.ResultFailed)}, want: []string{"A", "B"}},
+		// Removals blocked by max_removals_per_cycle or within the grace are not among the changes
+		{name: "no removal", target: []string{"A", "B"}, want: []string{"A", "B"}},
+		{name: "added", target: []string{"A"}, added: []string{"C"}, want: []string{"A", "C"}},
+		{name: "unsanitized duplicate", target: []string{"A"}, changes: []destination.Change{removed("a ", "sanitized", destination.ResultOK)}, want: []string{"A"}},
+		{name: "unsanitized entry replaced", target: []string{"B"}, changes: []destination.Change{removed("a ", "sanitized", destination.ResultOK)}, added: []string{"A"}, want: []string{"A", "B"}},
+		{name: "additions are not removals", target: []string{"A"}, changes: []destination.Change{{SerialNumber: "A", Action: destination.ActionAdd, Result: destination.ResultOK}}, want: []string{"A"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for serial := range serialsInList(createSet(tt.target), tt.changes, tt.added) {
+				got = append(got, serial)
+			}
+			sort.Strings(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("serialsInList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncEmailList(t *testing.T) {
+	emails := []testItem{
+		{Value: "alice@example.com", Comment: managedItem},
+		{Value: "carol@example.com", Comment: managedItem},
+		{Value: "dave@example.com", Comment: "added by hand"},
+	}
+	devices := []kandji.Device{
+		{SerialNumber: "A1", UserEmail: "Alice@example.com"},
+		{SerialNumber: "B1", UserEmail: "bob@example.com"},
+		{SerialNumber: "E1", UserEmail: "erin@example.com"},
+	}
+	tests := []struct {
+		name        string
+		cfg         config.Config
+		wantValues  []string
+		wantRemoved int
+		wantBlocked []string
+		wantMissing []string
+	}{
+		{
+			name:        "delete",
+			cfg:         config.Config{OnMissing: "delete", DeleteScope: "all"},
+			wantValues:  []string{"alice@example.com", "erin@example.com"},
+			wantRemoved: 2,
+		},
+		{
+			name:        "delete managed only",
+			cfg:         config.Config{OnMissing: "delete", DeleteScope: "managed_only"},
+			wantValues:  []string{"alice@example.com", "dave@example.com", "erin@example.com"},
+			wantRemoved: 1,
+		},
+		{
+			name:        "removals exceed max_removals_per_cycle",
+			cfg:         config.Config{OnMissing: "delete", DeleteScope: "all", MaxRemovals: config.MaxRemovalsConfig{Count: 1}},
+			wantValues:  []string{"alice@example.com", "carol@example.com", "dave@example.com", "erin@example.com"},
+			wantBlocked: []string{"carol@example.com", "dave@example.com"},
+		},
+		{
+			name:        "alert",
+			cfg:         config.Config{OnMissing: "alert"},
+			wantValues:  []string{"alice@example.com", "carol@example.com", "dave@example.com", "erin@example.com"},
+			wantMissing: []string{"carol@example.com", "dave@example.com"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.Cloudflare.EmailListID = testEmailListID
+			s, _ := newTestSyncer(t, &cfg, nil, testList{ID: testEmailListID, Name: "Owners", Type: "EMAIL", Items: emails})
+			report := &Report{}
+			// B1 was not added to the serial list, so its owner is not wanted
+			if err := s.syncEmailList(context.Background(), report, devices, createSet([]string{"A1", "E1"})); err != nil {
+				t.Fatalf("syncEmailList() error = %v", err)
+			}
+			if got := valuesOf(t, s, testEmailListID); !slices.Equal(got, tt.wantValues) {
+				t.Errorf("list = %v, want %v", got, tt.wantValues)
+			}
+			if report.OwnerEmailsAdded != 1 || report.OwnerEmailsRemoved != tt.wantRemoved {
+				t.Errorf("added %d and removed %d owners, want 1 and %d", report.OwnerEmailsAdded, report.OwnerEmailsRemoved, tt.wantRemoved)
+			}
+			sort.Strings(report.BlockedOwnerRemovals)
+			if !slices.Equal(report.BlockedOwnerRemovals, tt.wantBlocked) {
+				t.Errorf("BlockedOwnerRemovals = %v, want %v", report.BlockedOwnerRemovals, tt.wantBlocked)
+			}
+			sort.Strings(report.MissingOwners)
+			if !slices.Equal(report.MissingOwners, tt.wantMissing) {
+				t.Errorf("MissingOwners = %v, want %v", report.MissingOwners, tt.wantMissing)
+			}
+		})
+	}
+}
+
+func TestSyncEmailOnlyGuardsRemovals(t *testing.T) {
+	emails := []testItem{
+		{Value: "alice@example.com", Comment: managedItem},
+		{Value: "bob@example.com", Comment: managedItem},
+		{Value: "carol@example.com", Comment: managedItem},
+	}
+	devices := []kandji.Device{{SerialNumber: "A1", UserEmail: "alice@example.com"}, {SerialNumber: "B1", UserEmail: "bob@example.com"}}
+	tests := []struct {
+		name        string
+		cfg         config.Config
+		devices     []kandji.Device
+		wantValues  []string
+		wantBlocked int
+	}{
+		{
+			name:       "owner without devices",
+			cfg:        config.Config{OnMissing: "delete", MaxRemovals: config.MaxRemovalsConfig{Percent: 50}},
+			devices:    devices,
+			wantValues: []string{"alice@example.com", "bob@example.com"},
+		},
+		{
+			name:        "empty fleet",
+			cfg:         config.Config{OnMissing: "delete", MaxRemovals: config.MaxRemovalsConfig{Percent: 50}},
+			wantValues:  []string{"alice@example.com", "bob@example.com", "carol@example.com"},
+			wantBlocked: 3,
+		},
+		{
+			name:       "first miss within the grace",
+			cfg:        config.Config{OnMissing: "delete", OnMissingGraceCycles: 2},
+			devices:    devices,
+			wantValues: []string{"alice@example.com", "bob@example.com", "carol@example.com"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.Cloudflare.EmailListID = testEmailListID
+			cfg.Cloudflare.EmailOnly = true
+			s, _ := newTestSyncer(t, &cfg, nil, testList{ID: testEmailListID, Name: "Owners", Type: "EMAIL", Items: emails})
+			if cfg.OnMissingGraceCycles > 0 {
+				store, err := state.Open(filepath.Join(t.TempDir(), "state.db"), 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				s.state = store
+			}
+			report, err := s.syncEmailOnly(context.Background(), &Report{}, len(tt.devices), tt.devices, newSerialSanitizer())
+			if err != nil {
+				t.Fatalf("syncEmailOnly() error = %v", err)
+			}
+			if got := valuesOf(t, s, testEmailListID); !slices.Equal(got, tt.wantValues) {
+				t.Errorf("list = %v, want %v", got, tt.wantValues)
+			}
+			if len(report.BlockedOwnerRemovals) != tt.wantBlocked {
+				t.Errorf("BlockedOwnerRemovals = %v, want %d", report.BlockedOwnerRemovals, tt.wantBlocked)
+			}
+		})
+	}
+}
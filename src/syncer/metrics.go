@@ -0,0 +1,85 @@
+package syncer
+
+import (
+	"kandji-cloudflare-device-sync/metrics"
+)
+
+// syncMetrics are the metrics the syncer exports when WithMetrics is set.
+type syncMetrics struct {
+	sourceItems         *metrics.GaugeVec
+	sourceContributed   *metrics.GaugeVec
+	sourceFetchDuration *metrics.HistogramVec
+	sourceFetchFailures *metrics.CounterVec
+	cycleDuration       *metrics.GaugeVec
+	cycleSLOBreaches    *metrics.CounterVec
+	cycleOverruns       *metrics.CounterVec
+	missedRuns          *metrics.GaugeVec
+	catchUps            *metrics.CounterVec
+	circuitOpen         *metrics.GaugeVec
+	blackout            *metrics.GaugeVec
+}
+
+func newSyncMetrics(reg *metrics.Registry) *syncMetrics {
+	return &syncMetrics{
+		sourceItems: reg.Gauge("kandji_cloudflare_sync_source_list_items",
+			"Items fetched from the source list in the last cycle.", "list_id", "list_name"),
+		sourceContributed: reg.Gauge("kandji_cloudflare_sync_source_list_contributed_serials",
+			"Serials in the merged set of the last cycle that neither Kandji nor any other source list provided.", "list_id", "list_name"),
+		sourceFetchDuration: reg.Histogram("kandji_cloudflare_sync_source_list_fetch_duration_seconds",
+			"Time taken to fetch the items of the source list.", metrics.DefaultBuckets, "list_id", "list_name"),
+		sourceFetchFailures: reg.Counter("kandji_cloudflare_sync_source_list_fetch_failures_total",
+			"Failed fetches of the source list.", "list_id", "list_name"),
+		cycleDuration: reg.Gauge("kandji_cloudflare_sync_cycle_duration_seconds",
+			"Duration of the last sync cycle."),
+		cycleSLOBreaches: reg.Counter("kandji_cloudflare_sync_cycle_slo_breaches_total",
+			"Sync cycles that took longer than cycle_slo."),
+		cycleOverruns: reg.Counter("kandji_cloudflare_sync_cycle_overruns_total",
+			"Sync cycles that ran past the next scheduled start."),
+		missedRuns: reg.Gauge("kandji_cloudflare_sync_missed_runs",
+			"Scheduled sync cycles missed while the service was down before it last started."),
+		catchUps: reg.Counter("kandji_cloudflare_sync_catch_ups_total",
+			"Full reconciliations run at startup because too many scheduled cycles were missed."),
+		circuitOpen: reg.Gauge("kandji_cloudflare_sync_circuit_open",
+			"Whether the circuit breaker of the API is open (1) or closed (0).", "api"),
+		blackout: reg.Gauge("kandji_cloudflare_sync_blackout",
+			"Whether sync cycles run in a blackout window (1) or not (0)."),
+	}
+}
+
+// WithMetrics registers the syncer's metrics in reg and records them every cycle.
+func WithMetrics(reg *metrics.Registry) Option {
+	return func(s *Syncer) {
+		s.metrics = newSyncMetrics(reg)
+	}
+}
+
+// sourceListStats is what a cycle learned about a single source list, for the metrics.
+type sourceListStats struct {
+	name    string
+	items   int
+	serials map[string]struct{}
+}
+
+// recordSourceLists sets the per-source list gauges from the lists fetched in this cycle.
+// Lists that were not fetched are dropped, so removed lists do not linger.
+func (m *syncMetrics) recordSourceLists(stats map[string]*sourceListStats, kandjiSerials map[string]struct{}) {
+	providers := make(map[string]int)
+	for _, st := range stats {
+		for serial := range st.serials {
+			providers[serial]++
+		}
+	}
+
+	m.sourceItems.Reset()
+	m.sourceContributed.Reset()
+	for id, st := range stats {
+		contributed := 0
+		for serial := range st.serials {
+			if _, inKandji := kandjiSerials[serial]; !inKandji && providers[serial] == 1 {
+				contributed++
+			}
+		}
+		m.sourceItems.Set(float64(st.items), id, st.name)
+		m.sourceContributed.Set(float64(contributed), id, st.name)
+	}
+}
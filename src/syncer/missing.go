@@ -0,0 +1,115 @@
+package syncer
+
+import (
+	"context"
+
+	"kandji-cloudflare-device-sync/notify"
+)
+
+// missingEntry is a list entry that none of the sources want in the list anymore.
+type missingEntry struct {
+	// Value is the entry as stored in the list
+	Value   string
+	Comment string
+	// Key identifies the entry across cycles, the sanitized serial or the lowercased email
+	Key string
+}
+
+// missCounter counts the consecutive cycles the entries of a list have been missing, for
+// on_missing_grace_cycles.
+type missCounter struct {
+	graceCycles int
+	misses      map[string]int
+	newMisses   map[string]int
+}
+
+// withinGrace counts one more miss for key and reports whether it is still within the grace.
+func (c *missCounter) withinGrace(key string) bool {
+	return countMiss(c.misses, c.newMisses, key, c.graceCycles)
+}
+
+// newMissCounter returns the counter of a list for on_missing_grace_cycles, seeded with the
+// misses recorded in the state store. It is nil if no grace is configured.
+func (s *Syncer) newMissCounter(listID string) *missCounter {
+	graceCycles := s.config.OnMissingGraceCycles
+	if graceCycles <= 1 || s.state == nil {
+		return nil
+	}
+	counter := &missCounter{graceCycles: graceCycles, newMisses: make(map[string]int)}
+	if listID == s.config.Cloudflare.ListID {
+		counter.misses = s.state.Misses()
+	} else {
+		counter.misses = s.state.ListMisses(listID)
+	}
+	return counter
+}
+
+// recordMisses saves the misses counter counted this cycle, which forgets the entries no
+// longer missing. Dry runs do not count.
+func (s *Syncer) recordMisses(listID string, counter *missCounter) {
+	if counter == nil || s.dryRun() {
+		return
+	}
+	var err error
+	if listID == s.config.Cloudflare.ListID {
+		err = s.state.RecordMisses(counter.newMisses)
+	} else {
+		err = s.state.RecordListMisses(listID, counter.newMisses)
+	}
+	if err != nil {
+		s.log.Error("Failed to record missing entries in state store", "list_id", listID, "error", err)
+	}
+}
+
+// applyOnMissing applies on_missing to the missing entries of a list the same way for every
+// list: with "alert" they are alerted and kept, with "delete" the entries within the delete
+// scope are returned for removal, unless counter keeps them within the grace. counter may be
+// nil to remove them on the first miss.
+func (s *Syncer) applyOnMissing(ctx context.Context, listID string, entries []missingEntry, counter *missCounter) ([]missingEntry, []notify.MissingDevice) {
+	switch s.config.OnMissing {
+	case "alert":
+		missing := make([]notify.MissingDevice, 0, len(entries))
+		for _, entry := range entries {
+			missing = append(missing, notify.MissingDevice{SerialNumber: entry.Value, Comment: entry.Comment})
+		}
+		s.alertMissing(ctx, listID, missing)
+		return nil, missing
+	case "delete":
+	default:
+		return nil, nil
+	}
+
+	var toRemove []missingEntry
+	skippedUnmanaged, withinGrace := 0, 0
+	for _, entry := range entries {
+		if !s.deletable(entry.Comment) {
+			skippedUnmanaged++
+			continue
+		}
+		if counter != nil && counter.withinGrace(entry.Key) {
+			withinGrace++
+			continue
+		}
+		toRemove = append(toRemove, entry)
+	}
+	if withinGrace > 0 {
+		s.log.Info("Keeping missing entries until they have been missing for on_missing_grace_cycles", "list_id", listID, "count", withinGrace, "on_missing_grace_cycles", counter.graceCycles)
+	}
+	if skippedUnmanaged > 0 {
+		s.log.Info("Keeping missing entries not created by this tool", "list_id", listID, "count", skippedUnmanaged, "delete_scope", s.config.DeleteScope)
+	}
+	return toRemove, nil
+}
+
+// removalsExceeded reports whether the removals from a list exceed max_removals_per_cycle.
+// Exceeding removals are logged and alerted, and none of them may be applied.
+func (s *Syncer) removalsExceeded(ctx context.Context, listID string, toRemove []string, listSize int) bool {
+	limit, exceeded := s.config.MaxRemovals.Exceeded(len(toRemove), listSize)
+	if !exceeded {
+		delete(s.alertedBlocked, listID)
+		return false
+	}
+	s.log.Error("Not removing entries from Cloudflare list, the removals exceed max_removals_per_cycle", "list_id", listID, "count", len(toRemove), "list_size", listSize, "limit", limit)
+	s.alertBlockedRemovals(ctx, listID, toRemove, listSize, limit)
+	return true
+}
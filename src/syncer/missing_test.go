@@ -0,0 +1,221 @@
+package syncer
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+
+	"kandji-cloudflare-device-sync/cloudflare"
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/kandji"
+	"kandji-cloudflare-device-sync/notify"
+	"kandji-cloudflare-device-sync/sandbox"
+	"kandji-cloudflare-device-sync/state"
+)
+
+const (
+	testListID      = "5a3f2c1b-0000-4000-8000-000000000001"
+	testEmailListID = "5a3f2c1b-0000-4000-8000-000000000002"
+	testMarker      = "[kandji-sync]"
+	managedItem     = testMarker + " laptop"
+)
+
+type testItem struct {
+	Value   string `json:"value"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// testList is a list the sandbox API is seeded with.
+type testList struct {
+	ID    string     `json:"id"`
+	Name  string     `json:"name"`
+	Type  string     `json:"type"`
+	Items []testItem `json:"items"`
+}
+
+// recordingNotifier keeps the events it is sent.
+type recordingNotifier struct {
+	events []*notify.Event
+}
+
+func (n *recordingNotifier) Name() string { return "recording" }
+
+func (n *recordingNotifier) Notify(ctx context.Context, event *notify.Event) error {
+	n.events = append(n.events, event)
+	return nil
+}
+
+// newTestSyncer returns a syncer whose Cloudflare client talks to the sandbox API, seeded
+// with a SERIAL list of testListID holding items and any further lists.
+func newTestSyncer(t *testing.T, cfg *config.Config, items []testItem, more ...testList) (*Syncer, *recordingNotifier) {
+	t.Helper()
+	dir := t.TempDir()
+	lists, err := json.Marshal(append([]testList{{ID: testListID, Name: "Devices", Type: "SERIAL", Items: items}}, more...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, sandbox.CloudflareListsFile), lists, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	sb, err := sandbox.Start(dir, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(sb.Close)
+
+	cfg.Cloudflare.ApiToken, cfg.Cloudflare.AccountID = "token", "account"
+	cfg.Cloudflare.ManagedMarker = testMarker
+	cfg.Batch.Size = 100
+	client, err := cloudflare.NewClient(cfg.Cloudflare, nil, log, cloudflare.WithBaseURL(sb.CloudflareURL()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier := &recordingNotifier{}
+	return New(nil, client, cfg, log, WithNotifiers(notifier)), notifier
+}
+
+// listValues returns the sorted values of the test list.
+func listValues(t *testing.T, s *Syncer) []string {
+	t.Helper()
+	return valuesOf(t, s, testListID)
+}
+
+// valuesOf returns the sorted values of a list.
+func valuesOf(t *testing.T, s *Syncer, listID string) []string {
+	t.Helper()
+	items, err := s.cloudflareClient.GetListItemsByID(context.Background(), listID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []string
+	for _, item := range items {
+		values = append(values, item.Value)
+	}
+	sort.Strings(values)
+	return values
+}
+
+func TestSyncPlatformListOnMissing(t *testing.T) {
+	items := []testItem{
+		{Value: "KEEP1", Comment: managedItem},
+		{Value: "GONE1", Comment: managedItem},
+		{Value: "MANUAL1", Comment: "added by hand"},
+	}
+	tests := []struct {
+		name        string
+		onMissing   string
+		deleteScope string
+		wantValues  []string
+		wantMissing []string
+		wantAlerts  int
+	}{
+		{name: "ignore", onMissing: "ignore", wantValues: []string{"GONE1", "KEEP1", "MANUAL1", "NEW1"}},
+		{name: "alert", onMissing: "alert", wantValues: []string{"GONE1", "KEEP1", "MANUAL1", "NEW1"}, wantMissing: []string{"GONE1", "MANUAL1"}, wantAlerts: 1},
+		{name: "delete", onMissing: "delete", deleteScope: "all", wantValues: []string{"KEEP1", "NEW1"}},
+		{name: "delete managed only", onMissing: "delete", deleteScope: "managed_only", wantValues: []string{"KEEP1", "MANUAL1", "NEW1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, notifier := newTestSyncer(t, &config.Config{OnMissing: tt.onMissing, DeleteScope: tt.deleteScope}, items)
+			devices := []kandji.Device{{SerialNumber: "KEEP1"}, {SerialNumber: "NEW1"}}
+			result := PlatformListResult{List: testListID}
+			if err := s.syncPlatformList(context.Background(), &result, devices, nil, newSerialSanitizer(), time.Now()); err != nil {
+				t.Fatalf("syncPlatformList() error = %v", err)
+			}
+			if got := listValues(t, s); !slices.Equal(got, tt.wantValues) {
+				t.Errorf("list = %v, want %v", got, tt.wantValues)
+			}
+			sort.Strings(result.Missing)
+			if !slices.Equal(result.Missing, tt.wantMissing) {
+				t.Errorf("Missing = %v, want %v", result.Missing, tt.wantMissing)
+			}
+			if len(notifier.events) != tt.wantAlerts {
+				t.Fatalf("sent %d alerts, want %d", len(notifier.events), tt.wantAlerts)
+			}
+			for _, event := range notifier.events {
+				if event.Type != notify.TypeMissingDevices || event.ListID != testListID {
+					t.Errorf("alert %s for list %s, want %s for %s", event.Type, event.ListID, notify.TypeMissingDevices, testListID)
+				}
+			}
+
+			// The same entries are not alerted again
+			if err := s.syncPlatformList(context.Background(), &result, devices, nil, newSerialSanitizer(), time.Now()); err != nil {
+				t.Fatalf("second syncPlatformList() error = %v", err)
+			}
+			if len(notifier.events) != tt.wantAlerts {
+				t.Errorf("second cycle sent %d alerts in all, want %d", len(notifier.events), tt.wantAlerts)
+			}
+		})
+	}
+}
+
+func TestSyncPlatformListGrace(t *testing.T) {
+	store, err := state.Open(filepath.Join(t.TempDir(), "state.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := []testItem{{Value: "KEEP1", Comment: managedItem}, {Value: "GONE1", Comment: managedItem}}
+	s, _ := newTestSyncer(t, &config.Config{OnMissing: "delete", OnMissingGraceCycles: 3}, items)
+	s.state = store
+	devices := []kandji.Device{{SerialNumber: "KEEP1"}}
+
+	wantValues := [][]string{{"GONE1", "KEEP1"}, {"GONE1", "KEEP1"}, {"KEEP1"}}
+	for cycle, want := range wantValues {
+		result := PlatformListResult{List: testListID}
+		if err := s.syncPlatformList(context.Background(), &result, devices, nil, newSerialSanitizer(), time.Now()); err != nil {
+			t.Fatalf("cycle %d: syncPlatformList() error = %v", cycle+1, err)
+		}
+		if got := listValues(t, s); !slices.Equal(got, want) {
+			t.Errorf("cycle %d: list = %v, want %v", cycle+1, got, want)
+		}
+	}
+	if misses := store.Misses(); len(misses) != 0 {
+		t.Errorf("target list misses = %v, want the routed list's misses kept apart", misses)
+	}
+}
+
+func TestSyncPlatformListMaxRemovals(t *testing.T) {
+	items := []testItem{
+		{Value: "KEEP1", Comment: managedItem},
+		{Value: "GONE1", Comment: managedItem},
+		{Value: "GONE2", Comment: managedItem},
+	}
+	cfg := &config.Config{OnMissing: "delete", MaxRemovals: config.MaxRemovalsConfig{Count: 1}}
+	s, notifier := newTestSyncer(t, cfg, items)
+	devices := []kandji.Device{{SerialNumber: "KEEP1"}, {SerialNumber: "NEW1"}}
+	result := PlatformListResult{List: testListID}
+	if err := s.syncPlatformList(context.Background(), &result, devices, nil, newSerialSanitizer(), time.Now()); err != nil {
+		t.Fatalf("syncPlatformList() error = %v", err)
+	}
+	if got, want := listValues(t, s), []string{"GONE1", "GONE2", "KEEP1", "NEW1"}; !slices.Equal(got, want) {
+		t.Errorf("list = %v, want %v", got, want)
+	}
+	sort.Strings(result.BlockedRemovals)
+	if want := []string{"GONE1", "GONE2"}; !slices.Equal(result.BlockedRemovals, want) {
+		t.Errorf("BlockedRemovals = %v, want %v", result.BlockedRemovals, want)
+	}
+	if len(notifier.events) != 1 || notifier.events[0].Type != notify.TypeRemovalsBlocked || notifier.events[0].ListID != testListID {
+		t.Fatalf("alerts = %v, want one %s for %s", notifier.events, notify.TypeRemovalsBlocked, testListID)
+	}
+
+	// Once within the limit the removals are applied
+	devices = append(devices, kandji.Device{SerialNumber: "GONE2"})
+	result = PlatformListResult{List: testListID}
+	if err := s.syncPlatformList(context.Background(), &result, devices, nil, newSerialSanitizer(), time.Now()); err != nil {
+		t.Fatalf("second syncPlatformList() error = %v", err)
+	}
+	if got, want := listValues(t, s), []string{"GONE2", "KEEP1", "NEW1"}; !slices.Equal(got, want) {
+		t.Errorf("second cycle: list = %v, want %v", got, want)
+	}
+	if len(result.BlockedRemovals) != 0 {
+		t.Errorf("second cycle: BlockedRemovals = %v, want none", result.BlockedRemovals)
+	}
+}
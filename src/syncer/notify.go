@@ -0,0 +1,129 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"kandji-cloudflare-device-sync/notify"
+)
+
+// alertMissing raises an on_missing alert for the entries of a list missing from all
+// sources. An alert is only sent when a serial is missing that was not in the previous
+// alert for the list, so the same orphans are not reported every cycle; the alert then lists
+// all of them.
+func (s *Syncer) alertMissing(ctx context.Context, listID string, missing []notify.MissingDevice) {
+	current := make(map[string]struct{}, len(missing))
+	changed := false
+	for _, device := range missing {
+		current[device.SerialNumber] = struct{}{}
+		if _, alerted := s.alertedMissing[listID][device.SerialNumber]; !alerted {
+			changed = true
+		}
+	}
+	if s.alertedMissing == nil {
+		s.alertedMissing = make(map[string]map[string]struct{})
+	}
+	if !changed {
+		if len(missing) > 0 {
+			s.log.Info("Devices missing from all sources were already alerted", "list_id", listID, "count", len(missing))
+		}
+		s.alertedMissing[listID] = current
+		return
+	}
+	if s.dryRun() {
+		s.log.Info("Dry run: not sending alert for devices missing from all sources", "list_id", listID, "count", len(missing))
+		return
+	}
+	s.alertedMissing[listID] = current
+	notify.Dispatch(ctx, s.notifiers, &notify.Event{
+		Type:    notify.TypeMissingDevices,
+		Time:    time.Now().UTC(),
+		ListID:  listID,
+		Missing: missing,
+	}, s.log)
+}
+
+// alertBlockedRemovals raises an alert for removals from a list that exceeded
+// max_removals_per_cycle. Like alertMissing, it is only raised again once the blocked serials
+// of the list change.
+func (s *Syncer) alertBlockedRemovals(ctx context.Context, listID string, serials []string, listSize int, limit string) {
+	current := createSet(serials)
+	changed := len(current) != len(s.alertedBlocked[listID])
+	for serial := range current {
+		if _, alerted := s.alertedBlocked[listID][serial]; !alerted {
+			changed = true
+		}
+	}
+	if !changed {
+		s.log.Info("Blocked removals were already alerted", "list_id", listID, "count", len(serials))
+		return
+	}
+	if s.dryRun() {
+		s.log.Info("Dry run: not sending alert for removals exceeding max_removals_per_cycle", "list_id", listID, "count", len(serials))
+		return
+	}
+	if s.alertedBlocked == nil {
+		s.alertedBlocked = make(map[string]map[string]struct{})
+	}
+	s.alertedBlocked[listID] = current
+	notify.Dispatch(ctx, s.notifiers, &notify.Event{
+		Type:   notify.TypeRemovalsBlocked,
+		Time:   time.Now().UTC(),
+		ListID: listID,
+		Removals: &notify.BlockedRemovals{
+			Serials:  serials,
+			ListSize: listSize,
+			Limit:    limit,
+		},
+	}, s.log)
+}
+
+// notifyCycleStarted tells the notifiers that a cycle is starting.
+func (s *Syncer) notifyCycleStarted(ctx context.Context) {
+	notify.Dispatch(ctx, s.notifiers, &notify.Event{
+		Type:   notify.TypeCycleStarted,
+		Time:   time.Now().UTC(),
+		ListID: s.config.Cloudflare.ListID,
+	}, s.log)
+}
+
+// notifyCycle sends the summary of a finished cycle to the notifiers, preceded by a
+// TypeListUnavailable event if the cycle could not read the target list.
+func (s *Syncer) notifyCycle(ctx context.Context, report *Report, syncErr error) {
+	if errors.Is(syncErr, ErrTargetListUnavailable) {
+		notify.Dispatch(ctx, s.notifiers, &notify.Event{
+			Type:   notify.TypeListUnavailable,
+			Time:   time.Now().UTC(),
+			ListID: s.config.Cloudflare.ListID,
+			Error:  syncErr.Error(),
+		}, s.log)
+	}
+	event := &notify.Event{
+		Type:   notify.TypeCycleCompleted,
+		Time:   time.Now().UTC(),
+		ListID: s.config.Cloudflare.ListID,
+		Summary: &notify.Summary{
+			StartedAt:   report.StartedAt,
+			FinishedAt:  report.FinishedAt,
+			Devices:     report.DesiredDevices,
+			Added:       report.Added,
+			Removed:     report.Removed,
+			FailedToAdd: report.FailedToAdd,
+		},
+	}
+	if syncErr != nil {
+		event.Type = notify.TypeCycleFailed
+		event.Error = syncErr.Error()
+	}
+	notify.Dispatch(ctx, s.notifiers, event, s.log)
+}
+
+// serialsOf returns the serials of the missing devices.
+func serialsOf(missing []notify.MissingDevice) []string {
+	serials := make([]string, 0, len(missing))
+	for _, device := range missing {
+		serials = append(serials, device.SerialNumber)
+	}
+	return serials
+}
@@ -0,0 +1,236 @@
+package syncer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"kandji-cloudflare-device-sync/audit"
+	"kandji-cloudflare-device-sync/cloudflare"
+	"kandji-cloudflare-device-sync/destination"
+)
+
+// ErrPlanStale is returned by ApplyPlan when the target list changed after the plan was
+// computed.
+var ErrPlanStale = errors.New("target list changed since the plan was computed")
+
+// Plan is the change a sync cycle would make to the target list, computed by a dry run so
+// it can be reviewed and then applied exactly by ApplyPlan. It covers the target list
+// only, not the routed, tag or owner email lists.
+type Plan struct {
+	CreatedAt time.Time `json:"created_at"`
+	ListID    string    `json:"list_id"`
+	// SyncMode is the sync_mode the plan was computed with; a replace plan is applied by
+	// replacing the list, which also updates comments
+	SyncMode string `json:"sync_mode"`
+	// TargetFingerprint identifies the contents of the target list the plan was computed
+	// against, see TargetFingerprint
+	TargetFingerprint string     `json:"target_fingerprint"`
+	TargetItems       int        `json:"target_items"`
+	Add               []PlanItem `json:"add"`
+	Remove            []PlanItem `json:"remove"`
+	// UpdateComments are entries kept with a new comment, only computed with sync_mode replace
+	UpdateComments []PlanCommentUpdate `json:"update_comments,omitempty"`
+}
+
+// PlanItem is an entry a plan adds or removes.
+type PlanItem struct {
+	SerialNumber string `json:"serial_number"`
+	Comment      string `json:"comment"`
+	// Reason is the sources of an added entry, or why an entry is removed: on_missing,
+	// expired or sanitized
+	Reason string `json:"reason"`
+}
+
+// PlanCommentUpdate is an entry a plan keeps with a different comment.
+type PlanCommentUpdate struct {
+	SerialNumber string `json:"serial_number"`
+	Comment      string `json:"comment"`
+	NewComment   string `json:"new_comment"`
+}
+
+// Empty reports whether the plan changes nothing.
+func (p *Plan) Empty() bool {
+	return len(p.Add) == 0 && len(p.Remove) == 0 && len(p.UpdateComments) == 0
+}
+
+// Plan computes the change the next sync cycle would make to the target list without
+// making it. The syncer must be configured with dry_run, so nothing else is changed either.
+func (s *Syncer) Plan(ctx context.Context) (*Plan, error) {
+	if !s.config.DryRun {
+		return nil, errors.New("a plan is computed by a dry run, the syncer must be configured with dry_run")
+	}
+	if s.config.Cloudflare.EmailOnly {
+		return nil, errors.New("email_only has no target list to plan for")
+	}
+	s.plan = &Plan{
+		CreatedAt: time.Now().UTC(),
+		ListID:    s.config.Cloudflare.ListID,
+		SyncMode:  s.config.SyncMode,
+		Add:       []PlanItem{},
+		Remove:    []PlanItem{},
+	}
+	defer func() { s.plan = nil }()
+	plan := s.plan
+	if _, err := s.Sync(ctx); err != nil {
+		return nil, err
+	}
+	if plan.TargetFingerprint == "" {
+		return nil, errors.New("the sync cycle did not reach the target list")
+	}
+	return plan, nil
+}
+
+// recordPlan fills in the plan being computed from the changes the cycle computed for the
+// target list.
+func (s *Syncer) recordPlan(targetItems []cloudflare.GatewayListItem, changes []destination.Change, candidates *commentCandidates) {
+	s.plan.TargetFingerprint = TargetFingerprint(targetItems)
+	s.plan.TargetItems = len(targetItems)
+	removed := make(map[string]struct{})
+	for _, change := range changes {
+		item := PlanItem{SerialNumber: change.SerialNumber, Comment: change.Comment, Reason: change.Source}
+		switch change.Action {
+		case destination.ActionAdd:
+			s.plan.Add = append(s.plan.Add, item)
+		case destination.ActionRemove:
+			s.plan.Remove = append(s.plan.Remove, item)
+			removed[change.SerialNumber] = struct{}{}
+		}
+	}
+	if s.config.SyncMode != "replace" {
+		return
+	}
+	// A replacement writes kept entries with their resolved comment, as replaceTarget does
+	seen := make(map[string]struct{}, len(targetItems))
+	for _, item := range targetItems {
+		if _, ok := removed[item.Value]; ok {
+			continue
+		}
+		if _, ok := seen[item.Value]; ok {
+			continue
+		}
+		seen[item.Value] = struct{}{}
+		sources, ok := candidates.bySerial[item.Value]
+		if !ok {
+			continue
+		}
+		resolved, ok := s.resolveComment(sources)
+		if !ok {
+			continue
+		}
+		if comment := s.entryComment(resolved); comment != item.Comment {
+			s.plan.UpdateComments = append(s.plan.UpdateComments, PlanCommentUpdate{SerialNumber: item.Value, Comment: item.Comment, NewComment: comment})
+		}
+	}
+}
+
+// TargetFingerprint returns a hash of the values and comments of a list's items, whatever
+// their order.
+func TargetFingerprint(items []cloudflare.GatewayListItem) string {
+	entries := make([]string, 0, len(items))
+	for _, item := range items {
+		entries = append(entries, item.Value+"\x00"+item.Comment)
+	}
+	sort.Strings(entries)
+	hash := sha256.New()
+	for _, entry := range entries {
+		hash.Write([]byte(entry + "\n"))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// ApplyPlan makes exactly the change of a plan to its target list, refusing with
+// ErrPlanStale if the list no longer has the contents the plan was computed against. A diff
+// plan removes and then appends entries in batches of batchSize; a replace plan replaces
+// the list with the entries kept, with their updated comments, and the ones added.
+func ApplyPlan(ctx context.Context, client *cloudflare.Client, plan *Plan, batchSize int, log *slog.Logger) error {
+	targetItems, err := client.GetListItemsByID(ctx, plan.ListID)
+	if err != nil {
+		return fmt.Errorf("failed to get items of target list: %w", err)
+	}
+	if TargetFingerprint(targetItems) != plan.TargetFingerprint {
+		return fmt.Errorf("%w: %d entries now, %d when planned", ErrPlanStale, len(targetItems), plan.TargetItems)
+	}
+	if plan.Empty() {
+		log.Info("Plan makes no changes to the target list", "list_id", plan.ListID)
+		return nil
+	}
+
+	cycleID := newCycleID()
+	annotations := make(audit.Annotations)
+	for _, item := range plan.Remove {
+		annotations[item.SerialNumber] = audit.Annotation{Source: item.Reason, Comment: item.Comment}
+	}
+	for _, item := range plan.Add {
+		annotations[item.SerialNumber] = audit.Annotation{Source: item.Reason}
+	}
+	ctx = audit.WithAnnotations(audit.WithCycleID(ctx, cycleID), annotations)
+	log.Info("Applying plan to target list", "cycle_id", cycleID, "list_id", plan.ListID, "add", len(plan.Add), "remove", len(plan.Remove), "update_comments", len(plan.UpdateComments))
+
+	if plan.SyncMode == "replace" {
+		return client.ReplaceItemsByID(ctx, plan.ListID, plan.replacement(targetItems))
+	}
+
+	if len(plan.Remove) > 0 {
+		serials := make([]string, 0, len(plan.Remove))
+		for _, item := range plan.Remove {
+			serials = append(serials, item.SerialNumber)
+		}
+		result, err := client.DeleteItemsByID(ctx, plan.ListID, serials, batchSize)
+		if err != nil {
+			return fmt.Errorf("failed to remove devices: %w", err)
+		}
+		if len(result.FailedDevices) > 0 || len(result.Errors) > 0 {
+			return fmt.Errorf("failed to remove %d of %d devices, nothing was appended", len(serials)-result.SuccessCount, len(serials))
+		}
+	}
+	items := make([]cloudflare.GatewayListItemCreateRequest, 0, len(plan.Add))
+	for _, item := range plan.Add {
+		items = append(items, cloudflare.GatewayListItemCreateRequest{Value: item.SerialNumber, Comment: item.Comment})
+	}
+	if err := client.AppendItemsByID(ctx, plan.ListID, items); err != nil {
+		return fmt.Errorf("failed to append devices: %w", err)
+	}
+	return nil
+}
+
+// replacement returns the complete contents a replace plan writes to the target list.
+func (p *Plan) replacement(targetItems []cloudflare.GatewayListItem) []cloudflare.GatewayListItemCreateRequest {
+	removed := make(map[string]struct{}, len(p.Remove))
+	for _, item := range p.Remove {
+		removed[item.SerialNumber] = struct{}{}
+	}
+	comments := make(map[string]string, len(p.UpdateComments))
+	for _, update := range p.UpdateComments {
+		comments[update.SerialNumber] = update.NewComment
+	}
+	items := make([]cloudflare.GatewayListItemCreateRequest, 0, len(targetItems)+len(p.Add))
+	seen := make(map[string]struct{}, len(targetItems)+len(p.Add))
+	for _, item := range targetItems {
+		if _, ok := removed[item.Value]; ok {
+			continue
+		}
+		if _, ok := seen[item.Value]; ok {
+			continue
+		}
+		seen[item.Value] = struct{}{}
+		comment := item.Comment
+		if updated, ok := comments[item.Value]; ok {
+			comment = updated
+		}
+		items = append(items, cloudflare.GatewayListItemCreateRequest{Value: item.Value, Comment: comment})
+	}
+	for _, item := range p.Add {
+		if _, ok := seen[item.SerialNumber]; ok {
+			continue
+		}
+		seen[item.SerialNumber] = struct{}{}
+		items = append(items, cloudflare.GatewayListItemCreateRequest{Value: item.SerialNumber, Comment: item.Comment})
+	}
+	return items
+}
@@ -0,0 +1,134 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"kandji-cloudflare-device-sync/audit"
+	"kandji-cloudflare-device-sync/cloudflare"
+	"kandji-cloudflare-device-sync/kandji"
+)
+
+// PlatformListResult summarizes the sync of a list that platform_routing or blueprint_routing
+// routes devices to.
+type PlatformListResult struct {
+	// List is the list as referenced in the routing, by ID or name
+	List    string   `json:"list"`
+	ListID  string   `json:"list_id,omitempty"`
+	Devices int      `json:"devices"`
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	// Missing are the entries alerted with on_missing "alert"
+	Missing []string `json:"missing,omitempty"`
+	// BlockedRemovals are the removals not applied because they exceeded
+	// max_removals_per_cycle
+	BlockedRemovals []string `json:"blocked_removals,omitempty"`
+	Error           string   `json:"error,omitempty"`
+}
+
+// syncPlatformLists brings every list that platform_routing or blueprint_routing routes to
+// in line with the devices routed to it. A failure is logged and only affects its own list.
+func (s *Syncer) syncPlatformLists(ctx context.Context, routed map[string][]kandji.Device, deviceExpiry map[string]time.Time, sanitizer *serialSanitizer, now time.Time) []PlatformListResult {
+	var results []PlatformListResult
+	for _, ref := range s.config.Cloudflare.RoutedListRefs() {
+		result := PlatformListResult{List: ref, Devices: len(routed[ref])}
+		if err := s.syncPlatformList(ctx, &result, routed[ref], deviceExpiry, sanitizer, now); err != nil {
+			result.Error = err.Error()
+			s.log.Error("Failed to sync routed list", "list", ref, "list_id", result.ListID, "error", err)
+			// The list may have been recreated under the same name, resolve it again next cycle
+			s.cloudflareClient.InvalidateListRef(ref)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// syncPlatformList adds the routed devices missing from a list, and removes entries the way
+// they are removed from the target list: expired entries always, entries of devices that are
+// no longer routed to the list as applyOnMissing decides, and entries stored with unsanitized
+// serials so the sanitized serial is added in their place.
+func (s *Syncer) syncPlatformList(ctx context.Context, result *PlatformListResult, devices []kandji.Device, deviceExpiry map[string]time.Time, sanitizer *serialSanitizer, now time.Time) error {
+	listID, err := s.cloudflareClient.ResolveListID(ctx, result.List)
+	if err != nil {
+		return err
+	}
+	result.ListID = listID
+	items, err := s.cloudflareClient.GetListItemsByID(ctx, listID)
+	if err != nil {
+		return fmt.Errorf("failed to get list items: %w", err)
+	}
+
+	wanted := make(map[string]struct{}, len(devices))
+	for _, device := range devices {
+		wanted[device.SerialNumber] = struct{}{}
+	}
+	current := make(map[string]struct{}, len(items))
+	annotations := make(audit.Annotations)
+	ctx = audit.WithAnnotations(ctx, annotations)
+	var toRemove []string
+	var missingEntries []missingEntry
+	for _, item := range items {
+		serial := sanitizer.sanitize(listID, item.Value)
+		_, want := wanted[serial]
+		switch {
+		case s.expired(item.Comment, now):
+			toRemove = append(toRemove, item.Value)
+			annotations[item.Value] = audit.Annotation{Source: "expired", Comment: item.Comment}
+			current[serial] = struct{}{}
+		case serial != item.Value && (want || serial == "") && s.deletable(item.Comment):
+			toRemove = append(toRemove, item.Value)
+			annotations[item.Value] = audit.Annotation{Source: "sanitized", Comment: item.Comment}
+		case !want:
+			missingEntries = append(missingEntries, missingEntry{Value: item.Value, Comment: item.Comment, Key: serial})
+		default:
+			current[serial] = struct{}{}
+		}
+	}
+	counter := s.newMissCounter(listID)
+	removals, missing := s.applyOnMissing(ctx, listID, missingEntries, counter)
+	s.recordMisses(listID, counter)
+	for _, entry := range removals {
+		toRemove = append(toRemove, entry.Value)
+		annotations[entry.Value] = audit.Annotation{Source: "on_missing", Comment: entry.Comment}
+	}
+	result.Missing = serialsOf(missing)
+
+	var toAdd []cloudflare.GatewayListItemCreateRequest
+	var added []string
+	for _, device := range devices {
+		if _, ok := current[device.SerialNumber]; ok {
+			continue
+		}
+		current[device.SerialNumber] = struct{}{}
+		comment := s.composeComment(device)
+		if expiresAt, ok := deviceExpiry[device.SerialNumber]; ok {
+			comment = cloudflare.WithExpiry(comment, expiresAt)
+		}
+		toAdd = append(toAdd, cloudflare.GatewayListItemCreateRequest{Value: device.SerialNumber, Comment: s.entryComment(comment)})
+		added = append(added, device.SerialNumber)
+		annotations[device.SerialNumber] = audit.Annotation{Source: "kandji"}
+	}
+
+	if s.removalsExceeded(ctx, listID, toRemove, len(items)) {
+		result.BlockedRemovals = toRemove
+		toRemove = nil
+	}
+	if len(toRemove) > 0 {
+		removed, err := s.cloudflareClient.DeleteItemsByID(ctx, listID, toRemove, s.config.Batch.Size)
+		if err != nil {
+			return fmt.Errorf("failed to remove entries: %w", err)
+		}
+		if len(removed.Errors) > 0 {
+			return fmt.Errorf("failed to remove entries: %v", removed.Errors)
+		}
+		result.Removed = toRemove
+	}
+	if err := s.cloudflareClient.AppendItemsByID(ctx, listID, toAdd); err != nil {
+		return fmt.Errorf("failed to add entries: %w", err)
+	}
+	result.Added = added
+
+	s.log.Info("Routed list synced", "list", result.List, "list_id", listID, "devices", len(devices), "added", len(added), "removed", len(toRemove))
+	return nil
+}
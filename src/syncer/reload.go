@@ -0,0 +1,58 @@
+package syncer
+
+import (
+	"kandji-cloudflare-device-sync/cloudflare"
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/internal/breaker"
+	"kandji-cloudflare-device-sync/kandji"
+)
+
+// reload is a configuration queued by Reload, with the clients to sync with from then on.
+type reload struct {
+	cfg              *config.Config
+	kandjiClient     *kandji.Client
+	cloudflareClient *cloudflare.Client
+}
+
+// Reload queues a new configuration and clients, applied before the next cycle or by the
+// Run loop while it waits for one, so a cycle in progress finishes with the previous ones.
+// A reload queued while another one is pending replaces it. Run takes the sync interval
+// from the new configuration.
+func (s *Syncer) Reload(cfg *config.Config, kClient *kandji.Client, cClient *cloudflare.Client) {
+	r := reload{cfg: cfg, kandjiClient: kClient, cloudflareClient: cClient}
+	for {
+		select {
+		case s.reloads <- r:
+			return
+		default:
+		}
+		// Drop the pending reload, this one supersedes it
+		select {
+		case <-s.reloads:
+		default:
+		}
+	}
+}
+
+// applyReload switches to a reloaded configuration and its clients. The circuit breakers
+// are only replaced, and their state lost, if their settings changed; what is known about
+// the target list is dropped if the target list changed.
+func (s *Syncer) applyReload(r reload) {
+	old := s.config
+	s.config, s.kandjiClient, s.cloudflareClient = r.cfg, r.kandjiClient, r.cloudflareClient
+	if r.cfg.CircuitBreaker != old.CircuitBreaker {
+		s.kandjiBreaker, s.cloudflareBreaker = nil, nil
+		if r.cfg.CircuitBreaker.Enabled {
+			s.kandjiBreaker = breaker.New(r.cfg.CircuitBreaker.FailureThreshold, r.cfg.CircuitBreaker.Cooldown)
+			s.cloudflareBreaker = breaker.New(r.cfg.CircuitBreaker.FailureThreshold, r.cfg.CircuitBreaker.Cooldown)
+		}
+	}
+	if r.cfg.Cloudflare.ListID != old.Cloudflare.ListID {
+		s.knownTarget = nil
+	}
+	s.log.Info("Applied reloaded configuration",
+		"interval", r.cfg.SyncInterval.String(),
+		"target_list_id", r.cfg.Cloudflare.ListID,
+		"on_missing", r.cfg.OnMissing,
+		"dry_run", r.cfg.DryRun)
+}
@@ -0,0 +1,67 @@
+package syncer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Report summarizes the outcome of a single sync cycle.
+type Report struct {
+	// CycleID identifies the cycle in logs and the audit log
+	CycleID            string    `json:"cycle_id"`
+	StartedAt          time.Time `json:"started_at"`
+	FinishedAt         time.Time `json:"finished_at"`
+	KandjiDevices      int       `json:"kandji_devices"`
+	IntuneDevices      int       `json:"intune_devices,omitempty"`
+	MosyleDevices      int       `json:"mosyle_devices,omitempty"`
+	FileDevices        int       `json:"file_devices,omitempty"`
+	EligibleDevices    int       `json:"eligible_devices"`
+	DesiredDevices     int       `json:"desired_devices"`
+	Added              []string  `json:"added"`
+	Removed            []string  `json:"removed"`
+	FailedToAdd        []string  `json:"failed_to_add,omitempty"`
+	OwnerEmailsAdded   int       `json:"owner_emails_added"`
+	OwnerEmailsRemoved int       `json:"owner_emails_removed"`
+	Missing            []string  `json:"missing,omitempty"`
+	// MissingOwners are the owner email list entries reported by on_missing "alert"
+	MissingOwners []string `json:"missing_owners,omitempty"`
+	// BlockedRemovals are the removals not applied because they exceeded max_removals_per_cycle
+	BlockedRemovals []string `json:"blocked_removals,omitempty"`
+	// BlockedOwnerRemovals are the owner email list removals blocked the same way
+	BlockedOwnerRemovals []string             `json:"blocked_owner_removals,omitempty"`
+	Conflicts            []Conflict           `json:"conflicts,omitempty"`
+	SanitizedSerials     []SanitizedSerial    `json:"sanitized_serials,omitempty"`
+	PlatformLists        []PlatformListResult `json:"platform_lists,omitempty"`
+	TagLists             []PlatformListResult `json:"tag_lists,omitempty"`
+	WarmStart            bool                 `json:"warm_start,omitempty"`
+	MissedRuns           int                  `json:"missed_runs,omitempty"`
+	Resumed              bool                 `json:"resumed,omitempty"`
+	DryRun               bool                 `json:"dry_run,omitempty"`
+	// Blackout is the blackout window the cycle ran in, which made it a dry run
+	Blackout string `json:"blackout,omitempty"`
+}
+
+// Conflict describes a serial contributed by more than one source with differing comments.
+type Conflict struct {
+	SerialNumber string          `json:"serial_number"`
+	Candidates   []CommentSource `json:"candidates"`
+	// Resolution is the comment that was chosen, empty if the serial was skipped
+	Resolution string `json:"resolution"`
+	Skipped    bool   `json:"skipped,omitempty"`
+}
+
+// CommentSource is the comment a single source would write for a serial.
+type CommentSource struct {
+	// Source is "kandji" or "kandji:" and a tenant name, "intune", "file:" and the path of
+	// a source file, "static", or the ID of the Cloudflare source list
+	Source  string `json:"source"`
+	Comment string `json:"comment"`
+}
+
+// newCycleID returns a random ID for a sync cycle.
+func newCycleID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
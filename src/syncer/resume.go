@@ -0,0 +1,142 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"kandji-cloudflare-device-sync/audit"
+	"kandji-cloudflare-device-sync/cloudflare"
+	"kandji-cloudflare-device-sync/state"
+)
+
+// savePendingMutation records the operations an interrupted cycle left unapplied, so the next
+// cycle applies them instead of computing a new diff.
+func (s *Syncer) savePendingMutation(pending *state.PendingMutation) {
+	if s.state == nil {
+		s.log.Warn("Sync cycle interrupted, the next cycle computes a new diff since there is no state store",
+			"remove", len(pending.Remove), "append", len(pending.Append))
+		return
+	}
+	pending.ListID = s.config.Cloudflare.ListID
+	pending.InterruptedAt = time.Now().UTC()
+	if err := s.state.SetPendingMutation(pending); err != nil {
+		s.log.Error("Failed to save the operations left by the interrupted sync cycle", "error", err)
+		return
+	}
+	s.log.Warn("Sync cycle interrupted, saved the remaining operations to resume on the next cycle",
+		"remove", len(pending.Remove), "append", len(pending.Append))
+}
+
+// pendingAppends returns the entries an interrupted append would have written, deduplicated
+// the way appendNewDevices does.
+func (s *Syncer) pendingAppends(toAdd []deviceWithComment, targetSerialSet map[string]struct{}) []state.ListItem {
+	var items []state.ListItem
+	seen := make(map[string]struct{}, len(toAdd))
+	for _, d := range toAdd {
+		if _, exists := targetSerialSet[d.SerialNumber]; exists {
+			continue
+		}
+		if _, exists := seen[d.SerialNumber]; exists {
+			continue
+		}
+		seen[d.SerialNumber] = struct{}{}
+		items = append(items, state.ListItem{Value: d.SerialNumber, Comment: s.entryComment(d.Comment)})
+	}
+	return items
+}
+
+// pendingMutation returns the operations left by an interrupted cycle, if any are saved for
+// the current target list. Operations saved for another list are dropped.
+func (s *Syncer) pendingMutation() *state.PendingMutation {
+	if s.state == nil {
+		return nil
+	}
+	pending := s.state.PendingMutation()
+	if pending == nil || pending.ListID == s.config.Cloudflare.ListID {
+		return pending
+	}
+	s.log.Warn("Dropping operations of an interrupted sync cycle saved for another target list", "list_id", pending.ListID)
+	s.clearPendingMutation()
+	return nil
+}
+
+func (s *Syncer) clearPendingMutation() {
+	if err := s.state.SetPendingMutation(nil); err != nil {
+		s.log.Error("Failed to clear the operations of the interrupted sync cycle", "error", err)
+	}
+}
+
+// resumeMutation applies exactly the operations an interrupted cycle left unapplied, without
+// computing a new diff against state that may have changed since. If it is interrupted
+// again, what is still left stays saved.
+func (s *Syncer) resumeMutation(ctx context.Context, report *Report, pending *state.PendingMutation) (*Report, error) {
+	report.Resumed = true
+	s.knownTarget = nil
+	annotations := make(audit.Annotations)
+	for _, serial := range pending.Remove {
+		annotations[serial] = audit.Annotation{Source: "resumed"}
+	}
+	for _, item := range pending.Append {
+		annotations[item.Value] = audit.Annotation{Source: "resumed"}
+	}
+	ctx = audit.WithAnnotations(ctx, annotations)
+	// Batches of the diff applied before the interruption are skipped; a rest saved without
+	// its diff ID is applied in full
+	ctx = cloudflare.ContextWithMutationID(ctx, pending.MutationID)
+	s.log.Info("Resuming the operations of an interrupted sync cycle",
+		"interrupted_at", pending.InterruptedAt.Format(time.RFC3339),
+		"remove", len(pending.Remove),
+		"append", len(pending.Append))
+
+	if len(pending.Remove) > 0 {
+		result, err := s.cloudflareClient.DeleteDevices(ctx, pending.Remove, s.config.Batch.Size)
+		if err != nil {
+			return report, fmt.Errorf("failed to resume removals: %w", err)
+		}
+		if len(result.Pending) > 0 {
+			pending.Remove = result.Pending
+			s.savePendingMutation(pending)
+			return report, fmt.Errorf("resumed sync cycle interrupted while removing devices: %w", ctx.Err())
+		}
+		failed := make(map[string]struct{}, len(result.FailedDevices))
+		for _, failedDevice := range result.FailedDevices {
+			failed[failedDevice.SerialNumber] = struct{}{}
+			s.log.Error("Failed to delete device", "serial_number", failedDevice.SerialNumber, "error", failedDevice.Error)
+		}
+		for _, generalError := range result.Errors {
+			s.log.Error("Bulk deletion error", "error", generalError)
+		}
+		for _, serial := range pending.Remove {
+			if _, ok := failed[serial]; !ok {
+				report.Removed = append(report.Removed, serial)
+			}
+		}
+		pending.Remove = nil
+	}
+
+	if len(pending.Append) > 0 {
+		items := make([]cloudflare.GatewayListItemCreateRequest, 0, len(pending.Append))
+		var serials []string
+		for _, item := range pending.Append {
+			items = append(items, cloudflare.GatewayListItemCreateRequest{Value: item.Value, Comment: item.Comment})
+			serials = append(serials, item.Value)
+		}
+		if err := s.cloudflareClient.AppendDevices(ctx, items, s.config.Batch.Size); err != nil {
+			if ctx.Err() != nil {
+				s.savePendingMutation(pending)
+				return report, fmt.Errorf("resumed sync cycle interrupted while adding devices: %w", err)
+			}
+			// Not retried as is, the next cycle computes a new diff
+			s.clearPendingMutation()
+			report.FailedToAdd = serials
+			return report, fmt.Errorf("failed to resume additions: %w", err)
+		}
+		report.Added = serials
+	}
+
+	s.clearPendingMutation()
+	report.FinishedAt = time.Now().UTC()
+	s.log.Info("Resumed interrupted sync cycle", "removed", len(report.Removed), "added", len(report.Added))
+	return report, nil
+}
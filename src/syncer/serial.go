@@ -0,0 +1,85 @@
+package syncer
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"kandji-cloudflare-device-sync/cloudflare"
+)
+
+// SanitizeSerial strips what copy-pasted serial numbers commonly pick up: whitespace,
+// invisible formatting characters such as zero-width spaces and byte order marks, control
+// characters, and surrounding quotes.
+func SanitizeSerial(serial string) string {
+	cleaned := strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+		return r
+	}, serial)
+	return strings.TrimFunc(cleaned, isQuote)
+}
+
+func isQuote(r rune) bool {
+	switch r {
+	case '"', '\'', '`', '‘', '’', '‚', '“', '”', '„', '«', '»':
+		return true
+	}
+	return false
+}
+
+// SanitizedSerial is a serial number that was changed by sanitization.
+type SanitizedSerial struct {
+	// Source is "kandji", "intune", "file:" and the path of a source file, "static", the ID
+	// of the Cloudflare source list, or "target"
+	Source    string `json:"source"`
+	Original  string `json:"original"`
+	Sanitized string `json:"sanitized"`
+}
+
+// serialSanitizer sanitizes the serials read during a cycle and records those it changed.
+type serialSanitizer struct {
+	changed []SanitizedSerial
+	seen    map[SanitizedSerial]struct{}
+}
+
+func newSerialSanitizer() *serialSanitizer {
+	return &serialSanitizer{seen: make(map[SanitizedSerial]struct{})}
+}
+
+// sanitize returns the sanitized serial, recording it if it differs from the original.
+func (z *serialSanitizer) sanitize(source, serial string) string {
+	sanitized := SanitizeSerial(serial)
+	if sanitized != serial {
+		change := SanitizedSerial{Source: source, Original: serial, Sanitized: sanitized}
+		if _, ok := z.seen[change]; !ok {
+			z.seen[change] = struct{}{}
+			z.changed = append(z.changed, change)
+		}
+	}
+	return sanitized
+}
+
+// sanitizeItems returns the list items with sanitized values, dropping items left empty.
+func (z *serialSanitizer) sanitizeItems(source string, items []cloudflare.GatewayListItem) []cloudflare.GatewayListItem {
+	sanitized := make([]cloudflare.GatewayListItem, 0, len(items))
+	for _, item := range items {
+		item.Value = z.sanitize(source, item.Value)
+		if item.Value == "" {
+			continue
+		}
+		sanitized = append(sanitized, item)
+	}
+	return sanitized
+}
+
+// logSanitizedSerials logs every sanitized serial, quoting the original so hidden characters show.
+func (s *Syncer) logSanitizedSerials(changed []SanitizedSerial) {
+	for _, change := range changed {
+		s.log.Warn("Sanitized serial number",
+			"source", change.Source,
+			"original", strconv.QuoteToASCII(change.Original),
+			"sanitized", change.Sanitized)
+	}
+}
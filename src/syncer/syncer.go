@@ -2,112 +2,563 @@ package syncer
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"math/rand/v2"
+	"path"
+	"strings"
 	"time"
 
+	"kandji-cloudflare-device-sync/audit"
 	"kandji-cloudflare-device-sync/cloudflare"
 	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/destination"
+	"kandji-cloudflare-device-sync/events"
+	"kandji-cloudflare-device-sync/filesource"
+	"kandji-cloudflare-device-sync/internal/breaker"
+	"kandji-cloudflare-device-sync/intune"
 	"kandji-cloudflare-device-sync/kandji"
+	"kandji-cloudflare-device-sync/mosyle"
+	"kandji-cloudflare-device-sync/notify"
+	"kandji-cloudflare-device-sync/state"
 )
 
+// ErrTargetListUnavailable is wrapped by the error of a cycle that could not read the target
+// list.
+var ErrTargetListUnavailable = errors.New("target list unavailable")
+
 // Syncer orchestrates the synchronization from Kandji to Cloudflare.
 type Syncer struct {
 	kandjiClient     *kandji.Client
 	cloudflareClient *cloudflare.Client
 	config           *config.Config
 	log              *slog.Logger
+	destinations     []destination.Destination
+	notifiers        []notify.Notifier
+	// patternLists holds the lists matched by source_list_patterns in the previous cycle (ID -> name)
+	patternLists map[string]string
+	state        *state.Store
+	metrics      *syncMetrics
+	events       *events.Buffer
+	// triggers queues at most one requested immediate cycle
+	triggers chan string
+	// warmCache is the cache loaded at startup, cleared once the first cycle considered it
+	warmCache       *state.WarmCache
+	warmCacheMaxAge time.Duration
+	// knownTarget is the target list as left by the last cycle, see WarmCache
+	knownTarget *state.WarmCache
+	// interval is the schedule Run is on, recorded with every cycle
+	interval time.Duration
+	// missedRuns is set at startup if the first cycle catches up after downtime
+	missedRuns int
+	// alertedMissing holds the serials of the last on_missing alert of each list, by list
+	// ID, see alertMissing
+	alertedMissing map[string]map[string]struct{}
+	// alertedBlocked holds the serials of the last max_removals_per_cycle alert of each
+	// list, by list ID, see alertBlockedRemovals
+	alertedBlocked map[string]map[string]struct{}
+	// seenSerials are the eligible Kandji serials of the last cycle, see recordCycle
+	seenSerials []string
+	// kandjiBreaker and cloudflareBreaker are nil unless circuit_breaker is enabled
+	kandjiBreaker     *breaker.Breaker
+	cloudflareBreaker *breaker.Breaker
+	// intuneClient is nil unless the Intune source is enabled
+	intuneClient *intune.Client
+	// mosyleClient is nil unless Mosyle is enabled, and kandjiClient is nil if Mosyle
+	// replaces Kandji
+	mosyleClient *mosyle.Client
+	// kandjiTenants are the additional Kandji tenants, see WithKandjiTenants
+	kandjiTenants []KandjiTenant
+	// reloads queues at most one reloaded configuration, see Reload
+	reloads chan reload
+	// plan is filled in by the cycle Plan runs, nil otherwise
+	plan *Plan
+	// isLeader is nil unless leader election is enabled, see WithLeaderElection
+	isLeader func() bool
+	// control is the pause state and last outcome served by the admin API
+	control control
+	// blackout is the blackout window the cycle in progress runs in, see checkBlackout
+	blackout string
+}
+
+// KandjiTenant is the client of an additional Kandji tenant.
+type KandjiTenant struct {
+	Name   string
+	Client *kandji.Client
+}
+
+// Option configures optional Syncer behaviour.
+type Option func(*Syncer)
+
+// WithDestinations sets the destinations that receive the synced device set after every cycle.
+func WithDestinations(destinations ...destination.Destination) Option {
+	return func(s *Syncer) {
+		s.destinations = append(s.destinations, destinations...)
+	}
+}
+
+// WithNotifiers sets the notifiers that the alerts raised by the sync cycles are sent to.
+func WithNotifiers(notifiers ...notify.Notifier) Option {
+	return func(s *Syncer) {
+		s.notifiers = append(s.notifiers, notifiers...)
+	}
+}
+
+// WithStateStore sets the state store that every cycle is recorded in.
+func WithStateStore(store *state.Store) Option {
+	return func(s *Syncer) {
+		s.state = store
+	}
+}
+
+// WithIntune sets the client whose Intune devices are merged into the target list.
+func WithIntune(client *intune.Client) Option {
+	return func(s *Syncer) {
+		s.intuneClient = client
+	}
+}
+
+// WithMosyle sets the client whose Mosyle devices are merged with the Kandji devices.
+func WithMosyle(client *mosyle.Client) Option {
+	return func(s *Syncer) {
+		s.mosyleClient = client
+	}
+}
+
+// WithKandjiTenants sets the clients of additional Kandji tenants, whose devices are merged
+// with those of the primary tenant before filtering.
+func WithKandjiTenants(tenants ...KandjiTenant) Option {
+	return func(s *Syncer) {
+		s.kandjiTenants = append(s.kandjiTenants, tenants...)
+	}
+}
+
+// WithEvents sets the buffer that the outcome of every cycle is recorded in.
+func WithEvents(buffer *events.Buffer) Option {
+	return func(s *Syncer) {
+		s.events = buffer
+	}
+}
+
+// WithLeaderElection makes Run skip the cycles that are due while isLeader reports that
+// another replica is the leader. Cycles run by RunOnce are not affected.
+func WithLeaderElection(isLeader func() bool) Option {
+	return func(s *Syncer) {
+		s.isLeader = isLeader
+	}
+}
+
+// deviceWithComment is a serial to append to the target list along with its comment.
+type deviceWithComment struct {
+	SerialNumber string
+	Comment      string
 }
 
 // New creates a new Syncer.
-func New(kClient *kandji.Client, cClient *cloudflare.Client, cfg *config.Config, log *slog.Logger) *Syncer {
-	return &Syncer{
+func New(kClient *kandji.Client, cClient *cloudflare.Client, cfg *config.Config, log *slog.Logger, opts ...Option) *Syncer {
+	s := &Syncer{
 		kandjiClient:     kClient,
 		cloudflareClient: cClient,
 		config:           cfg,
 		log:              log,
+		patternLists:     make(map[string]string),
+		triggers:         make(chan string, 1),
+		reloads:          make(chan reload, 1),
+	}
+	if cfg.CircuitBreaker.Enabled {
+		s.kandjiBreaker = breaker.New(cfg.CircuitBreaker.FailureThreshold, cfg.CircuitBreaker.Cooldown)
+		s.cloudflareBreaker = breaker.New(cfg.CircuitBreaker.FailureThreshold, cfg.CircuitBreaker.Cooldown)
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
 // Run starts the synchronization loop, running at the specified interval.
 func (s *Syncer) Run(ctx context.Context, syncInterval time.Duration) {
 	s.log.Info("Starting sync process",
 		"interval", syncInterval.String(),
+		"sync_jitter", s.config.SyncJitter.String(),
 		"on_missing", s.config.OnMissing,
+		"dry_run", s.config.DryRun,
 		"sync_devices_without_owners", s.config.Kandji.SyncDevicesWithoutOwners,
 		"sync_mobile_devices", s.config.Kandji.SyncMobileDevices,
+		"platforms", s.config.Kandji.Platforms,
 		"include_tags", s.config.Kandji.IncludeTags,
 		"exclude_tags", s.config.Kandji.ExcludeTags,
 		"blueprints_include", s.config.Kandji.BlueprintsInclude,
-		"blueprints_exclude", s.config.Kandji.BlueprintsExclude)
+		"blueprints_exclude", s.config.Kandji.BlueprintsExclude,
+		"models_include", s.config.Kandji.ModelsInclude,
+		"models_exclude", s.config.Kandji.ModelsExclude,
+		"asset_tags_include", s.config.Kandji.AssetTagsInclude,
+		"asset_tags_exclude", s.config.Kandji.AssetTagsExclude,
+		"require_asset_tag", s.config.Kandji.RequireAssetTag,
+		"intune", s.config.Intune.Enabled,
+		"mosyle", s.config.Mosyle.Enabled,
+		"kandji_tenants", len(s.kandjiTenants),
+		"source_files", s.config.SourceFiles,
+		"min_os_version", s.config.Kandji.MinOSVersion)
 
-	ticker := time.NewTicker(syncInterval)
+	s.interval = syncInterval
+	s.checkMissedRuns(time.Now().UTC())
+	s.markProgress()
+	defer s.flushState()
+
+	if !s.splay(ctx) {
+		s.log.Info("Sync process stopping due to context cancellation.")
+		return
+	}
+	ticker := time.NewTicker(s.nextInterval(syncInterval))
 	defer ticker.Stop()
 
-	// Run a sync immediately on start-up
-	s.Sync(ctx)
+	// Cycles outlive ctx by up to the drain timeout, so a shutdown does not cut off the
+	// cycle in progress halfway through a batch
+	cycleCtx, cancelCycles := DrainContext(ctx, s.config.Shutdown.DrainTimeout)
+	defer cancelCycles()
+
+	// Run a sync on start-up, right after the startup splay
+	scheduled := s.config
+	s.runScheduledCycle(cycleCtx, ticker, syncInterval)
 
 	for {
+		// A reload, applied here or at the start of a cycle, can change the interval
+		if s.config != scheduled {
+			scheduled = s.config
+			if interval := scheduled.SyncInterval; interval > 0 && interval != syncInterval {
+				syncInterval, s.interval = interval, interval
+				ticker.Reset(s.nextInterval(syncInterval))
+				s.log.Info("Sync interval changed by reload", "interval", syncInterval.String())
+			}
+		}
 		select {
 		case <-ticker.C:
-			s.Sync(ctx)
+			// Every interval gets its own jitter
+			if s.config.SyncJitter > 0 {
+				ticker.Reset(s.nextInterval(syncInterval))
+			}
+		case reason := <-s.triggers:
+			if s.isLeader != nil && !s.isLeader() {
+				s.log.Info("Not the leader, ignoring triggered sync cycle", "reason", reason)
+				continue
+			}
+			if s.Paused() {
+				s.log.Info("Sync loop paused, ignoring triggered sync cycle", "reason", reason)
+				continue
+			}
+			s.log.Info("Running triggered sync cycle", "reason", reason)
+		case r := <-s.reloads:
+			s.applyReload(r)
+			continue
 		case <-ctx.Done():
 			s.log.Info("Sync process stopping due to context cancellation.")
 			return
 		}
+		// A tick or trigger that raced with the shutdown does not start a cycle
+		if ctx.Err() != nil {
+			s.log.Info("Sync process stopping due to context cancellation.")
+			return
+		}
+		s.runScheduledCycle(cycleCtx, ticker, syncInterval)
 	}
 }
 
-// Sync performs a single synchronization cycle.
-func (s *Syncer) Sync(ctx context.Context) {
-	s.log.Info("Starting new sync cycle")
+// nextInterval returns interval plus a random delay of up to sync_jitter, so that instances
+// started at the same moment drift apart.
+func (s *Syncer) nextInterval(interval time.Duration) time.Duration {
+	if s.config.SyncJitter <= 0 {
+		return interval
+	}
+	return interval + rand.N(s.config.SyncJitter)
+}
 
-	// 1. Get devices from Kandji and filter
-	kandjiDevices, err := s.kandjiClient.GetDevices(ctx)
-	if err != nil {
-		s.log.Error("Failed to get devices from Kandji", "error", err)
-		return
+// splay waits a random delay of up to startup_splay before the first cycle, so that
+// instances started together do not all call the APIs at once. It returns false if ctx was
+// done first.
+func (s *Syncer) splay(ctx context.Context) bool {
+	if s.config.StartupSplay <= 0 {
+		return true
+	}
+	delay := rand.N(s.config.StartupSplay)
+	s.log.Info("Delaying the first sync cycle by the startup splay", "delay", delay.Round(time.Millisecond).String(), "startup_splay", s.config.StartupSplay.String())
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		s.markProgress()
+		return true
+	case <-ctx.Done():
+		return false
 	}
-	s.log.Debug("Successfully fetched devices from Kandji", "count", len(kandjiDevices))
+}
 
-	var filteredKandjiSerials []string
-	var filteredKandjiDevices []kandji.Device
-	for _, device := range kandjiDevices {
-		if device.SerialNumber == "" {
-			s.log.Debug("Skipping device with empty serial number", "device_name", device.DeviceName)
-			continue
-		}
-		if !s.config.Kandji.SyncDevicesWithoutOwners && device.UserEmail == "" {
-			s.log.Debug("Skipping device without owner", "serial_number", device.SerialNumber)
-			continue
-		}
-		if !s.config.Kandji.SyncMobileDevices && (device.Platform == "iPhone" || device.Platform == "iPad") {
-			s.log.Debug("Skipping mobile device", "serial_number", device.SerialNumber)
-			continue
+// DrainContext returns the context to run sync cycles with when ctx stops the service: it
+// is cancelled timeout after ctx, so a cycle in progress at shutdown can finish, or with ctx
+// if timeout is zero. The returned function releases its resources.
+func DrainContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	cycleCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		timer := time.AfterFunc(timeout, cancel)
+		context.AfterFunc(cycleCtx, func() { timer.Stop() })
+	})
+	return cycleCtx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// Trigger requests an immediate sync cycle from the Run loop. Requests made while one is
+// already queued are coalesced; Trigger returns false in that case.
+func (s *Syncer) Trigger(reason string) bool {
+	select {
+	case s.triggers <- reason:
+		return true
+	default:
+		return false
+	}
+}
+
+// checkMissedRuns counts the cycles the schedule of the last recorded cycle would have
+// run while the service was down. If more than catch_up.threshold were missed, the warm
+// cache is dropped so the first cycle is a full reconciliation, and its report carries
+// the gap.
+func (s *Syncer) checkMissedRuns(now time.Time) {
+	if s.state == nil {
+		return
+	}
+	cycles := s.state.Cycles()
+	if len(cycles) == 0 {
+		return
+	}
+	last := cycles[len(cycles)-1]
+	missed := last.MissedRuns(now)
+	if s.metrics != nil {
+		s.metrics.missedRuns.Set(float64(missed))
+	}
+	if missed <= s.config.CatchUp.Threshold {
+		if missed > 0 {
+			s.log.Info("Missed scheduled sync cycles during downtime", "missed_runs", missed, "threshold", s.config.CatchUp.Threshold)
 		}
-		if len(s.config.Kandji.IncludeTags) > 0 && !s.deviceHasAnyTag(device, s.config.Kandji.IncludeTags) {
-			continue
+		return
+	}
+
+	s.log.Warn("Missed scheduled sync cycles during downtime, running a full reconciliation",
+		"missed_runs", missed,
+		"threshold", s.config.CatchUp.Threshold,
+		"last_cycle", last.StartedAt.Format(time.RFC3339),
+		"interval", last.Interval.String())
+	s.missedRuns = missed
+	s.warmCache = nil
+	if s.metrics != nil {
+		s.metrics.catchUps.Inc()
+	}
+}
+
+// runCycle runs a single sync cycle and logs its failure.
+func (s *Syncer) runCycle(ctx context.Context) {
+	if _, err := s.RunOnce(ctx); err != nil {
+		s.log.Error("Sync cycle failed", "error", err)
+	}
+}
+
+// runScheduledCycle runs a cycle of the sync loop and checks its duration against the cycle
+// SLO and the sync interval. A cycle that ran past the next scheduled start is handled
+// according to on_overlap, so overlapping runs never stack up: "skip" drops the runs that
+// were due during the cycle and keeps to the schedule, "delay" starts the next run a full
+// interval after this one finished.
+func (s *Syncer) runScheduledCycle(ctx context.Context, ticker *time.Ticker, interval time.Duration) {
+	if s.isLeader != nil && !s.isLeader() {
+		s.log.Debug("Not the leader, skipping sync cycle")
+		s.markProgress()
+		return
+	}
+	if s.Paused() {
+		s.log.Info("Sync loop paused, skipping sync cycle")
+		s.markProgress()
+		return
+	}
+	start := time.Now()
+	s.runCycle(ctx)
+	duration := time.Since(start)
+
+	if s.metrics != nil {
+		s.metrics.cycleDuration.Set(duration.Seconds())
+	}
+	if slo := s.config.CycleSLO; slo > 0 && duration > slo {
+		s.log.Warn("Sync cycle exceeded its duration SLO", "duration", duration.String(), "slo", slo.String())
+		if s.metrics != nil {
+			s.metrics.cycleSLOBreaches.Inc()
 		}
-		if len(s.config.Kandji.ExcludeTags) > 0 && s.deviceHasAnyTag(device, s.config.Kandji.ExcludeTags) {
-			continue
+	}
+	if duration < interval || ctx.Err() != nil {
+		return
+	}
+
+	if s.metrics != nil {
+		s.metrics.cycleOverruns.Inc()
+	}
+	// Drop the tick that fired while the cycle was running
+	select {
+	case <-ticker.C:
+	default:
+	}
+	if s.config.OnOverlap == "delay" {
+		ticker.Reset(s.nextInterval(interval))
+		s.log.Warn("Sync cycle overran the sync interval, delaying the next run",
+			"duration", duration.String(), "interval", interval.String(), "next_run_in", interval.String())
+		return
+	}
+	s.log.Warn("Sync cycle overran the sync interval, skipping overlapping runs",
+		"duration", duration.String(), "interval", interval.String(), "skipped_runs", int(duration/interval))
+}
+
+// RunOnce runs a single sync cycle like Run does, recording it in the state store, and
+// returns its report. A reload queued since the last cycle is applied first.
+func (s *Syncer) RunOnce(ctx context.Context) (*Report, error) {
+	select {
+	case r := <-s.reloads:
+		s.applyReload(r)
+	default:
+	}
+	s.checkBlackout(time.Now())
+	if !s.dryRun() {
+		s.notifyCycleStarted(ctx)
+	}
+	s.cycleStarted()
+	report, err := s.Sync(ctx)
+	s.cycleFinished(report, err)
+	if s.state != nil && !s.dryRun() {
+		s.recordCycle(report, err)
+	}
+	if s.events != nil {
+		s.recordCycleEvent(report, err)
+	}
+	if !s.dryRun() {
+		s.notifyCycle(ctx, report, err)
+	}
+	return report, err
+}
+
+// recordCycleEvent records the outcome of a cycle in the event buffer.
+func (s *Syncer) recordCycleEvent(report *Report, syncErr error) {
+	if syncErr != nil {
+		s.events.Record(events.TypeCycleFailed, "Sync cycle failed", "error", syncErr)
+		return
+	}
+	s.events.Record(events.TypeCycleFinished, "Sync cycle finished",
+		"duration", report.FinishedAt.Sub(report.StartedAt).String(),
+		"devices", report.DesiredDevices,
+		"added", len(report.Added),
+		"removed", len(report.Removed),
+		"failed_to_add", len(report.FailedToAdd))
+}
+
+// recordCycle persists the outcome of a cycle in the state store.
+func (s *Syncer) recordCycle(report *Report, syncErr error) {
+	cycle := state.Cycle{
+		StartedAt:  report.StartedAt,
+		FinishedAt: report.FinishedAt,
+		Devices:    report.DesiredDevices,
+		Added:      report.Added,
+		Removed:    report.Removed,
+		Failed:     report.FailedToAdd,
+		Interval:   s.interval,
+	}
+	if cycle.FinishedAt.IsZero() {
+		cycle.FinishedAt = time.Now().UTC()
+	}
+	if syncErr != nil {
+		cycle.Error = syncErr.Error()
+	}
+	if syncErr == nil {
+		if err := s.state.RecordSeen(s.seenSerials, cycle.FinishedAt); err != nil {
+			s.log.Error("Failed to record seen devices in state store", "error", err)
 		}
+	}
+	// Recording the cycle writes everything the cycle recorded to disk in one save
+	if err := s.state.RecordCycle(cycle); err != nil {
+		s.log.Error("Failed to record sync cycle in state store", "error", err)
+	}
+}
 
-		// Blueprint filtering
-		if !s.deviceMatchesBlueprint(&device) {
-			continue
+// flushState writes what was recorded in the state store but not written yet when the sync
+// loop stops.
+func (s *Syncer) flushState() {
+	if s.state == nil {
+		return
+	}
+	if err := s.state.Flush(); err != nil {
+		s.log.Error("Failed to write state store", "error", err)
+	}
+}
+
+// Sync performs a single synchronization cycle and returns its report. The report is
+// returned even if the cycle failed part way through.
+func (s *Syncer) Sync(ctx context.Context) (*Report, error) {
+	report := &Report{CycleID: newCycleID(), StartedAt: time.Now().UTC(), MissedRuns: s.missedRuns, DryRun: s.dryRun(), Blackout: s.blackout}
+	s.log.Info("Starting new sync cycle", "cycle_id", report.CycleID)
+	if s.blackout != "" {
+		ctx = cloudflare.ContextWithDryRun(ctx)
+	}
+	s.missedRuns = 0
+	// List mutations are audited with the cycle and, once known, why each item changed
+	annotations := make(audit.Annotations)
+	ctx = audit.WithAnnotations(audit.WithCycleID(ctx, report.CycleID), annotations)
+	// The diff of the cycle is identified by its ID, also when an interruption saves its rest
+	ctx = cloudflare.ContextWithMutationID(ctx, report.CycleID)
+	s.seenSerials = nil
+	if err := s.checkCircuits(report.StartedAt); err != nil {
+		return report, err
+	}
+
+	// 0. Apply what an interrupted cycle left unapplied before computing a new diff
+	if pending := s.pendingMutation(); pending != nil {
+		if !s.dryRun() {
+			report, err := s.resumeMutation(ctx, report, pending)
+			if err != nil {
+				s.apiFailed(ctx, "cloudflare", s.cloudflareBreaker, err)
+			} else {
+				s.apiSucceeded(ctx, "cloudflare", s.cloudflareBreaker)
+			}
+			return report, err
 		}
+		s.log.Info("Dry run: not resuming interrupted sync cycle", "remove", len(pending.Remove), "append", len(pending.Append))
+	}
 
-		filteredKandjiDevices = append(filteredKandjiDevices, device)
-		filteredKandjiSerials = append(filteredKandjiSerials, device.SerialNumber)
-		s.log.Debug("Including device for sync", "serial_number", device.SerialNumber)
+	// 1. Get devices from Kandji, its additional tenants and Mosyle and filter. Mosyle
+	// devices are treated like Kandji devices from here on.
+	kandjiDevices, err := s.fetchDevices(ctx, report)
+	if err != nil {
+		return report, err
+	}
+	kandjiHash := inventoryHash(kandjiDevices)
+
+	now := time.Now().UTC()
+	sanitizer := newSerialSanitizer()
+	eligible, err := s.filterDevices(kandjiDevices, sanitizer, now)
+	if err != nil {
+		return report, err
+	}
+	filteredKandjiDevices, filteredKandjiSerials := eligible.devices, eligible.serials
+	routed, routedDevices := eligible.routed, eligible.routedDevices
+	deviceExpiry := eligible.expiry
+	s.log.Info("Total new devices in Kandji that pass filters", "count", len(filteredKandjiDevices), "routed_by_platform", len(routedDevices))
+	if s.config.Cloudflare.EmailOnly {
+		return s.syncEmailOnly(ctx, report, len(kandjiDevices), filteredKandjiDevices, sanitizer)
 	}
-	s.log.Info("Total new devices in Kandji that pass filters", "count", len(filteredKandjiDevices))
 
 	// 2. Fetch serials from all source Cloudflare lists
 	mergedSourceSerials := createSet(filteredKandjiSerials)
 
 	sourceListDescriptions := make(map[string]string) // listID -> description
+	sourceStats := make(map[string]*sourceListStats)
 
-	for _, sourceListID := range s.config.Cloudflare.SourceListIDs {
+	sourceListIDs, sourceListRefs := s.resolveSourceLists(ctx)
+	for _, sourceListID := range sourceListIDs {
 		listType, err := s.cloudflareClient.GetListTypeByID(ctx, sourceListID)
 		if err != nil {
 			s.log.Error("Failed to fetch type for source Cloudflare list", "list_id", sourceListID, "error", err)
@@ -128,150 +579,908 @@ func (s *Syncer) Sync(ctx context.Context) {
 		if err == nil && sourceListMeta.Description != "" {
 			sourceListDescriptions[sourceListID] = sourceListMeta.Description
 		}
+		sourceListName := sourceListRefs[sourceListID]
+		if err == nil && sourceListMeta.Name != "" {
+			sourceListName = sourceListMeta.Name
+		}
 
+		fetchStart := time.Now()
 		items, err := s.cloudflareClient.GetListItemsByID(ctx, sourceListID)
+		if s.metrics != nil {
+			s.metrics.sourceFetchDuration.Observe(time.Since(fetchStart).Seconds(), sourceListID, sourceListName)
+		}
 		if err != nil {
 			s.log.Error("Failed to fetch items from source Cloudflare list", "list_id", sourceListID, "error", err)
+			if s.metrics != nil {
+				s.metrics.sourceFetchFailures.Inc(sourceListID, sourceListName)
+			}
+			// The list may have been recreated under the same name, resolve it again next cycle
+			s.cloudflareClient.InvalidateListRef(sourceListRefs[sourceListID])
 			continue
 		}
+		stats := &sourceListStats{name: sourceListName, items: len(items), serials: make(map[string]struct{})}
+		items = sanitizer.sanitizeItems(sourceListID, items)
 		for _, item := range items {
+			if s.expired(item.Comment, now) {
+				continue
+			}
 			mergedSourceSerials[item.Value] = struct{}{}
+			stats.serials[item.Value] = struct{}{}
 		}
+		sourceStats[sourceListID] = stats
 		s.log.Info("Merged serials from source Cloudflare list", "list_id", sourceListID, "count", len(items))
 	}
-
-	// 3. Fetch current serials from target Cloudflare list
-	targetSerials, err := s.cloudflareClient.GetListItems(ctx)
-	if err != nil {
-		s.log.Error("Failed to get devices from Cloudflare target list", "error", err)
-		return
-	}
-	targetSerialSet := make(map[string]struct{}, len(targetSerials))
-	for _, serial := range targetSerials {
-		targetSerialSet[serial] = struct{}{}
+	if s.metrics != nil {
+		s.metrics.recordSourceLists(sourceStats, createSet(filteredKandjiSerials))
 	}
-	s.log.Debug("Fetched serials from target Cloudflare list", "count", len(targetSerials))
 
-	// 4. Remove any devices from the target list that are not in the merged set (if on_missing == "delete")
-	var toRemove []string
-	if s.config.OnMissing == "delete" {
-		for serial := range targetSerialSet {
-			if _, keep := mergedSourceSerials[serial]; !keep {
-				toRemove = append(toRemove, serial)
-			}
+	// Intune devices are merged like a source list. A failed download fails the cycle, so
+	// on_missing does not remove the devices it would have kept.
+	var intuneDevices []intune.Device
+	if s.intuneClient != nil {
+		devices, err := s.intuneClient.GetDevices(ctx)
+		if err != nil {
+			return report, fmt.Errorf("failed to get devices from Intune: %w", err)
 		}
-		if len(toRemove) > 0 {
-			s.log.Info("Deleting devices in target Cloudflare list that are not present in merged sources", "count", len(toRemove), "batch_size", s.config.Batch.Size)
-			result, err := s.cloudflareClient.DeleteDevices(ctx, toRemove, s.config.Batch.Size)
-			if err != nil {
-				s.log.Error("Failed to delete missing devices", "error", err)
-				return
-			}
-			s.log.Info("Bulk device deletion completed", "success_count", result.SuccessCount, "failed_count", len(result.FailedDevices), "error_count", len(result.Errors))
-			for _, failedDevice := range result.FailedDevices {
-				s.log.Error("Failed to delete device", "serial_number", failedDevice.SerialNumber, "error", failedDevice.Error)
+		for _, device := range devices {
+			device.SerialNumber = sanitizer.sanitize("intune", device.SerialNumber)
+			if device.SerialNumber == "" {
+				s.log.Debug("Skipping Intune device with empty serial number", "device_name", device.DeviceName)
+				continue
 			}
-			for _, generalError := range result.Errors {
-				s.log.Error("Bulk deletion error", "error", generalError)
+			mergedSourceSerials[device.SerialNumber] = struct{}{}
+			intuneDevices = append(intuneDevices, device)
+		}
+		report.IntuneDevices = len(devices)
+		s.log.Info("Merged serials from Intune", "count", len(intuneDevices))
+	}
+
+	// Source files are read again every cycle, and merged like a source list. A file that
+	// cannot be read fails the cycle, like a failed Intune download.
+	var fileEntries []filesource.Entry
+	if len(s.config.SourceFiles) > 0 {
+		entries, err := filesource.Read(s.config.SourceFiles)
+		if err != nil {
+			return report, fmt.Errorf("failed to read source files: %w", err)
+		}
+		for _, entry := range entries {
+			entry.SerialNumber = sanitizer.sanitize("file:"+entry.File, entry.SerialNumber)
+			if entry.SerialNumber == "" || s.expired(entry.Comment, now) {
+				continue
 			}
+			mergedSourceSerials[entry.SerialNumber] = struct{}{}
+			fileEntries = append(fileEntries, entry)
 		}
+		report.FileDevices = len(fileEntries)
+		s.log.Info("Merged serials from source files", "count", len(fileEntries))
 	}
 
-	// 5. Push any new devices to target list
-	type deviceWithComment struct {
-		SerialNumber string
-		Comment      string
+	// Static serials are always desired, so on_missing never removes them
+	var staticSerials []config.StaticSerial
+	for _, static := range s.config.StaticSerials {
+		static.SerialNumber = sanitizer.sanitize("static", static.SerialNumber)
+		if static.SerialNumber == "" {
+			continue
+		}
+		mergedSourceSerials[static.SerialNumber] = struct{}{}
+		staticSerials = append(staticSerials, static)
 	}
 
-	var toAdd []deviceWithComment
+	// 3. Fetch current serials from target Cloudflare list, unless the warm cache has them.
+	// A replacement is always built from the list as it is now.
+	replace := s.config.SyncMode == "replace"
+	var targetItems []cloudflare.GatewayListItem
+	warm := false
+	if !replace {
+		targetItems, warm = s.warmTargetItems(kandjiHash)
+	}
+	if !warm {
+		targetItems, err = s.cloudflareClient.GetListItemsByID(ctx, s.config.Cloudflare.ListID)
+		if err != nil {
+			s.apiFailed(ctx, "cloudflare", s.cloudflareBreaker, err)
+			return report, fmt.Errorf("failed to get devices from Cloudflare target list: %w: %w", ErrTargetListUnavailable, err)
+		}
+	}
+	report.WarmStart = warm
+	// targetKnown is cleared if a removal or an append fails, the warm cache is not kept then
+	targetKnown := true
+	targetSerialSet := make(map[string]struct{}, len(targetItems))
+	for _, item := range targetItems {
+		if SanitizeSerial(item.Value) == item.Value {
+			targetSerialSet[item.Value] = struct{}{}
+		}
+	}
+	s.log.Debug("Fetched serials from target Cloudflare list", "count", len(targetItems))
+
+	// 4. Remove any devices from the target list that are not in the merged set (if on_missing == "delete"),
+	// and any entries whose embedded expiry has passed (if expiry is enabled). Entries stored with
+	// unsanitized serials are removed so their sanitized serial is added in their place, unless that
+	// would drop a serial that is not otherwise wanted.
+	var toRemove []string
+	var changes []destination.Change
+	var missingEntries []missingEntry
+	expiredCount := 0
+	replacedCount := 0
+	// With on_missing_grace_cycles, entries are only removed once they have been missing
+	// for that many consecutive cycles
+	counter := s.newMissCounter(s.config.Cloudflare.ListID)
+	for _, item := range targetItems {
+		serial := sanitizer.sanitize("target", item.Value)
+		if serial != item.Value && s.deletable(item.Comment) {
+			_, desired := mergedSourceSerials[serial]
+			_, duplicate := targetSerialSet[serial]
+			if serial == "" || desired || duplicate {
+				toRemove = append(toRemove, item.Value)
+				changes = append(changes, destination.Change{SerialNumber: item.Value, Action: destination.ActionRemove, Comment: item.Comment, Source: "sanitized"})
+				replacedCount++
+				continue
+			}
+		}
+		targetSerialSet[serial] = struct{}{}
+		if s.expired(item.Comment, now) {
+			toRemove = append(toRemove, item.Value)
+			changes = append(changes, destination.Change{SerialNumber: item.Value, Action: destination.ActionRemove, Comment: item.Comment, Source: "expired"})
+			expiredCount++
+			continue
+		}
+		if _, keep := mergedSourceSerials[serial]; !keep {
+			missingEntries = append(missingEntries, missingEntry{Value: item.Value, Comment: item.Comment, Key: serial})
+		}
+	}
+	removals, missing := s.applyOnMissing(ctx, s.config.Cloudflare.ListID, missingEntries, counter)
+	for _, entry := range removals {
+		toRemove = append(toRemove, entry.Value)
+		changes = append(changes, destination.Change{SerialNumber: entry.Value, Action: destination.ActionRemove, Comment: entry.Comment, Source: "on_missing"})
+	}
+	s.recordMisses(s.config.Cloudflare.ListID, counter)
+	if s.config.OnMissing == "alert" {
+		report.Missing = serialsOf(missing)
+	}
+	if s.removalsExceeded(ctx, s.config.Cloudflare.ListID, toRemove, len(targetItems)) {
+		report.BlockedRemovals = toRemove
+		toRemove, changes = nil, nil
+	}
+	for _, change := range changes {
+		annotations[change.SerialNumber] = audit.Annotation{Source: change.Source, Comment: change.Comment}
+	}
+	// The devices to add are computed before any removal, so that the rest of the diff saved
+	// by an interrupted removal includes them. Differing comments for serials contributed by
+	// more than one source are resolved.
+	candidates := newCommentCandidates()
 	for _, device := range filteredKandjiDevices {
-		if _, exists := targetSerialSet[device.SerialNumber]; !exists {
-			toAdd = append(toAdd, deviceWithComment{
-				SerialNumber: device.SerialNumber,
-				Comment:      device.DeviceName,
-			})
+		comment := s.composeComment(device)
+		if expiresAt, ok := deviceExpiry[device.SerialNumber]; ok {
+			comment = cloudflare.WithExpiry(comment, expiresAt)
 		}
+		candidates.add(device.SerialNumber, deviceSource(device), comment)
+	}
+	for _, device := range intuneDevices {
+		candidates.add(device.SerialNumber, "intune", s.composeIntuneComment(device))
+	}
+	for _, entry := range fileEntries {
+		candidates.add(entry.SerialNumber, "file:"+entry.File, entry.Comment)
+	}
+	for _, static := range staticSerials {
+		candidates.add(static.SerialNumber, "static", static.Comment)
 	}
 
 	/*
 	   Optimization: Avoid repeated API calls for source lists by caching items.
 	*/
 	sourceListItemsCache := make(map[string][]cloudflare.GatewayListItem)
-	for _, sourceListID := range s.config.Cloudflare.SourceListIDs {
+	for _, sourceListID := range sourceListIDs {
 		items, err := s.cloudflareClient.GetListItemsByID(ctx, sourceListID)
 		if err == nil {
-			sourceListItemsCache[sourceListID] = items
+			sourceListItemsCache[sourceListID] = sanitizer.sanitizeItems(sourceListID, items)
 		}
 	}
 
 	// For source lists, add serials with the source list description as comment
-	for _, sourceListID := range s.config.Cloudflare.SourceListIDs {
+	for _, sourceListID := range sourceListIDs {
 		items := sourceListItemsCache[sourceListID]
 		desc := sourceListDescriptions[sourceListID]
 		for _, item := range items {
-			if _, exists := targetSerialSet[item.Value]; !exists {
-				toAdd = append(toAdd, deviceWithComment{
-					SerialNumber: item.Value,
-					Comment:      desc,
-				})
+			if s.expired(item.Comment, now) {
+				continue
 			}
+			candidates.add(item.Value, sourceListID, desc)
+		}
+	}
+
+	report.Conflicts = s.conflicts(candidates)
+	for _, conflict := range report.Conflicts {
+		s.log.Warn("Serial has differing comments across sources",
+			"serial_number", conflict.SerialNumber,
+			"candidates", conflict.Candidates,
+			"resolution", conflict.Resolution,
+			"skipped", conflict.Skipped,
+			"strategy", s.config.Cloudflare.ConflictResolution)
+	}
+
+	var toAdd []deviceWithComment
+	for _, serial := range candidates.order {
+		if _, exists := targetSerialSet[serial]; exists {
+			continue
 		}
+		comment, ok := s.resolveComment(candidates.bySerial[serial])
+		if !ok {
+			continue
+		}
+		toAdd = append(toAdd, deviceWithComment{
+			SerialNumber: serial,
+			Comment:      comment,
+		})
 	}
 
 	s.log.Info("Total new devices to add to target Cloudflare list", "count", len(toAdd))
+	for _, d := range toAdd {
+		annotations[d.SerialNumber] = audit.Annotation{Source: candidateSources(candidates.bySerial[d.SerialNumber])}
+	}
 
-	if len(toAdd) > 0 {
-		// Defensive deduplication: filter out any serials already in the target list
-		deduped := make([]deviceWithComment, 0, len(toAdd))
-		intersection := make([]string, 0)
+	if len(toRemove) > 0 && !replace {
+		s.log.Info("Deleting devices in target Cloudflare list that are not present in merged sources, have expired or are stored unsanitized", "count", len(toRemove), "expired", expiredCount, "unsanitized", replacedCount, "batch_size", s.config.Batch.Size)
+		result, err := s.cloudflareClient.DeleteDevices(ctx, toRemove, s.config.Batch.Size)
+		if err != nil {
+			s.knownTarget = nil
+			s.apiFailed(ctx, "cloudflare", s.cloudflareBreaker, err)
+			return report, fmt.Errorf("failed to delete missing devices: %w", err)
+		}
+		if len(result.Pending) > 0 {
+			s.knownTarget = nil
+			s.savePendingMutation(&state.PendingMutation{MutationID: report.CycleID, Remove: result.Pending, Append: s.pendingAppends(toAdd, targetSerialSet)})
+			return report, fmt.Errorf("sync cycle interrupted while removing devices: %w", ctx.Err())
+		}
+		removeErrors := make(map[string]string, len(result.FailedDevices))
+		for _, failedDevice := range result.FailedDevices {
+			removeErrors[failedDevice.SerialNumber] = fmt.Sprint(failedDevice.Error)
+		}
+		for i := range changes {
+			changes[i].Result = destination.ResultOK
+			if msg, failed := removeErrors[changes[i].SerialNumber]; failed {
+				changes[i].Result, changes[i].Error = destination.ResultFailed, msg
+			}
+		}
+		s.log.Info("Bulk device deletion completed", "success_count", result.SuccessCount, "failed_count", len(result.FailedDevices), "error_count", len(result.Errors))
+		for _, failedDevice := range result.FailedDevices {
+			s.log.Error("Failed to delete device", "serial_number", failedDevice.SerialNumber, "error", failedDevice.Error)
+		}
+		for _, generalError := range result.Errors {
+			s.log.Error("Bulk deletion error", "error", generalError)
+		}
+		targetKnown = len(result.FailedDevices) == 0 && len(result.Errors) == 0
+	}
+
+	// 5. Push the new devices computed above to the target list
+	var failed []string
+	var added []string
+	if replace {
+		// Nothing is left to resume if the replacement fails, the next cycle replaces again
+		added, err = s.replaceTarget(ctx, targetItems, toRemove, toAdd, candidates)
+		if err != nil {
+			s.knownTarget = nil
+			s.apiFailed(ctx, "cloudflare", s.cloudflareBreaker, err)
+			return report, fmt.Errorf("failed to replace target list: %w", err)
+		}
+		for i := range changes {
+			changes[i].Result = destination.ResultOK
+		}
+	} else {
+		added, err = s.appendNewDevices(ctx, toAdd, targetSerialSet)
+	}
+	if err != nil {
+		s.log.Error("Failed to process device batch", "error", err)
+		targetKnown = false
 		for _, d := range toAdd {
-			if _, exists := targetSerialSet[d.SerialNumber]; !exists {
-				deduped = append(deduped, d)
-			} else {
-				intersection = append(intersection, d.SerialNumber)
+			failed = append(failed, d.SerialNumber)
+		}
+	}
+	if err != nil {
+		s.apiFailed(ctx, "cloudflare", s.cloudflareBreaker, err)
+	} else {
+		s.apiSucceeded(ctx, "cloudflare", s.cloudflareBreaker)
+	}
+	if err != nil && ctx.Err() != nil {
+		s.knownTarget = nil
+		s.savePendingMutation(&state.PendingMutation{MutationID: report.CycleID, Append: s.pendingAppends(toAdd, targetSerialSet)})
+		return report, fmt.Errorf("sync cycle interrupted while adding devices: %w", err)
+	}
+	addedSet := createSet(added)
+	var addedDevices []deviceWithComment
+	for _, d := range toAdd {
+		change := destination.Change{
+			SerialNumber: d.SerialNumber,
+			Action:       destination.ActionAdd,
+			Comment:      s.entryComment(d.Comment),
+			Source:       candidateSources(candidates.bySerial[d.SerialNumber]),
+			Result:       destination.ResultOK,
+		}
+		if _, ok := addedSet[d.SerialNumber]; ok {
+			addedDevices = append(addedDevices, d)
+		} else {
+			change.Result = destination.ResultFailed
+			if err != nil {
+				change.Error = err.Error()
 			}
 		}
-		if len(intersection) > 0 {
-			s.log.Warn("Deduplication: serials to be appended already exist in target list", "count", len(intersection), "serials", intersection)
+		changes = append(changes, change)
+	}
+	if s.plan != nil {
+		s.recordPlan(targetItems, changes, candidates)
+	}
+	if s.dryRun() {
+		s.logDryRun(changes)
+	} else {
+		s.rememberTarget(targetKnown, kandjiHash, targetItems, toRemove, addedDevices)
+	}
+
+	// 6. Sync the lists that platform_routing and blueprint_routing route devices to
+	if len(s.config.Cloudflare.PlatformRouting) > 0 || len(s.config.Cloudflare.BlueprintRouting) > 0 {
+		report.PlatformLists = s.syncPlatformLists(ctx, routed, deviceExpiry, sanitizer, now)
+	}
+
+	// 7. Sync the lists that tag_list_mapping adds the devices of the target list to
+	if len(s.config.Cloudflare.TagListMapping.Refs()) > 0 {
+		report.TagLists = s.syncTagLists(ctx, filteredKandjiDevices, deviceExpiry, sanitizer, now)
+	}
+
+	// 8. Keep the owner EMAIL list consistent with the serials now in the target list
+	if s.config.Cloudflare.EmailListID != "" {
+		inSerialList := serialsInList(targetSerialSet, changes, added)
+		if err := s.syncEmailList(ctx, report, filteredKandjiDevices, inSerialList); err != nil {
+			s.log.Error("Failed to sync owner email list", "list_id", s.config.Cloudflare.EmailListID, "error", err)
+		}
+	}
+
+	// 9. Hand the synced device set to the configured destinations
+	if len(s.destinations) > 0 && s.dryRun() {
+		s.log.Info("Dry run: not publishing to destinations", "count", len(s.destinations))
+	} else if len(s.destinations) > 0 {
+		snapshot := &destination.Snapshot{
+			CycleID: report.CycleID,
+			Time:    time.Now().UTC(),
+			Added:   added,
+			Removed: toRemove,
+			Failed:  failed,
+			Changes: changes,
 		}
-		if len(deduped) == 0 {
-			s.log.Info("No new devices to add after deduplication")
-			return
+		snapshot.Devices = snapshotDevices(append(append([]kandji.Device{}, filteredKandjiDevices...), routedDevices...))
+		for _, sourceListID := range sourceListIDs {
+			for _, item := range sourceListItemsCache[sourceListID] {
+				snapshot.Devices = append(snapshot.Devices, destination.Device{
+					SerialNumber: item.Value,
+					DeviceName:   item.Comment,
+					Source:       sourceListID,
+				})
+			}
 		}
+		s.publish(ctx, snapshot)
+	}
+
+	for _, device := range append(append([]kandji.Device{}, filteredKandjiDevices...), routedDevices...) {
+		s.seenSerials = append(s.seenSerials, device.SerialNumber)
+	}
 
-		var cfDevices []cloudflare.GatewayListItemCreateRequest
-		serialSeen := make(map[string]struct{})
-		duplicates := make([]string, 0)
-		for _, d := range deduped {
-			if _, exists := serialSeen[d.SerialNumber]; exists {
-				duplicates = append(duplicates, d.SerialNumber)
+	report.FinishedAt = time.Now().UTC()
+	report.KandjiDevices = len(kandjiDevices)
+	report.EligibleDevices = len(filteredKandjiDevices) + len(routedDevices)
+	report.DesiredDevices = len(mergedSourceSerials)
+	report.Added = added
+	report.Removed = toRemove
+	report.FailedToAdd = failed
+	report.SanitizedSerials = sanitizer.changed
+	s.logSanitizedSerials(sanitizer.changed)
+
+	s.log.Info("Sync cycle complete",
+		"cycle_id", report.CycleID,
+		"kandji_devices_total", report.KandjiDevices,
+		"eligible_devices", report.EligibleDevices,
+		"new_devices_found", len(toAdd),
+		"successfully_added", len(added),
+		"failed_to_add", len(failed),
+		"deleted_devices", len(toRemove),
+		"owner_emails_added", report.OwnerEmailsAdded,
+		"owner_emails_removed", report.OwnerEmailsRemoved,
+		"conflicts", len(report.Conflicts),
+		"missing_devices", len(report.Missing),
+		"sanitized_serials", len(report.SanitizedSerials),
+		"dry_run", report.DryRun,
+		"blackout", report.Blackout)
+	return report, nil
+}
+
+// fetchDevices downloads the devices of Kandji, its additional tenants and Mosyle, and
+// records the outcome with the circuit breaker.
+func (s *Syncer) fetchDevices(ctx context.Context, report *Report) ([]kandji.Device, error) {
+	var kandjiDevices []kandji.Device
+	if s.kandjiClient != nil {
+		var err error
+		kandjiDevices, err = s.kandjiClient.GetDevices(ctx)
+		if err != nil {
+			s.apiFailed(ctx, "kandji", s.kandjiBreaker, err)
+			return nil, fmt.Errorf("failed to get devices from Kandji: %w", err)
+		}
+		s.log.Debug("Successfully fetched devices from Kandji", "count", len(kandjiDevices))
+	}
+	for _, tenant := range s.kandjiTenants {
+		tenantDevices, err := tenant.Client.GetDevices(ctx)
+		if err != nil {
+			s.apiFailed(ctx, "kandji", s.kandjiBreaker, err)
+			return nil, fmt.Errorf("failed to get devices from Kandji tenant %s: %w", tenant.Name, err)
+		}
+		for i := range tenantDevices {
+			tenantDevices[i].Tenant = tenant.Name
+		}
+		s.log.Debug("Successfully fetched devices from Kandji tenant", "tenant", tenant.Name, "count", len(tenantDevices))
+		kandjiDevices = append(kandjiDevices, tenantDevices...)
+	}
+	if s.kandjiClient != nil {
+		// Only once every tenant was read, so a failing tenant opens the circuit breaker
+		s.apiSucceeded(ctx, "kandji", s.kandjiBreaker)
+	}
+	if s.mosyleClient != nil {
+		mosyleDevices, err := s.mosyleClient.GetDevices(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get devices from Mosyle: %w", err)
+		}
+		s.log.Debug("Successfully fetched devices from Mosyle", "count", len(mosyleDevices))
+		report.MosyleDevices = len(mosyleDevices)
+		kandjiDevices = append(kandjiDevices, mosyleDevices...)
+	}
+	return kandjiDevices, nil
+}
+
+// EligibleDevices downloads the inventory and returns the devices that pass the filters, as
+// the next cycle would sync them to the target list or route them to other lists. Source
+// lists, Intune, source files and static serials are not included.
+func (s *Syncer) EligibleDevices(ctx context.Context) ([]destination.Device, error) {
+	devices, err := s.fetchDevices(ctx, &Report{})
+	if err != nil {
+		return nil, err
+	}
+	eligible, err := s.filterDevices(devices, newSerialSanitizer(), time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	return snapshotDevices(append(eligible.devices, eligible.routedDevices...)), nil
+}
+
+// eligibleDevices are the devices that pass the filters.
+type eligibleDevices struct {
+	// devices are synced to the target list, serials are their serial numbers
+	devices []kandji.Device
+	serials []string
+	// routed maps the platform_routing and blueprint_routing lists to their devices;
+	// routedDevices are all of them
+	routed        map[string][]kandji.Device
+	routedDevices []kandji.Device
+	// expiry maps serials to the end of a time-limited access grant
+	expiry map[string]time.Time
+}
+
+// filterDevices applies the device filters and the platform and blueprint routing to the
+// downloaded devices, sanitizing their serial numbers.
+func (s *Syncer) filterDevices(devices []kandji.Device, sanitizer *serialSanitizer, now time.Time) (*eligibleDevices, error) {
+	eligible := &eligibleDevices{
+		expiry: make(map[string]time.Time),
+		routed: make(map[string][]kandji.Device),
+	}
+	includeTags, err := config.CompileTagPatterns(s.config.Kandji.IncludeTags)
+	if err != nil {
+		return nil, fmt.Errorf("kandji.include_tags: %w", err)
+	}
+	excludeTags, err := config.CompileTagPatterns(s.config.Kandji.ExcludeTags)
+	if err != nil {
+		return nil, fmt.Errorf("kandji.exclude_tags: %w", err)
+	}
+	includeAssetTags, err := config.CompileTagPatterns(s.config.Kandji.AssetTagsInclude)
+	if err != nil {
+		return nil, fmt.Errorf("kandji.asset_tags_include: %w", err)
+	}
+	excludeAssetTags, err := config.CompileTagPatterns(s.config.Kandji.AssetTagsExclude)
+	if err != nil {
+		return nil, fmt.Errorf("kandji.asset_tags_exclude: %w", err)
+	}
+	for _, device := range devices {
+		device.SerialNumber = sanitizer.sanitize("kandji", device.SerialNumber)
+		if device.SerialNumber == "" {
+			s.log.Debug("Skipping device with empty serial number", "device_name", device.DeviceName)
+			continue
+		}
+		if !s.config.Kandji.SyncDevicesWithoutOwners && device.UserEmail == "" {
+			s.log.Debug("Skipping device without owner", "serial_number", device.SerialNumber)
+			continue
+		}
+		routedList, isRouted := s.config.Cloudflare.PlatformList(device.Platform)
+		// Routing a mobile platform to a list syncs its devices regardless of the deprecated
+		// sync_mobile_devices, while kandji.platforms always applies
+		if !s.config.Kandji.PlatformAllowed(device.Platform) && (!isRouted || s.config.Kandji.Platforms != nil) {
+			s.log.Debug("Skipping device of a platform that is not synced", "serial_number", device.SerialNumber, "platform", device.Platform)
+			continue
+		}
+		if !includeTags.Empty() && !includeTags.MatchAny(device.Tags) {
+			continue
+		}
+		if excludeTags.MatchAny(device.Tags) {
+			continue
+		}
+
+		// Blueprint filtering
+		if !s.deviceMatchesBlueprint(&device) {
+			continue
+		}
+		if !s.deviceMatchesModel(device) {
+			continue
+		}
+		if !s.deviceMatchesAssetTag(device, includeAssetTags, excludeAssetTags) {
+			continue
+		}
+		if !s.config.Kandji.MeetsMinOSVersion(device.Platform, device.OSVersion) {
+			s.log.Debug("Skipping device below the minimum OS version", "serial_number", device.SerialNumber, "platform", device.Platform, "os_version", device.OSVersion)
+			continue
+		}
+		if !s.checkedInRecently(device, now) {
+			s.log.Debug("Skipping device that has not checked in recently", "serial_number", device.SerialNumber, "last_seen", device.LastSeen, "max_last_checkin_age", s.config.Kandji.MaxLastCheckinAge.String())
+			continue
+		}
+
+		if expiresAt, ok := s.deviceExpiry(device); ok {
+			if !expiresAt.After(now) {
+				s.log.Debug("Skipping device whose access grant has expired", "serial_number", device.SerialNumber, "expired_at", expiresAt)
 				continue
 			}
-			serialSeen[d.SerialNumber] = struct{}{}
-			cfDevices = append(cfDevices, cloudflare.GatewayListItemCreateRequest{
-				Value:   d.SerialNumber,
-				Comment: d.Comment,
-			})
+			eligible.expiry[device.SerialNumber] = expiresAt
 		}
-		if len(duplicates) > 0 {
-			s.log.Warn("Deduplication: duplicate serials skipped in PATCH payload", "count", len(duplicates), "serials", duplicates)
+
+		if blueprintList, ok := s.config.Cloudflare.BlueprintList(device.BlueprintID, device.BlueprintName); ok {
+			eligible.routed[blueprintList] = append(eligible.routed[blueprintList], device)
+			eligible.routedDevices = append(eligible.routedDevices, device)
+			s.log.Debug("Routing device to blueprint list", "serial_number", device.SerialNumber, "blueprint", device.BlueprintName, "list", blueprintList)
+			continue
 		}
+		if isRouted {
+			eligible.routed[routedList] = append(eligible.routed[routedList], device)
+			eligible.routedDevices = append(eligible.routedDevices, device)
+			s.log.Debug("Routing device to platform list", "serial_number", device.SerialNumber, "platform", device.Platform, "list", routedList)
+			continue
+		}
+		eligible.devices = append(eligible.devices, device)
+		eligible.serials = append(eligible.serials, device.SerialNumber)
+		s.log.Debug("Including device for sync", "serial_number", device.SerialNumber)
+	}
+	return eligible, nil
+}
 
-		s.log.Debug("PATCH append payload", "count", len(cfDevices), "serials", cfDevices)
-		err := s.cloudflareClient.AppendDevices(ctx, cfDevices, s.config.Batch.Size)
+// snapshotDevices describes Kandji devices for the destinations.
+func snapshotDevices(devices []kandji.Device) []destination.Device {
+	var result []destination.Device
+	for _, device := range devices {
+		result = append(result, destination.Device{
+			SerialNumber: device.SerialNumber,
+			DeviceID:     device.DeviceID,
+			DeviceName:   device.DeviceName,
+			UserEmail:    device.UserEmail,
+			Platform:     device.Platform,
+			Blueprint:    device.BlueprintName,
+			LastSeen:     device.LastSeen,
+			Tags:         device.Tags,
+			PublicIP:     device.PublicIP,
+			Source:       deviceSource(device),
+		})
+	}
+	return result
+}
+
+// deviceSource names the source of a Kandji device: "kandji" for the primary tenant, and
+// "kandji:" and the tenant name for an additional tenant.
+func deviceSource(device kandji.Device) string {
+	if device.Tenant != "" {
+		return "kandji:" + device.Tenant
+	}
+	return "kandji"
+}
+
+// resolveSourceLists resolves the configured source list references to list IDs. It also
+// returns the reference each ID was resolved from. References that cannot be resolved are
+// logged and skipped for this cycle.
+func (s *Syncer) resolveSourceLists(ctx context.Context) ([]string, map[string]string) {
+	var ids []string
+	refs := make(map[string]string)
+	for _, ref := range s.config.Cloudflare.SourceListRefs() {
+		id, err := s.cloudflareClient.ResolveListID(ctx, ref)
 		if err != nil {
-			s.log.Error("Failed to process device batch", "error", err)
-			return
+			s.log.Error("Failed to resolve source Cloudflare list", "list", ref, "error", err)
+			continue
 		}
-		s.log.Info("Bulk device creation completed", "success_count", len(cfDevices))
+		if _, dup := refs[id]; dup {
+			continue
+		}
+		ids = append(ids, id)
+		refs[id] = ref
 	}
 
-	s.log.Info("Sync cycle complete",
-		"kandji_devices_total", len(kandjiDevices),
-		"eligible_devices", len(filteredKandjiDevices),
-		"new_devices_found", len(toAdd),
-		"successfully_added", len(toAdd),
-		"deleted_devices", len(toRemove))
+	if len(s.config.Cloudflare.SourceListPatterns) > 0 {
+		for _, list := range s.matchSourceListPatterns(ctx) {
+			if _, dup := refs[list.ID]; dup {
+				continue
+			}
+			ids = append(ids, list.ID)
+			refs[list.ID] = list.Name
+		}
+	}
+	return ids, refs
+}
+
+// matchSourceListPatterns returns the device lists whose names match one of the configured
+// source_list_patterns. The account's lists are fetched every cycle so newly created lists
+// are picked up without a restart. The target and email lists are never matched.
+func (s *Syncer) matchSourceListPatterns(ctx context.Context) []cloudflare.GatewayList {
+	lists, err := s.cloudflareClient.ListLists(ctx)
+	if err != nil {
+		s.log.Error("Failed to list Cloudflare lists for source list patterns", "error", err)
+		return nil
+	}
+
+	var matched []cloudflare.GatewayList
+	current := make(map[string]string)
+	for _, list := range lists {
+		if list.Type != s.config.Cloudflare.DeviceListType() || list.ID == s.config.Cloudflare.ListID || list.ID == s.config.Cloudflare.EmailListID {
+			continue
+		}
+		for _, pattern := range s.config.Cloudflare.SourceListPatterns {
+			if ok, _ := path.Match(pattern, list.Name); ok {
+				matched = append(matched, list)
+				current[list.ID] = list.Name
+				break
+			}
+		}
+	}
+
+	for id, name := range current {
+		if _, ok := s.patternLists[id]; !ok {
+			s.log.Info("Source list pattern matched new list", "list_id", id, "name", name)
+		}
+	}
+	for id, name := range s.patternLists {
+		if _, ok := current[id]; !ok {
+			s.log.Info("Source list no longer matches any pattern", "list_id", id, "name", name)
+		}
+	}
+	s.patternLists = current
+
+	return matched
+}
+
+// appendNewDevices deduplicates the devices to add against the target list and appends
+// the remainder. It returns the serials that were appended.
+func (s *Syncer) appendNewDevices(ctx context.Context, toAdd []deviceWithComment, targetSerialSet map[string]struct{}) ([]string, error) {
+	if len(toAdd) == 0 {
+		return nil, nil
+	}
+
+	// Defensive deduplication: filter out any serials already in the target list
+	deduped := make([]deviceWithComment, 0, len(toAdd))
+	intersection := make([]string, 0)
+	for _, d := range toAdd {
+		if _, exists := targetSerialSet[d.SerialNumber]; !exists {
+			deduped = append(deduped, d)
+		} else {
+			intersection = append(intersection, d.SerialNumber)
+		}
+	}
+	if len(intersection) > 0 {
+		s.log.Warn("Deduplication: serials to be appended already exist in target list", "count", len(intersection), "serials", intersection)
+	}
+	if len(deduped) == 0 {
+		s.log.Info("No new devices to add after deduplication")
+		return nil, nil
+	}
+
+	var cfDevices []cloudflare.GatewayListItemCreateRequest
+	var serials []string
+	serialSeen := make(map[string]struct{})
+	duplicates := make([]string, 0)
+	for _, d := range deduped {
+		if _, exists := serialSeen[d.SerialNumber]; exists {
+			duplicates = append(duplicates, d.SerialNumber)
+			continue
+		}
+		serialSeen[d.SerialNumber] = struct{}{}
+		cfDevices = append(cfDevices, cloudflare.GatewayListItemCreateRequest{
+			Value:   d.SerialNumber,
+			Comment: s.entryComment(d.Comment),
+		})
+		serials = append(serials, d.SerialNumber)
+	}
+	if len(duplicates) > 0 {
+		s.log.Warn("Deduplication: duplicate serials skipped in PATCH payload", "count", len(duplicates), "serials", duplicates)
+	}
+
+	s.log.Debug("PATCH append payload", "count", len(cfDevices), "serials", cfDevices)
+	if err := s.cloudflareClient.AppendDevices(ctx, cfDevices, s.config.Batch.Size); err != nil {
+		return nil, err
+	}
+	s.log.Info("Bulk device creation completed", "success_count", len(cfDevices))
+	return serials, nil
+}
+
+// replaceTarget replaces the target list with its complete desired content: the current
+// items that are not removed, and the new devices. Items that are kept are written with
+// their resolved comment, so comments converge too; items no source wants any more, which
+// on_missing or delete_scope keep, are written back unchanged. It returns the serials added.
+func (s *Syncer) replaceTarget(ctx context.Context, targetItems []cloudflare.GatewayListItem, toRemove []string, toAdd []deviceWithComment, candidates *commentCandidates) ([]string, error) {
+	removed := createSet(toRemove)
+	items := make([]cloudflare.GatewayListItemCreateRequest, 0, len(targetItems)+len(toAdd))
+	seen := make(map[string]struct{}, len(targetItems)+len(toAdd))
+	for _, item := range targetItems {
+		if _, ok := removed[item.Value]; ok {
+			continue
+		}
+		if _, ok := seen[item.Value]; ok {
+			continue
+		}
+		seen[item.Value] = struct{}{}
+		comment := item.Comment
+		if sources, ok := candidates.bySerial[item.Value]; ok {
+			if resolved, ok := s.resolveComment(sources); ok {
+				comment = s.entryComment(resolved)
+			}
+		}
+		items = append(items, cloudflare.GatewayListItemCreateRequest{Value: item.Value, Comment: comment})
+	}
+	var added []string
+	for _, d := range toAdd {
+		if _, ok := seen[d.SerialNumber]; ok {
+			continue
+		}
+		seen[d.SerialNumber] = struct{}{}
+		items = append(items, cloudflare.GatewayListItemCreateRequest{Value: d.SerialNumber, Comment: s.entryComment(d.Comment)})
+		added = append(added, d.SerialNumber)
+	}
+
+	s.log.Info("Replacing target Cloudflare list with the desired set", "count", len(items), "add", len(added), "remove", len(toRemove))
+	if err := s.cloudflareClient.ReplaceItemsByID(ctx, s.config.Cloudflare.ListID, items); err != nil {
+		return nil, err
+	}
+	return added, nil
+}
+
+// entryComment returns the comment written to the target list for a resolved comment.
+func (s *Syncer) entryComment(comment string) string {
+	return cloudflare.TruncateComment(cloudflare.WithMarker(comment, s.config.Cloudflare.ManagedMarker), s.config.Cloudflare.ManagedMarker, s.config.Cloudflare.Comment.MaxLength)
+}
+
+// publish hands the snapshot to every destination. Destination failures are logged
+// and never fail the sync cycle.
+func (s *Syncer) publish(ctx context.Context, snapshot *destination.Snapshot) {
+	// Destinations write lists of their own, the annotations of the target list do not apply
+	ctx = audit.WithAnnotations(ctx, nil)
+	for _, dest := range s.destinations {
+		if err := dest.Publish(ctx, snapshot); err != nil {
+			s.log.Error("Failed to publish to destination", "destination", dest.Name(), "error", err)
+		}
+	}
+}
+
+// serialsInList returns the serials in the target list at the end of a cycle: the serials it
+// held, less those whose removal was applied, plus the added ones. Blocked removals are not
+// among the changes, and failed ones stay in the list.
+func serialsInList(targetSerialSet map[string]struct{}, changes []destination.Change, added []string) map[string]struct{} {
+	inSerialList := make(map[string]struct{}, len(targetSerialSet)+len(added))
+	for serial := range targetSerialSet {
+		inSerialList[serial] = struct{}{}
+	}
+	for _, change := range changes {
+		if change.Action != destination.ActionRemove || change.Result != destination.ResultOK {
+			continue
+		}
+		// An unsanitized duplicate leaves the list, the sanitized entry it duplicates stays
+		if change.Source == "sanitized" {
+			if _, stays := targetSerialSet[SanitizeSerial(change.SerialNumber)]; stays {
+				continue
+			}
+		}
+		delete(inSerialList, SanitizeSerial(change.SerialNumber))
+	}
+	for _, serial := range added {
+		inSerialList[serial] = struct{}{}
+	}
+	return inSerialList
+}
+
+// countMiss records in newMisses that serial is missing for one more consecutive cycle than
+// counted in misses, and reports whether it is still within the grace of graceCycles.
+func countMiss(misses, newMisses map[string]int, serial string, graceCycles int) bool {
+	newMisses[serial] = misses[serial] + 1
+	return newMisses[serial] < graceCycles
+}
+
+// deletable reports whether a list item may be removed under the configured delete scope.
+func (s *Syncer) deletable(comment string) bool {
+	if s.config.DeleteScope != "managed_only" {
+		return true
+	}
+	return cloudflare.HasMarker(comment, s.config.Cloudflare.ManagedMarker)
+}
+
+// deviceMatchesModel checks if a device's model matches the model filters. Exclusion wins,
+// and without include patterns every model that is not excluded matches.
+func (s *Syncer) deviceMatchesModel(device kandji.Device) bool {
+	for _, pattern := range s.config.Kandji.ModelsExclude {
+		if ok, _ := path.Match(pattern, device.Model); ok {
+			s.log.Debug("Device excluded by model", "serial_number", device.SerialNumber, "model", device.Model, "pattern", pattern)
+			return false
+		}
+	}
+	if len(s.config.Kandji.ModelsInclude) == 0 {
+		return true
+	}
+	for _, pattern := range s.config.Kandji.ModelsInclude {
+		if ok, _ := path.Match(pattern, device.Model); ok {
+			return true
+		}
+	}
+	s.log.Debug("Device did not match any include model filters", "serial_number", device.SerialNumber, "model", device.Model)
+	return false
+}
+
+// deviceMatchesAssetTag checks if a device's asset tag passes require_asset_tag and the
+// asset tag filters. Exclusion wins, and a device without an asset tag never matches
+// include patterns.
+func (s *Syncer) deviceMatchesAssetTag(device kandji.Device, include, exclude *config.TagMatcher) bool {
+	assetTag := strings.TrimSpace(device.AssetTag)
+	if assetTag == "" && s.config.Kandji.RequireAssetTag {
+		s.log.Debug("Skipping device without an asset tag", "serial_number", device.SerialNumber)
+		return false
+	}
+	if assetTag != "" && exclude.Match(assetTag) {
+		s.log.Debug("Device excluded by asset tag", "serial_number", device.SerialNumber, "asset_tag", assetTag)
+		return false
+	}
+	if include.Empty() {
+		return true
+	}
+	if assetTag != "" && include.Match(assetTag) {
+		return true
+	}
+	s.log.Debug("Device did not match any include asset tag filters", "serial_number", device.SerialNumber, "asset_tag", assetTag)
+	return false
+}
+
+// checkedInRecently reports whether a device last checked in to Kandji within
+// max_last_checkin_age. A device whose check-in time is unknown has not, unless the check
+// is disabled.
+func (s *Syncer) checkedInRecently(device kandji.Device, now time.Time) bool {
+	if s.config.Kandji.MaxLastCheckinAge == 0 {
+		return true
+	}
+	lastSeen, err := time.Parse(time.RFC3339Nano, device.LastSeen)
+	if err != nil {
+		return false
+	}
+	return now.Sub(lastSeen) <= s.config.Kandji.MaxLastCheckinAge
+}
+
+// deviceExpiry returns when the access grant of a device matching the expiry tags ends,
+// counted from its Kandji enrollment date.
+func (s *Syncer) deviceExpiry(device kandji.Device) (time.Time, bool) {
+	if !s.config.Expiry.Enabled || s.config.Expiry.TTL == 0 || !s.deviceHasAnyTag(device, s.config.Expiry.Tags) {
+		return time.Time{}, false
+	}
+	enrolled, err := time.Parse(time.RFC3339Nano, device.EnrollmentDate)
+	if err != nil {
+		s.log.Warn("Cannot compute access expiry, enrollment date is not parseable", "serial_number", device.SerialNumber, "enrollment_date", device.EnrollmentDate)
+		return time.Time{}, false
+	}
+	return enrolled.Add(s.config.Expiry.TTL), true
+}
+
+// expired reports whether a list item comment carries an expiry stamp that has passed.
+func (s *Syncer) expired(comment string, now time.Time) bool {
+	if !s.config.Expiry.Enabled {
+		return false
+	}
+	expiresAt, ok := cloudflare.ParseExpiry(comment)
+	return ok && !expiresAt.After(now)
 }
 
 // createSet creates a set from a slice of strings for efficient lookups.
@@ -285,10 +1494,10 @@ func createSet(items []string) map[string]struct{} {
 
 // deviceMatchesBlueprint checks if a device matches the blueprint filters.
 func (s *Syncer) deviceMatchesBlueprint(device *kandji.Device) bool {
-	includeIDs := createSet(s.config.Kandji.BlueprintsInclude.BlueprintIDs)
-	includeNames := createSet(s.config.Kandji.BlueprintsInclude.BlueprintNames)
-	excludeIDs := createSet(s.config.Kandji.BlueprintsExclude.BlueprintIDs)
-	excludeNames := createSet(s.config.Kandji.BlueprintsExclude.BlueprintNames)
+	include := s.config.Kandji.BlueprintsInclude
+	exclude := s.config.Kandji.BlueprintsExclude
+	includeIDs := createSet(include.BlueprintIDs)
+	excludeIDs := createSet(exclude.BlueprintIDs)
 
 	// Log device blueprint info for debugging
 	s.log.Debug("Checking device blueprint",
@@ -296,20 +1505,20 @@ func (s *Syncer) deviceMatchesBlueprint(device *kandji.Device) bool {
 		"device_blueprint_id", device.BlueprintID,
 		"device_blueprint_name", device.BlueprintName,
 		"include_ids", includeIDs,
-		"blueprint_names", includeNames)
+		"blueprint_names", include.BlueprintNames)
 
 	// Exclude filter has priority
 	if _, ok := excludeIDs[device.BlueprintID]; ok {
 		s.log.Debug("Device excluded by blueprint ID", "serial_number", device.SerialNumber, "blueprint_id", device.BlueprintID)
 		return false
 	}
-	if _, ok := excludeNames[device.BlueprintName]; ok {
-		s.log.Debug("Device excluded by blueprint name", "serial_number", device.SerialNumber, "blueprint_name", device.BlueprintName)
+	if pattern, ok := exclude.MatchName(device.BlueprintName); ok {
+		s.log.Debug("Device excluded by blueprint name", "serial_number", device.SerialNumber, "blueprint_name", device.BlueprintName, "pattern", pattern)
 		return false
 	}
 
 	// If no include filters are set, all non-excluded devices are included.
-	if len(includeIDs) == 0 && len(includeNames) == 0 {
+	if len(includeIDs) == 0 && len(include.BlueprintNames) == 0 {
 		return true
 	}
 
@@ -318,12 +1527,12 @@ func (s *Syncer) deviceMatchesBlueprint(device *kandji.Device) bool {
 		s.log.Debug("Device included by blueprint ID", "serial_number", device.SerialNumber, "blueprint_id", device.BlueprintID)
 		return true
 	}
-	if _, ok := includeNames[device.BlueprintName]; ok {
-		s.log.Debug("Device included by blueprint name", "serial_number", device.SerialNumber, "blueprint_name", device.BlueprintName)
+	if pattern, ok := include.MatchName(device.BlueprintName); ok {
+		s.log.Debug("Device included by blueprint name", "serial_number", device.SerialNumber, "blueprint_name", device.BlueprintName, "pattern", pattern)
 		return true
 	}
 
-	s.log.Debug("Device did not match any include blueprint filters", "serial_number", device.SerialNumber, "device_blueprint_name", device.BlueprintName, "device_blueprint_id", device.BlueprintID, "include_ids", includeIDs, "blueprint_names", includeNames)
+	s.log.Debug("Device did not match any include blueprint filters", "serial_number", device.SerialNumber, "device_blueprint_name", device.BlueprintName, "device_blueprint_id", device.BlueprintID, "include_ids", includeIDs, "blueprint_names", include.BlueprintNames)
 	return false
 }
 
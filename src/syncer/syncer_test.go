@@ -0,0 +1,99 @@
+package syncer
+
+import (
+	"io"
+	"log/slog"
+	"slices"
+	"sort"
+	"testing"
+
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/kandji"
+)
+
+func TestCountMiss(t *testing.T) {
+	tests := []struct {
+		name        string
+		graceCycles int
+		// cycles are the serials missing in each consecutive cycle
+		cycles [][]string
+		// wantRemoved are the serials past their grace in each cycle
+		wantRemoved [][]string
+	}{
+		{
+			name:        "removed once missing for the grace cycles",
+			graceCycles: 3,
+			cycles:      [][]string{{"A"}, {"A"}, {"A"}, {"A"}},
+			wantRemoved: [][]string{nil, nil, {"A"}, {"A"}},
+		},
+		{
+			name:        "a cycle that finds the serial again resets its count",
+			graceCycles: 2,
+			cycles:      [][]string{{"A"}, {}, {"A"}, {"A"}},
+			wantRemoved: [][]string{nil, nil, nil, {"A"}},
+		},
+		{
+			name:        "serials are counted separately",
+			graceCycles: 2,
+			cycles:      [][]string{{"A"}, {"A", "B"}, {"B"}},
+			wantRemoved: [][]string{nil, {"A"}, {"B"}},
+		},
+		{
+			name:        "a grace of one cycle removes at once",
+			graceCycles: 1,
+			cycles:      [][]string{{"A"}},
+			wantRemoved: [][]string{{"A"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			misses := map[string]int{}
+			for i, missing := range tt.cycles {
+				newMisses := make(map[string]int)
+				var removed []string
+				for _, serial := range missing {
+					if !countMiss(misses, newMisses, serial, tt.graceCycles) {
+						removed = append(removed, serial)
+					}
+				}
+				sort.Strings(removed)
+				if !slices.Equal(removed, tt.wantRemoved[i]) {
+					t.Errorf("cycle %d removed %v, want %v", i+1, removed, tt.wantRemoved[i])
+				}
+				// Like the state store, the counts of the cycle replace the previous ones
+				misses = newMisses
+			}
+		})
+	}
+}
+
+func TestDeviceMatchesModel(t *testing.T) {
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		model   string
+		want    bool
+	}{
+		{name: "no filters", model: "MacBook Pro (14-inch, 2023)", want: true},
+		{name: "included", include: []string{"MacBook*"}, model: "MacBook Air (M2, 2022)", want: true},
+		{name: "not included", include: []string{"MacBook*"}, model: "Mac mini (2023)"},
+		{name: "include matches the whole model", include: []string{"Pro*"}, model: "MacBook Pro (14-inch, 2023)"},
+		{name: "include is case-sensitive", include: []string{"macbook*"}, model: "MacBook Air (M2, 2022)"},
+		{name: "excluded", exclude: []string{"Mac mini*"}, model: "Mac mini (2023)"},
+		{name: "exclusion wins", include: []string{"Mac*"}, exclude: []string{"Mac mini*"}, model: "Mac mini (2023)"},
+		{name: "single character", include: []string{"iPad (?th generation)"}, model: "iPad (9th generation)", want: true},
+		{name: "unknown model with include patterns", include: []string{"MacBook*"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Kandji.ModelsInclude = tt.include
+			cfg.Kandji.ModelsExclude = tt.exclude
+			s := &Syncer{config: cfg, log: slog.New(slog.NewTextHandler(io.Discard, nil))}
+			if got := s.deviceMatchesModel(kandji.Device{SerialNumber: "SN1", Model: tt.model}); got != tt.want {
+				t.Errorf("deviceMatchesModel(%q) = %v, want %v", tt.model, got, tt.want)
+			}
+		})
+	}
+}
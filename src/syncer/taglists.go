@@ -0,0 +1,51 @@
+package syncer
+
+import (
+	"context"
+	"time"
+
+	"kandji-cloudflare-device-sync/kandji"
+)
+
+// tagListDevices groups the devices synced to the target list by the lists tag_list_mapping
+// adds them to. A device is added to the list of every mapped tag it has, once per list, and
+// to the default list if it has none.
+func (s *Syncer) tagListDevices(devices []kandji.Device) map[string][]kandji.Device {
+	mapping := s.config.Cloudflare.TagListMapping
+	lists := make(map[string][]kandji.Device)
+	for _, device := range devices {
+		added := make(map[string]struct{})
+		for _, tag := range device.Tags {
+			ref, ok := mapping.Tags[tag]
+			if !ok {
+				continue
+			}
+			if _, done := added[ref]; !done {
+				lists[ref] = append(lists[ref], device)
+				added[ref] = struct{}{}
+			}
+		}
+		if len(added) == 0 && mapping.DefaultList != "" {
+			lists[mapping.DefaultList] = append(lists[mapping.DefaultList], device)
+		}
+	}
+	return lists
+}
+
+// syncTagLists brings every list of tag_list_mapping in line with the devices mapped to it,
+// the way routed lists are maintained. A failure is logged and only affects its own list.
+func (s *Syncer) syncTagLists(ctx context.Context, devices []kandji.Device, deviceExpiry map[string]time.Time, sanitizer *serialSanitizer, now time.Time) []PlatformListResult {
+	tagged := s.tagListDevices(devices)
+	var results []PlatformListResult
+	for _, ref := range s.config.Cloudflare.TagListMapping.Refs() {
+		result := PlatformListResult{List: ref, Devices: len(tagged[ref])}
+		if err := s.syncPlatformList(ctx, &result, tagged[ref], deviceExpiry, sanitizer, now); err != nil {
+			result.Error = err.Error()
+			s.log.Error("Failed to sync tag list", "list", ref, "list_id", result.ListID, "error", err)
+			// The list may have been recreated under the same name, resolve it again next cycle
+			s.cloudflareClient.InvalidateListRef(ref)
+		}
+		results = append(results, result)
+	}
+	return results
+}
@@ -0,0 +1,111 @@
+package syncer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"kandji-cloudflare-device-sync/cloudflare"
+	"kandji-cloudflare-device-sync/kandji"
+	"kandji-cloudflare-device-sync/state"
+)
+
+// WithWarmCache sets the warm cache loaded at startup. The first cycle uses its target list
+// contents instead of downloading the list, if the cache is for the configured target list
+// and is no older than maxAge.
+func WithWarmCache(cache *state.WarmCache, maxAge time.Duration) Option {
+	return func(s *Syncer) {
+		s.warmCache = cache
+		s.warmCacheMaxAge = maxAge
+	}
+}
+
+// WarmCache returns the target list contents and Kandji inventory hash as of the last
+// cycle, to be saved on shutdown. It returns nil if no cycle completed, or if the last
+// cycle left the target list in a state that is not known exactly. It must not be called
+// while a cycle is running.
+func (s *Syncer) WarmCache() *state.WarmCache {
+	return s.knownTarget
+}
+
+// warmTargetItems returns the target list contents from the warm cache, once, if the cache
+// can be used. kandjiHash is the hash of the inventory just fetched, to log whether the
+// inventory changed while the service was down.
+func (s *Syncer) warmTargetItems(kandjiHash string) ([]cloudflare.GatewayListItem, bool) {
+	cache := s.warmCache
+	s.warmCache = nil
+	if cache == nil {
+		return nil, false
+	}
+	if cache.TargetListID != s.config.Cloudflare.ListID {
+		s.log.Info("Ignoring warm cache saved for another target list", "list_id", cache.TargetListID)
+		return nil, false
+	}
+	if age := cache.Age(); age > s.warmCacheMaxAge {
+		s.log.Info("Ignoring stale warm cache", "age", age.Round(time.Second).String(), "max_age", s.warmCacheMaxAge.String())
+		return nil, false
+	}
+
+	items := make([]cloudflare.GatewayListItem, 0, len(cache.TargetItems))
+	for _, item := range cache.TargetItems {
+		items = append(items, cloudflare.GatewayListItem{Value: item.Value, Comment: item.Comment})
+	}
+	s.log.Info("Using warm cache for the target list",
+		"saved_at", cache.SavedAt.Format(time.RFC3339),
+		"entries", len(items),
+		"kandji_inventory_unchanged", cache.KandjiHash == kandjiHash)
+	return items, true
+}
+
+// rememberTarget records the target list contents after a cycle: the entries read at the
+// start of the cycle, less the ones removed, plus the ones added. known is false if a
+// removal or an append failed, in which case the contents are not known exactly and
+// nothing is kept.
+func (s *Syncer) rememberTarget(known bool, kandjiHash string, items []cloudflare.GatewayListItem, removed []string, added []deviceWithComment) {
+	if !known {
+		s.knownTarget = nil
+		return
+	}
+	removedSet := createSet(removed)
+	cache := &state.WarmCache{TargetListID: s.config.Cloudflare.ListID, KandjiHash: kandjiHash}
+	for _, item := range items {
+		if _, ok := removedSet[item.Value]; ok {
+			continue
+		}
+		cache.TargetItems = append(cache.TargetItems, state.ListItem{Value: item.Value, Comment: item.Comment})
+	}
+	seen := make(map[string]struct{}, len(added))
+	for _, device := range added {
+		if _, ok := seen[device.SerialNumber]; ok {
+			continue
+		}
+		seen[device.SerialNumber] = struct{}{}
+		cache.TargetItems = append(cache.TargetItems, state.ListItem{Value: device.SerialNumber, Comment: s.entryComment(device.Comment)})
+	}
+	s.knownTarget = cache
+}
+
+// inventoryHash returns a hash of the Kandji inventory that does not depend on the order
+// devices are returned in. Last check-in times are left out, they change every cycle.
+func inventoryHash(devices []kandji.Device) string {
+	type hashedDevice struct {
+		kandji.Device
+		UserEmail string `json:"user_email"`
+		LastSeen  string `json:"last_seen,omitempty"`
+	}
+	hashed := make([]hashedDevice, 0, len(devices))
+	for _, device := range devices {
+		hashed = append(hashed, hashedDevice{Device: device, UserEmail: device.UserEmail})
+	}
+	sort.Slice(hashed, func(i, j int) bool {
+		if hashed[i].SerialNumber != hashed[j].SerialNumber {
+			return hashed[i].SerialNumber < hashed[j].SerialNumber
+		}
+		return hashed[i].DeviceID < hashed[j].DeviceID
+	})
+	data, _ := json.Marshal(hashed)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
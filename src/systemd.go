@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/internal/sdnotify"
+)
+
+// runWatchdog sends systemd watchdog heartbeats every half of timeout until ctx is done, as
+// long as every sync loop makes progress: a loop that has gone its maximum age without a
+// successful cycle, e.g. because a cycle hangs, withholds the heartbeats so systemd restarts
+// the service.
+func runWatchdog(ctx context.Context, notifier *sdnotify.Notifier, timeout time.Duration, cfg *config.Config, jobs []*syncJob, log *slog.Logger) {
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+
+	withheld := false
+	for {
+		stalled := stalledJobs(cfg, jobs, time.Now())
+		switch {
+		case len(stalled) == 0:
+			if err := notifier.Notify(sdnotify.Watchdog); err != nil {
+				log.Warn("Failed to send systemd watchdog heartbeat", "error", err)
+			}
+			if withheld {
+				log.Info("Sync cycles succeed again, resuming systemd watchdog heartbeats")
+				withheld = false
+			}
+		case !withheld:
+			log.Error("No successful sync cycle within the watchdog's maximum age, withholding systemd watchdog heartbeats", "jobs", stalled)
+			withheld = true
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// stalledJobs returns the names of the sync loops, "default" for the top-level one, that
+// made no progress within systemd.watchdog_max_age, or three of their sync intervals.
+func stalledJobs(cfg *config.Config, jobs []*syncJob, now time.Time) []string {
+	var stalled []string
+	for _, job := range jobs {
+		maxAge := cfg.Systemd.WatchdogMaxAge
+		if maxAge == 0 {
+			maxAge = 3 * job.cfg.SyncInterval
+		}
+		progress := job.syncer.LastProgress()
+		// A loop that has not started yet has nothing to show
+		if progress.IsZero() || now.Sub(progress) <= maxAge {
+			continue
+		}
+		name := job.name
+		if name == "" {
+			name = "default"
+		}
+		stalled = append(stalled, name)
+	}
+	return stalled
+}
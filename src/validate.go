@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"kandji-cloudflare-device-sync/config"
+	"kandji-cloudflare-device-sync/state"
+)
+
+// runValidate checks the configuration without contacting any API and prints every
+// validation error and lint warning.
+func runValidate(args []string) int {
+	cfg, err := config.ParseConfigArgs(newFlagSet("validate"), args)
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		return exitConfig
+	}
+
+	warnings := cfg.Lint(expectedCycleDuration(cfg))
+	for _, warning := range warnings {
+		fmt.Printf("warning: %s\n", warning)
+	}
+	fmt.Printf("configuration is valid (%d warnings)\n", len(warnings))
+	return exitOK
+}
+
+// expectedCycleDuration returns the average cycle duration recorded in the state store,
+// or zero if there is no state store or no history yet.
+func expectedCycleDuration(cfg *config.Config) time.Duration {
+	if cfg.State.Path == "" {
+		return 0
+	}
+	store, err := state.Open(cfg.State.Path, cfg.State.Retention)
+	if err != nil {
+		return 0
+	}
+	return state.Summarize(store.Cycles(), time.Now().UTC(), 0).AverageDuration
+}